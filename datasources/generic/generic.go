@@ -122,12 +122,18 @@ func (c *Client) walk(ctx context.Context, root, pathInRoot string, itemChan cha
 
 		fitem := fileItem{fsys: fsys, path: fpath, dirEntry: d}
 
+		var localPath string
+		if !isArchiveFS {
+			localPath = filepath.Join(root, pathInRoot, filepath.FromSlash(fpath))
+		}
+
 		item := &timeline.Item{
 			Timestamp:            fitem.timestamp(),
 			Location:             fitem.location(),
 			IntermediateLocation: fpath,
 			Content: timeline.ItemData{
-				Filename: filepath.Base(fpath),
+				Filename:  filepath.Base(fpath),
+				LocalPath: localPath,
 				Data: func(_ context.Context) (io.ReadCloser, error) {
 					return fsys.Open(fpath)
 				},
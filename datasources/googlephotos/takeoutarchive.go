@@ -175,13 +175,13 @@ func (fimp *FileImporter) processAlbumItem(ctx context.Context, albumMeta albumA
 		ig.Item.Retrieval.PreferFields = []string{"data", "original_location", "intermediate_location", "filename", "timestamp", "timespan", "timeframe", "time_offset", "time_uncertainty", "location"}
 	}
 
-	// if item has an "-edited" variant, relate it
+	// if item has an "-edited" variant, keep it as another representation of the item
 	ext := path.Ext(mediaFilePath)
 	editedPath := strings.TrimSuffix(mediaFilePath, ext) + "-edited" + ext
 	if timeline.FileExistsFS(fsys, editedPath) {
 		mediaFilePath = editedPath
 		edited := fimp.makeItemGraph(mediaFilePath, itemMeta, albumMeta, opt)
-		ig.ToItem(timeline.RelEdit, edited.Item)
+		ig.AddRepresentation(timeline.RepresentationEdited, edited.Item)
 	}
 
 	itemChan <- ig
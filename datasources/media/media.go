@@ -201,7 +201,7 @@ func (imp *FileImporter) FileImport(ctx context.Context, filenames []string, ite
 	owner := timeline.Entity{ID: dsOpt.OwnerEntityID}
 
 	for _, filename := range filenames {
-		fsys, err := archiver.FileSystem(ctx, filename)
+		fsys, err := timeline.FileSystem(ctx, filename, opt.Passphrase)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,261 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package timelinize implements a data source that imports items exported
+// from a timeline (see Timeline.ExportNDJSON), so that they can be moved
+// or copied between timelines. Items keep their global IDs, if they have
+// them, so importing the same export again does not duplicate items.
+package timelinize
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/timelinize/timelinize/timeline"
+	"go.uber.org/zap"
+)
+
+func init() {
+	err := timeline.RegisterDataSource(timeline.DataSource{
+		Name:            "timelinize",
+		Title:           "Timelinize",
+		Icon:            "timelinize.svg",
+		Description:     "Items exported from another timeline, as newline-delimited JSON",
+		NewFileImporter: func() timeline.FileImporter { return new(FileImporter) },
+	})
+	if err != nil {
+		timeline.Log.Fatal("registering data source", zap.Error(err))
+	}
+}
+
+// FileImporter imports exported items. Exports contain the paths of data files
+// relative to the timeline they were exported from, so data files are read
+// relative to the folder containing the export file (for example, put the export
+// file in the root of the exported timeline, or next to a copy of its data folder).
+type FileImporter struct{}
+
+// Recognize returns whether the files are exports from a timeline.
+func (FileImporter) Recognize(_ context.Context, filenames []string) (timeline.Recognition, error) {
+	var totalCount, matchCount int
+
+	for _, filename := range filenames {
+		totalCount++
+
+		ext := strings.ToLower(filepath.Ext(filename))
+		if ext != ".ndjson" && ext != ".jsonl" {
+			continue
+		}
+
+		first, err := readFirstLine(filename)
+		if err != nil {
+			return timeline.Recognition{}, err
+		}
+		var probe struct {
+			RepoID string `json:"repo_id"`
+			ID     int64  `json:"id"`
+		}
+		if json.Unmarshal(first, &probe) == nil && probe.RepoID != "" && probe.ID > 0 {
+			matchCount++
+		}
+	}
+
+	var confidence float64
+	if totalCount > 0 {
+		confidence = float64(matchCount) / float64(totalCount)
+	}
+
+	return timeline.Recognition{Confidence: confidence}, nil
+}
+
+func readFirstLine(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return line, nil
+}
+
+// FileImport imports the items in the export files.
+func (fi *FileImporter) FileImport(ctx context.Context, filenames []string, itemChan chan<- *timeline.Graph, _ timeline.ListingOptions) error {
+	for _, filename := range filenames {
+		if err := fi.importFile(ctx, filename, itemChan); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	return nil
+}
+
+func (fi *FileImporter) importFile(ctx context.Context, filename string, itemChan chan<- *timeline.Graph) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	baseDir := filepath.Dir(filename)
+	dec := json.NewDecoder(f)
+
+	for line := int64(1); ; line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var result timeline.SearchResult
+		if err := dec.Decode(&result); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decoding item on line %d: %w", line, err)
+		}
+
+		item, err := itemFromResult(result, baseDir)
+		if err != nil {
+			return fmt.Errorf("item %d on line %d: %w", result.ID, line, err)
+		}
+
+		itemChan <- &timeline.Graph{Item: item}
+	}
+}
+
+// itemFromResult converts an exported item back into an item to import.
+func itemFromResult(result timeline.SearchResult, baseDir string) (*timeline.Item, error) {
+	item := &timeline.Item{
+		Location: result.Location,
+	}
+
+	if result.GlobalID != nil {
+		item.GlobalID = *result.GlobalID
+	}
+
+	// original IDs are only unique within their data source, and an export
+	// can have items from many data sources, so qualify them
+	if result.OriginalID != nil && result.DataSourceName != nil {
+		item.ID = *result.DataSourceName + "/" + *result.OriginalID
+	} else {
+		item.ID = item.GlobalID
+	}
+
+	if result.Classification != nil {
+		item.Classification = timeline.Classification{Name: *result.Classification}
+	}
+
+	if result.Timestamp != nil {
+		item.Timestamp = *result.Timestamp
+		if result.TimeOffset != nil {
+			item.Timestamp = item.Timestamp.In(time.FixedZone("", *result.TimeOffset))
+		}
+	}
+	if result.Timespan != nil {
+		item.Timespan = *result.Timespan
+	}
+	if result.Timeframe != nil {
+		item.Timeframe = *result.Timeframe
+	}
+	if result.TimeUncertainty != nil {
+		item.TimeUncertainty = time.Duration(*result.TimeUncertainty) * time.Millisecond
+	}
+	if result.Sequence != nil {
+		item.Sequence = *result.Sequence
+	}
+	if result.OriginalLocation != nil {
+		item.OriginalLocation = *result.OriginalLocation
+	}
+	if result.IntermediateLocation != nil {
+		item.IntermediateLocation = *result.IntermediateLocation
+	}
+	if result.Visibility != nil {
+		item.Visibility = &timeline.Visibility{Level: *result.Visibility}
+	}
+
+	if result.Entity != nil && result.Entity.Name != nil {
+		item.Owner.Name = *result.Entity.Name
+		if attr := result.Entity.Attribute; attr.Name != nil && attr.Value != nil {
+			item.Owner.Attributes = []timeline.Attribute{
+				{
+					Name:        *attr.Name,
+					Value:       *attr.Value,
+					Identifying: true,
+				},
+			}
+		}
+	}
+
+	if len(result.Metadata) > 0 && !bytes.Equal(result.Metadata, []byte("null")) {
+		if err := json.Unmarshal(result.Metadata, &item.Metadata); err != nil {
+			return nil, fmt.Errorf("decoding metadata: %w", err)
+		}
+	}
+
+	if result.Filename != nil {
+		item.Content.Filename = *result.Filename
+	}
+	if result.DataType != nil {
+		item.Content.MediaType = *result.DataType
+	}
+	if result.DataText != nil {
+		item.Content.Data = timeline.StringData(*result.DataText)
+	} else if result.DataFile != nil {
+		dataFile := filepath.Join(baseDir, filepath.FromSlash(*result.DataFile))
+		compression := result.DataFileCompression
+		item.Content.Data = func(_ context.Context) (io.ReadCloser, error) {
+			f, err := os.Open(dataFile)
+			if err != nil {
+				return nil, err
+			}
+			if compression == "" {
+				return f, nil
+			}
+			dr, err := compression.NewReader(f)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			return decompressedFile{dr, f}, nil
+		}
+	}
+
+	return item, nil
+}
+
+// decompressedFile reads from the decompressor and closes both it and the underlying file.
+type decompressedFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (d decompressedFile) Close() error {
+	err := d.ReadCloser.Close()
+	if err2 := d.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
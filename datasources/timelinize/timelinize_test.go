@@ -0,0 +1,184 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timelinize
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/timelinize/timelinize/timeline"
+)
+
+// sourceImporter gives the items to import into the timeline being exported.
+type sourceImporter struct{ items []*timeline.Item }
+
+func (sourceImporter) Recognize(context.Context, []string) (timeline.Recognition, error) {
+	return timeline.Recognition{}, nil
+}
+
+func (si sourceImporter) FileImport(_ context.Context, _ []string, itemChan chan<- *timeline.Graph, _ timeline.ListingOptions) error {
+	for _, it := range si.items {
+		itemChan <- &timeline.Graph{Item: it}
+	}
+	return nil
+}
+
+func newTestTimeline(t *testing.T) *timeline.Timeline {
+	t.Helper()
+	tl, err := timeline.Create(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("creating timeline: %v", err)
+	}
+	t.Cleanup(func() { tl.Close() })
+	return tl
+}
+
+func TestExportedItemsKeepGlobalID(t *testing.T) {
+	ctx := context.Background()
+	ts := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	sourceName := fmt.Sprintf("export_source_%d", time.Now().UnixNano())
+	err := timeline.RegisterDataSource(timeline.DataSource{
+		Name:  sourceName,
+		Title: "Export source",
+		NewFileImporter: func() timeline.FileImporter {
+			return sourceImporter{items: []*timeline.Item{
+				{
+					ID:             "msg",
+					Classification: timeline.ClassMessage,
+					Timestamp:      ts,
+					Content:        timeline.ItemData{Data: timeline.StringData("hello from the other timeline")},
+				},
+				{
+					ID:             "photo",
+					Classification: timeline.ClassMedia,
+					Timestamp:      ts.Add(time.Hour),
+					Content: timeline.ItemData{
+						Filename:  "photo.bin",
+						MediaType: "application/octet-stream",
+						Data:      timeline.ByteData([]byte("photo bytes")),
+					},
+				},
+			}}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the source timeline assigns global IDs to its items
+	source := newTestTimeline(t)
+	if err := source.SetItemIDStrategy(ctx, timeline.ItemIDGlobal); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Import(ctx, timeline.ImportParameters{DataSourceName: sourceName, Filenames: []string{"test"}}); err != nil {
+		t.Fatalf("importing into source timeline: %v", err)
+	}
+
+	globalIDs := make(map[string]string) // original ID -> global ID
+	for _, id := range []string{"msg", "photo"} {
+		item, err := source.ItemByOriginalID(ctx, sourceName, 0, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.GlobalID == nil || *item.GlobalID == "" {
+			t.Fatalf("expected item %s to be assigned a global ID", id)
+		}
+		globalIDs[id] = *item.GlobalID
+	}
+
+	// export it into the root of the source timeline, so data files can be found
+	exportFile := filepath.Join(source.Dir(), "export.ndjson")
+	f, err := os.Create(exportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = source.ExportNDJSON(ctx, timeline.ItemSearchParams{}, f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("exporting: %v", err)
+	}
+
+	rec, err := FileImporter{}.Recognize(ctx, []string{exportFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Confidence != 1 {
+		t.Errorf("expected export file to be recognized, got confidence %v", rec.Confidence)
+	}
+
+	// import the export into a timeline that doesn't assign global IDs itself, twice
+	dest := newTestTimeline(t)
+	for i := 0; i < 2; i++ {
+		if err := dest.Import(ctx, timeline.ImportParameters{DataSourceName: "timelinize", Filenames: []string{exportFile}}); err != nil {
+			t.Fatalf("importing export (attempt %d): %v", i+1, err)
+		}
+	}
+
+	for id, globalID := range globalIDs {
+		item, err := dest.ItemByGlobalID(ctx, globalID)
+		if err != nil {
+			t.Fatalf("expected item %s to keep its global ID %s: %v", id, globalID, err)
+		}
+		if item.OriginalID == nil || *item.OriginalID != sourceName+"/"+id {
+			t.Errorf("unexpected original ID of imported item: %v", item.OriginalID)
+		}
+	}
+
+	msg, err := dest.ItemByGlobalID(ctx, globalIDs["msg"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.DataText == nil || *msg.DataText != "hello from the other timeline" {
+		t.Errorf("unexpected text of imported item: %v", msg.DataText)
+	}
+	photo, err := dest.ItemByGlobalID(ctx, globalIDs["photo"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.DataFile == nil {
+		t.Fatal("expected imported item to have a data file")
+	}
+	rc, err := dest.OpenDataFile(ctx, *photo.DataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "photo bytes" {
+		t.Errorf("unexpected data file contents of imported item: %q", contents)
+	}
+
+	// importing again did not duplicate anything
+	results, err := dest.Search(ctx, timeline.ItemSearchParams{WithTotal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != len(globalIDs) {
+		t.Errorf("expected %d items after importing twice, got %d", len(globalIDs), results.Total)
+	}
+}
@@ -41,6 +41,7 @@ import (
 	_ "github.com/timelinize/timelinize/datasources/smsbackuprestore"
 	_ "github.com/timelinize/timelinize/datasources/strava"
 	_ "github.com/timelinize/timelinize/datasources/telegram"
+	_ "github.com/timelinize/timelinize/datasources/timelinize"
 	_ "github.com/timelinize/timelinize/datasources/twitter"
 	_ "github.com/timelinize/timelinize/datasources/vcard"
 )
@@ -42,7 +42,7 @@ type Account struct {
 }
 
 func (acc *Account) fill(t *Timeline) error {
-	ds, ok := dataSources[acc.DataSource.Name]
+	ds, ok := lookupDataSource(acc.DataSource.Name)
 	if !ok {
 		return fmt.Errorf("inconsistent DB: unrecognized data source ID: %s", acc.DataSource.Name)
 	}
@@ -85,6 +85,10 @@ func (acc Account) NewHTTPClient(ctx context.Context, oauth2 OAuth2, rl RateLimi
 // given data source and owner. The account must not yet exist. This method
 // does not attempt to authenticate with any API / hosted service.
 func (t *Timeline) AddAccount(ctx context.Context, dataSourceID string, dsOptJSON json.RawMessage) (Account, error) {
+	if err := t.checkWritable("add account"); err != nil {
+		return Account{}, err
+	}
+
 	// ds, ok := dataSources[dataSourceID]
 	// if !ok {
 	// 	return Account{}, fmt.Errorf("data source not registered: %s", dataSourceID)
@@ -110,18 +114,31 @@ func (t *Timeline) AddAccount(ctx context.Context, dataSourceID string, dsOptJSO
 	// 	return Account{}, err
 	// }
 
+	return t.CreateAccount(ctx, dataSourceID)
+}
+
+// CreateAccount stores a new account for the data source with the given name.
+func (t *Timeline) CreateAccount(ctx context.Context, dataSourceName string) (Account, error) {
+	if err := t.checkWritable("create account"); err != nil {
+		return Account{}, err
+	}
+
+	dsRowID, err := t.dataSourceRowID(ctx, dataSourceName)
+	if err != nil {
+		return Account{}, err
+	}
+
 	// store the account
 	var accountID int64
 	t.dbMu.Lock()
-	err := t.db.QueryRow(`INSERT INTO accounts (data_source_id) VALUES (?) RETURNING id`,
-		dataSourceID).Scan(&accountID)
+	err = t.db.QueryRowContext(ctx, `INSERT INTO accounts (data_source_id) VALUES (?) RETURNING id`,
+		dsRowID).Scan(&accountID)
 	t.dbMu.Unlock()
 	if err != nil {
 		return Account{}, fmt.Errorf("inserting into DB: %v", err)
 	}
 
 	// load the new account so caller can get its info (like ID)
-	// TODO: should we just use context.Background() here? or pass in an actual context
 	acct, err := t.LoadAccount(ctx, accountID)
 	if err != nil {
 		return Account{}, fmt.Errorf("loading new account: %v", err)
@@ -130,6 +147,160 @@ func (t *Timeline) AddAccount(ctx context.Context, dataSourceID string, dsOptJSO
 	return acct, nil
 }
 
+// ListAccounts returns the accounts of the data source with the given name.
+// If dataSourceName is empty, accounts of all data sources are returned.
+func (t *Timeline) ListAccounts(ctx context.Context, dataSourceName string) ([]Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var dsNames []string
+	if dataSourceName != "" {
+		if _, ok := lookupDataSource(dataSourceName); !ok {
+			return nil, fmt.Errorf("unknown data source: %s", dataSourceName)
+		}
+		dsNames = []string{dataSourceName}
+	}
+	return t.LoadAccounts(nil, dsNames)
+}
+
+// DeleteAccount deletes the account with the given ID. If any items were imported
+// using the account, the account will not be deleted unless cascade is true, in
+// which case the account's imports and their items are deleted as well. An account
+// can't be deleted while any of its imports are running.
+func (t *Timeline) DeleteAccount(ctx context.Context, accountID int64, cascade bool) error {
+	if err := t.checkWritable("delete account"); err != nil {
+		return err
+	}
+
+	t.dbMu.RLock()
+	rows, err := t.db.QueryContext(ctx, `SELECT id FROM imports WHERE account_id=?`, accountID)
+	if err != nil {
+		t.dbMu.RUnlock()
+		return fmt.Errorf("querying imports of account %d: %v", accountID, err)
+	}
+	var importIDs []int64
+	for rows.Next() {
+		var importID int64
+		if err := rows.Scan(&importID); err != nil {
+			rows.Close()
+			t.dbMu.RUnlock()
+			return fmt.Errorf("scanning import ID: %v", err)
+		}
+		importIDs = append(importIDs, importID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		t.dbMu.RUnlock()
+		return fmt.Errorf("iterating imports of account %d: %v", accountID, err)
+	}
+	var itemCount int
+	err = t.db.QueryRowContext(ctx,
+		`SELECT count() FROM items, imports WHERE imports.account_id=? AND items.import_id = imports.id`,
+		accountID).Scan(&itemCount)
+	t.dbMu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("counting items of account %d: %v", accountID, err)
+	}
+
+	// a running import would keep adding items to the account
+	t.importJobsMu.Lock()
+	for _, importID := range importIDs {
+		if _, running := t.runningImports[importID]; running {
+			t.importJobsMu.Unlock()
+			return fmt.Errorf("import %d of account %d is running", importID, accountID)
+		}
+	}
+	t.importJobsMu.Unlock()
+
+	if itemCount > 0 && !cascade {
+		return fmt.Errorf("account %d has %d items from %d imports; refusing to delete without cascade",
+			accountID, itemCount, len(importIDs))
+	}
+
+	// the account's imports (and their items, if any) must be deleted first
+	for _, importID := range importIDs {
+		if err := t.DeleteImport(ctx, importID); err != nil {
+			return fmt.Errorf("deleting import %d of account %d: %v", importID, accountID, err)
+		}
+	}
+
+	t.dbMu.Lock()
+	_, err = t.db.ExecContext(ctx, `DELETE FROM accounts WHERE id=?`, accountID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+	t.dbMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("deleting account %d: %v", accountID, err)
+	}
+
+	return nil
+}
+
+// ReassignImportAccount attributes the import to a different account of the same
+// data source, for example if it was run with the wrong account, so that it does
+// not need to be imported again. The items that were imported with the old account
+// (see DedupScopeAccount) are moved to the new one as well, in the same transaction;
+// if that would make any of them collide with an item the new account already has,
+// nothing is changed and an error is returned. Running imports cannot be reassigned.
+// Afterward, searches by account and get-latest imports of the new account include
+// the import's items.
+func (t *Timeline) ReassignImportAccount(ctx context.Context, importID, newAccountID int64) error {
+	if err := t.checkWritable("reassign import account"); err != nil {
+		return err
+	}
+
+	t.importJobsMu.Lock()
+	_, running := t.runningImports[importID]
+	t.importJobsMu.Unlock()
+	if running {
+		return fmt.Errorf("import %d is running", importID)
+	}
+
+	t.dbMu.Lock()
+	defer t.dbMu.Unlock()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var importDataSourceID, oldAccountID *int64
+	err = tx.QueryRowContext(ctx, `SELECT data_source_id, account_id FROM imports WHERE id=? LIMIT 1`,
+		importID).Scan(&importDataSourceID, &oldAccountID)
+	if err != nil {
+		return fmt.Errorf("loading import %d: %w", importID, err)
+	}
+	var accountDataSourceID int64
+	err = tx.QueryRowContext(ctx, `SELECT data_source_id FROM accounts WHERE id=? LIMIT 1`,
+		newAccountID).Scan(&accountDataSourceID)
+	if err != nil {
+		return fmt.Errorf("loading account %d: %w", newAccountID, err)
+	}
+	if importDataSourceID == nil || *importDataSourceID != accountDataSourceID {
+		return fmt.Errorf("account %d is not of the same data source as import %d", newAccountID, importID)
+	}
+	if oldAccountID != nil && *oldAccountID == newAccountID {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE imports SET account_id=? WHERE id=?`, newAccountID, importID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+	if err != nil {
+		return fmt.Errorf("updating account of import %d: %v", importID, err)
+	}
+	if oldAccountID != nil {
+		_, err = tx.ExecContext(ctx, `UPDATE items SET account_id=? WHERE import_id=? AND account_id=?`,
+			newAccountID, importID, *oldAccountID)
+		if err != nil {
+			return fmt.Errorf("updating account of items from import %d (items may already exist for account %d): %v",
+				importID, newAccountID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+	return nil
+}
+
 func (acc *Account) AuthorizeOAuth2(ctx context.Context, oauth2 OAuth2) error {
 	creds, err := authorizeWithOAuth2(ctx, oauth2)
 	if err != nil {
@@ -0,0 +1,245 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCreateAndListAccounts(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		acc, err := tl.CreateAccount(ctx, testDataSourceName)
+		if err != nil {
+			t.Fatalf("creating account: %v", err)
+		}
+		if acc.ID == 0 || acc.DataSource.Name != testDataSourceName {
+			t.Errorf("unexpected account: %+v", acc)
+		}
+	}
+	if _, err := tl.CreateAccount(ctx, "nonexistent"); err == nil {
+		t.Error("expected error creating account for unknown data source")
+	}
+
+	accounts, err := tl.ListAccounts(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatalf("listing accounts: %v", err)
+	}
+	if len(accounts) != 2 {
+		t.Errorf("expected 2 accounts, got %d", len(accounts))
+	}
+
+	accounts, err = tl.ListAccounts(ctx, "")
+	if err != nil {
+		t.Fatalf("listing all accounts: %v", err)
+	}
+	if len(accounts) != 2 {
+		t.Errorf("expected 2 accounts in total, got %d", len(accounts))
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	// an account without any items can be deleted right away
+	unused, err := tl.CreateAccount(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.DeleteAccount(ctx, unused.ID, false); err != nil {
+		t.Fatalf("deleting unused account: %v", err)
+	}
+
+	// import some items using an account
+	acc, err := tl.CreateAccount(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("a", time.Now())}
+		itemChan <- &Graph{Item: testMessage("b", time.Now())}
+		return nil
+	}
+	err = tl.Import(ctx, ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		AccountID:      acc.ID,
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	if err := tl.DeleteAccount(ctx, acc.ID, false); err == nil {
+		t.Fatal("expected error deleting account with items without cascade")
+	}
+
+	if err := tl.DeleteAccount(ctx, acc.ID, true); err != nil {
+		t.Fatalf("deleting account with cascade: %v", err)
+	}
+
+	var items, imports, accounts int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&items); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.db.QueryRow(`SELECT count() FROM imports`).Scan(&imports); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.db.QueryRow(`SELECT count() FROM accounts`).Scan(&accounts); err != nil {
+		t.Fatal(err)
+	}
+	if items != 0 || imports != 0 || accounts != 0 {
+		t.Errorf("expected everything to be deleted, but have %d items, %d imports, %d accounts", items, imports, accounts)
+	}
+}
+
+func TestDeleteAccountWithRunningImport(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	acc, err := tl.CreateAccount(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("a", time.Now())}
+		close(started)
+		<-release
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- tl.Import(ctx, ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			AccountID:      acc.ID,
+		})
+	}()
+	<-started
+
+	if err := tl.DeleteAccount(ctx, acc.ID, true); err == nil {
+		t.Error("expected error deleting account while one of its imports is running")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if err := tl.DeleteAccount(ctx, acc.ID, true); err != nil {
+		t.Errorf("deleting account after its import finished: %v", err)
+	}
+}
+
+func TestReassignImportAccount(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	wrong, err := tl.CreateAccount(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := tl.CreateAccount(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newest := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	importWithAccount := func(accountID int64, procOpt ProcessingOptions, items ...*Item) Timeframe {
+		t.Helper()
+		var tf Timeframe
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+			tf = opt.Timeframe
+			for _, it := range items {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			AccountID:         accountID,
+			ProcessingOptions: procOpt,
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		return tf
+	}
+	importWithAccount(wrong.ID, ProcessingOptions{DedupScope: DedupScopeAccount},
+		testMessage("a", newest.Add(-time.Hour)), testMessage("b", newest))
+
+	var importID int64
+	if err := tl.db.QueryRow(`SELECT id FROM imports LIMIT 1`).Scan(&importID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.ReassignImportAccount(ctx, importID, right.ID); err != nil {
+		t.Fatalf("reassigning import: %v", err)
+	}
+
+	countForAccount := func(accountID int64) int {
+		t.Helper()
+		results, err := tl.Search(ctx, ItemSearchParams{AccountID: []int64{accountID}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(results.Items)
+	}
+	if n := countForAccount(right.ID); n != 2 {
+		t.Errorf("expected 2 items for the new account, got %d", n)
+	}
+	if n := countForAccount(wrong.ID); n != 0 {
+		t.Errorf("expected no items for the old account, got %d", n)
+	}
+	var stale int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE account_id IS NOT ?`, right.ID).Scan(&stale); err != nil {
+		t.Fatal(err)
+	}
+	if stale != 0 {
+		t.Errorf("expected all items to be scoped to the new account, but %d are not", stale)
+	}
+
+	// get latest for the new account picks up where the reassigned import left off
+	tf := importWithAccount(right.ID, ProcessingOptions{GetLatest: true})
+	if tf.Since == nil || !tf.Since.Equal(newest) {
+		t.Errorf("expected get latest for the new account to start at %s, got %v", newest, tf.Since)
+	}
+
+	// the account must be of the same data source
+	res, err := tl.db.Exec(`INSERT INTO data_sources (name) VALUES ('other_source')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDS, _ := res.LastInsertId()
+	var otherAccountID int64
+	if err := tl.db.QueryRow(`INSERT INTO accounts (data_source_id) VALUES (?) RETURNING id`, otherDS).Scan(&otherAccountID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.ReassignImportAccount(ctx, importID, otherAccountID); err == nil {
+		t.Error("expected error reassigning import to an account of another data source")
+	}
+	if err := tl.ReassignImportAccount(ctx, importID+100, right.ID); err == nil {
+		t.Error("expected error reassigning nonexistent import")
+	}
+}
@@ -0,0 +1,75 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+)
+
+// AccountBusyError is returned when an import is rejected because the
+// account's data source doesn't allow any more concurrent imports.
+type AccountBusyError struct {
+	AccountID      int64  `json:"account_id"`
+	DataSourceName string `json:"data_source_name"`
+	Limit          int    `json:"limit"`
+}
+
+func (e AccountBusyError) Error() string {
+	return fmt.Sprintf("account %d is busy: %s allows at most %d import(s) at a time per account",
+		e.AccountID, e.DataSourceName, e.Limit)
+}
+
+// acquireAccount reserves one of the import slots of the account for an import,
+// waiting for a slot to become available, unless the import should be rejected
+// if the account is busy. The returned function releases the slot.
+func (tl *Timeline) acquireAccount(ctx context.Context, ds DataSource, params ImportParameters) (func(), error) {
+	tl.importJobsMu.Lock()
+	if tl.accountImports == nil {
+		tl.accountImports = make(map[int64]chan struct{})
+	}
+	sem, ok := tl.accountImports[params.AccountID]
+	if !ok {
+		sem = make(chan struct{}, ds.AccountImportLimit)
+		tl.accountImports[params.AccountID] = sem
+	}
+	tl.importJobsMu.Unlock()
+
+	release := func() { <-sem }
+
+	if params.RejectIfAccountBusy {
+		select {
+		case sem <- struct{}{}:
+			return release, nil
+		default:
+			return nil, AccountBusyError{
+				AccountID:      params.AccountID,
+				DataSourceName: ds.Name,
+				Limit:          ds.AccountImportLimit,
+			}
+		}
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
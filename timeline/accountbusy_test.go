@@ -0,0 +1,117 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// blockingAPIImporter signals when an import starts, then waits until it is released.
+type blockingAPIImporter struct {
+	started chan<- struct{}
+	release <-chan struct{}
+}
+
+func (blockingAPIImporter) Authenticate(_ context.Context, _ Account, _ any) error { return nil }
+
+func (b blockingAPIImporter) APIImport(ctx context.Context, _ Account, _ chan<- *Graph, _ ListingOptions) error {
+	b.started <- struct{}{}
+	select {
+	case <-b.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestAccountImportLimit(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	name := fmt.Sprintf("single_session_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:               name,
+		Title:              "Single session test",
+		AccountImportLimit: 1,
+		NewAPIImporter: func() APIImporter {
+			return blockingAPIImporter{started: started, release: release}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := tl.CreateAccount(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := ImportParameters{DataSourceName: name, AccountID: acc.ID}
+
+	firstDone := make(chan error, 1)
+	go func() { firstDone <- tl.Import(ctx, params) }()
+	<-started
+
+	// a second import is rejected if configured to do so...
+	rejectParams := params
+	rejectParams.RejectIfAccountBusy = true
+	err = tl.Import(ctx, rejectParams)
+	var busy AccountBusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("expected AccountBusyError, got %v", err)
+	}
+	if busy.AccountID != acc.ID || busy.Limit != 1 {
+		t.Errorf("unexpected error details: %+v", busy)
+	}
+
+	// ...otherwise it waits for the first one to finish
+	secondDone := make(chan error, 1)
+	go func() { secondDone <- tl.Import(ctx, params) }()
+	select {
+	case <-started:
+		t.Fatal("second import started while the first was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-firstDone; err != nil {
+		t.Errorf("first import: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second import did not start after the first finished")
+	}
+	if err := <-secondDone; err != nil {
+		t.Errorf("second import: %v", err)
+	}
+
+	// the rejected import did not leave an import row behind
+	var imports int
+	if err := tl.db.QueryRow(`SELECT count() FROM imports WHERE account_id=?`, acc.ID).Scan(&imports); err != nil {
+		t.Fatal(err)
+	}
+	if imports != 2 {
+		t.Errorf("expected 2 imports, got %d", imports)
+	}
+}
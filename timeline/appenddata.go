@@ -0,0 +1,259 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// appendable returns true if the incoming item's data can be appended to the
+// data of the existing item row (see ProcessingOptions.AppendMode).
+func (p *processor) appendable(it *Item, ir ItemRow) bool {
+	if it.dataText == nil && it.dataFileIn == nil {
+		return false
+	}
+	if ir.Modified != nil && !p.params.ProcessingOptions.OverwriteModifications {
+		return false
+	}
+	// a data file without a hash has not finished being written
+	return ir.DataText != nil || (ir.DataFile != nil && ir.DataHash != nil)
+}
+
+// appendItemData appends the new content at the end of the incoming item's data to
+// the data of the existing item row. The incoming data must begin with the existing
+// data; if it is no longer than the existing data, there is nothing to append.
+func (p *processor) appendItemData(ctx context.Context, tx *sql.Tx, it *Item, ir ItemRow) (int64, error) {
+	var storedLength *int64
+	err := tx.QueryRowContext(ctx, `SELECT data_length FROM items WHERE id=? LIMIT 1`, ir.ID).Scan(&storedLength)
+	if err != nil {
+		return 0, fmt.Errorf("loading length of existing data: %v (row_id=%d)", err, ir.ID)
+	}
+
+	var appended int64
+	if ir.DataText != nil {
+		appended, err = p.appendItemText(ctx, tx, it, ir, storedLength)
+	} else {
+		appended, err = p.appendItemDataFile(ctx, tx, it, ir, storedLength)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("appending to item data: %w (row_id=%d item_id=%s)", err, ir.ID, it.ID)
+	}
+
+	if appended == 0 {
+		atomic.AddInt64(p.skippedItemCount, 1)
+		p.log.Debug("skipping processing of existing item because it has no new data to append",
+			zap.Int64("row_id", ir.ID),
+			zap.String("item_original_id", it.ID))
+		return ir.ID, nil
+	}
+
+	// like any other update, note the import that modified the item (if it's not the original one)
+	if ir.ImportID != nil && *ir.ImportID != p.impRow.id {
+		if _, err := tx.ExecContext(ctx, `UPDATE items SET modified_import_id=? WHERE id=?`, p.impRow.id, ir.ID); err != nil {
+			return 0, fmt.Errorf("updating modified import of item: %v (row_id=%d)", err, ir.ID)
+		}
+	}
+
+	atomic.AddInt64(p.updatedItemCount, 1)
+	p.updatedItems = append(p.updatedItems, ir.ID)
+	p.log.Debug("appended new data to existing item",
+		zap.Int64("row_id", ir.ID),
+		zap.String("item_original_id", it.ID),
+		zap.Int64("appended_bytes", appended))
+
+	return ir.ID, nil
+}
+
+// appendItemText appends to text stored in the database and returns the number of bytes appended.
+func (p *processor) appendItemText(ctx context.Context, tx *sql.Tx, it *Item, ir ItemRow, storedLength *int64) (int64, error) {
+	if it.dataText == nil {
+		return 0, errors.New("existing data is text but incoming data is not")
+	}
+	existing, incoming := *ir.DataText, *it.dataText
+	length := int64(len(existing))
+	if storedLength != nil {
+		length = *storedLength
+	}
+	if int64(len(incoming)) <= length {
+		return 0, nil
+	}
+	if !strings.HasPrefix(incoming, existing) {
+		return 0, errors.New("incoming data does not begin with the existing data")
+	}
+
+	var normalized *string
+	if tn := p.params.ProcessingOptions.TextNormalization; tn != nil {
+		n, err := tn.Normalize(incoming)
+		if err != nil {
+			return 0, fmt.Errorf("normalizing item text: %v", err)
+		}
+		normalized = &n
+	}
+
+	_, err := tx.ExecContext(ctx, `UPDATE items SET data_text=?, normalized_text=?, data_length=? WHERE id=?`,
+		incoming, normalized, len(incoming), ir.ID)
+	if err != nil {
+		return 0, fmt.Errorf("updating item text: %v", err)
+	}
+	return int64(len(incoming)) - length, nil
+}
+
+// appendItemDataFile appends to the item's data file and returns the number of bytes appended.
+// It reads as much of the incoming data as the existing file has, making sure it is the same
+// content by comparing hashes, then writes the rest to the end of the file. If that fails,
+// the file is truncated back to its original size.
+func (p *processor) appendItemDataFile(ctx context.Context, tx *sql.Tx, it *Item, ir ItemRow, storedLength *int64) (int64, error) {
+	// the file belongs to this item alone only if no other item or version refers to it
+	var shared bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM items WHERE data_file=? AND id!=?)
+		OR EXISTS(SELECT 1 FROM item_versions WHERE data_file=?)`, *ir.DataFile, ir.ID, *ir.DataFile).Scan(&shared)
+	if err != nil {
+		return 0, fmt.Errorf("checking whether data file is shared: %v", err)
+	}
+	if shared {
+		return 0, fmt.Errorf("data file %s is shared with other items", *ir.DataFile)
+	}
+	if isExternalDataFile(*ir.DataFile) {
+		return 0, fmt.Errorf("data file %s is referenced outside the repo and cannot be modified", *ir.DataFile)
+	}
+
+	compression, err := dataFileCompression(ctx, tx, *ir.DataFile)
+	if err != nil {
+		return 0, err
+	}
+
+	var length int64
+	if storedLength != nil {
+		length = *storedLength
+	} else if length, err = p.tl.dataFileLength(*ir.DataFile, compression); err != nil {
+		return 0, err
+	}
+
+	in := it.dataFileIn
+	if in == nil {
+		in = io.NopCloser(strings.NewReader(*it.dataText))
+	}
+
+	// the beginning of the incoming data must be the same as what we already have
+	h := newHash()
+	n, err := io.CopyN(h, in, length)
+	if errors.Is(err, io.EOF) {
+		return 0, nil // incoming data is no longer than existing data
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading incoming data: %v (read %d bytes)", err, n)
+	}
+	if !bytes.Equal(h.Sum(nil), ir.DataHash) {
+		return 0, errors.New("incoming data does not begin with the existing data")
+	}
+
+	// don't touch the file if there's nothing more
+	rest := bufio.NewReader(in)
+	if _, err := rest.Peek(1); errors.Is(err, io.EOF) {
+		return 0, nil
+	}
+
+	fullPath := p.tl.FullPath(*ir.DataFile)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return 0, fmt.Errorf("getting size of data file: %v", err)
+	}
+	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return 0, fmt.Errorf("opening data file for appending: %v", err)
+	}
+	defer file.Close()
+
+	appended, err := appendToDataFile(file, rest, h, compression)
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `UPDATE items SET data_hash=?, data_length=? WHERE id=?`,
+			h.Sum(nil), length+appended, ir.ID)
+	}
+	if err != nil {
+		if truncErr := os.Truncate(fullPath, info.Size()); truncErr != nil {
+			p.log.Error("restoring data file after failing to append to it",
+				zap.String("data_file", fullPath),
+				zap.Error(truncErr))
+		}
+		return 0, err
+	}
+
+	return appended, nil
+}
+
+// appendToDataFile writes r to the end of the data file, compressing it if the file is
+// compressed, and adds what it writes to the hash. Both gzip and zstd streams may be
+// concatenated, so appended content is written as its own stream.
+func appendToDataFile(file *os.File, r io.Reader, h io.Writer, compression DataFileCompression) (int64, error) {
+	var w io.Writer = file
+	var cw io.WriteCloser
+	if compression != "" {
+		var err error
+		cw, err = compression.newWriter(file)
+		if err != nil {
+			return 0, err
+		}
+		w = cw
+	}
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return n, fmt.Errorf("appending to data file: %v", err)
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			return n, fmt.Errorf("finishing compressed data: %v", err)
+		}
+	}
+	return n, nil
+}
+
+// dataFileLength returns the size of the (uncompressed) contents of the data file.
+func (tl *Timeline) dataFileLength(dataFile string, compression DataFileCompression) (int64, error) {
+	if compression == "" {
+		info, err := os.Stat(tl.FullPath(dataFile))
+		if err != nil {
+			return 0, fmt.Errorf("getting size of data file: %v", err)
+		}
+		return info.Size(), nil
+	}
+	rc, err := tl.openDataFile(dataFile, compression)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	n, err := io.Copy(io.Discard, rc)
+	if err != nil {
+		return 0, fmt.Errorf("reading data file: %v", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,109 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// growingLogItem returns the item for a log that has the given content so far.
+func growingLogItem(content string, mediaType string) *Item {
+	return &Item{
+		ID:             "growing.log",
+		Classification: ClassMessage,
+		Timestamp:      time.Date(2023, 5, 1, 9, 0, 0, 0, time.UTC),
+		Content: ItemData{
+			Filename:  "growing.log",
+			MediaType: mediaType,
+			Data:      StringData(content),
+		},
+	}
+}
+
+func TestAppendModeText(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	params := ImportParameters{ProcessingOptions: ProcessingOptions{AppendMode: true}}
+
+	importTestItemsWithParams(t, tl, params, growingLogItem("line 1", "text/plain"))
+	importTestItemsWithParams(t, tl, params, growingLogItem("line 1\nline 2", "text/plain"))
+	importTestItemsWithParams(t, tl, params, growingLogItem("line 1\nline 2", "text/plain"))
+
+	item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "growing.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.DataText == nil || *item.DataText != "line 1\nline 2" {
+		t.Errorf("expected text to be appended once, got %v", item.DataText)
+	}
+	if item.ModifiedImportID == nil {
+		t.Error("expected item to be marked as modified by a later import")
+	}
+}
+
+func TestAppendModeDataFile(t *testing.T) {
+	for _, compression := range []DataFileCompression{"", DataFileCompressionGzip, DataFileCompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			tl := newTestTimeline(t)
+
+			// a low threshold makes the log go into a data file
+			po := ProcessingOptions{AppendMode: true, InlineThresholdBytes: 8, CompressDataFiles: compression}
+
+			importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: po}, growingLogItem("first entry\n", "text/plain"))
+			first, _ := readTestDataFile(t, tl, "growing.log")
+
+			const grown = "first entry\nsecond entry\nthird entry\n"
+			importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: po}, growingLogItem("first entry\nsecond entry\n", "text/plain"))
+			importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: po}, growingLogItem(grown, "text/plain"))
+			importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: po}, growingLogItem(grown, "text/plain")) // nothing new
+
+			item, contents := readTestDataFile(t, tl, "growing.log")
+			if contents != grown {
+				t.Errorf("expected data file to contain %q, got %q", grown, contents)
+			}
+			if *item.DataFile != *first.DataFile {
+				t.Errorf("expected the same data file to be appended to, got %s then %s", *first.DataFile, *item.DataFile)
+			}
+			h := newHash()
+			h.Write([]byte(grown))
+			if !bytes.Equal(item.DataHash, h.Sum(nil)) {
+				t.Error("expected data hash to be updated to the hash of the appended contents")
+			}
+			if err := tl.verifyDataFile(*item.DataFile, compression, item.DataHash); err != nil {
+				t.Errorf("appended data file failed verification: %v", err)
+			}
+		})
+	}
+}
+
+func TestAppendModeRejectsDifferentContent(t *testing.T) {
+	tl := newTestTimeline(t)
+	po := ProcessingOptions{AppendMode: true, InlineThresholdBytes: 8}
+
+	importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: po}, growingLogItem("first entry\n", "text/plain"))
+	importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: po}, growingLogItem("rewritten entry\nsecond entry\n", "text/plain"))
+
+	_, contents := readTestDataFile(t, tl, "growing.log")
+	if contents != "first entry\n" {
+		t.Errorf("expected data that does not extend the existing data to be left alone, got %q", contents)
+	}
+}
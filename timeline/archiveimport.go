@@ -0,0 +1,82 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// ImportArchive imports the contents of the archive (such as a zip or tar file) at
+// archivePath without extracting it to disk first. The data source is given "." as
+// the only filename, and reads the entries of the archive through ListingOptions.FS,
+// so only data sources that support it (see DataSource.SupportsFS) can import archives.
+// Encrypted zip archives are decrypted with the passphrase in params. The archive is
+// recorded as the file of the import, so later imports skip it if it is unchanged.
+func (t *Timeline) ImportArchive(ctx context.Context, archivePath string, params ImportParameters) error {
+	if err := t.checkWritable("import"); err != nil {
+		return err
+	}
+
+	if len(params.Filenames) > 0 {
+		return fmt.Errorf("filenames cannot be given when importing an archive")
+	}
+	params.Filenames = []string{archivePath}
+	params.Archive = true
+	return t.Import(ctx, params)
+}
+
+// validateArchive returns an error if the parameters are of an
+// archive import that the data source can't do.
+func (params ImportParameters) validateArchive(ds DataSource) error {
+	if !params.Archive {
+		return nil
+	}
+	if !ds.SupportsFS {
+		return fmt.Errorf("data source %s cannot import from an archive without extracting it", ds.Name)
+	}
+	if len(params.Filenames) != 1 {
+		return fmt.Errorf("archive import must have exactly 1 archive, got %d filenames", len(params.Filenames))
+	}
+	return nil
+}
+
+// fileImportInput returns the filenames to give to the data source's FileImport, and
+// the file system they are in if it's not the local disk: for archive imports, the
+// contents of the archive.
+func (params ImportParameters) fileImportInput(ctx context.Context, ds DataSource) ([]string, fs.FS, error) {
+	if !params.Archive {
+		return params.Filenames, nil, nil
+	}
+	if err := params.validateArchive(ds); err != nil {
+		return nil, nil, err
+	}
+	archivePath := params.Filenames[0]
+	fsys, err := FileSystem(ctx, archivePath, params.Passphrase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening archive %s: %w", archivePath, err)
+	}
+	if _, ok := fsys.(archiver.FileFS); ok {
+		return nil, nil, fmt.Errorf("%s is not an archive", archivePath)
+	}
+	return []string{"."}, fsys, nil
+}
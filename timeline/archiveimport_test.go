@@ -0,0 +1,143 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeTestZip writes a zip archive with the given files (name to contents).
+func writeTestZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "export.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, contents := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func TestImportArchive(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	archiveSource := fmt.Sprintf("archive_test_%d", time.Now().UnixNano())
+	if err := RegisterDataSource(DataSource{
+		Name:            archiveSource,
+		Title:           "Archive test source",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+		SupportsFS:      true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := writeTestZip(t, map[string]string{
+		"messages/2020.txt": "first\nsecond\n",
+		"messages/2021.txt": "third\n",
+	})
+	archiveDir := filepath.Dir(archivePath)
+
+	// the data source reads each line of each file in the archive as a message
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	testFileImport = func(ctx context.Context, filenames []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		if opt.FS == nil {
+			return fmt.Errorf("expected archive as file system")
+		}
+		if !slices.Equal(filenames, []string{"."}) {
+			return fmt.Errorf("expected root of archive as the only filename, got %v", filenames)
+		}
+		return fs.WalkDir(opt.FS, filenames[0], func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			f, err := opt.FS.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for i := 0; scanner.Scan(); i++ {
+				it := testMessage(path.Base(name)+"#"+scanner.Text(), ts.Add(time.Duration(i)*time.Hour))
+				itemChan <- &Graph{Item: it}
+			}
+			return scanner.Err()
+		})
+	}
+
+	err := tl.ImportArchive(ctx, archivePath, ImportParameters{DataSourceName: archiveSource})
+	if err != nil {
+		t.Fatalf("importing archive: %v", err)
+	}
+
+	var ids []string
+	rows, err := tl.db.Query(`SELECT original_id FROM items`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	if expected := []string{"2020.txt#first", "2020.txt#second", "2021.txt#third"}; !slices.Equal(ids, expected) {
+		t.Errorf("expected items %v from the archive, got %v", expected, ids)
+	}
+
+	// nothing was extracted next to the archive
+	entries, err := os.ReadDir(archiveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the archive in its folder, found %d entries", len(entries))
+	}
+
+	// data sources that can't read from a file system can't import archives
+	if err := tl.ImportArchive(ctx, archivePath, ImportParameters{DataSourceName: testDataSourceName}); err == nil {
+		t.Error("expected error importing archive with data source that doesn't support it")
+	}
+}
@@ -0,0 +1,246 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+// ErrItemHasContent is returned when attaching a data file to an item
+// that already has content, without replacing it.
+var ErrItemHasContent = errors.New("item already has content")
+
+// AttachDataFileOptions configures how a data file is attached to an item.
+type AttachDataFileOptions struct {
+	// Replace the item's existing content (text or data file), if any.
+	Replace bool
+
+	// The media type of the file. If empty, it is detected from
+	// the file's contents, or its name as a last resort.
+	MediaType string
+}
+
+// AttachDataFile stores the contents of r as the data file of an existing item,
+// for example a file that was missing when the item was imported. The name is
+// the file's original name, which is used to name the data file. If the repo
+// already has a file with identical contents, that file is used instead. The
+// item's thumbnail is regenerated for the new content.
+//
+// If the item already has content, an error wrapping ErrItemHasContent is
+// returned unless opts.Replace is true. It returns the new data file's path,
+// relative to the repo.
+func (tl *Timeline) AttachDataFile(ctx context.Context, itemID int64, r io.Reader, name string, opts AttachDataFileOptions) (string, error) {
+	if err := tl.checkWritable("attach data file"); err != nil {
+		return "", err
+	}
+
+	rows, err := tl.itemsWhere(ctx, "id=?", itemID)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("item %d: %w", itemID, ErrItemNotFound)
+	}
+	ir := rows[0]
+	if (ir.DataText != nil || ir.DataFile != nil) && !opts.Replace {
+		return "", fmt.Errorf("item %d: %w", itemID, ErrItemHasContent)
+	}
+
+	// the item as far as naming its data file is concerned
+	it := &Item{
+		Content: ItemData{
+			Filename:  filepath.Base(name),
+			MediaType: opts.MediaType,
+		},
+	}
+	if ir.OriginalID != nil {
+		it.ID = *ir.OriginalID
+	}
+	if ir.Timestamp != nil {
+		it.Timestamp = *ir.Timestamp
+	}
+	var dataSourceName string
+	if ir.DataSourceName != nil {
+		dataSourceName = *ir.DataSourceName
+	}
+
+	br := bufio.NewReader(r)
+	if it.Content.MediaType == "" {
+		peekedBytes, _ := br.Peek(512)
+		detectContentType(peekedBytes, it)
+	}
+
+	// write (and hash) the file before claiming a place for it, so that the
+	// database isn't locked while copying what could be a large file
+	tmpFile, size, hash, err := tl.writeTempDataFile(br)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile) // no-op once it has been moved into place
+	if size == 0 {
+		return "", fmt.Errorf("item %d: data file is empty", itemID)
+	}
+
+	dataFile, err := tl.storeAttachedDataFile(ctx, ir, it, dataSourceName, tmpFile, hash)
+	if err != nil {
+		return "", fmt.Errorf("item %d: %w", itemID, err)
+	}
+
+	Log.Info("attached data file to item",
+		zap.Int64("item_id", itemID),
+		zap.String("data_file", dataFile),
+		zap.String("media_type", it.Content.MediaType),
+		zap.Int64("size", size))
+
+	thumbHash, err := tl.regenerateThumbnail(ctx, itemID, dataFile, it.Content.MediaType)
+	if err != nil {
+		return dataFile, fmt.Errorf("item %d: file was attached, but %w", itemID, err)
+	}
+	tl.dbMu.Lock()
+	_, err = tl.db.ExecContext(ctx, `UPDATE items SET thumb_hash=? WHERE id=?`, thumbHash, itemID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+	tl.dbMu.Unlock()
+	if err != nil {
+		return dataFile, fmt.Errorf("item %d: storing thumbhash: %v", itemID, err)
+	}
+
+	return dataFile, nil
+}
+
+// writeTempDataFile copies r into a new file in the repo's temporary folder,
+// from which it can be moved into place cheaply, and hashes it along the way.
+// It returns the file's path, its size, and its hash.
+func (tl *Timeline) writeTempDataFile(r io.Reader) (string, int64, []byte, error) {
+	dir := filepath.Join(tl.repoDir, TempFolderName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", 0, nil, fmt.Errorf("creating temporary folder: %v", err)
+	}
+	f, err := os.CreateTemp(dir, "attach_")
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("creating temporary file: %v", err)
+	}
+	defer f.Close()
+
+	h := newHash()
+	n, err := io.Copy(f, io.TeeReader(r, h))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, nil, fmt.Errorf("writing data file: %v", err)
+	}
+
+	return f.Name(), n, h.Sum(nil), nil
+}
+
+// storeAttachedDataFile moves the temporary file into the repo (or uses an identical
+// file already there) and links it to the item, replacing its previous content. It
+// returns the path of the item's data file.
+func (tl *Timeline) storeAttachedDataFile(ctx context.Context, ir ItemRow, it *Item, dataSourceName, tmpFile string, hash []byte) (dataFile string, err error) {
+	var committed bool
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	tx, err := tl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	dataFile, err = identicalDataFile(ctx, tx, hash)
+	if err != nil {
+		return "", err
+	}
+	if dataFile == "" {
+		f, canonical, err := tl.openUniqueCanonicalItemDataFile(tx, Log, it, dataSourceName, nil)
+		if err != nil {
+			return "", err
+		}
+		f.Close()
+		if err := os.Rename(tmpFile, tl.FullPath(canonical)); err != nil {
+			os.Remove(tl.FullPath(canonical))
+			return "", fmt.Errorf("moving data file into place: %v", err)
+		}
+		dataFile = canonical
+
+		// don't leave the new file behind if it doesn't get linked to the item
+		defer func() {
+			if !committed {
+				os.Remove(tl.FullPath(canonical))
+			}
+		}()
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE items SET data_file=?, data_hash=?, data_type=?, data_text=NULL, thumb_hash=NULL WHERE id=?`, // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		dataFile, hash, it.Content.MediaType, ir.ID)
+	if err != nil {
+		return "", fmt.Errorf("linking data file to item: %v", err)
+	}
+
+	// the previous data file may not be needed anymore
+	if ir.DataFile != nil && *ir.DataFile != dataFile {
+		if err := tl.cleanDataFile(tx, *ir.DataFile); err != nil {
+			return "", fmt.Errorf("cleaning up replaced data file: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("committing transaction: %v", err)
+	}
+	committed = true
+
+	return dataFile, nil
+}
+
+// identicalDataFile returns the path of a data file in the repo with the given
+// hash, if there is one. Files referenced outside the repo are not considered,
+// since they could go away at any time.
+func identicalDataFile(ctx context.Context, tx *sql.Tx, hash []byte) (string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT data_file FROM items WHERE data_hash=? AND data_file IS NOT NULL`, hash)
+	if err != nil {
+		return "", fmt.Errorf("looking for identical data file: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dataFile string
+		if err := rows.Scan(&dataFile); err != nil {
+			return "", fmt.Errorf("scanning data file: %v", err)
+		}
+		if !isExternalDataFile(dataFile) {
+			return dataFile, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("iterating data files: %v", err)
+	}
+
+	return "", nil
+}
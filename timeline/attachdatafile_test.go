@@ -0,0 +1,172 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPNG returns a small PNG image filled with the given color.
+func testPNG(t *testing.T, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 48; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAttachDataFile(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	ts := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	importTestItems(t, tl, testMessage("notes", ts), testMessage("copy", ts.Add(time.Hour)))
+	item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the item already has text, so it isn't replaced unless asked
+	_, err = tl.AttachDataFile(ctx, item.ID, strings.NewReader("first draft"), "notes.txt", AttachDataFileOptions{})
+	if !errors.Is(err, ErrItemHasContent) {
+		t.Fatalf("expected %v, got: %v", ErrItemHasContent, err)
+	}
+
+	attach := func(itemID int64, contents string) string {
+		t.Helper()
+		dataFile, err := tl.AttachDataFile(ctx, itemID, strings.NewReader(contents), "notes.txt", AttachDataFileOptions{Replace: true})
+		if err != nil {
+			t.Fatalf("attaching data file: %v", err)
+		}
+		row, err := tl.ItemByID(ctx, itemID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.DataFile == nil || *row.DataFile != dataFile {
+			t.Errorf("expected item's data file to be %s, got %v", dataFile, row.DataFile)
+		}
+		if row.DataText != nil {
+			t.Errorf("expected item's text to be replaced, got %q", *row.DataText)
+		}
+		if row.DataType == nil || !strings.HasPrefix(*row.DataType, "text/plain") {
+			t.Errorf("expected detected media type text/plain, got %v", row.DataType)
+		}
+		stored, err := os.ReadFile(tl.FullPath(dataFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(stored) != contents {
+			t.Errorf("expected stored data file to contain %q, got %q", contents, stored)
+		}
+		return dataFile
+	}
+
+	firstFile := attach(item.ID, "first draft")
+	secondFile := attach(item.ID, "second draft")
+	if firstFile == secondFile {
+		t.Fatalf("expected a new data file for new contents, got %s again", secondFile)
+	}
+	if _, err := os.Stat(tl.FullPath(firstFile)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected replaced data file %s to be deleted, got: %v", firstFile, err)
+	}
+
+	// an identical file already in the repo is reused instead of stored again
+	other, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataFile := attach(other.ID, "second draft"); dataFile != secondFile {
+		t.Errorf("expected identical data file %s to be reused, got %s", secondFile, dataFile)
+	}
+
+	// no such item
+	_, err = tl.AttachDataFile(ctx, 12345, strings.NewReader("orphan"), "orphan.txt", AttachDataFileOptions{})
+	if !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("expected %v, got: %v", ErrItemNotFound, err)
+	}
+}
+
+func TestAttachDataFileRegeneratesThumbnail(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	red, blue := testPNG(t, color.RGBA{R: 255, A: 255}), testPNG(t, color.RGBA{B: 255, A: 255})
+
+	// thumbnails depend on the image library being available
+	probe := filepath.Join(t.TempDir(), "probe.png")
+	if err := os.WriteFile(probe, red, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := loadImageFromFile(probe); err != nil {
+		t.Skipf("image thumbnails are not supported: %v", err)
+	} else {
+		img.Close()
+	}
+
+	importTestItems(t, tl, testMessage("photo", time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)))
+	item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "photo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attach := func(contents []byte) []byte {
+		t.Helper()
+		_, err := tl.AttachDataFile(ctx, item.ID, bytes.NewReader(contents), "photo.png", AttachDataFileOptions{Replace: true})
+		if err != nil {
+			t.Fatalf("attaching data file: %v", err)
+		}
+		row, err := tl.ItemByID(ctx, item.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.DataType == nil || *row.DataType != "image/png" {
+			t.Errorf("expected detected media type image/png, got %v", row.DataType)
+		}
+		if len(row.ThumbHash) == 0 {
+			t.Error("expected item to have a thumbhash")
+		}
+		thumbnail, err := os.ReadFile(tl.ThumbnailPath(item.ID, ImageThumbnail))
+		if err != nil {
+			t.Fatalf("expected thumbnail to be generated: %v", err)
+		}
+		return thumbnail
+	}
+
+	if bytes.Equal(attach(red), attach(blue)) {
+		t.Error("expected thumbnail to be regenerated for the new data file")
+	}
+}
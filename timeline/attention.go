@@ -0,0 +1,200 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+)
+
+// ItemsNeedingAttention lists items (and relationships) that are in a degraded
+// state, grouped by what is wrong with them, so the user can be prompted to
+// fix them.
+type ItemsNeedingAttention struct {
+	// Placeholder items that are known to exist but whose content was
+	// never imported or completed (see ItemsPendingCompletion).
+	UncompletedPlaceholders []ItemRow `json:"uncompleted_placeholders,omitempty"`
+
+	// Items whose data file is missing from the repo.
+	MissingDataFiles []ItemRow `json:"missing_data_files,omitempty"`
+
+	// Items without a timestamp, which can't be placed on the timeline.
+	// (Placeholders are not included here.)
+	MissingTimestamps []ItemRow `json:"missing_timestamps,omitempty"`
+
+	// Relationships to items that were deleted or never arrived.
+	DanglingRelationships []DanglingRelationship `json:"dangling_relationships,omitempty"`
+}
+
+// DanglingRelationship is a relationship that points to an item which
+// has been deleted, or which has no content and can't be completed.
+type DanglingRelationship struct {
+	RelationshipID int64  `json:"relationship_id"`
+	Relation       string `json:"relation"`
+	FromItemID     *int64 `json:"from_item_id,omitempty"`
+	ToItemID       *int64 `json:"to_item_id,omitempty"`
+}
+
+// itemHasNoContent returns a SQL condition that is true if the item with
+// the given table name or alias has no content (no text, file, or location).
+func itemHasNoContent(table string) string {
+	return `(` + table + `.data_text IS NULL OR ` + table + `.data_text='')
+		AND ` + table + `.data_file IS NULL
+		AND ` + table + `.longitude IS NULL
+		AND ` + table + `.latitude IS NULL
+		AND ` + table + `.altitude IS NULL`
+}
+
+// ItemsNeedingAttention finds items that are in a degraded state: placeholders
+// that were never completed, items whose data file is missing on disk, items
+// with no timestamp, and relationships that dangle. Deleted items are not
+// reported (except as the target of a dangling relationship). It checks every
+// data file in the repo, so it can take a while for large timelines.
+func (tl *Timeline) ItemsNeedingAttention(ctx context.Context) (ItemsNeedingAttention, error) {
+	var result ItemsNeedingAttention
+	var err error
+
+	result.UncompletedPlaceholders, err = tl.ItemsPendingCompletion(ctx, "")
+	if err != nil {
+		return result, err
+	}
+
+	result.MissingDataFiles, err = tl.itemsWithMissingDataFiles(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	result.MissingTimestamps, err = tl.itemsWhere(ctx, `timestamp IS NULL
+		AND deleted IS NULL
+		AND NOT (retrieval_key IS NOT NULL AND `+itemHasNoContent("items")+`)`)
+	if err != nil {
+		return result, fmt.Errorf("finding items without timestamps: %v", err)
+	}
+
+	result.DanglingRelationships, err = tl.danglingRelationships(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// itemsWithMissingDataFiles returns the items whose data file doesn't exist on disk.
+func (tl *Timeline) itemsWithMissingDataFiles(ctx context.Context) ([]ItemRow, error) {
+	var results []ItemRow
+	var lastItemID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		page, err := tl.integrityCheckPage(ctx, lastItemID)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		lastItemID = page[len(page)-1].rowID
+
+		var missing []int64
+		for _, it := range page {
+			if !FileExists(tl.FullPath(it.dataFile)) {
+				missing = append(missing, it.rowID)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		array, args := sqlArray(missing)
+		items, err := tl.itemsWhere(ctx, `id IN `+array+` AND deleted IS NULL`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("loading items with missing data files: %v", err)
+		}
+		results = append(results, items...)
+	}
+	return results, nil
+}
+
+// itemsWhere returns the items matching the SQL condition, in order of row ID.
+func (tl *Timeline) itemsWhere(ctx context.Context, condition string, args ...any) ([]ItemRow, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT `+itemDBColumns+`
+		FROM extended_items AS items
+		WHERE `+condition+`
+		ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying items: %v", err)
+	}
+	defer rows.Close()
+
+	var results []ItemRow
+	for rows.Next() {
+		ir, err := scanItemRow(rows, nil)
+		if err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		results = append(results, ir)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return results, nil
+}
+
+// danglingRelationships returns relationships where either item has been deleted, or
+// where the item being pointed to has no content and no way of getting completed.
+// (Items without content can be the origin of a relationship, such as an email with
+// only attachments, so that is not a problem.)
+func (tl *Timeline) danglingRelationships(ctx context.Context) ([]DanglingRelationship, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT relationships.id, relations.label, relationships.from_item_id, relationships.to_item_id
+		FROM relationships
+		JOIN relations ON relations.id = relationships.relation_id
+		LEFT JOIN items AS from_item ON from_item.id = relationships.from_item_id
+		LEFT JOIN items AS to_item ON to_item.id = relationships.to_item_id
+		WHERE from_item.deleted IS NOT NULL
+			OR to_item.deleted IS NOT NULL
+			OR (to_item.id IS NOT NULL AND to_item.retrieval_key IS NULL AND `+itemHasNoContent("to_item")+`)
+		ORDER BY relationships.id`)
+	if err != nil {
+		return nil, fmt.Errorf("querying dangling relationships: %v", err)
+	}
+	defer rows.Close()
+
+	var results []DanglingRelationship
+	for rows.Next() {
+		var dr DanglingRelationship
+		if err := rows.Scan(&dr.RelationshipID, &dr.Relation, &dr.FromItemID, &dr.ToItemID); err != nil {
+			return nil, fmt.Errorf("scanning relationship: %v", err)
+		}
+		results = append(results, dr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating relationship rows: %v", err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,94 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestItemsNeedingAttention(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		// a reply to a message that hasn't been imported (becomes a placeholder)
+		itemChan <- &Graph{Item: testMessage("reply", ts), Edges: []Relationship{
+			{Relation: RelReply, To: &Graph{Item: &Item{ID: "never_imported"}}},
+		}}
+		// a message with an attachment that will be deleted (leaving a dangling relationship)
+		itemChan <- &Graph{Item: testMessage("with_attachment", ts.Add(time.Hour)), Edges: []Relationship{
+			{Relation: RelAttachment, To: &Graph{Item: testMessage("attachment", ts.Add(time.Hour))}},
+		}}
+		itemChan <- &Graph{Item: testFileItem("file", ts.Add(2*time.Hour))}
+		itemChan <- &Graph{Item: testMessage("timeless", time.Time{})}
+		itemChan <- &Graph{Item: testMessage("healthy", ts.Add(3*time.Hour))}
+		return nil
+	}
+	err := tl.Import(ctx, ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{MissingReferences: MissingReferencesPlaceholder},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(tl.FullPath(*file.DataFile)); err != nil {
+		t.Fatal(err)
+	}
+	attachment, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "attachment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	noRetention := time.Duration(0)
+	if err := tl.DeleteItems(ctx, []int64{attachment.ID}, DeleteOptions{Retain: &noRetention}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := tl.ItemsNeedingAttention(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertItems := func(category string, items []ItemRow, originalID string) {
+		t.Helper()
+		if len(items) != 1 || items[0].OriginalID == nil || *items[0].OriginalID != originalID {
+			t.Errorf("expected %s to be only %s, got %+v", category, originalID, items)
+		}
+	}
+	assertItems("uncompleted placeholders", result.UncompletedPlaceholders, "never_imported")
+	assertItems("missing data files", result.MissingDataFiles, "file")
+	assertItems("missing timestamps", result.MissingTimestamps, "timeless")
+
+	if len(result.DanglingRelationships) != 1 {
+		t.Fatalf("expected 1 dangling relationship, got %+v", result.DanglingRelationships)
+	}
+	dangling := result.DanglingRelationships[0]
+	if dangling.Relation != RelAttachment.Label || dangling.ToItemID == nil || *dangling.ToItemID != attachment.ID {
+		t.Errorf("expected dangling relationship to deleted attachment %d, got %+v", attachment.ID, dangling)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// BackfillHashesOptions configures a hash backfill.
+type BackfillHashesOptions struct {
+	// If set, the backfill resumes after this item, which is
+	// the LastItemID of a previous, incomplete backfill.
+	ResumeAfterItemID int64 `json:"resume_after_item_id,omitempty"`
+
+	// How many data files to hash at the same time. Default: 4.
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// If set, this function will be called after each
+	// page of items is hashed.
+	ProgressFunc func(BackfillHashesResult) `json:"-"`
+}
+
+// BackfillHashesResult is the outcome (or progress) of a hash backfill.
+// If the backfill did not complete, it can be resumed by passing
+// LastItemID as the ResumeAfterItemID option.
+type BackfillHashesResult struct {
+	Hashed     int64 `json:"hashed"`
+	Skipped    int64 `json:"skipped"` // empty data files, which don't get a hash
+	Failed     int64 `json:"failed"`  // data files that couldn't be read; their hash stays empty
+	LastItemID int64 `json:"last_item_id"`
+	Complete   bool  `json:"complete"`
+}
+
+// BackfillHashes computes and stores the checksums of data files that don't
+// have one, for example because they were imported before checksums were
+// recorded or because their import was interrupted. Items without data files
+// are not affected. Running it again after it completes is a no-op, except that
+// data files which failed to hash are tried again.
+func (tl *Timeline) BackfillHashes(ctx context.Context, opts BackfillHashesOptions) (BackfillHashesResult, error) {
+	if err := tl.checkWritable("backfill hashes"); err != nil {
+		return BackfillHashesResult{}, err
+	}
+
+	result := BackfillHashesResult{LastItemID: opts.ResumeAfterItemID}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	logger := Log.Named("backfill")
+	logger.Info("backfilling data file hashes", zap.Int64("resuming_after_item_id", result.LastItemID))
+
+	for {
+		page, err := tl.backfillHashesPage(ctx, result.LastItemID)
+		if err != nil {
+			return result, err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		throttle := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, it := range page {
+			throttle <- struct{}{}
+			wg.Add(1)
+			go func(it integrityCheckItem) {
+				defer func() {
+					<-throttle
+					wg.Done()
+				}()
+				hashed, err := tl.backfillHash(ctx, it)
+				switch {
+				case err != nil:
+					logger.Warn("unable to backfill data file hash",
+						zap.Int64("item_id", it.rowID),
+						zap.String("data_file", it.dataFile),
+						zap.Error(err))
+					atomic.AddInt64(&result.Failed, 1)
+				case hashed:
+					atomic.AddInt64(&result.Hashed, 1)
+				default:
+					atomic.AddInt64(&result.Skipped, 1)
+				}
+			}(it)
+		}
+		wg.Wait()
+
+		// only advance the checkpoint once the whole page is done, so
+		// that a canceled page is processed again when resuming
+		if err := ctx.Err(); err != nil {
+			logger.Info("hash backfill canceled",
+				zap.Int64("hashed", result.Hashed),
+				zap.Int64("last_item_id", result.LastItemID))
+			return result, err
+		}
+		result.LastItemID = page[len(page)-1].rowID
+
+		if opts.ProgressFunc != nil {
+			opts.ProgressFunc(result)
+		}
+	}
+
+	result.Complete = true
+
+	logger.Info("hash backfill complete",
+		zap.Int64("hashed", result.Hashed),
+		zap.Int64("skipped", result.Skipped),
+		zap.Int64("failed", result.Failed))
+
+	return result, nil
+}
+
+// backfillHashesPage returns the next page of items with data files but no hash after the given item ID.
+func (tl *Timeline) backfillHashesPage(ctx context.Context, afterItemID int64) ([]integrityCheckItem, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx,
+		`SELECT items.id, items.data_file, coalesce(compressed_data_files.compression, '')
+		FROM items
+		LEFT JOIN compressed_data_files ON compressed_data_files.data_file = items.data_file
+		WHERE items.id > ? AND items.data_file IS NOT NULL AND items.data_hash IS NULL
+		ORDER BY items.id
+		LIMIT ?`, afterItemID, integrityCheckPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("querying items to hash: %v", err)
+	}
+	defer rows.Close()
+
+	var page []integrityCheckItem
+	for rows.Next() {
+		var it integrityCheckItem
+		if err := rows.Scan(&it.rowID, &it.dataFile, &it.compression); err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		page = append(page, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return page, nil
+}
+
+// backfillHash hashes the data file of the item and stores the hash. It returns
+// false if the file is empty, since empty data files don't get a hash.
+func (tl *Timeline) backfillHash(ctx context.Context, it integrityCheckItem) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	datafile, err := tl.openDataFile(it.dataFile, it.compression)
+	if err != nil {
+		return false, fmt.Errorf("opening data file: %w", err)
+	}
+	defer datafile.Close()
+
+	h := newHash()
+	n, err := io.Copy(h, datafile)
+	if err != nil {
+		return false, fmt.Errorf("reading data file: %w", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	// don't overwrite a hash that was stored in the meantime (e.g. by an import)
+	tl.dbMu.Lock()
+	_, err = tl.db.ExecContext(ctx, `UPDATE items SET data_hash=? WHERE id=? AND data_hash IS NULL`, // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		h.Sum(nil), it.rowID)
+	tl.dbMu.Unlock()
+	if err != nil {
+		return false, fmt.Errorf("storing hash: %v", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,117 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBackfillHashes(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const numItems = 5
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	var items []*Item
+	for i := 0; i < numItems; i++ {
+		items = append(items, testFileItem(fmt.Sprintf("file%d", i), ts.Add(time.Duration(i)*time.Hour)))
+	}
+	importTestItems(t, tl, items...)
+	importTestItems(t, tl, testMessage("text_only", ts))
+
+	// remember the correct hashes, then forget them, like a repo from before hashes were stored
+	expected := make(map[int64][]byte)
+	rows, err := tl.db.Query(`SELECT id, data_hash FROM items WHERE data_file IS NOT NULL ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rowIDs []int64
+	for rows.Next() {
+		var id int64
+		var hash []byte
+		if err := rows.Scan(&id, &hash); err != nil {
+			t.Fatal(err)
+		}
+		expected[id] = hash
+		rowIDs = append(rowIDs, id)
+	}
+	rows.Close()
+	if len(expected) != numItems {
+		t.Fatalf("expected %d items with data files, got %d", numItems, len(expected))
+	}
+	if _, err := tl.db.Exec(`UPDATE items SET data_hash=NULL`); err != nil {
+		t.Fatal(err)
+	}
+
+	// resuming part way through only hashes the remaining items
+	resumeAfter := rowIDs[1]
+	result, err := tl.BackfillHashes(context.Background(), BackfillHashesOptions{
+		ResumeAfterItemID: resumeAfter,
+		Concurrency:       2,
+	})
+	if err != nil {
+		t.Fatalf("backfilling hashes: %v", err)
+	}
+	if !result.Complete || result.Hashed != numItems-2 || result.Failed != 0 {
+		t.Errorf("unexpected result when resuming: %+v", result)
+	}
+
+	// a full run hashes the rest
+	var progressCalls int
+	result, err = tl.BackfillHashes(context.Background(), BackfillHashesOptions{
+		ProgressFunc: func(BackfillHashesResult) { progressCalls++ },
+	})
+	if err != nil {
+		t.Fatalf("backfilling hashes: %v", err)
+	}
+	if !result.Complete || result.Hashed != 2 || progressCalls == 0 {
+		t.Errorf("unexpected result (progress calls: %d): %+v", progressCalls, result)
+	}
+
+	for id, hash := range expected {
+		var got []byte
+		if err := tl.db.QueryRow(`SELECT data_hash FROM items WHERE id=?`, id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, hash) {
+			t.Errorf("item %d: expected hash %x, got %x", id, hash, got)
+		}
+	}
+
+	// items without data files are not given a hash
+	var textHash []byte
+	if err := tl.db.QueryRow(`SELECT data_hash FROM items WHERE original_id='text_only'`).Scan(&textHash); err != nil {
+		t.Fatal(err)
+	}
+	if textHash != nil {
+		t.Errorf("expected no hash for text item, got %x", textHash)
+	}
+
+	// running again does nothing
+	result, err = tl.BackfillHashes(context.Background(), BackfillHashesOptions{})
+	if err != nil {
+		t.Fatalf("backfilling hashes again: %v", err)
+	}
+	if !result.Complete || result.Hashed != 0 || result.Failed != 0 {
+		t.Errorf("expected re-run to be a no-op, got: %+v", result)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+)
+
+// CancelReason describes why an import stopped before it finished, so that
+// it can be explained to the user (for example, "stopped by user" versus
+// "stopped: timeline was closed").
+type CancelReason string
+
+const (
+	// CancelReasonUser means the import was canceled by the user or
+	// by the program that started it.
+	CancelReasonUser CancelReason = "user"
+
+	// CancelReasonShutdown means the import was canceled because the
+	// timeline was being closed.
+	CancelReasonShutdown CancelReason = "shutdown"
+
+	// CancelReasonInterrupted means the program stopped while the import
+	// was running, for example because it crashed or was killed (see
+	// ReconcileImports).
+	CancelReasonInterrupted CancelReason = "interrupted"
+
+	// CancelReasonDeadline means the import reached its maximum
+	// duration (see ProcessingOptions.MaxDuration).
+	CancelReasonDeadline CancelReason = "deadline"
+
+	// CancelReasonStalled means the import was aborted by the
+	// watchdog for not making progress (see ProcessingOptions.Watchdog).
+	CancelReasonStalled CancelReason = "stalled"
+
+	// CancelReasonFailures means the import was aborted because too many
+	// items failed (see ProcessingOptions.FailureThreshold).
+	CancelReasonFailures CancelReason = "failures"
+)
+
+// ImportCanceledError can be given as the cause when canceling the context of an
+// import (see context.WithCancelCause) to record why it was canceled, for reasons
+// that the timeline doesn't know about itself, such as the disk running low on space.
+type ImportCanceledError struct {
+	Reason CancelReason `json:"reason"`
+}
+
+func (e ImportCanceledError) Error() string {
+	return "import canceled: " + string(e.Reason)
+}
+
+func (ImportCanceledError) Unwrap() error { return context.Canceled }
+
+// canceledReason returns why the import's context was canceled.
+func (p *processor) canceledReason(ctx context.Context) CancelReason {
+	var canceled ImportCanceledError
+	if errors.As(context.Cause(ctx), &canceled) && canceled.Reason != "" {
+		return canceled.Reason
+	}
+	if p.tl.ctx.Err() != nil {
+		return CancelReasonShutdown
+	}
+	return CancelReasonUser
+}
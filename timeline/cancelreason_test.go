@@ -0,0 +1,112 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// slowTestImport makes the test data source emit items slowly until its context is done.
+func slowTestImport() {
+	ts := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		start := 0
+		if last, ok := opt.Checkpoint.(int); ok {
+			start = last + 1
+		}
+		for i := start; i < 1000; i++ {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			itemChan <- &Graph{
+				Item:       testMessage(fmt.Sprintf("slow%d", i), ts.Add(time.Duration(i)*time.Minute)),
+				Checkpoint: i,
+			}
+		}
+		return nil
+	}
+}
+
+func lastImportInfo(t *testing.T, tl *Timeline) ImportInfo {
+	t.Helper()
+	imports, err := tl.ListImports(context.Background(), ListImportsParams{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imports) != 1 {
+		t.Fatalf("expected 1 import, got %d", len(imports))
+	}
+	return imports[0]
+}
+
+func TestCancelReasonDeadline(t *testing.T) {
+	tl := newTestTimeline(t)
+	slowTestImport()
+
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{MaxDuration: 50 * time.Millisecond},
+	})
+	var exceeded DeadlineExceededImport
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected DeadlineExceededImport, got: %v", err)
+	}
+
+	imp := lastImportInfo(t, tl)
+	if imp.Status != importStatusPartial || imp.CancelReason != CancelReasonDeadline {
+		t.Errorf("expected partial import stopped by %q, got status=%q reason=%q",
+			CancelReasonDeadline, imp.Status, imp.CancelReason)
+	}
+}
+
+func TestCancelReasonUser(t *testing.T) {
+	for _, tc := range []struct {
+		cause  error
+		expect CancelReason
+	}{
+		{cause: nil, expect: CancelReasonUser},
+		{cause: ImportCanceledError{Reason: "low_disk"}, expect: "low_disk"},
+	} {
+		tl := newTestTimeline(t)
+		slowTestImport()
+
+		ctx, cancel := context.WithCancelCause(context.Background())
+		time.AfterFunc(50*time.Millisecond, func() { cancel(tc.cause) })
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+		})
+		if err == nil {
+			t.Fatal("expected import to be canceled")
+		}
+
+		imp := lastImportInfo(t, tl)
+		if imp.Status != importStatusAborted || imp.CancelReason != tc.expect {
+			t.Errorf("expected aborted import stopped by %q, got status=%q reason=%q",
+				tc.expect, imp.Status, imp.CancelReason)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"strings"
+)
+
+// Categories of items, for faceted browsing. Every item is assigned at most
+// one category when it is imported (see Classifier). Categories are stored
+// in the database, so these values are stable and must never change; new
+// categories may be added. Unlike classifications, which describe what kind
+// of record an item is from the data source's point of view, categories
+// describe what the item is to the user.
+const (
+	CategoryPhoto    = "photo"    // an image: photo, screenshot, illustration, etc.
+	CategoryVideo    = "video"    // a video recording
+	CategoryAudio    = "audio"    // a sound recording: voice memo, music, etc.
+	CategoryMessage  = "message"  // a message between people: chat, text, email, etc.
+	CategoryPost     = "post"     // a post, comment, or other content published to social media
+	CategoryLocation = "location" // a place the owner was, such as a location history point
+	CategoryDocument = "document" // a document, such as a PDF or a note
+	CategoryPurchase = "purchase" // an order, receipt, or other transaction
+)
+
+// Classifier assigns a category to items as they are imported, for example
+// based on their content or metadata. Classifiers are given by the import
+// parameters; see ImportParameters.Classifiers.
+type Classifier interface {
+	// Category returns the category of the item from the given data source,
+	// or an empty string if the classifier can't tell. It should be one of
+	// the standard categories, but custom values are allowed. It must not
+	// modify the item.
+	Category(ctx context.Context, ds DataSource, it *Item) string
+}
+
+// ClassifierFunc is a function that implements Classifier.
+type ClassifierFunc func(ctx context.Context, ds DataSource, it *Item) string
+
+// Category implements Classifier.
+func (f ClassifierFunc) Category(ctx context.Context, ds DataSource, it *Item) string {
+	return f(ctx, ds, it)
+}
+
+// DefaultClassifier categorizes items by their media type, then by their
+// classification, and finally by the data source's Category, if any. It is
+// always applied to items that the import's classifiers don't categorize.
+var DefaultClassifier Classifier = ClassifierFunc(defaultCategory)
+
+func defaultCategory(_ context.Context, ds DataSource, it *Item) string {
+	mediaType := strings.ToLower(it.Content.MediaType)
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return CategoryPhoto
+	case strings.HasPrefix(mediaType, "video/"):
+		return CategoryVideo
+	case strings.HasPrefix(mediaType, "audio/"):
+		return CategoryAudio
+	}
+
+	switch it.Classification.Name {
+	case ClassMessage.Name, ClassEmail.Name:
+		return CategoryMessage
+	case ClassSocial.Name:
+		return CategoryPost
+	case ClassLocation.Name:
+		return CategoryLocation
+	}
+
+	switch {
+	case mediaType == "application/pdf",
+		strings.HasPrefix(mediaType, "application/msword"),
+		strings.HasPrefix(mediaType, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(mediaType, "application/vnd.oasis.opendocument."):
+		return CategoryDocument
+	}
+
+	return ds.Category
+}
+
+// itemCategory returns the category of the item according to the import's
+// classifiers, falling back to the default classifier.
+func (p *processor) itemCategory(ctx context.Context, it *Item) string {
+	for _, c := range p.params.Classifiers {
+		if category := c.Category(ctx, p.ds, it); category != "" {
+			return category
+		}
+	}
+	return DefaultClassifier.Category(ctx, p.ds, it)
+}
@@ -0,0 +1,118 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPhotoItemIsCategorized(t *testing.T) {
+	tl := newTestTimeline(t)
+	ts := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	photo := testFileItem("photo", ts)
+	photo.Content.Filename = "IMG_0001.jpg"
+	photo.Content.MediaType = "image/jpeg"
+	importTestItems(t, tl, photo, testMessage("hello", ts), testFileItem("blob", ts))
+
+	category := func(originalID string) *string {
+		t.Helper()
+		var category *string
+		if err := tl.db.QueryRow(`SELECT category FROM items WHERE original_id=?`, originalID).Scan(&category); err != nil {
+			t.Fatal(err)
+		}
+		return category
+	}
+	if got := category("photo"); got == nil || *got != CategoryPhoto {
+		t.Errorf("expected photo to have category %q, got %v", CategoryPhoto, got)
+	}
+	if got := category("hello"); got == nil || *got != CategoryMessage {
+		t.Errorf("expected message to have category %q, got %v", CategoryMessage, got)
+	}
+	if got := category("blob"); got != nil {
+		t.Errorf("expected item of unknown type to have no category, got %q", *got)
+	}
+
+	search := func(categories []string) []string {
+		t.Helper()
+		results, err := tl.Search(context.Background(), ItemSearchParams{Category: categories})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		var ids []string
+		for _, sr := range results.Items {
+			ids = append(ids, *sr.OriginalID)
+			if *sr.OriginalID == "photo" && (sr.Category == nil || *sr.Category != CategoryPhoto) {
+				t.Errorf("expected search result to have category %q, got %v", CategoryPhoto, sr.Category)
+			}
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	if got := search([]string{CategoryPhoto}); !slices.Equal(got, []string{"photo"}) {
+		t.Errorf("expected only the photo in its category, got %v", got)
+	}
+	if got := search([]string{CategoryPhoto, CategoryMessage}); !slices.Equal(got, []string{"hello", "photo"}) {
+		t.Errorf("expected the photo and the message, got %v", got)
+	}
+	if got := search([]string{}); !slices.Equal(got, []string{"blob"}) {
+		t.Errorf("expected only the uncategorized item, got %v", got)
+	}
+}
+
+func TestImportClassifiers(t *testing.T) {
+	tl := newTestTimeline(t)
+	ts := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	receipt := testMessage("receipt", ts)
+	receipt.Metadata = Metadata{"Order total": "12.50"}
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: receipt}
+		itemChan <- &Graph{Item: testMessage("chat", ts)}
+		return nil
+	}
+
+	purchases := ClassifierFunc(func(_ context.Context, _ DataSource, it *Item) string {
+		if _, ok := it.Metadata["Order total"]; ok {
+			return CategoryPurchase
+		}
+		return ""
+	})
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		Classifiers:    []Classifier{purchases},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	for originalID, expected := range map[string]string{"receipt": CategoryPurchase, "chat": CategoryMessage} {
+		var category string
+		if err := tl.db.QueryRow(`SELECT category FROM items WHERE original_id=?`, originalID).Scan(&category); err != nil {
+			t.Fatal(err)
+		}
+		if category != expected {
+			t.Errorf("expected %s to have category %q, got %q", originalID, expected, category)
+		}
+	}
+}
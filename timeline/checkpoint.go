@@ -0,0 +1,85 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// checkpointWarnSize is the encoded size of a checkpoint above which
+	// a warning is logged, since checkpoints are rewritten often and large
+	// ones bloat the imports table. Data sources whose checkpoints keep
+	// growing should implement CompactCheckpoint.
+	checkpointWarnSize = 1024 * 1024
+
+	// maxCheckpointSize is the largest encoded checkpoint that is saved.
+	// Larger checkpoints are dropped (the previous one is kept), so a
+	// resumed import may redo some work, which is preferable to writing
+	// many megabytes for every checkpoint.
+	maxCheckpointSize = 16 * 1024 * 1024
+)
+
+// encodeCheckpoint compacts (if the data source supports it) and encodes the
+// data source's checkpoint data along with the import's parameters. It returns
+// nil if the encoded checkpoint is too large to save.
+func (p *processor) encodeCheckpoint(data any) ([]byte, error) {
+	if p.ds.CompactCheckpoint != nil {
+		data = p.ds.CompactCheckpoint(data)
+	}
+
+	chkpt, err := marshalGob(checkpoint{p.params.Filenames, p.params.Archive, p.params.ProcessingOptions, data})
+	if err != nil {
+		return nil, err
+	}
+	size := len(chkpt)
+
+	if size > maxCheckpointSize {
+		p.log.Error("checkpoint is too large; not saving it",
+			zap.Int("size", size),
+			zap.Int("max_size", maxCheckpointSize))
+		return nil, nil
+	}
+
+	// warn only the first time the checkpoint grows past the threshold
+	if previous := p.recordCheckpointSize(size); previous <= checkpointWarnSize && size > checkpointWarnSize {
+		p.log.Warn("data source's checkpoint is large; it should be compacted",
+			zap.Int("size", size),
+			zap.Int("warn_size", checkpointWarnSize),
+			zap.Bool("compacted", p.ds.CompactCheckpoint != nil))
+	}
+
+	return chkpt, nil
+}
+
+// recordCheckpointSize records the encoded size of a checkpoint and
+// returns the largest size recorded before it.
+func (p *processor) recordCheckpointSize(size int) int64 {
+	if p.largestCheckpoint == nil {
+		return 0
+	}
+	for {
+		largest := atomic.LoadInt64(p.largestCheckpoint)
+		if int64(size) <= largest || atomic.CompareAndSwapInt64(p.largestCheckpoint, largest, int64(size)) {
+			return largest
+		}
+	}
+}
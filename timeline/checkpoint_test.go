@@ -0,0 +1,135 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompactCheckpoint(t *testing.T) {
+	compactingSource := fmt.Sprintf("compacting_source_%d", time.Now().UnixNano())
+	var compactions atomic.Int32
+	err := RegisterDataSource(DataSource{
+		Name:            compactingSource,
+		Title:           "Compacting test source",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+		CompactCheckpoint: func(checkpoint any) any {
+			// the IDs are emitted in order, so the last one seen is as
+			// good as all of them for knowing where to resume
+			compactions.Add(1)
+			seen := checkpoint.([]string)
+			return seen[len(seen)-1:]
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl := newTestTimeline(t)
+
+	const numItems = 500
+
+	// savedCheckpoint returns the data of the checkpoint currently saved for the import
+	savedCheckpoint := func() (any, error) {
+		tl.dbMu.RLock()
+		defer tl.dbMu.RUnlock()
+		var chkptBytes []byte
+		err := tl.db.QueryRow(`SELECT checkpoint FROM imports ORDER BY id DESC LIMIT 1`).Scan(&chkptBytes)
+		if err != nil || len(chkptBytes) == 0 {
+			return nil, err
+		}
+		var chkpt checkpoint
+		if err := unmarshalGob(chkptBytes, &chkpt); err != nil {
+			return nil, fmt.Errorf("decoding checkpoint: %w", err)
+		}
+		return chkpt.Data, nil
+	}
+
+	// the checkpoint is cleared when the import succeeds, so look at it
+	// before the data source finishes (graphs are committed one at a time
+	// so that a checkpoint is saved without waiting for the end)
+	var savedData any
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		var seen []string
+		for i := 0; i < numItems; i++ {
+			id := fmt.Sprintf("item%03d", i)
+			seen = append(seen, id)
+			itemChan <- &Graph{
+				Item:       testMessage(id, ts.Add(time.Duration(i)*time.Minute)),
+				Checkpoint: slices.Clone(seen),
+			}
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			data, err := savedCheckpoint()
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				savedData = data
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	}
+
+	var largest atomic.Int64
+	err = tl.Import(context.Background(), ImportParameters{
+		DataSourceName: compactingSource,
+		Filenames:      []string{"test"},
+		ProcessingOptions: ProcessingOptions{
+			FlushEvery: &FlushEvery{Items: 1},
+		},
+		ProgressFunc: func(st ImportStatus) {
+			largest.Store(max(largest.Load(), st.LargestCheckpoint))
+		},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	if n := compactions.Load(); n != numItems {
+		t.Errorf("expected every checkpoint (%d) to be compacted, got %d compactions", numItems, n)
+	}
+
+	if ids, ok := savedData.([]string); !ok || len(ids) != 1 {
+		t.Errorf("expected saved checkpoint to be compacted to the last ID seen, got %#v", savedData)
+	}
+
+	// the saved checkpoints should be no larger than one with a single ID,
+	// even though the data source's seen-set grew to hundreds of IDs
+	compact, err := marshalGob(checkpoint{
+		Filenames: []string{"test"},
+		ProcOpt:   ProcessingOptions{FlushEvery: &FlushEvery{Items: 1}},
+		Data:      []string{"item000"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := largest.Load(); n == 0 || n > int64(len(compact))+16 {
+		t.Errorf("expected largest checkpoint to be about %d bytes, got %d", len(compact), n)
+	}
+}
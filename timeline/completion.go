@@ -0,0 +1,293 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CompletionOptions configures a pass that completes partially-imported items.
+type CompletionOptions struct {
+	// If set, only items from this data source are completed.
+	DataSourceName string
+
+	// How many times to try completing an item before it is flagged
+	// as failed and no longer tried. Default: 3.
+	MaxAttempts int
+}
+
+// CompletionResult describes the outcome of a completion pass.
+type CompletionResult struct {
+	// Number of items that were filled in.
+	Completed int
+
+	// Number of items that could not be completed this time,
+	// but which will be tried again on the next pass.
+	Retry int
+
+	// Row IDs of items that reached the maximum number of
+	// attempts during this pass, and will not be tried again.
+	Failed []int64
+
+	// Number of items whose data source can't complete items.
+	Unsupported int
+}
+
+// CompleteRetrievalKeyedItems fills in items that were only partially imported, i.e.
+// items with a retrieval key but no content (see ItemsPendingCompletion), by asking
+// their data source for the rest of the item. The completed items are processed like
+// any other import, then their retrieval keys are cleared.
+func (tl *Timeline) CompleteRetrievalKeyedItems(ctx context.Context, opts CompletionOptions) (CompletionResult, error) {
+	if err := tl.checkWritable("complete items"); err != nil {
+		return CompletionResult{}, err
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+
+	var result CompletionResult
+
+	pending, err := tl.itemsToComplete(ctx, opts.DataSourceName)
+	if err != nil {
+		return result, err
+	}
+
+	// items are completed per data source, since each completion is an import
+	byDataSource := make(map[string][]ItemRow)
+	var dsNames []string
+	for _, ir := range pending {
+		if _, ok := byDataSource[*ir.DataSourceName]; !ok {
+			dsNames = append(dsNames, *ir.DataSourceName)
+		}
+		byDataSource[*ir.DataSourceName] = append(byDataSource[*ir.DataSourceName], ir)
+	}
+
+	logger := Log.Named("completion")
+
+	for _, dsName := range dsNames {
+		rows := byDataSource[dsName]
+
+		ds, ok := lookupDataSource(dsName)
+		if !ok || ds.CompleteItem == nil {
+			result.Unsupported += len(rows)
+			continue
+		}
+
+		// ask the data source for the full items
+		var completed []*Item
+		var completedRows []ItemRow
+		for _, ir := range rows {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			it, err := ds.CompleteItem(ctx, ir.RetrievalKey, ir)
+			if err == nil && it == nil {
+				err = fmt.Errorf("item not available from data source")
+			}
+			if err != nil {
+				if err := tl.recordCompletionAttempt(ctx, ir.ID, err, opts.MaxAttempts, &result); err != nil {
+					return result, err
+				}
+				continue
+			}
+			// make sure the processor finds the placeholder
+			if it.ID == "" && ir.OriginalID != nil {
+				it.ID = *ir.OriginalID
+			}
+			it.Retrieval.key = ir.RetrievalKey
+			completed = append(completed, it)
+			completedRows = append(completedRows, ir)
+		}
+		if len(completed) == 0 {
+			continue
+		}
+
+		// process the completed items as an import of their data source
+		untrack, err := tl.trackImport(dsName)
+		if err != nil {
+			return result, err
+		}
+		err = tl.importCompletedItems(ctx, ds, completed)
+		untrack()
+		if err != nil {
+			return result, err
+		}
+
+		// only clear the retrieval keys of items that actually got content
+		for _, ir := range completedRows {
+			done, err := tl.finishCompletion(ctx, ir.ID)
+			if err != nil {
+				return result, err
+			}
+			if done {
+				result.Completed++
+				continue
+			}
+			err = tl.recordCompletionAttempt(ctx, ir.ID, fmt.Errorf("completed item has no content"), opts.MaxAttempts, &result)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	logger.Info("completion pass finished",
+		zap.Int("completed", result.Completed),
+		zap.Int("retry", result.Retry),
+		zap.Int("failed", len(result.Failed)),
+		zap.Int("unsupported", result.Unsupported))
+
+	return result, nil
+}
+
+// importCompletedItems processes the completed items as an import of their data source.
+func (tl *Timeline) importCompletedItems(ctx context.Context, ds DataSource, items []*Item) error {
+	ds.NewFileImporter = nil
+	ds.NewAPIImporter = func() APIImporter { return completionImporter(items) }
+	params := ImportParameters{DataSourceName: ds.Name}
+	impRow, err := tl.newImport(ctx, ds.Name, importModeAPI, params.ProcessingOptions, 0)
+	if err != nil {
+		return fmt.Errorf("creating import row for completed items: %v", err)
+	}
+	if err := tl.doImport(ctx, ds, params, impRow); err != nil {
+		return fmt.Errorf("importing completed items: %w", err)
+	}
+	return nil
+}
+
+// itemsToComplete returns the items pending completion that haven't been flagged as failed.
+func (tl *Timeline) itemsToComplete(ctx context.Context, dataSourceName string) ([]ItemRow, error) {
+	pending, err := tl.ItemsPendingCompletion(ctx, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT item_id FROM item_completions WHERE failed=1`)
+	if err != nil {
+		return nil, fmt.Errorf("querying failed completions: %v", err)
+	}
+	defer rows.Close()
+
+	failed := make(map[int64]bool)
+	for rows.Next() {
+		var itemID int64
+		if err := rows.Scan(&itemID); err != nil {
+			return nil, fmt.Errorf("scanning failed completion: %v", err)
+		}
+		failed[itemID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating failed completions: %v", err)
+	}
+
+	var results []ItemRow
+	for _, ir := range pending {
+		if !failed[ir.ID] {
+			results = append(results, ir)
+		}
+	}
+	return results, nil
+}
+
+// recordCompletionAttempt records a failed attempt to complete an item, and flags
+// the item as failed if it has reached the maximum number of attempts.
+func (tl *Timeline) recordCompletionAttempt(ctx context.Context, itemID int64, attemptErr error, maxAttempts int, result *CompletionResult) error {
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	var failed bool
+	err := tl.db.QueryRowContext(ctx, `
+		INSERT INTO item_completions (item_id, attempts, last_attempt, last_error, failed)
+		VALUES (?, 1, ?, ?, 1 >= ?)
+		ON CONFLICT (item_id) DO UPDATE
+		SET attempts=attempts+1, last_attempt=excluded.last_attempt, last_error=excluded.last_error,
+			failed=attempts+1 >= ?
+		RETURNING failed`,
+		itemID, time.Now().Unix(), attemptErr.Error(), maxAttempts, maxAttempts).Scan(&failed)
+	if err != nil {
+		return fmt.Errorf("recording completion attempt for item %d: %v", itemID, err)
+	}
+
+	if failed {
+		Log.Named("completion").Warn("giving up on completing item",
+			zap.Int64("item_id", itemID),
+			zap.Int("attempts", maxAttempts),
+			zap.Error(attemptErr))
+		result.Failed = append(result.Failed, itemID)
+	} else {
+		result.Retry++
+	}
+
+	return nil
+}
+
+// finishCompletion clears the retrieval key of the item if it now has content,
+// and returns true if so.
+func (tl *Timeline) finishCompletion(ctx context.Context, itemID int64) (bool, error) {
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	res, err := tl.db.ExecContext(ctx, `UPDATE items SET retrieval_key=NULL
+		WHERE id=?
+			AND ((data_text IS NOT NULL AND data_text!='')
+				OR data_file IS NOT NULL
+				OR longitude IS NOT NULL
+				OR latitude IS NOT NULL
+				OR altitude IS NOT NULL)`, itemID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+	if err != nil {
+		return false, fmt.Errorf("clearing retrieval key of item %d: %v", itemID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	_, err = tl.db.ExecContext(ctx, `DELETE FROM item_completions WHERE item_id=?`, itemID)
+	if err != nil {
+		return false, fmt.Errorf("clearing completion attempts of item %d: %v", itemID, err)
+	}
+
+	return true, nil
+}
+
+// completionImporter is an API importer that gives the processor completed items.
+type completionImporter []*Item
+
+func (completionImporter) Authenticate(_ context.Context, _ Account, _ any) error { return nil }
+
+func (ci completionImporter) APIImport(ctx context.Context, _ Account, itemChan chan<- *Graph, _ ListingOptions) error {
+	for _, it := range ci {
+		select {
+		case itemChan <- &Graph{Item: it}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
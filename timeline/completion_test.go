@@ -0,0 +1,128 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompleteRetrievalKeyedItems(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	// a fake completer that can only provide one of the referenced messages
+	var calls int
+	name := fmt.Sprintf("completing_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:            name,
+		Title:           "Completing test",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+		CompleteItem: func(_ context.Context, retrievalKey []byte, ir ItemRow) (*Item, error) {
+			calls++
+			if len(retrievalKey) == 0 || ir.OriginalID == nil {
+				return nil, fmt.Errorf("incomplete placeholder: %+v", ir)
+			}
+			if *ir.OriginalID != "available" {
+				return nil, nil
+			}
+			it := testMessage("", ts.Add(-time.Hour))
+			it.Content.Data = StringData("message available")
+			return it, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("reply", ts), Edges: []Relationship{
+			{Relation: RelReply, To: &Graph{Item: &Item{ID: "available"}}},
+			{Relation: RelQuotes, To: &Graph{Item: &Item{ID: "unavailable"}}},
+		}}
+		return nil
+	}
+	err = tl.Import(ctx, ImportParameters{
+		DataSourceName:    name,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{MissingReferences: MissingReferencesPlaceholder},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	pending, err := tl.ItemsPendingCompletion(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("expected 2 placeholders, got %d", len(pending))
+	}
+
+	opts := CompletionOptions{DataSourceName: name, MaxAttempts: 2}
+
+	result, err := tl.CompleteRetrievalKeyedItems(ctx, opts)
+	if err != nil {
+		t.Fatalf("first pass: %v", err)
+	}
+	if result.Completed != 1 || result.Retry != 1 || len(result.Failed) != 0 {
+		t.Errorf("first pass: unexpected result: %+v", result)
+	}
+
+	completed, err := tl.ItemByOriginalID(ctx, name, 0, "available")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed.DataText == nil || *completed.DataText != "message available" {
+		t.Errorf("expected placeholder to be filled in, got %+v", completed.ItemRow)
+	}
+	if completed.RetrievalKey != nil {
+		t.Error("expected retrieval key of completed item to be cleared")
+	}
+
+	pending, err = tl.ItemsPendingCompletion(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || *pending[0].OriginalID != "unavailable" {
+		t.Fatalf("expected only the unavailable item to be pending, got %+v", pending)
+	}
+
+	// second attempt reaches the limit, so the item is flagged
+	result, err = tl.CompleteRetrievalKeyedItems(ctx, opts)
+	if err != nil {
+		t.Fatalf("second pass: %v", err)
+	}
+	if result.Completed != 0 || result.Retry != 0 || len(result.Failed) != 1 || result.Failed[0] != pending[0].ID {
+		t.Errorf("second pass: unexpected result: %+v", result)
+	}
+
+	// flagged items are not tried again
+	calls = 0
+	result, err = tl.CompleteRetrievalKeyedItems(ctx, opts)
+	if err != nil {
+		t.Fatalf("third pass: %v", err)
+	}
+	if calls != 0 || result.Completed != 0 || result.Retry != 0 || len(result.Failed) != 0 {
+		t.Errorf("third pass: expected nothing to do, got %+v after %d calls", result, calls)
+	}
+}
@@ -381,7 +381,7 @@ func (tl *Timeline) LoadConversation(ctx context.Context, params ItemSearchParam
 	}
 
 	for _, sr := range results {
-		err = tl.expandRelationships(ctx, tx, params.Related, sr)
+		err = tl.expandRelationships(ctx, tx, params.Related, params.Viewer, sr)
 		if err != nil {
 			return SearchResults{}, err
 		}
@@ -0,0 +1,180 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DataFileCompression is a compression format for data files stored in the repo.
+// Data file hashes are always computed over the uncompressed content, so an item's
+// data hash and deduplication are the same whether its file is compressed or not.
+type DataFileCompression string
+
+const (
+	// DataFileCompressionGzip compresses data files with gzip.
+	DataFileCompressionGzip DataFileCompression = "gzip"
+
+	// DataFileCompressionZstd compresses data files with Zstandard.
+	DataFileCompressionZstd DataFileCompression = "zstd"
+)
+
+func (c DataFileCompression) validate() error {
+	switch c {
+	case "", DataFileCompressionGzip, DataFileCompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unrecognized data file compression: %s", c)
+}
+
+// newWriter returns a writer that compresses into w. It must be closed to flush
+// the compressed stream, which does not close w.
+func (c DataFileCompression) newWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case DataFileCompressionGzip:
+		return gzip.NewWriter(w), nil
+	case DataFileCompressionZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unrecognized data file compression: %s", c)
+}
+
+// NewReader returns a reader that decompresses r. Closing it does not close r.
+func (c DataFileCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case DataFileCompressionGzip:
+		return gzip.NewReader(r)
+	case DataFileCompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unrecognized data file compression: %s", c)
+}
+
+// compressibleMediaType returns true if data files of the given media type are worth
+// compressing. Only text-like types are; media such as images, video, audio, and
+// archives are usually compressed already.
+func compressibleMediaType(mediaType string) bool {
+	mediaType, _, _ = mime.ParseMediaType(mediaType)
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	if !strings.HasPrefix(mediaType, "application/") {
+		return false
+	}
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/xml",
+		"application/javascript", "application/x-yaml", "application/yaml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// OpenDataFile opens a data file (as given in the data_file column of an item) for
+// reading. If the data file is stored compressed, it is decompressed transparently.
+func (tl *Timeline) OpenDataFile(ctx context.Context, dataFile string) (io.ReadCloser, error) {
+	compression, err := tl.DataFileCompression(ctx, dataFile)
+	if err != nil {
+		return nil, err
+	}
+	return tl.openDataFile(dataFile, compression)
+}
+
+// DataFileCompression returns how the data file is compressed on disk, or an
+// empty value if it is not compressed.
+func (tl *Timeline) DataFileCompression(ctx context.Context, dataFile string) (DataFileCompression, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+	return dataFileCompression(ctx, tl.db, dataFile)
+}
+
+func dataFileCompression(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, dataFile string) (DataFileCompression, error) {
+	var compression DataFileCompression
+	err := q.QueryRowContext(ctx, `SELECT compression FROM compressed_data_files WHERE data_file=? LIMIT 1`, dataFile).Scan(&compression)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("looking up compression of data file %s: %v", dataFile, err)
+	}
+	return compression, nil
+}
+
+// setDataFileCompression records how the data file is compressed; an
+// empty compression value records that it is not compressed.
+func setDataFileCompression(tx *sql.Tx, dataFile string, compression DataFileCompression) error {
+	if compression == "" {
+		if _, err := tx.Exec(`DELETE FROM compressed_data_files WHERE data_file=?`, dataFile); err != nil {
+			return fmt.Errorf("clearing compression of data file %s: %v", dataFile, err)
+		}
+		return nil
+	}
+	_, err := tx.Exec(`INSERT INTO compressed_data_files (data_file, compression) VALUES (?, ?)
+		ON CONFLICT (data_file) DO UPDATE SET compression=excluded.compression`, dataFile, compression)
+	if err != nil {
+		return fmt.Errorf("recording compression of data file %s: %v", dataFile, err)
+	}
+	return nil
+}
+
+// openDataFile opens the data file, decompressing it with the given compression (if any).
+func (tl *Timeline) openDataFile(dataFile string, compression DataFileCompression) (io.ReadCloser, error) {
+	f, err := os.Open(tl.FullPath(dataFile))
+	if err != nil {
+		return nil, err
+	}
+	if compression == "" {
+		return f, nil
+	}
+	dr, err := compression.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("decompressing data file %s: %v", dataFile, err)
+	}
+	return decompressedDataFile{dr, f}, nil
+}
+
+// decompressedDataFile reads from the decompressor and closes both it and the underlying file.
+type decompressedDataFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (d decompressedDataFile) Close() error {
+	err := d.ReadCloser.Close()
+	if err2 := d.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testJSONItem(id, contents string, ts time.Time) *Item {
+	return &Item{
+		ID:        id,
+		Timestamp: ts,
+		Content: ItemData{
+			Filename:  id + ".json",
+			MediaType: "application/json",
+			Data:      ByteData([]byte(contents)),
+		},
+	}
+}
+
+func readTestDataFile(t *testing.T, tl *Timeline, originalID string) (*SearchResult, string) {
+	t.Helper()
+	ctx := context.Background()
+	item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, originalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.DataFile == nil {
+		t.Fatalf("expected item %s to have a data file", originalID)
+	}
+	rc, err := tl.OpenDataFile(ctx, *item.DataFile)
+	if err != nil {
+		t.Fatalf("opening data file: %v", err)
+	}
+	defer rc.Close()
+	contents, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading data file: %v", err)
+	}
+	return item, string(contents)
+}
+
+func TestDataFileCompressionRoundTrip(t *testing.T) {
+	contents := strings.Repeat(`{"message":"hello, world"}`+"\n", 100)
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, compression := range []DataFileCompression{DataFileCompressionGzip, DataFileCompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			tl := newTestTimeline(t)
+			ctx := context.Background()
+
+			importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: ProcessingOptions{CompressDataFiles: compression}}, testJSONItem("doc", contents, ts), testFileItem("media", ts))
+
+			doc, got := readTestDataFile(t, tl, "doc")
+			if got != contents {
+				t.Errorf("expected decompressed contents to round-trip, got %q", got)
+			}
+			if c, err := tl.DataFileCompression(ctx, *doc.DataFile); err != nil || c != compression {
+				t.Errorf("expected data file to be compressed with %s, got %q (err=%v)", compression, c, err)
+			}
+			onDisk, err := os.ReadFile(tl.FullPath(*doc.DataFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(onDisk) >= len(contents) {
+				t.Errorf("expected data file on disk to be compressed: %d bytes vs. %d uncompressed", len(onDisk), len(contents))
+			}
+
+			// the hash is of the uncompressed content
+			h := newHash()
+			h.Write([]byte(contents))
+			if !bytes.Equal(doc.DataHash, h.Sum(nil)) {
+				t.Errorf("expected data hash of uncompressed contents")
+			}
+
+			// media types that aren't text-like are not compressed
+			media, got := readTestDataFile(t, tl, "media")
+			if got != "binary contents of media" {
+				t.Errorf("unexpected media contents: %q", got)
+			}
+			if c, err := tl.DataFileCompression(ctx, *media.DataFile); err != nil || c != "" {
+				t.Errorf("expected media data file to not be compressed, got %q (err=%v)", c, err)
+			}
+		})
+	}
+}
+
+func TestDataFileCompressionDedup(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	contents := strings.Repeat("the same document, over and over\n", 50)
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	importTestItems(t, tl, testJSONItem("plain", contents, ts))
+	importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: ProcessingOptions{CompressDataFiles: DataFileCompressionZstd}}, testJSONItem("compressed", contents, ts.Add(time.Hour)))
+
+	plain, _ := readTestDataFile(t, tl, "plain")
+	compressed, got := readTestDataFile(t, tl, "compressed")
+	if got != contents {
+		t.Errorf("unexpected contents: %q", got)
+	}
+	if *compressed.DataFile != *plain.DataFile {
+		t.Errorf("expected identical content to share a data file regardless of compression: %s vs. %s", *compressed.DataFile, *plain.DataFile)
+	}
+	if !bytes.Equal(compressed.DataHash, plain.DataHash) {
+		t.Error("expected identical data hashes regardless of compression")
+	}
+	if c, err := tl.DataFileCompression(ctx, *plain.DataFile); err != nil || c != "" {
+		t.Errorf("expected shared data file to remain uncompressed, got %q (err=%v)", c, err)
+	}
+
+	// and the other way around
+	importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: ProcessingOptions{CompressDataFiles: DataFileCompressionGzip}}, testJSONItem("compressed_first", "other "+contents, ts.Add(2*time.Hour)))
+	importTestItems(t, tl, testJSONItem("plain_second", "other "+contents, ts.Add(3*time.Hour)))
+
+	first, _ := readTestDataFile(t, tl, "compressed_first")
+	second, got := readTestDataFile(t, tl, "plain_second")
+	if got != "other "+contents {
+		t.Errorf("unexpected contents: %q", got)
+	}
+	if *second.DataFile != *first.DataFile {
+		t.Errorf("expected identical content to share a data file regardless of compression: %s vs. %s", *second.DataFile, *first.DataFile)
+	}
+}
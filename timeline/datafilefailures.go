@@ -0,0 +1,103 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// DataFileFailurePolicy specifies what to do with an item whose data file could
+// not be written completely, for example because reading the data from the data
+// source failed partway through, or the data never matched its expected checksum.
+//
+// Data files are first written under a temporary name and are only moved into
+// place once they are complete, so regardless of policy, no partial data file is
+// ever left behind, and no item is left pointing to one.
+type DataFileFailurePolicy string
+
+const (
+	// DataFileFailuresRemove removes the item if it was added by the import, so
+	// that it can be imported again later. An item that was already in the
+	// timeline is kept, but without its data file. This is the default.
+	DataFileFailuresRemove DataFileFailurePolicy = "remove"
+
+	// DataFileFailuresKeep keeps the item, but without its data file. (If the
+	// item has no other content, it is deleted along with other empty items at
+	// the end of the import, unless KeepEmptyItems is enabled.)
+	DataFileFailuresKeep DataFileFailurePolicy = "keep"
+)
+
+func (dfp DataFileFailurePolicy) validate() error {
+	switch dfp {
+	case "", DataFileFailuresRemove, DataFileFailuresKeep:
+		return nil
+	}
+	return fmt.Errorf("unrecognized data file failure policy: %s", dfp)
+}
+
+// rollBackDataFile undoes the processing of an item's data file that was not
+// written completely: it closes and deletes the (temporary and placeholder)
+// files, and removes the item row or unlinks it from the data file, per the
+// configured policy. It must be called in the same transaction that finishes
+// the rest of the batch's data files.
+func (p *processor) rollBackDataFile(tx *sql.Tx, it *Item) error {
+	if it.dataFileIn != nil {
+		it.dataFileIn.Close()
+		it.dataFileIn = nil
+	}
+	for _, f := range []*os.File{it.dataFileTemp, it.dataFileOut} {
+		if f == nil {
+			continue
+		}
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("deleting incomplete data file: %v", err)
+		}
+	}
+	it.dataFileTemp, it.dataFileOut = nil, nil
+
+	if it.row.ID == 0 {
+		return nil
+	}
+
+	if it.insertedRow && p.params.ProcessingOptions.DataFileFailures != DataFileFailuresKeep {
+		if _, err := tx.Exec(`DELETE FROM items WHERE id=?`, it.row.ID); err != nil { // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+			return fmt.Errorf("deleting item row %d with incomplete data file: %v", it.row.ID, err)
+		}
+		p.log.Info("removed item whose data file could not be written",
+			zap.Int64("row_id", it.row.ID),
+			zap.String("item_original_id", it.ID))
+		return nil
+	}
+
+	if _, err := tx.Exec(`UPDATE items SET data_file=NULL, data_hash=NULL WHERE id=?`, it.row.ID); err != nil { // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		return fmt.Errorf("unlinking incomplete data file from item row %d: %v", it.row.ID, err)
+	}
+	p.log.Info("kept item without its data file, which could not be written",
+		zap.Int64("row_id", it.row.ID),
+		zap.String("item_original_id", it.ID))
+
+	return nil
+}
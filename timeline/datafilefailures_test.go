@@ -0,0 +1,145 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader gives some data, then fails, like a connection that drops.
+type failingReader struct{ n int }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := min(len(p), r.n)
+	for i := range p[:n] {
+		p[i] = 'x'
+	}
+	r.n -= n
+	return n, nil
+}
+
+func failingFileItem(id string, ts time.Time) *Item {
+	return &Item{
+		ID:             id,
+		Classification: ClassMedia,
+		Timestamp:      ts,
+		Content: ItemData{
+			Filename:  id + ".bin",
+			MediaType: "application/octet-stream",
+			Data: func(context.Context) (io.ReadCloser, error) {
+				return io.NopCloser(&failingReader{n: 64 * 1024}), nil
+			},
+		},
+	}
+}
+
+// dataFolderFiles returns the paths of all files in the data folder, relative to the repo.
+func dataFolderFiles(t *testing.T, tl *Timeline) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(tl.FullPath(DataFolderName), func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			rel, err := filepath.Rel(tl.FullPath(""), fpath)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestDataFileWriteFailureLeavesNoOrphans(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	importTestItems(t, tl,
+		testFileItem("good", ts),
+		failingFileItem("bad", ts.Add(time.Hour)),
+	)
+
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE original_id='bad'`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected item whose data file failed to be removed, but found %d rows", count)
+	}
+
+	var goodFile string
+	if err := tl.db.QueryRow(`SELECT data_file FROM items WHERE original_id='good'`).Scan(&goodFile); err != nil {
+		t.Fatalf("loading item with good data file: %v", err)
+	}
+	files := dataFolderFiles(t, tl)
+	if len(files) != 1 || files[0] != goodFile {
+		t.Errorf("expected only data file %s to remain, got: %v", goodFile, files)
+	}
+}
+
+func TestDataFileWriteFailureKeepsItem(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	lat, lon := 40.0, -111.0
+	it := failingFileItem("bad", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	it.Location = Location{Latitude: &lat, Longitude: &lon}
+	importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: ProcessingOptions{DataFileFailures: DataFileFailuresKeep}}, it)
+
+	var dataFile, dataHash *string
+	err := tl.db.QueryRow(`SELECT data_file, data_hash FROM items WHERE original_id='bad'`).Scan(&dataFile, &dataHash)
+	if err != nil {
+		t.Fatalf("expected item whose data file failed to be kept: %v", err)
+	}
+	if dataFile != nil || dataHash != nil {
+		t.Errorf("expected kept item to have no data file, got data_file=%v data_hash=%v", dataFile, dataHash)
+	}
+	for _, f := range dataFolderFiles(t, tl) {
+		if strings.HasSuffix(f, ".tmp") || strings.Contains(f, "bad") {
+			t.Errorf("expected no files left from failed data file, found %s", f)
+		}
+	}
+}
+
+func TestInvalidDataFileFailurePolicy(t *testing.T) {
+	tl := newTestTimeline(t)
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{DataFileFailures: "bogus"},
+	})
+	if err == nil {
+		t.Error("expected error for unrecognized data file failure policy")
+	}
+}
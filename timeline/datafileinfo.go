@@ -0,0 +1,141 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// ItemDataFileInfo describes the data file of an item, for diagnosing missing
+// or corrupted files and understanding how files are shared by duplicate items.
+type ItemDataFileInfo struct {
+	ItemID int64 `json:"item_id"`
+
+	// The data file, relative to the repo root; empty if the item has no data file.
+	DataFile string `json:"data_file,omitempty"`
+
+	// Whether the file exists, and its size on disk (which is the compressed
+	// size, if it is compressed).
+	Exists bool  `json:"exists"`
+	Size   int64 `json:"size"`
+
+	Compression DataFileCompression `json:"compression,omitempty"`
+
+	// The hash stored in the database and the hash of the file's current
+	// (uncompressed) contents. If the file could not be read, ReadError
+	// explains why and there is no computed hash.
+	StoredHash   []byte `json:"stored_hash,omitempty"`
+	ComputedHash []byte `json:"computed_hash,omitempty"`
+	HashMatches  bool   `json:"hash_matches"`
+	ReadError    string `json:"read_error,omitempty"`
+
+	// The number of other items that refer to the same data file.
+	SharedWith int `json:"shared_with"`
+}
+
+// ItemDataFileInfo returns information about the data file of the item with the given
+// row ID. The file is read in full to recompute its hash, so this can be slow for large
+// files. If there is no such item, an error wrapping ErrItemNotFound is returned.
+func (tl *Timeline) ItemDataFileInfo(ctx context.Context, itemID int64) (ItemDataFileInfo, error) {
+	info := ItemDataFileInfo{ItemID: itemID}
+
+	var dataFile *string
+	var refs int
+	tl.dbMu.RLock()
+	err := tl.db.QueryRowContext(ctx, `SELECT data_file, data_hash FROM items WHERE id=? LIMIT 1`,
+		itemID).Scan(&dataFile, &info.StoredHash)
+	if err == nil && dataFile != nil {
+		_, refs, err = dataFileReferences(ctx, tl.db, itemID)
+		if err == nil {
+			info.Compression, err = dataFileCompression(ctx, tl.db, *dataFile)
+		}
+	}
+	tl.dbMu.RUnlock()
+	if errors.Is(err, sql.ErrNoRows) {
+		return info, fmt.Errorf("item %d: %w", itemID, ErrItemNotFound)
+	}
+	if err != nil {
+		return info, fmt.Errorf("loading data file of item %d: %v", itemID, err)
+	}
+	if dataFile == nil || *dataFile == "" {
+		return info, nil
+	}
+	info.DataFile = *dataFile
+	info.SharedWith = refs - 1
+
+	stat, err := os.Stat(tl.FullPath(info.DataFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return info, nil
+	}
+	if err != nil {
+		info.ReadError = err.Error()
+		return info, nil
+	}
+	info.Exists = true
+	info.Size = stat.Size()
+
+	info.ComputedHash, err = tl.hashDataFile(info.DataFile, info.Compression)
+	if err != nil {
+		info.ReadError = err.Error()
+		return info, nil
+	}
+	info.HashMatches = bytes.Equal(info.StoredHash, info.ComputedHash)
+
+	return info, nil
+}
+
+// hashDataFile returns the hash of the (uncompressed) contents of the data file.
+func (tl *Timeline) hashDataFile(dataFile string, compression DataFileCompression) ([]byte, error) {
+	rc, err := tl.openDataFile(dataFile, compression)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	h := newHash()
+	if _, err := io.Copy(h, rc); err != nil {
+		return nil, fmt.Errorf("reading data file %s: %v", dataFile, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// dataFileReferences returns the data file of the item with the given row ID, and
+// the number of items that refer to that file (including this one). If the item has
+// no data file, the returned data file is nil and the count is 0.
+func dataFileReferences(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, rowID int64) (*string, int, error) {
+	var count int
+	var dataFile *string
+	err := q.QueryRowContext(ctx, `SELECT count(), data_file FROM items
+		WHERE data_file = (SELECT data_file FROM items
+							WHERE id=? AND data_file IS NOT NULL
+							AND data_file != "" LIMIT 1)`,
+		rowID).Scan(&count, &dataFile)
+	if err != nil {
+		return nil, 0, fmt.Errorf("querying count of rows sharing data file: %v", err)
+	}
+	return dataFile, count, nil
+}
@@ -0,0 +1,96 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestItemDataFileInfo(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	// two items with the same contents share a data file
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	first, second := testFileItem("first", ts), testFileItem("second", ts.Add(time.Hour))
+	second.Content.Data = first.Content.Data
+	importTestItems(t, tl, first, second, testMessage("text", ts))
+
+	item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := tl.ItemDataFileInfo(ctx, item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.DataFile == "" || !info.Exists || info.Size != int64(len("binary contents of first")) {
+		t.Errorf("expected existing data file of the right size, got %+v", info)
+	}
+	if !info.HashMatches || len(info.ComputedHash) == 0 {
+		t.Errorf("expected computed hash to match stored hash, got %+v", info)
+	}
+	if info.SharedWith != 1 {
+		t.Errorf("expected data file to be shared with 1 other item, got %d", info.SharedWith)
+	}
+
+	// a corrupted file no longer matches its hash
+	if err := os.WriteFile(tl.FullPath(info.DataFile), []byte("corrupted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err = tl.ItemDataFileInfo(ctx, item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.HashMatches {
+		t.Error("expected hash of corrupted file not to match")
+	}
+
+	// a missing file is reported as such
+	if err := os.Remove(tl.FullPath(info.DataFile)); err != nil {
+		t.Fatal(err)
+	}
+	info, err = tl.ItemDataFileInfo(ctx, item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Exists || info.ComputedHash != nil {
+		t.Errorf("expected missing file to be reported, got %+v", info)
+	}
+
+	// items without a data file have nothing to report
+	text, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = tl.ItemDataFileInfo(ctx, text.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.DataFile != "" || info.SharedWith != 0 {
+		t.Errorf("expected no data file, got %+v", info)
+	}
+
+	if _, err := tl.ItemDataFileInfo(ctx, 99999); err == nil {
+		t.Error("expected error for nonexistent item")
+	}
+}
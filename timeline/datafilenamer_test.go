@@ -0,0 +1,88 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDataFileNamer(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	dup := testFileItem("c", ts.Add(2*time.Hour))
+	dup.Content.Data = ByteData([]byte("binary contents of a"))
+
+	testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testFileItem("a", ts)}
+		itemChan <- &Graph{Item: testFileItem("b", ts.Add(time.Hour))}
+		itemChan <- &Graph{Item: dup}
+		return nil
+	}
+	var defaultNames []string
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		DataFileNamer: func(it *Item, defaultName string) string {
+			defaultNames = append(defaultNames, defaultName)
+			return it.Timestamp.Format("2006-01-02") + " photo?" + path.Ext(defaultName)
+		},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if !slices.Contains(defaultNames, "a.bin") {
+		t.Errorf("expected namer to be given the default name, got %v", defaultNames)
+	}
+
+	dataFiles := make(map[string]string)
+	for _, id := range []string{"a", "b", "c"} {
+		var dataFile string
+		if err := tl.db.QueryRow(`SELECT data_file FROM items WHERE original_id=?`, id).Scan(&dataFile); err != nil {
+			t.Fatalf("querying data file of item %s: %v", id, err)
+		}
+		if _, err := os.Stat(tl.FullPath(dataFile)); err != nil {
+			t.Errorf("data file of item %s: %v", id, err)
+		}
+		dataFiles[id] = dataFile
+	}
+
+	// items are processed concurrently, so any of them could get the name first
+	sanitized := regexp.MustCompile(`^2021-05-01photo(__\w{4})?\.bin$`)
+	for id, dataFile := range dataFiles {
+		if !sanitized.MatchString(path.Base(dataFile)) {
+			t.Errorf("expected sanitized date-based name for item %s, got %s", id, dataFile)
+		}
+	}
+	if dataFiles["a"] == dataFiles["b"] {
+		t.Errorf("expected colliding names to be made unique, got %s for both", dataFiles["a"])
+	}
+	if dataFiles["c"] != dataFiles["a"] {
+		t.Errorf("expected identical contents to share a data file regardless of name, got %s and %s", dataFiles["a"], dataFiles["c"])
+	}
+	if _, got := readTestDataFile(t, tl, "b"); got != "binary contents of b" {
+		t.Errorf("unexpected contents of renamed data file: %q", got)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// DataFilePostProcessor makes a derived form of a data file after it is written,
+// for example to convert a HEIC image to JPEG so it can be displayed (and given
+// a thumbnail) more widely. The derived file is stored alongside the original,
+// which is always kept as-is. Post-processors are given by the import parameters;
+// see ImportParameters.DataFilePostProcessors.
+type DataFilePostProcessor interface {
+	// Derives returns the media type of the file it makes from data files of
+	// the given media type, or an empty string if it doesn't apply to them.
+	Derives(mediaType string) string
+
+	// PostProcessDataFile reads the (uncompressed) contents of the data file
+	// from r and writes the derived file to w.
+	PostProcessDataFile(ctx context.Context, r io.Reader, w io.Writer) error
+}
+
+// DerivedDataFile is a file that was derived from a data file by a DataFilePostProcessor.
+type DerivedDataFile struct {
+	DataFile  string `json:"data_file"`  // the original data file, relative to the repo root
+	Filename  string `json:"filename"`   // the derived file, relative to the repo root
+	MediaType string `json:"media_type"` // media type of the derived file
+}
+
+// postProcessDataFile runs the import's post-processors on the item's data file,
+// which must be completely written. Post-processing is best-effort: if it fails,
+// the error is logged, any partial file is removed, and the item is imported
+// without the derived file.
+func (p *processor) postProcessDataFile(ctx context.Context, it *Item) {
+	// files referenced in place aren't in the repo, so we have nowhere to put derived files
+	if len(p.params.DataFilePostProcessors) == 0 || it.dataFileSize == 0 || isExternalDataFile(it.dataFileName) {
+		return
+	}
+	for _, pp := range p.params.DataFilePostProcessors {
+		mediaType := pp.Derives(it.Content.MediaType)
+		if mediaType == "" || it.hasDerivedFile(mediaType) {
+			continue
+		}
+		derived, err := p.writeDerivedFile(ctx, it, pp, mediaType)
+		if err != nil {
+			p.log.Warn("post-processing data file failed; importing item without derived file",
+				zap.String("item_original_id", it.ID),
+				zap.String("data_file_name", it.dataFileName),
+				zap.String("derived_media_type", mediaType),
+				zap.Error(err))
+			continue
+		}
+		it.derivedFiles = append(it.derivedFiles, derived)
+	}
+}
+
+func (p *processor) writeDerivedFile(ctx context.Context, it *Item, pp DataFilePostProcessor, mediaType string) (DerivedDataFile, error) {
+	derived := DerivedDataFile{
+		DataFile:  it.dataFileName,
+		Filename:  it.dataFileName + derivedFileExt(mediaType),
+		MediaType: mediaType,
+	}
+
+	in, err := p.tl.openDataFile(it.dataFileName, it.dataFileCompression)
+	if err != nil {
+		return derived, fmt.Errorf("opening data file: %v", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(p.tl.FullPath(derived.Filename), os.O_CREATE|os.O_RDWR|os.O_EXCL, 0600)
+	if err != nil {
+		return derived, fmt.Errorf("creating derived file: %v", err)
+	}
+	err = pp.PostProcessDataFile(ctx, in, out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return derived, err
+	}
+	return derived, nil
+}
+
+func (it *Item) hasDerivedFile(mediaType string) bool {
+	for _, derived := range it.derivedFiles {
+		if derived.MediaType == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// derivedFileExt returns the extension to append to a data file's name to
+// name the file derived from it that has the given media type.
+func derivedFileExt(mediaType string) string {
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".derived"
+}
+
+// recordDerivedFiles records the item's derived files as belonging to its data file,
+// which may be an existing identical file rather than the one that was written, in
+// which case derived files that the existing file already has are removed.
+func (p *processor) recordDerivedFiles(tx *sql.Tx, it *Item) error {
+	for _, derived := range it.derivedFiles {
+		var count int
+		err := tx.QueryRow(`SELECT count() FROM derived_data_files WHERE data_file=? AND media_type=? LIMIT 1`,
+			it.dataFileName, derived.MediaType).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("checking for existing derived file: %v", err)
+		}
+		if count > 0 {
+			if err := os.Remove(p.tl.FullPath(derived.Filename)); err != nil {
+				return fmt.Errorf("deleting duplicate derived file: %v", err)
+			}
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO derived_data_files (data_file, derived_file, media_type) VALUES (?, ?, ?)`,
+			it.dataFileName, derived.Filename, derived.MediaType)
+		if err != nil {
+			return fmt.Errorf("recording derived file %s: %v", derived.Filename, err)
+		}
+	}
+	return nil
+}
+
+// removeDerivedFiles deletes the derived files of an item that is not being kept.
+func (it *Item) removeDerivedFiles(tl *Timeline) error {
+	for _, derived := range it.derivedFiles {
+		if err := os.Remove(tl.FullPath(derived.Filename)); err != nil {
+			return fmt.Errorf("deleting derived file: %v", err)
+		}
+	}
+	it.derivedFiles = nil
+	return nil
+}
+
+// DerivedDataFiles returns the files derived from the given data file (as given
+// in the data_file column of an item), if any.
+func (tl *Timeline) DerivedDataFiles(ctx context.Context, dataFile string) ([]DerivedDataFile, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT derived_file, media_type FROM derived_data_files WHERE data_file=? ORDER BY derived_file`, dataFile)
+	if err != nil {
+		return nil, fmt.Errorf("querying derived files of %s: %v", dataFile, err)
+	}
+	defer rows.Close()
+
+	var all []DerivedDataFile
+	for rows.Next() {
+		derived := DerivedDataFile{DataFile: dataFile}
+		if err := rows.Scan(&derived.Filename, &derived.MediaType); err != nil {
+			return nil, fmt.Errorf("scanning derived file: %v", err)
+		}
+		all = append(all, derived)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating derived files: %v", err)
+	}
+	return all, nil
+}
+
+// deleteDerivedDataFiles deletes the files derived from the data file and forgets
+// them. It is used when the data file itself is deleted.
+func (tl *Timeline) deleteDerivedDataFiles(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}, dataFile string) error {
+	rows, err := q.QueryContext(ctx, `SELECT derived_file FROM derived_data_files WHERE data_file=?`, dataFile)
+	if err != nil {
+		return fmt.Errorf("querying derived files of %s: %v", dataFile, err)
+	}
+	var derivedFiles []string
+	for rows.Next() {
+		var derivedFile string
+		if err := rows.Scan(&derivedFile); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning derived file: %v", err)
+		}
+		derivedFiles = append(derivedFiles, derivedFile)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating derived files: %v", err)
+	}
+
+	for _, derivedFile := range derivedFiles {
+		if err := os.Remove(tl.FullPath(derivedFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("deleting derived file: %v", err)
+		}
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM derived_data_files WHERE data_file=?`, dataFile); err != nil {
+		return fmt.Errorf("forgetting derived files of %s: %v", dataFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// upperCaser is a fake converter that "converts" binary files to upper-cased text.
+type upperCaser struct{ fail bool }
+
+func (upperCaser) Derives(mediaType string) string {
+	if mediaType == "application/octet-stream" {
+		return "text/plain"
+	}
+	return ""
+}
+
+func (u upperCaser) PostProcessDataFile(_ context.Context, r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(bytes.ToUpper(data)); err != nil {
+		return err
+	}
+	if u.fail {
+		return errors.New("unsupported variant of format")
+	}
+	return nil
+}
+
+func TestDataFilePostProcessor(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	importTestItemsWithParams(t, tl, ImportParameters{DataFilePostProcessors: []DataFilePostProcessor{upperCaser{}}},
+		testFileItem("photo", ts),
+		testTextItem("note", "not converted", ts.Add(time.Hour)),
+	)
+
+	var dataFile string
+	if err := tl.db.QueryRow(`SELECT data_file FROM items WHERE original_id='photo'`).Scan(&dataFile); err != nil {
+		t.Fatal(err)
+	}
+	derived, err := tl.DerivedDataFiles(context.Background(), dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(derived) != 1 {
+		t.Fatalf("expected 1 derived file, got %d: %+v", len(derived), derived)
+	}
+	if derived[0].MediaType != "text/plain" || derived[0].DataFile != dataFile {
+		t.Errorf("unexpected derived file: %+v", derived[0])
+	}
+
+	// the original is kept as-is, and the derived file is stored next to it
+	original, err := os.ReadFile(tl.FullPath(dataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(original) != "binary contents of photo" {
+		t.Errorf("original data file was modified: %q", original)
+	}
+	converted, err := os.ReadFile(tl.FullPath(derived[0].Filename))
+	if err != nil {
+		t.Fatalf("reading derived file: %v", err)
+	}
+	if string(converted) != "BINARY CONTENTS OF PHOTO" {
+		t.Errorf("unexpected derived file contents: %q", converted)
+	}
+
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM derived_data_files`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected only the applicable item to be post-processed, but %d derived files were recorded", count)
+	}
+}
+
+func TestDataFilePostProcessorFailsSoft(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	importTestItemsWithParams(t, tl, ImportParameters{DataFilePostProcessors: []DataFilePostProcessor{upperCaser{fail: true}}},
+		testFileItem("photo", time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)))
+
+	var dataFile string
+	if err := tl.db.QueryRow(`SELECT data_file FROM items WHERE original_id='photo'`).Scan(&dataFile); err != nil {
+		t.Fatalf("expected item to be imported despite failed post-processing: %v", err)
+	}
+	derived, err := tl.DerivedDataFiles(context.Background(), dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(derived) != 0 {
+		t.Errorf("expected no derived files, got: %+v", derived)
+	}
+	files := dataFolderFiles(t, tl)
+	if len(files) != 1 || files[0] != dataFile {
+		t.Errorf("expected only data file %s with no partial derived file, got: %v", dataFile, files)
+	}
+}
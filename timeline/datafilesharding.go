@@ -0,0 +1,353 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Data files can be sharded into subfolders named after a prefix of the hash
+// of the file's name, so that no single folder ends up with too many entries.
+// With 2 shard levels, a data file IMG_1234.jpg whose name hashes to "abcd..."
+// is stored at data/2024/05/data_source/ab/cd/IMG_1234.jpg. The number of
+// levels is a repo setting; changing it with ReshardDataFiles moves existing
+// data files to match.
+
+// MaxDataFileShardLevels is the maximum number of shard folders per data file.
+const MaxDataFileShardLevels = 4
+
+// repoKeyDataFileShardLevels is the key in the repo table for the shard setting.
+const repoKeyDataFileShardLevels = "data_file_shard_levels"
+
+// reshardChunkSize is how many item rows are loaded at a time when resharding.
+const reshardChunkSize = 1000
+
+// DataFileShardLevels returns how many levels of shard folders new data files are placed in.
+// 0 means no sharding.
+func (tl *Timeline) DataFileShardLevels() int {
+	return int(tl.dataFileShardLevels.Load())
+}
+
+func loadDataFileShardLevels(db *sql.DB) (int, error) {
+	var levels int
+	err := db.QueryRow(`SELECT value FROM repo WHERE key=? LIMIT 1`, repoKeyDataFileShardLevels).Scan(&levels)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("loading data file shard levels: %w", err)
+	}
+	if levels < 0 || levels > MaxDataFileShardLevels {
+		return 0, fmt.Errorf("invalid data file shard levels in repo: %d", levels)
+	}
+	return levels, nil
+}
+
+// dataFileShards returns the shard folder names for the given data file name.
+// The name is lower-cased before hashing because data_file is compared
+// case-insensitively, and the repo may live on a case-insensitive file system.
+func dataFileShards(filename string, levels int) []string {
+	if levels <= 0 {
+		return nil
+	}
+	h := newHash()
+	_, _ = h.Write([]byte(strings.ToLower(filename)))
+	sum := hex.EncodeToString(h.Sum(nil))
+	shards := make([]string, levels)
+	for i := range shards {
+		shards[i] = sum[i*2 : i*2+2]
+	}
+	return shards
+}
+
+// shardedDataFileDir returns the folder within dir where the data file with the
+// given name belongs when using the given number of shard levels.
+func shardedDataFileDir(dir, filename string, levels int) string {
+	return path.Join(append([]string{dir}, dataFileShards(filename, levels)...)...)
+}
+
+// unshardedDataFileDir strips the shard folders (of any level) of the
+// given data file name from the end of dir.
+func unshardedDataFileDir(dir, filename string) string {
+	shards := dataFileShards(filename, MaxDataFileShardLevels)
+	for n := len(shards); n > 0; n-- {
+		if suffix := "/" + path.Join(shards[:n]...); strings.HasSuffix(dir, suffix) {
+			return strings.TrimSuffix(dir, suffix)
+		}
+	}
+	return dir
+}
+
+// ReshardResult describes what was done by resharding data files.
+type ReshardResult struct {
+	// Number of data files moved into the new layout.
+	Moved int
+
+	// Number of data files that are in the DB but missing on disk
+	// (they are left alone).
+	Missing int
+}
+
+// ReshardDataFiles changes the number of shard levels for data files in the repo
+// and moves existing data files (for example, from a flat layout) to match. Use
+// 0 levels to go back to the flat layout. If ctx is canceled, the data files
+// moved so far stay moved and it can be run again to finish. It refuses to run
+// while imports are running, and imports can't be started while it runs.
+func (tl *Timeline) ReshardDataFiles(ctx context.Context, levels int) (ReshardResult, error) {
+	if err := tl.checkWritable("reshard data files"); err != nil {
+		return ReshardResult{}, err
+	}
+
+	var result ReshardResult
+
+	if levels < 0 || levels > MaxDataFileShardLevels {
+		return result, fmt.Errorf("shard levels must be between 0 and %d: %d", MaxDataFileShardLevels, levels)
+	}
+
+	if err := tl.beginReshard(); err != nil {
+		return result, err
+	}
+	defer tl.endReshard()
+
+	logger := Log.Named("reshard").With(zap.Int("levels", levels))
+
+	// save the setting first so that, if this gets interrupted, running it again picks up where it left off
+	tl.dbMu.Lock()
+	_, err := tl.db.ExecContext(ctx, `INSERT INTO repo (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value=excluded.value`, repoKeyDataFileShardLevels, levels)
+	tl.dbMu.Unlock()
+	if err != nil {
+		return result, fmt.Errorf("saving data file shard levels: %v", err)
+	}
+	tl.dataFileShardLevels.Store(int32(levels))
+
+	var lastRowID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		rowIDs, dataFiles, err := tl.reshardChunk(ctx, lastRowID)
+		if err != nil {
+			return result, err
+		}
+		if len(rowIDs) == 0 {
+			break
+		}
+		lastRowID = rowIDs[len(rowIDs)-1]
+
+		// multiple items can share a data file; it only needs to be moved once
+		moved := make(map[string]bool)
+
+		for _, dataFile := range dataFiles {
+			if moved[dataFile] || isExternalDataFile(dataFile) {
+				continue
+			}
+			name := path.Base(dataFile)
+			baseDir := unshardedDataFileDir(path.Dir(dataFile), name)
+			if path.Join(shardedDataFileDir(baseDir, name, levels), name) == dataFile {
+				continue
+			}
+
+			newDataFile, err := tl.moveDataFile(ctx, logger, dataFile, baseDir, levels)
+			if errors.Is(err, fs.ErrNotExist) {
+				logger.Warn("data file is missing; not moving", zap.String("data_file", dataFile))
+				result.Missing++
+				continue
+			}
+			if err != nil {
+				return result, err
+			}
+			moved[dataFile] = true
+			result.Moved++
+
+			logger.Debug("moved data file",
+				zap.String("from", dataFile),
+				zap.String("to", newDataFile))
+		}
+	}
+
+	logger.Info("resharded data files",
+		zap.Int("moved", result.Moved),
+		zap.Int("missing", result.Missing))
+
+	return result, nil
+}
+
+// reshardChunk returns the next chunk of item row IDs after lastRowID that have a data file, with their data files.
+func (tl *Timeline) reshardChunk(ctx context.Context, lastRowID int64) ([]int64, []string, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx,
+		`SELECT id, data_file FROM items
+		WHERE id > ? AND data_file IS NOT NULL AND data_file != ''
+		ORDER BY id
+		LIMIT ?`, lastRowID, reshardChunkSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("querying items with data files: %v", err)
+	}
+	defer rows.Close()
+
+	var rowIDs []int64
+	var dataFiles []string
+	for rows.Next() {
+		var rowID int64
+		var dataFile string
+		if err := rows.Scan(&rowID, &dataFile); err != nil {
+			return nil, nil, fmt.Errorf("scanning item row: %v", err)
+		}
+		rowIDs = append(rowIDs, rowID)
+		dataFiles = append(dataFiles, dataFile)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return rowIDs, dataFiles, nil
+}
+
+// moveDataFile moves the data file to its place under baseDir with the given number of
+// shard levels, and updates all references to it in the DB. If the name is already taken
+// there, the name is made unique the same way as when data files are first written.
+// It returns the new data file path.
+func (tl *Timeline) moveDataFile(ctx context.Context, logger *zap.Logger, dataFile, baseDir string, levels int) (string, error) {
+	if _, err := os.Stat(tl.FullPath(dataFile)); err != nil {
+		return "", err
+	}
+
+	nameExt := path.Ext(dataFile)
+	nameWithoutExt := strings.TrimSuffix(path.Base(dataFile), nameExt)
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	for i := 0; i < 10; i++ {
+		tryName := nameWithoutExt
+		if i > 0 {
+			tryName += fmt.Sprintf("__%s", safeRandomString(4, true, nil)) // same case == true for portability to case-insensitive file systems
+		}
+		tryName += nameExt
+
+		tryDir := shardedDataFileDir(baseDir, tryName, levels)
+		tryPath := path.Join(tryDir, tryName)
+
+		// don't clobber a file on disk or a path that another item has claim to
+		if _, err := os.Lstat(tl.FullPath(tryPath)); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		var count int
+		err := tl.db.QueryRowContext(ctx, `SELECT count() FROM items WHERE data_file=? LIMIT 1`, tryPath).Scan(&count)
+		if err != nil {
+			return "", fmt.Errorf("checking DB for file uniqueness: %v", err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		if err := os.MkdirAll(tl.FullPath(tryDir), 0700); err != nil {
+			return "", fmt.Errorf("making directory for data file: %v", err)
+		}
+		if err := os.Rename(tl.FullPath(dataFile), tl.FullPath(tryPath)); err != nil {
+			return "", fmt.Errorf("moving data file %s to %s: %v", dataFile, tryPath, err)
+		}
+
+		if err := tl.updateDataFilePath(ctx, dataFile, tryPath); err != nil {
+			// put the file back so the DB still points to it
+			if err2 := os.Rename(tl.FullPath(tryPath), tl.FullPath(dataFile)); err2 != nil {
+				logger.Error("could not move data file back after failing to update DB",
+					zap.String("data_file", dataFile),
+					zap.String("moved_to", tryPath),
+					zap.Error(err2))
+			}
+			return "", err
+		}
+
+		tl.removeEmptyDataFileDirs(logger, tl.FullPath(dataFile))
+
+		return tryPath, nil
+	}
+
+	return "", fmt.Errorf("unable to find available path for data file: %s", dataFile)
+}
+
+// updateDataFilePath changes all references to a data file in the DB. The caller must hold a lock on dbMu.
+func (tl *Timeline) updateDataFilePath(ctx context.Context, oldDataFile, newDataFile string) error {
+	tx, err := tl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE items SET data_file=? WHERE data_file=?`, newDataFile, oldDataFile); err != nil {
+		return fmt.Errorf("updating items with data file %s: %v", oldDataFile, err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE item_versions SET data_file=? WHERE data_file=?`, newDataFile, oldDataFile); err != nil {
+		return fmt.Errorf("updating item versions with data file %s: %v", oldDataFile, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM compressed_data_files WHERE data_file=?`, newDataFile); err != nil {
+		return fmt.Errorf("clearing stale compression of data file %s: %v", newDataFile, err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE compressed_data_files SET data_file=? WHERE data_file=?`, newDataFile, oldDataFile); err != nil {
+		return fmt.Errorf("updating compression of data file %s: %v", oldDataFile, err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE derived_data_files SET data_file=? WHERE data_file=?`, newDataFile, oldDataFile); err != nil {
+		return fmt.Errorf("updating derived files of data file %s: %v", oldDataFile, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+	return nil
+}
+
+func (tl *Timeline) beginReshard() error {
+	tl.importJobsMu.Lock()
+	defer tl.importJobsMu.Unlock()
+	if n := len(tl.activeImports); n > 0 {
+		return fmt.Errorf("cannot reshard data files while imports from %d data source(s) are running", n)
+	}
+	if len(tl.purging) > 0 {
+		return fmt.Errorf("cannot reshard data files while a data source is being purged")
+	}
+	if tl.resharding {
+		return fmt.Errorf("data files are already being resharded")
+	}
+	if tl.reindexing {
+		return fmt.Errorf("cannot reshard data files while the timeline is being reindexed")
+	}
+	tl.resharding = true
+	return nil
+}
+
+func (tl *Timeline) endReshard() {
+	tl.importJobsMu.Lock()
+	tl.resharding = false
+	tl.importJobsMu.Unlock()
+}
@@ -0,0 +1,113 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestDataFileSharding(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	readDataFile := func(originalID string) string {
+		t.Helper()
+		item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, originalID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.DataFile == nil {
+			t.Fatalf("expected item %s to have a data file", originalID)
+		}
+		contents, err := os.ReadFile(tl.FullPath(*item.DataFile))
+		if err != nil {
+			t.Fatalf("reading data file: %v", err)
+		}
+		if want := "binary contents of " + originalID; string(contents) != want {
+			t.Errorf("expected data file contents %q, got %q", want, contents)
+		}
+		return *item.DataFile
+	}
+	assertSharded := func(dataFile string, levels int) {
+		t.Helper()
+		name := path.Base(dataFile)
+		want := path.Join(shardedDataFileDir(tl.canonicalItemDataFileDir(&Item{Timestamp: ts}, testDataSourceName), name, levels), name)
+		if dataFile != want {
+			t.Errorf("expected data file at %s, got %s", want, dataFile)
+		}
+	}
+
+	// start with the flat layout, then migrate it
+	importTestItems(t, tl, testFileItem("flat", ts))
+	flat := readDataFile("flat")
+	assertSharded(flat, 0)
+
+	result, err := tl.ReshardDataFiles(ctx, 2)
+	if err != nil {
+		t.Fatalf("resharding: %v", err)
+	}
+	if result.Moved != 1 || result.Missing != 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if FileExists(tl.FullPath(flat)) {
+		t.Error("expected data file to be moved out of the flat layout")
+	}
+	assertSharded(readDataFile("flat"), 2)
+
+	// new data files are written with the sharded layout
+	importTestItems(t, tl, testFileItem("sharded", ts))
+	sharded := readDataFile("sharded")
+	assertSharded(sharded, 2)
+
+	// deleting the file also cleans up its now-empty shard folders
+	if _, err := tl.deleteDataFiles(ctx, zap.NewNop(), []string{sharded}); err != nil {
+		t.Fatal(err)
+	}
+	if FileExists(tl.FullPath(sharded)) {
+		t.Error("expected data file to be deleted")
+	}
+	if FileExists(tl.FullPath(path.Dir(sharded))) {
+		t.Error("expected empty shard folder to be deleted")
+	}
+	if !FileExists(tl.FullPath(DataFolderName)) {
+		t.Error("expected data folder to remain")
+	}
+
+	// the setting persists with the repo
+	levels, err := loadDataFileShardLevels(tl.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels != 2 {
+		t.Errorf("expected 2 shard levels to be saved, got %d", levels)
+	}
+
+	// and the layout can be flattened again
+	if _, err := tl.ReshardDataFiles(ctx, 0); err != nil {
+		t.Fatalf("flattening: %v", err)
+	}
+	assertSharded(readDataFile("flat"), 0)
+}
@@ -0,0 +1,110 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy specifies how to store an item's data file when the file
+// it comes from (ItemData.LocalPath) is a symbolic link to a file outside
+// the repository.
+type SymlinkPolicy string
+
+const (
+	// SymlinksCopy copies the content of the link target into the repo,
+	// like any other data file. This is the default.
+	SymlinksCopy SymlinkPolicy = "copy"
+
+	// SymlinksReference stores the absolute path of the link target as the
+	// item's data file instead of copying it into the repo. This saves space,
+	// but the repo is no longer self-contained: the file is not backed up or
+	// moved along with the repo, and the item loses its data if the file is
+	// moved, changed, or deleted. (Integrity checks will detect changes.)
+	// Timelinize never modifies or deletes referenced files.
+	SymlinksReference SymlinkPolicy = "reference"
+
+	// SymlinksReject fails processing of items whose data file is a symlink
+	// to a file outside the repo.
+	SymlinksReject SymlinkPolicy = "reject"
+)
+
+func (sp SymlinkPolicy) validate() error {
+	switch sp {
+	case "", SymlinksCopy, SymlinksReference, SymlinksReject:
+		return nil
+	}
+	return fmt.Errorf("unrecognized symlink policy: %s", sp)
+}
+
+// externalDataFile applies the symlink policy to the item's data file. If the
+// item's content should be referenced in place, it returns the absolute path of
+// the file to reference; if it should be copied into the repo as usual, it
+// returns an empty string.
+func (p *processor) externalDataFile(it *Item) (string, error) {
+	policy := p.params.ProcessingOptions.SymlinkPolicy
+	if policy == "" || policy == SymlinksCopy || it.Content.LocalPath == "" {
+		return "", nil
+	}
+	info, err := os.Lstat(it.Content.LocalPath)
+	if err != nil {
+		return "", fmt.Errorf("checking data file: %v", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "", nil
+	}
+	target, err := filepath.EvalSymlinks(it.Content.LocalPath)
+	if err != nil {
+		return "", fmt.Errorf("resolving symlinked data file: %v", err)
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return "", fmt.Errorf("resolving symlinked data file: %v", err)
+	}
+	if p.tl.withinRepo(target) {
+		return "", nil
+	}
+	if policy == SymlinksReject {
+		return "", fmt.Errorf("data file %s is a symlink to %s, which is outside the repo", it.Content.LocalPath, target)
+	}
+	return target, nil
+}
+
+// withinRepo returns true if the absolute path is inside the repo folder.
+func (t *Timeline) withinRepo(absPath string) bool {
+	repoDir, err := filepath.EvalSymlinks(t.repoDir)
+	if err != nil {
+		repoDir = t.repoDir
+	}
+	if repoDir, err = filepath.Abs(repoDir); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(repoDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isExternalDataFile returns true if the data file (as given in the data_file
+// column of an item) is referenced in place outside the repo, rather than being
+// stored in it. Such files must not be moved, rewritten, or deleted.
+func isExternalDataFile(dataFile string) bool {
+	return filepath.IsAbs(filepath.FromSlash(dataFile))
+}
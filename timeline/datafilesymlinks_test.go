@@ -0,0 +1,175 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSymlinkPolicy(t *testing.T) {
+	ts := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// the data file is a symlink to a file outside the repo
+	externalDir, linkDir := t.TempDir(), t.TempDir()
+	target := filepath.Join(externalDir, "photo.bin")
+	if err := os.WriteFile(target, []byte("external contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(linkDir, "photo.bin")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("cannot create symlink: %v", err)
+	}
+	resolvedTarget, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symlinkedItem := func() *Item {
+		return &Item{
+			ID:             "photo",
+			Classification: ClassMedia,
+			Timestamp:      ts,
+			Content: ItemData{
+				Filename:  "photo.bin",
+				MediaType: "application/octet-stream",
+				LocalPath: link,
+				Data: func(context.Context) (io.ReadCloser, error) {
+					return os.Open(link)
+				},
+			},
+		}
+	}
+
+	for _, policy := range []SymlinkPolicy{"", SymlinksCopy, SymlinksReference, SymlinksReject} {
+		tl := newTestTimeline(t)
+		ctx := context.Background()
+
+		it := symlinkedItem()
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			itemChan <- &Graph{Item: it}
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{SymlinkPolicy: policy},
+		})
+		if err != nil {
+			t.Fatalf("policy %q: import failed: %v", policy, err)
+		}
+
+		item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "photo")
+		if policy == SymlinksReject {
+			if err == nil {
+				t.Errorf("policy %q: expected item to be rejected, but it was stored (row_id=%d)", policy, item.ID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("policy %q: loading item: %v", policy, err)
+		}
+		if item.DataFile == nil || item.DataHash == nil {
+			t.Fatalf("policy %q: expected data file with hash, got file=%v hash=%x", policy, item.DataFile, item.DataHash)
+		}
+
+		if policy != SymlinksReference {
+			if isExternalDataFile(*item.DataFile) {
+				t.Errorf("policy %q: expected data file to be copied into repo, but it references %s", policy, *item.DataFile)
+			}
+			contents, err := os.ReadFile(tl.FullPath(*item.DataFile))
+			if err != nil {
+				t.Fatalf("policy %q: reading copied data file: %v", policy, err)
+			}
+			if string(contents) != "external contents" {
+				t.Errorf("policy %q: unexpected contents of copied data file: %q", policy, contents)
+			}
+			continue
+		}
+
+		if *item.DataFile != resolvedTarget {
+			t.Errorf("policy %q: expected data file to reference %s, got %s", policy, resolvedTarget, *item.DataFile)
+		}
+		f, err := tl.OpenDataFile(ctx, *item.DataFile)
+		if err != nil {
+			t.Fatalf("policy %q: opening referenced data file: %v", policy, err)
+		}
+		contents, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || string(contents) != "external contents" {
+			t.Errorf("policy %q: reading referenced data file: contents=%q err=%v", policy, contents, err)
+		}
+
+		// erasing the item must leave the referenced file alone
+		noRetention := time.Duration(0)
+		if err := tl.DeleteItems(ctx, []int64{item.ID}, DeleteOptions{Retain: &noRetention}); err != nil {
+			t.Fatalf("policy %q: deleting item: %v", policy, err)
+		}
+		if _, err := os.Stat(target); err != nil {
+			t.Errorf("policy %q: referenced file was affected by erasing the item: %v", policy, err)
+		}
+	}
+}
+
+func TestSymlinkPolicyCopiesRegularFiles(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	regular := filepath.Join(t.TempDir(), "doc.bin")
+	if err := os.WriteFile(regular, []byte("regular contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	it := testFileItem("doc", time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC))
+	it.Content.LocalPath = regular
+
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: it}
+		return nil
+	}
+	err := tl.Import(ctx, ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{SymlinkPolicy: SymlinksReject},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "doc")
+	if err != nil {
+		t.Fatalf("expected item that isn't a symlink to be stored: %v", err)
+	}
+	if item.DataFile == nil || isExternalDataFile(*item.DataFile) {
+		t.Errorf("expected data file to be stored in repo, got %v", item.DataFile)
+	}
+}
+
+func TestSymlinkPolicyValidation(t *testing.T) {
+	tl := newTestTimeline(t)
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{SymlinkPolicy: "bogus"},
+	})
+	if err == nil {
+		t.Error("expected error for unrecognized symlink policy")
+	}
+}
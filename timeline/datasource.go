@@ -24,6 +24,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -50,11 +51,79 @@ type DataSource struct {
 	// Information that will help the user when choosing a data source.
 	Description string `json:"description"`
 
+	// The category of items from this data source that can't be categorized
+	// by their content or classification, such as orders from a store's
+	// purchase history (see DefaultClassifier). Optional.
+	Category string `json:"category,omitempty"`
+
 	NewOptions func() any `json:"-"`
 
+	// Optionally returns a JSON Schema describing the options
+	// returned by NewOptions. If set, options are validated
+	// against the schema before importing, which allows
+	// problems to be reported per-field.
+	OptionsSchema func() json.RawMessage `json:"-"`
+
+	// Optionally returns a copy of the options (as returned by NewOptions)
+	// with secrets such as tokens and passwords removed or masked. It must
+	// not modify the given options. Only data sources that implement this
+	// have their options stored with imports, so that they can be included
+	// in debug bundles (see ExportImportDebugBundle) for safe sharing.
+	RedactOptions func(dsOpt any) any `json:"-"`
+
 	NewFileImporter func() FileImporter `json:"-"`
 	NewAPIImporter  func() APIImporter  `json:"-"`
 
+	// If true, the file importer reads its input from ListingOptions.FS
+	// when it is set, so archives can be imported without extracting
+	// them to disk first (see ImportArchive).
+	SupportsFS bool `json:"supports_fs,omitempty"`
+
+	// The maximum number of API imports that may run at the same time
+	// for the same account, for services that forbid concurrent access
+	// (rate limits, single-session tokens, etc). 0 means no limit.
+	AccountImportLimit int `json:"account_import_limit,omitempty"`
+
+	// Optionally estimates the size of an import without performing
+	// it, for data sources that can cheaply enumerate their data (for
+	// example, by counting entries in an archive or asking an API).
+	// For file imports, acc is nil; for API imports, filenames is empty.
+	// It may return ErrEstimateUnsupported if the input can't be estimated.
+	EstimateImport func(ctx context.Context, filenames []string, acc *Account, dsOpt any) (ImportEstimate, error) `json:"-"`
+
+	// Optionally lists the original IDs of all the items currently at the
+	// source, for data sources that can do so without importing them, so
+	// that the timeline can be audited against the source (see
+	// CompareToSource). Like EstimateImport, acc is nil for file imports
+	// and filenames is empty for API imports.
+	EnumerateIDs func(ctx context.Context, filenames []string, acc *Account, dsOpt any) ([]string, error) `json:"-"`
+
+	// Optionally reports how well the data source recognizes an input from
+	// only its first bytes (up to recognizeHeadSize of them), for inputs that
+	// aren't files on disk, such as pasted text. It must be fast and must not
+	// assume the head is the whole input. It enables ImportFromReader to pick
+	// the data source automatically. Only used for file importers.
+	RecognizeHead func(head []byte) Recognition `json:"-"`
+
+	// Optionally fetches the full item for a placeholder that was only
+	// partially imported, i.e. an item with a retrieval key but no content.
+	// The retrieval key is as stored in the database (hashed), so the item's
+	// original ID is usually more useful. If the item isn't available (yet),
+	// it may return a nil item; it will be tried again later.
+	CompleteItem func(ctx context.Context, retrievalKey []byte, item ItemRow) (*Item, error) `json:"-"`
+
+	// Optionally derives the relationships from a stored item to other
+	// items, which must be deterministic. This allows relationships to be
+	// repaired without importing the data again (see RebuildRelationships).
+	DeriveRelationships func(ctx context.Context, item ItemRow) ([]DerivedRelationship, error) `json:"-"`
+
+	// Optionally shrinks the data of a checkpoint (see Graph.Checkpoint)
+	// before it is saved, for data sources whose checkpoints accumulate
+	// state during long imports, such as cursors or sets of IDs already
+	// seen. The result is what is given back to the data source when
+	// the import is resumed, so it must still be a valid checkpoint.
+	CompactCheckpoint func(checkpoint any) any `json:"-"`
+
 	// // TODO: a way to declare what this data source needs, like SMS backup & restore needs the person_identity for the user this came from (their phone number)
 	// // TODO: Maybe, if this is set, then we presume the data source requires a person identity to start with.
 	// NewIdentity func(input Person, dataSourceOptions any) (Person, error) `json:"-"`
@@ -99,6 +168,8 @@ func RegisterDataSource(ds DataSource) error {
 	}
 
 	// register the data source
+	dataSourcesMu.Lock()
+	defer dataSourcesMu.Unlock()
 	if _, ok := dataSources[ds.Name]; ok {
 		return fmt.Errorf("data source already registered: %s", ds.Name)
 	}
@@ -109,20 +180,20 @@ func RegisterDataSource(ds DataSource) error {
 
 // GetDataSource gets the data source with the given name (not database row ID).
 func GetDataSource(name string) (DataSource, error) {
-	for _, ds := range dataSources {
-		if ds.Name == name {
-			return ds, nil
-		}
+	if ds, ok := lookupDataSource(name); ok {
+		return ds, nil
 	}
 	return DataSource{}, fmt.Errorf("data source not found: %s", name)
 }
 
 // AllDataSources returns all registered data sources sorted by ID strings.
 func AllDataSources() []DataSource {
+	dataSourcesMu.RLock()
 	sources := make([]DataSource, 0, len(dataSources))
 	for _, ds := range dataSources {
 		sources = append(sources, ds)
 	}
+	dataSourcesMu.RUnlock()
 	sort.Slice(sources, func(i, j int) bool {
 		return sources[i].Name < sources[j].Name
 	})
@@ -144,7 +215,7 @@ func DataSourcesRecognize(ctx context.Context, filenames []string, timeout time.
 	}
 
 	var results []RecognizeResult
-	for _, ds := range dataSources {
+	for _, ds := range AllDataSources() {
 		if ctx.Err() != nil {
 			return nil, ctx.Err()
 		}
@@ -339,6 +410,11 @@ func (tf Timeframe) ContainsItem(it *Item, strict bool) bool {
 	return afterSince && beforeUntil
 }
 
+// FileImporter imports items from files. Files can be very large (tens of GB), so
+// FileImport should read them incrementally and send each graph as soon as it is
+// read, rather than loading the whole file first. Item data should likewise be
+// provided as a stream (see ItemData.Data); the processor writes it to disk as it
+// reads it. Sending on itemChan may block while the processor catches up.
 type FileImporter interface {
 	Recognize(ctx context.Context, filenames []string) (Recognition, error)
 	FileImport(ctx context.Context, filenames []string, itemChan chan<- *Graph, opt ListingOptions) error
@@ -357,4 +433,50 @@ type Recognition struct {
 	SnapshotDate time.Time `json:"snapshot_date"`
 }
 
-var dataSources = make(map[string]DataSource) // keyed by name (not DB row ID)
+// The registered data sources. They can be registered while timelines are
+// open (see RegisterDataSourceFactory), so always read them through
+// lookupDataSource or AllDataSources.
+var (
+	dataSources   = make(map[string]DataSource) // keyed by name (not DB row ID)
+	dataSourcesMu sync.RWMutex
+)
+
+// lookupDataSource returns the registered data source with the given name.
+func lookupDataSource(name string) (DataSource, bool) {
+	dataSourcesMu.RLock()
+	defer dataSourcesMu.RUnlock()
+	ds, ok := dataSources[name]
+	return ds, ok
+}
+
+// dataSourceRowID returns the database row ID of the registered data source with the
+// given name. If the data source was registered after the timeline was opened, and
+// thus doesn't have a row in the database yet, its row is created and cached.
+func (t *Timeline) dataSourceRowID(ctx context.Context, name string) (int64, error) {
+	t.cachesMu.RLock()
+	rowID, ok := t.dataSources[name]
+	t.cachesMu.RUnlock()
+	if ok {
+		return rowID, nil
+	}
+
+	if _, ok := lookupDataSource(name); !ok {
+		return 0, fmt.Errorf("unknown data source: %s", name)
+	}
+
+	t.dbMu.Lock()
+	_, err := t.db.ExecContext(ctx, `INSERT OR IGNORE INTO data_sources (name) VALUES (?)`, name)
+	if err == nil {
+		err = t.db.QueryRowContext(ctx, `SELECT id FROM data_sources WHERE name=? LIMIT 1`, name).Scan(&rowID)
+	}
+	t.dbMu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("storing data source %s: %v", name, err)
+	}
+
+	t.cachesMu.Lock()
+	t.dataSources[name] = rowID
+	t.cachesMu.Unlock()
+
+	return rowID, nil
+}
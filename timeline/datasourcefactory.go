@@ -0,0 +1,128 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// DataSourceFactory returns a data source. Factories allow data sources
+// to be provided by third parties, for example from plugins, without
+// needing to be compiled into this package's init phase.
+type DataSourceFactory func() (DataSource, error)
+
+var (
+	dataSourceFactories   = make(map[string]DataSourceFactory) // keyed by data source name; not yet instantiated
+	dataSourceFactoriesMu sync.Mutex
+)
+
+// RegisterDataSourceFactory registers a factory for the data source with the
+// given name. The factory is called when the next timeline is opened (or when
+// an open timeline reloads its data sources), and the data source it returns is
+// validated and registered the same way as with RegisterDataSource.
+func RegisterDataSourceFactory(name string, factory DataSourceFactory) error {
+	if name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if factory == nil {
+		return fmt.Errorf("missing factory function")
+	}
+
+	dataSourceFactoriesMu.Lock()
+	defer dataSourceFactoriesMu.Unlock()
+
+	if _, ok := lookupDataSource(name); ok {
+		return fmt.Errorf("data source already registered: %s", name)
+	}
+	if _, ok := dataSourceFactories[name]; ok {
+		return fmt.Errorf("data source factory already registered: %s", name)
+	}
+	dataSourceFactories[name] = factory
+
+	return nil
+}
+
+// instantiateDataSourceFactories calls all pending data source factories and
+// registers the data sources they return. A factory that fails is logged and
+// skipped so that one bad data source doesn't prevent opening the timeline.
+// It returns the names of the data sources that were registered.
+func instantiateDataSourceFactories() []string {
+	dataSourceFactoriesMu.Lock()
+	defer dataSourceFactoriesMu.Unlock()
+
+	names := make([]string, 0, len(dataSourceFactories))
+	for name := range dataSourceFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var registered []string
+	for _, name := range names {
+		factory := dataSourceFactories[name]
+		delete(dataSourceFactories, name)
+
+		ds, err := factory()
+		if err == nil && ds.Name != name {
+			err = fmt.Errorf("factory returned data source named %q", ds.Name)
+		}
+		if err == nil {
+			err = RegisterDataSource(ds)
+		}
+		if err != nil {
+			Log.Error("unable to register data source from factory",
+				zap.String("name", name),
+				zap.Error(err))
+			continue
+		}
+		registered = append(registered, name)
+	}
+
+	return registered
+}
+
+// reloadDataSources registers the data sources of any pending factories and
+// adds them to this timeline's database so they can be used for imports.
+func (tl *Timeline) reloadDataSources() ([]string, error) {
+	registered := instantiateDataSourceFactories()
+	if len(registered) == 0 {
+		return nil, nil
+	}
+
+	tl.dbMu.Lock()
+	err := saveAllDataSources(tl.db)
+	if err != nil {
+		tl.dbMu.Unlock()
+		return nil, err
+	}
+	dbDataSources, err := mapNamesToIDs(tl.db, "data_sources")
+	tl.dbMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("mapping data source names to IDs: %v", err)
+	}
+
+	tl.cachesMu.Lock()
+	tl.dataSources = dbDataSources
+	tl.cachesMu.Unlock()
+
+	return registered, nil
+}
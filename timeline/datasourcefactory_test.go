@@ -0,0 +1,142 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDataSourceFactory(t *testing.T) {
+	// data sources are registered globally, so use unique names in case the test is repeated
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	atOpen, afterOpen, broken := "factory_at_open_"+suffix, "factory_after_open_"+suffix, "factory_broken_"+suffix
+
+	factoryFor := func(name string) DataSourceFactory {
+		return func() (DataSource, error) {
+			return DataSource{
+				Name:            name,
+				Title:           "Factory test",
+				NewFileImporter: func() FileImporter { return testImporter{} },
+			}, nil
+		}
+	}
+	if err := RegisterDataSourceFactory(atOpen, factoryFor(atOpen)); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterDataSourceFactory(atOpen, factoryFor(atOpen)); err == nil {
+		t.Error("expected error registering duplicate factory")
+	}
+	if err := RegisterDataSourceFactory(broken, func() (DataSource, error) {
+		return DataSource{}, errors.New("broken")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// opening a timeline instantiates pending factories
+	tl := newTestTimeline(t)
+	if _, err := GetDataSource(atOpen); err != nil {
+		t.Fatalf("expected data source from factory to be registered: %v", err)
+	}
+	if _, err := GetDataSource(broken); err == nil {
+		t.Error("expected broken factory to be skipped")
+	}
+	if err := RegisterDataSourceFactory(atOpen, factoryFor(atOpen)); err == nil {
+		t.Error("expected error registering factory for existing data source")
+	}
+
+	// an open timeline can pick up factories registered later
+	if err := RegisterDataSourceFactory(afterOpen, factoryFor(afterOpen)); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := tl.reloadDataSources()
+	if err != nil {
+		t.Fatalf("reloading data sources: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0] != afterOpen {
+		t.Errorf("expected to load %s, got %v", afterOpen, loaded)
+	}
+
+	// both can be imported from
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("from_factory", time.Now())}
+		return nil
+	}
+	for _, name := range []string{atOpen, afterOpen} {
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: name,
+			Filenames:      []string{"test"},
+		})
+		if err != nil {
+			t.Errorf("importing with data source %s: %v", name, err)
+		}
+	}
+}
+
+func TestImportCreatesDataSourceRow(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// registered after the timeline was opened, so it has no row yet
+	name := fmt.Sprintf("lazy_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:            name,
+		Title:           "Lazy test",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("lazy", time.Now())}
+		return nil
+	}
+	err = tl.Import(context.Background(), ImportParameters{
+		DataSourceName: name,
+		Filenames:      []string{"test"},
+	})
+	if err != nil {
+		t.Fatalf("importing with data source that has no row: %v", err)
+	}
+
+	var rowID int64
+	err = tl.db.QueryRow(`SELECT id FROM data_sources WHERE name=?`, name).Scan(&rowID)
+	if err != nil {
+		t.Fatalf("expected data source row to be created: %v", err)
+	}
+	if cached := tl.dataSources[name]; cached != rowID {
+		t.Errorf("expected cached row ID %d, got %d", rowID, cached)
+	}
+
+	var count int
+	err = tl.db.QueryRow(`SELECT count() FROM items WHERE data_source_id=?`, rowID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 item from the new data source, got %d", count)
+	}
+
+	if _, err := tl.dataSourceRowID(context.Background(), "no_such_source"); err == nil {
+		t.Error("expected error for unregistered data source")
+	}
+}
@@ -19,6 +19,7 @@
 package timeline
 
 import (
+	"context"
 	"database/sql"
 	_ "embed"
 	"fmt"
@@ -66,6 +67,12 @@ func openDB(repoDir string) (*sql.DB, error) {
 }
 
 func provisionDB(db *sql.DB) error {
+	// bring tables of older databases up to date first, since
+	// the schema may create indexes on columns that were added
+	if err := migrateDB(db); err != nil {
+		return err
+	}
+
 	_, err := db.Exec(createDB)
 	if err != nil {
 		return fmt.Errorf("setting up database: %w", err)
@@ -82,7 +89,8 @@ func provisionDB(db *sql.DB) error {
 		return fmt.Errorf("persisting repo UUID and version: %w", err)
 	}
 
-	// add all registered data sources
+	// add all registered data sources, including any provided by factories
+	instantiateDataSourceFactories()
 	err = saveAllDataSources(db)
 	if err != nil {
 		return fmt.Errorf("saving registered data sources to database: %w", err)
@@ -103,8 +111,143 @@ func provisionDB(db *sql.DB) error {
 	return nil
 }
 
+// migrateDB adds columns to the tables of databases created before the
+// columns existed. Tables that don't exist yet are left for the schema
+// to create. Since ADD COLUMN can't add a UNIQUE column, columns that
+// are unique in the schema get a unique index instead.
+func migrateDB(db *sql.DB) error {
+	for _, col := range []struct{ table, name, definition, uniqueIndex string }{
+		{"imports", "file_hashes", "TEXT", ""},
+		{"items", "sequence", "INTEGER", ""},
+		{"items", "source_file", "TEXT", ""},
+		{"items", "source_offset", "INTEGER", ""},
+		{"items", "normalized_text", "TEXT", ""},
+		{"items", "visibility", "TEXT", ""},
+		{"items", "original_timestamp", "INTEGER", ""},
+		{"items", "global_id", "TEXT", "idx_items_global_id"},
+		{"items", "data_length", "INTEGER", ""},
+		{"items", "media_version", "INTEGER", ""},
+		{"items", "timestamp_micros", "INTEGER", ""},
+		{"items", "account_id", "INTEGER", ""},
+		{"items", "category", "TEXT", ""},
+		{"imports", "cancel_reason", "TEXT", ""},
+		{"entities", "name_import_id", `INTEGER REFERENCES "imports"("id") ON UPDATE CASCADE ON DELETE SET NULL`, ""},
+		{"entities", "picture_import_id", `INTEGER REFERENCES "imports"("id") ON UPDATE CASCADE ON DELETE SET NULL`, ""},
+	} {
+		var tableExists, columnExists bool
+		err := db.QueryRow(`SELECT count() > 0, coalesce(sum(name=?), 0) > 0 FROM pragma_table_info(?)`,
+			col.name, col.table).Scan(&tableExists, &columnExists)
+		if err != nil {
+			return fmt.Errorf("checking for column %s.%s: %w", col.table, col.name, err)
+		}
+		if !tableExists || columnExists {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %q ADD COLUMN %q %s`, col.table, col.name, col.definition))
+		if err != nil {
+			return fmt.Errorf("adding column %s.%s: %w", col.table, col.name, err)
+		}
+		if col.uniqueIndex != "" {
+			_, err = db.Exec(fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %q ON %q(%q)`, col.uniqueIndex, col.table, col.name))
+			if err != nil {
+				return fmt.Errorf("indexing column %s.%s: %w", col.table, col.name, err)
+			}
+		}
+	}
+	return rebuildItemsTable(db)
+}
+
+// rebuildItemsTable recreates the items table of databases created before
+// items could be unique per account (see DedupScope). Those tables have a
+// table constraint that makes original IDs unique per data source, which
+// can't be dropped, so the rows are copied into a new table without it.
+// The schema then recreates the table's indexes.
+func rebuildItemsTable(db *sql.DB) error {
+	var oldConstraint bool
+	err := db.QueryRow(`SELECT count() > 0 FROM pragma_index_list('items') AS il
+		WHERE il.origin='u'
+			AND (SELECT group_concat(name) FROM pragma_index_info(il.name)) = 'data_source_id,original_id'`).Scan(&oldConstraint)
+	if err != nil {
+		return fmt.Errorf("checking items table for unique original IDs per data source: %w", err)
+	}
+	if !oldConstraint {
+		return nil
+	}
+
+	const tableStart, tableEnd = `CREATE TABLE IF NOT EXISTS "items" (`, `) STRICT;`
+	start := strings.Index(createDB, tableStart)
+	end := strings.Index(createDB[start:], tableEnd)
+	if start < 0 || end < 0 {
+		return fmt.Errorf("items table not found in schema")
+	}
+	createItems := strings.Replace(createDB[start:start+end+len(tableEnd)], `"items"`, `"items_rebuilt"`, 1)
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// foreign keys can only be disabled outside a transaction; tables that refer
+	// to items do so by name and will refer to the new table once it is renamed;
+	// and the legacy behavior of ALTER TABLE leaves views and triggers alone
+	// instead of failing on their references to the dropped table
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys=OFF`); err != nil {
+		return fmt.Errorf("disabling foreign keys: %w", err)
+	}
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys=ON`)
+	if _, err := conn.ExecContext(ctx, `PRAGMA legacy_alter_table=ON`); err != nil {
+		return fmt.Errorf("enabling legacy alter table: %w", err)
+	}
+	defer conn.ExecContext(ctx, `PRAGMA legacy_alter_table=OFF`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(createItems); err != nil {
+		return fmt.Errorf("creating new items table: %w", err)
+	}
+
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info('items')
+		WHERE name IN (SELECT name FROM pragma_table_info('items_rebuilt'))`)
+	if err != nil {
+		return fmt.Errorf("listing item columns: %w", err)
+	}
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning item column: %w", err)
+		}
+		cols = append(cols, fmt.Sprintf("%q", col))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating item columns: %w", err)
+	}
+
+	colList := strings.Join(cols, ", ")
+	if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO items_rebuilt (%s) SELECT %s FROM items`, colList, colList)); err != nil {
+		return fmt.Errorf("copying items: %w", err)
+	}
+	if _, err := tx.Exec(`DROP TABLE items`); err != nil {
+		return fmt.Errorf("dropping old items table: %w", err)
+	}
+	if _, err := tx.Exec(`ALTER TABLE items_rebuilt RENAME TO items`); err != nil {
+		return fmt.Errorf("renaming new items table: %w", err)
+	}
+
+	return tx.Commit()
+}
+
 func saveAllDataSources(db *sql.DB) error {
-	if len(dataSources) == 0 {
+	sources := AllDataSources()
+	if len(sources) == 0 {
 		return nil
 	}
 
@@ -112,7 +255,7 @@ func saveAllDataSources(db *sql.DB) error {
 	var vals []any
 	var count int
 
-	for _, ds := range dataSources {
+	for _, ds := range sources {
 		if count > 0 {
 			query += ","
 		}
@@ -0,0 +1,182 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateDB(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "old.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// tables that don't exist yet are left alone
+	if err := migrateDB(db); err != nil {
+		t.Fatalf("migrating empty database: %v", err)
+	}
+
+	if _, err := db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, timestamp INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO items (timestamp) VALUES (?)`, int64(1680674828123)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE entities (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO entities (name) VALUES ('Alice')`); err != nil {
+		t.Fatal(err)
+	}
+
+	// running it again must be harmless
+	for range 2 {
+		if err := migrateDB(db); err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+	}
+
+	var ts int64
+	var micros, accountID *int64
+	if err := db.QueryRow(`SELECT timestamp, timestamp_micros, account_id FROM items`).Scan(&ts, &micros, &accountID); err != nil {
+		t.Fatal(err)
+	}
+	if ts != 1680674828123 || micros != nil || accountID != nil {
+		t.Errorf("existing row changed: timestamp=%d timestamp_micros=%v account_id=%v", ts, micros, accountID)
+	}
+
+	var name string
+	var nameImportID, pictureImportID *int64
+	if err := db.QueryRow(`SELECT name, name_import_id, picture_import_id FROM entities`).Scan(&name, &nameImportID, &pictureImportID); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Alice" || nameImportID != nil || pictureImportID != nil {
+		t.Errorf("existing entity changed: name=%s name_import_id=%v picture_import_id=%v", name, nameImportID, pictureImportID)
+	}
+}
+
+func TestProvisionBaselineDB(t *testing.T) {
+	baseline, err := os.ReadFile(filepath.Join("testdata", "schema_baseline.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoDir := t.TempDir()
+	db, err := openDB(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(baseline)); err != nil {
+		t.Fatalf("creating baseline schema: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO items (original_id, timestamp) VALUES ('old', 1)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provisionDB(db); err != nil {
+		t.Fatalf("provisioning database with baseline schema: %v", err)
+	}
+
+	// every column of the current schema must be usable
+	if _, err := db.Exec(`INSERT INTO items
+		(original_id, timestamp, sequence, source_file, source_offset, normalized_text, visibility,
+			original_timestamp, data_length, media_version, global_id, category, timestamp_micros)
+		VALUES ('new', 2, 1, 'file.json', 3, 'text', 'private', 4, 5, 1, 'gid', 'note', 2000)`); err != nil {
+		t.Fatalf("inserting item with new columns: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO imports (mode, file_hashes, cancel_reason) VALUES ('file', '{}', 'user')`); err != nil {
+		t.Fatalf("inserting import with new columns: %v", err)
+	}
+
+	// global_id is unique, even though ADD COLUMN can't say so
+	if _, err := db.Exec(`INSERT INTO items (original_id, global_id) VALUES ('dupe', 'gid')`); err == nil {
+		t.Error("expected duplicate global_id to be rejected")
+	}
+
+	var originalID string
+	if err := db.QueryRow(`SELECT original_id FROM items WHERE timestamp=1`).Scan(&originalID); err != nil || originalID != "old" {
+		t.Errorf("existing item changed: original_id=%q err=%v", originalID, err)
+	}
+}
+
+func TestRebuildItemsTable(t *testing.T) {
+	baseline, err := os.ReadFile(filepath.Join("testdata", "schema_baseline.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(baseline)); err != nil {
+		t.Fatalf("creating baseline schema: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO data_sources (id, name) VALUES (1, 'test_source')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO items (id, data_source_id, original_id, data_text) VALUES (7, 1, 'same', 'before')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO relations (id, label) VALUES (1, 'test_relation')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO relationships (relation_id, from_item_id, to_item_id) VALUES (1, 7, 7)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// running it again must be harmless
+	for range 2 {
+		if err := provisionDB(db); err != nil {
+			t.Fatalf("provisioning database with baseline schema: %v", err)
+		}
+	}
+
+	// the same original ID can now be stored under different accounts...
+	if _, err := db.Exec(`INSERT INTO accounts (id, data_source_id) VALUES (1, 1), (2, 1)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO items (data_source_id, original_id, account_id) VALUES (1, 'same', 1), (1, 'same', 2)`); err != nil {
+		t.Fatalf("inserting same original ID for different accounts: %v", err)
+	}
+	// ...but still only once per account
+	if _, err := db.Exec(`INSERT INTO items (data_source_id, original_id) VALUES (1, 'same')`); err == nil {
+		t.Error("expected duplicate original ID without account to be rejected")
+	}
+
+	// existing rows, references to them, and views on the table still work
+	var text string
+	if err := db.QueryRow(`SELECT data_text FROM extended_items WHERE id=7`).Scan(&text); err != nil || text != "before" {
+		t.Errorf("existing item changed: data_text=%q err=%v", text, err)
+	}
+	if _, err := db.Exec(`DELETE FROM items WHERE id=7`); err != nil {
+		t.Fatal(err)
+	}
+	var rels int
+	if err := db.QueryRow(`SELECT count() FROM relationships`).Scan(&rels); err != nil || rels != 0 {
+		t.Errorf("expected relationship to cascade with its item, got %d (err=%v)", rels, err)
+	}
+}
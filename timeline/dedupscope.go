@@ -0,0 +1,71 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import "fmt"
+
+// DedupScope specifies among which items an item is looked for to
+// determine whether it is already in the timeline.
+type DedupScope string
+
+const (
+	// DedupScopeDataSource considers items with the same original ID from
+	// the same data source to be the same item, regardless of account.
+	// This suits most data sources, since their IDs are usually unique
+	// across all users of the service (e.g. photo or message IDs), and
+	// it lets the same item be imported via an API and from an export
+	// without being duplicated. This is the default.
+	DedupScopeDataSource DedupScope = "data_source"
+
+	// DedupScopeAccount considers items to be the same only if they were
+	// imported with the same account. This suits data sources whose IDs
+	// are only unique within an account, such as a per-device counter or
+	// row IDs from a local database, where the same ID from two accounts
+	// refers to different items. Imports without an account fall back to
+	// data source scope.
+	//
+	// Timelines created before this scope was supported have original IDs
+	// constrained to be unique per data source in the database itself, so
+	// they can't hold items of different accounts with the same ID.
+	DedupScopeAccount DedupScope = "account"
+
+	// DedupScopeGlobal additionally considers items from any data source
+	// to be the same if their initial content (timestamp together with
+	// text, data file, or location) is identical. This suits overlapping
+	// sources of the same data, such as photos that were backed up to a
+	// cloud service and also exported from the phone they were taken on.
+	DedupScopeGlobal DedupScope = "global"
+)
+
+func (ds DedupScope) validate() error {
+	switch ds {
+	case "", DedupScopeDataSource, DedupScopeAccount, DedupScopeGlobal:
+		return nil
+	}
+	return fmt.Errorf("unrecognized dedup scope: %s", ds)
+}
+
+// dedupAccountID returns the account within which original IDs of items
+// in this import are unique, if they are not unique per data source.
+func (p *processor) dedupAccountID() *int64 {
+	if p.params.ProcessingOptions.DedupScope != DedupScopeAccount || p.params.AccountID == 0 {
+		return nil
+	}
+	return &p.params.AccountID
+}
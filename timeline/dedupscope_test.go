@@ -0,0 +1,135 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDedupScope(t *testing.T) {
+	otherSource := fmt.Sprintf("other_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:            otherSource,
+		Title:           "Other test source",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	importItem := func(tl *Timeline, dataSource string, accountID int64, scope DedupScope, it *Item) {
+		t.Helper()
+		testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			itemChan <- &Graph{Item: it}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    dataSource,
+			Filenames:         []string{"test"},
+			AccountID:         accountID,
+			ProcessingOptions: ProcessingOptions{DedupScope: scope},
+		})
+		if err != nil {
+			t.Fatalf("scope %q: import failed: %v", scope, err)
+		}
+	}
+	countItems := func(tl *Timeline) int {
+		t.Helper()
+		var count int
+		if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// the same original ID from two accounts is the same item unless scoped to the account
+	for _, tc := range []struct {
+		scope    DedupScope
+		expected int
+	}{
+		{"", 1},
+		{DedupScopeDataSource, 1},
+		{DedupScopeAccount, 2},
+	} {
+		tl := newTestTimeline(t)
+		var accounts []int64
+		for range 2 {
+			acc, err := tl.CreateAccount(context.Background(), testDataSourceName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			accounts = append(accounts, acc.ID)
+		}
+
+		importItem(tl, testDataSourceName, accounts[0], tc.scope, testMessage("msg1", ts))
+		importItem(tl, testDataSourceName, accounts[1], tc.scope, testMessage("msg1", ts.Add(time.Hour)))
+		// importing again with the first account must not add another item in any scope
+		importItem(tl, testDataSourceName, accounts[0], tc.scope, testMessage("msg1", ts))
+
+		if count := countItems(tl); count != tc.expected {
+			t.Errorf("scope %q: expected %d items, got %d", tc.scope, tc.expected, count)
+		}
+		if tc.scope == DedupScopeAccount {
+			for _, accountID := range accounts {
+				ir, err := tl.ItemByOriginalID(context.Background(), testDataSourceName, accountID, "msg1")
+				if err != nil {
+					t.Errorf("scope %q: account %d: %v", tc.scope, accountID, err)
+				} else if (accountID == accounts[0]) != ir.Timestamp.Equal(ts) {
+					t.Errorf("scope %q: account %d got the other account's item: %+v", tc.scope, accountID, ir)
+				}
+			}
+		}
+	}
+
+	// identical content from different data sources is only the same item in the global scope
+	for _, tc := range []struct {
+		scope    DedupScope
+		expected int
+	}{
+		{DedupScopeDataSource, 2},
+		{DedupScopeGlobal, 1},
+	} {
+		tl := newTestTimeline(t)
+		original := testMessage("sms1", ts)
+		backup := testMessage("backup-8f3a", ts)
+		backup.Content.Data = StringData("message sms1")
+
+		importItem(tl, testDataSourceName, 0, tc.scope, original)
+		importItem(tl, otherSource, 0, tc.scope, backup)
+
+		if count := countItems(tl); count != tc.expected {
+			t.Errorf("scope %q: expected %d items, got %d", tc.scope, tc.expected, count)
+		}
+	}
+
+	tl := newTestTimeline(t)
+	err = tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{DedupScope: "universe"},
+	})
+	if err == nil {
+		t.Error("expected error for unrecognized dedup scope")
+	}
+}
@@ -0,0 +1,248 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// downloadAttempts is how many times a download may be resumed
+// after failing part way through, before giving up.
+const downloadAttempts = 3
+
+// expectedDataCtxKey is how a download (see DownloadData) finds the
+// content whose data it is, so that it can verify the expected size
+// and hash before the data is used.
+var expectedDataCtxKey ctxKey = "expected_data"
+
+// download is an HTTP download that is saved to a temporary file before it
+// is used. If the connection fails part way through, the download continues
+// where the file left off with a Range request (if the server supports it)
+// instead of starting over. Nothing reads the file until it is complete and
+// verified, so a partial or corrupt download is never used.
+type download struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+
+	body      io.ReadCloser // of the most recent response
+	header    http.Header   // of the initial response
+	size      int64         // expected total size, or -1 if unknown
+	validator string        // ETag or Last-Modified of the resource, to make sure it hasn't changed when resuming
+	ranges    bool          // whether the server accepts range requests
+	attempts  int
+}
+
+func openDownload(ctx context.Context, client *http.Client, url string) (*download, error) {
+	d := &download{ctx: ctx, client: client, url: url, size: -1}
+
+	resp, err := d.request(nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("downloading %s: HTTP %d", url, resp.StatusCode)
+	}
+
+	d.body = resp.Body
+	d.header = resp.Header
+	d.size = resp.ContentLength
+	d.ranges = resp.Header.Get("Accept-Ranges") == "bytes"
+	d.validator = resp.Header.Get("ETag")
+	if d.validator == "" {
+		d.validator = resp.Header.Get("Last-Modified")
+	}
+
+	return d, nil
+}
+
+func (d *download) request(header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, vals := range header {
+		req.Header[key] = vals
+	}
+	return d.client.Do(req)
+}
+
+// save writes the download to a new temporary file in dir (or the default
+// directory for temporary files, if empty) and returns the file, rewound
+// to its start. The download is verified against the size given by the
+// server and the expected size and hash of expect, if any; if it doesn't
+// match, the returned error wraps errDataFileCorrupt. If maxSize is greater
+// than 0, downloads larger than that fail. The file is removed if there
+// is an error; otherwise the caller is responsible for it.
+func (d *download) save(dir string, maxSize int64, expect ItemData) (*os.File, error) {
+	file, err := os.CreateTemp(dir, "download_*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("creating file for download: %w", err)
+	}
+	if err := d.saveTo(file, maxSize, expect); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// saveTo writes the download to file, resuming into it if necessary, and verifies it.
+func (d *download) saveTo(file *os.File, maxSize int64, expect ItemData) error {
+	var expected hash.Hash
+	var w io.Writer = file
+	if len(expect.ExpectedHash) > 0 {
+		expected = expect.expectedHasher()
+		w = io.MultiWriter(file, expected)
+	}
+
+	var written int64
+	for {
+		var src io.Reader = d.body
+		if maxSize > 0 {
+			src = io.LimitReader(d.body, maxSize+1-written)
+		}
+		n, err := io.Copy(w, src)
+		written += n
+		if maxSize > 0 && written > maxSize {
+			return fmt.Errorf("download exceeds the limit of %d bytes", maxSize)
+		}
+		if err == nil && d.size >= 0 && written < d.size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			break
+		}
+		if resumeErr := d.resume(file); resumeErr != nil {
+			return fmt.Errorf("downloading %s: %w", d.url, errors.Join(err, resumeErr))
+		}
+	}
+
+	if d.size >= 0 && written != d.size {
+		return fmt.Errorf("%w: downloading %s: expected %d bytes, got %d", errDataFileCorrupt, d.url, d.size, written)
+	}
+	if err := expect.verify(written, expected); err != nil {
+		return fmt.Errorf("%w: downloading %s: %v", errDataFileCorrupt, d.url, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("syncing downloaded file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewinding downloaded file: %w", err)
+	}
+
+	return nil
+}
+
+// resume continues the download from the end of file with a range request.
+func (d *download) resume(file *os.File) error {
+	if !d.ranges {
+		return fmt.Errorf("server does not support resuming downloads")
+	}
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	if d.attempts >= downloadAttempts {
+		return fmt.Errorf("giving up after resuming download %d times", d.attempts)
+	}
+	d.attempts++
+	d.body.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("checking size of partial download: %w", err)
+	}
+	offset := strconv.FormatInt(info.Size(), 10)
+
+	header := http.Header{"Range": {"bytes=" + offset + "-"}}
+	if d.validator != "" {
+		// if the resource changed, the server sends the whole new one, which we can't use
+		header.Set("If-Range", d.validator)
+	}
+	resp, err := d.request(header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return fmt.Errorf("resuming download: expected HTTP %d, got %d (resource may have changed)",
+			http.StatusPartialContent, resp.StatusCode)
+	}
+	if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, "bytes "+offset+"-") {
+		resp.Body.Close()
+		return fmt.Errorf("resuming download: server sent wrong range: %s", contentRange)
+	}
+
+	d.body = resp.Body
+	return nil
+}
+
+func (d *download) Close() error { return d.body.Close() }
+
+// downloadedData is the data of an item that is downloaded (see DownloadData).
+// The download starts on the first read, rather than when the data is opened,
+// and is saved to a temporary file in the import's scratch directory, which is
+// then read from. The file is removed when it is closed.
+type downloadedData struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	file   *os.File
+}
+
+func (dd *downloadedData) Read(p []byte) (int, error) {
+	if dd.file == nil {
+		var dir string
+		if proc, ok := dd.ctx.Value(processorCtxKey).(*processor); ok {
+			dir = proc.tempDir
+		}
+		expect, _ := dd.ctx.Value(expectedDataCtxKey).(ItemData)
+
+		d, err := openDownload(dd.ctx, dd.client, dd.url)
+		if err != nil {
+			return 0, err
+		}
+		defer d.Close()
+		dd.file, err = d.save(dir, 0, expect)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return dd.file.Read(p)
+}
+
+func (dd *downloadedData) Close() error {
+	if dd.file == nil {
+		return nil
+	}
+	err := dd.file.Close()
+	if removeErr := os.Remove(dd.file.Name()); removeErr != nil && err == nil {
+		err = removeErr
+	}
+	return err
+}
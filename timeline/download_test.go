@@ -0,0 +1,350 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer serves content, but the first response is cut off half way through.
+func flakyServer(t *testing.T, content []byte, acceptRanges bool) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var ranges []string
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first := len(ranges) == 0
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+
+		w.Header().Set("ETag", `"v1"`)
+		if first {
+			if acceptRanges {
+				w.Header().Set("Accept-Ranges", "bytes")
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler) // drop the connection mid-stream
+		}
+		http.ServeContent(w, r, "file.bin", modTime, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ranges...)
+	}
+}
+
+func TestDownloadDataResumesWithRange(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	srv, requests := flakyServer(t, content, true)
+
+	ctx := context.Background()
+	rc, err := DownloadData(ctx, srv.URL)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading download: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content differs: got %d bytes, expected %d", len(got), len(content))
+	}
+
+	reqs := requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 requests, got %d: %v", len(reqs), reqs)
+	}
+	if reqs[0] != "" {
+		t.Errorf("first request should not have a range, got %q", reqs[0])
+	}
+	if reqs[1] == "" || reqs[1] == "bytes=0-" {
+		t.Errorf("expected resumed request to continue from partial bytes, got range %q", reqs[1])
+	}
+}
+
+func TestDownloadDataFailsWithoutRangeSupport(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 256*1024)
+	srv, requests := flakyServer(t, content, false)
+
+	ctx := context.Background()
+	rc, err := DownloadData(ctx, srv.URL)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	if _, err := io.ReadAll(rc); err == nil {
+		t.Fatal("expected error when download is cut off and server can't resume")
+	}
+	if n := len(requests()); n != 1 {
+		t.Errorf("expected no attempt to resume, got %d requests", n)
+	}
+}
+
+func TestDownloadIsVerifiedBeforeUse(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	srv, _ := flakyServer(t, content, true)
+
+	ctx := context.Background()
+	expected := bytes.Clone(content)
+	expected[0] ^= 0xff // the server sends something else
+	h := newHash()
+	h.Write(expected)
+	data := ItemData{Data: DownloadData(ctx, srv.URL), ExpectedHash: h.Sum(nil)}
+
+	rc, err := data.open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	n, err := rc.Read(make([]byte, 512))
+	if !errors.Is(err, errDataFileCorrupt) {
+		t.Errorf("expected download with wrong hash to be corrupt, got: %v", err)
+	}
+	if n > 0 {
+		t.Errorf("expected none of the corrupt download to be read, but got %d bytes", n)
+	}
+}
+
+func TestResumedDownloadIsImported(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	srv, requests := flakyServer(t, content, true)
+	importExpectedChecksum(t, tl, srv.URL, content, 0)
+
+	if reqs := requests(); len(reqs) != 2 || reqs[1] != "bytes="+strconv.Itoa(len(content)/2)+"-" {
+		t.Errorf("expected download to be resumed from where it was cut off, got requests: %q", reqs)
+	}
+	var dataFile string
+	if err := tl.db.QueryRow(`SELECT data_file FROM items WHERE original_id='photo'`).Scan(&dataFile); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(tl.FullPath(dataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("data file has wrong content (%d bytes, expected %d)", len(got), len(content))
+	}
+
+	// the partial download was kept in the import's scratch directory, which is gone now
+	if entries, err := os.ReadDir(tl.FullPath(TempFolderName)); err == nil && len(entries) > 0 {
+		t.Errorf("expected temporary files to be cleaned up, found %d", len(entries))
+	}
+}
+
+func TestLargeDownloadIsStreamedToDataFile(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const size = 64 * 1024 * 1024
+	content := func() io.Reader { return io.LimitReader(rand.New(rand.NewSource(1)), size) }
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		_, _ = io.Copy(w, content())
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: &Item{
+			ID:             "big",
+			Classification: ClassMedia,
+			Timestamp:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Content: ItemData{
+				Filename:  "big.bin",
+				MediaType: "application/octet-stream",
+				Data:      DownloadData(ctx, srv.URL),
+			},
+		}}
+		return nil
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	err := tl.Import(ctx, ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	// if the file was buffered anywhere (on either end of the
+	// connection), at least its whole size would be allocated
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/4 {
+		t.Errorf("expected data file to be streamed, but %d MiB were allocated for a %d MiB file",
+			allocated/1024/1024, size/1024/1024)
+	}
+
+	h := newHash()
+	if _, err := io.Copy(h, content()); err != nil {
+		t.Fatal(err)
+	}
+	var dataFile string
+	var dataHash []byte
+	if err := tl.db.QueryRow(`SELECT data_file, data_hash FROM items WHERE original_id='big'`).Scan(&dataFile, &dataHash); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dataHash, h.Sum(nil)) {
+		t.Errorf("data file hash is wrong: expected %x, got %x", h.Sum(nil), dataHash)
+	}
+	info, err := os.Stat(tl.FullPath(dataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Errorf("expected data file to be %d bytes, got %d", size, info.Size())
+	}
+}
+
+// corruptingServer serves content, but the first corrupt responses have a byte flipped.
+func corruptingServer(t *testing.T, content []byte, corrupt int) (*httptest.Server, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		body := content
+		if n <= corrupt {
+			body = bytes.Clone(content)
+			body[len(body)/2] ^= 0xff
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func importExpectedChecksum(t *testing.T, tl *Timeline, url string, content []byte, attempts int) {
+	t.Helper()
+	h := newHash()
+	h.Write(content)
+	sum := h.Sum(nil)
+
+	importTestItemsWithParams(t, tl, ImportParameters{ProcessingOptions: ProcessingOptions{DownloadAttempts: attempts}}, &Item{
+		ID:             "photo",
+		Classification: ClassMedia,
+		Timestamp:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Content: ItemData{
+			Filename:     "photo.bin",
+			MediaType:    "application/octet-stream",
+			Data:         DownloadData(context.Background(), url),
+			ExpectedSize: int64(len(content)),
+			ExpectedHash: sum,
+		},
+	})
+}
+
+func TestCorruptDownloadIsRetried(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	content := bytes.Repeat([]byte("not a real photo "), 1000)
+	srv, requests := corruptingServer(t, content, 1)
+	importExpectedChecksum(t, tl, srv.URL, content, 0)
+
+	if n := requests(); n != 2 {
+		t.Errorf("expected corrupt download to be downloaded again once, but got %d requests", n)
+	}
+	var dataFile string
+	if err := tl.db.QueryRow(`SELECT data_file FROM items WHERE original_id='photo'`).Scan(&dataFile); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(tl.FullPath(dataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("data file has wrong content after retry (%d bytes, expected %d)", len(got), len(content))
+	}
+}
+
+func TestPersistentlyCorruptDownloadIsSkipped(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	content := bytes.Repeat([]byte("not a real photo "), 1000)
+	srv, requests := corruptingServer(t, content, 100)
+	importExpectedChecksum(t, tl, srv.URL, content, 2)
+
+	if n := requests(); n != 2 {
+		t.Errorf("expected 2 download attempts, got %d", n)
+	}
+	var items int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE original_id='photo'`).Scan(&items); err != nil {
+		t.Fatal(err)
+	}
+	if items != 0 {
+		t.Errorf("expected item with corrupt data file to be skipped, but it was stored")
+	}
+	var skipped int
+	if err := tl.db.QueryRow(`SELECT count FROM import_skip_reasons WHERE reason=?`, SkipReasonDataFileCorrupt).Scan(&skipped); err != nil {
+		t.Fatalf("querying skip reason: %v", err)
+	}
+	if skipped != 1 {
+		t.Errorf("expected 1 item skipped for a corrupt data file, got %d", skipped)
+	}
+	var files int
+	_ = filepath.WalkDir(tl.FullPath(DataFolderName), func(_ string, d fs.DirEntry, _ error) error {
+		if d != nil && !d.IsDir() {
+			files++
+		}
+		return nil
+	})
+	if files > 0 {
+		t.Errorf("expected corrupt data file to be deleted, but %d files remain", files)
+	}
+}
@@ -0,0 +1,405 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap"
+)
+
+// DuplicateCriteria determines how FindDuplicateItems decides
+// which items are duplicates of each other.
+type DuplicateCriteria string
+
+const (
+	// DuplicatesByContent clusters items that have identical content: the
+	// same data file hash, or the same (normalized) text. Timestamps and
+	// other properties are not considered.
+	DuplicatesByContent DuplicateCriteria = "content"
+
+	// DuplicatesByTimestampText clusters text items whose timestamps are
+	// within a tolerance of each other and whose text is similar.
+	DuplicatesByTimestampText DuplicateCriteria = "timestamp_text"
+)
+
+// FindDuplicatesOptions configures how candidate duplicate items are found.
+type FindDuplicatesOptions struct {
+	// How to decide which items are duplicates; DuplicatesByContent by default.
+	Criteria DuplicateCriteria `json:"criteria,omitempty"`
+
+	// For DuplicatesByTimestampText: the maximum difference between the
+	// timestamps of two items for them to be considered duplicates.
+	// Default is 0, meaning the timestamps must be equal.
+	TimeTolerance time.Duration `json:"time_tolerance,omitempty"`
+
+	// For DuplicatesByTimestampText: the minimum similarity of the text
+	// of two items, from 0 to 1, where 1 means they have the same words
+	// (ignoring case and punctuation). Default is 0.8.
+	MinSimilarity float64 `json:"min_similarity,omitempty"`
+
+	// If set, only items from this data source are considered.
+	DataSourceName string `json:"data_source_name,omitempty"`
+
+	// The maximum number of clusters to return; 0 means no limit.
+	Limit int `json:"limit,omitempty"`
+}
+
+func (opts FindDuplicatesOptions) validate() error {
+	switch opts.Criteria {
+	case "", DuplicatesByContent, DuplicatesByTimestampText:
+	default:
+		return fmt.Errorf("unrecognized duplicate criteria: %s", opts.Criteria)
+	}
+	if opts.TimeTolerance < 0 {
+		return fmt.Errorf("time tolerance cannot be negative: %s", opts.TimeTolerance)
+	}
+	if opts.MinSimilarity < 0 || opts.MinSimilarity > 1 {
+		return fmt.Errorf("minimum similarity must be between 0 and 1: %f", opts.MinSimilarity)
+	}
+	if opts.Limit < 0 {
+		return fmt.Errorf("limit cannot be negative: %d", opts.Limit)
+	}
+	return nil
+}
+
+// DuplicateCluster is a group of items that are likely duplicates of each other.
+type DuplicateCluster struct {
+	// Describes what the items have in common, for example the hash of
+	// their content. It is informational only.
+	Key   string          `json:"key"`
+	Items []DuplicateItem `json:"items"`
+}
+
+// DuplicateItem describes an item in a DuplicateCluster, including where it came from.
+type DuplicateItem struct {
+	ID             int64      `json:"id"`
+	DataSourceName *string    `json:"data_source_name,omitempty"`
+	OriginalID     *string    `json:"original_id,omitempty"`
+	ImportID       *int64     `json:"import_id,omitempty"`
+	Timestamp      *time.Time `json:"timestamp,omitempty"`
+}
+
+func (c DuplicateCluster) itemIDs() []int64 {
+	ids := make([]int64, 0, len(c.Items))
+	for _, it := range c.Items {
+		ids = append(ids, it.ID)
+	}
+	return ids
+}
+
+// FindDuplicateItems returns clusters of items that are candidate duplicates
+// according to opts. It does not modify anything; to resolve a cluster, pass
+// it to MergeItems. Deleted items are not considered.
+func (tl *Timeline) FindDuplicateItems(ctx context.Context, opts FindDuplicatesOptions) ([]DuplicateCluster, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Criteria == DuplicatesByTimestampText {
+		return tl.findSimilarTextItems(ctx, opts)
+	}
+	return tl.findIdenticalContentItems(ctx, opts)
+}
+
+// duplicateItemColumns are the columns scanned by scanDuplicateItem, which
+// requires the items table to be joined with data_sources.
+const duplicateItemColumns = `items.id, data_sources.name, items.original_id, items.import_id, items.timestamp`
+
+func scanDuplicateItem(rows *sql.Rows, dest ...any) (DuplicateItem, error) {
+	var di DuplicateItem
+	var ts *int64
+	targets := append([]any{&di.ID, &di.DataSourceName, &di.OriginalID, &di.ImportID, &ts}, dest...)
+	if err := rows.Scan(targets...); err != nil {
+		return di, err
+	}
+	if ts != nil {
+		t := time.UnixMilli(*ts)
+		di.Timestamp = &t
+	}
+	return di, nil
+}
+
+func (tl *Timeline) findIdenticalContentItems(ctx context.Context, opts FindDuplicatesOptions) ([]DuplicateCluster, error) {
+	// items with a data file are compared by its hash; text items by their text
+	// (normalized, if it was normalized on import)
+	q := `WITH keyed AS (
+			SELECT items.id,
+				CASE WHEN items.data_hash IS NOT NULL THEN 'file:' || hex(items.data_hash)
+					ELSE 'text:' || coalesce(items.normalized_text, items.data_text) END AS content_key
+			FROM items
+			LEFT JOIN data_sources ON data_sources.id = items.data_source_id
+			WHERE items.deleted IS NULL
+				AND (items.data_hash IS NOT NULL OR items.data_text IS NOT NULL)`
+	var args []any
+	if opts.DataSourceName != "" {
+		q += ` AND data_sources.name=?`
+		args = append(args, opts.DataSourceName)
+	}
+	q += `)
+		SELECT ` + duplicateItemColumns + `, keyed.content_key
+		FROM keyed
+		JOIN items ON items.id = keyed.id
+		LEFT JOIN data_sources ON data_sources.id = items.data_source_id
+		WHERE keyed.content_key IN (SELECT content_key FROM keyed GROUP BY content_key HAVING count() > 1)
+		ORDER BY keyed.content_key, items.id`
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying items with identical content: %v", err)
+	}
+	defer rows.Close()
+
+	var clusters []DuplicateCluster
+	var lastKey string
+	for rows.Next() {
+		var contentKey string
+		di, err := scanDuplicateItem(rows, &contentKey)
+		if err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		if contentKey != lastKey || len(clusters) == 0 {
+			if opts.Limit > 0 && len(clusters) == opts.Limit {
+				break
+			}
+			clusters = append(clusters, DuplicateCluster{Key: duplicateContentKey(contentKey)})
+			lastKey = contentKey
+		}
+		clusters[len(clusters)-1].Items = append(clusters[len(clusters)-1].Items, di)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return clusters, nil
+}
+
+// duplicateContentKey returns a presentable form of a content key from
+// findIdenticalContentItems; text is hashed since it can be long.
+func duplicateContentKey(contentKey string) string {
+	if text, ok := strings.CutPrefix(contentKey, "text:"); ok {
+		h := newHash()
+		_, _ = h.Write([]byte(text))
+		return "text:" + hex.EncodeToString(h.Sum(nil))
+	}
+	return strings.ToLower(contentKey)
+}
+
+func (tl *Timeline) findSimilarTextItems(ctx context.Context, opts FindDuplicatesOptions) ([]DuplicateCluster, error) {
+	minSimilarity := opts.MinSimilarity
+	if minSimilarity == 0 {
+		minSimilarity = 0.8
+	}
+
+	q := `SELECT ` + duplicateItemColumns + `, coalesce(items.normalized_text, items.data_text)
+		FROM items
+		LEFT JOIN data_sources ON data_sources.id = items.data_source_id
+		WHERE items.deleted IS NULL
+			AND items.timestamp IS NOT NULL
+			AND items.data_text IS NOT NULL`
+	var args []any
+	if opts.DataSourceName != "" {
+		q += ` AND data_sources.name=?`
+		args = append(args, opts.DataSourceName)
+	}
+	q += ` ORDER BY items.timestamp, items.id`
+
+	type textItem struct {
+		DuplicateItem
+		words map[string]struct{}
+	}
+	var candidates []textItem
+
+	// read all the candidates before comparing them so we don't hold the lock longer than needed
+	err := func() error {
+		tl.dbMu.RLock()
+		defer tl.dbMu.RUnlock()
+
+		rows, err := tl.db.QueryContext(ctx, q, args...)
+		if err != nil {
+			return fmt.Errorf("querying text items: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var text string
+			di, err := scanDuplicateItem(rows, &text)
+			if err != nil {
+				return fmt.Errorf("scanning item: %v", err)
+			}
+			candidates = append(candidates, textItem{di, wordSet(text)})
+		}
+		return rows.Err()
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	// candidates are sorted by timestamp, so we only need to compare
+	// each item with the ones that follow it within the tolerance;
+	// similar items are joined into clusters with a union-find
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for j := i + 1; j < len(candidates); j++ {
+			if candidates[j].Timestamp.Sub(*candidates[i].Timestamp) > opts.TimeTolerance {
+				break
+			}
+			if textSimilarity(candidates[i].words, candidates[j].words) >= minSimilarity {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	var clusters []DuplicateCluster
+	clusterIndex := make(map[int]int)
+	for i, c := range candidates {
+		root := find(i)
+		idx, ok := clusterIndex[root]
+		if !ok {
+			idx = len(clusters)
+			clusterIndex[root] = idx
+			clusters = append(clusters, DuplicateCluster{Key: fmt.Sprintf("similar:%d", candidates[root].ID)})
+		}
+		clusters[idx].Items = append(clusters[idx].Items, c.DuplicateItem)
+	}
+	clusters = slices.DeleteFunc(clusters, func(c DuplicateCluster) bool { return len(c.Items) < 2 })
+	if opts.Limit > 0 && len(clusters) > opts.Limit {
+		clusters = clusters[:opts.Limit]
+	}
+
+	return clusters, nil
+}
+
+// wordSet returns the set of words in s, ignoring case and punctuation.
+func wordSet(s string) map[string]struct{} {
+	words := make(map[string]struct{})
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		words[w] = struct{}{}
+	}
+	return words
+}
+
+// textSimilarity returns the Jaccard similarity of two sets of words.
+func textSimilarity(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	var common int
+	for w := range a {
+		if _, ok := b[w]; ok {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// MergeItems resolves a cluster of duplicate items (as returned by FindDuplicateItems)
+// by keeping the item with ID keepID and deleting the others. Relationships, tags,
+// curations, and import records that refer to the deleted items are moved to the
+// item that is kept, unless it already has an equivalent one.
+func (tl *Timeline) MergeItems(ctx context.Context, cluster DuplicateCluster, keepID int64) error {
+	if err := tl.checkWritable("merge items"); err != nil {
+		return err
+	}
+
+	itemIDs := cluster.itemIDs()
+	if !slices.Contains(itemIDs, keepID) {
+		return fmt.Errorf("item to keep (%d) is not in the cluster", keepID)
+	}
+	var mergeIDs []int64
+	for _, id := range itemIDs {
+		if id <= 0 {
+			return fmt.Errorf("items to merge must have IDs greater than 0 (%d)", id)
+		}
+		if id != keepID && !slices.Contains(mergeIDs, id) {
+			mergeIDs = append(mergeIDs, id)
+		}
+	}
+	if len(mergeIDs) == 0 {
+		return nil
+	}
+
+	err := func() error {
+		tl.dbMu.Lock()
+		defer tl.dbMu.Unlock()
+
+		tx, err := tl.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("beginning transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		for _, mergeID := range mergeIDs {
+			// "OR IGNORE" leaves rows that would violate uniqueness constraints (because
+			// the item to keep already has them) to be deleted along with the merged item
+			for _, stmt := range []string{
+				`UPDATE OR IGNORE relationships SET from_item_id=? WHERE from_item_id=?`,
+				`UPDATE OR IGNORE relationships SET to_item_id=? WHERE to_item_id=?`,
+				`UPDATE OR IGNORE curation_elements SET item_id=? WHERE item_id=?`,
+				`UPDATE OR IGNORE tagged SET item_id=? WHERE item_id=?`,
+				`UPDATE OR IGNORE import_items SET item_id=? WHERE item_id=?`,
+			} {
+				if _, err := tx.ExecContext(ctx, stmt, keepID, mergeID); err != nil {
+					return fmt.Errorf("moving references from item %d to item %d: %v", mergeID, keepID, err)
+				}
+			}
+		}
+
+		// if the duplicates were related to each other, they are now related to themselves
+		if _, err := tx.ExecContext(ctx, `DELETE FROM relationships WHERE from_item_id=? AND to_item_id=?`, keepID, keepID); err != nil {
+			return fmt.Errorf("deleting relationships of item to itself: %v", err)
+		}
+
+		return tx.Commit()
+	}()
+	if err != nil {
+		return err
+	}
+
+	Log.Info("merging duplicate items",
+		zap.Int64("keep_item_id", keepID),
+		zap.Int64s("merged_item_ids", mergeIDs))
+
+	_, err = tl.deleteItemRows(ctx, mergeIDs, false, nil)
+	return err
+}
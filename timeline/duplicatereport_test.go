@@ -0,0 +1,178 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testTextItem(id, text string, ts time.Time) *Item {
+	return &Item{
+		ID:             id,
+		Classification: ClassMessage,
+		Timestamp:      ts,
+		Content:        ItemData{Data: StringData(text)},
+	}
+}
+
+func TestFindDuplicateItemsByContent(t *testing.T) {
+	tl := newTestTimeline(t)
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	importTestItems(t, tl,
+		testTextItem("a", "see you at noon", ts),
+		testTextItem("b", "see you at noon", ts.Add(time.Hour)),
+		testTextItem("c", "something else", ts.Add(2*time.Hour)),
+		testFileItem("f1", ts),
+		testFileItem("f2", ts.Add(time.Hour)),
+	)
+
+	clusters, err := tl.FindDuplicateItems(context.Background(), FindDuplicatesOptions{})
+	if err != nil {
+		t.Fatalf("finding duplicates: %v", err)
+	}
+	// the two messages have identical text; the two files do not have identical contents
+	if len(clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %d: %+v", len(clusters), clusters)
+	}
+	cluster := clusters[0]
+	if len(cluster.Items) != 2 {
+		t.Fatalf("expected 2 items in cluster, got %+v", cluster.Items)
+	}
+	// items are processed concurrently, so their row IDs (and thus order) can vary
+	var originalIDs []string
+	for i, di := range cluster.Items {
+		if di.OriginalID != nil {
+			originalIDs = append(originalIDs, *di.OriginalID)
+		}
+		if di.DataSourceName == nil || *di.DataSourceName != testDataSourceName {
+			t.Errorf("item %d: expected data source %q, got %v", i, testDataSourceName, di.DataSourceName)
+		}
+	}
+	slices.Sort(originalIDs)
+	if !slices.Equal(originalIDs, []string{"a", "b"}) {
+		t.Errorf("expected items a and b to be clustered, got %v", originalIDs)
+	}
+
+	// finding duplicates must not modify anything
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE deleted IS NULL`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("expected 5 items after finding duplicates, got %d", count)
+	}
+}
+
+func TestFindDuplicateItemsByTimestampText(t *testing.T) {
+	tl := newTestTimeline(t)
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	importTestItems(t, tl,
+		testTextItem("a", "Running late, see you at noon!", ts),
+		testTextItem("b", "running late see you at noon", ts.Add(time.Second)),
+		testTextItem("c", "running late see you at noon", ts.Add(time.Hour)),
+		testTextItem("d", "completely different words here", ts.Add(time.Second)),
+	)
+
+	clusters, err := tl.FindDuplicateItems(context.Background(), FindDuplicatesOptions{
+		Criteria:      DuplicatesByTimestampText,
+		TimeTolerance: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("finding duplicates: %v", err)
+	}
+	if len(clusters) != 1 || len(clusters[0].Items) != 2 {
+		t.Fatalf("expected 1 cluster of 2 items, got %+v", clusters)
+	}
+	originalIDs := []string{*clusters[0].Items[0].OriginalID, *clusters[0].Items[1].OriginalID}
+	slices.Sort(originalIDs)
+	if !slices.Equal(originalIDs, []string{"a", "b"}) {
+		t.Errorf("expected items a and b to be clustered, got %v", originalIDs)
+	}
+}
+
+func TestMergeItems(t *testing.T) {
+	tl := newTestTimeline(t)
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testTextItem("keep", "duplicate text", ts)}
+		itemChan <- &Graph{Item: testTextItem("reply", "a reply", ts.Add(2*time.Hour)), Edges: []Relationship{
+			{Relation: RelReply, To: &Graph{Item: testTextItem("dupe", "duplicate text", ts.Add(time.Hour))}},
+		}}
+		return nil
+	}
+	if err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+	}); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	clusters, err := tl.FindDuplicateItems(context.Background(), FindDuplicatesOptions{})
+	if err != nil {
+		t.Fatalf("finding duplicates: %v", err)
+	}
+	if len(clusters) != 1 || len(clusters[0].Items) != 2 {
+		t.Fatalf("expected 1 cluster of 2 items, got %+v", clusters)
+	}
+
+	var keepID, dupeID int64
+	for _, di := range clusters[0].Items {
+		switch *di.OriginalID {
+		case "keep":
+			keepID = di.ID
+		case "dupe":
+			dupeID = di.ID
+		}
+	}
+
+	if err := tl.MergeItems(context.Background(), clusters[0], 12345); err == nil {
+		t.Error("expected error when item to keep is not in the cluster")
+	}
+	if err := tl.MergeItems(context.Background(), clusters[0], keepID); err != nil {
+		t.Fatalf("merging items: %v", err)
+	}
+
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE id=?`, dupeID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("expected merged item to be deleted")
+	}
+	if err := tl.db.QueryRow(`SELECT count() FROM relationships WHERE to_item_id=?`, keepID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected relationship to be moved to the kept item, got %d relationships", count)
+	}
+
+	clusters, err = tl.FindDuplicateItems(context.Background(), FindDuplicatesOptions{})
+	if err != nil {
+		t.Fatalf("finding duplicates: %v", err)
+	}
+	if len(clusters) != 0 {
+		t.Errorf("expected no duplicates after merging, got %+v", clusters)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import "fmt"
+
+// DuplicateItemPolicy specifies what to do when a data source gives the same
+// item (by original ID) more than once within a single import, for example a
+// message that appears in two threads. This is separate from deduplicating
+// items across imports.
+//
+// Giving an item in pieces, such as a placeholder with only an ID to satisfy
+// a relationship followed by the full item, is not considered a duplicate.
+// Relationships of both occurrences are always kept.
+type DuplicateItemPolicy string
+
+const (
+	// DuplicatesMerge fills in information missing from the item with
+	// information from the duplicate. This is the default.
+	DuplicatesMerge DuplicateItemPolicy = "merge"
+
+	// DuplicatesLastWins replaces the item's information with that of
+	// the duplicate.
+	DuplicatesLastWins DuplicateItemPolicy = "last_wins"
+
+	// DuplicatesError fails processing of the duplicate (and its graph).
+	DuplicatesError DuplicateItemPolicy = "error"
+)
+
+func (dip DuplicateItemPolicy) validate() error {
+	switch dip {
+	case "", DuplicatesMerge, DuplicatesLastWins, DuplicatesError:
+		return nil
+	}
+	return fmt.Errorf("unrecognized intra-import duplicate policy: %s", dip)
+}
+
+// isIntraImportDuplicate returns true if the incoming item is a full duplicate
+// of an item that was already stored or updated by this import. Since a batch
+// is processed in a single transaction, this also finds items from earlier in
+// the same batch.
+func (p *processor) isIntraImportDuplicate(it *Item, dbItem ItemRow) bool {
+	if it.ID == "" || dbItem.OriginalID == nil || *dbItem.OriginalID != it.ID {
+		return false
+	}
+	fromThisImport := (dbItem.ImportID != nil && *dbItem.ImportID == p.impRow.id) ||
+		(dbItem.ModifiedImportID != nil && *dbItem.ModifiedImportID == p.impRow.id)
+	return fromThisImport && it.HasContent() && dbItem.hasContent()
+}
+
+// lastWinsUpdateOverrides returns the update policies that replace
+// an item with its duplicate.
+func lastWinsUpdateOverrides() map[string]fieldUpdatePolicy {
+	return map[string]fieldUpdatePolicy{
+		"data":                  updatePolicyOverwriteExisting,
+		"location":              updatePolicyOverwriteExisting,
+		"timestamp":             updatePolicyOverwriteExisting,
+		"timespan":              updatePolicyOverwriteExisting,
+		"timeframe":             updatePolicyOverwriteExisting,
+		"filename":              updatePolicyOverwriteExisting,
+		"original_location":     updatePolicyOverwriteExisting,
+		"intermediate_location": updatePolicyOverwriteExisting,
+		"metadata":              updatePolicyOverwriteExisting,
+	}
+}
@@ -0,0 +1,95 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIntraImportDuplicates(t *testing.T) {
+	ts := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		policy     DuplicateItemPolicy
+		expectText string
+	}{
+		{"", "first"},
+		{DuplicatesMerge, "first"},
+		{DuplicatesLastWins, "second"},
+		{DuplicatesError, "first"},
+	} {
+		tl := newTestTimeline(t)
+
+		// the same message appears in two threads, with different text each time
+		first := &Item{ID: "dup", Classification: ClassMessage, Timestamp: ts,
+			Content: ItemData{Data: StringData("first")}}
+		second := &Item{ID: "dup", Classification: ClassMessage, Timestamp: ts,
+			Content: ItemData{Data: StringData("second")}}
+		threadA, threadB := testMessage("thread_a", ts), testMessage("thread_b", ts)
+
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			// both occurrences are in the same graph (and thus the same batch), so they are processed in order
+			itemChan <- &Graph{Item: threadA, Edges: []Relationship{
+				{Relation: RelReply, To: &Graph{Item: first}},
+				{Relation: RelReply, To: &Graph{Item: threadB, Edges: []Relationship{
+					{Relation: RelReply, To: &Graph{Item: second}},
+				}}},
+			}}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{IntraImportDuplicates: tc.policy},
+		})
+		if err != nil {
+			t.Fatalf("policy %q: import failed: %v", tc.policy, err)
+		}
+
+		var count int
+		var text string
+		err = tl.db.QueryRow(`SELECT count(), data_text FROM items WHERE original_id='dup'`).Scan(&count, &text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("policy %q: expected duplicate to be stored once, got %d rows", tc.policy, count)
+		}
+		if text != tc.expectText {
+			t.Errorf("policy %q: expected text %q, got %q", tc.policy, tc.expectText, text)
+		}
+
+		var relationships int
+		err = tl.db.QueryRow(`SELECT count() FROM relationships
+			JOIN items ON items.id = relationships.to_item_id
+			WHERE items.original_id='dup'`).Scan(&relationships)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectRelationships := 2
+		if tc.policy == DuplicatesError {
+			expectRelationships = 1 // the duplicate failed, so it wasn't linked
+		}
+		if relationships != expectRelationships {
+			t.Errorf("policy %q: expected %d relationships to the item, got %d", tc.policy, expectRelationships, relationships)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// Passphrase is a secret that unlocks encrypted files, such as password-protected
+// zip archives. It is redacted when it is printed or encoded as JSON, so that it
+// doesn't end up in logs or in the database; it can still be decoded from JSON.
+type Passphrase string
+
+func (Passphrase) String() string   { return redactedPassphrase }
+func (Passphrase) GoString() string { return redactedPassphrase }
+
+// MarshalJSON redacts the passphrase.
+func (p Passphrase) MarshalJSON() ([]byte, error) {
+	if p == "" {
+		return json.Marshal("")
+	}
+	return json.Marshal(redactedPassphrase)
+}
+
+const redactedPassphrase = "[REDACTED]"
+
+// DecryptionError is returned when encrypted files can't be decrypted,
+// usually because the passphrase is wrong or missing.
+type DecryptionError struct {
+	Filename string
+	Err      error
+}
+
+func (e DecryptionError) Error() string {
+	return fmt.Sprintf("decrypting %s: %v", e.Filename, e.Err)
+}
+
+func (e DecryptionError) Unwrap() error { return e.Err }
+
+// errWrongPassphrase is the underlying error of a DecryptionError
+// caused by an incorrect (or missing) passphrase.
+var errWrongPassphrase = errors.New("wrong passphrase")
+
+// FileSystem is like archiver.FileSystem, but if filename is a zip archive with
+// encrypted (password-protected) files, those files are decrypted on the fly using
+// the passphrase. File importers should use this with the passphrase from their
+// ListingOptions. Only traditional PKWARE encryption is supported.
+func FileSystem(ctx context.Context, filename string, passphrase Passphrase) (fs.FS, error) {
+	encrypted, err := checkPassphrase(filename, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return archiver.FileSystem(ctx, filename)
+	}
+	return encryptedZipFS{path: filename, passphrase: passphrase}, nil
+}
+
+// checkPassphrase returns true if filename is a zip archive with encrypted files,
+// in which case it also verifies that the passphrase can decrypt them. If it can't,
+// a DecryptionError is returned. Files that are not zip archives are not an error.
+func checkPassphrase(filename string, passphrase Passphrase) (bool, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return false, nil // not a zip file (or not a file at all), so nothing to decrypt
+	}
+	defer zr.Close()
+
+	// the smallest encrypted file is the cheapest to fully decrypt; checking its
+	// contents is more reliable than checking its encryption header alone
+	var smallest *zip.File
+	for _, f := range zr.File {
+		if !isEncrypted(f) {
+			continue
+		}
+		if smallest == nil || f.CompressedSize64 < smallest.CompressedSize64 {
+			smallest = f
+		}
+	}
+	if smallest == nil {
+		return false, nil
+	}
+	if passphrase == "" {
+		return true, DecryptionError{Filename: filename, Err: fmt.Errorf("archive is encrypted: %w", errWrongPassphrase)}
+	}
+
+	rc, err := openEncryptedZipFile(smallest, passphrase)
+	if err == nil {
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+	}
+	if err != nil {
+		return true, DecryptionError{Filename: filename, Err: err}
+	}
+	return true, nil
+}
+
+// encryptedZipFS is a file system of a zip archive that decrypts encrypted files.
+// Like archiver's file systems, it opens the archive for each operation, so it
+// doesn't need to be closed.
+type encryptedZipFS struct {
+	path       string
+	passphrase Passphrase
+}
+
+func (ez encryptedZipFS) Open(name string) (fs.File, error) {
+	zr, err := zip.OpenReader(ez.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != name || !isEncrypted(f) {
+			continue
+		}
+		rc, err := openEncryptedZipFile(f, ez.passphrase)
+		if err != nil {
+			zr.Close()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return encryptedZipFile{ReadCloser: rc, info: f.FileInfo(), archive: zr}, nil
+	}
+
+	// directories and unencrypted files are handled as usual
+	file, err := zr.Open(name)
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+	if dir, ok := file.(fs.ReadDirFile); ok {
+		return encryptedZipDir{ReadDirFile: dir, archive: zr}, nil
+	}
+	return encryptedZipFile{ReadCloser: file, info: nil, archive: zr, file: file}, nil
+}
+
+// encryptedZipFile is a file in an encryptedZipFS; closing it closes the archive.
+type encryptedZipFile struct {
+	io.ReadCloser
+	info    fs.FileInfo
+	archive io.Closer
+	file    fs.File // set if the file is not encrypted
+}
+
+func (f encryptedZipFile) Stat() (fs.FileInfo, error) {
+	if f.file != nil {
+		return f.file.Stat()
+	}
+	return f.info, nil
+}
+
+func (f encryptedZipFile) Close() error {
+	err := f.ReadCloser.Close()
+	if err2 := f.archive.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// encryptedZipDir is a directory in an encryptedZipFS; closing it closes the archive.
+type encryptedZipDir struct {
+	fs.ReadDirFile
+	archive io.Closer
+}
+
+func (d encryptedZipDir) Close() error {
+	err := d.ReadDirFile.Close()
+	if err2 := d.archive.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func isEncrypted(f *zip.File) bool { return f.Flags&0x1 != 0 }
+
+// openEncryptedZipFile opens the file, which is encrypted with traditional PKWARE
+// encryption, for reading its decrypted and decompressed contents. Reading returns
+// an error wrapping errWrongPassphrase if the contents don't match their checksum.
+func openEncryptedZipFile(f *zip.File, passphrase Passphrase) (io.ReadCloser, error) {
+	if f.Method != zip.Store && f.Method != zip.Deflate {
+		// AES-encrypted files have their own method (99)
+		return nil, fmt.Errorf("%s: unsupported encryption or compression method %d", f.Name, f.Method)
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := newZipCryptoKeys(string(passphrase))
+	var header [zipCryptoHeaderLen]byte
+	if _, err := io.ReadFull(raw, header[:]); err != nil {
+		return nil, fmt.Errorf("%s: reading encryption header: %v", f.Name, err)
+	}
+	keys.decrypt(header[:])
+
+	// the last byte of the header is a quick check of the passphrase
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&0x8 != 0 {
+		check = byte(f.ModifiedTime >> 8) //nolint:staticcheck // the MS-DOS time is what the check byte is derived from
+	}
+	if header[zipCryptoHeaderLen-1] != check {
+		return nil, fmt.Errorf("%s: %w", f.Name, errWrongPassphrase)
+	}
+
+	var rc io.ReadCloser = io.NopCloser(&zipCryptoReader{r: raw, keys: keys})
+	if f.Method == zip.Deflate {
+		rc = flate.NewReader(&zipCryptoReader{r: raw, keys: keys})
+	}
+	return &zipChecksumReader{rc: rc, f: f, hash: crc32.NewIEEE()}, nil
+}
+
+const zipCryptoHeaderLen = 12
+
+// zipCryptoKeys is the state of traditional PKWARE ("ZipCrypto") decryption.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(passphrase string) *zipCryptoKeys {
+	keys := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(passphrase); i++ {
+		keys.update(passphrase[i])
+	}
+	return keys
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32Update(k[0], b)
+	k[1] += k[0] & 0xff
+	k[1] = k[1]*134775813 + 1
+	k[2] = crc32Update(k[2], byte(k[1]>>24))
+}
+
+func (k *zipCryptoKeys) streamByte() byte {
+	temp := k[2] | 2
+	return byte((temp * (temp ^ 1)) >> 8)
+}
+
+func (k *zipCryptoKeys) decrypt(buf []byte) {
+	for i := range buf {
+		buf[i] ^= k.streamByte()
+		k.update(buf[i])
+	}
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[(crc^uint32(b))&0xff] ^ (crc >> 8)
+}
+
+// zipCryptoReader decrypts what it reads.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+func (zr *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	zr.keys.decrypt(p[:n])
+	return n, err
+}
+
+// zipChecksumReader verifies the size and checksum of the file when it reaches
+// the end, since decrypting with the wrong passphrase yields garbage.
+type zipChecksumReader struct {
+	rc   io.ReadCloser
+	f    *zip.File
+	hash hash.Hash32
+	n    uint64
+}
+
+func (zr *zipChecksumReader) Read(p []byte) (int, error) {
+	n, err := zr.rc.Read(p)
+	zr.hash.Write(p[:n])
+	zr.n += uint64(n)
+	if errors.Is(err, io.EOF) {
+		if zr.n != zr.f.UncompressedSize64 || zr.hash.Sum32() != zr.f.CRC32 {
+			return n, fmt.Errorf("%s: %w (checksum mismatch)", zr.f.Name, errWrongPassphrase)
+		}
+	} else if corrupt := flate.CorruptInputError(0); errors.As(err, &corrupt) {
+		// decompressing garbage usually fails before the checksum can be checked
+		return n, fmt.Errorf("%s: %w (%v)", zr.f.Name, errWrongPassphrase, err)
+	}
+	return n, err
+}
+
+func (zr *zipChecksumReader) Close() error { return zr.rc.Close() }
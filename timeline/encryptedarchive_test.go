@@ -0,0 +1,182 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeEncryptedZip writes a zip archive with the files encrypted
+// using traditional PKWARE encryption, like `zip -P` does.
+func writeEncryptedZip(t *testing.T, passphrase string, files map[string]string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "encrypted.zip")
+	out, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	for name, contents := range files {
+		crc := crc32.ChecksumIEEE([]byte(contents))
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               name,
+			Method:             zip.Store,
+			Flags:              0x1,
+			CRC32:              crc,
+			CompressedSize64:   uint64(zipCryptoHeaderLen + len(contents)),
+			UncompressedSize64: uint64(len(contents)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := make([]byte, zipCryptoHeaderLen)
+		header[zipCryptoHeaderLen-1] = byte(crc >> 24)
+		plaintext := append(header, contents...)
+
+		keys := newZipCryptoKeys(passphrase)
+		ciphertext := make([]byte, len(plaintext))
+		for i, b := range plaintext {
+			ciphertext[i] = b ^ keys.streamByte()
+			keys.update(b)
+		}
+		if _, err := w.Write(ciphertext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestImportEncryptedArchive(t *testing.T) {
+	archive := writeEncryptedZip(t, "correct horse", map[string]string{
+		"one.txt": "first secret message",
+		"two.txt": "second secret message",
+	})
+
+	importArchive := func(ctx context.Context, filenames []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		fsys, err := FileSystem(ctx, filenames[0], opt.Passphrase)
+		if err != nil {
+			return err
+		}
+		ts := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+		for i, name := range []string{"one.txt", "two.txt"} {
+			contents, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return err
+			}
+			itemChan <- &Graph{Item: &Item{
+				ID:             name,
+				Classification: ClassMessage,
+				Timestamp:      ts.Add(time.Duration(i) * time.Minute),
+				Content:        ItemData{Data: StringData(string(contents))},
+			}}
+		}
+		return nil
+	}
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		tl := newTestTimeline(t)
+		testFileImport = importArchive
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{archive},
+			Passphrase:     "battery staple",
+		})
+		var decErr DecryptionError
+		if !errors.As(err, &decErr) {
+			t.Fatalf("expected DecryptionError, got: %v", err)
+		}
+		var imports int
+		if err := tl.db.QueryRow(`SELECT count() FROM imports`).Scan(&imports); err != nil {
+			t.Fatal(err)
+		}
+		if imports != 0 {
+			t.Errorf("expected no import to be started, but %d were", imports)
+		}
+	})
+
+	t.Run("correct passphrase", func(t *testing.T) {
+		tl := newTestTimeline(t)
+		testFileImport = importArchive
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{archive},
+			Passphrase:     "correct horse",
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		for id, expect := range map[string]string{"one.txt": "first secret message", "two.txt": "second secret message"} {
+			var text string
+			if err := tl.db.QueryRow(`SELECT data_text FROM items WHERE original_id=?`, id).Scan(&text); err != nil {
+				t.Fatalf("querying item %s: %v", id, err)
+			}
+			if text != expect {
+				t.Errorf("item %s: expected %q, got %q", id, expect, text)
+			}
+		}
+
+		var procOpt, checkpoint *string
+		if err := tl.db.QueryRow(`SELECT processing_options, checkpoint FROM imports LIMIT 1`).Scan(&procOpt, &checkpoint); err != nil {
+			t.Fatal(err)
+		}
+		for _, stored := range []*string{procOpt, checkpoint} {
+			if stored != nil && strings.Contains(*stored, "correct horse") {
+				t.Errorf("passphrase was stored with the import: %s", *stored)
+			}
+		}
+	})
+}
+
+func TestPassphraseIsRedacted(t *testing.T) {
+	params := ImportParameters{DataSourceName: testDataSourceName, Passphrase: "hunter2"}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{string(encoded), fmt.Sprintf("%v", params), fmt.Sprintf("%+v", params), fmt.Sprintf("%#v", params)} {
+		if strings.Contains(s, "hunter2") {
+			t.Errorf("passphrase was not redacted: %s", s)
+		}
+	}
+
+	var decoded ImportParameters
+	if err := json.Unmarshal([]byte(`{"passphrase":"hunter2"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Passphrase != "hunter2" {
+		t.Errorf("expected passphrase to be decoded, got %q", string(decoded.Passphrase))
+	}
+}
@@ -480,9 +480,9 @@ func (p *processor) processEntity(ctx context.Context, tx *sql.Tx, in Entity) (l
 
 		// if we could not find a person by any of those identities, add new person to DB
 		err = tx.QueryRowContext(ctx, `INSERT INTO entities
-				(type_id, import_id, name, metadata) VALUES (?, ?, ?, ?)
+				(type_id, import_id, name, name_import_id, metadata) VALUES (?, ?, ?, ?, ?)
 				RETURNING id`,
-			in.typeID, p.impRow.id, in.dbName(), metadata).Scan(&in.ID)
+			in.typeID, p.impRow.id, in.dbName(), p.impRow.id, metadata).Scan(&in.ID)
 		if err != nil {
 			return latentID{}, fmt.Errorf("adding new person %+v: %v", in, err)
 		}
@@ -494,7 +494,7 @@ func (p *processor) processEntity(ctx context.Context, tx *sql.Tx, in Entity) (l
 				zap.Int64("entity_id", in.ID),
 				zap.Error(err))
 		} else if pictureFile != "" {
-			_, err = tx.Exec(`UPDATE entities SET picture_file=? WHERE id=?`, pictureFile, in.ID) // TODO: LIMIT 1, if ever implemented
+			_, err = tx.Exec(`UPDATE entities SET picture_file=?, picture_import_id=? WHERE id=?`, pictureFile, p.impRow.id, in.ID) // TODO: LIMIT 1, if ever implemented
 			if err != nil {
 				p.log.Error("updating new entity's profile picture in DB",
 					zap.Int64("entity_id", in.ID),
@@ -516,43 +516,9 @@ func (p *processor) processEntity(ctx context.Context, tx *sql.Tx, in Entity) (l
 		// specificity from data sources, and we have to rely on the user to maintain that
 		// information because only they'd know)
 
-		entity := entities[0]
-
 		// update entity with the latest info from the incoming entity
-		var setClause string
-		var args []any
-		// TODO: I used to have "|| (len(in.Name) > len(entity.Name))" as a condition, hoping that it would add missing information (such as a last name to a first name) but when testing with Josh it replaced his name with "Synology NAS Diskstation..." gibberish... anyway...
-		if entity.Name == "" && in.Name != "" {
-			if setClause != "" {
-				setClause += ", "
-			}
-			setClause += "name=?"
-			args = append(args, in.Name)
-		}
-		if entity.Picture == nil && in.NewPicture != nil {
-			// we don't update existing profile pictures at this time... but we can set the picture if one doesn't already exist
-			in.ID = entity.ID
-			pictureFile, err := p.processEntityPicture(ctx, in)
-			if err != nil {
-				p.log.Error("saving existing entity's new profile picture",
-					zap.Int64("entity_id", entity.ID),
-					zap.Error(err))
-			} else if pictureFile != "" {
-				if setClause != "" {
-					setClause += ", "
-				}
-				setClause += "picture_file=?"
-				args = append(args, pictureFile)
-			}
-		}
-		setClause = strings.TrimSuffix(setClause, ", ")
-
-		if len(args) > 0 {
-			args = append(args, entity.ID)
-			_, err := tx.ExecContext(ctx, `UPDATE entities SET `+setClause+` WHERE id=?`, args...)
-			if err != nil {
-				return latentID{}, fmt.Errorf("updating person %d (%+v): %v", entity.ID, in, err)
-			}
+		if err := p.mergeEntityInfo(ctx, tx, entities[0], in); err != nil {
+			return latentID{}, err
 		}
 	}
 
@@ -750,6 +716,10 @@ func storeAttribute(ctx context.Context, tx *sql.Tx, attr Attribute) (int64, err
 }
 
 func (tl *Timeline) MergeEntities(ctx context.Context, entityIDToKeep int64, entityIDsToMerge []int64) error {
+	if err := tl.checkWritable("merge entities"); err != nil {
+		return err
+	}
+
 	// input verification / sanity checks, as well as loading entity information
 	if entityIDToKeep <= 0 {
 		return fmt.Errorf("entity to keep must have an ID greater than 0")
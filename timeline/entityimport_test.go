@@ -0,0 +1,105 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestImportEntitiesThenItems(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	// a contact list: only entities, no items
+	contacts := []*Entity{
+		{Name: "Alice", Attributes: []Attribute{{Name: AttributeEmail, Value: "alice@example.com", Identifying: true}}},
+		{Name: "Bob", Attributes: []Attribute{{Name: AttributePhoneNumber, Value: "+12025550123", Identifying: true}}},
+	}
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		for _, e := range contacts {
+			itemChan <- &Graph{Entity: e}
+		}
+		return nil
+	}
+	var status ImportStatus
+	err := tl.Import(ctx, ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		ProgressFunc:   func(st ImportStatus) { status = st },
+	})
+	if err != nil {
+		t.Fatalf("importing contacts: %v", err)
+	}
+	if status.EntityCount != 2 || status.NewEntityCount != 2 || status.ItemCount != 0 {
+		t.Errorf("expected 2 new entities and no items, got %+v", status)
+	}
+	var itemCount int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&itemCount); err != nil {
+		t.Fatal(err)
+	}
+	if itemCount != 0 {
+		t.Errorf("expected contacts import to create no items, got %d", itemCount)
+	}
+	aliceID := entityIDByName(t, tl, "Alice")
+	bobID := entityIDByName(t, tl, "Bob")
+
+	// then items from the same people, identified only by email or phone
+	ts := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	fromAlice := testMessage("from_alice", ts)
+	fromAlice.Owner = Entity{Attributes: []Attribute{{Name: AttributeEmail, Value: "Alice@Example.com", Identifying: true}}}
+	fromBob := testMessage("from_bob", ts.Add(time.Minute))
+	fromBob.Owner = Entity{Attributes: []Attribute{{Name: AttributePhoneNumber, Value: "+1 202-555-0123", Identifying: true}}}
+	status = ImportStatus{}
+	importTestItemsWithParams(t, tl, ImportParameters{ProgressFunc: func(st ImportStatus) { status = st }}, fromAlice, fromBob)
+	if status.NewEntityCount != 0 {
+		t.Errorf("expected items to link to the imported contacts, but %d new entities were created", status.NewEntityCount)
+	}
+
+	for _, tc := range []struct {
+		itemID   string
+		entityID int64
+	}{
+		{"from_alice", aliceID},
+		{"from_bob", bobID},
+	} {
+		var ownerID int64
+		err := tl.db.QueryRow(`SELECT entity_attributes.entity_id
+			FROM items
+			JOIN entity_attributes ON entity_attributes.attribute_id = items.attribute_id
+			WHERE items.original_id=?
+			LIMIT 1`, tc.itemID).Scan(&ownerID)
+		if err != nil {
+			t.Fatalf("%s: loading owner: %v", tc.itemID, err)
+		}
+		if ownerID != tc.entityID {
+			t.Errorf("%s: expected owner to be entity %d, got %d", tc.itemID, tc.entityID, ownerID)
+		}
+	}
+}
+
+func entityIDByName(t *testing.T, tl *Timeline, name string) int64 {
+	t.Helper()
+	var id int64
+	if err := tl.db.QueryRow(`SELECT id FROM entities WHERE name=? LIMIT 1`, name).Scan(&id); err != nil {
+		t.Fatalf("loading entity %s: %v", name, err)
+	}
+	return id
+}
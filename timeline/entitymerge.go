@@ -0,0 +1,233 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// AttributeDisplayName is the attribute by which the names an entity goes by
+// are kept when sources disagree on its name. The entity's name is just one
+// of them, chosen according to the EntityMergePolicy.
+const AttributeDisplayName = "display_name"
+
+// EntityMergePolicy specifies how to resolve conflicting information when an
+// incoming entity matches one already in the timeline, for example when two
+// data sources know the same person by different names or pictures.
+//
+// Attributes (email addresses, phone numbers, etc.) are multi-valued, so they
+// always accumulate; each link between an entity and an attribute records the
+// import it came from. The policy applies to the entity's name and picture,
+// of which there is only one. Conflicting names are also kept as
+// AttributeDisplayName attributes of the entity, whichever one wins.
+type EntityMergePolicy struct {
+	// How to choose between the existing and incoming name and picture.
+	// Default: accumulate.
+	Strategy EntityMergeStrategy `json:"strategy,omitempty"`
+
+	// For the preferred source strategy, the names of data sources in
+	// order of preference (most preferred first). Data sources that are
+	// not listed are least preferred.
+	PreferredSources []string `json:"preferred_sources,omitempty"`
+}
+
+// EntityMergeStrategy is a way to choose between conflicting values.
+type EntityMergeStrategy string
+
+const (
+	// EntityMergeAccumulate keeps the existing name and picture, only
+	// filling them in if they are missing. This is the default.
+	EntityMergeAccumulate EntityMergeStrategy = "accumulate"
+
+	// EntityMergeNewestWins replaces the existing name and picture
+	// with the incoming ones.
+	EntityMergeNewestWins EntityMergeStrategy = "newest_wins"
+
+	// EntityMergePreferredSource replaces the existing name and picture
+	// with the incoming ones only if the incoming data source is at least
+	// as preferred as the one that provided the existing value.
+	EntityMergePreferredSource EntityMergeStrategy = "preferred_source"
+)
+
+func (emp EntityMergePolicy) validate() error {
+	switch emp.Strategy {
+	case "", EntityMergeAccumulate, EntityMergeNewestWins:
+	case EntityMergePreferredSource:
+		if len(emp.PreferredSources) == 0 {
+			return errors.New("preferred source entity merge strategy requires preferred sources")
+		}
+	default:
+		return fmt.Errorf("unrecognized entity merge strategy: %s", emp.Strategy)
+	}
+	return nil
+}
+
+// replaceEntityField returns true if the existing value of the entity's name
+// or picture (column) should be replaced with the incoming one, according to
+// the entity merge policy.
+func (p *processor) replaceEntityField(ctx context.Context, tx *sql.Tx, entityID int64, column string) (bool, error) {
+	policy := p.params.ProcessingOptions.EntityMerge
+	if policy == nil {
+		return false, nil
+	}
+	switch policy.Strategy {
+	case EntityMergeNewestWins:
+		return true, nil
+	case EntityMergePreferredSource:
+		existingSource, err := entityFieldSource(ctx, tx, entityID, column)
+		if err != nil {
+			return false, err
+		}
+		rank := func(dsName string) int {
+			if i := slices.Index(policy.PreferredSources, dsName); i >= 0 {
+				return i
+			}
+			return len(policy.PreferredSources)
+		}
+		incoming := rank(p.ds.Name)
+		return incoming < len(policy.PreferredSources) && incoming <= rank(existingSource), nil
+	}
+	return false, nil
+}
+
+// entityFieldSource returns the name of the data source that provided the entity's
+// current name or picture (column), or an empty string if it is not known.
+func entityFieldSource(ctx context.Context, tx *sql.Tx, entityID int64, column string) (string, error) {
+	var dsName *string
+	err := tx.QueryRowContext(ctx, `SELECT data_sources.name
+		FROM entities
+		JOIN imports ON imports.id = coalesce(entities.`+column+`_import_id, entities.import_id)
+		JOIN data_sources ON data_sources.id = imports.data_source_id
+		WHERE entities.id=?
+		LIMIT 1`, entityID).Scan(&dsName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("querying source of entity %s: %v", column, err)
+	}
+	if dsName == nil {
+		return "", nil
+	}
+	return *dsName, nil
+}
+
+// mergeEntityInfo updates the existing entity with the name and picture of the
+// incoming entity that matched it, according to the entity merge policy. The
+// provenance of the values is recorded as well.
+func (p *processor) mergeEntityInfo(ctx context.Context, tx *sql.Tx, entity, in Entity) error {
+	var setClauses []string
+	var args []any
+
+	if in.Name != "" && !strings.EqualFold(in.Name, entity.Name) {
+		replace := entity.Name == ""
+		if !replace {
+			// keep all the names the entity is known by
+			if err := linkEntityDisplayName(ctx, tx, entity.ID, entity.Name, nil); err != nil {
+				return err
+			}
+			if err := linkEntityDisplayName(ctx, tx, entity.ID, in.Name, &p.impRow.id); err != nil {
+				return err
+			}
+			var err error
+			if replace, err = p.replaceEntityField(ctx, tx, entity.ID, "name"); err != nil {
+				return err
+			}
+		}
+		if replace {
+			setClauses = append(setClauses, "name=?", "name_import_id=?")
+			args = append(args, in.Name, p.impRow.id)
+		}
+	}
+
+	if in.NewPicture != nil {
+		replace := entity.Picture == nil
+		if !replace {
+			var err error
+			if replace, err = p.replaceEntityField(ctx, tx, entity.ID, "picture"); err != nil {
+				return err
+			}
+		}
+		if replace {
+			in.ID = entity.ID
+			pictureFile, err := p.processEntityPicture(ctx, in)
+			if err != nil {
+				p.log.Error("saving existing entity's new profile picture",
+					zap.Int64("entity_id", entity.ID),
+					zap.Error(err))
+			} else if pictureFile != "" {
+				setClauses = append(setClauses, "picture_file=?", "picture_import_id=?")
+				args = append(args, pictureFile, p.impRow.id)
+
+				// the new picture may have a different file extension
+				if entity.Picture != nil && *entity.Picture != pictureFile {
+					if err := os.Remove(p.tl.FullPath(*entity.Picture)); err != nil {
+						p.log.Error("removing replaced profile picture",
+							zap.Int64("entity_id", entity.ID),
+							zap.Stringp("picture_file", entity.Picture),
+							zap.Error(err))
+					}
+				}
+			}
+		}
+	}
+
+	if len(setClauses) == 0 {
+		return nil
+	}
+	args = append(args, entity.ID)
+	_, err := tx.ExecContext(ctx, `UPDATE entities SET `+strings.Join(setClauses, ", ")+` WHERE id=?`, args...)
+	if err != nil {
+		return fmt.Errorf("updating person %d (%+v): %v", entity.ID, in, err)
+	}
+	return nil
+}
+
+// linkEntityDisplayName links the name to the entity as a display name attribute,
+// if it isn't already. The import that provided the name is recorded as its origin;
+// if importID is nil, it is the import that provided the entity's current name.
+func linkEntityDisplayName(ctx context.Context, tx *sql.Tx, entityID int64, name string, importID *int64) error {
+	attrID, err := storeAttribute(ctx, tx, Attribute{Name: AttributeDisplayName, Value: name})
+	if err != nil {
+		return err
+	}
+	if importID == nil {
+		err = tx.QueryRowContext(ctx, `SELECT coalesce(name_import_id, import_id) FROM entities WHERE id=? LIMIT 1`,
+			entityID).Scan(&importID)
+		if err != nil {
+			return fmt.Errorf("querying source of entity name: %v", err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO entity_attributes (entity_id, attribute_id, import_id)
+		SELECT ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM entity_attributes WHERE entity_id=? AND attribute_id=?)`,
+		entityID, attrID, importID, entityID, attrID)
+	if err != nil {
+		return fmt.Errorf("linking display name to entity %d: %v", entityID, err)
+	}
+	return nil
+}
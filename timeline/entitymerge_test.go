@@ -0,0 +1,171 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+)
+
+// importTestEntity imports the entity on its own with the given data source
+// and processing options, and returns the ID of the import.
+func importTestEntity(t *testing.T, tl *Timeline, dsName string, po ProcessingOptions, e Entity) int64 {
+	t.Helper()
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Entity: &e}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    dsName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: po,
+	})
+	if err != nil {
+		t.Fatalf("importing entity: %v", err)
+	}
+	imports, err := tl.ListImports(context.Background(), ListImportsParams{Limit: 1})
+	if err != nil || len(imports) != 1 {
+		t.Fatalf("loading import: %v (imports=%v)", err, imports)
+	}
+	return imports[0].ID
+}
+
+func testContact(name string) Entity {
+	return Entity{
+		Name:       name,
+		Attributes: []Attribute{{Name: AttributeEmail, Value: "alice@example.com", Identifying: true}},
+	}
+}
+
+// entityNameAndSource returns the name of the only entity with a name, and the import that provided it.
+func entityNameAndSource(t *testing.T, tl *Timeline) (string, int64) {
+	t.Helper()
+	var name string
+	var importID int64
+	err := tl.db.QueryRow(`SELECT name, coalesce(name_import_id, import_id) FROM entities WHERE name IS NOT NULL`).Scan(&name, &importID)
+	if err != nil {
+		t.Fatalf("loading entity: %v", err)
+	}
+	return name, importID
+}
+
+func TestEntityMergeAccumulate(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	first := importTestEntity(t, tl, testDataSourceName, ProcessingOptions{}, testContact("Alice Smith"))
+	second := importTestEntity(t, tl, testDataSourceName, ProcessingOptions{}, testContact("Ally"))
+
+	name, source := entityNameAndSource(t, tl)
+	if name != "Alice Smith" || source != first {
+		t.Errorf("expected existing name from import %d to be kept, got %q from import %d", first, name, source)
+	}
+
+	// both names are kept, with where they came from
+	rows, err := tl.db.Query(`SELECT attributes.value, entity_attributes.import_id
+		FROM entity_attributes
+		JOIN attributes ON attributes.id = entity_attributes.attribute_id
+		WHERE attributes.name=?`, AttributeDisplayName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	displayNames := make(map[string]int64)
+	for rows.Next() {
+		var value string
+		var importID int64
+		if err := rows.Scan(&value, &importID); err != nil {
+			t.Fatal(err)
+		}
+		displayNames[value] = importID
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(displayNames) != 2 || displayNames["Alice Smith"] != first || displayNames["Ally"] != second {
+		t.Errorf("expected display names from imports %d and %d, got %v", first, second, displayNames)
+	}
+
+	// seeing the same names again doesn't duplicate them
+	importTestEntity(t, tl, testDataSourceName, ProcessingOptions{}, testContact("Ally"))
+	var count int
+	err = tl.db.QueryRow(`SELECT count() FROM entity_attributes
+		JOIN attributes ON attributes.id = entity_attributes.attribute_id
+		WHERE attributes.name=?`, AttributeDisplayName).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 display names, got %d", count)
+	}
+}
+
+func TestEntityMergeNewestWins(t *testing.T) {
+	tl := newTestTimeline(t)
+	po := ProcessingOptions{EntityMerge: &EntityMergePolicy{Strategy: EntityMergeNewestWins}}
+
+	importTestEntity(t, tl, testDataSourceName, po, testContact("Alice Smith"))
+	second := importTestEntity(t, tl, testDataSourceName, po, testContact("Ally"))
+
+	name, source := entityNameAndSource(t, tl)
+	if name != "Ally" || source != second {
+		t.Errorf("expected newest name from import %d, got %q from import %d", second, name, source)
+	}
+
+	var entities int
+	if err := tl.db.QueryRow(`SELECT count() FROM entities WHERE name IS NOT NULL`).Scan(&entities); err != nil {
+		t.Fatal(err)
+	}
+	if entities != 1 {
+		t.Errorf("expected the contacts to be merged into 1 entity, got %d", entities)
+	}
+}
+
+func TestEntityMergePreferredSource(t *testing.T) {
+	po := ProcessingOptions{EntityMerge: &EntityMergePolicy{
+		Strategy:         EntityMergePreferredSource,
+		PreferredSources: []string{testDataSourceName},
+	}}
+
+	// the less preferred source doesn't replace the name
+	tl := newTestTimeline(t)
+	preferred := importTestEntity(t, tl, testDataSourceName, po, testContact("Alice Smith"))
+	importTestEntity(t, tl, redactTestDataSourceName, po, testContact("Ally"))
+	if name, source := entityNameAndSource(t, tl); name != "Alice Smith" || source != preferred {
+		t.Errorf("expected name from preferred source to be kept, got %q from import %d", name, source)
+	}
+
+	// but the preferred source does
+	tl = newTestTimeline(t)
+	importTestEntity(t, tl, redactTestDataSourceName, po, testContact("Ally"))
+	preferred = importTestEntity(t, tl, testDataSourceName, po, testContact("Alice Smith"))
+	if name, source := entityNameAndSource(t, tl); name != "Alice Smith" || source != preferred {
+		t.Errorf("expected name from preferred source to replace existing, got %q from import %d", name, source)
+	}
+}
+
+func TestEntityMergePolicyValidation(t *testing.T) {
+	for _, emp := range []EntityMergePolicy{
+		{Strategy: "bogus"},
+		{Strategy: EntityMergePreferredSource},
+	} {
+		if err := emp.validate(); err == nil {
+			t.Errorf("expected error for entity merge policy %+v", emp)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrEstimateUnsupported is returned when a data source is not able
+// to estimate an import.
+var ErrEstimateUnsupported = errors.New("data source does not support estimating imports")
+
+// ImportEstimate describes the approximate size of an import before it
+// is performed. Zero values mean the data source could not determine
+// that particular value.
+type ImportEstimate struct {
+	ItemCount  int64      `json:"item_count,omitempty"`
+	TotalBytes int64      `json:"total_bytes,omitempty"`
+	Earliest   *time.Time `json:"earliest,omitempty"`
+	Latest     *time.Time `json:"latest,omitempty"`
+}
+
+// EstimateImport asks the data source to estimate how much would be imported
+// with the given parameters, without importing anything. If the data source
+// can't estimate imports, an error wrapping ErrEstimateUnsupported is returned.
+func (t *Timeline) EstimateImport(ctx context.Context, params ImportParameters) (ImportEstimate, error) {
+	if params.ResumeImportID > 0 {
+		return ImportEstimate{}, fmt.Errorf("cannot estimate a resumed import")
+	}
+
+	ds, ok := lookupDataSource(params.DataSourceName)
+	if !ok {
+		return ImportEstimate{}, fmt.Errorf("unknown data source: %s", params.DataSourceName)
+	}
+	if len(params.Filenames) > 0 && ds.NewFileImporter == nil {
+		return ImportEstimate{}, fmt.Errorf("data source %s does not support importing from files", ds.Name)
+	}
+	if len(params.Filenames) == 0 && ds.NewAPIImporter == nil {
+		return ImportEstimate{}, fmt.Errorf("data source %s does not support importing via API", ds.Name)
+	}
+	if ds.EstimateImport == nil {
+		return ImportEstimate{}, fmt.Errorf("%s: %w", ds.Name, ErrEstimateUnsupported)
+	}
+
+	if err := ds.validateOptions(params.DataSourceOptions); err != nil {
+		return ImportEstimate{}, err
+	}
+	dsOpt, err := ds.UnmarshalOptions(params.DataSourceOptions)
+	if err != nil {
+		return ImportEstimate{}, err
+	}
+
+	var acc *Account
+	if params.AccountID > 0 {
+		loaded, err := t.LoadAccount(ctx, params.AccountID)
+		if err != nil {
+			return ImportEstimate{}, err
+		}
+		acc = &loaded
+	}
+
+	est, err := ds.EstimateImport(ctx, params.Filenames, acc, dsOpt)
+	if err != nil {
+		return ImportEstimate{}, fmt.Errorf("%s: estimating import: %w", ds.Name, err)
+	}
+	return est, nil
+}
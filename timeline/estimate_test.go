@@ -0,0 +1,85 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEstimateImport(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	earliest := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	latest := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	name := fmt.Sprintf("estimating_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:            name,
+		Title:           "Estimating test",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+		EstimateImport: func(_ context.Context, filenames []string, acc *Account, _ any) (ImportEstimate, error) {
+			if acc != nil {
+				return ImportEstimate{}, errors.New("unexpected account for file import")
+			}
+			return ImportEstimate{
+				ItemCount:  int64(len(filenames)) * 10,
+				TotalBytes: 1024,
+				Earliest:   &earliest,
+				Latest:     &latest,
+			}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	est, err := tl.EstimateImport(context.Background(), ImportParameters{
+		DataSourceName: name,
+		Filenames:      []string{"a", "b"},
+	})
+	if err != nil {
+		t.Fatalf("estimating import: %v", err)
+	}
+	if est.ItemCount != 20 || est.TotalBytes != 1024 ||
+		est.Earliest == nil || !est.Earliest.Equal(earliest) ||
+		est.Latest == nil || !est.Latest.Equal(latest) {
+		t.Errorf("unexpected estimate: %+v", est)
+	}
+
+	// nothing should have been imported
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM imports`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected no imports after estimating, got %d", count)
+	}
+
+	// data sources without an estimator report that it's unsupported
+	_, err = tl.EstimateImport(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"a"},
+	})
+	if !errors.Is(err, ErrEstimateUnsupported) {
+		t.Errorf("expected ErrEstimateUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// EventTopic is the kind of an event that can be subscribed to.
+type EventTopic string
+
+const (
+	// Items were inserted by an import (published after each batch is committed).
+	TopicItemInserted EventTopic = "item-inserted"
+
+	// Existing items were updated by an import (published after each batch is committed),
+	// or by a bulk edit such as ShiftTimestamps.
+	TopicItemUpdated EventTopic = "item-updated"
+
+	// Items were deleted (or marked for deletion).
+	TopicItemDeleted EventTopic = "item-deleted"
+
+	// An import finished, successfully or not.
+	TopicImportFinished EventTopic = "import-finished"
+
+	// Items were found to be missing their thumbnail when they were read
+	// (see ItemSearchParams.HealThumbnails); the timeline generates them.
+	TopicThumbnailNeeded EventTopic = "thumbnail-needed"
+)
+
+// Event describes something that happened in the timeline.
+type Event struct {
+	Topic EventTopic `json:"topic"`
+
+	// The import the event belongs to, if any.
+	ImportID int64 `json:"import_id,omitempty"`
+
+	// The row IDs of the items, for item events.
+	ItemIDs []int64 `json:"item_ids,omitempty"`
+
+	// The status of the import, for import-finished events.
+	ImportStatus string `json:"import_status,omitempty"`
+}
+
+// EventHandler handles an event. An error is logged; it does not affect
+// whatever caused the event.
+type EventHandler func(ctx context.Context, ev Event) error
+
+const (
+	// eventWorkers is how many event handlers can run at the same time.
+	eventWorkers = 4
+
+	// eventQueueSize is how many events can wait to be handled before
+	// new ones are dropped (publishing never blocks).
+	eventQueueSize = 1024
+)
+
+// eventBus delivers events to subscribers using a bounded pool of workers.
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers map[EventTopic]map[*eventSubscription]struct{}
+	queue       chan eventDelivery
+	startOnce   sync.Once
+}
+
+type eventSubscription struct {
+	handler EventHandler
+}
+
+type eventDelivery struct {
+	sub *eventSubscription
+	ev  Event
+}
+
+// Subscribe calls handler for every event of the topic until the returned
+// function is called. Handlers run in a small pool of goroutines, so they
+// should not take long; they may run concurrently with each other and with
+// whatever caused the event, and events may be dropped if handlers can't
+// keep up. Publishing events never blocks imports or other operations.
+func (tl *Timeline) Subscribe(topic EventTopic, handler EventHandler) (unsubscribe func()) {
+	eb := &tl.events
+	eb.startOnce.Do(func() {
+		eb.queue = make(chan eventDelivery, eventQueueSize)
+		for i := 0; i < eventWorkers; i++ {
+			go tl.handleEvents()
+		}
+	})
+
+	sub := &eventSubscription{handler: handler}
+	eb.mu.Lock()
+	if eb.subscribers == nil {
+		eb.subscribers = make(map[EventTopic]map[*eventSubscription]struct{})
+	}
+	if eb.subscribers[topic] == nil {
+		eb.subscribers[topic] = make(map[*eventSubscription]struct{})
+	}
+	eb.subscribers[topic][sub] = struct{}{}
+	eb.mu.Unlock()
+
+	return func() {
+		eb.mu.Lock()
+		delete(eb.subscribers[topic], sub)
+		eb.mu.Unlock()
+	}
+}
+
+// publish queues ev for delivery to its subscribers, if any. It never blocks;
+// if the queue is full, the event is dropped for that subscriber.
+func (tl *Timeline) publish(ev Event) {
+	eb := &tl.events
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	for sub := range eb.subscribers[ev.Topic] {
+		select {
+		case eb.queue <- eventDelivery{sub: sub, ev: ev}:
+		default:
+			Log.Named("events").Warn("event queue is full; dropping event",
+				zap.String("topic", string(ev.Topic)),
+				zap.Int64("import_id", ev.ImportID),
+				zap.Int("item_count", len(ev.ItemIDs)))
+		}
+	}
+}
+
+// handleEvents runs event handlers until the timeline is closed.
+func (tl *Timeline) handleEvents() {
+	logger := Log.Named("events")
+	for {
+		select {
+		case <-tl.ctx.Done():
+			return
+		case d := <-tl.events.queue:
+			if err := tl.handleEvent(d); err != nil {
+				logger.Error("event handler",
+					zap.String("topic", string(d.ev.Topic)),
+					zap.Int64("import_id", d.ev.ImportID),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+func (tl *Timeline) handleEvent(d eventDelivery) (err error) {
+	// a misbehaving handler shouldn't take down the other handlers
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return d.sub.handler(tl.ctx, d.ev)
+}
@@ -0,0 +1,139 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSubscribersReceiveEvents(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	events := make(chan Event, 100)
+	for _, topic := range []EventTopic{TopicItemInserted, TopicItemUpdated, TopicItemDeleted, TopicImportFinished} {
+		tl.Subscribe(topic, func(_ context.Context, ev Event) error {
+			events <- ev
+			return nil
+		})
+	}
+
+	// a failing handler must not affect the import or other handlers
+	tl.Subscribe(TopicItemInserted, func(context.Context, Event) error {
+		return errors.New("oops")
+	})
+	tl.Subscribe(TopicItemInserted, func(context.Context, Event) error {
+		panic("oops")
+	})
+
+	next := func(topic EventTopic) Event {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Topic == topic {
+					return ev
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s event", topic)
+			}
+		}
+	}
+
+	ts := time.Date(2022, 2, 2, 0, 0, 0, 0, time.UTC)
+	importTestItems(t, tl, testMessage("a", ts), testMessage("b", ts.Add(time.Minute)))
+
+	// items may be committed in more than one batch, and events are
+	// delivered concurrently, so gather everything from the first import
+	var inserted, finished Event
+	for len(inserted.ItemIDs) < 2 || finished.Topic == "" {
+		select {
+		case ev := <-events:
+			switch ev.Topic {
+			case TopicItemInserted:
+				if inserted.ImportID != 0 && ev.ImportID != inserted.ImportID {
+					t.Errorf("inserted items reported for different imports: %d and %d", inserted.ImportID, ev.ImportID)
+				}
+				inserted.ImportID = ev.ImportID
+				inserted.ItemIDs = append(inserted.ItemIDs, ev.ItemIDs...)
+			case TopicImportFinished:
+				finished = ev
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events of first import; inserted=%+v finished=%+v", inserted, finished)
+		}
+	}
+	if len(inserted.ItemIDs) != 2 || inserted.ImportID == 0 {
+		t.Errorf("expected events for 2 inserted items of an import, got: %+v", inserted)
+	}
+	if finished.ImportID != inserted.ImportID || finished.ImportStatus != importStatusSuccess {
+		t.Errorf("expected successful import %d to finish, got: %+v", inserted.ImportID, finished)
+	}
+
+	changed := testMessage("a", ts)
+	changed.Content.Data = StringData("edited message a")
+	testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: changed}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		ProcessingOptions: ProcessingOptions{
+			ItemFieldUpdates: map[string]fieldUpdatePolicy{"data": updatePolicyOverwriteExisting},
+		},
+	})
+	if err != nil {
+		t.Fatalf("second import failed: %v", err)
+	}
+	updated := next(TopicItemUpdated)
+	if len(updated.ItemIDs) != 1 || !slices.Contains(inserted.ItemIDs, updated.ItemIDs[0]) {
+		t.Errorf("expected event for the updated item, got: %+v", updated)
+	}
+
+	if err := tl.DeleteItems(context.Background(), updated.ItemIDs, DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deleted := next(TopicItemDeleted)
+	if !slices.Equal(deleted.ItemIDs, updated.ItemIDs) {
+		t.Errorf("expected event for the deleted item, got: %+v", deleted)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	events := make(chan Event, 10)
+	unsubscribe := tl.Subscribe(TopicItemDeleted, func(_ context.Context, ev Event) error {
+		events <- ev
+		return nil
+	})
+	unsubscribe()
+
+	tl.publish(Event{Topic: TopicItemDeleted, ItemIDs: []int64{1}})
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event after unsubscribing: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
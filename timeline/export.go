@@ -0,0 +1,182 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// ExportNDJSON writes the items matching params to w as newline-delimited JSON,
+// one search result object per line. Items are streamed as they are read from
+// the database, so the result set is never held in memory all at once. The
+// objects include the path of the item's data file (relative to the repo),
+// and how it is compressed, but not the contents of the file. If the repo
+// assigns global IDs to items (see ItemIDStrategy), they are included, so
+// that importing the export elsewhere preserves the items' identity.
+//
+// Unlike Search, there is no limit on the number of results by default. Related
+// items, edit history, and GeoJSON mode are not supported. The database is
+// locked for reading while the export runs, so w should not block for long.
+func (tl *Timeline) ExportNDJSON(ctx context.Context, params ItemSearchParams, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return tl.exportItems(ctx, params, func(sr SearchResult) error {
+		if params.WithSize {
+			tl.fillSize(&sr)
+		}
+		if sr.DataFile != nil {
+			var err error
+			sr.DataFileCompression, err = dataFileCompression(ctx, tl.db, *sr.DataFile)
+			if err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(sr); err != nil {
+			return fmt.Errorf("writing item %d: %w", sr.ID, err)
+		}
+		return nil
+	})
+}
+
+// mlFeatureColumns are the columns of the table written by ExportForML.
+var mlFeatureColumns = []string{
+	"id",
+	"timestamp",
+	"data_source",
+	"category",
+	"has_location",
+	"latitude",
+	"longitude",
+	"text_length",
+}
+
+// ExportForML writes features of the items matching params to w as a CSV
+// table with a header row, one item per row, suitable for loading into a
+// dataframe for analysis. Unlike ExportNDJSON, items are flattened to a fixed
+// set of columns: the item's row ID; its timestamp (RFC 3339, in UTC); the name
+// of its data source; its category; whether it has a location, and if so, its
+// latitude and longitude; and the length of its text in characters. Values
+// that an item doesn't have are empty. Rows are streamed, and the same
+// filters and limitations apply as with ExportNDJSON.
+func (tl *Timeline) ExportForML(ctx context.Context, params ItemSearchParams, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(mlFeatureColumns); err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+	record := make([]string, len(mlFeatureColumns))
+	err := tl.exportItems(ctx, params, func(sr SearchResult) error {
+		for i := range record {
+			record[i] = ""
+		}
+		record[0] = strconv.FormatInt(sr.ID, 10)
+		if sr.Timestamp != nil {
+			record[1] = sr.Timestamp.UTC().Format(time.RFC3339Nano)
+		}
+		if sr.DataSourceName != nil {
+			record[2] = *sr.DataSourceName
+		}
+		if sr.Category != nil {
+			record[3] = *sr.Category
+		}
+		hasLocation := sr.Latitude != nil && sr.Longitude != nil
+		record[4] = strconv.FormatBool(hasLocation)
+		if hasLocation {
+			record[5] = strconv.FormatFloat(*sr.Latitude, 'f', -1, 64)
+			record[6] = strconv.FormatFloat(*sr.Longitude, 'f', -1, 64)
+		}
+		var textLength int
+		if sr.DataText != nil {
+			textLength = utf8.RuneCountInString(*sr.DataText)
+		}
+		record[7] = strconv.Itoa(textLength)
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("writing item %d: %w", sr.ID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("writing items: %w", err)
+	}
+	return nil
+}
+
+// exportItems calls fn for each item matching params, as it is read from the
+// database. It stops at the first error, or if ctx is canceled.
+func (tl *Timeline) exportItems(ctx context.Context, params ItemSearchParams, fn func(SearchResult) error) error {
+	if params.GeoJSON || params.Related > 0 || params.WithHistory {
+		return fmt.Errorf("GeoJSON, related items, and edit history are not supported when exporting")
+	}
+	if params.Limit == 0 {
+		params.Limit = -1
+	}
+
+	q, args, err := tl.prepareSearchQuery(params)
+	if err != nil {
+		return err
+	}
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return fmt.Errorf("querying db for items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var re relatedEntity // entity is left-joined, so could be null
+		var totalCount int
+		entityTargets := []any{&re.ID, &re.Name, &re.Picture, &re.Attribute.Name, &re.Attribute.Value, &re.Attribute.AltValue}
+		if params.WithTotal {
+			entityTargets = append(entityTargets, &totalCount)
+		}
+		itemRow, err := scanItemRow(rows, entityTargets)
+		if err != nil {
+			return err
+		}
+		sr := SearchResult{RepoID: tl.id.String(), ItemRow: itemRow}
+		if re.ID != nil {
+			sr.Entity = &re
+		}
+
+		if err := fn(sr); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating item rows: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportNDJSON(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const numItems = 25
+	ts := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	var items []*Item
+	for i := 0; i < numItems; i++ {
+		items = append(items, testMessage(fmt.Sprintf("export%02d", i), ts.Add(time.Duration(i)*time.Hour)))
+	}
+	importTestItems(t, tl, items...)
+
+	var buf bytes.Buffer
+	err := tl.ExportNDJSON(context.Background(), ItemSearchParams{Sort: SortAsc}, &buf)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	scanner := bufio.NewScanner(&buf)
+	var i int
+	for scanner.Scan() {
+		var sr SearchResult
+		if err := json.Unmarshal(scanner.Bytes(), &sr); err != nil {
+			t.Fatalf("line %d: decoding: %v", i, err)
+		}
+		expectedID := fmt.Sprintf("export%02d", i)
+		if sr.OriginalID == nil || *sr.OriginalID != expectedID {
+			t.Errorf("line %d: expected item %s, got %v", i, expectedID, sr.OriginalID)
+		}
+		if sr.DataText == nil || *sr.DataText != "message "+expectedID {
+			t.Errorf("line %d: unexpected data text: %v", i, sr.DataText)
+		}
+		if sr.Timestamp == nil || !sr.Timestamp.Equal(ts.Add(time.Duration(i)*time.Hour)) {
+			t.Errorf("line %d: unexpected timestamp: %v", i, sr.Timestamp)
+		}
+		i++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != numItems {
+		t.Errorf("expected %d lines, got %d", numItems, i)
+	}
+
+	// filters are respected
+	buf.Reset()
+	err = tl.ExportNDJSON(context.Background(), ItemSearchParams{OriginalID: []string{"export03"}}, &buf)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if lines := bytes.Count(buf.Bytes(), []byte("\n")); lines != 1 {
+		t.Errorf("expected 1 line for filtered export, got %d", lines)
+	}
+
+	// cancellation is respected
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tl.ExportNDJSON(ctx, ItemSearchParams{}, &buf); err == nil {
+		t.Error("expected error from canceled export")
+	}
+}
+
+func TestExportForML(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	lat, lon := 40.5, -111.25
+	located := testTextItem("located", "héllo", ts)
+	located.Location = Location{Latitude: &lat, Longitude: &lon}
+	importTestItems(t, tl,
+		located,
+		testMessage("plain", ts.Add(time.Hour)),
+		testFileItem("file", ts.Add(2*time.Hour)),
+	)
+
+	var buf bytes.Buffer
+	err := tl.ExportForML(context.Background(), ItemSearchParams{Sort: SortAsc}, &buf)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading exported table: %v", err)
+	}
+
+	// the category and ID columns depend on the import, so fill them in from the DB
+	var ids []string
+	var categories []string
+	for _, origID := range []string{"located", "plain", "file"} {
+		var id int64
+		var category *string
+		if err := tl.db.QueryRow(`SELECT id, category FROM items WHERE original_id=?`, origID).Scan(&id, &category); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, fmt.Sprint(id))
+		if category != nil {
+			categories = append(categories, *category)
+		} else {
+			categories = append(categories, "")
+		}
+	}
+
+	if categories[0] != CategoryMessage {
+		t.Errorf("expected message to be categorized, got %q", categories[0])
+	}
+
+	expected := [][]string{
+		{"id", "timestamp", "data_source", "category", "has_location", "latitude", "longitude", "text_length"},
+		{ids[0], "2021-02-03T04:05:06Z", testDataSourceName, categories[0], "true", "40.5", "-111.25", "5"},
+		{ids[1], "2021-02-03T05:05:06Z", testDataSourceName, categories[1], "false", "", "", "13"},
+		{ids[2], "2021-02-03T06:05:06Z", testDataSourceName, categories[2], "false", "", "", "0"},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d rows, got %d: %v", len(expected), len(records), records)
+	}
+	for i := range expected {
+		if strings.Join(records[i], ",") != strings.Join(expected[i], ",") {
+			t.Errorf("row %d: expected %v, got %v", i, expected[i], records[i])
+		}
+	}
+
+	// filters are respected
+	buf.Reset()
+	err = tl.ExportForML(context.Background(), ItemSearchParams{OriginalID: []string{"plain"}}, &buf)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if lines := bytes.Count(buf.Bytes(), []byte("\n")); lines != 2 {
+		t.Errorf("expected header and 1 row for filtered export, got %d lines", lines)
+	}
+
+	// cancellation is respected
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tl.ExportForML(ctx, ItemSearchParams{}, &buf); err == nil {
+		t.Error("expected error from canceled export")
+	}
+}
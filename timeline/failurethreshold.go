@@ -0,0 +1,109 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// FailureThreshold is how many items may fail to be processed before the
+// whole import fails. Either or both limits may be set; zero means no limit.
+// An item counts as failed if it, or anything in its graph, failed.
+type FailureThreshold struct {
+	// The import is aborted as soon as more than this many items fail.
+	MaxFailures int64 `json:"max_failures,omitempty"`
+
+	// The import fails if more than this fraction (0-1) of its items
+	// failed. Since the fraction is only meaningful once all the items
+	// are known, this is checked when the data source is finished.
+	MaxFraction float64 `json:"max_fraction,omitempty"`
+}
+
+func (ft FailureThreshold) validate() error {
+	if ft.MaxFailures < 0 {
+		return fmt.Errorf("maximum failures cannot be negative: %d", ft.MaxFailures)
+	}
+	if ft.MaxFraction < 0 || ft.MaxFraction > 1 {
+		return fmt.Errorf("maximum failure fraction must be between 0 and 1: %g", ft.MaxFraction)
+	}
+	return nil
+}
+
+// FailureThresholdExceeded is the error returned when an import fails
+// because too many of its items failed to be processed.
+type FailureThresholdExceeded struct {
+	ImportID  int64            `json:"import_id"`
+	Failed    int64            `json:"failed"`
+	Processed int64            `json:"processed"`
+	Threshold FailureThreshold `json:"threshold"`
+}
+
+func (e FailureThresholdExceeded) Error() string {
+	return fmt.Sprintf("import %d exceeded failure threshold: %d of %d items failed (max_failures=%d max_fraction=%g)",
+		e.ImportID, e.Failed, e.Processed, e.Threshold.MaxFailures, e.Threshold.MaxFraction)
+}
+
+// countFailure records that the graph failed to be processed, and
+// aborts the import if that is more failures than are allowed.
+func (p *processor) countFailure(g *Graph, err error) {
+	g.err = err
+	if p.failedGraphCount == nil {
+		return
+	}
+	failed := atomic.AddInt64(p.failedGraphCount, 1)
+
+	ft := p.params.ProcessingOptions.FailureThreshold
+	if ft == nil || p.abort == nil || ft.MaxFailures == 0 || failed <= ft.MaxFailures {
+		return
+	}
+	p.abort(FailureThresholdExceeded{
+		ImportID:  p.impRow.id,
+		Failed:    failed,
+		Processed: atomic.LoadInt64(p.graphCount),
+		Threshold: *ft,
+	})
+}
+
+// failureThresholdError returns a FailureThresholdExceeded error if the
+// import was aborted for too many failures, or if the fraction of items
+// that failed so far is more than allowed.
+func (p *processor) failureThresholdError(ctx context.Context) error {
+	var exceeded FailureThresholdExceeded
+	if errors.As(context.Cause(ctx), &exceeded) {
+		return exceeded
+	}
+
+	ft := p.params.ProcessingOptions.FailureThreshold
+	if ft == nil || ft.MaxFraction == 0 || p.failedGraphCount == nil {
+		return nil
+	}
+	failed, processed := atomic.LoadInt64(p.failedGraphCount), atomic.LoadInt64(p.graphCount)
+	if processed == 0 || float64(failed)/float64(processed) <= ft.MaxFraction {
+		return nil
+	}
+	return FailureThresholdExceeded{
+		ImportID:  p.impRow.id,
+		Failed:    failed,
+		Processed: processed,
+		Threshold: *ft,
+	}
+}
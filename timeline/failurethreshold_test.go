@@ -0,0 +1,123 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFailureThreshold(t *testing.T) {
+	since := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name      string
+		threshold FailureThreshold
+		expectErr bool
+	}{
+		{"count just under", FailureThreshold{MaxFailures: 3}, false},
+		{"count just over", FailureThreshold{MaxFailures: 2}, true},
+		{"fraction just under", FailureThreshold{MaxFraction: 0.3}, false},
+		{"fraction just over", FailureThreshold{MaxFraction: 0.29}, true},
+	} {
+		tl := newTestTimeline(t)
+
+		// 3 of the 10 items are outside the timeframe, so they fail
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for i := 0; i < 10; i++ {
+				ts := since.Add(time.Duration(i+1) * time.Hour)
+				if i%3 == 1 {
+					ts = since.Add(-time.Hour)
+				}
+				select {
+				case itemChan <- &Graph{Item: testMessage(fmt.Sprintf("item%d", i), ts)}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		}
+
+		var mu sync.Mutex
+		var lastStatus ImportStatus
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			ProcessingOptions: ProcessingOptions{
+				Timeframe:        Timeframe{Since: &since},
+				FailureThreshold: &tc.threshold,
+			},
+			ProgressFunc: func(st ImportStatus) {
+				mu.Lock()
+				lastStatus = st
+				mu.Unlock()
+			},
+		})
+
+		var exceeded FailureThresholdExceeded
+		if tc.expectErr {
+			if !errors.As(err, &exceeded) {
+				t.Errorf("%s: expected FailureThresholdExceeded, got %v", tc.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: expected import to succeed, got %v", tc.name, err)
+		}
+
+		var status string
+		if err := tl.db.QueryRow(`SELECT status FROM imports LIMIT 1`).Scan(&status); err != nil {
+			t.Fatal(err)
+		}
+		if tc.expectErr && status != importStatusError {
+			t.Errorf("%s: expected import status %q, got %q", tc.name, importStatusError, status)
+		}
+		if !tc.expectErr {
+			if status != importStatusSuccess {
+				t.Errorf("%s: expected import status %q, got %q", tc.name, importStatusSuccess, status)
+			}
+			if lastStatus.FailedItemCount != 3 {
+				t.Errorf("%s: expected 3 failed items, got %d", tc.name, lastStatus.FailedItemCount)
+			}
+			var count int
+			if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 7 {
+				t.Errorf("%s: expected 7 items, got %d", tc.name, count)
+			}
+		}
+	}
+}
+
+func TestFailureThresholdValidation(t *testing.T) {
+	tl := newTestTimeline(t)
+	for _, ft := range []FailureThreshold{{MaxFailures: -1}, {MaxFraction: 1.5}} {
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{FailureThreshold: &ft},
+		})
+		if err == nil {
+			t.Errorf("expected invalid threshold %+v to be rejected", ft)
+		}
+	}
+}
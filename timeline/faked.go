@@ -34,6 +34,10 @@ import (
 )
 
 func (tl *Timeline) PopulateWithFakeData(ctx context.Context) error {
+	if err := tl.checkWritable("populate with fake data"); err != nil {
+		return err
+	}
+
 	// if timeline is new, generate person ID 1 (as simulated timeline owner)
 	if tl.Empty() {
 		bd := gofakeit.Date()
@@ -116,7 +120,7 @@ func (tl *Timeline) PopulateWithFakeData(ctx context.Context) error {
 		people = append(people, ent)
 	}
 
-	for _, ds := range dataSources {
+	for _, ds := range AllDataSources() {
 		dsRowID, ok := tl.dataSources[ds.Name]
 		if !ok {
 			return fmt.Errorf("unknown data source: %s", ds.Name)
@@ -156,6 +160,7 @@ func (tl *Timeline) PopulateWithFakeData(ctx context.Context) error {
 		proc := processor{
 			itemCount:        new(int64),
 			skippedItemCount: new(int64),
+			checkpointCount:  new(int64),
 			ds:               ds,
 			dsRowID:          dsRowID,
 			params:           importParams,
@@ -0,0 +1,81 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SetFavorite marks the item with the given row ID as a favorite, or unmarks it.
+// Favorites are stored in the item's starred column and can be found with the
+// Favorite search parameter. Imports don't change whether an item is a favorite.
+func (tl *Timeline) SetFavorite(ctx context.Context, itemID int64, favorite bool) error {
+	if err := tl.checkWritable("set favorite"); err != nil {
+		return err
+	}
+
+	var starred *int
+	if favorite {
+		one := 1
+		starred = &one
+	}
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	res, err := tl.db.ExecContext(ctx, `UPDATE items SET starred=? WHERE id=? AND deleted IS NULL`, starred, itemID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+	if err != nil {
+		return fmt.Errorf("setting favorite on item %d: %v", itemID, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("checking for item %d: %v", itemID, err)
+	} else if n == 0 {
+		return fmt.Errorf("item %d not found", itemID)
+	}
+
+	return nil
+}
+
+// CountImportFavorites returns the number of favorite items that would be deleted
+// along with the import with the given ID (see DeleteImport), so that the user
+// can be warned before deleting it. Favorites that were also given by other
+// imports are kept when the import is deleted, so they are not counted.
+func (tl *Timeline) CountImportFavorites(ctx context.Context, importID int64) (int, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+	return countImportFavorites(ctx, tl.db, importID)
+}
+
+// countImportFavorites is like CountImportFavorites, but it must be called
+// inside a lock on the database (such as Timeline.dbMu).
+func countImportFavorites(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, importID int64) (int, error) {
+	var count int
+	err := q.QueryRowContext(ctx, `SELECT count() FROM items
+		WHERE import_id=? AND starred IS NOT NULL AND deleted IS NULL
+			AND NOT EXISTS (SELECT 1 FROM import_items WHERE import_items.item_id = items.id AND import_items.import_id != ?)`,
+		importID, importID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("counting favorites of import %d: %v", importID, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,101 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFavorites(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2021, 2, 14, 0, 0, 0, 0, time.UTC)
+
+	importTestItems(t, tl, testMessage("loved", ts), testMessage("liked", ts), testMessage("meh", ts))
+	var importID int64
+	if err := tl.db.QueryRow(`SELECT max(id) FROM imports`).Scan(&importID); err != nil {
+		t.Fatal(err)
+	}
+	rowID := func(originalID string) int64 {
+		t.Helper()
+		var id int64
+		if err := tl.db.QueryRow(`SELECT id FROM items WHERE original_id=?`, originalID).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	favorites := func() []string {
+		t.Helper()
+		results, err := tl.Search(ctx, ItemSearchParams{Favorite: true})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		var ids []string
+		for _, sr := range results.Items {
+			ids = append(ids, *sr.OriginalID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	for _, id := range []string{"loved", "liked"} {
+		if err := tl.SetFavorite(ctx, rowID(id), true); err != nil {
+			t.Fatalf("setting favorite: %v", err)
+		}
+	}
+	if got := favorites(); !slices.Equal(got, []string{"liked", "loved"}) {
+		t.Errorf("expected 2 favorites, got %v", got)
+	}
+
+	if err := tl.SetFavorite(ctx, rowID("liked"), false); err != nil {
+		t.Fatalf("unsetting favorite: %v", err)
+	}
+	if got := favorites(); !slices.Equal(got, []string{"loved"}) {
+		t.Errorf("expected only the remaining favorite, got %v", got)
+	}
+	results, err := tl.Search(ctx, ItemSearchParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Items) != 3 {
+		t.Errorf("expected all items without the favorite filter, got %d", len(results.Items))
+	}
+
+	if err := tl.SetFavorite(ctx, rowID("meh")+100, true); err == nil {
+		t.Error("expected error setting favorite on item that doesn't exist")
+	}
+
+	// deleting the import deletes its favorites, which can be counted beforehand
+	count, err := tl.CountImportFavorites(ctx, importID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 favorite to be deleted with the import, got %d", count)
+	}
+	if err := tl.DeleteImport(ctx, importID); err != nil {
+		t.Fatal(err)
+	}
+	if got := favorites(); len(got) != 0 {
+		t.Errorf("expected favorites to be deleted with the import, got %v", got)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fileProgress tracks how much of the input files of an import the data
+// source has read, so that progress is weighted by the size of each file
+// rather than jumping unevenly from one file to the next.
+type fileProgress struct {
+	mu       sync.Mutex
+	sizes    map[string]int64 // total size of each input file (or directory), keyed by clean path
+	consumed map[string]int64 // bytes read of each file reported by the data source
+	total    int64
+
+	// serializes progress reports, so the reported fraction never goes backwards
+	reportMu sync.Mutex
+}
+
+// newFileProgress sums up the sizes of the input files. The total size
+// of a directory is that of the regular files within it. Inputs that
+// aren't on disk (not all data sources take paths to files) are ignored.
+func newFileProgress(filenames []string) (*fileProgress, error) {
+	fp := &fileProgress{
+		sizes:    make(map[string]int64),
+		consumed: make(map[string]int64),
+	}
+	for _, filename := range filenames {
+		filename = filepath.Clean(filename)
+		var size int64
+		err := filepath.WalkDir(filename, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fp.sizes[filename] = size
+		fp.total += size
+	}
+	return fp, nil
+}
+
+// report records that the first n bytes of filename have been read.
+func (fp *fileProgress) report(filename string, n int64) {
+	filename = filepath.Clean(filename)
+	fp.mu.Lock()
+	if n > fp.consumed[filename] {
+		fp.consumed[filename] = n
+	}
+	fp.mu.Unlock()
+}
+
+// fraction returns the portion (0-1) of the total size of the input
+// files that has been read. It never decreases.
+func (fp *fileProgress) fraction() float64 {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if fp.total == 0 {
+		return 0
+	}
+	var done int64
+	for input, size := range fp.sizes {
+		var inputDone int64
+		for filename, n := range fp.consumed {
+			if filename == input || strings.HasPrefix(filename, input+string(filepath.Separator)) {
+				inputDone += n
+			}
+		}
+		done += min(inputDone, size)
+	}
+	return float64(done) / float64(fp.total)
+}
+
+// ReportFileProgress lets the processor know that the data source has read
+// the first n bytes of filename, which is one of the files being imported or
+// a file within one of them (if it is a directory). Data sources that import
+// multiple or large files should call this as they go, so the progress of
+// the import is proportional to the data read. It is safe to call from
+// multiple goroutines, and is a no-op if progress isn't being tracked.
+func (opt ListingOptions) ReportFileProgress(filename string, n int64) {
+	if opt.fileProgress != nil {
+		opt.fileProgress.report(filename, n)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileProgressWeightedBySize(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.txt")
+	large := filepath.Join(dir, "large.txt")
+	if err := os.WriteFile(small, bytes.Repeat([]byte("s"), 1000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(large, bytes.Repeat([]byte("L"), 9000), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// weighted by size, reading all of the small file is only a tenth of the work
+	fp, err := newFileProgress([]string{small, large})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.report(small, 1000)
+	if got := fp.fraction(); got != 0.1 {
+		t.Errorf("expected 0.1 after reading the small file, got %f", got)
+	}
+
+	tl := newTestTimeline(t)
+
+	// read each file in chunks, emitting an item for each chunk
+	const chunkSize = 100
+	testFileImport = func(_ context.Context, filenames []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for _, filename := range filenames {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			for offset := 0; offset < len(data); offset += chunkSize {
+				ts = ts.Add(time.Minute)
+				itemChan <- &Graph{Item: testMessage(fmt.Sprintf("%s-%d", filepath.Base(filename), offset), ts)}
+				opt.ReportFileProgress(filename, int64(offset+chunkSize))
+			}
+		}
+		return nil
+	}
+
+	var mu sync.Mutex
+	var fractions []float64
+	err = tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{small, large},
+		ProgressFunc: func(st ImportStatus) {
+			mu.Lock()
+			fractions = append(fractions, st.Progress)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(fractions) == 0 {
+		t.Fatal("no progress was reported")
+	}
+	for i, f := range fractions {
+		if f < 0 || f > 1 {
+			t.Errorf("progress %d out of range: %f", i, f)
+		}
+		if i > 0 && f < fractions[i-1] {
+			t.Errorf("progress went backwards: %v", fractions)
+			break
+		}
+	}
+	if last := fractions[len(fractions)-1]; last != 1 {
+		t.Errorf("expected final progress to be 1, got %f (all: %v)", last, fractions)
+	}
+}
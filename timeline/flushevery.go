@@ -0,0 +1,55 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// FlushEvery forces batches of items to be committed to the database before
+// they are full, so that items from slow imports (for example, a data source
+// that follows a live feed) become queryable promptly. Either or both limits
+// may be set; zero means no limit.
+//
+// Batches exist for performance: each one is committed in a transaction, so
+// smaller batches mean more transactions and a slower import overall. Only
+// use limits that are as small as needed for timely visibility.
+type FlushEvery struct {
+	// A batch is committed once it has at least this many items (counting
+	// the items and entities in their graphs), even if it isn't full.
+	Items int `json:"items,omitempty"`
+
+	// A batch is committed once its first item has been waiting for about
+	// this long, even if no more items arrive.
+	Interval time.Duration `json:"interval,omitempty"`
+}
+
+func (fe FlushEvery) validate() error {
+	if fe.Items < 0 {
+		return fmt.Errorf("flush item count cannot be negative: %d", fe.Items)
+	}
+	if fe.Interval < 0 {
+		return fmt.Errorf("flush interval cannot be negative: %s", fe.Interval)
+	}
+	if fe.Items == 0 && fe.Interval == 0 {
+		return fmt.Errorf("flush requires an item count or interval")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFlushEvery(t *testing.T) {
+	ts := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, fe := range []FlushEvery{
+		{Items: 1},
+		{Interval: 20 * time.Millisecond},
+	} {
+		tl := newTestTimeline(t)
+		ctx := context.Background()
+
+		// the data source waits for its first item to be committed before
+		// sending more, which would never happen if only full batches were
+		// committed (the batch is far from full)
+		var visible bool
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			itemChan <- &Graph{Item: testMessage("first", ts)}
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				if _, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "first"); err == nil {
+					visible = true
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			itemChan <- &Graph{Item: testMessage("second", ts.Add(time.Minute))}
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{FlushEvery: &fe},
+		})
+		if err != nil {
+			t.Fatalf("flush every %+v: import failed: %v", fe, err)
+		}
+		if !visible {
+			t.Errorf("flush every %+v: first item was not committed before the batch filled up", fe)
+		}
+		if _, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "second"); err != nil {
+			t.Errorf("flush every %+v: expected second item to be imported: %v", fe, err)
+		}
+	}
+}
+
+func TestFlushEveryValidation(t *testing.T) {
+	tl := newTestTimeline(t)
+	for _, fe := range []FlushEvery{
+		{},
+		{Items: -1},
+		{Interval: -time.Second},
+	} {
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{FlushEvery: &fe},
+		})
+		if err == nil {
+			t.Errorf("expected error for flush every %+v", fe)
+		}
+	}
+}
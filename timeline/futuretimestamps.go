@@ -0,0 +1,72 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// FutureTimestampPolicy specifies what to do with items that have a
+// timestamp in the future, which are usually caused by a device with
+// a bad clock. Left alone, such an item would appear to be the most
+// recent one, which can cause get-latest imports to miss real items.
+type FutureTimestampPolicy string
+
+const (
+	// FutureTimestampsClamp stores the item with its timestamp set to
+	// the time of import, and remembers the original timestamp (which
+	// also flags the item as clamped). This is the default.
+	FutureTimestampsClamp FutureTimestampPolicy = "clamp"
+
+	// FutureTimestampsReject skips the item.
+	FutureTimestampsReject FutureTimestampPolicy = "reject"
+
+	// FutureTimestampsKeep stores the item as-is.
+	FutureTimestampsKeep FutureTimestampPolicy = "keep"
+)
+
+func (ftp FutureTimestampPolicy) validate() error {
+	switch ftp {
+	case "", FutureTimestampsClamp, FutureTimestampsReject, FutureTimestampsKeep:
+		return nil
+	}
+	return fmt.Errorf("unrecognized future timestamp policy: %s", ftp)
+}
+
+func (ftp FutureTimestampPolicy) clamps() bool {
+	return ftp == "" || ftp == FutureTimestampsClamp
+}
+
+// clampFutureTimestamp sets the timestamp of ir to now if it is after now,
+// keeping the original timestamp. Ending times in the future are dropped,
+// since they can no longer be after the timestamp.
+func clampFutureTimestamp(ir *ItemRow, now time.Time) {
+	if ir.Timestamp == nil || !ir.Timestamp.After(now) {
+		return
+	}
+	ir.OriginalTimestamp = ir.Timestamp
+	ir.Timestamp = &now
+	if ir.Timespan != nil && ir.Timespan.After(now) {
+		ir.Timespan = nil
+	}
+	if ir.Timeframe != nil && ir.Timeframe.After(now) {
+		ir.Timeframe = nil
+	}
+}
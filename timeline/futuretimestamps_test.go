@@ -0,0 +1,83 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFutureTimestampDoesNotPoisonGetLatest(t *testing.T) {
+	for _, policy := range []FutureTimestampPolicy{"", FutureTimestampsReject, FutureTimestampsKeep} {
+		tl := newTestTimeline(t)
+
+		past := time.Now().Add(-24 * time.Hour).Truncate(time.Millisecond)
+		future := time.Now().Add(365 * 24 * time.Hour).Truncate(time.Millisecond)
+		importTestItemsWithOptions := func(procOpt ProcessingOptions, items ...*Item) *Timeframe {
+			t.Helper()
+			var tf Timeframe
+			testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+				tf = opt.Timeframe
+				for _, it := range items {
+					itemChan <- &Graph{Item: it}
+				}
+				return nil
+			}
+			err := tl.Import(context.Background(), ImportParameters{
+				DataSourceName:    testDataSourceName,
+				Filenames:         []string{"test"},
+				ProcessingOptions: procOpt,
+			})
+			if err != nil {
+				t.Fatalf("policy %q: import failed: %v", policy, err)
+			}
+			return &tf
+		}
+
+		importTestItemsWithOptions(ProcessingOptions{FutureTimestamps: policy},
+			testMessage("real", past), testMessage("bad_clock", future))
+
+		var ts int64
+		var origTS *int64
+		err := tl.db.QueryRow(`SELECT timestamp, original_timestamp FROM items WHERE original_id='bad_clock'`).Scan(&ts, &origTS)
+		switch policy {
+		case FutureTimestampsReject:
+			if err == nil {
+				t.Errorf("policy %q: expected future item to be rejected", policy)
+			}
+		case FutureTimestampsKeep:
+			if err != nil || ts != future.UnixMilli() || origTS != nil {
+				t.Errorf("policy %q: expected future item to be kept as-is (err=%v timestamp=%d original=%v)", policy, err, ts, origTS)
+			}
+		default:
+			if err != nil || ts > time.Now().UnixMilli() || origTS == nil || *origTS != future.UnixMilli() {
+				t.Errorf("policy %q: expected future item to be clamped (err=%v timestamp=%d original=%v)", policy, err, ts, origTS)
+			}
+		}
+
+		tf := importTestItemsWithOptions(ProcessingOptions{GetLatest: true})
+		if tf.Since == nil || !tf.Since.Equal(past) {
+			t.Errorf("policy %q: expected get latest to start at %s, got %v", policy, past, tf.Since)
+		}
+		if tf.SinceItemID == nil || *tf.SinceItemID != "real" {
+			t.Errorf("policy %q: expected get latest to start after item 'real', got %v", policy, tf.SinceItemID)
+		}
+	}
+}
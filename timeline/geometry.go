@@ -0,0 +1,90 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// GeometryPoint is one point of an item's geometry (see Item.Geometry).
+type GeometryPoint struct {
+	Longitude float64  `json:"longitude"`          // degrees
+	Latitude  float64  `json:"latitude"`           // degrees
+	Altitude  *float64 `json:"altitude,omitempty"` // meters
+}
+
+func (gp GeometryPoint) location() Location {
+	lon, lat := gp.Longitude, gp.Latitude
+	return Location{
+		Longitude: &lon,
+		Latitude:  &lat,
+		Altitude:  gp.Altitude,
+	}
+}
+
+// storeItemGeometry replaces the geometry of the item with the given row ID.
+func storeItemGeometry(ctx context.Context, tx *sql.Tx, itemRowID int64, points []GeometryPoint) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM item_geometry WHERE item_id=?`, itemRowID)
+	if err != nil {
+		return fmt.Errorf("clearing previous item geometry: %v", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO item_geometry (item_id, sequence, longitude, latitude, altitude) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("preparing item geometry statement: %v", err)
+	}
+	defer stmt.Close()
+	for i, pt := range points {
+		_, err := stmt.ExecContext(ctx, itemRowID, i, pt.Longitude, pt.Latitude, pt.Altitude)
+		if err != nil {
+			return fmt.Errorf("storing point %d of item geometry: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// ItemGeometry returns the points of the item's geometry, in order,
+// or nil if the item has no geometry.
+func (tl *Timeline) ItemGeometry(ctx context.Context, itemRowID int64) ([]GeometryPoint, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx,
+		`SELECT longitude, latitude, altitude FROM item_geometry WHERE item_id=? ORDER BY sequence`,
+		itemRowID)
+	if err != nil {
+		return nil, fmt.Errorf("querying item geometry: %v", err)
+	}
+	defer rows.Close()
+
+	var points []GeometryPoint
+	for rows.Next() {
+		var pt GeometryPoint
+		if err := rows.Scan(&pt.Longitude, &pt.Latitude, &pt.Altitude); err != nil {
+			return nil, fmt.Errorf("scanning geometry point: %v", err)
+		}
+		points = append(points, pt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating geometry points: %v", err)
+	}
+
+	return points, nil
+}
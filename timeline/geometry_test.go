@@ -0,0 +1,100 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearchBoundingBoxMatchesGeometry(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2023, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	// a track heading north-east, of which only the middle point
+	// is within the bounding box searched for below
+	alt := 120.0
+	track := []GeometryPoint{
+		{Longitude: 20, Latitude: 10, Altitude: &alt},
+		{Longitude: 21, Latitude: 11},
+		{Longitude: 22, Latitude: 12},
+		{Longitude: 23, Latitude: 13},
+		{Longitude: 24, Latitude: 14},
+	}
+	trackItem := &Item{
+		ID:             "track",
+		Classification: ClassLocation,
+		Timestamp:      ts,
+		Geometry:       track,
+	}
+	lon, lat := 100.0, -30.0
+	elsewhere := testMessage("elsewhere", ts)
+	elsewhere.Location = Location{Longitude: &lon, Latitude: &lat}
+	importTestItems(t, tl, trackItem, elsewhere)
+
+	search := func(minLat, maxLat, minLon, maxLon float64) []string {
+		t.Helper()
+		results, err := tl.Search(ctx, ItemSearchParams{
+			MinLatitude:  &minLat,
+			MaxLatitude:  &maxLat,
+			MinLongitude: &minLon,
+			MaxLongitude: &maxLon,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range results.Items {
+			ids = append(ids, *r.OriginalID)
+		}
+		return ids
+	}
+
+	if got := search(11.5, 12.5, 21.5, 22.5); !reflect.DeepEqual(got, []string{"track"}) {
+		t.Errorf("expected bounding box around middle of track to match it, got %v", got)
+	}
+	if got := search(11.5, 12.5, 23.5, 24.5); len(got) != 0 {
+		t.Errorf("expected bounding box away from track to match nothing, got %v", got)
+	}
+	if got := search(-31, -29, 99, 101); !reflect.DeepEqual(got, []string{"elsewhere"}) {
+		t.Errorf("expected bounding box around point item to match it, got %v", got)
+	}
+
+	results, err := tl.Search(ctx, ItemSearchParams{OriginalID: []string{"track"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Items) != 1 {
+		t.Fatalf("expected 1 track item, got %d", len(results.Items))
+	}
+	row := results.Items[0].ItemRow
+	if row.Latitude == nil || *row.Latitude != 10 || row.Longitude == nil || *row.Longitude != 20 {
+		t.Errorf("expected track to be located at its first point, got lat=%v lon=%v", row.Latitude, row.Longitude)
+	}
+	points, err := tl.ItemGeometry(ctx, row.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(points, track) {
+		t.Errorf("expected geometry %+v, got %+v", track, points)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HistogramInterval is the width of the buckets of a time histogram.
+type HistogramInterval string
+
+const (
+	HistogramDay   HistogramInterval = "day"
+	HistogramWeek  HistogramInterval = "week" // weeks start on Monday
+	HistogramMonth HistogramInterval = "month"
+	HistogramYear  HistogramInterval = "year"
+)
+
+// startOfBucket returns the SQL expression that computes the date (in
+// YYYY-MM-DD form, UTC) of the start of the bucket of the item timestamp.
+func (hi HistogramInterval) startOfBucket() (string, error) {
+	const date = "date(items.timestamp/1000.0, 'unixepoch'"
+	switch hi {
+	case HistogramDay:
+		return date + ")", nil
+	case HistogramWeek:
+		return date + ", 'weekday 0', '-6 days')", nil
+	case HistogramMonth:
+		return date + ", 'start of month')", nil
+	case HistogramYear:
+		return date + ", 'start of year')", nil
+	}
+	return "", fmt.Errorf("unrecognized histogram interval: %s", hi)
+}
+
+// truncate returns the start of the bucket t is in.
+func (hi HistogramInterval) truncate(t time.Time) time.Time {
+	t = t.UTC()
+	y, m, d := t.Date()
+	switch hi {
+	case HistogramWeek:
+		d -= (int(t.Weekday()) + 6) % 7
+	case HistogramMonth:
+		d = 1
+	case HistogramYear:
+		m, d = time.January, 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// next returns the start of the bucket after the one starting at t.
+func (hi HistogramInterval) next(t time.Time) time.Time {
+	switch hi {
+	case HistogramWeek:
+		return t.AddDate(0, 0, 7)
+	case HistogramMonth:
+		return t.AddDate(0, 1, 0)
+	case HistogramYear:
+		return t.AddDate(1, 0, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// HistogramBucket is the number of items in a span of time.
+type HistogramBucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// TimeHistogram counts the items matching params in buckets of the given
+// interval (in UTC), for drawing charts of activity over time. Buckets are
+// returned in chronological order, spanning the start and end timestamps
+// of params (if set) or the matching items, with empty buckets included so
+// the result is continuous. Limit, offset, and sort of params are ignored,
+// as are items without a timestamp.
+func (tl *Timeline) TimeHistogram(ctx context.Context, params ItemSearchParams, interval HistogramInterval) ([]HistogramBucket, error) {
+	bucketExpr, err := interval.startOfBucket()
+	if err != nil {
+		return nil, err
+	}
+
+	params.timestampsOnly = true
+	params.GeoJSON = false
+	params.WithTotal = false
+	params.Sort = SortNone
+	params.Limit = -1
+	params.Offset = 0
+	params.Timestamp, params.Latitude, params.Longitude = nil, nil, nil
+
+	itemsQuery, args, err := tl.prepareSearchQuery(params)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(`SELECT %s AS bucket, count() FROM (%s) AS items
+		WHERE items.timestamp IS NOT NULL
+		GROUP BY bucket
+		ORDER BY bucket`, bucketExpr, itemsQuery)
+
+	counts := make(map[time.Time]int64)
+	var first, last time.Time
+
+	tl.dbMu.RLock()
+	rows, err := tl.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		tl.dbMu.RUnlock()
+		return nil, fmt.Errorf("querying histogram: %v", err)
+	}
+	for rows.Next() {
+		var bucket string
+		var count int64
+		if err := rows.Scan(&bucket, &count); err != nil {
+			rows.Close()
+			tl.dbMu.RUnlock()
+			return nil, fmt.Errorf("scanning histogram bucket: %v", err)
+		}
+		start, err := time.Parse(time.DateOnly, bucket)
+		if err != nil {
+			rows.Close()
+			tl.dbMu.RUnlock()
+			return nil, fmt.Errorf("parsing histogram bucket %q: %v", bucket, err)
+		}
+		counts[start] = count
+		if first.IsZero() {
+			first = start
+		}
+		last = start
+	}
+	rows.Close()
+	err = rows.Err()
+	tl.dbMu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("iterating histogram rows: %v", err)
+	}
+
+	// the requested timeframe determines the extent of the histogram, if set
+	if params.StartTimestamp != nil {
+		first = interval.truncate(*params.StartTimestamp)
+	}
+	if params.EndTimestamp != nil {
+		last = interval.truncate(*params.EndTimestamp)
+	}
+	if first.IsZero() || last.IsZero() {
+		return []HistogramBucket{}, nil
+	}
+
+	var buckets []HistogramBucket
+	for start := first; !start.After(last); start = interval.next(start) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, HistogramBucket{Start: start, Count: counts[start]})
+	}
+	return buckets, nil
+}
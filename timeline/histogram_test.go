@@ -0,0 +1,94 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimeHistogram(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2022, month, d, 12, 0, 0, 0, time.UTC)
+	}
+	var items []*Item
+	for i, ts := range []time.Time{
+		day(time.January, 3), day(time.January, 31),
+		// nothing in February
+		day(time.March, 15),
+		day(time.April, 1), day(time.April, 2), day(time.April, 30),
+	} {
+		items = append(items, testMessage(fmt.Sprintf("item%d", i), ts))
+	}
+	importTestItems(t, tl, items...)
+
+	type expectation struct {
+		start string
+		count int64
+	}
+	check := func(name string, params ItemSearchParams, interval HistogramInterval, expected []expectation) {
+		t.Helper()
+		buckets, err := tl.TimeHistogram(context.Background(), params, interval)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(buckets) != len(expected) {
+			t.Fatalf("%s: expected %d buckets, got %d: %+v", name, len(expected), len(buckets), buckets)
+		}
+		for i, b := range buckets {
+			if start := b.Start.Format(time.DateOnly); start != expected[i].start || b.Count != expected[i].count {
+				t.Errorf("%s: bucket %d: expected %s=%d, got %s=%d", name, i, expected[i].start, expected[i].count, start, b.Count)
+			}
+		}
+	}
+
+	check("monthly", ItemSearchParams{}, HistogramMonth, []expectation{
+		{"2022-01-01", 2}, {"2022-02-01", 0}, {"2022-03-01", 1}, {"2022-04-01", 3},
+	})
+	check("yearly", ItemSearchParams{}, HistogramYear, []expectation{
+		{"2022-01-01", 6},
+	})
+
+	// the timeframe limits the items and extends the range to fill
+	start, end := day(time.March, 28), day(time.April, 10)
+	check("weekly with timeframe", ItemSearchParams{StartTimestamp: &start, EndTimestamp: &end}, HistogramWeek, []expectation{
+		{"2022-03-28", 2}, {"2022-04-04", 0},
+	})
+	check("daily", ItemSearchParams{StartTimestamp: &start, EndTimestamp: &end}, HistogramDay, []expectation{
+		{"2022-03-28", 0}, {"2022-03-29", 0}, {"2022-03-30", 0}, {"2022-03-31", 0},
+		{"2022-04-01", 1}, {"2022-04-02", 1}, {"2022-04-03", 0}, {"2022-04-04", 0},
+		{"2022-04-05", 0}, {"2022-04-06", 0}, {"2022-04-07", 0}, {"2022-04-08", 0},
+		{"2022-04-09", 0}, {"2022-04-10", 0},
+	})
+
+	// other filters apply too
+	check("data source", ItemSearchParams{DataSourceName: []string{"nonexistent"}}, HistogramMonth, []expectation{})
+	check("account", ItemSearchParams{AccountID: []int64{1}}, HistogramMonth, []expectation{})
+	check("classification", ItemSearchParams{Classification: []string{ClassMessage.Name}}, HistogramYear, []expectation{
+		{"2022-01-01", 6},
+	})
+
+	if _, err := tl.TimeHistogram(context.Background(), ItemSearchParams{}, "fortnight"); err == nil {
+		t.Error("expected error for unrecognized interval")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+)
+
+// ImportItemCounts describes how many items an import produced.
+type ImportItemCounts struct {
+	// Items that were added by the import (and not deleted since).
+	Added int `json:"added"`
+
+	// Existing items that were most recently modified by the import.
+	Modified int `json:"modified"`
+
+	// All items the import gave, including existing ones that it didn't
+	// change. This is only recorded for imports done after the timeline
+	// started recording the items of each import, so it is 0 for older ones.
+	Given int `json:"given"`
+}
+
+// CountItemsByImport returns the item counts of the imports with the given IDs, or of
+// all imports if no IDs are given, keyed by import ID. Imports without any items have
+// zero counts, and IDs of imports that don't exist are not included.
+func (t *Timeline) CountItemsByImport(ctx context.Context, importIDs ...int64) (map[int64]ImportItemCounts, error) {
+	idFilter := func(col string) (string, []any) {
+		if len(importIDs) == 0 {
+			return "", nil
+		}
+		array, args := sqlArray(importIDs)
+		return " AND " + col + " IN " + array, args
+	}
+
+	t.dbMu.RLock()
+	defer t.dbMu.RUnlock()
+
+	counts := make(map[int64]ImportItemCounts)
+
+	where, args := idFilter("id")
+	rows, err := t.db.QueryContext(ctx, `SELECT id FROM imports WHERE 1=1`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying imports: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var importID int64
+		if err := rows.Scan(&importID); err != nil {
+			return nil, fmt.Errorf("scanning import ID: %v", err)
+		}
+		counts[importID] = ImportItemCounts{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating imports: %v", err)
+	}
+	rows.Close()
+
+	for _, q := range []struct {
+		col, from string
+		add       func(*ImportItemCounts, int)
+	}{
+		{"import_id", "items WHERE deleted IS NULL", func(c *ImportItemCounts, n int) { c.Added = n }},
+		{"modified_import_id", "items WHERE deleted IS NULL", func(c *ImportItemCounts, n int) { c.Modified = n }},
+		{"import_id", "import_items WHERE 1=1", func(c *ImportItemCounts, n int) { c.Given = n }},
+	} {
+		where, args := idFilter(q.col)
+		rows, err := t.db.QueryContext(ctx, `SELECT `+q.col+`, count() FROM `+q.from+where+` GROUP BY `+q.col, args...)
+		if err != nil {
+			return nil, fmt.Errorf("counting items by %s: %v", q.col, err)
+		}
+		for rows.Next() {
+			var importID *int64
+			var n int
+			if err := rows.Scan(&importID, &n); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scanning item count: %v", err)
+			}
+			if importID == nil {
+				continue
+			}
+			if c, ok := counts[*importID]; ok {
+				q.add(&c, n)
+				counts[*importID] = c
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("iterating item counts: %v", err)
+		}
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,77 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCountItemsByImport(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	latestImport := func() int64 {
+		var id int64
+		if err := tl.db.QueryRow(`SELECT max(id) FROM imports`).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	importTestItems(t, tl, testMessage("a", ts), testMessage("b", ts), testMessage("c", ts))
+	first := latestImport()
+	importTestItems(t, tl, testMessage("b", ts), testMessage("c", ts), testMessage("d", ts))
+	second := latestImport()
+	importTestItems(t, tl)
+	empty := latestImport()
+
+	if _, err := tl.db.Exec(`UPDATE items SET modified_import_id=? WHERE original_id='a'`, second); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := tl.CountItemsByImport(ctx)
+	if err != nil {
+		t.Fatalf("counting items: %v", err)
+	}
+	expected := map[int64]ImportItemCounts{
+		first:  {Added: 3, Given: 3},
+		second: {Added: 1, Modified: 1, Given: 3},
+		empty:  {},
+	}
+	if len(counts) != len(expected) {
+		t.Errorf("expected counts of %d imports, got %d: %v", len(expected), len(counts), counts)
+	}
+	for importID, want := range expected {
+		if got := counts[importID]; got != want {
+			t.Errorf("import %d: expected %+v, got %+v", importID, want, got)
+		}
+	}
+
+	// only the requested imports that exist are counted
+	counts, err = tl.CountItemsByImport(ctx, second, 9999)
+	if err != nil {
+		t.Fatalf("counting items: %v", err)
+	}
+	if len(counts) != 1 || counts[second] != expected[second] {
+		t.Errorf("expected only counts of import %d, got %v", second, counts)
+	}
+}
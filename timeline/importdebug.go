@@ -0,0 +1,211 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Reasons items or files are skipped by an import.
+const (
+	SkipReasonFileAlreadyImported = "file_already_imported"
+	SkipReasonItemUnchanged       = "item_unchanged"
+	SkipReasonItemAlreadyInImport = "item_already_in_import"
+	SkipReasonItemModified        = "item_manually_modified"
+	SkipReasonDataFileCorrupt     = "data_file_corrupt"
+)
+
+// ImportDebugBundle is information about an import that is useful
+// for troubleshooting it. It contains no secrets (as long as the
+// data source redacts its options), so it is safe to share.
+type ImportDebugBundle struct {
+	Import ImportInfo `json:"import"`
+
+	// The processing options the import was started with.
+	ProcessingOptions json.RawMessage `json:"processing_options,omitempty"`
+
+	// The data source options the import was started with, with
+	// secrets redacted. Only available if the data source can
+	// redact its options (see DataSource.RedactOptions).
+	DataSourceOptions json.RawMessage `json:"data_source_options,omitempty"`
+
+	// The number of files the import was given.
+	FileCount int `json:"file_count"`
+
+	// The number of items created by the import that are still in the
+	// timeline, and the number of other items it updated.
+	ItemCount        int64 `json:"item_count"`
+	UpdatedItemCount int64 `json:"updated_item_count"`
+
+	// The number of items and files skipped, by reason.
+	SkipReasons map[string]int64 `json:"skip_reasons,omitempty"`
+
+	Generated time.Time `json:"generated"`
+}
+
+// ExportImportDebugBundle gathers information about the import with the given
+// ID into a bundle that can be shared for troubleshooting.
+func (t *Timeline) ExportImportDebugBundle(ctx context.Context, importID int64) (ImportDebugBundle, error) {
+	imports, err := t.ListImports(ctx, ListImportsParams{ImportID: importID})
+	if err != nil {
+		return ImportDebugBundle{}, err
+	}
+	if len(imports) == 0 {
+		return ImportDebugBundle{}, fmt.Errorf("import %d not found", importID)
+	}
+	bundle := ImportDebugBundle{
+		Import:    imports[0],
+		Generated: time.Now(),
+	}
+
+	t.dbMu.RLock()
+	defer t.dbMu.RUnlock()
+
+	var procOpt, metadata, fileHashes *string
+	err = t.db.QueryRowContext(ctx, `SELECT processing_options, metadata, file_hashes FROM imports WHERE id=? LIMIT 1`,
+		importID).Scan(&procOpt, &metadata, &fileHashes)
+	if err != nil {
+		return bundle, fmt.Errorf("querying import: %v", err)
+	}
+	if procOpt != nil && *procOpt != "" {
+		bundle.ProcessingOptions = json.RawMessage(*procOpt)
+	}
+	if metadata != nil && *metadata != "" {
+		var meta importMetadata
+		if err := json.Unmarshal([]byte(*metadata), &meta); err != nil {
+			return bundle, fmt.Errorf("decoding import metadata: %v", err)
+		}
+		bundle.DataSourceOptions = meta.DataSourceOptions
+	}
+	if fileHashes != nil && *fileHashes != "" {
+		var hashes map[string]string
+		if err := json.Unmarshal([]byte(*fileHashes), &hashes); err != nil {
+			return bundle, fmt.Errorf("decoding import file hashes: %v", err)
+		}
+		bundle.FileCount = len(hashes)
+	}
+
+	err = t.db.QueryRowContext(ctx, `SELECT
+			(SELECT count() FROM items WHERE import_id=?),
+			(SELECT count() FROM items WHERE modified_import_id=? AND import_id!=?)`,
+		importID, importID, importID).Scan(&bundle.ItemCount, &bundle.UpdatedItemCount)
+	if err != nil {
+		return bundle, fmt.Errorf("counting items of import: %v", err)
+	}
+
+	rows, err := t.db.QueryContext(ctx, `SELECT reason, count FROM import_skip_reasons WHERE import_id=?`, importID)
+	if err != nil {
+		return bundle, fmt.Errorf("querying skip reasons: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reason string
+		var count int64
+		if err := rows.Scan(&reason, &count); err != nil {
+			return bundle, fmt.Errorf("scanning skip reason: %v", err)
+		}
+		if bundle.SkipReasons == nil {
+			bundle.SkipReasons = make(map[string]int64)
+		}
+		bundle.SkipReasons[reason] = count
+	}
+	if err := rows.Err(); err != nil {
+		return bundle, fmt.Errorf("iterating skip reasons: %v", err)
+	}
+
+	return bundle, nil
+}
+
+// redactedOptions returns the JSON encoding of the data source options with
+// secrets redacted, or nil if the data source can't redact its options.
+func (ds DataSource) redactedOptions(jsonOpt json.RawMessage) (json.RawMessage, error) {
+	if ds.RedactOptions == nil || len(jsonOpt) == 0 {
+		return nil, nil
+	}
+	dsOpt, err := ds.UnmarshalOptions(jsonOpt)
+	if err != nil {
+		return nil, err
+	}
+	redacted, err := json.Marshal(ds.RedactOptions(dsOpt))
+	if err != nil {
+		return nil, fmt.Errorf("encoding redacted data source options: %v", err)
+	}
+	return redacted, nil
+}
+
+// countSkip counts an item or file skipped by the import for the given reason.
+func (p *processor) countSkip(reason string) {
+	if p.skipReasonsMu == nil {
+		return
+	}
+	p.skipReasonsMu.Lock()
+	p.skipReasons[reason]++
+	p.skipReasonsMu.Unlock()
+}
+
+// existingItemSkipReason returns why the existing item was not reprocessed.
+func (p *processor) existingItemSkipReason(dbItem ItemRow) string {
+	switch {
+	case dbItem.ImportID != nil && *dbItem.ImportID == p.impRow.id:
+		return SkipReasonItemAlreadyInImport
+	case dbItem.Modified != nil && !p.params.ProcessingOptions.OverwriteModifications:
+		return SkipReasonItemModified
+	}
+	return SkipReasonItemUnchanged
+}
+
+// saveSkipReasons adds the skip counts of this run of the import to those
+// of previous runs (if it was resumed).
+func (p *processor) saveSkipReasons() error {
+	if p.skipReasonsMu == nil {
+		return nil
+	}
+	p.skipReasonsMu.Lock()
+	defer p.skipReasonsMu.Unlock()
+	if len(p.skipReasons) == 0 {
+		return nil
+	}
+
+	p.tl.dbMu.Lock()
+	defer p.tl.dbMu.Unlock()
+
+	tx, err := p.tl.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for reason, count := range p.skipReasons {
+		_, err := tx.Exec(`INSERT INTO import_skip_reasons (import_id, reason, count) VALUES (?, ?, ?)
+			ON CONFLICT (import_id, reason) DO UPDATE SET count=count+excluded.count`,
+			p.impRow.id, reason, count)
+		if err != nil {
+			return fmt.Errorf("saving skip reason %s: %v", reason, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing skip reasons: %v", err)
+	}
+	clear(p.skipReasons)
+
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redactTestDataSourceName = "test_redact"
+
+type redactTestOptions struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+func init() {
+	err := RegisterDataSource(DataSource{
+		Name:            redactTestDataSourceName,
+		Title:           "Test Redaction",
+		NewOptions:      func() any { return new(redactTestOptions) },
+		NewFileImporter: func() FileImporter { return testImporter{} },
+		RedactOptions: func(dsOpt any) any {
+			redacted := *dsOpt.(*redactTestOptions)
+			if redacted.Token != "" {
+				redacted.Token = "REDACTED"
+			}
+			return redacted
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestExportImportDebugBundle(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	const secret = "s3cr3t-t0k3n"
+	ts := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("one", ts)}
+		itemChan <- &Graph{Item: testMessage("two", ts.Add(time.Hour))}
+		return nil
+	}
+
+	// import the same items twice, so the second import skips them
+	for i := 0; i < 2; i++ {
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName:    redactTestDataSourceName,
+			Filenames:         []string{"test"},
+			DataSourceOptions: json.RawMessage(`{"username": "alice", "token": "` + secret + `"}`),
+			Labels:            map[string]string{"run": string(rune('a' + i))},
+		})
+		if err != nil {
+			t.Fatalf("import %d failed: %v", i, err)
+		}
+	}
+
+	first, err := tl.ListImports(ctx, ListImportsParams{Labels: []string{"run=a"}})
+	if err != nil || len(first) != 1 {
+		t.Fatalf("expected first import, got %v (err=%v)", first, err)
+	}
+	second, err := tl.ListImports(ctx, ListImportsParams{Labels: []string{"run=b"}})
+	if err != nil || len(second) != 1 {
+		t.Fatalf("expected second import, got %v (err=%v)", second, err)
+	}
+
+	bundle, err := tl.ExportImportDebugBundle(ctx, first[0].ID)
+	if err != nil {
+		t.Fatalf("exporting debug bundle: %v", err)
+	}
+	if bundle.Import.ID != first[0].ID || bundle.Import.DataSourceName != redactTestDataSourceName || bundle.Import.Labels["run"] != "a" {
+		t.Errorf("unexpected import info in bundle: %+v", bundle.Import)
+	}
+	if bundle.ItemCount != 2 {
+		t.Errorf("expected 2 items created by first import, got %d", bundle.ItemCount)
+	}
+	var opt redactTestOptions
+	if err := json.Unmarshal(bundle.DataSourceOptions, &opt); err != nil {
+		t.Fatalf("decoding data source options in bundle: %v (%s)", err, bundle.DataSourceOptions)
+	}
+	if opt.Username != "alice" || opt.Token != "REDACTED" {
+		t.Errorf("expected redacted data source options, got %+v", opt)
+	}
+	bundleJSON, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bundleJSON), secret) {
+		t.Errorf("debug bundle contains secret: %s", bundleJSON)
+	}
+
+	bundle, err = tl.ExportImportDebugBundle(ctx, second[0].ID)
+	if err != nil {
+		t.Fatalf("exporting debug bundle: %v", err)
+	}
+	if bundle.ItemCount != 0 {
+		t.Errorf("expected no items created by second import, got %d", bundle.ItemCount)
+	}
+	if bundle.SkipReasons[SkipReasonItemUnchanged] != 2 {
+		t.Errorf("expected 2 items skipped as unchanged, got skip reasons %v", bundle.SkipReasons)
+	}
+
+	if _, err := tl.ExportImportDebugBundle(ctx, second[0].ID+100); err == nil {
+		t.Error("expected error for nonexistent import")
+	}
+}
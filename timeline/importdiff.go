@@ -0,0 +1,230 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ImportDiff describes how two imports of the same data source differ, for
+// example two imports of the same archive before and after a parser change.
+// Items are matched by their original ID (or by the item they were
+// deduplicated into, if the data source doesn't give IDs).
+type ImportDiff struct {
+	ImportA int64 `json:"import_a"`
+	ImportB int64 `json:"import_b"`
+
+	OnlyInA []ItemRow     `json:"only_in_a,omitempty"`
+	OnlyInB []ItemRow     `json:"only_in_b,omitempty"`
+	Changed []ChangedItem `json:"changed,omitempty"`
+}
+
+// ChangedItem is an item given by both imports but with different field values.
+// Item is the item as currently stored in the timeline, which depending on update
+// policies may reflect either import.
+type ChangedItem struct {
+	Item   ItemRow  `json:"item"`
+	Fields []string `json:"fields"` // names of the fields that differ
+}
+
+// Diff compares two imports of the same data source. Only imports that were done after
+// the timeline started recording the items of each import can be compared. The contents
+// of data files are not compared, only their filenames and media types, since data files
+// of items that already exist are not downloaded again.
+func (tl *Timeline) Diff(ctx context.Context, importA, importB int64) (ImportDiff, error) {
+	diff := ImportDiff{ImportA: importA, ImportB: importB}
+
+	impA, err := tl.loadImport(ctx, importA)
+	if err != nil {
+		return diff, err
+	}
+	impB, err := tl.loadImport(ctx, importB)
+	if err != nil {
+		return diff, err
+	}
+	if impA.dataSourceName != impB.dataSourceName {
+		return diff, fmt.Errorf("imports are of different data sources: %s and %s", impA.dataSourceName, impB.dataSourceName)
+	}
+
+	const onlyIn = `items.id IN (SELECT item_id FROM import_items WHERE import_id=?
+		EXCEPT SELECT item_id FROM import_items WHERE import_id=?)`
+	diff.OnlyInA, err = tl.itemsWhere(ctx, onlyIn, importA, importB)
+	if err != nil {
+		return diff, err
+	}
+	diff.OnlyInB, err = tl.itemsWhere(ctx, onlyIn, importB, importA)
+	if err != nil {
+		return diff, err
+	}
+
+	changedFields, err := tl.changedImportItems(ctx, importA, importB)
+	if err != nil {
+		return diff, err
+	}
+	if len(changedFields) == 0 {
+		return diff, nil
+	}
+	rowIDs := make([]int64, 0, len(changedFields))
+	for rowID := range changedFields {
+		rowIDs = append(rowIDs, rowID)
+	}
+	array, args := sqlArray(rowIDs)
+	changed, err := tl.itemsWhere(ctx, "items.id IN "+array, args...)
+	if err != nil {
+		return diff, err
+	}
+	for _, ir := range changed {
+		diff.Changed = append(diff.Changed, ChangedItem{Item: ir, Fields: changedFields[ir.ID]})
+	}
+
+	return diff, nil
+}
+
+// changedImportItems returns the names of the fields that differ, keyed by item row ID,
+// for items given by both imports.
+func (tl *Timeline) changedImportItems(ctx context.Context, importA, importB int64) (map[int64][]string, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx,
+		`SELECT a.item_id, a.field_digests, b.field_digests
+		FROM import_items AS a
+		JOIN import_items AS b ON b.item_id = a.item_id AND b.import_id=?
+		WHERE a.import_id=? AND a.field_digests IS NOT b.field_digests`, importB, importA)
+	if err != nil {
+		return nil, fmt.Errorf("querying items of both imports: %v", err)
+	}
+	defer rows.Close()
+
+	changed := make(map[int64][]string)
+	for rows.Next() {
+		var rowID int64
+		var digestsA, digestsB *string
+		if err := rows.Scan(&rowID, &digestsA, &digestsB); err != nil {
+			return nil, fmt.Errorf("scanning item digests: %v", err)
+		}
+		fields, err := changedFieldDigests(digestsA, digestsB)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %v", rowID, err)
+		}
+		if len(fields) > 0 {
+			changed[rowID] = fields
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item digests: %v", err)
+	}
+
+	return changed, nil
+}
+
+// changedFieldDigests returns the sorted names of the fields whose digests differ.
+func changedFieldDigests(digestsA, digestsB *string) ([]string, error) {
+	var a, b map[string]string
+	if digestsA != nil {
+		if err := json.Unmarshal([]byte(*digestsA), &a); err != nil {
+			return nil, fmt.Errorf("decoding field digests: %v", err)
+		}
+	}
+	if digestsB != nil {
+		if err := json.Unmarshal([]byte(*digestsB), &b); err != nil {
+			return nil, fmt.Errorf("decoding field digests: %v", err)
+		}
+	}
+	var fields []string
+	for field, digest := range a {
+		if b[field] != digest {
+			fields = append(fields, field)
+		}
+	}
+	for field := range b {
+		if _, ok := a[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// recordImportItem records that the current import gave the item with the given row ID,
+// along with digests of the item's fields as they were given.
+func (p *processor) recordImportItem(ctx context.Context, tx *sql.Tx, rowID int64, it *Item) error {
+	digests, err := json.Marshal(itemFieldDigests(it))
+	if err != nil {
+		return fmt.Errorf("encoding item field digests: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO import_items (import_id, item_id, field_digests) VALUES (?, ?, ?)
+		ON CONFLICT (import_id, item_id) DO UPDATE SET field_digests=excluded.field_digests`,
+		p.impRow.id, rowID, string(digests))
+	if err != nil {
+		return fmt.Errorf("recording item of import: %v (row_id=%d)", err, rowID)
+	}
+	return nil
+}
+
+// itemFieldDigests returns short hashes of the values of the item's fields that
+// are given by the data source, keyed by field name. Empty fields are omitted.
+func itemFieldDigests(it *Item) map[string]string {
+	digests := make(map[string]string)
+	add := func(field string, value any) {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return
+		}
+		h := newHash()
+		h.Write(encoded)
+		digests[field] = hex.EncodeToString(h.Sum(nil)[:8])
+	}
+	if it.Classification.Name != "" {
+		add("classification", it.Classification.Name)
+	}
+	if !it.Timestamp.IsZero() {
+		add("timestamp", it.Timestamp.UnixMilli())
+	}
+	if !it.Timespan.IsZero() {
+		add("timespan", it.Timespan.UnixMilli())
+	}
+	if !it.Timeframe.IsZero() {
+		add("timeframe", it.Timeframe.UnixMilli())
+	}
+	if it.OriginalLocation != "" {
+		add("original_location", it.OriginalLocation)
+	}
+	if it.Content.Filename != "" {
+		add("filename", it.Content.Filename)
+	}
+	if it.Content.MediaType != "" {
+		add("data_type", it.Content.MediaType)
+	}
+	if it.dataText != nil {
+		add("data_text", *it.dataText)
+	}
+	if !it.Location.IsEmpty() {
+		add("location", it.Location)
+	}
+	if it.Metadata.Clean(); len(it.Metadata) > 0 {
+		add("metadata", it.Metadata)
+	}
+	return digests
+}
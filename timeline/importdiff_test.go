@@ -0,0 +1,78 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestImportDiff(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	ts := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	importTestItems(t, tl, testMessage("same", ts), testMessage("changed", ts), testMessage("removed", ts))
+
+	changed := testMessage("changed", ts)
+	changed.Content.Data = StringData("message changed, but differently parsed")
+	importTestItems(t, tl, testMessage("same", ts), changed, testMessage("added", ts))
+
+	var importA, importB int64
+	err := tl.db.QueryRow(`SELECT min(id), max(id) FROM imports`).Scan(&importA, &importB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := tl.Diff(ctx, importA, importB)
+	if err != nil {
+		t.Fatalf("diffing imports: %v", err)
+	}
+
+	originalIDs := func(items []ItemRow) []string {
+		var ids []string
+		for _, ir := range items {
+			ids = append(ids, *ir.OriginalID)
+		}
+		return ids
+	}
+	if got := originalIDs(diff.OnlyInA); !reflect.DeepEqual(got, []string{"removed"}) {
+		t.Errorf("expected only 'removed' to be only in A, got %v", got)
+	}
+	if got := originalIDs(diff.OnlyInB); !reflect.DeepEqual(got, []string{"added"}) {
+		t.Errorf("expected only 'added' to be only in B, got %v", got)
+	}
+	if len(diff.Changed) != 1 || *diff.Changed[0].Item.OriginalID != "changed" {
+		t.Fatalf("expected only 'changed' to be changed, got %+v", diff.Changed)
+	}
+	if !reflect.DeepEqual(diff.Changed[0].Fields, []string{"data_text"}) {
+		t.Errorf("expected only the text to have changed, got %v", diff.Changed[0].Fields)
+	}
+
+	// the other way around, the additions and removals are swapped
+	reverse, err := tl.Diff(ctx, importB, importA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(originalIDs(reverse.OnlyInA), originalIDs(diff.OnlyInB)) || len(reverse.Changed) != 1 {
+		t.Errorf("expected reversed diff to mirror the original, got %+v", reverse)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// validateImportLabels ensures the labels can be matched by label selectors.
+func validateImportLabels(labels map[string]string) error {
+	for key, val := range labels {
+		if key == "" {
+			return fmt.Errorf("import label key cannot be empty")
+		}
+		if strings.ContainsAny(key, "=!, ") {
+			return fmt.Errorf("import label key cannot contain '=', '!', ',', or spaces: %q", key)
+		}
+		if strings.Contains(val, ",") {
+			return fmt.Errorf("import label %s: value cannot contain ',': %q", key, val)
+		}
+	}
+	return nil
+}
+
+func (t *Timeline) setImportLabels(ctx context.Context, importID int64, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	t.dbMu.Lock()
+	defer t.dbMu.Unlock()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for key, val := range labels {
+		_, err = tx.ExecContext(ctx, `INSERT INTO import_labels (import_id, key, value) VALUES (?, ?, ?)`, importID, key, val)
+		if err != nil {
+			return fmt.Errorf("saving import label %s: %v", key, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ImportInfo describes an import in the timeline's history.
+type ImportInfo struct {
+	ID             int64             `json:"id"`
+	DataSourceName string            `json:"data_source_name,omitempty"`
+	Mode           string            `json:"mode"`
+	AccountID      *int64            `json:"account_id,omitempty"`
+	Started        time.Time         `json:"started"`
+	Ended          *time.Time        `json:"ended,omitempty"`
+	Status         string            `json:"status"`
+	CancelReason   CancelReason      `json:"cancel_reason,omitempty"` // why the import stopped early, if it did
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+// ListImportsParams filters and limits the results of ListImports.
+type ListImportsParams struct {
+	// Only list the import with this ID.
+	ImportID int64 `json:"import_id,omitempty"`
+
+	// Only list imports from this data source.
+	DataSourceName string `json:"data_source_name,omitempty"`
+
+	// Only list imports of this account.
+	AccountID int64 `json:"account_id,omitempty"`
+
+	// Label selectors which all must match an import's labels
+	// for it to be listed. A selector is one of:
+	//
+	//   - "key=value": the label has the value
+	//   - "key!=value": the label is absent or has a different value
+	//   - "key": the label is present, with any value
+	//   - "!key": the label is absent
+	//
+	// Multiple selectors may also be given in one string,
+	// separated by commas, e.g. "env=prod,!test".
+	Labels []string `json:"labels,omitempty"`
+
+	// The maximum number of imports to list. Default: no limit.
+	Limit int `json:"limit,omitempty"`
+}
+
+// ListImports lists the imports in the timeline, most recent first.
+func (t *Timeline) ListImports(ctx context.Context, params ListImportsParams) ([]ImportInfo, error) {
+	q := `SELECT imports.id, data_sources.name, imports.mode, imports.account_id,
+			imports.started, imports.ended, imports.status, coalesce(imports.cancel_reason, '')
+		FROM imports
+		LEFT JOIN data_sources ON data_sources.id = imports.data_source_id`
+
+	var clauses []string
+	var args []any
+	if params.ImportID != 0 {
+		clauses = append(clauses, "imports.id=?")
+		args = append(args, params.ImportID)
+	}
+	if params.DataSourceName != "" {
+		clauses = append(clauses, "data_sources.name=?")
+		args = append(args, params.DataSourceName)
+	}
+	if params.AccountID != 0 {
+		clauses = append(clauses, "imports.account_id=?")
+		args = append(args, params.AccountID)
+	}
+	for _, selectors := range params.Labels {
+		for _, selector := range strings.Split(selectors, ",") {
+			clause, clauseArgs, err := labelSelectorClause(strings.TrimSpace(selector))
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, clause)
+			args = append(args, clauseArgs...)
+		}
+	}
+	if len(clauses) > 0 {
+		q += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	q += " ORDER BY imports.started DESC, imports.id DESC"
+	if params.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, params.Limit)
+	}
+
+	t.dbMu.RLock()
+	defer t.dbMu.RUnlock()
+
+	rows, err := t.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying imports: %v", err)
+	}
+	defer rows.Close()
+
+	var imports []ImportInfo
+	byID := make(map[int64]int)
+	for rows.Next() {
+		var imp ImportInfo
+		var dsName *string
+		var started int64
+		var ended *int64
+		if err := rows.Scan(&imp.ID, &dsName, &imp.Mode, &imp.AccountID, &started, &ended, &imp.Status, &imp.CancelReason); err != nil {
+			return nil, fmt.Errorf("scanning import: %v", err)
+		}
+		if dsName != nil {
+			imp.DataSourceName = *dsName
+		}
+		imp.Started = time.Unix(started, 0)
+		if ended != nil {
+			endedTime := time.Unix(*ended, 0)
+			imp.Ended = &endedTime
+		}
+		byID[imp.ID] = len(imports)
+		imports = append(imports, imp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating imports: %v", err)
+	}
+	rows.Close()
+
+	if len(imports) == 0 {
+		return imports, nil
+	}
+
+	// fill in the labels of the listed imports
+	labelRows, err := t.db.QueryContext(ctx, `SELECT import_id, key, value FROM import_labels`)
+	if err != nil {
+		return nil, fmt.Errorf("querying import labels: %v", err)
+	}
+	defer labelRows.Close()
+	for labelRows.Next() {
+		var importID int64
+		var key, val string
+		if err := labelRows.Scan(&importID, &key, &val); err != nil {
+			return nil, fmt.Errorf("scanning import label: %v", err)
+		}
+		i, ok := byID[importID]
+		if !ok {
+			continue
+		}
+		if imports[i].Labels == nil {
+			imports[i].Labels = make(map[string]string)
+		}
+		imports[i].Labels[key] = val
+	}
+	if err := labelRows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating import labels: %v", err)
+	}
+
+	return imports, nil
+}
+
+// labelSelectorClause returns the SQL clause and its arguments which match
+// imports by the label selector (see ListImportsParams.Labels).
+func labelSelectorClause(selector string) (string, []any, error) {
+	const hasLabel = "EXISTS (SELECT 1 FROM import_labels WHERE import_labels.import_id=imports.id AND import_labels.key=?"
+
+	if selector == "" {
+		return "", nil, fmt.Errorf("empty label selector")
+	}
+	if key, val, ok := strings.Cut(selector, "!="); ok {
+		if err := validateImportLabels(map[string]string{key: val}); err != nil {
+			return "", nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+		}
+		return "NOT " + hasLabel + " AND import_labels.value=?)", []any{key, val}, nil
+	}
+	if key, val, ok := strings.Cut(selector, "="); ok {
+		if err := validateImportLabels(map[string]string{key: val}); err != nil {
+			return "", nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+		}
+		return hasLabel + " AND import_labels.value=?)", []any{key, val}, nil
+	}
+	if key, ok := strings.CutPrefix(selector, "!"); ok {
+		if err := validateImportLabels(map[string]string{key: ""}); err != nil {
+			return "", nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+		}
+		return "NOT " + hasLabel + ")", []any{key}, nil
+	}
+	if err := validateImportLabels(map[string]string{selector: ""}); err != nil {
+		return "", nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+	}
+	return hasLabel + ")", []any{selector}, nil
+}
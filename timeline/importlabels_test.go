@@ -0,0 +1,129 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestListImportsByLabels(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("a", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))}
+		return nil
+	}
+
+	labelSets := []map[string]string{
+		{"env": "prod", "team": "a"},
+		{"env": "staging"},
+		nil,
+	}
+	var importIDs []int64
+	for _, labels := range labelSets {
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			Labels:         labels,
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		all, err := tl.ListImports(ctx, ListImportsParams{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		importIDs = append(importIDs, all[0].ID)
+		if !reflect.DeepEqual(all[0].Labels, labels) {
+			t.Errorf("import %d: expected labels %v, got %v", all[0].ID, labels, all[0].Labels)
+		}
+	}
+
+	for _, tc := range []struct {
+		selectors []string
+		expect    []int // indices of labelSets
+	}{
+		{nil, []int{0, 1, 2}},
+		{[]string{"env=prod"}, []int{0}},
+		{[]string{"env!=prod"}, []int{1, 2}},
+		{[]string{"env"}, []int{0, 1}},
+		{[]string{"!env"}, []int{2}},
+		{[]string{"env=prod", "team=a"}, []int{0}},
+		{[]string{"env=prod,team=b"}, nil},
+		{[]string{"env, !team"}, []int{1}},
+	} {
+		results, err := tl.ListImports(ctx, ListImportsParams{Labels: tc.selectors})
+		if err != nil {
+			t.Errorf("selectors %q: %v", tc.selectors, err)
+			continue
+		}
+		var got, expect []int64
+		for _, imp := range results {
+			got = append(got, imp.ID)
+		}
+		for _, i := range tc.expect {
+			expect = append(expect, importIDs[i])
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, expect) {
+			t.Errorf("selectors %q: expected imports %v, got %v", tc.selectors, expect, got)
+		}
+	}
+
+	for _, bad := range []string{"=prod", "my env=prod", "!"} {
+		if _, err := tl.ListImports(ctx, ListImportsParams{Labels: []string{bad}}); err == nil {
+			t.Errorf("expected error for label selector %q", bad)
+		}
+	}
+
+	err := tl.Import(ctx, ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		Labels:         map[string]string{"a=b": "c"},
+	})
+	if err == nil {
+		t.Error("expected error importing with invalid label key")
+	}
+}
+
+func TestImportLabelsTableCreatedForExistingRepo(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// simulate a repo created before import labels existed
+	if _, err := tl.db.Exec(`DROP TABLE import_labels`); err != nil {
+		t.Fatal(err)
+	}
+	if err := provisionDB(tl.db); err != nil {
+		t.Fatalf("provisioning existing database: %v", err)
+	}
+
+	importTestItems(t, tl, testMessage("a", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	imports, err := tl.ListImports(context.Background(), ListImportsParams{Labels: []string{"!env"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imports) != 1 {
+		t.Errorf("expected 1 import, got %d", len(imports))
+	}
+}
@@ -0,0 +1,69 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logLevel returns the parsed LogLevel, or nil if it is not set.
+func (params ImportParameters) logLevel() (*zapcore.Level, error) {
+	if params.LogLevel == "" {
+		return nil, nil
+	}
+	level, err := zapcore.ParseLevel(params.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %v", err)
+	}
+	return &level, nil
+}
+
+// withLevelOverride returns a logger derived from logger that logs at the given
+// level, regardless of the levels (and sampling) configured on logger's core.
+// Loggers derived from the returned logger inherit the override.
+func withLevelOverride(logger *zap.Logger, level zapcore.LevelEnabler) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return levelOverrideCore{Core: core, level: level}
+	}))
+}
+
+// levelOverrideCore decides for itself which entries are enabled, then writes
+// them directly to the underlying core, bypassing its own level checks.
+type levelOverrideCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func (c levelOverrideCore) Enabled(level zapcore.Level) bool { return c.level.Enabled(level) }
+
+func (c levelOverrideCore) Level() zapcore.Level { return zapcore.LevelOf(c.level) }
+
+func (c levelOverrideCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelOverrideCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c levelOverrideCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
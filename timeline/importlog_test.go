@@ -0,0 +1,99 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestImportLogLevel(t *testing.T) {
+	// the process log only emits info and above; restore it after the timeline is closed
+	origLog := Log
+	core, logs := observer.New(zapcore.InfoLevel)
+	Log = zap.New(core)
+	t.Cleanup(func() { Log = origLog })
+
+	tl := newTestTimeline(t)
+	ts := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	importWithLevel := func(name, level string) {
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for i := range 3 {
+				itemChan <- &Graph{Item: testFileItem(fmt.Sprintf("%s%d", name, i), ts.Add(time.Duration(i)*time.Hour))}
+			}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{name},
+			LogLevel:       level,
+		})
+		if err != nil {
+			t.Fatalf("import %s failed: %v", name, err)
+		}
+	}
+	importWithLevel("default", "")
+	importWithLevel("debug", "debug")
+	importWithLevel("warn", "warn")
+
+	entryImport := func(e observer.LoggedEntry) string {
+		if filenames, ok := e.ContextMap()["filenames"].([]any); ok && len(filenames) == 1 {
+			return filenames[0].(string)
+		}
+		return ""
+	}
+
+	debugLines, progressLines := make(map[string]int), make(map[string]int)
+	for _, e := range logs.All() {
+		if e.Level == zapcore.DebugLevel {
+			debugLines[entryImport(e)]++
+		}
+		if e.LoggerName == "processor.progress" {
+			progressLines[entryImport(e)]++
+		}
+	}
+
+	if debugLines["debug"] == 0 {
+		t.Error("expected debug lines from the import with log level overridden to debug")
+	}
+	if len(debugLines) != 1 {
+		t.Errorf("expected debug lines only from the overridden import, got %v", debugLines)
+	}
+	if progressLines["default"] == 0 || progressLines["debug"] == 0 {
+		t.Errorf("expected progress lines from imports logging at info or debug, got %v", progressLines)
+	}
+	if progressLines["warn"] > 0 {
+		t.Errorf("expected progress logger to inherit the warn level override, got %v", progressLines)
+	}
+
+	if err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"bogus"},
+		LogLevel:       "chatty",
+	}); err == nil {
+		t.Error("expected error for invalid log level")
+	}
+}
@@ -0,0 +1,159 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+// MergeImports consolidates imports that are re-runs of the same import into
+// the import with ID keepID: everything that refers to one of the imports with
+// mergeIDs (items, entities, notes, the items each import gave, labels, etc.)
+// is re-pointed to the kept import, their skip counts are added to its counts,
+// and then the merged import rows are deleted. The kept import spans the time
+// of all the merged imports. Labels of the kept import take precedence over
+// those of the merged imports. Item data is never touched.
+//
+// All the imports must be from the same data source and account, and none of
+// them may be running. Either all of the imports are merged, or none are.
+func (t *Timeline) MergeImports(ctx context.Context, keepID int64, mergeIDs []int64) error {
+	if err := t.checkWritable("merge imports"); err != nil {
+		return err
+	}
+
+	if len(mergeIDs) == 0 {
+		return nil
+	}
+	if slices.Contains(mergeIDs, keepID) {
+		return fmt.Errorf("cannot merge import %d into itself", keepID)
+	}
+	allIDs := append([]int64{keepID}, mergeIDs...)
+
+	t.dbMu.Lock()
+	defer t.dbMu.Unlock()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// make sure the imports can be merged
+	var keepDataSourceID, keepAccountID *int64
+	for i, importID := range allIDs {
+		var dataSourceID, accountID *int64
+		var status string
+		err := tx.QueryRowContext(ctx, `SELECT data_source_id, account_id, status FROM imports WHERE id=? LIMIT 1`,
+			importID).Scan(&dataSourceID, &accountID, &status)
+		if err != nil {
+			return fmt.Errorf("loading import %d: %v", importID, err)
+		}
+		if status == importStatusStarted {
+			return fmt.Errorf("import %d is still running", importID)
+		}
+		if i == 0 {
+			keepDataSourceID, keepAccountID = dataSourceID, accountID
+			continue
+		}
+		if (dataSourceID == nil) != (keepDataSourceID == nil) ||
+			(dataSourceID != nil && *dataSourceID != *keepDataSourceID) {
+			return fmt.Errorf("import %d is from a different data source than import %d", importID, keepID)
+		}
+		if (accountID == nil) != (keepAccountID == nil) ||
+			(accountID != nil && *accountID != *keepAccountID) {
+			return fmt.Errorf("import %d is from a different account than import %d", importID, keepID)
+		}
+	}
+
+	merged, mergedArgs := sqlArray(mergeIDs)
+	all, allArgs := sqlArray(allIDs)
+	withKeep := func(args []any) []any { return append([]any{keepID}, args...) }
+
+	for _, q := range []struct {
+		desc  string
+		query string
+		args  []any
+	}{
+		// an item is only modified by an import other than the one that added it
+		{"clearing modifications of items by their own import",
+			`UPDATE items SET modified_import_id=NULL WHERE modified_import_id IN ` + all + ` AND import_id IN ` + all,
+			append(slices.Clone(allArgs), allArgs...)},
+		{"re-pointing modified items", `UPDATE items SET modified_import_id=? WHERE modified_import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing items", `UPDATE items SET import_id=? WHERE import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing item versions", `UPDATE item_versions SET import_id=? WHERE import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing entities", `UPDATE entities SET import_id=? WHERE import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing entity names", `UPDATE entities SET name_import_id=? WHERE name_import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing entity pictures", `UPDATE entities SET picture_import_id=? WHERE picture_import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing entity attributes", `UPDATE entity_attributes SET import_id=? WHERE import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing autolinked entity attributes", `UPDATE entity_attributes SET autolink_import_id=? WHERE autolink_import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing notes", `UPDATE notes SET import_id=? WHERE import_id IN ` + merged, withKeep(mergedArgs)},
+
+		// rows that the kept import already has are deleted along with the merged imports
+		{"re-pointing items given by the imports", `UPDATE OR IGNORE import_items SET import_id=? WHERE import_id IN ` + merged, withKeep(mergedArgs)},
+		{"re-pointing labels", `UPDATE OR IGNORE import_labels SET import_id=? WHERE import_id IN ` + merged, withKeep(mergedArgs)},
+		{"adding up skip reasons",
+			`INSERT INTO import_skip_reasons (import_id, reason, count)
+			SELECT ?, reason, sum(count) FROM import_skip_reasons WHERE import_id IN ` + merged + ` GROUP BY reason
+			ON CONFLICT (import_id, reason) DO UPDATE SET count=count+excluded.count`,
+			withKeep(mergedArgs)},
+
+		// the kept import covers the whole time the imports ran
+		{"extending the kept import",
+			`UPDATE imports SET
+				started=(SELECT min(started) FROM imports WHERE id IN ` + all + `),
+				ended=(SELECT max(ended) FROM imports WHERE id IN ` + all + `)
+			WHERE id=?`,
+			append(append(slices.Clone(allArgs), allArgs...), keepID)},
+	} {
+		if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+			return fmt.Errorf("%s: %v", q.desc, err)
+		}
+	}
+
+	// files that were successfully imported by any of the imports still count
+	// as imported, so they can be skipped by later imports
+	for _, importID := range mergeIDs {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE imports SET file_hashes=json_patch(coalesce(imports.file_hashes, '{}'), merged.file_hashes)
+			FROM (SELECT file_hashes FROM imports WHERE id=? AND status=? AND file_hashes IS NOT NULL) AS merged
+			WHERE imports.id=?`,
+			importID, importStatusSuccess, keepID)
+		if err != nil {
+			return fmt.Errorf("merging file hashes of import %d: %v", importID, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM imports WHERE id IN `+merged, mergedArgs...); err != nil {
+		return fmt.Errorf("deleting merged imports: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+
+	Log.Info("merged imports",
+		zap.Int64("kept_import_id", keepID),
+		zap.Int64s("merged_import_ids", mergeIDs))
+
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMergeImports(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	importItems := func(dataSourceName string, labels map[string]string, items ...*Item) int64 {
+		t.Helper()
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, it := range items {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName: dataSourceName,
+			Filenames:      []string{"test"},
+			Labels:         labels,
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		var id int64
+		if err := tl.db.QueryRow(`SELECT max(id) FROM imports`).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// the same data is imported again, with one new item
+	first := importItems(testDataSourceName, map[string]string{"run": "first"},
+		testMessage("a", ts), testMessage("b", ts))
+	second := importItems(testDataSourceName, map[string]string{"run": "second", "env": "prod"},
+		testMessage("a", ts), testMessage("b", ts), testMessage("c", ts))
+
+	// an import from another data source can't be merged
+	otherSource := fmt.Sprintf("merge_other_%d", time.Now().UnixNano())
+	if err := RegisterDataSource(DataSource{
+		Name:            otherSource,
+		Title:           "Other test source",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	other := importItems(otherSource, nil, testMessage("d", ts))
+	if err := tl.MergeImports(ctx, first, []int64{second, other}); err == nil {
+		t.Fatal("expected error merging imports from different data sources")
+	}
+	var imports int
+	if err := tl.db.QueryRow(`SELECT count() FROM imports WHERE id IN (?, ?, ?)`, first, second, other).Scan(&imports); err != nil {
+		t.Fatal(err)
+	}
+	if imports != 3 {
+		t.Fatalf("expected failed merge to leave all 3 imports, but %d remain", imports)
+	}
+
+	if err := tl.MergeImports(ctx, first, []int64{second}); err != nil {
+		t.Fatalf("merging imports: %v", err)
+	}
+
+	rows, err := tl.db.Query(`SELECT original_id, import_id, modified_import_id FROM items WHERE original_id IN ('a', 'b', 'c')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var items int
+	for rows.Next() {
+		var originalID string
+		var importID int64
+		var modifiedImportID *int64
+		if err := rows.Scan(&originalID, &importID, &modifiedImportID); err != nil {
+			t.Fatal(err)
+		}
+		items++
+		if importID != first {
+			t.Errorf("expected item %s to belong to kept import %d, got %d", originalID, first, importID)
+		}
+		if modifiedImportID != nil {
+			t.Errorf("expected item %s not to be modified by another import, got %d", originalID, *modifiedImportID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if items != 3 {
+		t.Errorf("expected 3 items, got %d", items)
+	}
+
+	var remaining int
+	if err := tl.db.QueryRow(`SELECT count() FROM imports WHERE id=?`, second).Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Error("expected merged import to be deleted")
+	}
+
+	counts, err := tl.CountItemsByImport(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := counts[first]; c.Added != 3 || c.Given != 3 {
+		t.Errorf("expected kept import to have added and given 3 items, got %+v", c)
+	}
+
+	bundle, err := tl.ExportImportDebugBundle(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bundle.SkipReasons[SkipReasonItemUnchanged]; n != 2 {
+		t.Errorf("expected skip counts of merged import to be added, got %v", bundle.SkipReasons)
+	}
+	if labels := bundle.Import.Labels; labels["run"] != "first" || labels["env"] != "prod" {
+		t.Errorf("expected kept import's labels plus new ones, got %v", labels)
+	}
+}
+
+func TestMergeImportsFromDifferentAccounts(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	importWithAccount := func(accountID int64, it *Item) int64 {
+		t.Helper()
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			itemChan <- &Graph{Item: it}
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			AccountID:      accountID,
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		var id int64
+		if err := tl.db.QueryRow(`SELECT max(id) FROM imports`).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	acc, err := tl.CreateAccount(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAcc, err := tl.CreateAccount(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := importWithAccount(acc.ID, testMessage("a", ts))
+	second := importWithAccount(otherAcc.ID, testMessage("b", ts))
+	withoutAccount := importWithAccount(0, testMessage("c", ts))
+
+	for _, mergeID := range []int64{second, withoutAccount} {
+		if err := tl.MergeImports(ctx, first, []int64{mergeID}); err == nil {
+			t.Errorf("expected error merging import %d from a different account", mergeID)
+		}
+	}
+	var imports int
+	if err := tl.db.QueryRow(`SELECT count() FROM imports WHERE id IN (?, ?, ?)`, first, second, withoutAccount).Scan(&imports); err != nil {
+		t.Fatal(err)
+	}
+	if imports != 3 {
+		t.Errorf("expected failed merges to leave all 3 imports, but %d remain", imports)
+	}
+}
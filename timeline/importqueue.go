@@ -0,0 +1,251 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// QueuedImportState is the state of an import in the import queue.
+type QueuedImportState string
+
+const (
+	QueueStateQueued  QueuedImportState = "queued"
+	QueueStateRunning QueuedImportState = "running"
+	QueueStateDone    QueuedImportState = "done"
+)
+
+// defaultMaxConcurrentImports is how many queued imports run at the
+// same time, unless configured otherwise. Imports contend for the
+// database, so running many at once rarely makes them finish sooner.
+const defaultMaxConcurrentImports = 1
+
+// QueuedImport is a handle to an import that was enqueued with EnqueueImport.
+// Its progress can be followed by its job ID (see ProgressHandler) once it runs.
+type QueuedImport struct {
+	JobID    string `json:"job_id"`
+	Priority int    `json:"priority"`
+
+	tl     *Timeline
+	params ImportParameters
+	order  int64 // order of enqueueing, to run jobs of the same priority first-come, first-served
+	index  int   // index in the queue's heap, or -1 if not waiting
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// protected by the queue's mutex
+	state QueuedImportState
+	err   error
+}
+
+// State returns the current state of the import.
+func (qi *QueuedImport) State() QueuedImportState {
+	qi.tl.importQueue.mu.Lock()
+	defer qi.tl.importQueue.mu.Unlock()
+	return qi.state
+}
+
+// Done returns a channel that is closed when the import is done,
+// whether it succeeded, failed, or was canceled.
+func (qi *QueuedImport) Done() <-chan struct{} { return qi.done }
+
+// Wait waits for the import to be done and returns its error, if any.
+// If ctx is done first, its error is returned instead (but the import
+// is not canceled).
+func (qi *QueuedImport) Wait(ctx context.Context) error {
+	select {
+	case <-qi.done:
+		qi.tl.importQueue.mu.Lock()
+		defer qi.tl.importQueue.mu.Unlock()
+		return qi.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel cancels the import. If it is still waiting in the queue,
+// it is removed from the queue without running.
+func (qi *QueuedImport) Cancel() {
+	q := &qi.tl.importQueue
+	q.mu.Lock()
+	if qi.state == QueueStateQueued {
+		heap.Remove(&q.waiting, qi.index)
+		qi.finish(context.Canceled)
+	}
+	q.mu.Unlock()
+	qi.cancel()
+}
+
+// finish marks the import as done. The queue's mutex must be held.
+func (qi *QueuedImport) finish(err error) {
+	delete(qi.tl.importQueue.jobs, qi.JobID)
+	qi.state, qi.err = QueueStateDone, err
+	close(qi.done)
+}
+
+// importQueue runs enqueued imports by priority, a limited number at a time.
+type importQueue struct {
+	mu       sync.Mutex
+	waiting  queuedImportHeap
+	jobs     map[string]*QueuedImport // queued and running imports, by job ID
+	running  int
+	max      int // if 0, defaultMaxConcurrentImports
+	enqueued int64
+}
+
+// SetMaxConcurrentImports sets how many imports from the import queue (see
+// EnqueueImport) may run at the same time. Imports started by calling Import
+// directly are not limited. If max is 0, the default of 1 is used.
+func (tl *Timeline) SetMaxConcurrentImports(max int) error {
+	if max < 0 {
+		return fmt.Errorf("maximum concurrent imports cannot be negative: %d", max)
+	}
+	q := &tl.importQueue
+	q.mu.Lock()
+	q.max = max
+	tl.dispatchQueuedImports()
+	q.mu.Unlock()
+	return nil
+}
+
+// EnqueueImport adds an import to the import queue and returns a handle to it.
+// Imports in the queue run when there is room (see SetMaxConcurrentImports);
+// those with higher priority run first, and those with the same priority run
+// in the order they were enqueued. If the parameters don't have a job ID, one
+// is assigned. The import runs until it is done, canceled, or the timeline is
+// closed.
+func (tl *Timeline) EnqueueImport(params ImportParameters, priority int) (*QueuedImport, error) {
+	if err := tl.checkWritable("import"); err != nil {
+		return nil, err
+	}
+	if params.JobID == "" {
+		params.JobID = uuid.NewString()
+	}
+
+	q := &tl.importQueue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.jobs[params.JobID]; ok {
+		return nil, fmt.Errorf("import job %s is already queued", params.JobID)
+	}
+	if q.jobs == nil {
+		q.jobs = make(map[string]*QueuedImport)
+	}
+
+	ctx, cancel := context.WithCancel(tl.ctx)
+	q.enqueued++
+	qi := &QueuedImport{
+		JobID:    params.JobID,
+		Priority: priority,
+		tl:       tl,
+		params:   params,
+		order:    q.enqueued,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		state:    QueueStateQueued,
+	}
+	q.jobs[qi.JobID] = qi
+	heap.Push(&q.waiting, qi)
+
+	tl.dispatchQueuedImports()
+
+	return qi, nil
+}
+
+// QueuedImport returns the handle of the queued or running import
+// with the given job ID, if it was enqueued with EnqueueImport.
+func (tl *Timeline) QueuedImport(jobID string) (*QueuedImport, bool) {
+	tl.importQueue.mu.Lock()
+	defer tl.importQueue.mu.Unlock()
+	qi, ok := tl.importQueue.jobs[jobID]
+	return qi, ok
+}
+
+// dispatchQueuedImports starts waiting imports while there is room.
+// The queue's mutex must be held.
+func (tl *Timeline) dispatchQueuedImports() {
+	q := &tl.importQueue
+	maxRunning := q.max
+	if maxRunning == 0 {
+		maxRunning = defaultMaxConcurrentImports
+	}
+	for q.running < maxRunning && q.waiting.Len() > 0 {
+		qi := heap.Pop(&q.waiting).(*QueuedImport)
+		qi.state = QueueStateRunning
+		q.running++
+		go tl.runQueuedImport(qi)
+	}
+}
+
+func (tl *Timeline) runQueuedImport(qi *QueuedImport) {
+	defer qi.cancel()
+
+	Log.Info("starting queued import",
+		zap.String("job_id", qi.JobID),
+		zap.Int("priority", qi.Priority))
+
+	err := tl.Import(qi.ctx, qi.params)
+
+	q := &tl.importQueue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	qi.finish(err)
+	tl.dispatchQueuedImports()
+}
+
+// queuedImportHeap is a priority queue of imports: highest priority
+// first, then first enqueued.
+type queuedImportHeap []*QueuedImport
+
+func (h queuedImportHeap) Len() int { return len(h) }
+func (h queuedImportHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].order < h[j].order
+}
+func (h queuedImportHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *queuedImportHeap) Push(x any) {
+	qi := x.(*QueuedImport)
+	qi.index = len(*h)
+	*h = append(*h, qi)
+}
+func (h *queuedImportHeap) Pop() any {
+	old := *h
+	n := len(old)
+	qi := old[n-1]
+	old[n-1] = nil
+	qi.index = -1
+	*h = old[:n-1]
+	return qi
+}
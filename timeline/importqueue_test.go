@@ -0,0 +1,185 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func queueTestParams(name string) ImportParameters {
+	return ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{name},
+	}
+}
+
+func TestImportQueueRunsByPriority(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	testFileImport = func(ctx context.Context, filenames []string, _ chan<- *Graph, _ ListingOptions) error {
+		if filenames[0] == "blocker" {
+			<-release
+		}
+		mu.Lock()
+		order = append(order, filenames[0])
+		mu.Unlock()
+		return nil
+	}
+
+	blocker, err := tl.EnqueueImport(queueTestParams("blocker"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQueueState(t, blocker, QueueStateRunning)
+
+	var jobs []*QueuedImport
+	for _, job := range []struct {
+		name     string
+		priority int
+	}{
+		{"low1", 1},
+		{"high", 10},
+		{"low2", 1},
+		{"mid", 5},
+	} {
+		qi, err := tl.EnqueueImport(queueTestParams(job.name), job.priority)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state := qi.State(); state != QueueStateQueued {
+			t.Errorf("expected job %s to be %s, got %s", job.name, QueueStateQueued, state)
+		}
+		jobs = append(jobs, qi)
+	}
+
+	close(release)
+	for _, qi := range append(jobs, blocker) {
+		if err := qi.Wait(context.Background()); err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	expected := []string{"blocker", "high", "mid", "low1", "low2"}
+	if !slices.Equal(order, expected) {
+		t.Errorf("expected imports to run in order %v, got %v", expected, order)
+	}
+}
+
+func TestImportQueueRespectsConcurrencyLimit(t *testing.T) {
+	tl := newTestTimeline(t)
+	if err := tl.SetMaxConcurrentImports(2); err != nil {
+		t.Fatal(err)
+	}
+
+	var running, maxRunning atomic.Int32
+	testFileImport = func(ctx context.Context, _ []string, _ chan<- *Graph, _ ListingOptions) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			highest := maxRunning.Load()
+			if n <= highest || maxRunning.CompareAndSwap(highest, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+
+	var jobs []*QueuedImport
+	for i := 0; i < 5; i++ {
+		qi, err := tl.EnqueueImport(queueTestParams("test"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, qi)
+	}
+	for _, qi := range jobs {
+		if err := qi.Wait(context.Background()); err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		if state := qi.State(); state != QueueStateDone {
+			t.Errorf("expected job to be %s, got %s", QueueStateDone, state)
+		}
+	}
+
+	if got := maxRunning.Load(); got != 2 {
+		t.Errorf("expected at most 2 imports to run at once (and 2 to do so), got %d", got)
+	}
+}
+
+func TestImportQueueCancelQueuedJob(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	release := make(chan struct{})
+	var ran atomic.Int32
+	testFileImport = func(ctx context.Context, filenames []string, _ chan<- *Graph, _ ListingOptions) error {
+		ran.Add(1)
+		<-release
+		return nil
+	}
+
+	blocker, err := tl.EnqueueImport(queueTestParams("blocker"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQueueState(t, blocker, QueueStateRunning)
+
+	queued, err := tl.EnqueueImport(queueTestParams("queued"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tl.QueuedImport(queued.JobID); !ok {
+		t.Errorf("expected to find queued job %s", queued.JobID)
+	}
+	queued.Cancel()
+	if err := queued.Wait(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled job to end with %v, got %v", context.Canceled, err)
+	}
+	if _, ok := tl.QueuedImport(queued.JobID); ok {
+		t.Errorf("did not expect to find canceled job %s", queued.JobID)
+	}
+
+	close(release)
+	if err := blocker.Wait(context.Background()); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if n := ran.Load(); n != 1 {
+		t.Errorf("expected only 1 import to run, got %d", n)
+	}
+}
+
+func waitForQueueState(t *testing.T, qi *QueuedImport, state QueuedImportState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for qi.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for job %s to be %s (is %s)", qi.JobID, state, qi.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
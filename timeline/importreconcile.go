@@ -0,0 +1,111 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// trackRunningImport records that the import is being run by this process,
+// so it isn't mistaken for an interrupted import. The returned function
+// must be called when the import is done.
+func (tl *Timeline) trackRunningImport(importID int64) func() {
+	tl.importJobsMu.Lock()
+	defer tl.importJobsMu.Unlock()
+	if tl.runningImports == nil {
+		tl.runningImports = make(map[int64]struct{})
+	}
+	tl.runningImports[importID] = struct{}{}
+	return func() {
+		tl.importJobsMu.Lock()
+		delete(tl.runningImports, importID)
+		tl.importJobsMu.Unlock()
+	}
+}
+
+// ReconcileImports finds imports that are still marked as started but aren't
+// being run by this process; for example, because the program crashed or was
+// killed during the import. They are marked as partial if they have a checkpoint
+// they can be resumed from, and as aborted otherwise. The IDs of the reconciled
+// imports are returned. This is done automatically when the timeline is opened.
+func (tl *Timeline) ReconcileImports(ctx context.Context) ([]int64, error) {
+	if err := tl.checkWritable("reconcile imports"); err != nil {
+		return nil, err
+	}
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT id, checkpoint IS NOT NULL FROM imports WHERE status=?`, importStatusStarted)
+	if err != nil {
+		return nil, fmt.Errorf("querying started imports: %v", err)
+	}
+	type startedImport struct {
+		id        int64
+		resumable bool
+	}
+	var started []startedImport
+	for rows.Next() {
+		var imp startedImport
+		if err := rows.Scan(&imp.id, &imp.resumable); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning import: %v", err)
+		}
+		started = append(started, imp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating started imports: %v", err)
+	}
+
+	logger := Log.Named("imports")
+
+	var reconciled []int64
+	for _, imp := range started {
+		tl.importJobsMu.Lock()
+		_, running := tl.runningImports[imp.id]
+		tl.importJobsMu.Unlock()
+		if running {
+			continue
+		}
+
+		status := importStatusAborted
+		if imp.resumable {
+			status = importStatusPartial
+		}
+
+		// the time the import actually stopped is unknown, so the best we can
+		// do is say it ended by now (it at least no longer appears to be running)
+		_, err := tl.db.ExecContext(ctx, `UPDATE imports SET status=?, ended=unixepoch(), cancel_reason=? WHERE id=? AND status=?`,
+			status, CancelReasonInterrupted, imp.id, importStatusStarted)
+		if err != nil {
+			return reconciled, fmt.Errorf("updating status of interrupted import %d: %v", imp.id, err)
+		}
+
+		logger.Warn("import was interrupted",
+			zap.Int64("import_id", imp.id),
+			zap.String("status", status))
+		reconciled = append(reconciled, imp.id)
+	}
+
+	return reconciled, nil
+}
@@ -0,0 +1,111 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReconcileInterruptedImportsOnOpen(t *testing.T) {
+	repoDir, cacheDir := t.TempDir(), t.TempDir()
+	ctx := context.Background()
+
+	tl, err := Create(repoDir, cacheDir)
+	if err != nil {
+		t.Fatalf("creating timeline: %v", err)
+	}
+	t.Cleanup(func() { testFileImport = nil })
+
+	importTestItems(t, tl, testMessage("a", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	// simulate imports that were running when the process crashed,
+	// one of which has a checkpoint it can be resumed from
+	crashed, err := tl.newImport(ctx, testDataSourceName, importModeFile, ProcessingOptions{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashedWithCheckpoint, err := tl.newImport(ctx, testDataSourceName, importModeFile, ProcessingOptions{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.db.Exec(`UPDATE imports SET checkpoint=x'00' WHERE id=?`, crashedWithCheckpoint.id); err != nil {
+		t.Fatal(err)
+	}
+
+	// an import that is running in this process is left alone
+	running, err := tl.newImport(ctx, testDataSourceName, importModeFile, ProcessingOptions{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := tl.trackRunningImport(running.id)
+	reconciled, err := tl.ReconcileImports(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done()
+	if slices.Contains(reconciled, running.id) {
+		t.Errorf("running import %d was reconciled", running.id)
+	}
+	if len(reconciled) != 2 {
+		t.Errorf("expected 2 reconciled imports, got %v", reconciled)
+	}
+
+	// put the imports back as they were when the "crash" happened
+	if _, err := tl.db.Exec(`UPDATE imports SET status=?, ended=NULL WHERE id IN (?, ?)`,
+		importStatusStarted, crashed.id, crashedWithCheckpoint.id); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tl, err = Open(repoDir, cacheDir)
+	if err != nil {
+		t.Fatalf("reopening timeline: %v", err)
+	}
+	defer tl.Close()
+
+	imports, err := tl.ListImports(ctx, ListImportsParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[int64]string{
+		crashed.id:               importStatusAborted,
+		crashedWithCheckpoint.id: importStatusPartial,
+		running.id:               importStatusAborted, // not running anymore after reopening
+	}
+	for _, imp := range imports {
+		if imp.Status == importStatusStarted || imp.Ended == nil {
+			t.Errorf("import %d still appears to be running: status=%s ended=%v", imp.ID, imp.Status, imp.Ended)
+		}
+		if want, ok := expect[imp.ID]; ok && imp.Status != want {
+			t.Errorf("import %d: expected status %s, got %s", imp.ID, want, imp.Status)
+		} else if ok && imp.CancelReason != CancelReasonInterrupted {
+			t.Errorf("import %d: expected cancel reason %s, got %q", imp.ID, CancelReasonInterrupted, imp.CancelReason)
+		} else if !ok && imp.Status != importStatusSuccess {
+			t.Errorf("completed import %d: expected status %s, got %s", imp.ID, importStatusSuccess, imp.Status)
+		}
+	}
+	if len(imports) != 4 {
+		t.Errorf("expected 4 imports, got %d", len(imports))
+	}
+}
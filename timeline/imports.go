@@ -20,14 +20,20 @@ package timeline
 
 import (
 	"context"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/zeebo/blake3"
+	"go.uber.org/zap"
 )
 
 type ImportParameters struct {
@@ -36,13 +42,100 @@ type ImportParameters struct {
 	DataSourceName string `json:"data_source_name"`
 	// TODO: we might need a way to map filenames to the data source that will process them.
 	Filenames         []string          `json:"filenames,omitempty"`  // file imports
+	Archive           bool              `json:"archive,omitempty"`    // if true, the only filename is an archive to import without extracting it (see ImportArchive)
 	AccountID         int64             `json:"account_id,omitempty"` // API imports
 	ProcessingOptions ProcessingOptions `json:"processing_options,omitempty"`
 	DataSourceOptions json.RawMessage   `json:"data_source_options,omitempty"`
 
+	// When resuming a file import whose files have been moved, the new
+	// locations of the files, keyed by their original filenames. The files
+	// must have the same contents as when the import started.
+	AltFilenames map[string]string `json:"alt_filenames,omitempty"`
+
+	// For file imports, the URL the files were downloaded from, if any;
+	// it is recorded with the import as provenance.
+	SourceURL string `json:"source_url,omitempty"`
+
+	// For file imports, the passphrase to decrypt encrypted files (such as
+	// password-protected zip archives). It is not stored with the import, so
+	// it has to be given again when resuming.
+	Passphrase Passphrase `json:"passphrase,omitempty"`
+
 	JobID string `json:"job_id"` // assigned by application frontend
+
+	// If the data source's limit of concurrent imports for the account
+	// is reached, return AccountBusyError instead of waiting.
+	RejectIfAccountBusy bool `json:"reject_if_account_busy,omitempty"`
+
+	// If set, the import logs at this level ("debug", "info", "warn",
+	// "error") instead of the levels configured for the process logs,
+	// for example to troubleshoot one import without making every
+	// other import noisy. Like the passphrase, it is not stored with
+	// the import, so it has to be given again when resuming.
+	LogLevel string `json:"log_level,omitempty"`
+
+	// If true, the import's temporary directory (see ListingOptions.TempDir)
+	// is not deleted if the import fails, so its contents can be inspected.
+	KeepTempOnError bool `json:"keep_temp_on_error,omitempty"`
+
+	// If set, notifications are sent to this webhook when
+	// the import starts and when it finishes or fails.
+	Webhook *Webhook `json:"webhook,omitempty"`
+
+	// Arbitrary key/value labels to organize imports by, for
+	// example "env": "prod". They are stored with the import
+	// and can be used to filter ListImports.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// If set, this function will be called with status updates
+	// as the import progresses (after every batch is committed).
+	ProgressFunc ProgressFunc `json:"-"`
+
+	// If set, this function names the data files of imported items
+	// instead of the default naming scheme.
+	DataFileNamer DataFileNamer `json:"-"`
+
+	// Classifiers to categorize imported items, tried in order until one
+	// returns a category; items that none of them categorize are given to
+	// DefaultClassifier.
+	Classifiers []Classifier `json:"-"`
+
+	// Post-processors to make derived forms of data files after they are
+	// written, such as converted images; derived files are only made if
+	// post-processors are given, and failing to make one does not fail the
+	// item.
+	DataFilePostProcessors []DataFilePostProcessor `json:"-"`
+
+	// If set, this function is called after the parameters are validated
+	// but before anything is imported; if it returns an error, the import
+	// is aborted with that error without storing anything.
+	PreImportCheck PreImportCheck `json:"-"`
+}
+
+// PreImportCheck inspects an import before it begins, for example to reject
+// an export from the wrong account or of an unexpected version. Returning an
+// error rejects the import.
+type PreImportCheck func(ctx context.Context, ic ImportContext) error
+
+// ImportContext describes an import that is about to begin.
+type ImportContext struct {
+	DataSource DataSource
+	Filenames  []string // for file imports
+	Account    *Account // nil if the import is not associated with an account
+
+	// nonzero if the import is being resumed
+	ResumeImportID int64
 }
 
+// DataFileNamer returns the name of the data file for an item, for example to
+// make the files more meaningful when browsing the repo directly. The default
+// name (usually the item's original filename) is given as defaultName, which it
+// may return. The result is sanitized for use as a single path component, and if
+// the name is taken, a suffix is added to make it unique. If it returns an empty
+// name (or one that is empty after sanitization), the default name is used.
+// Data files with identical contents are still deduplicated, regardless of name.
+type DataFileNamer func(it *Item, defaultName string) string
+
 func (params ImportParameters) Hash(repoID string) string {
 	accountIDOrFilename := "files:" + strings.Join(params.Filenames, ",")
 	if params.AccountID > 0 {
@@ -63,6 +156,7 @@ type importRow struct {
 	started           time.Time
 	ended             *time.Time
 	status            importStatus
+	cancelReason      CancelReason // why the import stopped early, if it did
 	checkpointBytes   []byte
 
 	checkpoint *checkpoint // the decoded checkpointBytes
@@ -70,25 +164,31 @@ type importRow struct {
 
 func (t *Timeline) loadImport(ctx context.Context, importID int64) (importRow, error) {
 	var imp importRow
-	var snapshotTs *int64
+	var snapshotTs, endedTs *int64
+	var startedTs int64
 	t.dbMu.RLock()
 	err := t.db.QueryRowContext(ctx,
 		`SELECT
 			imports.id, imports.mode, imports.snapshot_date, imports.account_id,
 			imports.started, imports.ended, imports.status, imports.checkpoint,
-			data_source.name
+			data_sources.name
 		FROM imports, data_sources
 		WHERE imports.id=?
-			AND data_sources.id = accounts.data_source_id
+			AND data_sources.id = imports.data_source_id
 		LIMIT 1`,
-		// TODO: I'm pretty sure these won't all scan correctly, currently
-		importID).Scan(&imp.id, &imp.mode, &snapshotTs, &imp.accountID, &imp.started, &imp.ended,
+		importID).Scan(&imp.id, &imp.mode, &snapshotTs, &imp.accountID, &startedTs, &endedTs,
 		&imp.status, &imp.checkpointBytes, &imp.dataSourceName)
 	t.dbMu.RUnlock()
 	if err != nil {
 		return imp, fmt.Errorf("querying import %d from DB: %v", importID, err)
 	}
+	imp.started = time.Unix(startedTs, 0)
+	if endedTs != nil {
+		ended := time.Unix(*endedTs, 0)
+		imp.ended = &ended
+	}
 	if len(imp.checkpointBytes) > 0 {
+		imp.checkpoint = new(checkpoint)
 		err = unmarshalGob(imp.checkpointBytes, imp.checkpoint)
 		if err != nil {
 			return imp, fmt.Errorf("decoding checkpoint: %v", err)
@@ -110,7 +210,7 @@ func (t *Timeline) newImport(ctx context.Context, dataSourceID string, mode impo
 		err := t.db.QueryRowContext(ctx,
 			`SELECT data_sources.name
 		FROM data_sources, accounts
-		WHERE accounts.id = ? AND data_source.id = accounts.data_source_id
+		WHERE accounts.id = ? AND data_sources.id = accounts.data_source_id
 		LIMIT 1`,
 			accountID).Scan(&accountDataSourceID)
 		t.dbMu.RUnlock()
@@ -123,14 +223,9 @@ func (t *Timeline) newImport(ctx context.Context, dataSourceID string, mode impo
 		}
 	}
 
-	// TODO: Maybe this (getting a data source's row ID) should be a separate function
-	var dataSourceRowID int64
-	t.dbMu.RLock()
-	err := t.db.QueryRowContext(ctx, `SELECT id FROM data_sources WHERE name=? LIMIT 1`,
-		dataSourceID).Scan(&dataSourceRowID)
-	t.dbMu.RUnlock()
+	dataSourceRowID, err := t.dataSourceRowID(ctx, dataSourceID)
 	if err != nil {
-		return importRow{}, fmt.Errorf("querying data source's row ID: %s: %v", dataSourceID, err)
+		return importRow{}, err
 	}
 
 	imp := importRow{
@@ -164,11 +259,36 @@ func (t *Timeline) newImport(ctx context.Context, dataSourceID string, mode impo
 	return imp, nil
 }
 
+// importMetadata is additional information about an import,
+// stored as JSON in the metadata column of its row.
+type importMetadata struct {
+	SourceURL string `json:"source_url,omitempty"`
+
+	// the data source options with secrets redacted,
+	// if the data source is able to redact them
+	DataSourceOptions json.RawMessage `json:"data_source_options,omitempty"`
+}
+
+func (t *Timeline) setImportMetadata(ctx context.Context, importID int64, meta importMetadata) error {
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encoding import metadata: %v", err)
+	}
+	t.dbMu.Lock()
+	_, err = t.db.ExecContext(ctx, `UPDATE imports SET metadata=? WHERE id=?`, string(metaJSON), importID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+	t.dbMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("saving import metadata: %v", err)
+	}
+	return nil
+}
+
 type importMode string
 
 const (
-	importModeFile importMode = "file"
-	importModeAPI  importMode = "api"
+	importModeFile     importMode = "file"
+	importModeAPI      importMode = "api"
+	importModeArchived importMode = "archived" // placeholder for imports removed by compaction
 )
 
 type importStatus string
@@ -178,4 +298,369 @@ const (
 	importStatusAborted = "abort"
 	importStatusSuccess = "ok" // TODO: "success", to be clearer, maybe?
 	importStatusError   = "err"
+	importStatusPartial = "partial" // stopped early, but can be resumed
 )
+
+// hashImportFiles computes the content hash of each regular file in filenames.
+// Directories and other non-regular files are not hashed and are omitted
+// from the resulting map, which is keyed by filename.
+func hashImportFiles(ctx context.Context, filenames []string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, filename := range filenames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(filename)
+		if errors.Is(err, fs.ErrNotExist) {
+			// not every data source takes paths on disk as input
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		h := newHash()
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("hashing %s: %w", filename, err)
+		}
+		hashes[filename] = hex.EncodeToString(h.Sum(nil))
+	}
+	return hashes, nil
+}
+
+// previouslyImportedFileHashes returns the set of file hashes that were recorded
+// by prior successful imports of the given data source (and account, if set).
+func (t *Timeline) previouslyImportedFileHashes(ctx context.Context, dataSourceName string, accountID int64) (map[string]struct{}, error) {
+	q := `SELECT imports.file_hashes
+		FROM imports, data_sources
+		WHERE imports.status=?
+			AND imports.file_hashes IS NOT NULL
+			AND data_sources.id = imports.data_source_id
+			AND data_sources.name = ?`
+	args := []any{importStatusSuccess, dataSourceName}
+	if accountID > 0 {
+		q += " AND imports.account_id=?"
+		args = append(args, accountID)
+	}
+
+	t.dbMu.RLock()
+	defer t.dbMu.RUnlock()
+
+	rows, err := t.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying previous imports: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]struct{})
+	for rows.Next() {
+		var hashesJSON string
+		if err := rows.Scan(&hashesJSON); err != nil {
+			return nil, fmt.Errorf("scanning file hashes: %w", err)
+		}
+		var fileHashes map[string]string
+		if err := json.Unmarshal([]byte(hashesJSON), &fileHashes); err != nil {
+			return nil, fmt.Errorf("decoding file hashes: %w", err)
+		}
+		for _, h := range fileHashes {
+			hashes[h] = struct{}{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating previous imports: %w", err)
+	}
+
+	return hashes, nil
+}
+
+// skipPreviouslyImportedFiles removes files from the import which are identical to
+// files from a previous successful import of the same data source and account,
+// unless the Force processing option is enabled. The hashes of the remaining files
+// are recorded on the import row.
+func (p *processor) skipPreviouslyImportedFiles(ctx context.Context) error {
+	hashes, err := hashImportFiles(ctx, p.params.Filenames)
+	if err != nil {
+		return fmt.Errorf("hashing files to import: %w", err)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	if !p.params.ProcessingOptions.Force {
+		prior, err := p.tl.previouslyImportedFileHashes(ctx, p.ds.Name, p.params.AccountID)
+		if err != nil {
+			return err
+		}
+		var remaining []string
+		for _, filename := range p.params.Filenames {
+			if h, ok := hashes[filename]; ok {
+				if _, seen := prior[h]; seen {
+					p.log.Info("skipping file that was already imported",
+						zap.String("filename", filename),
+						zap.String("hash", h))
+					p.countSkip(SkipReasonFileAlreadyImported)
+					delete(hashes, filename)
+					continue
+				}
+			}
+			remaining = append(remaining, filename)
+		}
+		p.params.Filenames = remaining
+	}
+
+	hashesJSON, err := json.Marshal(hashes)
+	if err != nil {
+		return fmt.Errorf("encoding file hashes: %w", err)
+	}
+	p.tl.dbMu.Lock()
+	_, err = p.tl.db.ExecContext(ctx, `UPDATE imports SET file_hashes=? WHERE id=?`, // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		string(hashesJSON), p.impRow.id)
+	p.tl.dbMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("recording file hashes: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteImport deletes the import with the given ID along with all the items that it added.
+// Items that were also given by other imports (for example, the same photo in several
+// archives) are still wanted, so they are kept and attributed to the earliest of those
+// imports instead. Favorites are deleted like any other item, so a warning is logged if
+// there are any; use CountImportFavorites to warn the user beforehand.
+func (t *Timeline) DeleteImport(ctx context.Context, importID int64) error {
+	if err := t.checkWritable("delete import"); err != nil {
+		return err
+	}
+
+	// re-attributing the shared items and deleting the rest happen in one
+	// transaction, so no import can be left as the source of items it didn't
+	// give, nor can another import commit in between
+	t.dbMu.Lock()
+	defer t.dbMu.Unlock()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE items
+		SET import_id=(
+			SELECT min(import_items.import_id) FROM import_items
+			WHERE import_items.item_id = items.id AND import_items.import_id != ?)
+		WHERE import_id=?
+			AND EXISTS (SELECT 1 FROM import_items WHERE import_items.item_id = items.id AND import_items.import_id != ?)`,
+		importID, importID, importID)
+	if err != nil {
+		return fmt.Errorf("keeping items of import %d that were also seen in other imports: %v", importID, err)
+	}
+
+	favorites, err := countImportFavorites(ctx, tx, importID)
+	if err != nil {
+		return err
+	}
+	if favorites > 0 {
+		Log.Warn("deleting favorite items along with import",
+			zap.Int64("import_id", importID),
+			zap.Int("favorites", favorites))
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM items WHERE import_id=?`, importID)
+	if err != nil {
+		return fmt.Errorf("querying items of import %d: %v", importID, err)
+	}
+	var rowIDs []int64
+	for rows.Next() {
+		var rowID int64
+		if err := rows.Scan(&rowID); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning item row ID: %v", err)
+		}
+		rowIDs = append(rowIDs, rowID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating items of import %d: %v", importID, err)
+	}
+
+	if len(rowIDs) > 0 {
+		Log.Info("deleting item rows", zap.Int64s("item_ids", rowIDs))
+	}
+	dataFilesToDelete, err := deleteItemRowsTx(ctx, tx, rowIDs)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM imports WHERE id=?`, importID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+	if err != nil {
+		return fmt.Errorf("deleting import %d: %v", importID, err)
+	}
+
+	// like when deleting items, data files are deleted only after the rows are
+	// gone for good, since stray files can be swept up later but missing ones can't
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing deletion of import %d: %v", importID, err)
+	}
+	if _, err := t.deleteDataFiles(ctx, Log, dataFilesToDelete); err != nil {
+		return fmt.Errorf("deleting data files (after deleting import %d from DB): %v", importID, err)
+	}
+
+	return nil
+}
+
+// ItemImports returns the IDs of the imports that gave the item with the given row
+// ID, in order, including imports in which it was a duplicate of the existing item
+// and thus wasn't stored again. Imports done before the timeline started recording
+// the items of each import are not included.
+func (t *Timeline) ItemImports(ctx context.Context, itemRowID int64) ([]int64, error) {
+	t.dbMu.RLock()
+	defer t.dbMu.RUnlock()
+
+	rows, err := t.db.QueryContext(ctx, `SELECT import_id FROM import_items WHERE item_id=? ORDER BY import_id`, itemRowID)
+	if err != nil {
+		return nil, fmt.Errorf("querying imports of item %d: %v", itemRowID, err)
+	}
+	defer rows.Close()
+
+	var importIDs []int64
+	for rows.Next() {
+		var importID int64
+		if err := rows.Scan(&importID); err != nil {
+			return nil, fmt.Errorf("scanning import ID: %v", err)
+		}
+		importIDs = append(importIDs, importID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating imports of item %d: %v", itemRowID, err)
+	}
+
+	return importIDs, nil
+}
+
+// CompactImportHistory removes import rows that started before olderThan and
+// no longer matter: imports that are empty, and imports whose items have all
+// been superseded (modified by a later import). Imports that last modified
+// any item are kept, since they are responsible for its current state.
+// Item data is never touched;
+// items and entities that still refer to a removed import are re-pointed to a
+// single synthetic "archived" import, so nothing is orphaned. Imports that are
+// still running or that have notes attached are kept. It returns the number
+// of import rows removed.
+func (t *Timeline) CompactImportHistory(ctx context.Context, olderThan time.Time) (int, error) {
+	if err := t.checkWritable("compact import history"); err != nil {
+		return 0, err
+	}
+
+	t.dbMu.Lock()
+	defer t.dbMu.Unlock()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// find old imports whose items (if any) have all been superseded by later
+	// imports, and which are not responsible for the current state of any item
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id FROM imports
+		WHERE started < ?
+			AND status != ?
+			AND mode != ?
+			AND NOT EXISTS (SELECT 1 FROM notes WHERE notes.import_id = imports.id)
+			AND NOT EXISTS (SELECT 1 FROM items WHERE items.modified_import_id = imports.id)
+			AND NOT EXISTS (
+				SELECT 1 FROM items
+				WHERE items.import_id = imports.id AND items.modified_import_id IS NULL
+			)`,
+		olderThan.Unix(), importStatusStarted, importModeArchived)
+	if err != nil {
+		return 0, fmt.Errorf("querying imports to compact: %v", err)
+	}
+	var importIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning import ID: %v", err)
+		}
+		importIDs = append(importIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterating imports to compact: %v", err)
+	}
+	if len(importIDs) == 0 {
+		return 0, nil
+	}
+
+	var archivedImportID int64
+	for _, importID := range importIDs {
+		var refs int
+		err := tx.QueryRowContext(ctx,
+			`SELECT (SELECT count() FROM items WHERE import_id=?) + (SELECT count() FROM entities WHERE import_id=?)`,
+			importID, importID).Scan(&refs)
+		if err != nil {
+			return 0, fmt.Errorf("counting references to import %d: %v", importID, err)
+		}
+
+		// re-point anything that still refers to this import so it isn't orphaned
+		if refs > 0 {
+			if archivedImportID == 0 {
+				archivedImportID, err = archivedImport(ctx, tx)
+				if err != nil {
+					return 0, err
+				}
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE items SET import_id=? WHERE import_id=?`, archivedImportID, importID); err != nil {
+				return 0, fmt.Errorf("re-pointing items of import %d: %v", importID, err)
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE entities SET import_id=? WHERE import_id=?`, archivedImportID, importID); err != nil {
+				return 0, fmt.Errorf("re-pointing entities of import %d: %v", importID, err)
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM imports WHERE id=?`, importID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		if err != nil {
+			return 0, fmt.Errorf("deleting import %d: %v", importID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %v", err)
+	}
+
+	Log.Info("compacted import history",
+		zap.Time("older_than", olderThan),
+		zap.Int("removed", len(importIDs)))
+
+	return len(importIDs), nil
+}
+
+// archivedImport returns the ID of the synthetic import that stands in for
+// imports removed by compaction, creating it if it doesn't exist yet.
+func archivedImport(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM imports WHERE mode=? LIMIT 1`, importModeArchived).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("querying archived import: %v", err)
+	}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO imports (mode, status, started, ended) VALUES (?, ?, 0, 0) RETURNING id`,
+		importModeArchived, importStatusSuccess).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("creating archived import: %v", err)
+	}
+	return id, nil
+}
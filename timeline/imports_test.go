@@ -0,0 +1,405 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// importLinesAsMessages is a test FileImport func that emits one
+// message item per line of each file, using the line as its ID.
+// The file and line number are reported as the item's provenance.
+func importLinesAsMessages(ctx context.Context, filenames []string, itemChan chan<- *Graph, _ ListingOptions) error {
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, filename := range filenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		var line int64
+		for scanner.Scan() {
+			line++
+			ts = ts.Add(time.Minute)
+			itemChan <- &Graph{
+				Item:         testMessage(scanner.Text(), ts),
+				SourceFile:   filename,
+				SourceOffset: line,
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestImportSkipsUnchangedFiles(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	var filesSeen []string
+	testFileImport = func(ctx context.Context, filenames []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		filesSeen = append(filesSeen, filenames...)
+		return importLinesAsMessages(ctx, filenames, itemChan, opt)
+	}
+
+	filename := filepath.Join(t.TempDir(), "messages.txt")
+	writeFile := func(contents string) {
+		if err := os.WriteFile(filename, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doImport := func(force bool) {
+		filesSeen = nil
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{filename},
+			ProcessingOptions: ProcessingOptions{Force: force},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	writeFile("a\nb\n")
+	doImport(false)
+	if len(filesSeen) != 1 {
+		t.Fatalf("expected first import to process the file, got %v", filesSeen)
+	}
+
+	doImport(false)
+	if len(filesSeen) != 0 {
+		t.Errorf("expected unchanged file to be skipped, but it was imported: %v", filesSeen)
+	}
+
+	doImport(true)
+	if len(filesSeen) != 1 {
+		t.Errorf("expected unchanged file to be imported when forced, got %v", filesSeen)
+	}
+
+	writeFile("a\nb\nc\n")
+	doImport(false)
+	if len(filesSeen) != 1 {
+		t.Errorf("expected modified file to be imported, got %v", filesSeen)
+	}
+}
+
+func TestImportRecordsItemProvenance(t *testing.T) {
+	tl := newTestTimeline(t)
+	testFileImport = importLinesAsMessages
+
+	dir := t.TempDir()
+	file1, file2 := filepath.Join(dir, "one.txt"), filepath.Join(dir, "two.txt")
+	if err := os.WriteFile(file1, []byte("a\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file2, []byte("c\nd\ne\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{file1, file2},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	type provenance struct {
+		file string
+		line int64
+	}
+	expected := map[string]provenance{
+		"a": {file1, 1},
+		"b": {file1, 2},
+		"c": {file2, 1},
+		"d": {file2, 2},
+		"e": {file2, 3},
+	}
+
+	results, err := tl.Search(context.Background(), ItemSearchParams{})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results.Items) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(results.Items))
+	}
+	for _, result := range results.Items {
+		want := expected[*result.OriginalID]
+		if result.SourceFile == nil || *result.SourceFile != want.file {
+			t.Errorf("item %s: expected source file %s, got %v", *result.OriginalID, want.file, result.SourceFile)
+		}
+		if result.SourceOffset == nil || *result.SourceOffset != want.line {
+			t.Errorf("item %s: expected source offset %d, got %v", *result.OriginalID, want.line, result.SourceOffset)
+		}
+	}
+}
+
+func TestCompactImportHistory(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	// import returns the ID of a new import of the given items
+	importItems := func(items ...*Item) int64 {
+		importTestItems(t, tl, items...)
+		var id int64
+		if err := tl.db.QueryRow(`SELECT max(id) FROM imports`).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	exec := func(q string, args ...any) {
+		if _, err := tl.db.Exec(q, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	oldEmpty := importItems()
+	oldWithItems := importItems(testMessage("kept", ts))
+	oldSuperseded := importItems(testMessage("superseded", ts))
+	recentEmpty := importItems()
+	superseding := importItems()
+
+	old := time.Now().Add(-365 * 24 * time.Hour).Unix()
+	for _, id := range []int64{oldEmpty, oldWithItems, oldSuperseded} {
+		exec(`UPDATE imports SET started=? WHERE id=?`, old, id)
+	}
+	exec(`UPDATE items SET modified_import_id=? WHERE import_id=?`, superseding, oldSuperseded)
+
+	removed, err := tl.CompactImportHistory(ctx, time.Now().Add(-30*24*time.Hour))
+	if err != nil {
+		t.Fatalf("compacting import history: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 imports to be removed, got %d", removed)
+	}
+
+	importExists := func(id int64) bool {
+		var count int
+		if err := tl.db.QueryRow(`SELECT count() FROM imports WHERE id=?`, id).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count > 0
+	}
+	for id, shouldExist := range map[int64]bool{
+		oldEmpty:      false,
+		oldWithItems:  true,
+		oldSuperseded: false,
+		recentEmpty:   true,
+		superseding:   true,
+	} {
+		if importExists(id) != shouldExist {
+			t.Errorf("import %d: expected exists=%t", id, shouldExist)
+		}
+	}
+
+	// the superseded item must still exist and point to a valid import
+	var mode string
+	err = tl.db.QueryRow(`SELECT imports.mode FROM items JOIN imports ON imports.id = items.import_id
+		WHERE items.original_id='superseded'`).Scan(&mode)
+	if err != nil {
+		t.Fatalf("loading import of superseded item: %v", err)
+	}
+	if mode != string(importModeArchived) {
+		t.Errorf("expected superseded item to be re-pointed to archived import, got mode %q", mode)
+	}
+
+	// with a later cutoff, the recent empty import goes too; but the superseding
+	// import is still responsible for an item, and the archived import stays
+	removed, err = tl.CompactImportHistory(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("compacting import history: %v", err)
+	}
+	if removed != 1 || importExists(recentEmpty) || !importExists(superseding) {
+		t.Errorf("expected only the recent empty import to be removed, got %d removed", removed)
+	}
+	var orphans int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE import_id NOT IN (SELECT id FROM imports)`).Scan(&orphans); err != nil {
+		t.Fatal(err)
+	}
+	if orphans > 0 {
+		t.Errorf("found %d orphaned items", orphans)
+	}
+}
+
+func TestDedupedItemTracksAllImports(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// the same photo, without an original ID, appears in three archives; since its
+	// identity comes from its data file, it is only found to be a duplicate after
+	// the file is downloaded
+	importPhoto := func(items ...*Item) int64 {
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, it := range items {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			ProcessingOptions: ProcessingOptions{
+				ItemUniqueConstraints: map[string]bool{"data": false, "timestamp": false},
+			},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		var id int64
+		if err := tl.db.QueryRow(`SELECT max(id) FROM imports`).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	photo := func() *Item {
+		it := testFileItem("photo", ts)
+		it.ID = ""
+		return it
+	}
+	other := testFileItem("other", ts)
+	other.ID = ""
+	other.Timestamp = ts.Add(time.Hour)
+
+	first := importPhoto(photo(), other)
+	second := importPhoto(photo())
+	third := importPhoto(photo())
+
+	var rowID int64
+	var count int
+	err := tl.db.QueryRow(`SELECT min(id), count() FROM items WHERE filename='photo.bin'`).Scan(&rowID, &count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 stored photo, got %d", count)
+	}
+
+	importIDs, err := tl.ItemImports(ctx, rowID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{first, second, third}; !slices.Equal(importIDs, want) {
+		t.Errorf("expected photo to be seen in imports %v, got %v", want, importIDs)
+	}
+
+	// if deleting the import fails, nothing about its items has changed
+	if _, err := tl.db.Exec(`CREATE TRIGGER fail_import_deletion BEFORE DELETE ON imports
+		BEGIN SELECT RAISE(ABORT, 'import deletion failed'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.DeleteImport(ctx, first); err == nil {
+		t.Fatal("expected deleting import to fail")
+	}
+	var importID int64
+	if err := tl.db.QueryRow(`SELECT import_id FROM items WHERE id=?`, rowID).Scan(&importID); err != nil {
+		t.Fatal(err)
+	}
+	if importID != first {
+		t.Errorf("expected photo to still be attributed to import %d after failed deletion, got %d", first, importID)
+	}
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected both items to remain after failed deletion, got %d", count)
+	}
+	if _, err := tl.db.Exec(`DROP TRIGGER fail_import_deletion`); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleting the import that stored the photo keeps it, since later imports
+	// still want it, but the item that was only in that import goes away
+	if err := tl.DeleteImport(ctx, first); err != nil {
+		t.Fatalf("deleting import: %v", err)
+	}
+	if err := tl.db.QueryRow(`SELECT import_id FROM items WHERE id=?`, rowID).Scan(&importID); err != nil {
+		t.Fatalf("loading photo after deleting its import: %v", err)
+	}
+	if importID != second {
+		t.Errorf("expected photo to be attributed to import %d, got %d", second, importID)
+	}
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected only the photo to remain, got %d items", count)
+	}
+}
+
+func TestPreImportCheckRejectsImport(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("a", time.Now())}
+		return nil
+	}
+
+	errWrongExport := errors.New("not an export from the expected account")
+	var checked ImportContext
+	check := func(_ context.Context, ic ImportContext) error {
+		checked = ic
+		if strings.Contains(filepath.Base(ic.Filenames[0]), "other-account") {
+			return errWrongExport
+		}
+		return nil
+	}
+
+	err := tl.Import(ctx, ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"export-other-account.zip"},
+		PreImportCheck: check,
+	})
+	if !errors.Is(err, errWrongExport) {
+		t.Fatalf("expected import to be rejected by check, got: %v", err)
+	}
+	if checked.DataSource.Name != testDataSourceName || checked.Account != nil {
+		t.Errorf("unexpected import context: %+v", checked)
+	}
+	for _, table := range []string{"imports", "items"} {
+		var count int
+		if err := tl.db.QueryRow(`SELECT count() FROM ` + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count > 0 {
+			t.Errorf("expected no rows in %s after rejected import, got %d", table, count)
+		}
+	}
+
+	// an acceptable file gets imported as usual
+	err = tl.Import(ctx, ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"export-my-account.zip"},
+		PreImportCheck: check,
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+// makeTempDir creates a scratch directory for the import within the repo's
+// temporary folder, so that files written there can be moved into the repo
+// cheaply. The returned function removes the directory, unless the import
+// failed and the parameters say to keep it for troubleshooting.
+func (p *processor) makeTempDir() (string, func(failed bool), error) {
+	base := filepath.Join(p.tl.repoDir, TempFolderName)
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return "", nil, fmt.Errorf("creating temporary folder: %v", err)
+	}
+	dir, err := os.MkdirTemp(base, fmt.Sprintf("import_%d_", p.impRow.id))
+	if err != nil {
+		return "", nil, fmt.Errorf("creating temporary directory for import: %v", err)
+	}
+
+	remove := func(failed bool) {
+		if failed && p.params.KeepTempOnError {
+			p.log.Warn("keeping temporary directory of failed import",
+				zap.Int64("import_id", p.impRow.id),
+				zap.String("dir", dir))
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			p.log.Error("removing temporary directory of import",
+				zap.Int64("import_id", p.impRow.id),
+				zap.String("dir", dir),
+				zap.Error(err))
+		}
+	}
+
+	return dir, remove, nil
+}
@@ -0,0 +1,101 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImportTempDir(t *testing.T) {
+	ts := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name        string
+		cancel      bool
+		fail        bool
+		keepOnError bool
+		expectKept  bool
+	}{
+		{name: "normal"},
+		{name: "canceled", cancel: true},
+		{name: "canceled, kept for debugging", cancel: true, keepOnError: true, expectKept: true},
+		{name: "succeeded with keep on error", keepOnError: true},
+		{name: "failed", fail: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := newTestTimeline(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var tempDir string
+			testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+				tempDir = opt.TempDir
+				if tempDir == "" {
+					return errors.New("no temp dir")
+				}
+				if !filepath.IsAbs(tempDir) || filepath.Dir(tempDir) != filepath.Join(tl.repoDir, TempFolderName) {
+					return errors.New("temp dir is not in the repo's temporary folder: " + tempDir)
+				}
+				if err := os.WriteFile(filepath.Join(tempDir, "scratch"), []byte("partial"), 0600); err != nil {
+					return err
+				}
+				itemChan <- &Graph{Item: testMessage("one", ts)}
+				if tc.cancel {
+					cancel()
+					return ctx.Err()
+				}
+				if tc.fail {
+					return errors.New("data source failed")
+				}
+				return nil
+			}
+
+			err := tl.Import(ctx, ImportParameters{
+				DataSourceName:  testDataSourceName,
+				Filenames:       []string{"test"},
+				KeepTempOnError: tc.keepOnError,
+			})
+			if tc.cancel || tc.fail {
+				if err == nil {
+					t.Fatal("expected import to fail")
+				}
+			} else if err != nil {
+				t.Fatalf("import failed: %v", err)
+			}
+			if tempDir == "" {
+				t.Fatal("data source was not given a temp dir")
+			}
+
+			_, err = os.Stat(tempDir)
+			if tc.expectKept {
+				if err != nil {
+					t.Errorf("expected temp dir to be kept, but: %v", err)
+				}
+			} else if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected temp dir to be removed, but: %v", err)
+			}
+		})
+	}
+}
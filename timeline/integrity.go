@@ -0,0 +1,246 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// IntegrityCheckOptions configures an integrity check.
+type IntegrityCheckOptions struct {
+	// If set, the integrity check with this ID will be resumed
+	// from the last item it checked, instead of starting over.
+	ResumeCheckID int64 `json:"resume_check_id,omitempty"`
+
+	// If set, this function will be called after each data
+	// file is checked.
+	ProgressFunc func(IntegrityCheckStatus) `json:"-"`
+}
+
+// IntegrityCheckStatus describes the progress of a running integrity check.
+type IntegrityCheckStatus struct {
+	CheckID    int64 `json:"check_id"`
+	Checked    int64 `json:"checked"`
+	Problems   int   `json:"problems"`
+	LastItemID int64 `json:"last_item_id"`
+}
+
+// IntegrityCheckResult is the outcome of an integrity check. If the check was
+// canceled, the results are partial and Complete is false; the check can be
+// resumed later by passing its CheckID as the ResumeCheckID option.
+type IntegrityCheckResult struct {
+	CheckID  int64              `json:"check_id"`
+	Checked  int64              `json:"checked"`
+	Problems []IntegrityProblem `json:"problems,omitempty"`
+	Complete bool               `json:"complete"`
+}
+
+// IntegrityProblem describes an item whose data file failed the integrity check.
+type IntegrityProblem struct {
+	ItemID   int64  `json:"item_id"`
+	DataFile string `json:"data_file"`
+	Problem  string `json:"problem"`
+}
+
+// integrityCheckPageSize is how many items to load from the DB at a time during an integrity check.
+const integrityCheckPageSize = 100
+
+// CheckIntegrity verifies that the data file of every item exists and matches
+// its recorded checksum. Its progress is recorded in the database so that it
+// can be resumed if it is canceled or interrupted.
+func (tl *Timeline) CheckIntegrity(ctx context.Context, opts IntegrityCheckOptions) (IntegrityCheckResult, error) {
+	if err := tl.checkWritable("check integrity"); err != nil {
+		return IntegrityCheckResult{}, err
+	}
+
+	var result IntegrityCheckResult
+	var lastItemID int64
+
+	if opts.ResumeCheckID == 0 {
+		tl.dbMu.Lock()
+		err := tl.db.QueryRowContext(ctx, `INSERT INTO integrity_checks DEFAULT VALUES RETURNING id`).Scan(&result.CheckID)
+		tl.dbMu.Unlock()
+		if err != nil {
+			return result, fmt.Errorf("inserting integrity check row: %v", err)
+		}
+	} else {
+		var status string
+		var lastID *int64
+		var problemsJSON *string
+		tl.dbMu.RLock()
+		err := tl.db.QueryRowContext(ctx,
+			`SELECT status, last_item_id, checked_count, problems FROM integrity_checks WHERE id=? LIMIT 1`,
+			opts.ResumeCheckID).Scan(&status, &lastID, &result.Checked, &problemsJSON)
+		tl.dbMu.RUnlock()
+		if err != nil {
+			return result, fmt.Errorf("loading integrity check %d: %v", opts.ResumeCheckID, err)
+		}
+		if status == importStatusSuccess {
+			return result, fmt.Errorf("integrity check %d already completed", opts.ResumeCheckID)
+		}
+		if problemsJSON != nil {
+			if err := json.Unmarshal([]byte(*problemsJSON), &result.Problems); err != nil {
+				return result, fmt.Errorf("decoding problems of integrity check %d: %v", opts.ResumeCheckID, err)
+			}
+		}
+		if lastID != nil {
+			lastItemID = *lastID
+		}
+		result.CheckID = opts.ResumeCheckID
+	}
+
+	logger := Log.Named("integrity").With(zap.Int64("check_id", result.CheckID))
+	logger.Info("checking integrity of data files", zap.Int64("resuming_after_item_id", lastItemID))
+
+	// save progress when we return, so the check can be resumed if it didn't finish
+	status := importStatusStarted
+	defer func() {
+		if err := tl.saveIntegrityCheck(result, lastItemID, status); err != nil {
+			logger.Error("saving integrity check progress", zap.Error(err))
+		}
+	}()
+
+	for {
+		page, err := tl.integrityCheckPage(ctx, lastItemID)
+		if err != nil {
+			status = importStatusError
+			return result, err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, it := range page {
+			if err := ctx.Err(); err != nil {
+				status = importStatusAborted
+				logger.Info("integrity check canceled",
+					zap.Int64("checked", result.Checked),
+					zap.Int64("last_item_id", lastItemID))
+				return result, err
+			}
+
+			if err := tl.verifyDataFile(it.dataFile, it.compression, it.dataHash); err != nil {
+				logger.Warn("data file failed integrity check",
+					zap.Int64("item_id", it.rowID),
+					zap.String("data_file", it.dataFile),
+					zap.Error(err))
+				result.Problems = append(result.Problems, IntegrityProblem{
+					ItemID:   it.rowID,
+					DataFile: it.dataFile,
+					Problem:  err.Error(),
+				})
+			}
+			result.Checked++
+			lastItemID = it.rowID
+
+			if opts.ProgressFunc != nil {
+				opts.ProgressFunc(IntegrityCheckStatus{
+					CheckID:    result.CheckID,
+					Checked:    result.Checked,
+					Problems:   len(result.Problems),
+					LastItemID: lastItemID,
+				})
+			}
+		}
+
+		// save a checkpoint after every page in case we're interrupted
+		if err := tl.saveIntegrityCheck(result, lastItemID, status); err != nil {
+			logger.Error("saving integrity check progress", zap.Error(err))
+		}
+	}
+
+	status = importStatusSuccess
+	result.Complete = true
+
+	logger.Info("integrity check complete",
+		zap.Int64("checked", result.Checked),
+		zap.Int("problems", len(result.Problems)))
+
+	return result, nil
+}
+
+type integrityCheckItem struct {
+	rowID       int64
+	dataFile    string
+	dataHash    []byte
+	compression DataFileCompression
+}
+
+// integrityCheckPage returns the next page of items with data files after the given item ID.
+func (tl *Timeline) integrityCheckPage(ctx context.Context, afterItemID int64) ([]integrityCheckItem, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx,
+		`SELECT items.id, items.data_file, items.data_hash, coalesce(compressed_data_files.compression, '')
+		FROM items
+		LEFT JOIN compressed_data_files ON compressed_data_files.data_file = items.data_file
+		WHERE items.id > ? AND items.data_file IS NOT NULL
+		ORDER BY items.id
+		LIMIT ?`, afterItemID, integrityCheckPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("querying items to check: %v", err)
+	}
+	defer rows.Close()
+
+	var page []integrityCheckItem
+	for rows.Next() {
+		var it integrityCheckItem
+		if err := rows.Scan(&it.rowID, &it.dataFile, &it.dataHash, &it.compression); err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		page = append(page, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return page, nil
+}
+
+func (tl *Timeline) saveIntegrityCheck(result IntegrityCheckResult, lastItemID int64, status string) error {
+	var problemsJSON *string
+	if len(result.Problems) > 0 {
+		b, err := json.Marshal(result.Problems)
+		if err != nil {
+			return fmt.Errorf("encoding problems: %v", err)
+		}
+		str := string(b)
+		problemsJSON = &str
+	}
+
+	var ended *int64
+	if status != importStatusStarted {
+		now := time.Now().Unix()
+		ended = &now
+	}
+
+	tl.dbMu.Lock()
+	_, err := tl.db.Exec(`UPDATE integrity_checks
+		SET status=?, ended=?, last_item_id=?, checked_count=?, problems=?
+		WHERE id=?`, // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		status, ended, lastItemID, result.Checked, problemsJSON, result.CheckID)
+	tl.dbMu.Unlock()
+	return err
+}
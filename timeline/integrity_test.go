@@ -0,0 +1,109 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// testFileItem returns an item whose content will be stored in a data file.
+func testFileItem(id string, ts time.Time) *Item {
+	return &Item{
+		ID:             id,
+		Classification: ClassMedia,
+		Timestamp:      ts,
+		Content: ItemData{
+			Filename:  id + ".bin",
+			MediaType: "application/octet-stream",
+			Data:      ByteData([]byte("binary contents of " + id)),
+		},
+	}
+}
+
+func TestCheckIntegrityResume(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const numItems = 5
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	var items []*Item
+	for i := 0; i < numItems; i++ {
+		items = append(items, testFileItem(fmt.Sprintf("file%d", i), ts.Add(time.Duration(i)*time.Hour)))
+	}
+	importTestItems(t, tl, items...)
+
+	// corrupt the data file of the last item
+	var corruptID int64
+	var corruptFile string
+	err := tl.db.QueryRow(`SELECT id, data_file FROM items WHERE data_file IS NOT NULL ORDER BY id DESC LIMIT 1`).Scan(&corruptID, &corruptFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tl.FullPath(corruptFile), []byte("corrupted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// start checking, but cancel part way through
+	const cancelAfter = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result, err := tl.CheckIntegrity(ctx, IntegrityCheckOptions{
+		ProgressFunc: func(st IntegrityCheckStatus) {
+			if st.Checked == cancelAfter {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled error, got: %v", err)
+	}
+	if result.Complete || result.Checked != cancelAfter {
+		t.Fatalf("expected partial result with %d checked, got: %+v", cancelAfter, result)
+	}
+
+	// resume and ensure only the remaining files are checked
+	var checkedOnResume []int64
+	result, err = tl.CheckIntegrity(context.Background(), IntegrityCheckOptions{
+		ResumeCheckID: result.CheckID,
+		ProgressFunc: func(st IntegrityCheckStatus) {
+			checkedOnResume = append(checkedOnResume, st.LastItemID)
+		},
+	})
+	if err != nil {
+		t.Fatalf("resuming integrity check: %v", err)
+	}
+	if len(checkedOnResume) != numItems-cancelAfter {
+		t.Errorf("expected %d files to be checked on resume, got %d (%v)", numItems-cancelAfter, len(checkedOnResume), checkedOnResume)
+	}
+	if !result.Complete || result.Checked != numItems {
+		t.Errorf("expected complete result with %d checked, got: %+v", numItems, result)
+	}
+	if len(result.Problems) != 1 || result.Problems[0].ItemID != corruptID {
+		t.Errorf("expected one problem with item %d, got: %+v", corruptID, result.Problems)
+	}
+
+	// a completed check cannot be resumed
+	if _, err := tl.CheckIntegrity(context.Background(), IntegrityCheckOptions{ResumeCheckID: result.CheckID}); err == nil {
+		t.Error("expected error resuming a completed integrity check")
+	}
+}
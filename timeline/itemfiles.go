@@ -39,6 +39,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// errDataFileCorrupt is returned when a downloaded data file does not match the
+// size or hash that the data source expects, even after downloading it again.
+var errDataFileCorrupt = errors.New("data file does not match its expected checksum")
+
 // downloadDataFile downloads the data file and hashes it. It attaches the
 // results to the item.
 func (p *processor) downloadDataFile(ctx context.Context, it *Item) error {
@@ -46,15 +50,27 @@ func (p *processor) downloadDataFile(ctx context.Context, it *Item) error {
 		return nil
 	}
 	h := newHash()
-	dataFileSize, err := p.downloadAndHashDataFile(it, h)
+	dataFileSize, err := p.downloadAndHashDataFile(ctx, it, h)
+	if errors.Is(err, errDataFileCorrupt) {
+		// not much we can do if the source keeps giving us bad data; skip
+		// the item so that it will be tried again by the next import
+		p.log.Warn("skipping item with corrupt data file",
+			zap.String("item_original_id", it.ID),
+			zap.String("data_file_name", it.dataFileName),
+			zap.Error(err))
+		p.countSkip(SkipReasonDataFileCorrupt)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	it.dataFileWritten = true
 	it.dataFileSize = dataFileSize
 	if dataFileSize > 0 {
 		it.dataFileHash = h.Sum(nil)
 	}
 	it.makeContentHash() // update content hash now that we know the data file hash
+	p.postProcessDataFile(ctx, it)
 	return nil
 }
 
@@ -63,10 +79,17 @@ func (p *processor) downloadDataFile(ctx context.Context, it *Item) error {
 // It returns the size of the data file that was downloaded and, if the item was found
 // to be a duplicate, the row ID of the existing row for this item.
 func (p *processor) finishDataFileProcessing(ctx context.Context, tx *sql.Tx, it *Item) error {
-	if it == nil || it.dataFileOut == nil {
+	if it == nil || (it.dataFileOut == nil && !isExternalDataFile(it.dataFileName)) {
 		return nil
 	}
 
+	// an item whose data file was not written completely (because it was corrupt,
+	// or writing it failed, or it was never attempted because another item in its
+	// graph failed first) must not point to a missing or partial file
+	if !it.dataFileWritten {
+		return p.rollBackDataFile(tx, it)
+	}
+
 	// Now that we have a hash of the file, perform one last check WITHOUT row/original IDs to ensure the checksum
 	// will be used in the query, to see if this ITEM is a duplicate. The check we performed before processing the
 	// file doesn't have the file hash yet because it hasn't downloaded the item. (Even if the item is distinct,
@@ -84,11 +107,12 @@ func (p *processor) finishDataFileProcessing(ctx context.Context, tx *sql.Tx, it
 	// First, copy the item so we can zero-out its original ID; then nilify the data source name if empty...
 	itCopy := *it
 	itCopy.ID = ""
+	itCopy.GlobalID = ""
 	var dsName *string
 	if p.params.DataSourceName != "" {
 		dsName = &p.params.DataSourceName
 	}
-	if existingItemRow, err := p.tl.loadItemRow(ctx, tx, 0, &itCopy, dsName, p.params.ProcessingOptions.ItemUniqueConstraints, true); err == nil && existingItemRow.ID > 0 && existingItemRow.ID != it.row.ID {
+	if existingItemRow, err := p.tl.loadItemRow(ctx, tx, 0, &itCopy, dsName, p.params.ProcessingOptions.DedupScope, p.dedupAccountID(), p.params.ProcessingOptions.ItemUniqueConstraints, p.params.ProcessingOptions.TimeAwareDedup, true); err == nil && existingItemRow.ID > 0 && existingItemRow.ID != it.row.ID {
 		// ah, so with the file hash, we have now determined that we already have the item;
 		// this is a duplicate ITEM, so delete the row and file we just created, and since this
 		// is being done asynchronously, the duplicate item has already finished processing
@@ -103,9 +127,14 @@ func (p *processor) finishDataFileProcessing(ctx context.Context, tx *sql.Tx, it
 			zap.Binary("data_file_hash", it.dataFileHash),
 			zap.Int64("bytes_written", it.dataFileSize))
 
-		// delete duplicate data file
-		if err := os.Remove(it.dataFileOut.Name()); err != nil {
-			return fmt.Errorf("deleting duplicate data file %s: %v", it.dataFileOut.Name(), err)
+		// delete duplicate data file (but never a referenced one)
+		if it.dataFileOut != nil {
+			if err := os.Remove(it.dataFileOut.Name()); err != nil {
+				return fmt.Errorf("deleting duplicate data file %s: %v", it.dataFileOut.Name(), err)
+			}
+		}
+		if err := it.removeDerivedFiles(p.tl); err != nil {
+			return err
 		}
 
 		// update references to the newly-inserted row to refer to the existing row instead,
@@ -135,6 +164,11 @@ func (p *processor) finishDataFileProcessing(ctx context.Context, tx *sql.Tx, it
 			p.log.Debug("after detecting duplicate item file, could not update relevant tags",
 				zap.Error(fmt.Errorf("updating tagged referencing item_id %d to %d: %v", existingItemRow.ID, it.row.ID, err)))
 		}
+		// the existing item was also seen in this import (if it was already recorded
+		// for this import, the duplicate's record is deleted along with its row)
+		if _, err := tx.Exec(`UPDATE OR IGNORE import_items SET item_id=? WHERE item_id=?`, existingItemRow.ID, it.row.ID); err != nil {
+			return fmt.Errorf("recording that item %d was seen in this import: %v", existingItemRow.ID, err)
+		}
 		if _, err = tx.Exec(`DELETE FROM items WHERE id=?`, it.row.ID); err != nil {
 			p.log.Debug("after detecting duplicate item file, could not delete item row",
 				zap.Error(fmt.Errorf("deleting duplicate item row %d: %v", it.row.ID, err)))
@@ -160,9 +194,11 @@ func (p *processor) finishDataFileProcessing(ctx context.Context, tx *sql.Tx, it
 			return fmt.Errorf("unlinking data file from item row: %v", err)
 		}
 
-		// delete the empty data file
-		if err := os.Remove(it.dataFileOut.Name()); err != nil {
-			return fmt.Errorf("deleting empty data file: %v", err)
+		// delete the empty data file (but never a referenced one)
+		if it.dataFileOut != nil {
+			if err := os.Remove(it.dataFileOut.Name()); err != nil {
+				return fmt.Errorf("deleting empty data file: %v", err)
+			}
 		}
 
 		return nil
@@ -174,8 +210,23 @@ func (p *processor) finishDataFileProcessing(ctx context.Context, tx *sql.Tx, it
 	// which is kind of pointless IMO
 	// (this is where it's important that it.row.DataFile is not a pointer to it.dataFileName,
 	// because we end up changing the value of it.dataFileName in this method)
-	if err := p.replaceWithExisting(tx, &it.dataFileName, it.dataFileHash, it.row.ID); err != nil {
-		return fmt.Errorf("replacing data file with identical existing file: %v", err)
+	// (a referenced file is not in the repo, so there is no copy to replace)
+	downloadedDataFile := it.dataFileName
+	if !isExternalDataFile(it.dataFileName) {
+		if err := p.replaceWithExisting(tx, &it.dataFileName, it.dataFileHash, it.row.ID, it.dataFileCompression); err != nil {
+			return fmt.Errorf("replacing data file with identical existing file: %v", err)
+		}
+	}
+
+	// remember whether the file we just wrote is compressed (if we kept it)
+	if it.dataFileName == downloadedDataFile {
+		if err := setDataFileCompression(tx, it.dataFileName, it.dataFileCompression); err != nil {
+			return err
+		}
+	}
+
+	if err := p.recordDerivedFiles(tx, it); err != nil {
+		return err
 	}
 
 	// save the file's name and hash to all items which use it, to confirm it was downloaded successfully
@@ -188,7 +239,7 @@ func (p *processor) finishDataFileProcessing(ctx context.Context, tx *sql.Tx, it
 	if err != nil {
 		p.log.Error("updating item's data file hash in DB failed; hash info will be incorrect or missing",
 			zap.Error(err),
-			zap.String("filename", it.dataFileOut.Name()),
+			zap.String("filename", it.dataFileName),
 			zap.Int64("row_id", it.row.ID),
 		)
 	}
@@ -203,16 +254,19 @@ func (p *processor) finishDataFileProcessing(ctx context.Context, tx *sql.Tx, it
 		)
 	}
 
-	return nil
+	return p.linkSameContent(ctx, tx, it.row.ID, it)
 }
 
 // downloadAndHashDataFile downloads the data file for the item, computing h along the way.
+// If the item's content has an expected size or hash, the file is verified, and downloaded
+// again if it doesn't match; if it still doesn't match after the configured number of
+// attempts, the file is deleted and an error wrapping errDataFileCorrupt is returned.
 // It closes the file handles and returns the number of bytes copied.
 //
 // The item must not be nil, but it can have nil file handles without error; in that
 // case this is a no-op. If only one file handle is nil, the other file is closed and
 // an error is returned.
-func (p *processor) downloadAndHashDataFile(it *Item, h hash.Hash) (int64, error) {
+func (p *processor) downloadAndHashDataFile(ctx context.Context, it *Item, h hash.Hash) (int64, error) {
 	if it == nil {
 		return 0, fmt.Errorf("missing item for which to download file")
 	}
@@ -236,34 +290,158 @@ func (p *processor) downloadAndHashDataFile(it *Item, h hash.Hash) (int64, error
 	if it.dataFileIn == nil {
 		return 0, fmt.Errorf("%s: missing reader from which to download file (filename=%s original_location=%s intermediate_location=%s rowid=%d)", it.dataFileName, it.Content.Filename, it.OriginalLocation, it.IntermediateLocation, it.row.ID)
 	}
+	if it.dataFileOut == nil && isExternalDataFile(it.dataFileName) {
+		// the file is referenced in place, so it only needs to be hashed
+		n, err := io.Copy(h, it.dataFileIn)
+		if err != nil {
+			return n, fmt.Errorf("hashing referenced file: %v", err)
+		}
+		return n, nil
+	}
 	if it.dataFileOut == nil {
 		return 0, fmt.Errorf("%s: missing writer with which to write file (filename=%s original_location=%s intermediate_location=%s rowid=%d)", it.dataFileName, it.Content.Filename, it.OriginalLocation, it.IntermediateLocation, it.row.ID)
 	}
 
-	// give the hasher a copy of the file bytes
-	tr := io.TeeReader(it.dataFileIn, h)
+	// write to a temporary file next to the data file, and only move it into
+	// place (by atomic rename) once it is complete, so that a failed write
+	// never leaves a partial file behind under the name the row refers to;
+	// the empty file at the final name keeps that name claimed meanwhile
+	finalName := it.dataFileOut.Name()
+	tmp, err := os.CreateTemp(filepath.Dir(finalName), filepath.Base(finalName)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("creating temporary data file: %v", err)
+	}
+	it.dataFileTemp = tmp
 
-	n, err := io.Copy(it.dataFileOut, tr)
+	n, err := p.writeDataFile(ctx, it, h)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		it.dataFileOut.Close()
+		err = os.Rename(tmp.Name(), finalName)
+	}
 	if err != nil {
-		os.Remove(it.dataFileOut.Name())
-		return n, fmt.Errorf("copying contents: %v", err)
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return n, err
 	}
 
-	// TODO: If n == 0, should we retry? (would need to call h.Reset() first) - to help handle sporadic I/O issues maybe
+	p.log.Debug("downloaded data file",
+		zap.String("item_id", it.ID),
+		zap.String("filename", finalName),
+		zap.Int64("size", n),
+	)
+
+	return n, nil
+}
+
+// writeDataFile writes the item's data into its temporary data file, downloading
+// it again if it does not match the size or hash the data source expects.
+func (p *processor) writeDataFile(ctx context.Context, it *Item, h hash.Hash) (int64, error) {
+	attempts := p.params.ProcessingOptions.DownloadAttempts
+	if attempts == 0 {
+		attempts = downloadAttempts
+	}
+
+	var n int64
+	for attempt := 1; ; attempt++ {
+		var expected hash.Hash
+		if len(it.Content.ExpectedHash) > 0 {
+			expected = it.Content.expectedHasher()
+		}
+
+		var err error
+		n, err = p.copyDataFile(it, h, expected)
+		if err != nil && !errors.Is(err, errDataFileCorrupt) {
+			return n, err
+		}
+
+		// the data may have found itself to be corrupt already (see DownloadData)
+		verifyErr := err
+		if verifyErr == nil {
+			verifyErr = it.Content.verify(n, expected)
+		}
+		if verifyErr == nil {
+			break
+		}
+		if attempt >= attempts {
+			return n, fmt.Errorf("%w after %d attempts: %v", errDataFileCorrupt, attempt, verifyErr)
+		}
+
+		p.log.Warn("downloaded data file does not match its expected checksum; trying again",
+			zap.String("item_id", it.ID),
+			zap.String("filename", it.dataFileOut.Name()),
+			zap.Int("attempt", attempt),
+			zap.Error(verifyErr))
+
+		// start over with a fresh stream and an empty file
+		it.dataFileIn.Close()
+		it.dataFileIn = nil
+		rc, err := it.Content.open(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("getting item's data stream again: %v", err)
+		}
+		if rc == nil {
+			return 0, fmt.Errorf("%w: no data on attempt %d", errDataFileCorrupt, attempt+1)
+		}
+		it.dataFileIn = rc
+		if _, err := it.dataFileTemp.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("rewinding data file: %v", err)
+		}
+		if err := it.dataFileTemp.Truncate(0); err != nil {
+			return 0, fmt.Errorf("truncating data file: %v", err)
+		}
+		h.Reset()
+		it.dataFileCompression = ""
+	}
 
 	// we can probably increase performance if we don't sync all the time, but that would be less reliable...
 	if n > 0 {
-		if err := it.dataFileOut.Sync(); err != nil {
-			os.Remove(it.dataFileOut.Name())
+		if err := it.dataFileTemp.Sync(); err != nil {
 			return n, fmt.Errorf("syncing file after downloading: %v", err)
 		}
 	}
 
-	p.log.Debug("downloaded data file",
-		zap.String("item_id", it.ID),
-		zap.String("filename", it.dataFileOut.Name()),
-		zap.Int64("size", n),
-	)
+	return n, nil
+}
+
+// copyDataFile copies the item's data into its temporary data file, computing h
+// and, if not nil, expected along the way. It returns the number of bytes read,
+// which is the size of the uncompressed content.
+func (p *processor) copyDataFile(it *Item, h, expected hash.Hash) (int64, error) {
+	// give the hasher a copy of the file bytes (the hash is always
+	// of the uncompressed content, so dedup works either way)
+	var hashes io.Writer = h
+	if expected != nil {
+		hashes = io.MultiWriter(h, expected)
+	}
+	tr := io.TeeReader(it.dataFileIn, hashes)
+
+	// compress text-like files if configured to do so
+	var out io.Writer = it.dataFileTemp
+	var compressor io.WriteCloser
+	if c := p.params.ProcessingOptions.CompressDataFiles; c != "" && compressibleMediaType(it.Content.MediaType) {
+		var err error
+		compressor, err = c.newWriter(it.dataFileTemp)
+		if err != nil {
+			return 0, fmt.Errorf("compressing contents: %v", err)
+		}
+		it.dataFileCompression = c
+		out = compressor
+	}
+
+	n, err := io.Copy(out, tr)
+	if err != nil {
+		return n, fmt.Errorf("copying contents: %w", err)
+	}
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return n, fmt.Errorf("finishing compressed contents: %v", err)
+		}
+	}
+
+	// TODO: If n == 0, should we retry? (would need to call h.Reset() first) - to help handle sporadic I/O issues maybe
 
 	return n, nil
 }
@@ -278,30 +456,41 @@ func (p *processor) downloadAndHashDataFile(it *Item, h hash.Hash) (int64, error
 // a collision to occur, as the DB is the source of truth, and this function creates a file
 // but does not update the DB, so it is expected that the filename is "claimed" in the DB in
 // the transaction tx before tx is committed.
-func (t *Timeline) openUniqueCanonicalItemDataFile(tx *sql.Tx, logger *zap.Logger, it *Item, dataSourceID string) (*os.File, string, error) {
+//
+// If namer is not nil, it chooses the filename instead of the default.
+func (t *Timeline) openUniqueCanonicalItemDataFile(tx *sql.Tx, logger *zap.Logger, it *Item, dataSourceID string, namer DataFileNamer) (*os.File, string, error) {
 	if dataSourceID == "" {
 		return nil, "", fmt.Errorf("missing data source ID")
 	}
 
 	dir := t.canonicalItemDataFileDir(it, dataSourceID)
-
-	err := os.MkdirAll(t.FullPath(dir), 0700)
-	if err != nil {
-		return nil, "", fmt.Errorf("making directory for data file: %v", err)
-	}
+	shardLevels := t.DataFileShardLevels()
 
 	// find a unique filename for this item
 	canonicalFilename := t.canonicalItemDataFileName(it, dataSourceID)
+	if namer != nil {
+		if name := t.safePathComponent(namer(it, canonicalFilename)); name != "" {
+			canonicalFilename = t.ensureDataFileNameShortEnough(name)
+		}
+	}
 	canonicalFilenameExt := path.Ext(canonicalFilename)
 	canonicalFilenameWithoutExt := strings.TrimSuffix(canonicalFilename, canonicalFilenameExt)
 
 	for i := 0; i < 10; i++ {
-		// build the filepath to try; only add randomness to the filename if the original name isn't available
-		tryPath := path.Join(dir, canonicalFilenameWithoutExt)
+		// build the filename to try; only add randomness to the filename if the original name isn't available
+		tryName := canonicalFilenameWithoutExt
 		if i > 0 {
-			tryPath += fmt.Sprintf("__%s", safeRandomString(4, true, nil)) // same case == true for portability to case-insensitive file systems
+			tryName += fmt.Sprintf("__%s", safeRandomString(4, true, nil)) // same case == true for portability to case-insensitive file systems
+		}
+		tryName += canonicalFilenameExt
+
+		// the shard directories (if any) are derived from the final filename,
+		// so they have to be computed for each attempt
+		tryDir := shardedDataFileDir(dir, tryName, shardLevels)
+		if err := os.MkdirAll(t.FullPath(tryDir), 0700); err != nil {
+			return nil, "", fmt.Errorf("making directory for data file: %v", err)
 		}
-		tryPath += canonicalFilenameExt
+		tryPath := path.Join(tryDir, tryName)
 
 		// see if the filename is available; create it with EXCLUSIVE so that we don't truncate any existing
 		// file, and instead we should get a special error that the file already exists if it's taken...
@@ -390,7 +579,8 @@ func (t *Timeline) canonicalItemDataFileName(it *Item, dataSourceID string) stri
 
 // canonicalItemDataFileDir returns the path to the directory for the given item
 // relative to the timeline root, using forward slash as path separators (this
-// is the form used in the data_file column of the DB).
+// is the form used in the data_file column of the DB). If data files are sharded,
+// the file goes in shard folders within this directory (see shardedDataFileDir).
 func (t *Timeline) canonicalItemDataFileDir(it *Item, dataSourceID string) string {
 	ts := it.Timestamp
 	if ts.IsZero() {
@@ -423,7 +613,7 @@ func (t *Timeline) ensureDataFileNameShortEnough(filename string) string {
 }
 
 // TODO:/NOTE: If changing a file name, all items with same data_hash must also be updated to use same file name
-func (p *processor) replaceWithExisting(tx *sql.Tx, canonical *string, checksum []byte, itemRowID int64) error {
+func (p *processor) replaceWithExisting(tx *sql.Tx, canonical *string, checksum []byte, itemRowID int64, compression DataFileCompression) error {
 	if canonical == nil || *canonical == "" || len(checksum) == 0 {
 		return fmt.Errorf("missing data filename and/or hash of contents")
 	}
@@ -453,15 +643,24 @@ func (p *processor) replaceWithExisting(tx *sql.Tx, canonical *string, checksum
 		return fmt.Errorf("item with matching hash is missing data file name; hash: %x", checksum)
 	}
 
+	// a referenced file outside the repo could go away at any time, so keep our copy
+	if isExternalDataFile(*existingDatafile) {
+		return nil
+	}
+
 	// TODO: maybe this all should be limited to only when integrity checks are enabled? how do we know that this download has the right version/contents?
 	p.log.Debug("verifying existing file is still the same",
 		zap.Int64("row_id", itemRowID),
 		zap.Stringp("existing_data_file", existingDatafile),
 		zap.Binary("checksum", checksum))
 
-	// ensure the existing file is still the same
+	// ensure the existing file is still the same (its hash is of its uncompressed content)
+	existingCompression, err := dataFileCompression(p.tl.ctx, tx, *existingDatafile)
+	if err != nil {
+		return err
+	}
 	h := newHash()
-	f, err := os.Open(p.tl.FullPath(*existingDatafile))
+	f, err := p.tl.openDataFile(*existingDatafile, existingCompression)
 	if err != nil {
 		// TODO: This error is happening often when (re-?)importing SMS backup & restore MMS data files ("no such file or directory")
 		return fmt.Errorf("opening existing file: %v", err)
@@ -489,6 +688,9 @@ func (p *processor) replaceWithExisting(tx *sql.Tx, canonical *string, checksum
 		if err != nil {
 			return fmt.Errorf("replacing modified data file: %v", err)
 		}
+		if err := setDataFileCompression(tx, *existingDatafile, compression); err != nil {
+			return err
+		}
 	} else {
 		// everything checks out; delete the newly-downloaded file
 		// and use the existing file instead of duplicating it
@@ -543,6 +745,9 @@ func randomString(n int, sameCase bool, r mathrand.Source) string {
 // FullPath returns the full file system path for a data file, including the repo path.
 // It converts forward slashes in the input to the file system path separator.
 func (t *Timeline) FullPath(canonicalDatafileName string) string {
+	if isExternalDataFile(canonicalDatafileName) {
+		return filepath.FromSlash(canonicalDatafileName)
+	}
 	return filepath.Join(t.repoDir, filepath.FromSlash(canonicalDatafileName))
 }
 
@@ -25,6 +25,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"os"
@@ -36,6 +37,12 @@ import (
 // Graph is either an item or entity node with optional connections to other
 // items and entities. Either an Item or Entity may be set, but not both.
 // All Graph values should be pointers to ensure consistency.
+//
+// A graph with only an Entity, such as a contact from an address book, adds
+// the entity to the timeline (or updates the existing entity it matches by
+// identifying attributes like email address or phone number) without creating
+// any items. Items imported later whose owner has a matching identifying
+// attribute are then attributed to that entity.
 // The usual weird/fun thing about representing graph data structures
 // in memory is that a graph is a node, and a node is a graph. 🤓
 type Graph struct {
@@ -56,18 +63,47 @@ type Graph struct {
 	// TODO: experimental - let the processor decide whether to checkpoint
 	Checkpoint any
 
+	// Optional provenance of the item: the file it was read from (usually one of
+	// the import's filenames, or a path within it) and the position within that
+	// file, such as a line number or byte offset. This is stored with the item to
+	// help debug parsing problems. Graphs connected by edges inherit the provenance
+	// of the graph they are connected to, unless they set their own.
+	SourceFile   string
+	SourceOffset int64
+
 	// state needed by processing pipeline
 	err error
 }
 
-// Size returns the number of nodes in the graph. If this is
-// an entity graph, the size is 1. If it's an item graph, the
-// size is counted recursively across relationship edges.
+// Size returns the number of nodes and edges in the graph, counted
+// recursively across relationship edges. Since each node and edge is
+// stored as a row, this is roughly how many rows the graph adds to the
+// transaction that processes it.
 func (g *Graph) Size() int {
-	if g.Entity != nil {
-		return 1
+	return g.recursiveSize(make(map[*Graph]struct{}))
+}
+
+func (g *Graph) recursiveSize(visited map[*Graph]struct{}) int {
+	if g == nil {
+		return 0
 	}
-	return g.ItemCount()
+
+	// prevent infinite recursion
+	if _, ok := visited[g]; ok {
+		return 0
+	}
+	visited[g] = struct{}{}
+
+	var size int
+	if g.Item != nil || g.Entity != nil {
+		size++
+	}
+	for _, edge := range g.Edges {
+		size++ // the relationship itself
+		size += edge.From.recursiveSize(visited)
+		size += edge.To.recursiveSize(visited)
+	}
+	return size
 }
 
 func (g *Graph) ItemCount() int {
@@ -149,6 +185,25 @@ func (g *Graph) FromEntityWithValue(entity *Entity, rel Relation, value any) {
 	})
 }
 
+// ReplyTo links the item on this graph as a reply to the item with the given
+// original ID from the same data source, forming a thread. The parent doesn't
+// need to be imported before the reply: if it isn't in the timeline yet, a
+// placeholder is stored that is filled in when the parent is imported later
+// (unless the import drops missing references; see MissingReferencePolicy).
+func (g *Graph) ReplyTo(parentID string) {
+	g.ToItem(RelReply, &Item{ID: parentID, forwardRef: true})
+}
+
+// AddRepresentation links another representation of the item on this graph,
+// such as an edited version of a photo, with the given role. The item on this
+// graph is the primary representation, which is the one that appears in search
+// results; see Timeline.ItemRepresentations to get all of them. Each
+// representation is its own item with its own data file, so they are
+// deduplicated independently.
+func (g *Graph) AddRepresentation(role RepresentationRole, item *Item) {
+	g.ToItemWithValue(RelRepresentation, item, string(role))
+}
+
 func (g *Graph) String() string {
 	if g.Item != nil {
 		return fmt.Sprintf("item:%s", g.Item.String())
@@ -188,9 +243,20 @@ type Item struct {
 	// Approximate error of the time values.
 	TimeUncertainty time.Duration
 
+	// An optional ordinal that orders this item relative to
+	// other items with the same timestamp. If not set, the
+	// processor assigns it from the order in which the data
+	// source emits items, so most data sources need not set it.
+	Sequence int64
+
 	// The coordinates where the item originated.
 	Location Location
 
+	// For items that span a sequence of coordinates rather than
+	// a single point, such as GPS tracks and routes, the points
+	// in order. If Location is empty, it is set to the first point.
+	Geometry []GeometryPoint
+
 	// The person who owns, created, or originated the item. At
 	// least one attribute is required: an identifying attribute
 	// like a user ID of the person on this data source.
@@ -225,21 +291,48 @@ type Item struct {
 	// TODO: Very experimental
 	Retrieval ItemRetrieval
 
+	// Who may see the item. If nil, the import's default
+	// visibility is used, if any; otherwise it is public.
+	Visibility *Visibility
+
+	// The globally-unique ID of the item, if it already has one;
+	// typically only set when importing data that was exported
+	// from a timeline, so the item keeps its identity across
+	// repos. Otherwise, it is assigned when the item is stored,
+	// depending on the repo's item ID strategy.
+	GlobalID string
+
 	// Used for storing state during processing; either the
 	// text content of the item, or the source from which
 	// to read when creating the data file on disk. Data
 	// sources should set Content instead; NOT these!
 	dataText *string
 
+	// the normalized form of dataText, if text normalization is enabled
+	normalizedText *string
+
+	// provenance of the item, copied from its graph by the processor
+	sourceFile   string
+	sourceOffset int64
+
+	// set if the item is only a reference to an item that may arrive
+	// later, such as the parent of a reply (see Graph.ReplyTo)
+	forwardRef bool
+
 	// state for processing pipeline phases
-	row          ItemRow
-	dataFileIn   io.ReadCloser
-	dataFileOut  *os.File
-	dataFileSize int64
-	dataFileName string
-	dataFileHash []byte // should only be set if dataFileSize > 0
-	idHash       []byte
-	contentHash  []byte
+	row                 ItemRow
+	dataFileIn          io.ReadCloser
+	dataFileOut         *os.File // placeholder that claims the data file's name
+	dataFileTemp        *os.File // where the data file is written before being moved into place
+	dataFileSize        int64    // size of the uncompressed content
+	dataFileName        string
+	dataFileHash        []byte // should only be set if dataFileSize > 0
+	dataFileCompression DataFileCompression
+	dataFileWritten     bool              // if the data file was completely written (or hashed, if referenced in place)
+	derivedFiles        []DerivedDataFile // files made from the data file by post-processors
+	insertedRow         bool              // if the row was inserted (rather than updated) by this import
+	idHash              []byte
+	contentHash         []byte
 }
 
 type ItemRetrieval struct {
@@ -423,6 +516,22 @@ type ItemData struct {
 	// A function that returns a way to read the item's data. The
 	// returned ReadCloser will be closed when processing finishes.
 	Data DataFunc
+
+	// The path on the local file system of the file that Data reads
+	// from, if the content is a regular file (not within an archive,
+	// for example). Optional; it is used to apply the SymlinkPolicy
+	// processing option. Data must still be set.
+	LocalPath string
+
+	// If set, the size in bytes and the hash that Data is expected to have,
+	// as given by the data source (for example, in an API response). After
+	// the data file is downloaded, it is verified, and if it doesn't match,
+	// it is downloaded again (see ProcessingOptions.DownloadAttempts); if it
+	// never matches, the item is skipped. ExpectedHash is computed with
+	// ExpectedHashFunc, or with BLAKE3 (like data_hash) if that is nil.
+	ExpectedSize     int64
+	ExpectedHash     []byte
+	ExpectedHashFunc func() hash.Hash
 }
 
 // hasPlainTextMediaType returns true fi the item is declared as having
@@ -442,8 +551,43 @@ func (id ItemData) hasPlainTextMediaType() bool {
 	return false
 }
 
+// expectedHasher returns a new hash with which to compute ExpectedHash.
+func (id ItemData) expectedHasher() hash.Hash {
+	if id.ExpectedHashFunc != nil {
+		return id.ExpectedHashFunc()
+	}
+	return newHash()
+}
+
+// open calls Data with a context that lets the data verify itself against
+// the expected size and hash, if it can, before it is read (see DownloadData).
+func (id ItemData) open(ctx context.Context) (io.ReadCloser, error) {
+	return id.Data(context.WithValue(ctx, expectedDataCtxKey, id))
+}
+
+// verify returns an error if the size or the hash of the data that was
+// read do not match the expected values. If an expected hash is given,
+// h must have been computed with expectedHasher.
+func (id ItemData) verify(size int64, h hash.Hash) error {
+	if id.ExpectedSize > 0 && size != id.ExpectedSize {
+		return fmt.Errorf("expected %d bytes, got %d", id.ExpectedSize, size)
+	}
+	if len(id.ExpectedHash) > 0 {
+		if sum := h.Sum(nil); !bytes.Equal(sum, id.ExpectedHash) {
+			return fmt.Errorf("expected hash %x, got %x", id.ExpectedHash, sum)
+		}
+	}
+	return nil
+}
+
 // DataFunc is a function that returns an item's data. It must honor
 // context cancellation if it does anything long-running or async.
+//
+// The reader is streamed into the repo as it is read, and the data file
+// is hashed along the way, so content of any size can be stored without
+// holding it in memory. Large content, such as media, should therefore
+// be returned as a stream (for example, an open file or the body of an
+// HTTP response) rather than being read into memory first.
 type DataFunc func(context.Context) (io.ReadCloser, error)
 
 // Metadata is a map of arbitrary extra information to associate
@@ -602,15 +746,17 @@ func (m Metadata) Merge(incoming Metadata, policy MetadataMergePolicy) {
 // human-friendly phrases when visualizing the timeline.
 var (
 	// TODO: rename to RelAttaches? (and label to "attaches"?)
-	RelAttachment   = Relation{Label: "attachment", Directed: true, Subordinating: true} // "<from_item> has attachment <to_item>", or "<to> is attached to <from>"
-	RelSent         = Relation{Label: "sent", Directed: true}                            // "<from_item> was sent to <to_person>"
-	RelCCed         = Relation{Label: "cc", Directed: true}                              // "<from_item> is carbon-copied to <to_person>"
-	RelReply        = Relation{Label: "reply", Directed: true}                           // "<from_item> is reply to <to_item>"
-	RelQuotes       = Relation{Label: "quotes", Directed: true}                          // "<from_item> quotes <to>", or "<to> is quoted by <from>"
-	RelReacted      = Relation{Label: "reacted", Directed: true}                         // "<from_entity>" reacted to <to_item> with <value>"
-	RelDepicts      = Relation{Label: "depicts", Directed: true}                         // flexible, but most common is: "<from_item> depicts <to_entity>"
-	RelEdit         = Relation{Label: "edit", Directed: true}                            // "<to_item> is edit of <from_item>"
-	RelInCollection = Relation{Label: "in_collection", Directed: true}                   // "<from_item> is in collection <to_item> at position <value>"
+	RelAttachment     = Relation{Label: "attachment", Directed: true, Subordinating: true}     // "<from_item> has attachment <to_item>", or "<to> is attached to <from>"
+	RelSent           = Relation{Label: "sent", Directed: true}                                // "<from_item> was sent to <to_person>"
+	RelCCed           = Relation{Label: "cc", Directed: true}                                  // "<from_item> is carbon-copied to <to_person>"
+	RelReply          = Relation{Label: "reply", Directed: true}                               // "<from_item> is reply to <to_item>"
+	RelQuotes         = Relation{Label: "quotes", Directed: true}                              // "<from_item> quotes <to>", or "<to> is quoted by <from>"
+	RelReacted        = Relation{Label: "reacted", Directed: true}                             // "<from_entity>" reacted to <to_item> with <value>"
+	RelDepicts        = Relation{Label: "depicts", Directed: true}                             // flexible, but most common is: "<from_item> depicts <to_entity>"
+	RelEdit           = Relation{Label: "edit", Directed: true}                                // "<to_item> is edit of <from_item>"
+	RelInCollection   = Relation{Label: "in_collection", Directed: true}                       // "<from_item> is in collection <to_item> at position <value>"
+	RelSameContent    = Relation{Label: "same_content"}                                        // "<from_item> has the same content as <to_item>" (at a different time)
+	RelRepresentation = Relation{Label: "representation", Directed: true, Subordinating: true} // "<to_item> is another representation of <from_item>, as <value>" (see RepresentationRole)
 	// RelTranscript = Relation{Label: "transcript", Directed: true, Subordinating: true} // "<from_item> is transcribed by <to_item>"
 )
 
@@ -622,29 +768,37 @@ type ItemRow struct {
 	ModifiedImportID     *int64          `json:"modified_import_id,omitempty"`
 	AttributeID          *int64          `json:"attribute_id,omitempty"`
 	ClassificationID     *int64          `json:"classification_id,omitempty"` // row ID, used only internally
+	Category             *string         `json:"category,omitempty"`
 	OriginalID           *string         `json:"original_id,omitempty"`
 	OriginalLocation     *string         `json:"original_location,omitempty"`
 	IntermediateLocation *string         `json:"intermediate_location,omitempty"`
 	Filename             *string         `json:"filename,omitempty"`
 	Timestamp            *time.Time      `json:"timestamp,omitempty"`
+	OriginalTimestamp    *time.Time      `json:"original_timestamp,omitempty"` // set if Timestamp was clamped because it was in the future
 	Timespan             *time.Time      `json:"timespan,omitempty"`
 	Timeframe            *time.Time      `json:"timeframe,omitempty"`
 	TimeOffset           *int            `json:"time_offset,omitempty"`
 	TimeUncertainty      *int64          `json:"time_uncertainty,omitempty"`
+	Sequence             *int64          `json:"sequence,omitempty"`
+	SourceFile           *string         `json:"source_file,omitempty"`
+	SourceOffset         *int64          `json:"source_offset,omitempty"`
 	Stored               time.Time       `json:"stored,omitempty"`
 	Modified             *time.Time      `json:"modified,omitempty"`
 	DataType             *string         `json:"data_type,omitempty"`
 	DataText             *string         `json:"data_text,omitempty"`
+	NormalizedText       *string         `json:"normalized_text,omitempty"`
 	DataFile             *string         `json:"data_file,omitempty"` // must NOT be a pointer to an Item.dataFileName value (should be its own copy!)
 	DataHash             []byte          `json:"data_hash,omitempty"` // BLAKE3 hash of the contents of DataFile
 	Metadata             json.RawMessage `json:"metadata,omitempty"`  // JSON-encoded extra information
 	Location
 	Note               *string    `json:"note,omitempty"`
 	Starred            *int       `json:"starred,omitempty"`
+	Visibility         *string    `json:"visibility,omitempty"`
 	ThumbHash          []byte     `json:"thumb_hash,omitempty"`
 	OriginalIDHash     []byte     `json:"original_id_hash,omitempty"`
 	InitialContentHash []byte     `json:"initial_content_hash,omitempty"`
 	RetrievalKey       []byte     `json:"retrieval_key,omitempty"`
+	GlobalID           *string    `json:"global_id,omitempty"`
 	Hidden             *bool      `json:"hidden,omitempty"`
 	Deleted            *time.Time `json:"deleted,omitempty"`
 
@@ -665,6 +819,14 @@ func (ir ItemRow) timestampUnix() *int64 {
 	return &unix
 }
 
+func (ir ItemRow) originalTimestampUnix() *int64 {
+	if ir.OriginalTimestamp == nil {
+		return nil
+	}
+	unix := ir.OriginalTimestamp.UnixMilli()
+	return &unix
+}
+
 func (ir ItemRow) timespanUnix() *int64 {
 	if ir.Timespan == nil {
 		return nil
@@ -693,17 +855,18 @@ func scanItemRow(row sqlScanner, targetsAfterItemCols []any) (ItemRow, error) {
 	var ir ItemRow
 
 	var metadata, className *string
-	var ts, tspan, tframe, modified, deleted *int64 // will convert from Unix milli timestamp
-	var stored int64                                // will convert from Unix milli timestamp
+	var ts, tsMicros, origTS, tspan, tframe, modified, deleted *int64 // will convert from Unix milli timestamp
+	var stored int64                                                  // will convert from Unix milli timestamp
 
 	itemTargets := []any{&ir.ID, &ir.DataSourceID, &ir.ImportID, &ir.ModifiedImportID, &ir.AttributeID,
-		&ir.ClassificationID, &ir.OriginalID, &ir.OriginalLocation, &ir.IntermediateLocation, &ir.Filename,
-		&ts, &tspan, &tframe, &ir.TimeOffset, &ir.TimeUncertainty, &stored, &modified,
-		&ir.DataType, &ir.DataText, &ir.DataFile, &ir.DataHash,
+		&ir.ClassificationID, &ir.Category, &ir.OriginalID, &ir.OriginalLocation, &ir.IntermediateLocation, &ir.Filename,
+		&ts, &tsMicros, &origTS, &tspan, &tframe, &ir.TimeOffset, &ir.TimeUncertainty, &ir.Sequence,
+		&ir.SourceFile, &ir.SourceOffset, &stored, &modified,
+		&ir.DataType, &ir.DataText, &ir.NormalizedText, &ir.DataFile, &ir.DataHash,
 		&metadata, &ir.Location.Longitude, &ir.Location.Latitude, &ir.Location.Altitude,
 		&ir.Location.CoordinateSystem, &ir.Location.CoordinateUncertainty, &ir.Note, &ir.Starred,
-		&ir.ThumbHash, &ir.OriginalIDHash, &ir.InitialContentHash,
-		&ir.Hidden, &deleted,
+		&ir.Visibility, &ir.ThumbHash, &ir.OriginalIDHash, &ir.InitialContentHash, &ir.RetrievalKey,
+		&ir.GlobalID, &ir.Hidden, &deleted,
 		&ir.DataSourceName, &className}
 	targets := append(itemTargets, targetsAfterItemCols...)
 
@@ -718,8 +881,16 @@ func scanItemRow(row sqlScanner, targetsAfterItemCols []any) (ItemRow, error) {
 	ir.Classification = className
 	if ts != nil {
 		tsVal := time.UnixMilli(*ts)
+		// only trust the precise timestamp if it wasn't left behind by an update
+		if tsMicros != nil && time.UnixMicro(*tsMicros).UnixMilli() == *ts {
+			tsVal = time.UnixMicro(*tsMicros)
+		}
 		ir.Timestamp = &tsVal
 	}
+	if origTS != nil {
+		origTSVal := time.UnixMilli(*origTS)
+		ir.OriginalTimestamp = &origTSVal
+	}
 	if tspan != nil {
 		tspanVal := time.UnixMilli(*tspan)
 		ir.Timespan = &tspanVal
@@ -745,13 +916,14 @@ func scanItemRow(row sqlScanner, targetsAfterItemCols []any) (ItemRow, error) {
 }
 
 // used for selecting from the extended_items view, but "AS items"
-const itemDBColumns = `items.id, items.data_source_id, items.import_id, items.modified_import_id, items.attribute_id, items.classification_id,
+const itemDBColumns = `items.id, items.data_source_id, items.import_id, items.modified_import_id, items.attribute_id, items.classification_id, items.category,
 items.original_id, items.original_location, items.intermediate_location, items.filename,
-items.timestamp, items.timespan, items.timeframe, items.time_offset, items.time_uncertainty, items.stored, items.modified,
-items.data_type, items.data_text, items.data_file, items.data_hash, items.metadata,
+items.timestamp, items.timestamp_micros, items.original_timestamp, items.timespan, items.timeframe, items.time_offset, items.time_uncertainty, items.sequence,
+items.source_file, items.source_offset, items.stored, items.modified,
+items.data_type, items.data_text, items.normalized_text, items.data_file, items.data_hash, items.metadata,
 items.longitude, items.latitude, items.altitude, items.coordinate_system, items.coordinate_uncertainty,
-items.note, items.starred, items.thumb_hash, items.original_id_hash, items.initial_content_hash,
-items.hidden, items.deleted, data_source_name, classification_name`
+items.note, items.starred, items.visibility, items.thumb_hash, items.original_id_hash, items.initial_content_hash, items.retrieval_key,
+items.global_id, items.hidden, items.deleted, data_source_name, classification_name`
 
 // Location represents a precise coordinate on a planetary body.
 // By default, standard Earth GPS lon/lat coordinates are assumed.
@@ -0,0 +1,120 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ItemIDStrategy determines how items are identified across repos. Within a repo,
+// items are always identified by their integer row ID, but row IDs collide across
+// repos, which makes it hard to export items from one timeline and import them
+// into another (or sync them) without duplicating them.
+type ItemIDStrategy string
+
+const (
+	// ItemIDSequential identifies items by their row ID only. New items do not
+	// get a global ID, but items imported with one keep it. This is the default.
+	ItemIDSequential ItemIDStrategy = "sequential"
+
+	// ItemIDGlobal also assigns a globally-unique ID to each new item when it
+	// is stored. Global IDs are time-ordered UUIDs (version 7), so, like ULIDs,
+	// they sort in the order they were created.
+	ItemIDGlobal ItemIDStrategy = "global"
+)
+
+func (s ItemIDStrategy) validate() error {
+	switch s {
+	case ItemIDSequential, ItemIDGlobal:
+		return nil
+	}
+	return fmt.Errorf("unrecognized item ID strategy: %s", s)
+}
+
+// repoKeyItemIDStrategy is the key in the repo table for the item ID strategy.
+const repoKeyItemIDStrategy = "item_id_strategy"
+
+// ItemIDStrategy returns the repo's item ID strategy.
+func (tl *Timeline) ItemIDStrategy() ItemIDStrategy {
+	if tl.globalItemIDs.Load() {
+		return ItemIDGlobal
+	}
+	return ItemIDSequential
+}
+
+// SetItemIDStrategy changes the repo's item ID strategy for items stored from now on.
+// Existing items are not changed.
+func (tl *Timeline) SetItemIDStrategy(ctx context.Context, strategy ItemIDStrategy) error {
+	if err := tl.checkWritable("set item ID strategy"); err != nil {
+		return err
+	}
+
+	if err := strategy.validate(); err != nil {
+		return err
+	}
+
+	tl.dbMu.Lock()
+	_, err := tl.db.ExecContext(ctx, `INSERT INTO repo (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value=excluded.value`, repoKeyItemIDStrategy, strategy)
+	tl.dbMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("saving item ID strategy: %v", err)
+	}
+	tl.globalItemIDs.Store(strategy == ItemIDGlobal)
+
+	return nil
+}
+
+func loadItemIDStrategy(db *sql.DB) (ItemIDStrategy, error) {
+	var strategy ItemIDStrategy
+	err := db.QueryRow(`SELECT value FROM repo WHERE key=? LIMIT 1`, repoKeyItemIDStrategy).Scan(&strategy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ItemIDSequential, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("loading item ID strategy: %w", err)
+	}
+	if err := strategy.validate(); err != nil {
+		return "", err
+	}
+	return strategy, nil
+}
+
+// newGlobalItemID returns the global ID to store with a new item: the one it
+// already has, if any, or a new one if the repo assigns global IDs.
+func (tl *Timeline) newGlobalItemID(existing *string) *string {
+	if existing != nil || !tl.globalItemIDs.Load() {
+		return existing
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		// should only happen if the system's random source fails, in which case the
+		// item can still be stored; it just won't have a global ID
+		Log.Error("generating global item ID", zap.Error(err))
+		return nil
+	}
+	idStr := id.String()
+	return &idStr
+}
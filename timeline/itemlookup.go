@@ -0,0 +1,176 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrItemNotFound is returned when an item being looked up does not exist.
+var ErrItemNotFound = errors.New("item not found")
+
+// ItemByID loads the item with the given row ID, along with its owner entity
+// and the size of its content. If there is no such item, an error wrapping
+// ErrItemNotFound is returned.
+func (tl *Timeline) ItemByID(ctx context.Context, rowID int64) (*SearchResult, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	tx, err := tl.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	return tl.loadItemDetails(ctx, tx, rowID)
+}
+
+// ItemByOriginalID loads the item with the given original ID (the ID assigned by the
+// data source) from the named data source, along with its owner entity and the size of
+// its content. If accountID is nonzero, the item must have been imported with that
+// account. If there is no such item, an error wrapping ErrItemNotFound is returned.
+func (tl *Timeline) ItemByOriginalID(ctx context.Context, dataSourceName string, accountID int64, originalID string) (*SearchResult, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	tx, err := tl.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	ir, err := itemRowByOriginalID(ctx, tx, dataSourceName, accountID, originalID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", dataSourceName, originalID, err)
+	}
+
+	return tl.loadItemDetails(ctx, tx, ir.ID)
+}
+
+// ItemByGlobalID loads the item with the given global ID (see ItemIDStrategy), along with
+// its owner entity and the size of its content. If there is no such item, an error
+// wrapping ErrItemNotFound is returned.
+func (tl *Timeline) ItemByGlobalID(ctx context.Context, globalID string) (*SearchResult, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	tx, err := tl.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	ir, err := itemRowByGlobalID(ctx, tx, globalID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", globalID, err)
+	}
+
+	return tl.loadItemDetails(ctx, tx, ir.ID)
+}
+
+// loadItemDetails loads the item with the given row ID as a search result.
+func (tl *Timeline) loadItemDetails(ctx context.Context, tx *sql.Tx, rowID int64) (*SearchResult, error) {
+	sr, err := tl.loadRelatedItem(ctx, tx, nil, rowID)
+	if err != nil {
+		return nil, err
+	}
+	if sr.ID == 0 {
+		return nil, fmt.Errorf("item %d: %w", rowID, ErrItemNotFound)
+	}
+	tl.fillSize(sr)
+	return sr, nil
+}
+
+// itemRowByOriginalID loads the row of the item from the named data source with the
+// given original ID, optionally only if it was imported with the given account. It
+// returns ErrItemNotFound if there is no such item. It must be called inside a lock
+// on the database (such as Timeline.dbMu).
+func itemRowByOriginalID(ctx context.Context, tx *sql.Tx, dataSourceName string, accountID int64, originalID string) (ItemRow, error) {
+	q := `SELECT ` + itemDBColumns + `
+		FROM extended_items AS items
+		WHERE data_source_name=? AND original_id=?`
+	args := []any{dataSourceName, originalID}
+	if accountID != 0 {
+		q += ` AND import_id IN (SELECT id FROM imports WHERE account_id=?)`
+		args = append(args, accountID)
+	}
+	q += ` LIMIT 1`
+
+	ir, err := scanItemRow(tx.QueryRowContext(ctx, q, args...), nil)
+	if err != nil {
+		return ItemRow{}, err
+	}
+	if ir.ID == 0 {
+		return ItemRow{}, ErrItemNotFound
+	}
+	return ir, nil
+}
+
+// fillSize sets the size of the item's content on the search result:
+// the length of its text, or the size of its data file on disk.
+func (tl *Timeline) fillSize(sr *SearchResult) {
+	if sr.DataText != nil {
+		sr.Size = int64(len(*sr.DataText))
+	}
+	if sr.DataFile != nil {
+		info, err := os.Stat(filepath.Join(tl.Dir(), *sr.DataFile))
+		if err == nil {
+			sr.Size = info.Size()
+		}
+	}
+}
+
+// itemRowByContentHash loads the row of an item, from any data source, whose initial
+// content has the given hash. It returns ErrItemNotFound if there is no such item. It
+// must be called inside a lock on the database (such as Timeline.dbMu).
+func itemRowByContentHash(ctx context.Context, tx *sql.Tx, contentHash []byte) (ItemRow, error) {
+	ir, err := scanItemRow(tx.QueryRowContext(ctx, `SELECT `+itemDBColumns+`
+		FROM extended_items AS items
+		WHERE initial_content_hash=? AND deleted IS NULL
+		LIMIT 1`, contentHash), nil)
+	if err != nil {
+		return ItemRow{}, err
+	}
+	if ir.ID == 0 {
+		return ItemRow{}, ErrItemNotFound
+	}
+	return ir, nil
+}
+
+// itemRowByGlobalID loads the row of the item with the given global ID. It returns
+// ErrItemNotFound if there is no such item. It must be called inside a lock on the
+// database (such as Timeline.dbMu).
+func itemRowByGlobalID(ctx context.Context, tx *sql.Tx, globalID string) (ItemRow, error) {
+	ir, err := scanItemRow(tx.QueryRowContext(ctx, `SELECT `+itemDBColumns+`
+		FROM extended_items AS items
+		WHERE global_id=?
+		LIMIT 1`, globalID), nil)
+	if err != nil {
+		return ItemRow{}, err
+	}
+	if ir.ID == 0 {
+		return ItemRow{}, ErrItemNotFound
+	}
+	return ir, nil
+}
@@ -0,0 +1,78 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestItemLookups(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	importTestItems(t, tl, testMessage("msg1", ts), testFileItem("file1", ts.Add(time.Minute)))
+
+	msg, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "msg1")
+	if err != nil {
+		t.Fatalf("looking up by original ID: %v", err)
+	}
+	if msg.OriginalID == nil || *msg.OriginalID != "msg1" {
+		t.Errorf("wrong item: %+v", msg.ItemRow)
+	}
+	if want := int64(len("message msg1")); msg.Size != want {
+		t.Errorf("expected size %d, got %d", want, msg.Size)
+	}
+
+	byID, err := tl.ItemByID(ctx, msg.ID)
+	if err != nil {
+		t.Fatalf("looking up by ID: %v", err)
+	}
+	if byID.ID != msg.ID || byID.DataText == nil || *byID.DataText != "message msg1" {
+		t.Errorf("wrong item: %+v", byID.ItemRow)
+	}
+
+	file, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "file1")
+	if err != nil {
+		t.Fatalf("looking up file item: %v", err)
+	}
+	if file.DataFile == nil {
+		t.Fatal("expected item to have a data file")
+	}
+	if want := int64(len("binary contents of file1")); file.Size != want {
+		t.Errorf("expected data file size %d, got %d", want, file.Size)
+	}
+
+	// not found
+	if _, err := tl.ItemByID(ctx, 1<<40); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for unknown row ID, got %v", err)
+	}
+	if _, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "nope"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for unknown original ID, got %v", err)
+	}
+	if _, err := tl.ItemByOriginalID(ctx, "other_source", 0, "msg1"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for other data source, got %v", err)
+	}
+	if _, err := tl.ItemByOriginalID(ctx, testDataSourceName, 1<<40, "msg1"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for other account, got %v", err)
+	}
+}
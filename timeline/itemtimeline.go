@@ -0,0 +1,144 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RoleOwner is the role of an entity in items it owns, i.e. items it
+// authored or sent. Its other roles are the labels of the relations
+// between the item and the entity, such as "sent" or "depicts".
+const RoleOwner = "owner"
+
+// ItemTimelineParams configures which of an entity's items are returned.
+type ItemTimelineParams struct {
+	// If set, only items from these data sources are included.
+	DataSourceName []string `json:"data_source,omitempty"`
+
+	// Ordered by timestamp, ascending by default.
+	Sort SortDir `json:"sort,omitempty"`
+
+	Limit  int `json:"limit,omitempty"`  // number of items to include (-1 for no limit); default 1000
+	Offset int `json:"offset,omitempty"` // number of items to skip
+}
+
+// EntityItem is an item involving an entity, along with how it is involved.
+type EntityItem struct {
+	ItemRow
+
+	// How the entity is involved with the item: RoleOwner,
+	// or the labels of relations between them (sorted).
+	Roles []string `json:"roles"`
+}
+
+// ItemTimeline returns the items involving the entity, ordered by time: items it
+// owns (authored or sent), and items related to it, for example items that were
+// sent to it or that depict it. Deleted items are not included.
+func (tl *Timeline) ItemTimeline(ctx context.Context, entityID int64, params ItemTimelineParams) ([]EntityItem, error) {
+	sortDir := params.Sort
+	switch sortDir {
+	case "", SortNone:
+		sortDir = SortAsc
+	case SortAsc, SortDesc:
+	default:
+		return nil, fmt.Errorf("invalid sort direction: %s", sortDir)
+	}
+	if params.Limit == 0 {
+		params.Limit = 1000
+	}
+
+	// gather the entity's roles in all the items involving it in one pass, through
+	// any of its attributes, then join those to the items; an item may be involved
+	// in multiple ways (for example, a message sent by and to the same person)
+	q := `WITH entity_attrs AS (
+			SELECT attribute_id FROM entity_attributes WHERE entity_id=?
+		),
+		involved AS (
+			SELECT id AS item_id, ? AS role
+			FROM items
+			WHERE attribute_id IN entity_attrs
+			UNION
+			SELECT relationships.from_item_id, relations.label
+			FROM relationships
+			JOIN relations ON relations.id = relationships.relation_id
+			WHERE relationships.to_attribute_id IN entity_attrs
+				AND relationships.from_item_id IS NOT NULL
+			UNION
+			SELECT relationships.to_item_id, relations.label
+			FROM relationships
+			JOIN relations ON relations.id = relationships.relation_id
+			WHERE relationships.from_attribute_id IN entity_attrs
+				AND relationships.to_item_id IS NOT NULL
+		),
+		roles AS (
+			SELECT item_id, group_concat(role) AS roles
+			FROM involved
+			GROUP BY item_id
+		)
+		SELECT ` + itemDBColumns + `, roles.roles
+		FROM roles
+		JOIN extended_items AS items ON items.id = roles.item_id
+		WHERE items.deleted IS NULL`
+	args := []any{entityID, RoleOwner}
+
+	if len(params.DataSourceName) > 0 {
+		q += ` AND items.data_source_name IN (` + strings.Repeat("?, ", len(params.DataSourceName)-1) + `?)`
+		for _, name := range params.DataSourceName {
+			args = append(args, name)
+		}
+	}
+
+	q += ` ORDER BY items.timestamp ` + string(sortDir) + `, items.id ` + string(sortDir)
+	q += ` LIMIT ?`
+	args = append(args, params.Limit)
+	if params.Offset > 0 {
+		q += ` OFFSET ?`
+		args = append(args, params.Offset)
+	}
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying items involving entity: %v", err)
+	}
+	defer rows.Close()
+
+	var results []EntityItem
+	for rows.Next() {
+		var roles string
+		ir, err := scanItemRow(rows, []any{&roles})
+		if err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		result := EntityItem{ItemRow: ir, Roles: strings.Split(roles, ",")}
+		slices.Sort(result.Roles)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,134 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestItemTimeline(t *testing.T) {
+	otherSource := fmt.Sprintf("photos_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:            otherSource,
+		Title:           "Photos test source",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	alice := func() Entity {
+		return Entity{Name: "Alice", Attributes: []Attribute{{Name: AttributeEmail, Value: "alice@example.com", Identifying: true}}}
+	}
+	bob := func() Entity {
+		return Entity{Name: "Bob", Attributes: []Attribute{{Name: AttributeEmail, Value: "bob@example.com", Identifying: true}}}
+	}
+	importGraphs := func(dataSource string, graphs ...*Graph) {
+		t.Helper()
+		testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, g := range graphs {
+				itemChan <- g
+			}
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName: dataSource,
+			Filenames:      []string{"test"},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	ts := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	unrelated := testMessage("unrelated", ts)
+	unrelated.Owner = bob()
+	byAlice := testMessage("by_alice", ts.Add(time.Hour))
+	byAlice.Owner = alice()
+	toAlice := &Graph{Item: testMessage("to_alice", ts.Add(2*time.Hour))}
+	toAlice.Item.Owner = bob()
+	recipient := alice()
+	toAlice.ToEntity(RelSent, &recipient)
+	importGraphs(testDataSourceName, &Graph{Item: unrelated}, &Graph{Item: byAlice}, toAlice)
+
+	photo := &Graph{Item: testFileItem("photo_of_alice", ts.Add(3*time.Hour))}
+	depicted := alice()
+	photo.ToEntity(RelDepicts, &depicted)
+	noteToSelf := &Graph{Item: testMessage("note_to_self", ts.Add(4*time.Hour))}
+	noteToSelf.Item.Owner = alice()
+	self := alice()
+	noteToSelf.ToEntity(RelSent, &self)
+	importGraphs(otherSource, photo, noteToSelf)
+
+	aliceID := entityIDByName(t, tl, "Alice")
+
+	type result struct {
+		id    string
+		roles []string
+	}
+	timeline := func(params ItemTimelineParams) []result {
+		t.Helper()
+		items, err := tl.ItemTimeline(ctx, aliceID, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []result
+		for _, it := range items {
+			results = append(results, result{*it.OriginalID, it.Roles})
+		}
+		return results
+	}
+	ids := func(results []result) []string {
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.id)
+		}
+		return ids
+	}
+
+	all := timeline(ItemTimelineParams{})
+	if expected := []string{"by_alice", "to_alice", "photo_of_alice", "note_to_self"}; !slices.Equal(ids(all), expected) {
+		t.Fatalf("expected items %v, got %v", expected, ids(all))
+	}
+	for i, expected := range [][]string{
+		{RoleOwner},
+		{RelSent.Label},
+		{RelDepicts.Label},
+		{RoleOwner, RelSent.Label},
+	} {
+		if !slices.Equal(all[i].roles, expected) {
+			t.Errorf("item %s: expected roles %v, got %v", all[i].id, expected, all[i].roles)
+		}
+	}
+
+	if got := ids(timeline(ItemTimelineParams{DataSourceName: []string{otherSource}})); !slices.Equal(got, []string{"photo_of_alice", "note_to_self"}) {
+		t.Errorf("expected only items from %s, got %v", otherSource, got)
+	}
+	if got := ids(timeline(ItemTimelineParams{Sort: SortDesc, Limit: 2, Offset: 1})); !slices.Equal(got, []string{"photo_of_alice", "to_alice"}) {
+		t.Errorf("expected second page in descending order, got %v", got)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ItemVersion is a prior version of an item, from its edit history.
+type ItemVersion struct {
+	ID        int64           `json:"id"`
+	ItemID    int64           `json:"item_id"`
+	ImportID  *int64          `json:"import_id,omitempty"`
+	Replaced  time.Time       `json:"replaced"`
+	Timestamp *time.Time      `json:"timestamp,omitempty"`
+	DataType  *string         `json:"data_type,omitempty"`
+	DataText  *string         `json:"data_text,omitempty"`
+	DataFile  *string         `json:"data_file,omitempty"`
+	DataHash  []byte          `json:"data_hash,omitempty"`
+	Metadata  json.RawMessage `json:"metadata,omitempty"`
+}
+
+// isNewItemVersion returns true if the incoming item has different content than
+// the existing item row, meaning the data source is giving us a new version of
+// the item. Currently, only text content and metadata are compared; an item that
+// doesn't have content yet is simply being filled in, so that isn't a new version.
+func isNewItemVersion(it *Item, ir ItemRow) bool {
+	if !ir.hasContent() {
+		return false
+	}
+	if it.dataText != nil && (ir.DataText == nil || *it.dataText != *ir.DataText) {
+		return true
+	}
+	if len(it.Metadata) > 0 {
+		it.Metadata.Clean()
+		metadata, err := json.Marshal(it.Metadata)
+		if err == nil && !bytes.Equal(metadata, ir.Metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+// editHistoryUpdateOverrides returns the update policies to apply when replacing
+// an existing item with a new version of it. Data files are not replaced.
+func editHistoryUpdateOverrides(it *Item) map[string]fieldUpdatePolicy {
+	overrides := map[string]fieldUpdatePolicy{
+		"metadata": updatePolicyOverwriteExisting,
+	}
+	if it.dataText != nil {
+		overrides["data"] = updatePolicyOverwriteExisting
+	}
+	return overrides
+}
+
+// snapshotItemVersion copies the current content of the item row into its edit history.
+func snapshotItemVersion(ctx context.Context, tx *sql.Tx, itemRowID int64) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO item_versions (item_id, import_id, timestamp, data_type, data_text, data_file, data_hash, metadata)
+		SELECT id, COALESCE(modified_import_id, import_id), timestamp, data_type, data_text, data_file, data_hash, metadata
+		FROM items WHERE id=?`, itemRowID)
+	return err
+}
+
+// ItemHistory returns the prior versions of the given item, oldest first.
+func (tl *Timeline) ItemHistory(ctx context.Context, itemRowID int64) ([]ItemVersion, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+	return loadItemVersions(ctx, tl.db, itemRowID)
+}
+
+// loadItemVersions loads the edit history of an item. It must be called
+// inside a lock on the database (such as Timeline.dbMu).
+func loadItemVersions(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}, itemRowID int64) ([]ItemVersion, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, item_id, import_id, replaced, timestamp, data_type, data_text, data_file, data_hash, metadata
+		FROM item_versions
+		WHERE item_id=?
+		ORDER BY id`, itemRowID)
+	if err != nil {
+		return nil, fmt.Errorf("querying item history: %v", err)
+	}
+	defer rows.Close()
+
+	var versions []ItemVersion
+	for rows.Next() {
+		var v ItemVersion
+		var replaced int64
+		var ts *int64
+		var metadata *string
+		err := rows.Scan(&v.ID, &v.ItemID, &v.ImportID, &replaced, &ts,
+			&v.DataType, &v.DataText, &v.DataFile, &v.DataHash, &metadata)
+		if err != nil {
+			return nil, fmt.Errorf("scanning item version: %v", err)
+		}
+		v.Replaced = time.Unix(replaced, 0)
+		if ts != nil {
+			tsVal := time.UnixMilli(*ts)
+			v.Timestamp = &tsVal
+		}
+		if metadata != nil && *metadata != "" {
+			v.Metadata = json.RawMessage(*metadata)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item versions: %v", err)
+	}
+
+	return versions, nil
+}
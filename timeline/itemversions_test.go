@@ -0,0 +1,135 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestImportEditHistory(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2022, 3, 1, 9, 0, 0, 0, time.UTC)
+	versionedMessage := func(text string) *Item {
+		it := testMessage("edited", ts)
+		it.Content.Data = StringData(text)
+		return it
+	}
+
+	importVersion := func(text string) {
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			itemChan <- &Graph{Item: versionedMessage(text)}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{ImportEditHistory: true},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+	importVersion("first version")
+	importVersion("second version")
+
+	results, err := tl.Search(context.Background(), ItemSearchParams{WithHistory: true})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results.Items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(results.Items))
+	}
+	result := results.Items[0]
+	if result.DataText == nil || *result.DataText != "second version" {
+		t.Errorf("expected current version to be the second version, got %v", result.DataText)
+	}
+	if len(result.History) != 1 {
+		t.Fatalf("expected 1 prior version, got %d", len(result.History))
+	}
+	if prior := result.History[0]; prior.DataText == nil || *prior.DataText != "first version" {
+		t.Errorf("expected prior version to be the first version, got %v", prior.DataText)
+	}
+
+	history, err := tl.ItemHistory(context.Background(), result.ID)
+	if err != nil {
+		t.Fatalf("loading item history: %v", err)
+	}
+	if len(history) != 1 || history[0].ItemID != result.ID {
+		t.Errorf("unexpected item history: %+v", history)
+	}
+
+	// importing the same version again should not add to the history
+	importVersion("second version")
+	history, err = tl.ItemHistory(context.Background(), result.ID)
+	if err != nil {
+		t.Fatalf("loading item history: %v", err)
+	}
+	if len(history) != 1 {
+		t.Errorf("expected history to be unchanged, got %d versions", len(history))
+	}
+}
+
+func TestImportEditHistoryKeepsManualModifications(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2022, 3, 1, 9, 0, 0, 0, time.UTC)
+	importVersion := func(text string) {
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			it := testMessage("edited", ts)
+			it.Content.Data = StringData(text)
+			itemChan <- &Graph{Item: it}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{ImportEditHistory: true},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+	importVersion("first version")
+
+	// simulate the user editing the item
+	if _, err := tl.db.Exec(`UPDATE items SET data_text='user edit', modified=unixepoch()`); err != nil {
+		t.Fatalf("modifying item: %v", err)
+	}
+
+	importVersion("second version")
+
+	var itemID int64
+	var text string
+	if err := tl.db.QueryRow(`SELECT id, data_text FROM items LIMIT 1`).Scan(&itemID, &text); err != nil {
+		t.Fatalf("querying item: %v", err)
+	}
+	if text != "user edit" {
+		t.Errorf("expected manual modification to be kept, got %q", text)
+	}
+	history, err := tl.ItemHistory(context.Background(), itemID)
+	if err != nil {
+		t.Fatalf("loading item history: %v", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("expected no prior versions, got %d", len(history))
+	}
+}
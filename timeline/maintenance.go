@@ -190,15 +190,15 @@ func (tl *Timeline) deleteDataInItemRows(ctx context.Context, tx *sql.Tx, rowIDs
 	// Keep the row hashes to remember the signature(s) of what was deleted.
 	sb.WriteString(`UPDATE items
 		SET data_source_id=NULL, import_id=NULL, modified_import_id=NULL, attribute_id=NULL,
-			classification_id=NULL, original_id=NULL, original_location=NULL, intermediate_location=NULL,
-			filename=NULL, timestamp=NULL, timespan=NULL, timeframe=NULL, time_offset=NULL, time_uncertainty=NULL,
-			stored=0, modified=NULL, data_type=NULL, data_text=NULL, data_file=NULL, data_hash=NULL,
-			metadata=NULL, longitude=NULL, latitude=NULL, altitude=NULL, coordinate_system=NULL,
+			classification_id=NULL, original_id=NULL, account_id=NULL, original_location=NULL, intermediate_location=NULL,
+			filename=NULL, timestamp=NULL, timestamp_micros=NULL, original_timestamp=NULL, timespan=NULL, timeframe=NULL, time_offset=NULL, time_uncertainty=NULL,
+			source_file=NULL, source_offset=NULL, stored=0, modified=NULL, data_type=NULL, data_text=NULL, normalized_text=NULL, data_file=NULL, data_hash=NULL,
+			data_length=NULL, metadata=NULL, longitude=NULL, latitude=NULL, altitude=NULL, coordinate_system=NULL,
 			coordinate_uncertainty=NULL, `)
 	if !preserveUserNotes {
 		sb.WriteString("note=NULL, ")
 	}
-	sb.WriteString("starred=NULL, hidden=NULL, deleted=1 WHERE id IN ")
+	sb.WriteString("starred=NULL, visibility=NULL, hidden=NULL, deleted=1 WHERE id IN ")
 
 	// write the row IDs into the WHERE clause
 	array, args := sqlArray(rowIDs)
@@ -209,11 +209,22 @@ func (tl *Timeline) deleteDataInItemRows(ctx context.Context, tx *sql.Tx, rowIDs
 		return fmt.Errorf("erasing item rows: %v", err)
 	}
 
+	_, err = tx.ExecContext(ctx, `DELETE FROM item_geometry WHERE item_id IN `+array, args...)
+	if err != nil {
+		return fmt.Errorf("erasing item geometry: %v", err)
+	}
+
 	return nil
 }
 
 func (tl *Timeline) deleteDataFiles(ctx context.Context, logger *zap.Logger, dataFilesToDelete []string) (int, error) {
 	for _, dataFile := range dataFilesToDelete {
+		// files referenced in place outside the repo aren't ours to delete
+		if isExternalDataFile(dataFile) {
+			logger.Debug("not deleting data file referenced outside the repo", zap.String("data_file", dataFile))
+			continue
+		}
+
 		dataFileFullPath := tl.FullPath(dataFile)
 		err := os.Remove(dataFileFullPath)
 		if errors.Is(err, fs.ErrNotExist) {
@@ -227,34 +238,54 @@ func (tl *Timeline) deleteDataFiles(ctx context.Context, logger *zap.Logger, dat
 			continue
 		}
 
-		// if parent dir is empty, delete it too (go up to 2 parent dirs = data source folder then month)
-		parentDir := dataFileFullPath // incorrect for now, will be Dir()'ed at beginning of loop iteration
-		for i := 0; i < 2; i++ {
-			parentDir = filepath.Dir(dataFileFullPath)
+		// forget how it was compressed, in case the path gets used again
+		// (callers hold the DB lock while deleting data files)
+		if _, err := tl.db.ExecContext(ctx, `DELETE FROM compressed_data_files WHERE data_file=?`, dataFile); err != nil {
+			logger.Error("could not clear compression of deleted data file",
+				zap.String("data_file", dataFile),
+				zap.Error(err))
+		}
+		if err := tl.deleteDerivedDataFiles(ctx, tl.db, dataFile); err != nil {
+			logger.Error("could not delete files derived from deleted data file",
+				zap.String("data_file", dataFile),
+				zap.Error(err))
+		}
 
-			isEmpty, _, err := directoryEmpty(parentDir, true)
-			if err != nil {
-				// this is optional but is nice for staying tidy; don't break here
-				logger.Error("checking if parent dir is empty",
-					zap.String("dir", parentDir),
-					zap.Error(err))
-				break
-			}
+		// if parent dirs are empty, delete them too
+		tl.removeEmptyDataFileDirs(logger, dataFileFullPath)
+	}
 
-			if isEmpty {
-				logger.Debug("cleaning up empty parent directory",
-					zap.String("parent_dir", parentDir),
-					zap.Error(err))
+	return len(dataFilesToDelete), nil
+}
 
-				if err := os.Remove(parentDir); err != nil {
-					logger.Error("removing empty parent directory",
-						zap.String("dir", parentDir),
-						zap.Error(err))
-					break
-				}
-			}
+// removeEmptyDataFileDirs removes the parent directories of the given data file path
+// for as long as they are empty, stopping at the data folder. This takes care of
+// shard folders as well as the data source and month folders.
+func (tl *Timeline) removeEmptyDataFileDirs(logger *zap.Logger, dataFileFullPath string) {
+	dataRoot := tl.FullPath(DataFolderName)
+	parentDir := filepath.Dir(dataFileFullPath)
+	for parentDir != dataRoot && strings.HasPrefix(parentDir, dataRoot+string(filepath.Separator)) {
+		isEmpty, _, err := directoryEmpty(parentDir, true)
+		if err != nil {
+			// this is optional but is nice for staying tidy; don't break here
+			logger.Error("checking if parent dir is empty",
+				zap.String("dir", parentDir),
+				zap.Error(err))
+			return
+		}
+		if !isEmpty {
+			return
 		}
-	}
 
-	return len(dataFilesToDelete), nil
+		logger.Debug("cleaning up empty parent directory", zap.String("parent_dir", parentDir))
+
+		if err := os.Remove(parentDir); err != nil {
+			logger.Error("removing empty parent directory",
+				zap.String("dir", parentDir),
+				zap.Error(err))
+			return
+		}
+
+		parentDir = filepath.Dir(parentDir)
+	}
 }
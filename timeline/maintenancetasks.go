@@ -0,0 +1,261 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MaintenanceTask is an operation that can be run periodically on an open
+// timeline (see Timeline.StartMaintenance).
+type MaintenanceTask struct {
+	// The unique name of the task, by which it is scheduled.
+	Name string
+
+	// Run performs the task. It should return promptly when
+	// ctx is canceled.
+	Run func(ctx context.Context, tl *Timeline) error
+}
+
+var (
+	maintenanceTasks   = make(map[string]MaintenanceTask)
+	maintenanceTasksMu sync.RWMutex
+)
+
+// RegisterMaintenanceTask registers a task so that it can be scheduled
+// by name. The built-in tasks are:
+//
+//   - "erase_deleted_items": erases items whose retention period has passed
+//   - "check_integrity": verifies the checksums of all data files
+//   - "optimize_database": lets the database update its query planner statistics
+//   - "vacuum_database": rebuilds the database file to reclaim unused space
+func RegisterMaintenanceTask(task MaintenanceTask) error {
+	if task.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if task.Run == nil {
+		return fmt.Errorf("maintenance task %s: missing Run function", task.Name)
+	}
+	maintenanceTasksMu.Lock()
+	defer maintenanceTasksMu.Unlock()
+	if _, ok := maintenanceTasks[task.Name]; ok {
+		return fmt.Errorf("maintenance task already registered: %s", task.Name)
+	}
+	maintenanceTasks[task.Name] = task
+	return nil
+}
+
+// MaintenanceSchedule configures which maintenance tasks run, and how often.
+type MaintenanceSchedule struct {
+	// How often to run each task, keyed by task name. The first run
+	// is one interval after the schedule is started.
+	Intervals map[string]time.Duration `json:"intervals"`
+
+	// Each wait is randomly lengthened or shortened by up to this
+	// fraction of its interval, so that tasks (of the same or other
+	// timelines) don't keep running at the same time. Must be
+	// between 0 and 1. Default: 0.1.
+	Jitter *float64 `json:"jitter,omitempty"`
+}
+
+func (ms MaintenanceSchedule) validate() error {
+	if ms.Jitter != nil && (*ms.Jitter < 0 || *ms.Jitter > 1) {
+		return fmt.Errorf("jitter must be between 0 and 1: %f", *ms.Jitter)
+	}
+	maintenanceTasksMu.RLock()
+	defer maintenanceTasksMu.RUnlock()
+	for name, interval := range ms.Intervals {
+		if _, ok := maintenanceTasks[name]; !ok {
+			return fmt.Errorf("unknown maintenance task: %s", name)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("maintenance task %s: interval must be positive: %s", name, interval)
+		}
+	}
+	return nil
+}
+
+// StartMaintenance runs the scheduled maintenance tasks periodically until
+// StopMaintenance is called or the timeline is closed. A task's run is
+// skipped while imports are running, since most tasks are slow and would
+// contend with the import for the database. Panics in tasks are recovered.
+// Any previously started schedule is stopped first.
+func (tl *Timeline) StartMaintenance(schedule MaintenanceSchedule) error {
+	if err := tl.checkWritable("run maintenance"); err != nil {
+		return err
+	}
+	if err := schedule.validate(); err != nil {
+		return err
+	}
+	jitter := 0.1
+	if schedule.Jitter != nil {
+		jitter = *schedule.Jitter
+	}
+
+	tl.StopMaintenance()
+
+	tl.maintenanceMu.Lock()
+	defer tl.maintenanceMu.Unlock()
+
+	ctx, cancel := context.WithCancel(tl.ctx)
+	tl.maintenanceCancel = cancel
+
+	logger := Log.Named("maintenance")
+
+	maintenanceTasksMu.RLock()
+	defer maintenanceTasksMu.RUnlock()
+	for name, interval := range schedule.Intervals {
+		task := maintenanceTasks[name]
+		tl.maintenanceWG.Add(1)
+		go func() {
+			defer tl.maintenanceWG.Done()
+			tl.runMaintenanceTask(ctx, logger.With(zap.String("task", task.Name)), task, interval, jitter)
+		}()
+	}
+
+	return nil
+}
+
+// StopMaintenance stops running maintenance tasks, if started, and
+// waits for any that are running to return.
+func (tl *Timeline) StopMaintenance() {
+	tl.maintenanceMu.Lock()
+	defer tl.maintenanceMu.Unlock()
+	if tl.maintenanceCancel != nil {
+		tl.maintenanceCancel()
+		tl.maintenanceCancel = nil
+	}
+	tl.maintenanceWG.Wait()
+}
+
+// Vacuum rebuilds the database file to reclaim unused space. It is also
+// run periodically by the vacuum_database maintenance task.
+func (tl *Timeline) Vacuum(ctx context.Context) error {
+	if err := tl.checkWritable("vacuum database"); err != nil {
+		return err
+	}
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+	_, err := tl.db.ExecContext(ctx, "VACUUM")
+	return err
+}
+
+func (tl *Timeline) runMaintenanceTask(ctx context.Context, logger *zap.Logger, task MaintenanceTask, interval time.Duration, jitter float64) {
+	timer := time.NewTimer(jitterDuration(interval, jitter))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if tl.importsRunning() {
+			logger.Debug("skipping maintenance task while imports are running")
+		} else {
+			start := time.Now()
+			err := runMaintenanceTaskOnce(ctx, tl, task)
+			if err != nil {
+				logger.Error("maintenance task failed", zap.Duration("duration", time.Since(start)), zap.Error(err))
+			} else {
+				logger.Info("maintenance task succeeded", zap.Duration("duration", time.Since(start)))
+			}
+		}
+
+		timer.Reset(jitterDuration(interval, jitter))
+	}
+}
+
+// runMaintenanceTaskOnce runs the task, converting a panic into an error.
+func runMaintenanceTaskOnce(ctx context.Context, tl *Timeline, task MaintenanceTask) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return task.Run(ctx, tl)
+}
+
+// jitterDuration returns d randomly lengthened or shortened by up to
+// the fraction jitter of it.
+func jitterDuration(d time.Duration, jitter float64) time.Duration {
+	maxJitter := int64(float64(d) * jitter)
+	if maxJitter <= 0 {
+		return d
+	}
+	return d + time.Duration(mathrand.Int63n(2*maxJitter+1)-maxJitter)
+}
+
+// importsRunning returns true if any import is running.
+func (tl *Timeline) importsRunning() bool {
+	tl.importJobsMu.Lock()
+	defer tl.importJobsMu.Unlock()
+	return len(tl.activeImports) > 0
+}
+
+func init() {
+	for _, task := range []MaintenanceTask{
+		{
+			Name: "erase_deleted_items",
+			Run: func(ctx context.Context, tl *Timeline) error {
+				return tl.deleteExpiredItems(ctx, Log.Named("maintenance"))
+			},
+		},
+		{
+			Name: "check_integrity",
+			Run: func(ctx context.Context, tl *Timeline) error {
+				result, err := tl.CheckIntegrity(ctx, IntegrityCheckOptions{})
+				if err != nil {
+					return err
+				}
+				if len(result.Problems) > 0 {
+					return fmt.Errorf("integrity check %d found %d problem(s)", result.CheckID, len(result.Problems))
+				}
+				return nil
+			},
+		},
+		{
+			Name: "optimize_database",
+			Run: func(ctx context.Context, tl *Timeline) error {
+				tl.dbMu.Lock()
+				defer tl.dbMu.Unlock()
+				_, err := tl.db.ExecContext(ctx, "PRAGMA optimize")
+				return err
+			},
+		},
+		{
+			Name: "vacuum_database",
+			Run: func(ctx context.Context, tl *Timeline) error {
+				return tl.Vacuum(ctx)
+			},
+		},
+	} {
+		if err := RegisterMaintenanceTask(task); err != nil {
+			panic(err)
+		}
+	}
+}
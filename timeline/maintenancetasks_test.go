@@ -0,0 +1,117 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceScheduler(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// tasks can't be unregistered, so use unique names in case the test runs more than once
+	ticksTask := fmt.Sprintf("test_ticks_%d", time.Now().UnixNano())
+	panicsTask := fmt.Sprintf("test_panics_%d", time.Now().UnixNano())
+
+	ran := make(chan struct{}, 10)
+	if err := RegisterMaintenanceTask(MaintenanceTask{
+		Name: ticksTask,
+		Run: func(_ context.Context, _ *Timeline) error {
+			select {
+			case ran <- struct{}{}:
+			default:
+			}
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMaintenanceTask(MaintenanceTask{
+		Name: panicsTask,
+		Run:  func(_ context.Context, _ *Timeline) error { panic("oops") },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMaintenanceTask(MaintenanceTask{
+		Name: ticksTask,
+		Run:  func(_ context.Context, _ *Timeline) error { return nil },
+	}); err == nil {
+		t.Error("expected error registering a duplicate task name")
+	}
+
+	if err := tl.StartMaintenance(MaintenanceSchedule{
+		Intervals: map[string]time.Duration{"no_such_task": time.Millisecond},
+	}); err == nil {
+		t.Error("expected error scheduling an unknown task")
+	}
+
+	err := tl.StartMaintenance(MaintenanceSchedule{
+		Intervals: map[string]time.Duration{
+			ticksTask:  10 * time.Millisecond,
+			panicsTask: 5 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the task should keep firing, even though the other one panics
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("task ran %d times; expected it to keep running", i)
+		}
+	}
+
+	tl.StopMaintenance()
+	for len(ran) > 0 {
+		<-ran
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(ran) > 0 {
+		t.Error("task ran after maintenance was stopped")
+	}
+
+	// skipped while an import is running
+	done, err := tl.trackImport(testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tl.StartMaintenance(MaintenanceSchedule{
+		Intervals: map[string]time.Duration{ticksTask: 5 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(ran) > 0 {
+		t.Error("task ran while an import was running")
+	}
+	done()
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Error("task did not run after the import finished")
+	}
+	tl.StopMaintenance()
+}
@@ -0,0 +1,196 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// downloadBufferBytes is roughly how much memory a data
+	// file download uses for buffering while it is in flight.
+	downloadBufferBytes = 32 * 1024
+
+	// graphNodeBytes is a rough estimate of the memory used by an item or
+	// entity in a graph, not counting its strings and metadata.
+	graphNodeBytes = 512
+)
+
+// memoryBudget tracks the estimated in-memory footprint of an import: the graphs
+// that are queued or being processed, and the buffers of in-flight downloads.
+// A nil memoryBudget is unlimited; all its methods are no-ops.
+type memoryBudget struct {
+	limit int64
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	used      int64
+	downloads int
+}
+
+// newMemoryBudget returns a budget of limit bytes, or nil if limit is not positive.
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	mb := &memoryBudget{limit: limit}
+	mb.cond = sync.NewCond(&mb.mu)
+	return mb
+}
+
+// add tracks n more bytes. It never blocks, since the bytes are already in memory.
+func (mb *memoryBudget) add(n int64) {
+	if mb == nil {
+		return
+	}
+	mb.mu.Lock()
+	mb.used += n
+	mb.mu.Unlock()
+}
+
+// release stops tracking n bytes.
+func (mb *memoryBudget) release(n int64) {
+	if mb == nil {
+		return
+	}
+	mb.mu.Lock()
+	mb.used -= n
+	mb.cond.Broadcast()
+	mb.mu.Unlock()
+}
+
+// nearlyFull returns true if the footprint is approaching the limit,
+// at which point batches should be processed early.
+func (mb *memoryBudget) nearlyFull() bool {
+	if mb == nil {
+		return false
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.used >= mb.limit/4*3
+}
+
+// inUse returns the tracked footprint and the limit.
+func (mb *memoryBudget) inUse() (int64, int64) {
+	if mb == nil {
+		return 0, 0
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.used, mb.limit
+}
+
+// waitBelowLimit blocks while the footprint is at or over the limit. All the
+// bytes over the limit belong to batches or downloads in flight, which release
+// them when they are done, so this doesn't block forever.
+func (mb *memoryBudget) waitBelowLimit(ctx context.Context) error {
+	if mb == nil {
+		return nil
+	}
+	return mb.wait(ctx, func() bool { return mb.used < mb.limit })
+}
+
+// acquireDownload blocks until there is room for another download's buffer,
+// then tracks it. One download is always allowed, so that a budget smaller
+// than a single buffer can still make progress.
+func (mb *memoryBudget) acquireDownload(ctx context.Context) error {
+	if mb == nil {
+		return nil
+	}
+	err := mb.wait(ctx, func() bool {
+		return mb.downloads == 0 || mb.used+downloadBufferBytes <= mb.limit
+	})
+	if err != nil {
+		return err
+	}
+	mb.mu.Lock()
+	mb.used += downloadBufferBytes
+	mb.downloads++
+	mb.mu.Unlock()
+	return nil
+}
+
+// releaseDownload stops tracking a download's buffer.
+func (mb *memoryBudget) releaseDownload() {
+	if mb == nil {
+		return
+	}
+	mb.mu.Lock()
+	mb.used -= downloadBufferBytes
+	mb.downloads--
+	mb.cond.Broadcast()
+	mb.mu.Unlock()
+}
+
+// wait blocks until ready returns true or ctx is canceled. ready is
+// called with mb.mu locked.
+func (mb *memoryBudget) wait(ctx context.Context, ready func() bool) error {
+	stop := context.AfterFunc(ctx, func() {
+		mb.mu.Lock()
+		mb.cond.Broadcast()
+		mb.mu.Unlock()
+	})
+	defer stop()
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for !ready() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		mb.cond.Wait()
+	}
+	return nil
+}
+
+// memSize returns a rough estimate of the number of bytes the graph
+// occupies in memory. Item data is not counted, since it is streamed.
+func (g *Graph) memSize() int64 {
+	return g.recursiveMemSize(make(map[*Graph]struct{}))
+}
+
+func (g *Graph) recursiveMemSize(visited map[*Graph]struct{}) int64 {
+	if g == nil {
+		return 0
+	}
+	if _, ok := visited[g]; ok {
+		return 0
+	}
+	visited[g] = struct{}{}
+
+	size := int64(graphNodeBytes)
+	if it := g.Item; it != nil {
+		size += int64(len(it.ID) + len(it.OriginalLocation) + len(it.IntermediateLocation) + len(it.Content.Filename))
+		for k, v := range it.Metadata {
+			size += int64(len(k)) + 16
+			switch val := v.(type) {
+			case string:
+				size += int64(len(val))
+			case []byte:
+				size += int64(len(val))
+			}
+		}
+	}
+	for _, edge := range g.Edges {
+		size += edge.From.recursiveMemSize(visited)
+		size += edge.To.recursiveMemSize(visited)
+	}
+	return size
+}
@@ -0,0 +1,122 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudgetFlushesEarly(t *testing.T) {
+	const itemCount = 30
+
+	run := func(budget int64) (progressCalls int64) {
+		t.Helper()
+		tl := newTestTimeline(t)
+
+		testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			ts := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i := 0; i < itemCount; i++ {
+				it := testMessage(fmt.Sprintf("item%d", i), ts.Add(time.Duration(i)*time.Minute))
+				it.Metadata = Metadata{"Padding": strings.Repeat("x", 2048)}
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+
+		var maxMemory atomic.Int64
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{MemoryBudgetBytes: budget},
+			ProgressFunc: func(st ImportStatus) {
+				atomic.AddInt64(&progressCalls, 1)
+				if st.MemoryBytes > maxMemory.Load() {
+					maxMemory.Store(st.MemoryBytes)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatalf("budget %d: import failed: %v", budget, err)
+		}
+
+		var count int
+		if err := tl.db.QueryRow(`SELECT count() FROM items WHERE original_id LIKE 'item%'`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != itemCount {
+			t.Errorf("budget %d: expected %d items to be stored, got %d", budget, itemCount, count)
+		}
+		if budget > 0 && maxMemory.Load() == 0 {
+			t.Errorf("budget %d: expected memory footprint to be reported", budget)
+		}
+		return progressCalls
+	}
+
+	unbounded := run(0)
+	bounded := run(8 * 1024)
+
+	// batches are reported as they are processed; an unbounded import of so few
+	// items processes them in at most one batch per worker, but a tight budget
+	// forces many smaller batches
+	if unbounded > workers {
+		t.Errorf("expected at most %d batches without a budget, got %d", workers, unbounded)
+	}
+	if bounded <= unbounded || bounded < itemCount/3 {
+		t.Errorf("expected tight budget to flush batches early (got %d batches, vs. %d without budget)", bounded, unbounded)
+	}
+}
+
+func TestMemoryBudgetThrottlesDownloads(t *testing.T) {
+	mb := newMemoryBudget(downloadBufferBytes + 1)
+	ctx := context.Background()
+
+	if err := mb.acquireDownload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		if err := mb.acquireDownload(ctx); err == nil {
+			close(acquired)
+		}
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected second download to wait for memory")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mb.releaseDownload()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("expected second download to proceed after memory was freed")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := mb.acquireDownload(canceled); err == nil {
+		t.Error("expected canceled context to stop waiting")
+	}
+}
@@ -0,0 +1,140 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// MissingReferencePolicy specifies what to do when a relationship refers to
+// an item that is given only by its ID (no content), and which is not in the
+// timeline (yet). For example, a message may be a reply to a message that was
+// deleted on the device, or that will be imported later.
+type MissingReferencePolicy string
+
+const (
+	// MissingReferencesKeep stores the referenced item as-is. Unless it has
+	// a retrieval key, it is deleted along with other empty items at the end
+	// of the import. This is the default.
+	MissingReferencesKeep MissingReferencePolicy = "keep"
+
+	// MissingReferencesDrop skips the relationship altogether.
+	MissingReferencesDrop MissingReferencePolicy = "drop"
+
+	// MissingReferencesPlaceholder stores the referenced item as a placeholder
+	// that is pending completion: when the item is imported later, the placeholder
+	// is filled in, and the relationship points to the complete item.
+	MissingReferencesPlaceholder MissingReferencePolicy = "placeholder"
+)
+
+func (mrp MissingReferencePolicy) validate() error {
+	switch mrp {
+	case "", MissingReferencesKeep, MissingReferencesDrop, MissingReferencesPlaceholder:
+		return nil
+	}
+	return fmt.Errorf("unrecognized missing reference policy: %s", mrp)
+}
+
+// applyMissingReferencePolicy applies the configured missing reference policy to the
+// connected node of a relationship. It returns true if the relationship should be dropped.
+func (p *processor) applyMissingReferencePolicy(ctx context.Context, tx *sql.Tx, connected *Graph) (bool, error) {
+	it := connected.Item
+	if it == nil || it.HasContent() || len(it.Retrieval.key) > 0 {
+		return false, nil
+	}
+
+	// references to items that are expected to arrive later, such as the
+	// parents of replies, are resolved with placeholders unless dropped
+	policy := p.params.ProcessingOptions.MissingReferences
+	if it.forwardRef && policy != MissingReferencesDrop {
+		policy = MissingReferencesPlaceholder
+	}
+	if policy == "" || policy == MissingReferencesKeep {
+		return false, nil
+	}
+
+	// an item without an ID can't be found again later, so
+	// there's nothing to complete; it's only a dangling reference
+	if it.ID == "" {
+		return policy == MissingReferencesDrop, nil
+	}
+
+	_, err := itemRowByOriginalID(ctx, tx, p.params.DataSourceName, 0, it.ID)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrItemNotFound) {
+		return false, fmt.Errorf("looking up referenced item: %v", err)
+	}
+
+	switch policy {
+	case MissingReferencesDrop:
+		p.log.Debug("dropping relationship to missing item", zap.String("item_original_id", it.ID))
+		return true, nil
+	case MissingReferencesPlaceholder:
+		// the retrieval key keeps the empty row from being deleted at the end of the import
+		it.Retrieval.SetKey(p.params.DataSourceName + ":" + it.ID)
+	}
+	return false, nil
+}
+
+// ItemsPendingCompletion returns the items that are known to exist, but whose
+// content hasn't been imported yet; i.e. items with a retrieval key but no content.
+// If dataSourceName is not empty, only items from that data source are returned.
+func (tl *Timeline) ItemsPendingCompletion(ctx context.Context, dataSourceName string) ([]ItemRow, error) {
+	q := `SELECT ` + itemDBColumns + `
+		FROM extended_items AS items
+		WHERE retrieval_key IS NOT NULL
+			AND ` + itemHasNoContent("items") + `
+			AND deleted IS NULL`
+	var args []any
+	if dataSourceName != "" {
+		q += ` AND data_source_name=?`
+		args = append(args, dataSourceName)
+	}
+	q += ` ORDER BY id`
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying items pending completion: %v", err)
+	}
+	defer rows.Close()
+
+	var results []ItemRow
+	for rows.Next() {
+		ir, err := scanItemRow(rows, nil)
+		if err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		results = append(results, ir)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return results, nil
+}
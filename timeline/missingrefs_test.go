@@ -0,0 +1,123 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMissingReferencePlaceholderIsCompletedLater(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	importWith := func(g *Graph) {
+		t.Helper()
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			itemChan <- g
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{MissingReferences: MissingReferencesPlaceholder, Force: true},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	// the reply refers to a message that hasn't been imported yet
+	importWith(&Graph{Item: testMessage("reply", ts), Edges: []Relationship{
+		{Relation: RelReply, To: &Graph{Item: &Item{ID: "original"}}},
+	}})
+
+	pending, err := tl.ItemsPendingCompletion(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].OriginalID == nil || *pending[0].OriginalID != "original" {
+		t.Fatalf("expected placeholder to be pending completion, got %+v", pending)
+	}
+	placeholderID := pending[0].ID
+
+	var toItemID int64
+	err = tl.db.QueryRow(`SELECT to_item_id FROM relationships WHERE from_item_id=(SELECT id FROM items WHERE original_id='reply')`).Scan(&toItemID)
+	if err != nil {
+		t.Fatalf("querying relationship: %v", err)
+	}
+	if toItemID != placeholderID {
+		t.Errorf("expected relationship to point to placeholder %d, got %d", placeholderID, toItemID)
+	}
+
+	// now the referenced message shows up
+	importWith(&Graph{Item: testMessage("original", ts.Add(-time.Hour))})
+
+	pending, err = tl.ItemsPendingCompletion(ctx, testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no items pending completion, got %+v", pending)
+	}
+
+	completed, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "original")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed.ID != placeholderID {
+		t.Errorf("expected placeholder row %d to be completed, got row %d", placeholderID, completed.ID)
+	}
+	if completed.DataText == nil || *completed.DataText != "message original" {
+		t.Errorf("expected placeholder to be filled in, got text %v", completed.DataText)
+	}
+}
+
+func TestMissingReferenceDrop(t *testing.T) {
+	tl := newTestTimeline(t)
+	ts := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("reply", ts), Edges: []Relationship{
+			{Relation: RelReply, To: &Graph{Item: &Item{ID: "gone"}}},
+		}}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{MissingReferences: MissingReferencesDrop},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	var items, relationships int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE original_id='gone'`).Scan(&items); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.db.QueryRow(`SELECT count() FROM relationships`).Scan(&relationships); err != nil {
+		t.Fatal(err)
+	}
+	if items != 0 || relationships != 0 {
+		t.Errorf("expected reference to be dropped, got %d items and %d relationships", items, relationships)
+	}
+}
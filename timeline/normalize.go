@@ -0,0 +1,84 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TextNormalization configures how the text of items is normalized for the
+// purposes of deduplication and searching. Text that is equivalent according
+// to these rules is treated as equal, even if it is encoded differently (for
+// example, precomposed vs. combining accents, or full-width characters). The
+// original text of the item is always stored verbatim.
+type TextNormalization struct {
+	// The Unicode normalization form: "NFC" (the default) or "NFKC". NFKC
+	// also unifies compatibility characters, such as full-width letters
+	// and ligatures, with their canonical equivalents.
+	Form string `json:"form,omitempty"`
+
+	// If true, letter case is ignored.
+	FoldCase bool `json:"fold_case,omitempty"`
+
+	// If true, diacritics (accents and other marks) are removed,
+	// so that "café" and "cafe" are equal.
+	FoldDiacritics bool `json:"fold_diacritics,omitempty"`
+}
+
+func (tn TextNormalization) form() (norm.Form, error) {
+	switch strings.ToUpper(tn.Form) {
+	case "", "NFC":
+		return norm.NFC, nil
+	case "NFKC":
+		return norm.NFKC, nil
+	}
+	return 0, fmt.Errorf("unsupported normalization form: %s (must be NFC or NFKC)", tn.Form)
+}
+
+func (tn TextNormalization) validate() error {
+	_, err := tn.form()
+	return err
+}
+
+// Normalize returns s normalized according to tn.
+func (tn TextNormalization) Normalize(s string) (string, error) {
+	form, err := tn.form()
+	if err != nil {
+		return "", err
+	}
+	s = form.String(s)
+	if tn.FoldCase {
+		s = cases.Fold().String(s)
+	}
+	if tn.FoldDiacritics {
+		// decompose so that marks are separate from their base characters, then drop the marks
+		s, _, err = transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), form), s)
+		if err != nil {
+			return "", fmt.Errorf("removing diacritics: %v", err)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,113 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTextNormalization(t *testing.T) {
+	const precomposed, combining = "caf\u00e9", "cafe\u0301"
+
+	for i, tc := range []struct {
+		tn    TextNormalization
+		a, b  string
+		equal bool
+	}{
+		{tn: TextNormalization{}, a: precomposed, b: combining, equal: true},
+		{tn: TextNormalization{FoldCase: true}, a: "CAFÉ", b: combining, equal: true},
+		{tn: TextNormalization{}, a: "CAFÉ", b: combining, equal: false},
+		{tn: TextNormalization{FoldDiacritics: true}, a: combining, b: "cafe", equal: true},
+		{tn: TextNormalization{}, a: combining, b: "cafe", equal: false},
+		{tn: TextNormalization{Form: "NFKC"}, a: "\uff43\uff41\uff46\u00e9", b: combining, equal: true},
+		{tn: TextNormalization{Form: "NFC"}, a: "\uff43\uff41\uff46\u00e9", b: combining, equal: false},
+	} {
+		a, err := tc.tn.Normalize(tc.a)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		b, err := tc.tn.Normalize(tc.b)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if (a == b) != tc.equal {
+			t.Errorf("test %d: expected equal=%t for %q and %q (normalized: %q and %q)", i, tc.equal, tc.a, tc.b, a, b)
+		}
+	}
+
+	if _, err := (TextNormalization{Form: "NFD"}).Normalize("x"); err == nil {
+		t.Error("expected error for unsupported normalization form")
+	}
+}
+
+func TestImportDeduplicatesNormalizedText(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	textItem := func(text string) *Item {
+		return &Item{
+			Classification: ClassMessage,
+			Timestamp:      ts,
+			Content:        ItemData{Data: StringData(text)},
+		}
+	}
+	tn := &TextNormalization{FoldCase: true, FoldDiacritics: true}
+
+	for _, text := range []string{"Café au lait", "cafe\u0301 au lait"} {
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			itemChan <- &Graph{Item: textItem(text)}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			ProcessingOptions: ProcessingOptions{
+				TextNormalization:     tn,
+				ItemUniqueConstraints: map[string]bool{"timestamp": true, "data": false},
+			},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	results, err := tl.Search(context.Background(), ItemSearchParams{})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results.Items) != 1 {
+		t.Fatalf("expected equivalent texts to be deduplicated into 1 item, got %d", len(results.Items))
+	}
+	if text := results.Items[0].DataText; text == nil || *text != "Café au lait" {
+		t.Errorf("expected original text to be preserved verbatim, got %v", text)
+	}
+
+	results, err = tl.Search(context.Background(), ItemSearchParams{
+		DataText:          []string{"CAFE"},
+		TextNormalization: tn,
+	})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results.Items) != 1 {
+		t.Errorf("expected normalized search to match, got %d results", len(results.Items))
+	}
+}
@@ -0,0 +1,60 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import "context"
+
+// openFileLimiter bounds how many data files an import writes at once, so that
+// many concurrent downloads can't exhaust the process's file descriptors (which
+// would fail with EMFILE); instead, downloads wait for a turn. Writing a data
+// file takes at most a few descriptors (the temporary file, and any derived
+// files made from it), and only while it is being written. A nil openFileLimiter
+// is unlimited; all its methods are no-ops.
+type openFileLimiter struct {
+	slots chan struct{}
+}
+
+// newOpenFileLimiter returns a limiter of n files, or nil if n is not positive.
+func newOpenFileLimiter(n int) *openFileLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &openFileLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire blocks until another data file can be written.
+func (l *openFileLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release makes room for another data file to be written.
+func (l *openFileLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
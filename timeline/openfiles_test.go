@@ -0,0 +1,125 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowReader reads a few bytes at a time, slowly, and tracks how many of its
+// kind are being read at once, like data files being downloaded.
+type slowReader struct {
+	remaining    int
+	started      bool
+	active, peak *atomic.Int32
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		n := r.active.Add(1)
+		for {
+			peak := r.peak.Load()
+			if n <= peak || r.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+	}
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(time.Millisecond)
+	n := min(len(p), r.remaining, 16)
+	for i := range p[:n] {
+		p[i] = 'x'
+	}
+	r.remaining -= n
+	if r.remaining == 0 {
+		r.active.Add(-1)
+	}
+	return n, nil
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const maxOpenFiles = 2
+	var active, peak atomic.Int32
+
+	ts := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	var items []*Item
+	for i := range 12 {
+		items = append(items, &Item{
+			ID:             fmt.Sprintf("file%d", i),
+			Classification: ClassMedia,
+			Timestamp:      ts.Add(time.Duration(i) * time.Minute),
+			Content: ItemData{
+				Filename:  fmt.Sprintf("file%d.bin", i),
+				MediaType: "application/octet-stream",
+				Data: func(context.Context) (io.ReadCloser, error) {
+					// (contents differ so that the files aren't deduplicated)
+					return io.NopCloser(&slowReader{remaining: 64 + i, active: &active, peak: &peak}), nil
+				},
+			},
+		})
+	}
+
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		for _, it := range items {
+			itemChan <- &Graph{Item: it}
+		}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{MaxOpenFiles: maxOpenFiles},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	if p := peak.Load(); p > maxOpenFiles {
+		t.Errorf("expected at most %d data files to be written at once, but %d were", maxOpenFiles, p)
+	}
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE data_file IS NOT NULL`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(items) {
+		t.Errorf("expected all %d data files to be written, got %d", len(items), count)
+	}
+}
+
+func TestNegativeMaxOpenFiles(t *testing.T) {
+	tl := newTestTimeline(t)
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{MaxOpenFiles: -1},
+	})
+	if err == nil {
+		t.Error("expected error for negative maximum open files")
+	}
+}
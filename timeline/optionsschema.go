@@ -0,0 +1,246 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// ValidateDataSourceOptions validates the raw JSON options for the named data
+// source against the data source's options schema, if it has one. If the options
+// are invalid, an OptionsValidationError is returned which describes the problem
+// with each field, suitable for displaying alongside a form.
+func (t *Timeline) ValidateDataSourceOptions(dataSourceName string, raw json.RawMessage) error {
+	ds, ok := lookupDataSource(dataSourceName)
+	if !ok {
+		return fmt.Errorf("unknown data source: %s", dataSourceName)
+	}
+	return ds.validateOptions(raw)
+}
+
+func (ds DataSource) validateOptions(raw json.RawMessage) error {
+	if ds.OptionsSchema == nil {
+		return nil
+	}
+
+	var schema jsonSchema
+	if err := json.Unmarshal(ds.OptionsSchema(), &schema); err != nil {
+		return fmt.Errorf("invalid options schema for data source %s: %v", ds.Name, err)
+	}
+
+	var opts any = map[string]any{}
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &opts); err != nil {
+			return OptionsValidationError{
+				DataSource: ds.Name,
+				Errors:     []FieldError{{Message: fmt.Sprintf("invalid JSON: %v", err)}},
+			}
+		}
+	}
+
+	var errs []FieldError
+	schema.validate("", opts, &errs)
+	if len(errs) > 0 {
+		return OptionsValidationError{DataSource: ds.Name, Errors: errs}
+	}
+	return nil
+}
+
+// OptionsValidationError is returned when data source options
+// do not conform to the data source's options schema.
+type OptionsValidationError struct {
+	DataSource string       `json:"data_source"`
+	Errors     []FieldError `json:"errors"`
+}
+
+func (e OptionsValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.String())
+	}
+	return fmt.Sprintf("invalid options for data source %s: %s", e.DataSource, strings.Join(msgs, "; "))
+}
+
+// FieldError describes a problem with a single field. Field is
+// the path to the field, like "foo.bar[2]"; it is empty if the
+// problem is with the options as a whole.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (fe FieldError) String() string {
+	if fe.Field == "" {
+		return fe.Message
+	}
+	return fe.Field + ": " + fe.Message
+}
+
+// jsonSchema is a minimal subset of JSON Schema, enough to
+// describe the options of data sources.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+}
+
+// schemaTypes is the "type" keyword, which may be a string or a list of strings.
+type schemaTypes []string
+
+func (st *schemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*st = schemaTypes{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(b, &multi); err != nil {
+		return fmt.Errorf("type must be a string or list of strings: %v", err)
+	}
+	*st = multi
+	return nil
+}
+
+func (s *jsonSchema) validate(path string, v any, errs *[]FieldError) {
+	addErr := func(format string, a ...any) {
+		*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if len(s.Type) > 0 {
+		var ok bool
+		for _, typ := range s.Type {
+			if jsonValueIsType(v, typ) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			addErr("must be of type %s", strings.Join(s.Type, " or "))
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		var ok bool
+		for _, allowed := range s.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			addErr("must be one of %v", s.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		for _, req := range s.Required {
+			if _, ok := val[req]; !ok {
+				*errs = append(*errs, FieldError{Field: joinFieldPath(path, req), Message: "is required"})
+			}
+		}
+		for key, fieldVal := range val {
+			propSchema, ok := s.Properties[key]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*errs = append(*errs, FieldError{Field: joinFieldPath(path, key), Message: "is not a recognized option"})
+				}
+				continue
+			}
+			propSchema.validate(joinFieldPath(path, key), fieldVal, errs)
+		}
+
+	case []any:
+		if s.Items != nil {
+			for i, elem := range val {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), elem, errs)
+			}
+		}
+
+	case string:
+		if s.MinLength != nil && len(val) < *s.MinLength {
+			addErr("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && len(val) > *s.MaxLength {
+			addErr("must be at most %d characters", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				addErr("schema has invalid pattern: %v", err)
+			} else if !re.MatchString(val) {
+				addErr("must match pattern %s", s.Pattern)
+			}
+		}
+
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			addErr("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			addErr("must be at most %v", *s.Maximum)
+		}
+	}
+}
+
+func jsonValueIsType(v any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+func joinFieldPath(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
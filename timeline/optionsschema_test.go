@@ -0,0 +1,82 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestValidateDataSourceOptions(t *testing.T) {
+	ds := DataSource{
+		Name: "schema_test",
+		OptionsSchema: func() json.RawMessage {
+			return json.RawMessage(`{
+				"type": "object",
+				"required": ["owner_phone"],
+				"additionalProperties": false,
+				"properties": {
+					"owner_phone": {"type": "string", "minLength": 3},
+					"mode": {"enum": ["fast", "thorough"]},
+					"limit": {"type": "integer", "minimum": 1}
+				}
+			}`)
+		},
+	}
+
+	for i, tc := range []struct {
+		input        string
+		expectFields []string
+	}{
+		{input: `{"owner_phone": "+15555555555"}`},
+		{input: `{"owner_phone": "+15555555555", "mode": "fast", "limit": 10}`},
+		{input: ``, expectFields: []string{"owner_phone"}},
+		{input: `{"mode": "fast"}`, expectFields: []string{"owner_phone"}},
+		{input: `{"owner_phone": 5}`, expectFields: []string{"owner_phone"}},
+		{input: `{"owner_phone": "+1", "limit": 1.5}`, expectFields: []string{"owner_phone", "limit"}},
+		{input: `{"owner_phone": "+15555555555", "mode": "slow", "bogus": true}`, expectFields: []string{"mode", "bogus"}},
+		{input: `[]`, expectFields: []string{""}},
+	} {
+		err := ds.validateOptions(json.RawMessage(tc.input))
+		if len(tc.expectFields) == 0 {
+			if err != nil {
+				t.Errorf("test %d: expected no error, got: %v", i, err)
+			}
+			continue
+		}
+		var valErr OptionsValidationError
+		if !errors.As(err, &valErr) {
+			t.Errorf("test %d: expected validation error, got: %v", i, err)
+			continue
+		}
+		got := make(map[string]bool)
+		for _, fe := range valErr.Errors {
+			got[fe.Field] = true
+		}
+		for _, field := range tc.expectFields {
+			if !got[field] {
+				t.Errorf("test %d: expected error for field '%s', got: %v", i, field, valErr.Errors)
+			}
+		}
+		if len(got) != len(tc.expectFields) {
+			t.Errorf("test %d: expected errors for %v, got: %v", i, tc.expectFields, valErr.Errors)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+//go:build cgo && (linux || darwin || freebsd)
+
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+)
+
+// DataSourcePluginSymbol is the name of the function that data source plugins
+// must export. Its signature must be that of a DataSourceFactory:
+//
+//	func TimelinizeDataSource() (timeline.DataSource, error)
+const DataSourcePluginSymbol = "TimelinizeDataSource"
+
+// LoadDataSourcePlugins opens every Go plugin (.so file) in dir and registers
+// the data source each one provides, then makes the new data sources available
+// to this timeline. It returns the names of the data sources that were loaded.
+// It should be called before any imports are started.
+//
+// Go plugins have strict ABI constraints: a plugin must be built with
+// -buildmode=plugin using the exact same Go toolchain version, the same build
+// tags and flags, and the same versions of every package it shares with the
+// host program (including this module) as the host program itself. Plugins
+// cannot be unloaded, and they are only supported on Linux, macOS, and
+// FreeBSD with cgo enabled.
+func (tl *Timeline) LoadDataSourcePlugins(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading plugin directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".so" {
+			continue
+		}
+		pluginPath := filepath.Join(dir, entry.Name())
+
+		plug, err := plugin.Open(pluginPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening plugin %s: %v", pluginPath, err)
+		}
+		sym, err := plug.Lookup(DataSourcePluginSymbol)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %v", pluginPath, err)
+		}
+		factory, ok := sym.(func() (DataSource, error))
+		if !ok {
+			return nil, fmt.Errorf("plugin %s: symbol %s has wrong type %T; expected func() (timeline.DataSource, error)",
+				pluginPath, DataSourcePluginSymbol, sym)
+		}
+
+		// we need the name to register the factory, so call it once up front
+		ds, err := factory()
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %v", pluginPath, err)
+		}
+		if err := RegisterDataSourceFactory(ds.Name, factory); err != nil {
+			return nil, fmt.Errorf("plugin %s: %v", pluginPath, err)
+		}
+	}
+
+	return tl.reloadDataSources()
+}
@@ -0,0 +1,36 @@
+//go:build !cgo || !(linux || darwin || freebsd)
+
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import "fmt"
+
+// DataSourcePluginSymbol is the name of the function that data source plugins
+// must export. Its signature must be that of a DataSourceFactory:
+//
+//	func TimelinizeDataSource() (timeline.DataSource, error)
+const DataSourcePluginSymbol = "TimelinizeDataSource"
+
+// LoadDataSourcePlugins is not supported on this platform, since Go plugins
+// require cgo and Linux, macOS, or FreeBSD. Data sources can still be added
+// with RegisterDataSourceFactory.
+func (tl *Timeline) LoadDataSourcePlugins(dir string) ([]string, error) {
+	return nil, fmt.Errorf("data source plugins are not supported on this platform")
+}
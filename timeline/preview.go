@@ -0,0 +1,127 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// PreviewImport runs the data source just long enough to get the first n
+// items it would import with the given parameters, then stops it. Nothing
+// is written to the timeline, so this is useful for confirming that the
+// right files or account were chosen before importing. Fewer than n items
+// are returned if the data source doesn't have that many.
+//
+// The content of returned items can still be read (if the data source
+// allows reading it more than once), but should be read promptly, since
+// the underlying files or connections may not be kept around.
+func (t *Timeline) PreviewImport(ctx context.Context, params ImportParameters, n int) ([]*Item, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("number of items to preview must be positive: %d", n)
+	}
+	if params.ResumeImportID > 0 {
+		return nil, fmt.Errorf("cannot preview a resumed import")
+	}
+
+	ds, ok := lookupDataSource(params.DataSourceName)
+	if !ok {
+		return nil, fmt.Errorf("unknown data source: %s", params.DataSourceName)
+	}
+	if len(params.Filenames) > 0 && ds.NewFileImporter == nil {
+		return nil, fmt.Errorf("data source %s does not support importing from files", ds.Name)
+	}
+	if len(params.Filenames) == 0 && ds.NewAPIImporter == nil {
+		return nil, fmt.Errorf("data source %s does not support importing via API", ds.Name)
+	}
+	for _, filename := range params.Filenames {
+		if _, err := checkPassphrase(filename, params.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ds.validateOptions(params.DataSourceOptions); err != nil {
+		return nil, err
+	}
+	dsOpt, err := ds.UnmarshalOptions(params.DataSourceOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var acc Account
+	if params.AccountID > 0 {
+		acc, err = t.LoadAccount(ctx, params.AccountID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger := Log.Named("preview").With(zap.String("data_source", ds.Name))
+	listOpt := ListingOptions{
+		Log:               logger,
+		Timeframe:         params.ProcessingOptions.Timeframe,
+		DataSourceOptions: dsOpt,
+		Passphrase:        params.Passphrase,
+		MaxItems:          n,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan *Graph)
+	done := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		if len(params.Filenames) > 0 {
+			filenames, fsys, err := params.fileImportInput(ctx, ds)
+			if err != nil {
+				done <- err
+				return
+			}
+			listOpt.FS = fsys
+			done <- ds.NewFileImporter().FileImport(ctx, filenames, ch, listOpt)
+		} else {
+			done <- ds.NewAPIImporter().APIImport(ctx, acc, ch, listOpt)
+		}
+	}()
+
+	items := make([]*Item, 0, n)
+	for g := range ch {
+		if g == nil || g.Item == nil || len(items) == n {
+			continue
+		}
+		items = append(items, g.Item)
+		if len(items) == n {
+			// we have enough; stop the data source, but keep draining
+			// the channel in case it sends more before noticing
+			cancel()
+		}
+	}
+
+	err = <-done
+	if err != nil && !(errors.Is(err, context.Canceled) && len(items) == n) {
+		return items, fmt.Errorf("%s: previewing import: %w", ds.Name, err)
+	}
+	logger.Debug("previewed import", zap.Int("items", len(items)))
+
+	return items, nil
+}
@@ -0,0 +1,117 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPreviewImport(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const total, n = 100, 5
+	ts := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	var maxItems int
+	stopped := make(chan int, 1)
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		maxItems = opt.MaxItems
+		for i := 0; i < total; i++ {
+			select {
+			case itemChan <- &Graph{Item: testFileItem(fmt.Sprintf("item%03d", i), ts.Add(time.Duration(i)*time.Minute))}:
+			case <-ctx.Done():
+				stopped <- i
+				return ctx.Err()
+			}
+		}
+		stopped <- total
+		return nil
+	}
+
+	items, err := tl.PreviewImport(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+	}, n)
+	if err != nil {
+		t.Fatalf("preview failed: %v", err)
+	}
+	if len(items) != n {
+		t.Fatalf("expected %d items, got %d", n, len(items))
+	}
+	for i, it := range items {
+		if expected := fmt.Sprintf("item%03d", i); it.ID != expected {
+			t.Errorf("item %d: expected %s, got %s", i, expected, it.ID)
+		}
+	}
+	if maxItems != n {
+		t.Errorf("expected data source to be told to list at most %d items, got %d", n, maxItems)
+	}
+
+	// the data source must have been stopped, not run to completion
+	select {
+	case sent := <-stopped:
+		if sent >= total {
+			t.Errorf("data source was not stopped early")
+		}
+	default:
+		t.Error("data source was still running after preview returned")
+	}
+
+	// nothing must have been written
+	var imports, rows int
+	if err := tl.db.QueryRow(`SELECT (SELECT count() FROM imports), (SELECT count() FROM items)`).Scan(&imports, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if imports != 0 || rows != 0 {
+		t.Errorf("expected nothing in database, got %d imports and %d items", imports, rows)
+	}
+	if entries, err := os.ReadDir(filepath.Join(tl.repoDir, DataFolderName)); err == nil && len(entries) > 0 {
+		t.Errorf("expected no data files, got %d entries", len(entries))
+	}
+
+	// a smaller data source gives what it has
+	testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("only", ts)}
+		return nil
+	}
+	items, err = tl.PreviewImport(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+	}, n)
+	if err != nil || len(items) != 1 {
+		t.Errorf("expected 1 item and no error, got %d items and %v", len(items), err)
+	}
+
+	// errors from the data source are reported
+	testFileImport = func(context.Context, []string, chan<- *Graph, ListingOptions) error {
+		return errors.New("not an export")
+	}
+	if _, err := tl.PreviewImport(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+	}, n); err == nil {
+		t.Error("expected error from data source")
+	}
+}
@@ -43,10 +43,11 @@ import (
 )
 
 const (
-	// batchSize is how many items to process in one transaction;
-	// except for the final remainder, this is a minimum count,
-	// not a maximum, due to the recursive and inter-related nature
-	// of item graphs -- hopefully data sources don't send graphs
+	// batchSize is how many rows (items, entities, and relationships)
+	// to write in one transaction; graphs are batched until their sizes
+	// add up to at least this many, and if the edges of a graph grow a
+	// transaction beyond it, the transaction is committed and the batch
+	// continues in a new one -- hopefully data sources don't send graphs
 	// too big for available memory
 	batchSize = 50
 
@@ -57,20 +58,114 @@ const (
 	workers = 5
 )
 
+// batchLimits returns the size of the buffer of graphs waiting to be processed,
+// and the number of graphs at which a batch is committed, which the processing
+// options can lower from batchSize.
+func batchLimits(po ProcessingOptions) (bufSize, maxBatchSize int) {
+	// buffer the channel a little so that we can observe
+	// how many graphs are waiting to be processed
+	bufSize, maxBatchSize = batchSize, batchSize
+	if limit := po.MaxPendingGraphs; limit > 0 {
+		// the bound includes graphs buffered in the channel, and a batch must be
+		// able to fill up with the rest, otherwise it would wait forever
+		bufSize = min(bufSize, limit/2)
+		maxBatchSize = min(maxBatchSize, limit-bufSize-po.ReorderWindow) // graphs held for reordering count toward the bound
+	}
+	if fe := po.FlushEvery; fe != nil && fe.Items > 0 {
+		maxBatchSize = min(maxBatchSize, fe.Items)
+	}
+	return bufSize, maxBatchSize
+}
+
 func (p *processor) beginProcessing(ctx context.Context, po ProcessingOptions) (*sync.WaitGroup, chan<- *Graph) {
 	wg := new(sync.WaitGroup)
-	ch := make(chan *Graph)
+
+	bufSize, maxBatchSize := batchLimits(po)
+	var pending chan struct{}
+	if limit := po.MaxPendingGraphs; limit > 0 {
+		pending = make(chan struct{}, limit-bufSize)
+	}
+	var flushInterval time.Duration
+	if fe := po.FlushEvery; fe != nil {
+		flushInterval = fe.Interval
+	}
+	ch := make(chan *Graph, bufSize)
+	p.graphs = ch
+
+	// a single goroutine receives graphs in the order they are emitted by
+	// the data source, so it can number the items to preserve that order
+	// (for ties in timestamps) before handing them off to the workers;
+	// if the number of pending graphs is bounded, it stops receiving when
+	// the bound is reached, so that the data source blocks when it sends
+	work := make(chan *Graph)
+	go func() {
+		defer close(work)
+		var sequence int64
+		send := func(g *Graph) bool {
+			if g.Item != nil && g.Item.Sequence == 0 {
+				sequence++
+				g.Item.Sequence = sequence
+			}
+			select {
+			case work <- g:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// if enabled, hold back some graphs to put them in order
+		reorder := newReorderBuffer(po.ReorderWindow)
+
+		for {
+			if pending != nil {
+				select {
+				case pending <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err := p.memory.waitBelowLimit(ctx); err != nil {
+				return
+			}
+			g, ok := <-ch
+			if !ok {
+				break
+			}
+			if g == nil {
+				if pending != nil {
+					<-pending
+				}
+				continue
+			}
+			if g = reorder.push(g); g == nil {
+				continue // held back
+			}
+			if !send(g) {
+				return
+			}
+		}
+
+		// the data source is done, so release the graphs still held back
+		for g := reorder.pop(); g != nil; g = reorder.pop() {
+			if !send(g) {
+				return
+			}
+		}
+	}()
 
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func(workerNum int) {
 			defer wg.Done()
 
-			// addToBatch adds g to the batch, and if the batch is full, it
-			// sends it for processing and resets the batch. If g is nil,
-			// the batch is processed regardless of its size.
-			addToBatch := func(g *Graph) {
+			// addToBatch adds g (if not nil) to the batch, and if the batch
+			// is full or due to be flushed, it sends it for processing and
+			// resets the batch. If final is true, the batch is processed
+			// regardless of its size.
+			addToBatch := func(g *Graph, final bool) {
 				var batch []*Graph
+				var batchBytes int64
 
 				// TODO: do we want/need to prevent infinite recursion (avoid visiting same graph twice)?
 
@@ -79,43 +174,81 @@ func (p *processor) beginProcessing(ctx context.Context, po ProcessingOptions) (
 				// copy it (just the slice header) then reset the batch
 				p.batchMu.Lock()
 				if g != nil {
+					if len(p.batch) == 0 {
+						p.batchStart = time.Now()
+					}
 					p.batch = append(p.batch, g)
 					p.batchSize += g.Size()
+					if p.memory != nil {
+						graphBytes := g.memSize()
+						p.memory.add(graphBytes)
+						p.batchBytes += graphBytes
+					}
 				}
-				if p.batchSize >= batchSize || (g == nil && len(p.batch) > 0) {
-					batch = p.batch
+				// if memory is tight, process the batch early to free it up
+				due := flushInterval > 0 && time.Since(p.batchStart) >= flushInterval
+				if p.batchSize >= maxBatchSize || (len(p.batch) > 0 && (final || due || p.memory.nearlyFull())) {
+					batch, batchBytes = p.batch, p.batchBytes
 					p.batch = make([]*Graph, 0, batchSize)
-					p.batchSize = 0
+					p.batchSize, p.batchBytes = 0, 0
 				}
 				p.batchMu.Unlock()
 
 				if len(batch) > 0 {
 					err := p.pipeline(ctx, batch, &recursiveState{
-						worker:  workerNum,
-						procOpt: po,
+						worker:    workerNum,
+						procOpt:   po,
+						maxTxRows: maxBatchSize,
 					})
 					if err != nil {
 						p.log.Error("batch pipeline",
 							zap.Int("worker", workerNum),
 							zap.Error(err))
 					}
+					p.reportProgress()
+
+					// the graphs are done, so make room for more
+					if pending != nil {
+						for range batch {
+							<-pending
+						}
+					}
+					p.memory.release(batchBytes)
 				}
 			}
 
+			// if batches are flushed periodically, check on them even
+			// if no more graphs arrive
+			var flushTick <-chan time.Time
+			if flushInterval > 0 {
+				ticker := time.NewTicker(flushInterval)
+				defer ticker.Stop()
+				flushTick = ticker.C
+			}
+
 			// read all incoming item graphs (or entities) and add them
 			// to a batch, and process the batch if it is full
-			for g := range ch {
-				if ctx.Err() != nil {
-					return
-				}
-				if g == nil {
-					continue
+		receive:
+			for {
+				select {
+				case g, ok := <-work:
+					if !ok {
+						break receive
+					}
+					if ctx.Err() != nil {
+						return
+					}
+					if g == nil {
+						continue
+					}
+					addToBatch(g, false)
+				case <-flushTick:
+					addToBatch(nil, false)
 				}
-				addToBatch(g)
 			}
 
 			// process the remaining items in the last batch
-			addToBatch(nil)
+			addToBatch(nil, true)
 		}(i)
 	}
 
@@ -123,6 +256,9 @@ func (p *processor) beginProcessing(ctx context.Context, po ProcessingOptions) (
 }
 
 func (p *processor) pipeline(ctx context.Context, batch []*Graph, rs *recursiveState) error {
+	defer p.setPhase(rs.worker, phaseIdle)
+
+	p.setPhase(rs.worker, phaseInsert)
 	err := p.phase1(ctx, rs, batch)
 	if err != nil {
 		return err
@@ -133,9 +269,11 @@ func (p *processor) pipeline(ctx context.Context, batch []*Graph, rs *recursiveS
 	// an extra parameter or return value. Phases 2 and 3 do make some allocations even if
 	// there aren't any data files, but I'd want to dig deeper (likely with a profile) to
 	// determine if avoiding these phases entirely is worth the effort.
+	p.setPhase(rs.worker, phaseDownload)
 	if err := p.phase2(ctx, rs, batch); err != nil {
 		return err
 	}
+	p.setPhase(rs.worker, phaseFinalize)
 	if err := p.phase3(ctx, rs, batch); err != nil {
 		return err
 	}
@@ -147,22 +285,44 @@ func (p *processor) phase1(ctx context.Context, rs *recursiveState, batch []*Gra
 	p.tl.dbMu.Lock()
 	defer p.tl.dbMu.Unlock()
 
+	start := time.Now()
+
+	p.insertedItems, p.updatedItems = nil, nil
+
 	tx, err := p.tl.db.Begin()
 	if err != nil {
 		return fmt.Errorf("beginning transaction for batch: %v", err)
 	}
-	defer tx.Rollback()
+	// (the batch may continue in a new transaction, so don't bind tx yet)
+	defer func() { tx.Rollback() }()
+	rs.txRows = 0
 
 	for _, g := range batch {
-		if _, err = p.processGraph(ctx, tx, rs, g); err != nil {
+		if p.graphCount != nil {
+			atomic.AddInt64(p.graphCount, 1)
+		}
+		if _, tx, err = p.processGraph(ctx, tx, rs, g); err != nil {
 			p.log.Error("processing graph", zap.String("graph", g.String()), zap.Error(err))
-			g.err = err
+			p.countFailure(g, err)
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("committing transaction for batch: %v", err)
 	}
+	p.recordCommitSize(rs.txRows)
+
+	// let subscribers know about the items now that they are in the DB
+	if len(p.insertedItems) > 0 {
+		p.tl.publish(Event{Topic: TopicItemInserted, ImportID: p.impRow.id, ItemIDs: p.insertedItems})
+	}
+	if len(p.updatedItems) > 0 {
+		p.tl.publish(Event{Topic: TopicItemUpdated, ImportID: p.impRow.id, ItemIDs: p.updatedItems})
+	}
+
+	if p.commitLatency != nil {
+		p.commitLatency.add(time.Since(start))
+	}
 
 	return nil
 }
@@ -183,7 +343,7 @@ func (p *processor) phase2(ctx context.Context, rs *recursiveState, batch []*Gra
 			}()
 			if err := p.downloadDataFilesInGraph(ctx, g); err != nil {
 				p.log.Error("downloading data files in graph", zap.Error(err))
-				g.err = err
+				p.countFailure(g, err)
 			}
 		}(g)
 	}
@@ -202,13 +362,14 @@ func (p *processor) phase3(ctx context.Context, rs *recursiveState, batch []*Gra
 	}
 	defer tx.Rollback()
 
+	// (graphs that failed are finished too, so that their data files that
+	// were not written completely are rolled back along with their items)
 	for _, g := range batch {
-		if g.err != nil {
-			continue
-		}
 		if err := p.finishProcessingDataFiles(ctx, tx, g); err != nil {
 			p.log.Error("finalizing data files in graph", zap.Error(err))
-			g.err = err
+			if g.err == nil {
+				p.countFailure(g, err)
+			}
 		}
 	}
 
@@ -225,8 +386,18 @@ func (p *processor) downloadDataFilesInGraph(ctx context.Context, g *Graph) erro
 	}
 
 	// download main item's data file (root node of graph), only if there is one
-	if g.Item != nil && g.Item.dataFileIn != nil && g.Item.dataFileOut != nil {
-		if err := p.downloadDataFile(ctx, g.Item); err != nil {
+	if g.Item != nil && g.Item.dataFileIn != nil && (g.Item.dataFileOut != nil || isExternalDataFile(g.Item.dataFileName)) {
+		if err := p.memory.acquireDownload(ctx); err != nil {
+			return err
+		}
+		if err := p.openFiles.acquire(ctx); err != nil {
+			p.memory.releaseDownload()
+			return err
+		}
+		err := p.downloadDataFile(ctx, g.Item)
+		p.openFiles.release()
+		p.memory.releaseDownload()
+		if err != nil {
 			return err
 		}
 	}
@@ -264,14 +435,14 @@ func (p *processor) finishProcessingDataFiles(ctx context.Context, tx *sql.Tx, g
 	return nil
 }
 
-func (p *processor) processGraph(ctx context.Context, tx *sql.Tx, state *recursiveState, ig *Graph) (latentID, error) {
+func (p *processor) processGraph(ctx context.Context, tx *sql.Tx, state *recursiveState, ig *Graph) (latentID, *sql.Tx, error) {
 	if ig == nil {
-		return latentID{}, nil
+		return latentID{}, tx, nil
 	}
 
 	// validate node type
 	if ig.Item != nil && ig.Entity != nil {
-		return latentID{}, fmt.Errorf("ambiguous node in graph is both an item and entity node (item_graph=%p)", ig)
+		return latentID{}, tx, fmt.Errorf("ambiguous node in graph is both an item and entity node (item_graph=%p)", ig)
 	}
 
 	var rowID latentID
@@ -299,20 +470,37 @@ func (p *processor) processGraph(ctx context.Context, tx *sql.Tx, state *recursi
 		l.Info("finished graph")
 	}()
 
+	// record where the item came from, if the data source told us
+	if ig.Item != nil && ig.SourceFile != "" {
+		ig.Item.sourceFile, ig.Item.sourceOffset = ig.SourceFile, ig.SourceOffset
+	}
+	for _, edge := range ig.Edges {
+		for _, connected := range []*Graph{edge.From, edge.To} {
+			if connected != nil && connected.SourceFile == "" {
+				connected.SourceFile, connected.SourceOffset = ig.SourceFile, ig.SourceOffset
+			}
+		}
+	}
+
 	// process root node
 	switch {
 	case ig.Entity != nil:
 		var err error
 		rowID, err = p.processEntity(ctx, tx, *ig.Entity)
 		if err != nil {
-			return latentID{}, fmt.Errorf("processing entity node: %v", err)
+			return latentID{}, tx, fmt.Errorf("processing entity node: %v", err)
+		}
+		if p.entityCount != nil {
+			atomic.AddInt64(p.entityCount, 1)
 		}
+		state.txRows++
 	case ig.Item != nil:
 		var err error
 		rowID, err = p.processItem(ctx, tx, ig.Item, state)
 		if err != nil {
-			return latentID{}, fmt.Errorf("processing item node: %v", err)
+			return latentID{}, tx, fmt.Errorf("processing item node: %v", err)
 		}
+		state.txRows++
 	}
 
 	// process connected nodes
@@ -328,22 +516,41 @@ func (p *processor) processGraph(ctx context.Context, tx *sql.Tx, state *recursi
 
 	// successfully finished processing graph; save checkpoint, if specified
 	if ig.Checkpoint != nil {
-		chkpt, err := marshalGob(checkpoint{p.filenames, p.params.ProcessingOptions, ig.Checkpoint})
+		chkpt, err := p.encodeCheckpoint(ig.Checkpoint)
 		if err != nil {
-			return latentID{}, err
+			return latentID{}, tx, err
 		}
 
-		_, err = tx.Exec(`UPDATE imports SET checkpoint=? WHERE id=?`, // TODO: LIMIT 1 (see https://github.com/mattn/go-sqlite3/pull/564)
-			chkpt, p.impRow.id)
-		if err != nil {
-			return latentID{}, err
+		if chkpt != nil {
+			_, err = tx.Exec(`UPDATE imports SET checkpoint=? WHERE id=?`, // TODO: LIMIT 1 (see https://github.com/mattn/go-sqlite3/pull/564)
+				chkpt, p.impRow.id)
+			if err != nil {
+				return latentID{}, tx, err
+			}
+			atomic.AddInt64(p.checkpointCount, 1)
 		}
 	}
 
-	return rowID, nil
+	return rowID, tx, nil
 }
 
 func (p *processor) processItem(ctx context.Context, tx *sql.Tx, it *Item, state *recursiveState) (latentID, error) {
+	// an epoch timestamp usually means the source didn't know the time
+	if state.procOpt.ZeroTimestampAsUnknown {
+		clearEpochTimestamps(it, state.procOpt.ZeroTimestampThreshold)
+	}
+
+	// store and compare timestamps only as precisely as configured
+	state.procOpt.TimestampPrecision.truncateTimestamps(it)
+
+	// skip item if it's from the future and the user doesn't want those
+	if state.procOpt.FutureTimestamps == FutureTimestampsReject && it.Timestamp.After(time.Now()) {
+		p.log.Warn("rejecting item with timestamp in the future (source clock may be wrong)",
+			zap.String("item_id", it.ID),
+			zap.Time("item_timestamp", it.Timestamp))
+		return latentID{}, fmt.Errorf("item timestamp is in the future: %s", it.Timestamp)
+	}
+
 	// skip item if outside of timeframe (data source should do this for us, but
 	// ultimately we should enforce it: it just means the data source is being
 	// less efficient than it could be)
@@ -366,11 +573,29 @@ func (p *processor) processItem(ctx context.Context, tx *sql.Tx, it *Item, state
 		}
 	}
 
+	// tracks and routes are located where they start, unless told otherwise
+	if it.Location.IsEmpty() && len(it.Geometry) > 0 {
+		it.Location = it.Geometry[0].location()
+	}
+
 	itemRowID, err := p.storeItem(ctx, tx, it)
 	if err != nil {
 		return latentID{itemID: itemRowID}, err
 	}
 
+	if itemRowID > 0 && len(it.Geometry) > 0 {
+		if err := storeItemGeometry(ctx, tx, itemRowID, it.Geometry); err != nil {
+			return latentID{itemID: itemRowID}, err
+		}
+	}
+
+	// remember that this import gave us this item, and what it looked like
+	if itemRowID > 0 {
+		if err := p.recordImportItem(ctx, tx, itemRowID, it); err != nil {
+			return latentID{itemID: itemRowID}, err
+		}
+	}
+
 	return latentID{itemID: itemRowID}, nil
 }
 
@@ -384,7 +609,7 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 	var processDataFile bool // if true, we'll be storing the data as a file on disk, not in the DB
 
 	if it.Content.Data != nil {
-		rc, err := it.Content.Data(ctx)
+		rc, err := it.Content.open(ctx)
 		if err != nil {
 			return 0, fmt.Errorf("getting item's data stream: %v (item_id=%s)", err, it.ID)
 		}
@@ -447,20 +672,29 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 					sizePeekBufPool.Put(bufPtr)
 				}()
 
-				n, err := io.ReadFull(it.dataFileIn, buf)
+				// the pooled buffer is only big enough for the default threshold
+				peek := buf
+				if threshold := p.params.ProcessingOptions.InlineThresholdBytes; threshold > len(buf) {
+					peek = make([]byte, threshold)
+				} else if threshold > 0 {
+					peek = buf[:threshold]
+				}
+
+				n, err := io.ReadFull(it.dataFileIn, peek)
 				if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
 					return 0, fmt.Errorf("buffering item's data stream to peek size: %v", err)
 				}
-				if n == len(buf) {
+				if n == len(peek) {
 					// content is at least as large as our buffer, so it probably belongs on disk;
-					// recover the bytes we already buffered when we go to write the file
+					// recover the bytes we already buffered when we go to write the file (copy
+					// them, since the file is written after the buffer is returned to the pool)
 					processDataFile = true
-					it.dataFileIn = io.NopCloser(io.MultiReader(bytes.NewReader(buf), it.dataFileIn))
+					it.dataFileIn = io.NopCloser(io.MultiReader(bytes.NewReader(bytes.Clone(peek)), it.dataFileIn))
 				} else if n > 0 {
 					// NOTE: We trim leading/trailing spaces for this because it can be hard
 					// for some data sources to strip them, and I don't think we need them,
 					// especially for short text content stored in the DB
-					dataTextStr := string(buf[:n])
+					dataTextStr := string(peek[:n])
 					dataTextStr = strings.TrimSpace(dataTextStr)
 					it.dataText = &dataTextStr
 				}
@@ -470,6 +704,15 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 		}
 	}
 
+	// normalize the text, if enabled, so equivalent text is recognized as the same
+	if tn := p.params.ProcessingOptions.TextNormalization; tn != nil && it.dataText != nil {
+		normalized, err := tn.Normalize(*it.dataText)
+		if err != nil {
+			return 0, fmt.Errorf("normalizing item text: %v", err)
+		}
+		it.normalizedText = &normalized
+	}
+
 	// at this point, we have the text data, or a handle to the
 	// file data, but we won't download the full file until later;
 	// first we need to do some more preparation and insert its
@@ -491,14 +734,44 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 	var updateOverrides map[string]fieldUpdatePolicy
 
 	// if the item is already in our DB, load it
-	ir, err := p.tl.loadItemRow(ctx, tx, 0, it, dsName, p.params.ProcessingOptions.ItemUniqueConstraints, true)
+	ir, err := p.tl.loadItemRow(ctx, tx, 0, it, dsName, p.params.ProcessingOptions.DedupScope, p.dedupAccountID(), p.params.ProcessingOptions.ItemUniqueConstraints, p.params.ProcessingOptions.TimeAwareDedup, true)
 	if err != nil {
 		return 0, fmt.Errorf("looking up item in database: %v", err)
 	}
+	var newVersion bool
 	if ir.ID > 0 {
 		// found it in our DB; skip it?
 		var reprocessItem, reprocessDataFile bool
-		reprocessItem, reprocessDataFile, updateOverrides = p.shouldProcessExistingItem(it, ir, processDataFile)
+		// like any other update, a new version doesn't overwrite the user's own changes
+		newVersion = p.params.ProcessingOptions.ImportEditHistory && isNewItemVersion(it, ir) &&
+			(ir.Modified == nil || p.params.ProcessingOptions.OverwriteModifications)
+		dupPolicy := p.params.ProcessingOptions.IntraImportDuplicates
+		switch {
+		case dupPolicy == DuplicatesError && p.isIntraImportDuplicate(it, ir):
+			processDataFile = false
+			return 0, fmt.Errorf("item %s was already imported earlier in this import (row_id=%d)", it.ID, ir.ID)
+		case dupPolicy == DuplicatesLastWins && p.isIntraImportDuplicate(it, ir):
+			newVersion = false
+			reprocessItem, reprocessDataFile, updateOverrides = true, processDataFile, lastWinsUpdateOverrides()
+			if ir.DataFile != nil && ir.DataHash == nil {
+				// the data file from earlier in this import may still be being written, so leave it be
+				reprocessDataFile = false
+				delete(updateOverrides, "data")
+			}
+		case p.params.ProcessingOptions.AppendMode && p.appendable(it, ir):
+			if ir.DataText != nil && processDataFile {
+				// the data outgrew the database, so store all of it in a data file instead
+				reprocessItem, reprocessDataFile = true, true
+				updateOverrides = map[string]fieldUpdatePolicy{"data": updatePolicyOverwriteExisting}
+				break
+			}
+			processDataFile = false
+			return p.appendItemData(ctx, tx, it, ir)
+		case newVersion:
+			reprocessItem, updateOverrides = true, editHistoryUpdateOverrides(it)
+		default:
+			reprocessItem, reprocessDataFile, updateOverrides = p.shouldProcessExistingItem(tx, it, ir, processDataFile)
+		}
 		if !reprocessItem {
 			// don't confuse phase 2 which downloads data files, by setting
 			// a reader (above) but not a writer (below), so make sure the
@@ -506,6 +779,7 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 			processDataFile = false
 
 			atomic.AddInt64(p.skippedItemCount, 1)
+			p.countSkip(p.existingItemSkipReason(ir))
 			p.log.Debug("skipping processing of existing item",
 				zap.Int64("row_id", ir.ID),
 				zap.String("filename", it.Content.Filename),
@@ -517,7 +791,7 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 		// if we are in fact processing this data file, move any old one out of the way temporarily
 		// as a safe measure, and also because our filename-generator will not allow a file to be
 		// overwritten, but we want to replace the existing file in this case...
-		if processDataFile && ir.DataFile != nil {
+		if processDataFile && ir.DataFile != nil && !isExternalDataFile(*ir.DataFile) {
 			origFile := p.tl.FullPath(*ir.DataFile)
 			bakFile := p.tl.FullPath(*ir.DataFile + ".bak")
 			err = os.Rename(origFile, bakFile)
@@ -551,15 +825,64 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 	}
 
 	// get the filename for the data file if we are processing it
+	// (unless it is referenced in place, in which case it is only hashed)
 	if processDataFile {
-		it.dataFileOut, it.dataFileName, err = p.tl.openUniqueCanonicalItemDataFile(tx, p.log, it, p.ds.Name)
+		// if storing the item fails from here on, undo both the row and the data
+		// file that it claims, so that neither is left behind without the other
+		if _, err = tx.Exec(`SAVEPOINT store_item`); err != nil {
+			return 0, fmt.Errorf("beginning savepoint for item: %v", err)
+		}
+		defer func() {
+			if err == nil {
+				return
+			}
+			if _, err := tx.Exec(`ROLLBACK TO store_item`); err != nil {
+				p.log.Error("rolling back item", zap.String("item_id", it.ID), zap.Error(err))
+			}
+			if _, err := tx.Exec(`RELEASE store_item`); err != nil {
+				p.log.Error("releasing savepoint for item", zap.String("item_id", it.ID), zap.Error(err))
+			}
+			if it.dataFileOut != nil {
+				it.dataFileOut.Close()
+				if err := os.Remove(it.dataFileOut.Name()); err != nil {
+					p.log.Error("deleting unused data file", zap.String("filename", it.dataFileOut.Name()), zap.Error(err))
+				}
+				it.dataFileOut = nil
+			}
+			it.dataFileName = ""
+			if it.dataFileIn != nil {
+				it.dataFileIn.Close()
+				it.dataFileIn = nil
+			}
+		}()
+
+		it.dataFileName, err = p.externalDataFile(it)
 		if err != nil {
-			return 0, fmt.Errorf("opening output data file: %v", err)
+			return 0, fmt.Errorf("%w (item_id=%s)", err, it.ID)
+		}
+		if it.dataFileName == "" {
+			it.dataFileOut, it.dataFileName, err = p.tl.openUniqueCanonicalItemDataFile(tx, p.log, it, p.ds.Name, p.params.DataFileNamer)
+			if err != nil {
+				return 0, fmt.Errorf("opening output data file: %v", err)
+			}
+			// the placeholder only claims the name, and it isn't written to (the
+			// file is written separately, then moved into place), so don't hold
+			// its descriptor for the rest of the batch
+			it.dataFileOut.Close()
 		}
 	}
 
 	// make a copy of this 'cause we might use it later to clean up a data file if we ended up setting it to NULL
 	startingDataFile := ir.DataFile
+	inserting := ir.ID == 0
+	it.insertedRow = inserting
+
+	// preserve the existing version of the item before it gets replaced
+	if newVersion {
+		if err = snapshotItemVersion(ctx, tx, ir.ID); err != nil {
+			return 0, fmt.Errorf("saving previous version of item: %v (row_id=%d)", err, ir.ID)
+		}
+	}
 
 	err = p.fillItemRow(ctx, tx, &ir, it)
 	if err != nil {
@@ -576,6 +899,25 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 		return 0, fmt.Errorf("storing item in database: %v (row_id=%d item_id=%v)", err, ir.ID, ir.OriginalID)
 	}
 
+	if vis := p.itemVisibility(it); vis != nil && vis.Level == VisibilityShared {
+		if err = storeItemViewers(ctx, tx, ir.ID, vis.SharedWith); err != nil {
+			return 0, fmt.Errorf("storing item viewers: %v (row_id=%d)", err, ir.ID)
+		}
+	}
+
+	// (items with data files are linked once the file's hash is known)
+	if inserting && it.dataText != nil {
+		if err = p.linkSameContent(ctx, tx, ir.ID, it); err != nil {
+			return 0, fmt.Errorf("%w (row_id=%d)", err, ir.ID)
+		}
+	}
+
+	if processDataFile {
+		if _, err = tx.Exec(`RELEASE store_item`); err != nil {
+			return 0, fmt.Errorf("releasing savepoint for item: %v (row_id=%d)", err, ir.ID)
+		}
+	}
+
 	it.row = ir
 
 	return ir.ID, nil
@@ -584,6 +926,12 @@ func (p *processor) storeItem(ctx context.Context, tx *sql.Tx, it *Item) (int64,
 type recursiveState struct {
 	worker  int
 	procOpt ProcessingOptions
+
+	// how many rows (items, entities, and relationships) have been written by the
+	// current transaction, and how many it may write before it is committed and
+	// the batch continues in a new one, so that big graphs don't make oversized
+	// transactions (only used in phase 1)
+	txRows, maxTxRows int
 }
 
 func (p *processor) processRelationship(ctx context.Context, tx *sql.Tx, r Relationship, ig *Graph, rowID latentID, state *recursiveState) (*sql.Tx, error) {
@@ -594,6 +942,19 @@ func (p *processor) processRelationship(ctx context.Context, tx *sql.Tx, r Relat
 		return tx, fmt.Errorf("invalid edge: must have node on both sides: %+v", r)
 	}
 
+	for _, connected := range []*Graph{r.From, r.To} {
+		if connected == nil {
+			continue
+		}
+		drop, err := p.applyMissingReferencePolicy(ctx, tx, connected)
+		if err != nil {
+			return tx, err
+		}
+		if drop {
+			return tx, nil
+		}
+	}
+
 	rawRel := rawRelationship{Relation: r.Relation}
 
 	if r.Value != "" {
@@ -620,7 +981,9 @@ func (p *processor) processRelationship(ctx context.Context, tx *sql.Tx, r Relat
 	// if the relationship explicitly has a "from" node set, use that;
 	// otherwise, assume this node is the "from" side
 	if r.From != nil {
-		connectedRowID, err := p.processGraph(ctx, tx, state, r.From)
+		var connectedRowID latentID
+		var err error
+		connectedRowID, tx, err = p.processGraph(ctx, tx, state, r.From)
 		if err != nil {
 			return tx, fmt.Errorf("from node: %v", err)
 		}
@@ -649,7 +1012,9 @@ func (p *processor) processRelationship(ctx context.Context, tx *sql.Tx, r Relat
 	// if the relationship explicitly has a "to" node set, use that;
 	// otherwise, assume this node is the "to" side
 	if r.To != nil {
-		connectedRowID, err := p.processGraph(ctx, tx, state, r.To)
+		var connectedRowID latentID
+		var err error
+		connectedRowID, tx, err = p.processGraph(ctx, tx, state, r.To)
 		if err != nil {
 			return tx, fmt.Errorf("to node: %v", err)
 		}
@@ -680,37 +1045,76 @@ func (p *processor) processRelationship(ctx context.Context, tx *sql.Tx, r Relat
 	if err != nil {
 		return tx, fmt.Errorf("storing relationship: %v", err)
 	}
+	state.txRows++
+
+	// a graph can have many more edges than the batch has graphs, so
+	// don't let it grow the transaction without bound
+	if state.maxTxRows > 0 && state.txRows >= state.maxTxRows {
+		return p.continueInNewTx(tx, state)
+	}
 
 	return tx, nil
 }
 
+// continueInNewTx commits tx and returns a new transaction to continue the
+// batch with. Since the processing of the graph isn't finished, its
+// checkpoint (if any) isn't saved until a later transaction.
+func (p *processor) continueInNewTx(tx *sql.Tx, state *recursiveState) (*sql.Tx, error) {
+	if err := tx.Commit(); err != nil {
+		return tx, fmt.Errorf("committing partial transaction for batch: %v", err)
+	}
+	p.recordCommitSize(state.txRows)
+	p.log.Debug("transaction reached maximum size; continuing batch in new transaction",
+		zap.Int("worker", state.worker),
+		zap.Int("rows", state.txRows))
+	state.txRows = 0
+
+	newTx, err := p.tl.db.Begin()
+	if err != nil {
+		return tx, fmt.Errorf("beginning new transaction for batch: %v", err)
+	}
+	return newTx, nil
+}
+
 func (tl *Timeline) cleanDataFile(tx *sql.Tx, dataFilePath string) error {
 	var count int
 	err := tx.QueryRow(`SELECT count() FROM items WHERE data_file=? LIMIT 1`, dataFilePath).Scan(&count)
 	if err != nil {
 		return fmt.Errorf("querying to check if data file is unused: %v", err)
 	}
-	if count > 0 {
+	if count > 0 || isExternalDataFile(dataFilePath) {
 		return nil
 	}
 	if err := os.Remove(tl.FullPath(dataFilePath)); err != nil {
 		return fmt.Errorf("deleting unused data file: %v", err)
 	}
-	return nil
+	if err := tl.deleteDerivedDataFiles(tl.ctx, tx, dataFilePath); err != nil {
+		return err
+	}
+	return setDataFileCompression(tx, dataFilePath, "")
 }
 
-func (p *processor) integrityCheck(dbItem ItemRow) error {
+func (p *processor) integrityCheck(tx *sql.Tx, dbItem ItemRow) error {
 	if p.params.ProcessingOptions.Integrity || dbItem.DataFile == nil {
 		return nil
 	}
+	compression, err := dataFileCompression(p.tl.ctx, tx, *dbItem.DataFile)
+	if err != nil {
+		return err
+	}
+	return p.tl.verifyDataFile(*dbItem.DataFile, compression, dbItem.DataHash)
+}
 
+// verifyDataFile ensures the data file exists, can be read, and
+// that its (uncompressed) contents have the expected checksum.
+func (tl *Timeline) verifyDataFile(dataFile string, compression DataFileCompression, expectedHash []byte) error {
 	// expected hash must be set; if missing, data file was not completely downloaded last time
-	if dbItem.DataHash == nil {
+	if expectedHash == nil {
 		return fmt.Errorf("checksum missing")
 	}
 
 	// file must open successfully
-	datafile, err := os.Open(p.tl.FullPath(*dbItem.DataFile))
+	datafile, err := tl.openDataFile(dataFile, compression)
 	if err != nil {
 		return fmt.Errorf("opening existing data file: %w", err)
 	}
@@ -724,8 +1128,8 @@ func (p *processor) integrityCheck(dbItem ItemRow) error {
 	}
 
 	// file checksum must be identical
-	if itemHash := h.Sum(nil); !bytes.Equal(itemHash, dbItem.DataHash) {
-		return fmt.Errorf("checksum mismatch (expected=%x actual=%x)", dbItem.DataHash, itemHash)
+	if itemHash := h.Sum(nil); !bytes.Equal(itemHash, expectedHash) {
+		return fmt.Errorf("checksum mismatch (expected=%x actual=%x)", expectedHash, itemHash)
 	}
 
 	return nil
@@ -735,7 +1139,7 @@ func (p *processor) integrityCheck(dbItem ItemRow) error {
 // item in the database. It returns true for item if the whole item should be reprocessed, and
 // it returns true for dataFile if at least the dataFile should be processed.
 // Valid return values: false false, true false, true true.
-func (p *processor) shouldProcessExistingItem(it *Item, dbItem ItemRow, dataFileIncoming bool) (item bool, dataFile bool, updateOverrides map[string]fieldUpdatePolicy) {
+func (p *processor) shouldProcessExistingItem(tx *sql.Tx, it *Item, dbItem ItemRow, dataFileIncoming bool) (item bool, dataFile bool, updateOverrides map[string]fieldUpdatePolicy) {
 	// An item may be referenced by the data source more than once, and thus the same item may be processed concurrently;
 	// when this happens, multiple data files are created in the repo: the first will presumably have the original filename,
 	// while the later ones will have random strings appended. The problem is if a later one end up finishing first, the
@@ -776,45 +1180,7 @@ func (p *processor) shouldProcessExistingItem(it *Item, dbItem ItemRow, dataFile
 	// of the item's information -- so if our current item row is missing information, we can at
 	// least safely add new info I think
 	if dbItem.ImportID != nil && *dbItem.ImportID == p.impRow.id {
-		updateOverrides = make(map[string]fieldUpdatePolicy)
-
-		// if there's an incoming data file and we don't have one, then update
-		if dbItem.DataText == nil && dbItem.DataFile == nil && (it.dataText != nil || it.Content.Data != nil) {
-			dataFile = true
-			updateOverrides["data"] = updatePolicyPreferIncoming
-		}
-
-		// reprocess the item row if there's new data to be added
-		if (dbItem.Latitude == nil && it.Location.Latitude != nil) ||
-			(dbItem.Longitude == nil && it.Location.Longitude != nil) ||
-			(dbItem.Altitude == nil && it.Location.Altitude != nil) {
-			updateOverrides["location"] = updatePolicyPreferIncoming
-		}
-		if (dbItem.Timestamp == nil || dbItem.TimeOffset == nil) && !it.Timestamp.IsZero() {
-			updateOverrides["timestamp"] = updatePolicyPreferIncoming
-		}
-		if dbItem.Timespan == nil && !it.Timespan.IsZero() {
-			updateOverrides["timespan"] = updatePolicyPreferIncoming
-		}
-		if dbItem.Timeframe == nil && !it.Timeframe.IsZero() {
-			updateOverrides["timeframe"] = updatePolicyPreferIncoming
-		}
-		if dbItem.Filename == nil && it.Content.Filename != "" {
-			updateOverrides["filename"] = updatePolicyPreferIncoming
-		}
-		if dbItem.Classification == nil && it.Classification.Name != "" {
-			updateOverrides["classification_id"] = updatePolicyPreferIncoming
-		}
-		if dbItem.OriginalLocation == nil && it.OriginalLocation != "" {
-			updateOverrides["original_location"] = updatePolicyPreferIncoming
-		}
-		if dbItem.OriginalID == nil && it.ID != "" {
-			updateOverrides["original_id"] = updatePolicyPreferIncoming
-		}
-		if len(it.Metadata) > 0 {
-			updateOverrides["metadata"] = updatePolicyOverwriteExisting
-		}
-
+		updateOverrides, dataFile = missingFieldUpdateOverrides(it, dbItem)
 		item = len(updateOverrides) > 0
 
 		if !item {
@@ -831,7 +1197,7 @@ func (p *processor) shouldProcessExistingItem(it *Item, dbItem ItemRow, dataFile
 	// provide the whole item in one import, so in that case, always reprocess, but make sure
 	// to account for the update overrides specified by the data source
 	if len(it.Retrieval.key) > 0 {
-		updateOverrides = make(map[string]fieldUpdatePolicy)
+		updateOverrides, _ = missingFieldUpdateOverrides(it, dbItem)
 		for _, field := range it.Retrieval.PreferFields {
 			updateOverrides[field] = updatePolicyOverwriteExisting
 		}
@@ -842,7 +1208,7 @@ func (p *processor) shouldProcessExistingItem(it *Item, dbItem ItemRow, dataFile
 	// perform integrity check (no-op if not enabled) and log if it fails;
 	// we'll decide what to do about it next; but writing the logs can be
 	// important even if no data file is incoming
-	integrityCheckErr := p.integrityCheck(dbItem)
+	integrityCheckErr := p.integrityCheck(tx, dbItem)
 	if integrityCheckErr != nil {
 		// this sometimes happens when an item/file is referenced more than once and
 		// is currently being processed, and has been inserted into the DB, but the
@@ -916,10 +1282,11 @@ func (p *processor) shouldProcessExistingItem(it *Item, dbItem ItemRow, dataFile
 	// fields to update -- together with their policies -- would mean that no values in
 	// the DB would actually be changed
 
-	// if the item in the DB is basically empty, go ahead and reprocess
+	// if the item in the DB is basically empty (for example, a placeholder for
+	// an item that was referenced before it was imported), fill it in
 	if !dbItem.hasContent() {
-		item = true
-		return
+		updateOverrides, dataFile = missingFieldUpdateOverrides(it, dbItem)
+		return true, dataFile && dataFileIncoming, updateOverrides
 	}
 
 	// finally, if the user has configured/enabled updates, reprocess the item
@@ -928,6 +1295,55 @@ func (p *processor) shouldProcessExistingItem(it *Item, dbItem ItemRow, dataFile
 	return item || dataFile, dataFile, nil
 }
 
+// missingFieldUpdateOverrides returns update policies that fill in the fields of the
+// item row that are missing but which the incoming item has. It also returns true
+// if the incoming item has data but the row doesn't.
+func missingFieldUpdateOverrides(it *Item, dbItem ItemRow) (updateOverrides map[string]fieldUpdatePolicy, dataFile bool) {
+	updateOverrides = make(map[string]fieldUpdatePolicy)
+
+	// if there's an incoming data file and we don't have one, then update
+	if dbItem.DataText == nil && dbItem.DataFile == nil && (it.dataText != nil || it.Content.Data != nil) {
+		dataFile = true
+		updateOverrides["data"] = updatePolicyPreferIncoming
+	}
+
+	// reprocess the item row if there's new data to be added
+	if (dbItem.Latitude == nil && it.Location.Latitude != nil) ||
+		(dbItem.Longitude == nil && it.Location.Longitude != nil) ||
+		(dbItem.Altitude == nil && it.Location.Altitude != nil) {
+		updateOverrides["location"] = updatePolicyPreferIncoming
+	}
+	if (dbItem.Timestamp == nil || dbItem.TimeOffset == nil) && !it.Timestamp.IsZero() {
+		updateOverrides["timestamp"] = updatePolicyPreferIncoming
+	}
+	if dbItem.Timespan == nil && !it.Timespan.IsZero() {
+		updateOverrides["timespan"] = updatePolicyPreferIncoming
+	}
+	if dbItem.Timeframe == nil && !it.Timeframe.IsZero() {
+		updateOverrides["timeframe"] = updatePolicyPreferIncoming
+	}
+	if dbItem.Filename == nil && it.Content.Filename != "" {
+		updateOverrides["filename"] = updatePolicyPreferIncoming
+	}
+	if dbItem.Classification == nil && it.Classification.Name != "" {
+		updateOverrides["classification_id"] = updatePolicyPreferIncoming
+	}
+	if dbItem.Category == nil {
+		updateOverrides["category"] = updatePolicyPreferIncoming
+	}
+	if dbItem.OriginalLocation == nil && it.OriginalLocation != "" {
+		updateOverrides["original_location"] = updatePolicyPreferIncoming
+	}
+	if dbItem.OriginalID == nil && it.ID != "" {
+		updateOverrides["original_id"] = updatePolicyPreferIncoming
+	}
+	if len(it.Metadata) > 0 {
+		updateOverrides["metadata"] = updatePolicyOverwriteExisting
+	}
+
+	return updateOverrides, dataFile
+}
+
 func (p *processor) fillItemRow(ctx context.Context, tx *sql.Tx, ir *ItemRow, it *Item) error {
 	// unpack the item's information into values to use in the row
 
@@ -976,6 +1392,9 @@ func (p *processor) fillItemRow(ctx context.Context, tx *sql.Tx, ir *ItemRow, it
 	if clID != 0 {
 		ir.ClassificationID = &clID
 	}
+	if category := p.itemCategory(ctx, it); category != "" {
+		ir.Category = &category
+	}
 	if it.ID != "" {
 		ir.OriginalID = &it.ID
 	}
@@ -1001,6 +1420,9 @@ func (p *processor) fillItemRow(ctx context.Context, tx *sql.Tx, ir *ItemRow, it
 	if !it.Timeframe.IsZero() {
 		ir.Timeframe = &it.Timeframe
 	}
+	if p.params.ProcessingOptions.FutureTimestamps.clamps() {
+		clampFutureTimestamp(ir, time.Now())
+	}
 	if it.TimeUncertainty > 0 {
 		uncert := int64(it.TimeUncertainty * time.Millisecond)
 		ir.TimeUncertainty = &uncert
@@ -1008,10 +1430,20 @@ func (p *processor) fillItemRow(ctx context.Context, tx *sql.Tx, ir *ItemRow, it
 		generalUncert := int64(it.TimeUncertainty)
 		ir.TimeUncertainty = &generalUncert
 	}
+	if it.Sequence != 0 {
+		ir.Sequence = &it.Sequence
+	}
+	if it.sourceFile != "" {
+		ir.SourceFile = &it.sourceFile
+		if it.sourceOffset != 0 {
+			ir.SourceOffset = &it.sourceOffset
+		}
+	}
 	if it.Content.MediaType != "" {
 		ir.DataType = &it.Content.MediaType
 	}
 	ir.DataText = it.dataText
+	ir.NormalizedText = it.normalizedText
 	if it.dataFileName != "" {
 		// BIG TIME bug fix :)
 		// When deduplicating data files, if this is not a copy of the dataFileName, then we end up not
@@ -1024,6 +1456,12 @@ func (p *processor) fillItemRow(ctx context.Context, tx *sql.Tx, ir *ItemRow, it
 	}
 	ir.Metadata = metadata
 	ir.Location = it.Location
+	if vis := p.itemVisibility(it); vis != nil {
+		if err := vis.validate(); err != nil {
+			return err
+		}
+		ir.Visibility = &vis.Level
+	}
 
 	// enforce valid timestamp and timespan values
 	if ir.Timespan != nil {
@@ -1043,6 +1481,11 @@ func (p *processor) fillItemRow(ctx context.Context, tx *sql.Tx, ir *ItemRow, it
 
 	ir.RetrievalKey = it.Retrieval.key
 
+	// an item's global ID never changes once it has one
+	if ir.GlobalID == nil && it.GlobalID != "" {
+		ir.GlobalID = &it.GlobalID
+	}
+
 	return nil
 }
 
@@ -1054,9 +1497,12 @@ func (p *processor) fillItemRow(ctx context.Context, tx *sql.Tx, ir *ItemRow, it
 // find the specific item. The most specific, exact matches should use all the available fields
 // to match; if no columns are specified, then it is an error if the item does not have an
 // original ID. If the original ID is specified, the sole criteria used to look up a unique item
-// is the data source and the original ID.
+// is the data source and the original ID (and the account, if accountID is set). With the global
+// dedup scope, an item with identical initial content from any data source is also a match.
+// If timeAware is set, items with the same data only match if their timestamps are within its
+// window (unless the timestamp is a unique constraint itself, in which case they must be equal).
 // TODO: checkDeleted is more like "use hashes to retrieve rows for deduplication purposes"
-func (tl *Timeline) loadItemRow(ctx context.Context, tx *sql.Tx, rowID int64, it *Item, dataSourceName *string, uniqueConstraints map[string]bool, checkDeleted bool) (ItemRow, error) {
+func (tl *Timeline) loadItemRow(ctx context.Context, tx *sql.Tx, rowID int64, it *Item, dataSourceName *string, scope DedupScope, accountID *int64, uniqueConstraints map[string]bool, timeAware *TimeAwareDedup, checkDeleted bool) (ItemRow, error) {
 	var sb strings.Builder
 
 	sb.WriteString("SELECT ")
@@ -1076,19 +1522,56 @@ func (tl *Timeline) loadItemRow(ctx context.Context, tx *sql.Tx, rowID int64, it
 		// any results OR if no original ID was provided, we use the long-form query that
 		// compares every configured field.
 
-		if dataSourceName != nil && it.ID != "" {
-			row := tx.QueryRow(`SELECT `+itemDBColumns+`
-				FROM extended_items AS items
-				WHERE data_source_name=? AND original_id=?
-				LIMIT 1`, dataSourceName, &it.ID)
-			ir, err := scanItemRow(row, nil)
+		// an item that already has a global ID (i.e. it was exported from
+		// a timeline) is the same item wherever it is imported
+		if it.GlobalID != "" {
+			ir, err := itemRowByGlobalID(ctx, tx, it.GlobalID)
 			if err == nil {
 				return ir, nil
 			}
-			if !errors.Is(err, sql.ErrNoRows) {
-				return ItemRow{}, fmt.Errorf("querying by original id: %w", err)
+			if !errors.Is(err, ErrItemNotFound) {
+				return ItemRow{}, fmt.Errorf("querying by global id: %w", err)
+			}
+		}
+
+		// an item with the same content from any data source is the same item in the global scope
+		byContent := func() (ItemRow, error) {
+			if scope != DedupScopeGlobal || len(it.contentHash) == 0 {
+				return ItemRow{}, nil
+			}
+			ir, err := itemRowByContentHash(ctx, tx, it.contentHash)
+			if errors.Is(err, ErrItemNotFound) {
+				return ItemRow{}, nil
+			}
+			if err != nil {
+				return ItemRow{}, fmt.Errorf("querying by content hash: %w", err)
+			}
+			return ir, nil
+		}
+
+		if dataSourceName != nil && it.ID != "" {
+			var dedupAccountID int64
+			if accountID != nil {
+				dedupAccountID = *accountID
+			}
+			ir, err := itemRowByOriginalID(ctx, tx, *dataSourceName, dedupAccountID, it.ID)
+			if errors.Is(err, ErrItemNotFound) {
+				return byContent() // if not found, it's a new item
+			}
+			if err == nil {
+				return ir, nil
 			}
-		} else if len(uniqueConstraints) == 0 {
+			return ItemRow{}, fmt.Errorf("querying by original id: %w", err)
+		}
+		if len(uniqueConstraints) == 0 && len(it.Retrieval.key) > 0 {
+			// an item being imported piecewise can be found by its retrieval key alone
+			sb.WriteString("retrieval_key=? LIMIT 1")
+			return scanItemRow(tx.QueryRowContext(ctx, sb.String(), it.Retrieval.key), nil)
+		}
+		if ir, err := byContent(); err != nil || ir.ID > 0 {
+			return ir, err
+		}
+		if len(uniqueConstraints) == 0 {
 			// if no fields were specified (by mistake?), this could be problematic
 			// as it would match any item with the same data source, I think
 			return ItemRow{}, fmt.Errorf("missing unique constraints; at least 1 required when no original ID specified")
@@ -1123,6 +1606,9 @@ func (tl *Timeline) loadItemRow(ctx context.Context, tx *sql.Tx, rowID int64, it
 			}
 		}
 
+		// identical data at distant times may be distinct items (such as a forwarded message)
+		_, hasTimestampConstraint := uniqueConstraints["timestamp"]
+
 		// iterate each field to be selected on to finish building WHERE clause
 		firstIter := true
 		for field, strictNull := range uniqueConstraints {
@@ -1144,9 +1630,23 @@ func (tl *Timeline) loadItemRow(ctx context.Context, tx *sql.Tx, rowID int64, it
 
 			switch field {
 			case "data":
-				sb.WriteString("(data_text=? OR (data_text IS NULL ")
+				sb.WriteString("(data_text=? OR ")
+				// if we have normalized text, equivalent text in any encoding is the same
+				if it.normalizedText != nil {
+					sb.WriteString("normalized_text=? OR ")
+				}
+				sb.WriteString("(data_text IS NULL ")
 				sb.WriteString(op)
 				sb.WriteString(" ? IS NULL)) AND (data_hash=? OR ? IS NULL)")
+				if timeAware != nil && !hasTimestampConstraint {
+					sb.WriteString(" AND (timestamp IS NULL OR ? IS NULL OR abs(timestamp - ?) <= ?)")
+				}
+			case "timestamp":
+				// a clamped timestamp is matched by the timestamp the data source gave
+				// (sub-millisecond precision, if any, must match too)
+				sb.WriteString("((timestamp=? AND timestamp_micros IS ?) OR original_timestamp=? OR (timestamp IS NULL ")
+				sb.WriteString(op)
+				sb.WriteString(" ? IS NULL))")
 			case "location":
 				sb.WriteString("(longitude=? OR (longitude IS NULL ")
 				sb.WriteString(op)
@@ -1196,7 +1696,7 @@ func (tl *Timeline) loadItemRow(ctx context.Context, tx *sql.Tx, rowID int64, it
 				args = append(args, filename, filename)
 			case "timestamp":
 				timestamp := it.timestampUnix()
-				args = append(args, timestamp, timestamp)
+				args = append(args, timestamp, it.timestampMicros(), timestamp, timestamp)
 			case "timespan":
 				timespan := it.timespanUnix()
 				args = append(args, timespan, timespan)
@@ -1204,9 +1704,17 @@ func (tl *Timeline) loadItemRow(ctx context.Context, tx *sql.Tx, rowID int64, it
 				timeframe := it.timeframeUnix()
 				args = append(args, timeframe, timeframe)
 			case "data":
+				args = append(args, it.dataText)
+				if it.normalizedText != nil {
+					args = append(args, it.normalizedText)
+				}
 				args = append(args,
-					it.dataText, it.dataText,
+					it.dataText,
 					it.dataFileHash, it.dataFileHash)
+				if timeAware != nil && !hasTimestampConstraint {
+					timestamp := it.timestampUnix()
+					args = append(args, timestamp, timestamp, timeAware.Window.Milliseconds())
+				}
 			case "data_type", "data_text", "data_hash":
 				return ItemRow{}, fmt.Errorf("cannot select on specific components of item data such as text or file hash; specify 'data' instead")
 			case "location":
@@ -1250,24 +1758,27 @@ func (p *processor) insertOrUpdateItem(ctx context.Context, tx *sql.Tx, ir ItemR
 
 		err := tx.QueryRowContext(ctx,
 			`INSERT INTO items
-				(data_source_id, import_id, attribute_id, classification_id,
-				original_id, original_location, intermediate_location, filename,
-				timestamp, timespan, timeframe, time_offset, time_uncertainty,
-				data_type, data_text, data_file, data_hash, metadata,
+				(data_source_id, import_id, attribute_id, classification_id, category,
+				original_id, account_id, original_location, intermediate_location, filename,
+				timestamp, timestamp_micros, original_timestamp, timespan, timeframe, time_offset, time_uncertainty, sequence, source_file, source_offset,
+				data_type, data_text, normalized_text, data_file, data_hash, metadata,
 				longitude, latitude, altitude, coordinate_system, coordinate_uncertainty,
-				note, starred, original_id_hash, initial_content_hash, retrieval_key)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				note, starred, visibility, original_id_hash, initial_content_hash, retrieval_key, global_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			RETURNING id`,
-			ir.DataSourceID, ir.ImportID, ir.AttributeID, ir.ClassificationID,
-			ir.OriginalID, ir.OriginalLocation, ir.IntermediateLocation, ir.Filename,
-			ir.timestampUnix(), ir.timespanUnix(), ir.timeframeUnix(), ir.TimeOffset, ir.TimeUncertainty,
-			ir.DataType, ir.DataText, ir.DataFile, ir.DataHash, string(ir.Metadata),
+			ir.DataSourceID, ir.ImportID, ir.AttributeID, ir.ClassificationID, ir.Category,
+			ir.OriginalID, p.dedupAccountID(), ir.OriginalLocation, ir.IntermediateLocation, ir.Filename,
+			ir.timestampUnix(), ir.timestampMicros(), ir.originalTimestampUnix(), ir.timespanUnix(), ir.timeframeUnix(), ir.TimeOffset, ir.TimeUncertainty, ir.Sequence, ir.SourceFile, ir.SourceOffset,
+			ir.DataType, ir.DataText, ir.NormalizedText, ir.DataFile, ir.DataHash, string(ir.Metadata),
 			ir.Location.Longitude, ir.Location.Latitude, ir.Location.Altitude,
 			ir.Location.CoordinateSystem, ir.Location.CoordinateUncertainty,
-			ir.Note, ir.Starred, ir.OriginalIDHash, ir.InitialContentHash, ir.RetrievalKey,
+			ir.Note, ir.Starred, ir.Visibility, ir.OriginalIDHash, ir.InitialContentHash, ir.RetrievalKey, p.tl.newGlobalItemID(ir.GlobalID),
 		).Scan(&rowID)
 
 		atomic.AddInt64(p.newItemCount, 1)
+		if err == nil {
+			p.insertedItems = append(p.insertedItems, rowID)
+		}
 
 		return rowID, err
 	}
@@ -1330,8 +1841,14 @@ func (p *processor) insertOrUpdateItem(ctx context.Context, tx *sql.Tx, ir ItemR
 		case "data":
 			appendToQuery("data_type", policy)
 			appendToQuery("data_text", policy)
+			appendToQuery("normalized_text", policy)
 			appendToQuery("data_file", policy)
 			appendToQuery("data_hash", policy)
+			sb.WriteString(", data_length=NULL") // data may have been replaced, so any appended length no longer applies
+		case "timestamp":
+			appendToQuery("timestamp", policy)
+			appendToQuery("timestamp_micros", policy)
+			appendToQuery("original_timestamp", policy)
 		case "location":
 			appendToQuery("longitude", policy)
 			appendToQuery("latitude", policy)
@@ -1347,6 +1864,8 @@ func (p *processor) insertOrUpdateItem(ctx context.Context, tx *sql.Tx, ir ItemR
 			args = append(args, ir.AttributeID)
 		case "classification_id":
 			args = append(args, ir.ClassificationID)
+		case "category":
+			args = append(args, ir.Category)
 		case "original_location":
 			args = append(args, ir.OriginalLocation)
 		case "intermediate_location":
@@ -1355,6 +1874,8 @@ func (p *processor) insertOrUpdateItem(ctx context.Context, tx *sql.Tx, ir ItemR
 			args = append(args, ir.Filename)
 		case "timestamp":
 			args = append(args, ir.timestampUnix())
+			args = append(args, ir.timestampMicros())
+			args = append(args, ir.originalTimestampUnix())
 		case "timespan":
 			args = append(args, ir.timespanUnix())
 		case "timeframe":
@@ -1363,9 +1884,16 @@ func (p *processor) insertOrUpdateItem(ctx context.Context, tx *sql.Tx, ir ItemR
 			args = append(args, ir.TimeOffset)
 		case "time_uncertainty":
 			args = append(args, ir.TimeUncertainty)
+		case "sequence":
+			args = append(args, ir.Sequence)
+		case "source_file":
+			args = append(args, ir.SourceFile)
+		case "source_offset":
+			args = append(args, ir.SourceOffset)
 		case "data":
 			args = append(args, ir.DataType)
 			args = append(args, ir.DataText)
+			args = append(args, ir.NormalizedText)
 			args = append(args, ir.DataFile)
 			args = append(args, ir.DataHash)
 		case "data_type", "data_text", "data_file", "data_hash":
@@ -1385,6 +1913,8 @@ func (p *processor) insertOrUpdateItem(ctx context.Context, tx *sql.Tx, ir ItemR
 			args = append(args, ir.Note)
 		case "starred":
 			args = append(args, ir.Starred)
+		case "visibility":
+			args = append(args, ir.Visibility)
 		default:
 			return fmt.Errorf("unrecognized field with update policy %v: %s", policy, field)
 		}
@@ -1432,6 +1962,7 @@ func (p *processor) insertOrUpdateItem(ctx context.Context, tx *sql.Tx, ir ItemR
 	}
 
 	atomic.AddInt64(p.updatedItemCount, 1)
+	p.updatedItems = append(p.updatedItems, ir.ID)
 
 	return ir.ID, nil
 }
@@ -1521,9 +2052,9 @@ var sizePeekBufPool = sync.Pool{
 	},
 }
 
-// maxTextSizeForDB is the maximum size of text data we want
-// to store in the DB. Sqlite doesn't have a limit per-se, but
-// it's not comfortable to store huge text files in the DB,
-// they belong in files; we just want to avoid lots of little
-// text files on disk.
+// maxTextSizeForDB is the default maximum size of text data we
+// want to store in the DB (see InlineThresholdBytes). Sqlite
+// doesn't have a limit per-se, but it's not comfortable to store
+// huge text files in the DB, they belong in files; we just want
+// to avoid lots of little text files on disk.
 const maxTextSizeForDB = 1024 * 1024
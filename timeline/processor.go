@@ -26,6 +26,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -39,53 +40,81 @@ import (
 type processor struct {
 	// accessed atomically (align on 64-bit word boundary, for 32-bit systems)
 	itemCount, newItemCount, updatedItemCount, skippedItemCount *int64
-	newEntityCount                                              *int64
-
-	tl        *Timeline
-	ds        DataSource
-	dsRowID   int64 // only used directly with DB to reduce DB queries; different for every DB
-	acc       Account
-	impRow    importRow
-	params    ImportParameters
-	filenames []string
-	log       *zap.Logger
-	progress  *zap.Logger
+	entityCount, newEntityCount                                 *int64 // entityCount is of entity-only graphs
+	checkpointCount                                             *int64
+	graphCount, failedGraphCount                                *int64 // top-level graphs only
+
+	tl       *Timeline
+	ds       DataSource
+	dsRowID  int64 // only used directly with DB to reduce DB queries; different for every DB
+	acc      Account
+	impRow   importRow
+	params   ImportParameters
+	log      *zap.Logger
+	progress *zap.Logger
 
 	// batching inserts can greatly increase speed
-	batch     []*Graph
-	batchSize int // size is at least len(batch) but edges on a graph can add to it
-	batchMu   *sync.Mutex
+	batch      []*Graph
+	batchSize  int       // size is at least len(batch) but edges on a graph can add to it
+	batchBytes int64     // estimated memory used by the batch (only tracked if there is a memory budget)
+	batchStart time.Time // when the first graph was added to the batch
+	batchMu    *sync.Mutex
+
+	// the estimated memory footprint of the import, if it is limited
+	memory *memoryBudget
+
+	// the number of data files being written at once, if it is limited
+	openFiles *openFileLimiter
+
+	// row IDs of items inserted and updated by the batch in phase 1,
+	// to be published once it is committed (protected by tl.dbMu)
+	insertedItems, updatedItems []int64
+
+	// graphs is the channel the data source sends graphs on; its
+	// length is the number of graphs waiting to be processed
+	graphs chan *Graph
+
+	// durations of recent batch commits, for diagnostics
+	commitLatency *latencyRing
+
+	// the most rows written by a single transaction (accessed atomically)
+	largestCommit *int64
+
+	// the encoded size of the largest checkpoint (accessed atomically)
+	largestCheckpoint *int64
+
+	// what each worker is doing (values are processingPhase; accessed atomically)
+	workerPhases []int32
+
+	// subscribers to the progress of this import, if it has a job ID
+	job *importJob
+
+	// how many items and files were skipped, by reason
+	skipReasons   map[string]int64
+	skipReasonsMu *sync.Mutex
+
+	// how much of the input files the data source has read (only set for file imports)
+	fileProgress *fileProgress
+
+	// scratch directory of the import (see ListingOptions.TempDir)
+	tempDir string
 
 	// allow many concurrent file downloads as they can be massively parallel
 	downloadThrottle chan struct{}
+
+	// cancels the import if too many items fail (only set if there is a failure threshold)
+	abort context.CancelCauseFunc
 }
 
 func (t *Timeline) Import(ctx context.Context, params ImportParameters) error {
-	// ensure data source is compatible with mode of import
-	ds, ok := dataSources[params.DataSourceName]
-	if !ok {
-		return fmt.Errorf("unknown data source: %s", params.DataSourceName)
-	}
-	if len(params.Filenames) > 0 && ds.NewFileImporter == nil {
-		return fmt.Errorf("data source %s does not support importing from files", ds.Name)
-	}
-	if len(params.Filenames) == 0 && ds.NewAPIImporter == nil {
-		return fmt.Errorf("data source %s does not support importing via API", ds.Name)
+	if err := t.checkWritable("import"); err != nil {
+		return err
 	}
 
-	// create or resume import operation
+	// if resuming, the parameters come from the import being resumed
 	var impRow importRow
 	var err error
-	if params.ResumeImportID == 0 {
-		mode := importModeAPI
-		if len(params.Filenames) > 0 {
-			mode = importModeFile
-		}
-		impRow, err = t.newImport(ctx, params.DataSourceName, mode, params.ProcessingOptions, params.AccountID)
-		if err != nil {
-			return fmt.Errorf("creating new import row: %v", err)
-		}
-	} else {
+	if params.ResumeImportID > 0 {
 		impRow, err = t.loadImport(ctx, params.ResumeImportID)
 		if err != nil {
 			return fmt.Errorf("loading existing import row: %v", err)
@@ -94,19 +123,223 @@ func (t *Timeline) Import(ctx context.Context, params ImportParameters) error {
 			return fmt.Errorf("import %d has no checkpoint to resume from", impRow.id)
 		}
 		if params.DataSourceName != "" || params.AccountID != 0 ||
-			len(params.Filenames) > 0 || !params.ProcessingOptions.IsEmpty() ||
-			params.DataSourceOptions != nil {
+			len(params.Filenames) > 0 || params.Archive || !params.ProcessingOptions.IsEmpty() ||
+			params.DataSourceOptions != nil || params.SourceURL != "" || len(params.Labels) > 0 {
 			// no need to specify these; it only risks being different and thus in conflict
 			return fmt.Errorf("pointless to specify any other parameters when resuming import")
 		}
 
 		// adjust parameters to set up resumption
-		params.Filenames = impRow.checkpoint.Filenames
+		params.Filenames, err = t.resumeFilenames(ctx, impRow.id, impRow.checkpoint.Filenames, params.AltFilenames)
+		if err != nil {
+			return err
+		}
 		params.DataSourceName = impRow.dataSourceName
 		if impRow.accountID != nil {
 			params.AccountID = *impRow.accountID
 		}
 		params.ProcessingOptions = impRow.checkpoint.ProcOpt
+		params.Archive = impRow.checkpoint.Archive
+	}
+
+	// ensure data source is compatible with mode of import
+	ds, ok := lookupDataSource(params.DataSourceName)
+	if !ok {
+		return fmt.Errorf("unknown data source: %s", params.DataSourceName)
+	}
+	if len(params.Filenames) > 0 && ds.NewFileImporter == nil {
+		return fmt.Errorf("data source %s does not support importing from files", ds.Name)
+	}
+	if len(params.Filenames) == 0 && ds.NewAPIImporter == nil {
+		return fmt.Errorf("data source %s does not support importing via API", ds.Name)
+	}
+	if err := params.validateArchive(ds); err != nil {
+		return err
+	}
+
+	if params.Webhook != nil {
+		if err := params.Webhook.validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := params.logLevel(); err != nil {
+		return err
+	}
+
+	// fail fast if encrypted files can't be decrypted
+	for _, filename := range params.Filenames {
+		if _, err := checkPassphrase(filename, params.Passphrase); err != nil {
+			return err
+		}
+	}
+
+	untrack, err := t.trackImport(ds.Name)
+	if err != nil {
+		return err
+	}
+	defer untrack()
+
+	// some services don't allow the same account to be used concurrently
+	if ds.AccountImportLimit > 0 && params.AccountID > 0 {
+		release, err := t.acquireAccount(ctx, ds, params)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	// validate the options of a new import (resumed imports were validated when they started)
+	if params.ResumeImportID == 0 {
+		if len(params.AltFilenames) > 0 {
+			return fmt.Errorf("alternate filenames can only be given when resuming an import")
+		}
+		if err := ds.validateOptions(params.DataSourceOptions); err != nil {
+			return err
+		}
+		if err := validateImportLabels(params.Labels); err != nil {
+			return err
+		}
+		if tn := params.ProcessingOptions.TextNormalization; tn != nil {
+			if err := tn.validate(); err != nil {
+				return err
+			}
+		}
+		if vis := params.ProcessingOptions.DefaultVisibility; vis != nil {
+			if err := vis.validate(); err != nil {
+				return fmt.Errorf("default visibility: %w", err)
+			}
+		}
+		if rt := params.ProcessingOptions.RelativeTimeframe; rt != nil {
+			if err := rt.validate(); err != nil {
+				return err
+			}
+			if params.ProcessingOptions.GetLatest || params.ProcessingOptions.Timeframe.Since != nil {
+				return fmt.Errorf("relative timeframe cannot be combined with get latest or a since constraint")
+			}
+		}
+		if qp := params.ProcessingOptions.QuietPeriod; qp != 0 {
+			if qp < 0 {
+				return fmt.Errorf("quiet period cannot be negative: %s", qp)
+			}
+			if !params.ProcessingOptions.GetLatest {
+				return fmt.Errorf("quiet period requires get latest")
+			}
+		}
+		if params.ProcessingOptions.DownloadAttempts < 0 {
+			return fmt.Errorf("download attempts cannot be negative: %d", params.ProcessingOptions.DownloadAttempts)
+		}
+		if err := params.ProcessingOptions.IntraImportDuplicates.validate(); err != nil {
+			return err
+		}
+		if ft := params.ProcessingOptions.FailureThreshold; ft != nil {
+			if err := ft.validate(); err != nil {
+				return err
+			}
+		}
+		if err := params.ProcessingOptions.MissingReferences.validate(); err != nil {
+			return err
+		}
+		if err := params.ProcessingOptions.DataFileFailures.validate(); err != nil {
+			return err
+		}
+		if err := params.ProcessingOptions.validateThumbnailOptions(); err != nil {
+			return err
+		}
+		if err := params.ProcessingOptions.FutureTimestamps.validate(); err != nil {
+			return err
+		}
+		if err := params.ProcessingOptions.DedupScope.validate(); err != nil {
+			return err
+		}
+		if err := params.ProcessingOptions.SymlinkPolicy.validate(); err != nil {
+			return err
+		}
+		if emp := params.ProcessingOptions.EntityMerge; emp != nil {
+			if err := emp.validate(); err != nil {
+				return err
+			}
+		}
+		if tad := params.ProcessingOptions.TimeAwareDedup; tad != nil {
+			if err := tad.validate(); err != nil {
+				return err
+			}
+		}
+		if err := params.ProcessingOptions.TimestampPrecision.validate(); err != nil {
+			return err
+		}
+		if params.ProcessingOptions.ZeroTimestampThreshold < 0 {
+			return fmt.Errorf("zero timestamp threshold cannot be negative: %s", params.ProcessingOptions.ZeroTimestampThreshold)
+		}
+		if params.ProcessingOptions.InlineThresholdBytes < 0 {
+			return fmt.Errorf("inline threshold cannot be negative: %d", params.ProcessingOptions.InlineThresholdBytes)
+		}
+		if params.ProcessingOptions.MaxPendingGraphs < 0 {
+			return fmt.Errorf("maximum pending graphs cannot be negative: %d", params.ProcessingOptions.MaxPendingGraphs)
+		}
+		if rw := params.ProcessingOptions.ReorderWindow; rw < 0 {
+			return fmt.Errorf("reorder window cannot be negative: %d", rw)
+		} else if limit := params.ProcessingOptions.MaxPendingGraphs; limit > 0 && rw >= limit/2 {
+			return fmt.Errorf("reorder window (%d) must be less than half of the maximum pending graphs (%d)", rw, limit)
+		}
+		if params.ProcessingOptions.MemoryBudgetBytes < 0 {
+			return fmt.Errorf("memory budget cannot be negative: %d", params.ProcessingOptions.MemoryBudgetBytes)
+		}
+		if params.ProcessingOptions.MaxOpenFiles < 0 {
+			return fmt.Errorf("maximum open files cannot be negative: %d", params.ProcessingOptions.MaxOpenFiles)
+		}
+		if err := params.ProcessingOptions.CompressDataFiles.validate(); err != nil {
+			return err
+		}
+		if fe := params.ProcessingOptions.FlushEvery; fe != nil {
+			if err := fe.validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// let the caller reject the import before anything is stored
+	if params.PreImportCheck != nil {
+		ic := ImportContext{
+			DataSource:     ds,
+			Filenames:      params.Filenames,
+			ResumeImportID: params.ResumeImportID,
+		}
+		if params.AccountID > 0 {
+			acc, err := t.LoadAccount(ctx, params.AccountID)
+			if err != nil {
+				return err
+			}
+			ic.Account = &acc
+		}
+		if err := params.PreImportCheck(ctx, ic); err != nil {
+			return fmt.Errorf("import rejected: %w", err)
+		}
+	}
+
+	// create new import operation, if not resuming one
+	if params.ResumeImportID == 0 {
+		redactedOpt, err := ds.redactedOptions(params.DataSourceOptions)
+		if err != nil {
+			return err
+		}
+
+		mode := importModeAPI
+		if len(params.Filenames) > 0 {
+			mode = importModeFile
+		}
+		impRow, err = t.newImport(ctx, params.DataSourceName, mode, params.ProcessingOptions, params.AccountID)
+		if err != nil {
+			return fmt.Errorf("creating new import row: %v", err)
+		}
+		if params.SourceURL != "" || redactedOpt != nil {
+			meta := importMetadata{SourceURL: params.SourceURL, DataSourceOptions: redactedOpt}
+			if err := t.setImportMetadata(ctx, impRow.id, meta); err != nil {
+				return err
+			}
+		}
+		if err := t.setImportLabels(ctx, impRow.id, params.Labels); err != nil {
+			return err
+		}
 	}
 
 	return t.doImport(ctx, ds, params, impRow)
@@ -135,42 +368,120 @@ func (t *Timeline) doImport(ctx context.Context, ds DataSource, params ImportPar
 	if len(params.Filenames) > 0 {
 		logger = logger.With(zap.Strings("filenames", params.Filenames))
 	}
+	logLevel, err := params.logLevel()
+	if err != nil {
+		return err
+	}
+	if logLevel != nil {
+		logger = withLevelOverride(logger, *logLevel)
+	}
 
-	dsRowID, ok := t.dataSources[ds.Name]
-	if !ok {
-		return fmt.Errorf("unknown data source: %s", ds.Name)
+	dsRowID, err := t.dataSourceRowID(ctx, ds.Name)
+	if err != nil {
+		return err
 	}
 
 	proc := processor{
-		itemCount:        new(int64),
-		newItemCount:     new(int64),
-		updatedItemCount: new(int64),
-		skippedItemCount: new(int64),
-		newEntityCount:   new(int64),
-		ds:               ds,
-		dsRowID:          dsRowID,
-		params:           params,
-		tl:               t,
-		acc:              acc,
-		impRow:           impRow,
-		log:              logger,
-		progress:         logger.Named("progress"),
-		batchMu:          new(sync.Mutex),
-		downloadThrottle: make(chan struct{}, batchSize*workers*2), // batchSize is a minimum, so multiplier speeds up larger batches
-	}
-
-	return proc.doImport(ctx)
+		itemCount:         new(int64),
+		newItemCount:      new(int64),
+		updatedItemCount:  new(int64),
+		skippedItemCount:  new(int64),
+		skipReasons:       make(map[string]int64),
+		skipReasonsMu:     new(sync.Mutex),
+		graphCount:        new(int64),
+		failedGraphCount:  new(int64),
+		entityCount:       new(int64),
+		newEntityCount:    new(int64),
+		checkpointCount:   new(int64),
+		ds:                ds,
+		dsRowID:           dsRowID,
+		params:            params,
+		tl:                t,
+		acc:               acc,
+		impRow:            impRow,
+		log:               logger,
+		progress:          logger.Named("progress"),
+		batchMu:           new(sync.Mutex),
+		commitLatency:     new(latencyRing),
+		largestCommit:     new(int64),
+		largestCheckpoint: new(int64),
+		workerPhases:      make([]int32, workers),
+		downloadThrottle:  make(chan struct{}, batchSize*workers*2), // batchSize is a minimum, so multiplier speeds up larger batches
+		memory:            newMemoryBudget(params.ProcessingOptions.MemoryBudgetBytes),
+		openFiles:         newOpenFileLimiter(params.ProcessingOptions.MaxOpenFiles),
+	}
+
+	// let others follow along with the progress of this job, if it has an ID
+	if params.JobID != "" {
+		job, err := t.startImportJob(params.JobID)
+		if err != nil {
+			return err
+		}
+		proc.job = job
+	}
+
+	// notify the webhook, if any, of the import's lifecycle
+	var hook *webhookNotifier
+	if params.Webhook != nil {
+		hook = t.startWebhook(*params.Webhook, logger)
+		hook.notify(proc.webhookPayload(WebhookImportStarted, nil))
+	}
+
+	err = proc.doImport(ctx)
+
+	if proc.job != nil {
+		t.finishImportJob(params.JobID, proc.status(), proc.impRow.status, proc.impRow.cancelReason, err)
+	}
+	if hook != nil {
+		event := WebhookImportFinished
+		if err != nil {
+			event = WebhookImportFailed
+		}
+		hook.notify(proc.webhookPayload(event, err))
+		hook.close()
+	}
+
+	return err
 }
 
-func (proc *processor) doImport(ctx context.Context) error {
+func (proc *processor) doImport(ctx context.Context) (err error) {
 	ctx = context.WithValue(ctx, processorCtxKey, proc) // for checkpoints
 
+	// (deferred first, so the import is considered running until its status is updated)
+	defer proc.tl.trackRunningImport(proc.impRow.id)()
+
+	// when we return, update the import row in the DB with the results, even
+	// if there turns out to be nothing to do
+	importResult := "ok"
+	defer func() {
+		proc.impRow.status = importStatus(importResult)
+		if err := proc.saveSkipReasons(); err != nil {
+			proc.log.Error("saving skip reasons", zap.Int64("import_id", proc.impRow.id), zap.Error(err))
+		}
+		var cancelReason *CancelReason
+		if proc.impRow.cancelReason != "" {
+			cancelReason = &proc.impRow.cancelReason
+		}
+		proc.tl.dbMu.Lock()
+		_, err := proc.tl.db.Exec(`UPDATE imports SET ended=?, status=?, cancel_reason=? WHERE id=?`, // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+			time.Now().Unix(), importResult, cancelReason, proc.impRow.id)
+		proc.tl.dbMu.Unlock()
+		if err != nil {
+			proc.log.Error("updating import status",
+				zap.Int64("import_id", proc.impRow.id),
+				zap.String("status", importResult),
+				zap.Error(err))
+		}
+		proc.tl.publish(Event{Topic: TopicImportFinished, ImportID: proc.impRow.id, ImportStatus: importResult})
+	}()
+
 	timeframe := proc.params.ProcessingOptions.Timeframe
 
 	// convert data source options to their concrete type (we know it
 	// only as interface{}, but actual data source can type-assert)
 	dsOpt, err := proc.ds.UnmarshalOptions(proc.params.DataSourceOptions)
 	if err != nil {
+		importResult = "err"
 		return err
 	}
 
@@ -178,6 +489,7 @@ func (proc *processor) doImport(ctx context.Context) error {
 	if proc.params.ProcessingOptions.GetLatest {
 		if len(proc.params.ProcessingOptions.ItemFieldUpdates) > 0 || proc.params.ProcessingOptions.Prune ||
 			proc.params.ProcessingOptions.Integrity || proc.params.ProcessingOptions.Timeframe.Since != nil {
+			importResult = "err"
 			return fmt.Errorf("get latest does not support reprocessing, pruning, integrity checking, and timeframe since constraints")
 		}
 
@@ -190,8 +502,6 @@ func (proc *processor) doImport(ctx context.Context) error {
 		// ensuring it is the last item from the last successful import
 		// (note that )
 		// TODO: in the old schema, we just recorded the item ID, I am not sure if this new query is correct
-		var mostRecentTimestamp *int64
-		var mostRecentOriginalID *string
 		// if proc.acc.lastItemID != nil {
 		// 	proc.tl.dbMu.RLock()
 		// 	err := proc.tl.db.QueryRow(`SELECT timestamp, original_id FROM items WHERE id=? LIMIT 1`, *proc.acc.lastItemID).Scan(&mostRecentTimestamp, &mostRecentOriginalID)
@@ -200,34 +510,51 @@ func (proc *processor) doImport(ctx context.Context) error {
 		// 		return fmt.Errorf("getting most recent item: %v", err)
 		// 	}
 		// }
-		proc.tl.dbMu.RLock()
-		err := proc.tl.db.QueryRow(`
-			SELECT items.original_id, items.timestamp
-			FROM items, imports, data_sources
-			WHERE imports.status=?
-				AND imports.id = items.import_id
-				AND data_sources.id = imports.data_source_id
-				AND data_sources.name = ?
-			ORDER BY imports.started DESC
-			LIMIT 1`, importStatusSuccess, proc.params.DataSourceName).Scan(&mostRecentOriginalID, &mostRecentTimestamp)
-		proc.tl.dbMu.RUnlock()
-		if err != nil && err != sql.ErrNoRows {
-			return fmt.Errorf("getting most recent item: %v", err)
+		mostRecent, err := proc.newestItemOfPreviousImport(ctx, 1, time.Now())
+		if err != nil {
+			importResult = "err"
+			return err
 		}
 
 		// constrain the pull to the recent timeframe
 		timeframe.Until = proc.params.ProcessingOptions.Timeframe.Until
-		if mostRecentTimestamp != nil {
-			ts := time.Unix(*mostRecentTimestamp, 0)
-			timeframe.Since = &ts
-			if timeframe.Until != nil && timeframe.Until.Before(ts) {
+
+		// leave the newest items for the next import if they may not all be
+		// available yet; the cutoff is enforced (and checkpointed) so that
+		// no item newer than it becomes the starting point of the next import
+		if qp := proc.params.ProcessingOptions.QuietPeriod; qp > 0 {
+			cutoff := time.Now().Add(-qp)
+			if timeframe.Until == nil || cutoff.Before(*timeframe.Until) {
+				timeframe.Until = &cutoff
+			}
+			proc.params.ProcessingOptions.Timeframe.Until = timeframe.Until
+		}
+
+		if mostRecent.timestamp != nil {
+			timeframe.Since = mostRecent.timestamp
+			if timeframe.Until != nil && timeframe.Until.Before(*mostRecent.timestamp) {
 				// most recent item is already after "until"/end date; nothing to do
 				return nil
 			}
 		}
-		if mostRecentOriginalID != nil {
-			timeframe.SinceItemID = mostRecentOriginalID
+		if mostRecent.originalID != nil {
+			timeframe.SinceItemID = mostRecent.originalID
+		}
+	}
+
+	// resolve a relative timeframe into concrete bounds, which are then
+	// enforced (and checkpointed) as if they were given explicitly
+	if rt := proc.params.ProcessingOptions.RelativeTimeframe; rt != nil {
+		timeframe, err = proc.resolveRelativeTimeframe(ctx, *rt, timeframe, time.Now())
+		if err != nil {
+			importResult = "err"
+			return err
+		}
+		if timeframe.Since != nil && timeframe.Until != nil && timeframe.Until.Before(*timeframe.Since) {
+			return nil // nothing to do
 		}
+		proc.params.ProcessingOptions.Timeframe = timeframe
+		proc.params.ProcessingOptions.RelativeTimeframe = nil
 	}
 
 	var checkpointData any
@@ -240,6 +567,66 @@ func (proc *processor) doImport(ctx context.Context) error {
 		Timeframe:         timeframe,
 		Checkpoint:        checkpointData,
 		DataSourceOptions: dsOpt,
+		Passphrase:        proc.params.Passphrase,
+	}
+
+	// give the data source a place for scratch files, and clean it up no matter how we return
+	tempDir, removeTempDir, err := proc.makeTempDir()
+	if err != nil {
+		importResult = "err"
+		return err
+	}
+	defer func() { removeTempDir(err != nil || importResult != "ok") }()
+	listOpt.TempDir = tempDir
+	proc.tempDir = tempDir
+
+	// don't bother processing files that are identical to ones we've already imported
+	if len(proc.params.Filenames) > 0 && proc.impRow.checkpoint == nil {
+		if err := proc.skipPreviouslyImportedFiles(ctx); err != nil {
+			importResult = "err"
+			return err
+		}
+		if len(proc.params.Filenames) == 0 {
+			proc.log.Info("all files were already imported; nothing to do",
+				zap.Int64("import_id", proc.impRow.id))
+			return nil
+		}
+	}
+
+	// weigh the progress through the files by their size
+	if len(proc.params.Filenames) > 0 {
+		proc.fileProgress, err = newFileProgress(proc.params.Filenames)
+		if err != nil {
+			proc.log.Warn("unable to determine size of input files; progress will not be reported by size", zap.Error(err))
+		}
+		listOpt.fileProgress = proc.fileProgress
+	}
+
+	// if configured, watch for the import getting stuck
+	if wd := proc.params.ProcessingOptions.Watchdog; wd > 0 {
+		var abort context.CancelCauseFunc
+		ctx, abort = context.WithCancelCause(ctx)
+		defer abort(nil)
+		go proc.watchdog(ctx, wd, abort)
+	}
+
+	// if configured, stop the import once too many items fail
+	if proc.params.ProcessingOptions.FailureThreshold != nil {
+		ctx, proc.abort = context.WithCancelCause(ctx)
+		defer proc.abort(nil)
+	}
+
+	// if configured, don't let the data source run longer than allowed; the
+	// deadline only applies to the data source so that the items it has
+	// already given us can still be processed and checkpointed
+	dsCtx := ctx
+	if maxDur := proc.params.ProcessingOptions.MaxDuration; maxDur > 0 {
+		var cancel context.CancelFunc
+		dsCtx, cancel = context.WithTimeoutCause(ctx, maxDur, DeadlineExceededImport{
+			ImportID:    proc.impRow.id,
+			MaxDuration: maxDur,
+		})
+		defer cancel()
 	}
 
 	start := time.Now()
@@ -248,35 +635,52 @@ func (proc *processor) doImport(ctx context.Context) error {
 	wg, ch := proc.beginProcessing(ctx, proc.params.ProcessingOptions)
 
 	if len(proc.params.Filenames) > 0 {
-		err = proc.ds.NewFileImporter().FileImport(ctx, proc.params.Filenames, ch, listOpt)
+		var filenames []string
+		filenames, listOpt.FS, err = proc.params.fileImportInput(ctx, proc.ds)
+		if err == nil {
+			err = proc.ds.NewFileImporter().FileImport(dsCtx, filenames, ch, listOpt)
+		}
 	} else {
-		err = proc.ds.NewAPIImporter().APIImport(ctx, proc.acc, ch, listOpt)
+		err = proc.ds.NewAPIImporter().APIImport(dsCtx, proc.acc, ch, listOpt)
 	}
 	// handle error in a little bit (see below)
 
 	// we are no longer using this; closing the channel signals to the workers to exit
 	close(ch)
 
-	// when we return, update the import row in the DB with the results
-	importResult := "ok"
-	defer func() {
-		proc.tl.dbMu.Lock()
-		_, err := proc.tl.db.Exec(`UPDATE imports SET ended=?, status=? WHERE id=?`, // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
-			time.Now().Unix(), importResult, proc.impRow.id)
-		proc.tl.dbMu.Unlock()
-		if err != nil {
-			proc.log.Error("updating import status",
-				zap.Int64("import_id", proc.impRow.id),
-				zap.String("status", importResult),
-				zap.Error(err))
-		}
-	}()
+	// if too many items failed, the import is a failure, regardless of how the data source ended
+	var tooManyFailures FailureThresholdExceeded
+	if errors.As(context.Cause(ctx), &tooManyFailures) {
+		importResult = "err"
+		proc.impRow.cancelReason = CancelReasonFailures
+		wg.Wait()
+		return fmt.Errorf("import: %w", tooManyFailures)
+	}
+
+	// if we ran out of time, stop here in a way that the import can be resumed
+	var exceeded DeadlineExceededImport
+	if errors.As(context.Cause(dsCtx), &exceeded) {
+		importResult = importStatusPartial
+		proc.impRow.cancelReason = CancelReasonDeadline
+		return proc.stopAtDeadline(wg, exceeded, start)
+	}
 
 	// handle any error returned from import
 	if err != nil {
+		var stalled StalledImportError
+		if errors.As(context.Cause(ctx), &stalled) {
+			proc.log.Error("import aborted by watchdog",
+				zap.Error(stalled),
+				zap.Duration("duration", time.Since(start)))
+			importResult = "err"
+			proc.impRow.cancelReason = CancelReasonStalled
+			return fmt.Errorf("import: %w", stalled)
+		}
 		if errors.Is(err, context.Canceled) {
+			proc.impRow.cancelReason = proc.canceledReason(ctx)
 			proc.log.Error("import aborted",
 				zap.Error(err),
+				zap.String("reason", string(proc.impRow.cancelReason)),
 				zap.Duration("duration", time.Since(start)))
 			importResult = "abort"
 		} else {
@@ -293,6 +697,13 @@ func (proc *processor) doImport(ctx context.Context) error {
 	// wait for all processing workers to complete
 	wg.Wait()
 
+	// the last items may have been too many failures
+	if err := proc.failureThresholdError(ctx); err != nil {
+		importResult = "err"
+		proc.impRow.cancelReason = CancelReasonFailures
+		return fmt.Errorf("import: %w", err)
+	}
+
 	proc.log.Info("import complete", zap.Duration("duration", time.Since(start)))
 
 	// clear checkpoint and update last item ID for account
@@ -301,11 +712,71 @@ func (proc *processor) doImport(ctx context.Context) error {
 		return fmt.Errorf("processing completed, but error cleaning up: %v", err)
 	}
 
+	// if enabled, fill in items that are still missing their content
+	if proc.params.ProcessingOptions.CompleteItems {
+		_, err := proc.tl.CompleteRetrievalKeyedItems(ctx, CompletionOptions{DataSourceName: proc.ds.Name})
+		if err != nil {
+			proc.log.Error("completing partially-imported items", zap.Error(err))
+		}
+	}
+
 	go proc.generateThumbnailsForImportedItems()
 
 	return nil
 }
 
+// DeadlineExceededImport is the error returned when an import is stopped
+// because it reached its MaxDuration. Unlike a canceled import, it is
+// recorded as partial and can be continued by resuming it.
+type DeadlineExceededImport struct {
+	ImportID    int64         `json:"import_id"`
+	MaxDuration time.Duration `json:"max_duration"`
+}
+
+func (e DeadlineExceededImport) Error() string {
+	return fmt.Sprintf("import %d exceeded maximum duration of %s; it can be resumed", e.ImportID, e.MaxDuration)
+}
+
+func (DeadlineExceededImport) Unwrap() error { return context.DeadlineExceeded }
+
+// stopAtDeadline finishes an import that ran out of time: it waits for the workers
+// to finish processing what the data source gave us, ensures there is a checkpoint
+// to resume from, and starts generating thumbnails for the items imported so far.
+func (p *processor) stopAtDeadline(wg *sync.WaitGroup, exceeded DeadlineExceededImport, start time.Time) error {
+	wg.Wait()
+
+	if err := p.saveResumeCheckpoint(); err != nil {
+		p.log.Error("saving checkpoint to resume import", zap.Error(err))
+	}
+
+	p.log.Warn("import reached maximum duration; stopping",
+		zap.Int64("import_id", p.impRow.id),
+		zap.Duration("max_duration", exceeded.MaxDuration),
+		zap.Duration("duration", time.Since(start)),
+		zap.Int64("items_processed", atomic.LoadInt64(p.itemCount)))
+
+	// this uses the timeline's context, not the import's, so it isn't cut short by the deadline
+	go p.generateThumbnailsForImportedItems()
+
+	return fmt.Errorf("import: %w", exceeded)
+}
+
+// saveResumeCheckpoint saves a checkpoint with the import's parameters so that it can
+// be resumed, if the data source did not already save a checkpoint of its own. Without
+// the data source's checkpoint data, the resumed import starts over from the beginning,
+// but items that were already imported will be recognized and skipped.
+func (p *processor) saveResumeCheckpoint() error {
+	chkpt, err := marshalGob(checkpoint{Filenames: p.params.Filenames, Archive: p.params.Archive, ProcOpt: p.params.ProcessingOptions})
+	if err != nil {
+		return err
+	}
+	p.tl.dbMu.Lock()
+	_, err = p.tl.db.Exec(`UPDATE imports SET checkpoint=? WHERE id=? AND checkpoint IS NULL`, // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		chkpt, p.impRow.id)
+	p.tl.dbMu.Unlock()
+	return err
+}
+
 func (p *processor) successCleanup() error {
 	// delete empty items from this import (items with no content and no meaningful relationships)
 	if err := p.deleteEmptyItems(p.impRow.id); err != nil {
@@ -400,14 +871,18 @@ func (p *processor) deleteEmptyItems(importID int64) error {
 		zap.Int("count", len(emptyItems)))
 
 	retention := time.Duration(0)
-	return p.tl.deleteItemRows(p.tl.ctx, emptyItems, false, &retention)
+	_, err = p.tl.deleteItemRows(p.tl.ctx, emptyItems, false, &retention)
+	return err
 }
 
 // DeleteItemRows deletes the item rows specified by their row IDs. If remember is true, the item rows will
-// be hashed, and the hash will be stored with the row,
-func (tl *Timeline) deleteItemRows(ctx context.Context, rowIDs []int64, remember bool, retention *time.Duration) error {
+// be hashed, and the hash will be stored with the row. It returns the number of data files deleted.
+func (tl *Timeline) deleteItemRows(ctx context.Context, rowIDs []int64, remember bool, retention *time.Duration) (int, error) {
 	if len(rowIDs) == 0 {
-		return nil
+		return 0, nil
+	}
+	if err := tl.checkWritable("delete items"); err != nil {
+		return 0, err
 	}
 
 	Log.Info("deleting item rows", zap.Int64s("item_ids", rowIDs))
@@ -417,28 +892,47 @@ func (tl *Timeline) deleteItemRows(ctx context.Context, rowIDs []int64, remember
 
 	tx, err := tl.db.Begin()
 	if err != nil {
-		return fmt.Errorf("beginning transaction: %v", err)
+		return 0, fmt.Errorf("beginning transaction: %v", err)
 	}
 	defer tx.Rollback()
 
+	dataFilesToDelete, err := deleteItemRowsTx(ctx, tx, rowIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	// commit to delete the item from the DB first; even if deleting the data file fails, stray
+	// data files can be cleaned up with a sweep later, whereas if we delete that file first and
+	// then fail to delete from DB, the DB being the ultimate source of truth is now missing data
+	// and we aren't sure whether we need to recover it or finish deleting it... by deleting the
+	// DB row first we can know that we just need to delete the file if there's no row using it
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing deletion transaction: %v", err)
+	}
+
+	deleted, err := tl.deleteDataFiles(ctx, Log, dataFilesToDelete)
+	if err != nil {
+		return deleted, fmt.Errorf("deleting data files (after deleting associated item rows from DB): %v", err)
+	}
+
+	return deleted, nil
+}
+
+// deleteItemRowsTx deletes the item rows specified by their row IDs within tx, and returns
+// the data files that are no longer referenced by any item, to be deleted once tx commits.
+func deleteItemRowsTx(ctx context.Context, tx *sql.Tx, rowIDs []int64) ([]string, error) {
 	var dataFilesToDelete []string
 	for _, rowID := range rowIDs {
 		// before deleting the row, find out whether this item
 		// has a data file and is the only one referencing it
-		var count int
-		var dataFile *string
-		err = tx.QueryRow(`SELECT count(), data_file FROM items
-		WHERE data_file = (SELECT data_file FROM items
-							WHERE id=? AND data_file IS NOT NULL
-							AND data_file != "" LIMIT 1)`,
-			rowID).Scan(&count, &dataFile)
+		dataFile, count, err := dataFileReferences(ctx, tx, rowID)
 		if err != nil {
-			return fmt.Errorf("querying count of rows sharing data file: %v", err)
+			return nil, err
 		}
 
-		_, err = tx.Exec(`DELETE FROM items WHERE id=?`, rowID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		_, err = tx.ExecContext(ctx, `DELETE FROM items WHERE id=?`, rowID) // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
 		if err != nil {
-			return fmt.Errorf("deleting item %d from DB: %v", rowID, err)
+			return nil, fmt.Errorf("deleting item %d from DB: %v", rowID, err)
 		}
 
 		// if this row is the only one that references the data file, we can delete it
@@ -446,26 +940,11 @@ func (tl *Timeline) deleteItemRows(ctx context.Context, rowIDs []int64, remember
 			dataFilesToDelete = append(dataFilesToDelete, *dataFile)
 		}
 	}
-
-	// commit to delete the item from the DB first; even if deleting the data file fails, stray
-	// data files can be cleaned up with a sweep later, whereas if we delete that file first and
-	// then fail to delete from DB, the DB being the ultimate source of truth is now missing data
-	// and we aren't sure whether we need to recover it or finish deleting it... by deleting the
-	// DB row first we can know that we just need to delete the file if there's no row using it
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("committing deletion transaction: %v", err)
-	}
-
-	_, err = tl.deleteDataFiles(ctx, Log, dataFilesToDelete)
-	if err != nil {
-		return fmt.Errorf("deleting data files (after deleting associated item rows from DB): %v", err)
-	}
-
-	return nil
+	return dataFilesToDelete, nil
 }
 
 func (p processor) String() string {
-	accountIDOrFilename := "files:" + strings.Join(p.filenames, ",")
+	accountIDOrFilename := "files:" + strings.Join(p.params.Filenames, ",")
 	if p.acc.ID > 0 {
 		accountIDOrFilename = "account:" + strconv.Itoa(int(p.acc.ID))
 	}
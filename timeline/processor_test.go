@@ -0,0 +1,536 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testDataSourceName = "test_source"
+
+// testFileImport is called by the test data source's FileImport method;
+// tests set it to control what the data source emits.
+var testFileImport func(ctx context.Context, filenames []string, itemChan chan<- *Graph, opt ListingOptions) error
+
+// testFileImports holds what the test data source emits for imports made with
+// importTestItemsWithParams, keyed by the filename the import is given, so that
+// those imports don't depend on (or change) testFileImport.
+var (
+	testFileImports   sync.Map // map[string][]*Item
+	testFileImportSeq atomic.Int64
+)
+
+type testImporter struct{}
+
+func (testImporter) Recognize(_ context.Context, _ []string) (Recognition, error) {
+	return Recognition{}, nil
+}
+
+func (testImporter) FileImport(ctx context.Context, filenames []string, itemChan chan<- *Graph, opt ListingOptions) error {
+	if len(filenames) == 1 {
+		if items, ok := testFileImports.Load(filenames[0]); ok {
+			for _, it := range items.([]*Item) {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+	}
+	if testFileImport == nil {
+		return nil
+	}
+	return testFileImport(ctx, filenames, itemChan, opt)
+}
+
+func init() {
+	err := RegisterDataSource(DataSource{
+		Name:            testDataSourceName,
+		Title:           "Test",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newTestTimeline creates a new, empty timeline that is closed when the test finishes.
+func newTestTimeline(t *testing.T) *Timeline {
+	t.Helper()
+	tl, err := Create(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("creating timeline: %v", err)
+	}
+	t.Cleanup(func() {
+		tl.Close()
+		testFileImport = nil
+	})
+	return tl
+}
+
+// importTestItemsWithParams imports the given items, in order, with the given
+// parameters; the data source is the test data source unless params has one.
+// The parameters may not have filenames.
+func importTestItemsWithParams(t *testing.T, tl *Timeline, params ImportParameters, items ...*Item) {
+	t.Helper()
+	filename := fmt.Sprintf("test_%d", testFileImportSeq.Add(1))
+	testFileImports.Store(filename, items)
+	defer testFileImports.Delete(filename)
+
+	if params.DataSourceName == "" {
+		params.DataSourceName = testDataSourceName
+	}
+	params.Filenames = []string{filename}
+	if err := tl.Import(context.Background(), params); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+}
+
+// testMessage returns a simple text item with the given original ID.
+func testMessage(id string, ts time.Time) *Item {
+	return &Item{
+		ID:             id,
+		Classification: ClassMessage,
+		Timestamp:      ts,
+		Content: ItemData{
+			Data: StringData("message " + id),
+		},
+	}
+}
+
+func TestImportProgressMetrics(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const numItems = batchSize * 4
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < numItems; i++ {
+			itemChan <- &Graph{Item: testMessage(fmt.Sprintf("msg%d", i), ts.Add(time.Duration(i)*time.Minute))}
+		}
+		return nil
+	}
+
+	var mu sync.Mutex
+	var statuses []ImportStatus
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		ProgressFunc: func(st ImportStatus) {
+			mu.Lock()
+			statuses = append(statuses, st)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(statuses) == 0 {
+		t.Fatal("expected progress to be reported")
+	}
+	for i, st := range statuses {
+		if st.ImportID == 0 {
+			t.Errorf("status %d: expected import ID", i)
+		}
+		if st.BatchCapacity != batchSize {
+			t.Errorf("status %d: expected batch capacity %d, got %d", i, batchSize, st.BatchCapacity)
+		}
+		if st.LastCommitLatency <= 0 || st.AvgCommitLatency <= 0 {
+			t.Errorf("status %d: expected commit latencies to be populated, got last=%s avg=%s",
+				i, st.LastCommitLatency, st.AvgCommitLatency)
+		}
+		if st.QueueDepth < 0 || st.BatchFill < 0 {
+			t.Errorf("status %d: invalid queue depth (%d) or batch fill (%d)", i, st.QueueDepth, st.BatchFill)
+		}
+	}
+	var maxItems int64
+	for _, st := range statuses {
+		if st.ItemCount > maxItems {
+			maxItems = st.ItemCount
+		}
+	}
+	if maxItems != numItems {
+		t.Errorf("expected final item count %d, got %d", numItems, maxItems)
+	}
+}
+
+func TestImportStatusBatchCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		po   ProcessingOptions
+		want int
+	}{
+		{"default", ProcessingOptions{}, batchSize},
+		{"flush every", ProcessingOptions{FlushEvery: &FlushEvery{Items: 7}}, 7},
+		{"max pending graphs", ProcessingOptions{MaxPendingGraphs: 40, ReorderWindow: 4}, 40 - 20 - 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := newTestTimeline(t)
+			var capacity atomic.Int64
+			params := ImportParameters{
+				ProcessingOptions: tc.po,
+				ProgressFunc:      func(st ImportStatus) { capacity.Store(int64(st.BatchCapacity)) },
+			}
+			importTestItemsWithParams(t, tl, params, testMessage("a", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+			if got := capacity.Load(); got != int64(tc.want) {
+				t.Errorf("expected batch capacity %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGraphWithManyEdgesHasBoundedTransactions(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// a few messages, each sent to many more recipients than fit in a batch
+	const numItems, recipients = 3, batchSize * 4
+	var graphs []*Graph
+	for i := 0; i < numItems; i++ {
+		g := &Graph{Item: testMessage(fmt.Sprintf("msg%d", i), time.Date(2020, 1, 1, i, 0, 0, 0, time.UTC))}
+		for j := 0; j < recipients; j++ {
+			g.ToEntity(RelSent, &Entity{
+				Attributes: []Attribute{{Name: AttributeEmail, Value: fmt.Sprintf("person%d@example.com", j), Identifying: true}},
+			})
+		}
+		graphs = append(graphs, g)
+	}
+	if size := graphs[0].Size(); size != 1+recipients*2 {
+		t.Errorf("expected graph size to count the item, entities, and edges (%d), got %d", 1+recipients*2, size)
+	}
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		for _, g := range graphs {
+			itemChan <- g
+		}
+		return nil
+	}
+
+	var mu sync.Mutex
+	var largestCommit int64
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		ProgressFunc: func(st ImportStatus) {
+			mu.Lock()
+			largestCommit = max(largestCommit, st.LargestCommit)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	// a transaction may go over the limit by at most one
+	// relationship and the node at the other end of it
+	mu.Lock()
+	defer mu.Unlock()
+	if largestCommit == 0 || largestCommit > batchSize+1 {
+		t.Errorf("expected transactions to be bounded by the batch size (%d), but largest wrote %d rows", batchSize, largestCommit)
+	}
+
+	var relationships int
+	if err := tl.db.QueryRow(`SELECT count() FROM relationships`).Scan(&relationships); err != nil {
+		t.Fatal(err)
+	}
+	if relationships != numItems*recipients {
+		t.Errorf("expected %d relationships, got %d", numItems*recipients, relationships)
+	}
+}
+
+func TestLatencyRing(t *testing.T) {
+	var r latencyRing
+	if last, avg := r.stats(); last != 0 || avg != 0 {
+		t.Fatalf("expected zero stats for empty ring, got %s %s", last, avg)
+	}
+	for i := 1; i <= len(r.durations)+4; i++ {
+		r.add(time.Duration(i) * time.Millisecond)
+	}
+	last, avg := r.stats()
+	if last != time.Duration(len(r.durations)+4)*time.Millisecond {
+		t.Errorf("unexpected last duration: %s", last)
+	}
+	// ring holds 5..20 ms; average is 12.5ms
+	if avg != 12500*time.Microsecond {
+		t.Errorf("unexpected average duration: %s", avg)
+	}
+}
+
+func TestImportWatchdogAbortsStalledImport(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// emit one item, then hang until canceled, like a data source stuck on a network call
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("only", time.Now())}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			ProcessingOptions: ProcessingOptions{
+				Watchdog:      100 * time.Millisecond,
+				WatchdogAbort: true,
+			},
+		})
+	}()
+
+	select {
+	case err := <-done:
+		var stalled StalledImportError
+		if !errors.As(err, &stalled) {
+			t.Fatalf("expected StalledImportError, got: %v", err)
+		}
+		if stalled.ImportID == 0 || stalled.StalledFor < 100*time.Millisecond || len(stalled.WorkerPhases) != workers {
+			t.Errorf("unexpected error details: %+v", stalled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("watchdog did not abort stalled import")
+	}
+}
+
+func TestImportWatchdogWithTinyTimeout(t *testing.T) {
+	tl := newTestTimeline(t)
+	importTestItemsWithParams(t, tl, ImportParameters{
+		ProcessingOptions: ProcessingOptions{Watchdog: time.Nanosecond},
+	}, testMessage("only", time.Now()))
+}
+
+func TestImportMaxDurationIsResumable(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// emit items slowly, checkpointing after each one, and pick up after
+	// the last checkpoint when resumed
+	const numItems = 20
+	ts := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		start := 0
+		if last, ok := opt.Checkpoint.(int); ok {
+			start = last + 1
+		}
+		for i := start; i < numItems; i++ {
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			itemChan <- &Graph{
+				Item:       testMessage(fmt.Sprintf("slow%d", i), ts.Add(time.Duration(i)*time.Minute)),
+				Checkpoint: i,
+			}
+		}
+		return nil
+	}
+
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{MaxDuration: 100 * time.Millisecond},
+	})
+	var exceeded DeadlineExceededImport
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected DeadlineExceededImport, got: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		t.Errorf("expected error to be a deadline, not a cancellation: %v", err)
+	}
+
+	imp, err := tl.loadImport(context.Background(), exceeded.ImportID)
+	if err != nil {
+		t.Fatalf("loading import: %v", err)
+	}
+	if imp.status != importStatusPartial {
+		t.Errorf("expected status %q, got %q", importStatusPartial, imp.status)
+	}
+	if imp.checkpoint == nil {
+		t.Fatal("expected a checkpoint to resume from")
+	}
+
+	// each run is also limited by the max duration, so it may take a few
+	// resumptions, each one continuing where the last one left off
+	for attempt := 1; ; attempt++ {
+		err = tl.Import(context.Background(), ImportParameters{ResumeImportID: exceeded.ImportID})
+		if err == nil {
+			break
+		}
+		if !errors.As(err, &exceeded) || attempt == numItems {
+			t.Fatalf("resuming import failed (attempt %d): %v", attempt, err)
+		}
+	}
+
+	results, err := tl.Search(context.Background(), ItemSearchParams{Limit: numItems * 2})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results.Items) != numItems {
+		t.Errorf("expected %d items after resuming, got %d", numItems, len(results.Items))
+	}
+
+	imp, err = tl.loadImport(context.Background(), exceeded.ImportID)
+	if err != nil {
+		t.Fatalf("loading import: %v", err)
+	}
+	if imp.status != importStatusSuccess || imp.checkpoint != nil {
+		t.Errorf("expected resumed import to succeed and clear its checkpoint; status=%q checkpoint=%v",
+			imp.status, imp.checkpoint)
+	}
+}
+
+func TestInlineThresholdBytes(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const threshold = 16
+	sizes := []int{threshold - 1, threshold, threshold + 1}
+
+	// no original IDs, so existing items have to be recognized by their content
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	var items []*Item
+	for i, size := range sizes {
+		items = append(items, &Item{
+			Classification: ClassMessage,
+			Timestamp:      ts.Add(time.Duration(i) * time.Hour),
+			Content:        ItemData{Data: StringData(strings.Repeat("x", size))},
+		})
+	}
+	importWithThreshold := func(threshold int) error {
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, it := range items {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		return tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			ProcessingOptions: ProcessingOptions{
+				Force:                 true,
+				InlineThresholdBytes:  threshold,
+				ItemUniqueConstraints: map[string]bool{"data": false, "timestamp": false},
+			},
+		})
+	}
+
+	if err := importWithThreshold(threshold); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	for i, size := range sizes {
+		var dataText, dataFile *string
+		err := tl.db.QueryRow(`SELECT data_text, data_file FROM items WHERE timestamp=?`,
+			items[i].Timestamp.UnixMilli()).Scan(&dataText, &dataFile)
+		if err != nil {
+			t.Fatalf("loading item of %d bytes: %v", size, err)
+		}
+		if size < threshold {
+			if dataText == nil || len(*dataText) != size || dataFile != nil {
+				t.Errorf("item of %d bytes: expected content in data_text, got text=%v file=%v", size, dataText, dataFile)
+			}
+		} else if dataText != nil || dataFile == nil {
+			t.Errorf("item of %d bytes: expected content in data file, got text=%v file=%v", size, dataText, dataFile)
+		}
+	}
+
+	// importing the same items again with thresholds that store them
+	// differently should still recognize them as existing items
+	for _, threshold := range []int{0, 1} {
+		if err := importWithThreshold(threshold); err != nil {
+			t.Fatalf("re-import with threshold %d failed: %v", threshold, err)
+		}
+		var count int
+		if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != len(sizes) {
+			t.Errorf("expected %d items after re-import with threshold %d, got %d", len(sizes), threshold, count)
+		}
+	}
+
+	if err := importWithThreshold(-1); err == nil {
+		t.Error("expected error for negative threshold")
+	}
+}
+
+// slowData is item data that is slow to read, and counts when it is closed.
+type slowData struct {
+	r      *strings.Reader
+	closed *atomic.Int64
+}
+
+func (d slowData) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return d.r.Read(p)
+}
+
+func (d slowData) Close() error {
+	d.closed.Add(1)
+	return nil
+}
+
+func TestMaxPendingGraphs(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const numItems, maxPending = 150, 10
+
+	// the data source can emit items much faster than they can be
+	// processed, so without backpressure they would pile up
+	var finished atomic.Int64
+	var maxInFlight int64
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < numItems; i++ {
+			it := testFileItem(fmt.Sprintf("file%d", i), ts.Add(time.Duration(i)*time.Minute))
+			contents := "slow contents of " + it.ID
+			it.Content.Data = func(context.Context) (io.ReadCloser, error) {
+				return slowData{strings.NewReader(contents), &finished}, nil
+			}
+			itemChan <- &Graph{Item: it}
+			maxInFlight = max(maxInFlight, int64(i+1)-finished.Load())
+		}
+		return nil
+	}
+
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{MaxPendingGraphs: maxPending},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	if maxInFlight > maxPending {
+		t.Errorf("expected at most %d graphs in flight, got %d", maxPending, maxInFlight)
+	}
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != numItems {
+		t.Errorf("expected %d items to be imported, got %d", numItems, count)
+	}
+}
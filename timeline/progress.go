@@ -0,0 +1,194 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ImportStatus is a snapshot of the progress and internals of a running import.
+// It is useful for showing progress to the user as well as diagnosing whether
+// an import is bound by the database or by the data source.
+type ImportStatus struct {
+	ImportID int64 `json:"import_id"`
+
+	ItemCount        int64 `json:"item_count"`
+	NewItemCount     int64 `json:"new_item_count"`
+	UpdatedItemCount int64 `json:"updated_item_count"`
+	SkippedItemCount int64 `json:"skipped_item_count"`
+	FailedItemCount  int64 `json:"failed_item_count"`
+	EntityCount      int64 `json:"entity_count"` // entities given on their own, like contacts
+	NewEntityCount   int64 `json:"new_entity_count"`
+
+	// How full the current (not yet committed) batch is, relative to
+	// the batch size at which it will be committed.
+	BatchFill     int `json:"batch_fill"`
+	BatchCapacity int `json:"batch_capacity"`
+
+	// The number of graphs sent by the data source that are
+	// waiting to be picked up by a worker.
+	QueueDepth int `json:"queue_depth"`
+
+	// The estimated memory footprint of the import and its budget,
+	// if it has one (see ProcessingOptions.MemoryBudgetBytes).
+	MemoryBytes  int64 `json:"memory_bytes,omitempty"`
+	MemoryBudget int64 `json:"memory_budget,omitempty"`
+
+	// How long the most recent batch commit took, and the average
+	// duration of recent batch commits.
+	LastCommitLatency time.Duration `json:"last_commit_latency"`
+	AvgCommitLatency  time.Duration `json:"avg_commit_latency"`
+
+	// The most rows (items, entities, and relationships) written by
+	// a single transaction. Batches whose graphs have many edges are
+	// split into multiple transactions to keep this near the batch size.
+	LargestCommit int64 `json:"largest_commit"`
+
+	// The size in bytes of the largest checkpoint saved so far,
+	// after compaction (if the data source supports it).
+	LargestCheckpoint int64 `json:"largest_checkpoint"`
+
+	// For file imports, the fraction (0-1) of the input files, by size,
+	// that the data source has read so far, if the data source reports
+	// it (see ListingOptions.ReportFileProgress). It never decreases.
+	Progress float64 `json:"progress,omitempty"`
+}
+
+// ProgressFunc is a function that receives status updates during an import.
+// It is called from processing goroutines, so it should return quickly.
+type ProgressFunc func(ImportStatus)
+
+// status returns the current status of the import.
+func (p *processor) status() ImportStatus {
+	_, batchCapacity := batchLimits(p.params.ProcessingOptions)
+	st := ImportStatus{
+		ImportID:      p.impRow.id,
+		BatchCapacity: batchCapacity,
+		QueueDepth:    len(p.graphs),
+	}
+	if p.itemCount != nil {
+		st.ItemCount = atomic.LoadInt64(p.itemCount)
+	}
+	if p.newItemCount != nil {
+		st.NewItemCount = atomic.LoadInt64(p.newItemCount)
+	}
+	if p.updatedItemCount != nil {
+		st.UpdatedItemCount = atomic.LoadInt64(p.updatedItemCount)
+	}
+	if p.skippedItemCount != nil {
+		st.SkippedItemCount = atomic.LoadInt64(p.skippedItemCount)
+	}
+	if p.failedGraphCount != nil {
+		st.FailedItemCount = atomic.LoadInt64(p.failedGraphCount)
+	}
+	if p.entityCount != nil {
+		st.EntityCount = atomic.LoadInt64(p.entityCount)
+	}
+	if p.newEntityCount != nil {
+		st.NewEntityCount = atomic.LoadInt64(p.newEntityCount)
+	}
+	if p.batchMu != nil {
+		p.batchMu.Lock()
+		st.BatchFill = p.batchSize
+		p.batchMu.Unlock()
+	}
+	st.MemoryBytes, st.MemoryBudget = p.memory.inUse()
+	if p.commitLatency != nil {
+		st.LastCommitLatency, st.AvgCommitLatency = p.commitLatency.stats()
+	}
+	if p.largestCommit != nil {
+		st.LargestCommit = atomic.LoadInt64(p.largestCommit)
+	}
+	if p.largestCheckpoint != nil {
+		st.LargestCheckpoint = atomic.LoadInt64(p.largestCheckpoint)
+	}
+	if p.fileProgress != nil {
+		st.Progress = p.fileProgress.fraction()
+	}
+	return st
+}
+
+// reportProgress sends the current status to the progress func and
+// to subscribers of the import job, if any.
+func (p *processor) reportProgress() {
+	if p.params.ProgressFunc == nil && p.job == nil {
+		return
+	}
+	if p.fileProgress != nil {
+		// concurrent reports could otherwise arrive out of order
+		p.fileProgress.reportMu.Lock()
+		defer p.fileProgress.reportMu.Unlock()
+	}
+	st := p.status()
+	if p.params.ProgressFunc != nil {
+		p.params.ProgressFunc(st)
+	}
+	if p.job != nil {
+		p.job.publish(ImportProgressEvent{ImportStatus: st})
+	}
+}
+
+// recordCommitSize records the number of rows written by a committed transaction.
+func (p *processor) recordCommitSize(rows int) {
+	if p.largestCommit == nil {
+		return
+	}
+	for {
+		largest := atomic.LoadInt64(p.largestCommit)
+		if int64(rows) <= largest || atomic.CompareAndSwapInt64(p.largestCommit, largest, int64(rows)) {
+			return
+		}
+	}
+}
+
+// latencyRing is a small, fixed-size ring buffer of durations, used
+// to keep track of how long recent batch commits took.
+type latencyRing struct {
+	mu        sync.Mutex
+	durations [16]time.Duration
+	next      int // index of next write
+	count     int // number of valid entries
+}
+
+func (r *latencyRing) add(d time.Duration) {
+	r.mu.Lock()
+	r.durations[r.next] = d
+	r.next = (r.next + 1) % len(r.durations)
+	if r.count < len(r.durations) {
+		r.count++
+	}
+	r.mu.Unlock()
+}
+
+// stats returns the most recent and the average duration in the ring.
+func (r *latencyRing) stats() (last, avg time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.count == 0 {
+		return 0, 0
+	}
+	last = r.durations[(r.next-1+len(r.durations))%len(r.durations)]
+	var sum time.Duration
+	for i := 0; i < r.count; i++ {
+		sum += r.durations[i]
+	}
+	return last, sum / time.Duration(r.count)
+}
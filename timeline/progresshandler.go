@@ -0,0 +1,179 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ImportProgressEvent is an update about the progress of an import job.
+// The last event for a job has Done set, along with the final status
+// of the import ("ok", "err", "abort", or "partial"), why it stopped early
+// (if it did), and its error, if any.
+type ImportProgressEvent struct {
+	ImportStatus
+	Done         bool         `json:"done,omitempty"`
+	Result       string       `json:"result,omitempty"`
+	CancelReason CancelReason `json:"cancel_reason,omitempty"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// importJob fans out the progress of a running import to its subscribers.
+type importJob struct {
+	mu   sync.Mutex
+	subs map[chan ImportProgressEvent]struct{}
+}
+
+// publish sends ev to all subscribers. Subscribers only ever need the
+// latest progress, so if a subscriber hasn't received the previous event
+// yet, it is replaced; this way, slow subscribers never block the import.
+// If ev is the final event, subscriber channels are closed after it.
+func (j *importJob) publish(ev ImportProgressEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for ch := range j.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- ev
+		if ev.Done {
+			close(ch)
+			delete(j.subs, ch)
+		}
+	}
+}
+
+func (tl *Timeline) startImportJob(jobID string) (*importJob, error) {
+	tl.importJobsMu.Lock()
+	defer tl.importJobsMu.Unlock()
+	if _, ok := tl.importJobs[jobID]; ok {
+		return nil, fmt.Errorf("import job %s is already running", jobID)
+	}
+	if tl.importJobs == nil {
+		tl.importJobs = make(map[string]*importJob)
+	}
+	job := &importJob{subs: make(map[chan ImportProgressEvent]struct{})}
+	tl.importJobs[jobID] = job
+	return job, nil
+}
+
+// finishImportJob sends the final event to the job's subscribers and forgets the job.
+func (tl *Timeline) finishImportJob(jobID string, st ImportStatus, result importStatus, reason CancelReason, err error) {
+	tl.importJobsMu.Lock()
+	job := tl.importJobs[jobID]
+	delete(tl.importJobs, jobID)
+	tl.importJobsMu.Unlock()
+	if job == nil {
+		return
+	}
+
+	ev := ImportProgressEvent{ImportStatus: st, Done: true, Result: string(result), CancelReason: reason}
+	if result == "" || result == importStatusStarted {
+		ev.Result = importStatusSuccess
+		if err != nil {
+			ev.Result = importStatusError
+		}
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	job.publish(ev)
+}
+
+// subscribeImportProgress returns a channel on which progress events of the running
+// import with the given job ID are received, and a function to unsubscribe. The
+// channel is closed after the final event. It returns false if no such import is running.
+func (tl *Timeline) subscribeImportProgress(jobID string) (<-chan ImportProgressEvent, func(), bool) {
+	tl.importJobsMu.Lock()
+	job, ok := tl.importJobs[jobID]
+	if !ok {
+		tl.importJobsMu.Unlock()
+		return nil, nil, false
+	}
+	ch := make(chan ImportProgressEvent, 1)
+	job.mu.Lock()
+	job.subs[ch] = struct{}{}
+	job.mu.Unlock()
+	tl.importJobsMu.Unlock()
+
+	unsubscribe := func() {
+		job.mu.Lock()
+		delete(job.subs, ch)
+		job.mu.Unlock()
+	}
+	return ch, unsubscribe, true
+}
+
+// ProgressHandler returns an HTTP handler that streams the progress of the running
+// import with the given job ID as Server-Sent Events. Progress updates are sent as
+// "progress" events, and the stream ends with a "done" event when the import
+// finishes. The data of each event is an ImportProgressEvent encoded as JSON. If
+// no import with the job ID is running, the handler responds with 404.
+func (tl *Timeline) ProgressHandler(jobID string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		events, unsubscribe, ok := tl.subscribeImportProgress(jobID)
+		if !ok {
+			http.Error(w, "no running import with job ID "+jobID, http.StatusNotFound)
+			return
+		}
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(ev)
+				if err != nil {
+					Log.Error("encoding import progress event", zap.String("job_id", jobID), zap.Error(err))
+					return
+				}
+				eventType := "progress"
+				if ev.Done {
+					eventType = "done"
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,121 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressHandler(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const jobID = "test-job"
+	const numItems = batchSize * 2
+
+	srv := httptest.NewServer(tl.ProgressHandler(jobID))
+	defer srv.Close()
+
+	// no import is running yet
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 when no import is running, got %d", resp.StatusCode)
+	}
+
+	// hold the import until we're subscribed
+	release := make(chan struct{})
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		<-release
+		ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < numItems; i++ {
+			itemChan <- &Graph{Item: testMessage(fmt.Sprintf("sse%d", i), ts.Add(time.Duration(i)*time.Second))}
+		}
+		return nil
+	}
+	importErr := make(chan error, 1)
+	go func() {
+		importErr <- tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			JobID:          jobID,
+		})
+	}()
+
+	// wait for the job to start, then subscribe
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err = http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		resp.Body.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("import job never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	close(release)
+
+	var eventType string
+	var final *ImportProgressEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && eventType == "done":
+			final = new(ImportProgressEvent)
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), final); err != nil {
+				t.Fatalf("decoding final event: %v", err)
+			}
+		}
+	}
+	if err := <-importErr; err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	if final == nil {
+		t.Fatal("did not receive final event")
+	}
+	if !final.Done || final.Result != importStatusSuccess || final.Error != "" {
+		t.Errorf("unexpected final event: %+v", final)
+	}
+	if final.ItemCount != numItems {
+		t.Errorf("expected final item count %d, got %d", numItems, final.ItemCount)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// purgeChunkSize is how many items are deleted at a time when purging.
+const purgeChunkSize = 1000
+
+// PurgeOptions configures how a data source is purged.
+type PurgeOptions struct {
+	// If true, the data source's accounts are also deleted.
+	Accounts bool
+}
+
+// PurgeResult describes what was removed by purging a data source.
+type PurgeResult struct {
+	Items     int
+	DataFiles int
+	Imports   int64
+	Accounts  int64
+}
+
+// PurgeDataSource removes everything from the named data source: all its items
+// (and their relationships and data files), its imports, and optionally its
+// accounts. Items are deleted in chunks, and if ctx is canceled, the purge stops
+// between chunks; it can be run again to finish. It refuses to run while an import
+// from the data source is running, and imports can't be started while it runs.
+func (tl *Timeline) PurgeDataSource(ctx context.Context, name string, opts PurgeOptions) (PurgeResult, error) {
+	if err := tl.checkWritable("purge data source"); err != nil {
+		return PurgeResult{}, err
+	}
+
+	var result PurgeResult
+
+	if err := tl.beginPurge(name); err != nil {
+		return result, err
+	}
+	defer tl.endPurge(name)
+
+	logger := Log.Named("purge").With(zap.String("data_source", name))
+
+	tl.dbMu.RLock()
+	var dsRowID int64
+	err := tl.db.QueryRowContext(ctx, `SELECT id FROM data_sources WHERE name=? LIMIT 1`, name).Scan(&dsRowID)
+	tl.dbMu.RUnlock()
+	if err != nil {
+		return result, fmt.Errorf("looking up data source %s: %v", name, err)
+	}
+
+	retention := time.Duration(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		rowIDs, err := tl.purgeChunk(ctx, dsRowID)
+		if err != nil {
+			return result, err
+		}
+		if len(rowIDs) == 0 {
+			break
+		}
+
+		files, err := tl.deleteItemRows(ctx, rowIDs, false, &retention)
+		result.DataFiles += files
+		if err != nil {
+			return result, err
+		}
+		result.Items += len(rowIDs)
+	}
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	tx, err := tl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// entities outlive the imports that created them
+	_, err = tx.ExecContext(ctx, `UPDATE entities SET import_id=NULL
+		WHERE import_id IN (SELECT id FROM imports WHERE data_source_id=?)`, dsRowID)
+	if err != nil {
+		return result, fmt.Errorf("detaching entities from imports: %v", err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM imports WHERE data_source_id=?`, dsRowID)
+	if err != nil {
+		return result, fmt.Errorf("deleting imports: %v", err)
+	}
+	if result.Imports, err = res.RowsAffected(); err != nil {
+		return result, err
+	}
+
+	if opts.Accounts {
+		res, err := tx.ExecContext(ctx, `DELETE FROM accounts WHERE data_source_id=?`, dsRowID)
+		if err != nil {
+			return result, fmt.Errorf("deleting accounts: %v", err)
+		}
+		if result.Accounts, err = res.RowsAffected(); err != nil {
+			return result, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("committing transaction: %v", err)
+	}
+
+	logger.Info("purged data source",
+		zap.Int("items", result.Items),
+		zap.Int("data_files", result.DataFiles),
+		zap.Int64("imports", result.Imports),
+		zap.Int64("accounts", result.Accounts))
+
+	return result, nil
+}
+
+// purgeChunk returns the row IDs of the next chunk of items to purge.
+func (tl *Timeline) purgeChunk(ctx context.Context, dsRowID int64) ([]int64, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT id FROM items WHERE data_source_id=? LIMIT ?`, dsRowID, purgeChunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("querying items to purge: %v", err)
+	}
+	defer rows.Close()
+
+	var rowIDs []int64
+	for rows.Next() {
+		var rowID int64
+		if err := rows.Scan(&rowID); err != nil {
+			return nil, fmt.Errorf("scanning item row ID: %v", err)
+		}
+		rowIDs = append(rowIDs, rowID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating items to purge: %v", err)
+	}
+
+	return rowIDs, nil
+}
+
+// trackImport records that an import from the data source is running, unless
+// the data source is being purged. The returned function must be called when
+// the import is done.
+func (tl *Timeline) trackImport(dataSourceName string) (func(), error) {
+	tl.importJobsMu.Lock()
+	defer tl.importJobsMu.Unlock()
+	if tl.purging[dataSourceName] {
+		return nil, fmt.Errorf("data source %s is being purged", dataSourceName)
+	}
+	if tl.resharding {
+		return nil, fmt.Errorf("data files are being resharded")
+	}
+	if tl.reindexing {
+		return nil, fmt.Errorf("timeline is being reindexed")
+	}
+	if tl.activeImports == nil {
+		tl.activeImports = make(map[string]int)
+	}
+	tl.activeImports[dataSourceName]++
+	return func() {
+		tl.importJobsMu.Lock()
+		tl.activeImports[dataSourceName]--
+		if tl.activeImports[dataSourceName] == 0 {
+			delete(tl.activeImports, dataSourceName)
+		}
+		tl.importJobsMu.Unlock()
+	}, nil
+}
+
+func (tl *Timeline) beginPurge(dataSourceName string) error {
+	tl.importJobsMu.Lock()
+	defer tl.importJobsMu.Unlock()
+	if n := tl.activeImports[dataSourceName]; n > 0 {
+		return fmt.Errorf("cannot purge data source %s while %d import(s) from it are running", dataSourceName, n)
+	}
+	if tl.purging[dataSourceName] {
+		return fmt.Errorf("data source %s is already being purged", dataSourceName)
+	}
+	if tl.resharding {
+		return fmt.Errorf("cannot purge data source %s while data files are being resharded", dataSourceName)
+	}
+	if tl.reindexing {
+		return fmt.Errorf("cannot purge data source %s while the timeline is being reindexed", dataSourceName)
+	}
+	if tl.purging == nil {
+		tl.purging = make(map[string]bool)
+	}
+	tl.purging[dataSourceName] = true
+	return nil
+}
+
+func (tl *Timeline) endPurge(dataSourceName string) {
+	tl.importJobsMu.Lock()
+	delete(tl.purging, dataSourceName)
+	tl.importJobsMu.Unlock()
+}
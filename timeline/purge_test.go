@@ -0,0 +1,130 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPurgeDataSource(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	keepName := fmt.Sprintf("kept_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:            keepName,
+		Title:           "Kept test",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// seed both data sources
+	importTestItems(t, tl, testMessage("purged", ts), testFileItem("purged_file", ts))
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("kept", ts)}
+		return nil
+	}
+	if err := tl.Import(ctx, ImportParameters{DataSourceName: keepName, Filenames: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.CreateAccount(ctx, testDataSourceName); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "purged_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.DataFile == nil || !FileExists(tl.FullPath(*file.DataFile)) {
+		t.Fatal("expected seeded item to have a data file")
+	}
+
+	result, err := tl.PurgeDataSource(ctx, testDataSourceName, PurgeOptions{Accounts: true})
+	if err != nil {
+		t.Fatalf("purging: %v", err)
+	}
+	if result.Items != 2 || result.DataFiles != 1 || result.Imports != 1 || result.Accounts != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if FileExists(tl.FullPath(*file.DataFile)) {
+		t.Error("expected data file to be deleted")
+	}
+
+	var items int
+	var remaining string
+	if err := tl.db.QueryRow(`SELECT count(), original_id FROM items`).Scan(&items, &remaining); err != nil {
+		t.Fatal(err)
+	}
+	if items != 1 || remaining != "kept" {
+		t.Errorf("expected only the other data source's item to remain, got %d items (%s)", items, remaining)
+	}
+	var imports int
+	if err := tl.db.QueryRow(`SELECT count() FROM imports`).Scan(&imports); err != nil {
+		t.Fatal(err)
+	}
+	if imports != 1 {
+		t.Errorf("expected the other data source's import to remain, got %d imports", imports)
+	}
+}
+
+func TestPurgeDataSourceRefusesDuringImport(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	name := fmt.Sprintf("busy_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:  name,
+		Title: "Busy test",
+		NewAPIImporter: func() APIImporter {
+			return blockingAPIImporter{started: started, release: release}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := tl.CreateAccount(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importDone := make(chan error, 1)
+	go func() { importDone <- tl.Import(ctx, ImportParameters{DataSourceName: name, AccountID: acc.ID}) }()
+	<-started
+
+	if _, err := tl.PurgeDataSource(ctx, name, PurgeOptions{}); err == nil {
+		t.Error("expected purge to be refused while an import is running")
+	}
+
+	close(release)
+	if err := <-importDone; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tl.PurgeDataSource(ctx, name, PurgeOptions{}); err != nil {
+		t.Errorf("expected purge to succeed after import finished: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetLatestQuietPeriod(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	now := time.Now().Truncate(time.Millisecond)
+	old, recent := testMessage("old", now.Add(-3*time.Hour)), testMessage("recent", now.Add(-10*time.Minute))
+
+	// the data source gives everything it has, regardless of timeframe
+	var tf Timeframe
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		tf = opt.Timeframe
+		itemChan <- &Graph{Item: old}
+		itemChan <- &Graph{Item: recent}
+		return nil
+	}
+	importLatest := func(quietPeriod time.Duration) {
+		t.Helper()
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			ProcessingOptions: ProcessingOptions{
+				GetLatest:   true,
+				QuietPeriod: quietPeriod,
+			},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+	imported := func(originalID string) bool {
+		t.Helper()
+		var count int
+		if err := tl.db.QueryRow(`SELECT count() FROM items WHERE original_id=?`, originalID).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count > 0
+	}
+
+	// the item within the quiet period is left for later
+	before := time.Now()
+	importLatest(time.Hour)
+	if tf.Until == nil || tf.Until.Before(before.Add(-time.Hour)) || tf.Until.After(time.Now().Add(-time.Hour)) {
+		t.Errorf("expected listing to end an hour ago, got %v", tf.Until)
+	}
+	if !imported("old") {
+		t.Error("expected item before the quiet period to be imported")
+	}
+	if imported("recent") {
+		t.Error("did not expect item within the quiet period to be imported")
+	}
+
+	// so the next run starts from before it, and gets it once it's out of the quiet period
+	importLatest(time.Minute)
+	if tf.Since == nil || !tf.Since.Equal(old.Timestamp) {
+		t.Errorf("expected next run to start from the newest item before the quiet period (%s), got %v", old.Timestamp, tf.Since)
+	}
+	if !imported("recent") {
+		t.Error("expected item from the previous quiet period to be imported by the next run")
+	}
+
+	// a run with nothing to get because of the quiet period still finishes
+	importLatest(time.Hour)
+	var status string
+	var ended *int64
+	if err := tl.db.QueryRow(`SELECT status, ended FROM imports ORDER BY id DESC LIMIT 1`).Scan(&status, &ended); err != nil {
+		t.Fatal(err)
+	}
+	if status != "ok" || ended == nil {
+		t.Errorf("expected import with nothing to do to end with status ok, got %s (ended: %v)", status, ended)
+	}
+
+	// a quiet period only makes sense when getting the latest items
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{QuietPeriod: time.Hour},
+	})
+	if err == nil {
+		t.Error("expected error for quiet period without get latest")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// recognizeHeadSize is how many bytes from the start of an input
+// are given to data sources to recognize it (see DataSource.RecognizeHead).
+const recognizeHeadSize = 4096
+
+// AmbiguousInputError is returned when an input to be imported is recognized
+// equally well by more than one data source, so one can't be chosen automatically.
+type AmbiguousInputError struct {
+	Candidates []string // names of the data sources
+}
+
+func (e AmbiguousInputError) Error() string {
+	return fmt.Sprintf("input is recognized equally by multiple data sources; please specify one of: %s",
+		strings.Join(e.Candidates, ", "))
+}
+
+// UnrecognizedInputError is returned when no data source recognizes an input to be imported.
+type UnrecognizedInputError struct {
+	Candidates []string // names of the data sources that were tried
+}
+
+func (e UnrecognizedInputError) Error() string {
+	return fmt.Sprintf("input is not recognized by any data source (tried: %s)", strings.Join(e.Candidates, ", "))
+}
+
+// ImportFromReader imports the contents of r, such as a quick capture or the
+// clipboard, as a file import with the given parameters, which must not specify
+// any filenames. The contents are saved to a temporary file named filename (or
+// a generic name if empty; some data sources go by the file extension), which
+// is removed once the import is done. If params doesn't name a data source,
+// the one that recognizes the start of the input best is used (see
+// DataSource.RecognizeHead); if there isn't exactly one best, an
+// AmbiguousInputError or UnrecognizedInputError is returned.
+func (t *Timeline) ImportFromReader(ctx context.Context, r io.Reader, filename string, params ImportParameters) error {
+	if err := t.checkWritable("import"); err != nil {
+		return err
+	}
+
+	if len(params.Filenames) > 0 || params.AccountID != 0 || params.ResumeImportID != 0 {
+		return fmt.Errorf("importing from a reader cannot be combined with filenames, accounts, or resuming an import")
+	}
+
+	head := make([]byte, recognizeHeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("reading start of input: %w", err)
+	}
+	head = head[:n]
+
+	if params.DataSourceName == "" {
+		ds, err := recognizeHead(head)
+		if err != nil {
+			return err
+		}
+		Log.Info("recognized input to import",
+			zap.String("data_source", ds.Name),
+			zap.Float64("confidence", ds.Confidence))
+		params.DataSourceName = ds.Name
+	}
+
+	dir, err := os.MkdirTemp("", "timelinize_reader_import_")
+	if err != nil {
+		return fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			Log.Error("removing temporary import file", zap.String("dir", dir), zap.Error(err))
+		}
+	}()
+
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) {
+		filename = "input"
+	}
+	filename = filepath.Join(dir, filename)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("creating temporary import file: %w", err)
+	}
+	_, err = io.Copy(file, io.MultiReader(bytes.NewReader(head), r))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("saving input to import: %w", err)
+	}
+
+	params.Filenames = []string{filename}
+
+	return t.Import(ctx, params)
+}
+
+// recognizeHead returns the file-importing data source which recognizes
+// the start of an input with the highest confidence.
+func recognizeHead(head []byte) (RecognizeResult, error) {
+	var tried []string
+	var results []RecognizeResult
+	for _, ds := range AllDataSources() {
+		if ds.RecognizeHead == nil || ds.NewFileImporter == nil {
+			continue
+		}
+		tried = append(tried, ds.Name)
+		if result := ds.RecognizeHead(head); result.Confidence > 0 {
+			results = append(results, RecognizeResult{ds, result})
+		}
+	}
+	sort.Strings(tried)
+
+	if len(results) == 0 {
+		return RecognizeResult{}, UnrecognizedInputError{Candidates: tried}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Confidence == results[j].Confidence {
+			return results[i].Name < results[j].Name
+		}
+		return results[i].Confidence > results[j].Confidence
+	})
+
+	var best []string
+	for _, result := range results {
+		if result.Confidence == results[0].Confidence {
+			best = append(best, result.Name)
+		}
+	}
+	if len(best) > 1 {
+		return RecognizeResult{}, AmbiguousInputError{Candidates: best}
+	}
+
+	return results[0], nil
+}
@@ -0,0 +1,146 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// headTestImporter imports the whole file as the text of a single item.
+type headTestImporter struct{}
+
+func (headTestImporter) Recognize(_ context.Context, _ []string) (Recognition, error) {
+	return Recognition{}, nil
+}
+
+func (headTestImporter) FileImport(_ context.Context, filenames []string, itemChan chan<- *Graph, _ ListingOptions) error {
+	contents, err := os.ReadFile(filenames[0])
+	if err != nil {
+		return err
+	}
+	it := testMessage(filepath.Base(filenames[0]), time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC))
+	it.Content.Data = StringData(string(contents))
+	itemChan <- &Graph{Item: it}
+	return nil
+}
+
+func init() {
+	for _, ds := range []DataSource{
+		{
+			Name:            "test_head_csv",
+			Title:           "Test CSV",
+			NewFileImporter: func() FileImporter { return headTestImporter{} },
+			RecognizeHead: func(head []byte) Recognition {
+				switch {
+				case bytes.HasPrefix(head, []byte("date,text\n")):
+					return Recognition{Confidence: 0.9}
+				case bytes.HasPrefix(head, []byte("ambiguous")):
+					return Recognition{Confidence: 0.5}
+				}
+				return Recognition{}
+			},
+		},
+		{
+			Name:            "test_head_json",
+			Title:           "Test JSON",
+			NewFileImporter: func() FileImporter { return headTestImporter{} },
+			RecognizeHead: func(head []byte) Recognition {
+				switch {
+				case bytes.HasPrefix(head, []byte("{")):
+					return Recognition{Confidence: 0.8}
+				case bytes.HasPrefix(head, []byte("date,")):
+					return Recognition{Confidence: 0.2} // any CSV starting with a date, less specifically
+				case bytes.HasPrefix(head, []byte("ambiguous")):
+					return Recognition{Confidence: 0.5}
+				}
+				return Recognition{}
+			},
+		},
+	} {
+		if err := RegisterDataSource(ds); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestImportFromReaderRecognizesDataSource(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	// large enough that the data source only sees the start of it
+	fixture := "date,text\n" + strings.Repeat("2024-02-02,hello\n", recognizeHeadSize/10)
+	err := tl.ImportFromReader(ctx, strings.NewReader(fixture), "capture.csv", ImportParameters{})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	results, err := tl.Search(ctx, ItemSearchParams{OriginalID: []string{"capture.csv"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Items) != 1 {
+		t.Fatalf("expected 1 imported item, got %d", len(results.Items))
+	}
+	it := results.Items[0]
+	if it.DataSourceName == nil || *it.DataSourceName != "test_head_csv" {
+		t.Errorf("expected input to be imported by test_head_csv, got %v", it.DataSourceName)
+	}
+	var contents string
+	switch {
+	case it.DataText != nil:
+		contents = *it.DataText
+	case it.DataFile != nil:
+		data, err := os.ReadFile(tl.FullPath(*it.DataFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = string(data)
+	}
+	if contents != strings.TrimSpace(fixture) && contents != fixture {
+		t.Errorf("expected the whole input to be imported; got %d of %d bytes", len(contents), len(fixture))
+	}
+
+	var ambiguous AmbiguousInputError
+	err = tl.ImportFromReader(ctx, strings.NewReader("ambiguous input"), "", ImportParameters{})
+	if !errors.As(err, &ambiguous) {
+		t.Errorf("expected AmbiguousInputError, got %v", err)
+	} else if !slices.Equal(ambiguous.Candidates, []string{"test_head_csv", "test_head_json"}) {
+		t.Errorf("unexpected candidates: %v", ambiguous.Candidates)
+	}
+
+	var unrecognized UnrecognizedInputError
+	err = tl.ImportFromReader(ctx, strings.NewReader("<html>"), "", ImportParameters{})
+	if !errors.As(err, &unrecognized) {
+		t.Errorf("expected UnrecognizedInputError, got %v", err)
+	}
+
+	// an explicit data source needs no recognition
+	err = tl.ImportFromReader(ctx, strings.NewReader("<html>"), "page.html", ImportParameters{DataSourceName: "test_head_json"})
+	if err != nil {
+		t.Errorf("import with explicit data source failed: %v", err)
+	}
+}
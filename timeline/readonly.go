@@ -0,0 +1,86 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ReadOnlyError is returned by methods that would modify a
+// timeline that was opened read-only (see OpenReadOnly).
+type ReadOnlyError struct {
+	Op string `json:"op"` // what was attempted
+}
+
+func (e ReadOnlyError) Error() string {
+	return fmt.Sprintf("timeline is opened read-only: cannot %s", e.Op)
+}
+
+// OpenReadOnly opens an existing timeline without the ability to modify it, for
+// example to view a timeline while another process imports into it. The database
+// is opened read-only, so searching and listing work as usual (and see changes
+// made by other processes), but methods that write, like Import, return a
+// ReadOnlyError. Since a read-only timeline does no upkeep of the repo (such as
+// erasing expired items or reconciling interrupted imports), the timeline should
+// also be opened normally from time to time. Timelines should always be Close()'d
+// for a clean shutdown when done.
+func OpenReadOnly(repo, cache string) (*Timeline, error) {
+	repoDBFile := filepath.Join(repo, DBFilename)
+	if _, err := os.Stat(repoDBFile); err != nil {
+		return nil, fmt.Errorf("checking repo DB file: %w", err)
+	}
+
+	db, err := openReadOnlyDB(repoDBFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	return openTimeline(repo, cache, db, true)
+}
+
+func openReadOnlyDB(dbPath string) (*sql.DB, error) {
+	// the path is given as a URI so the mode can be set (which also
+	// requires any special characters in the path to be escaped)
+	dsn := (&url.URL{Scheme: "file", Path: filepath.ToSlash(dbPath)}).String()
+	db, err := sql.Open("sqlite3", dsn+"?mode=ro&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+	return db, nil
+}
+
+// ReadOnly returns true if the timeline was opened read-only.
+func (t *Timeline) ReadOnly() bool { return t.readOnly }
+
+// checkWritable returns a ReadOnlyError if the timeline is read-only; op
+// describes what was attempted.
+func (t *Timeline) checkWritable(op string) error {
+	if t.readOnly {
+		return ReadOnlyError{Op: op}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2022, 2, 2, 0, 0, 0, 0, time.UTC)
+	importTestItems(t, tl, testMessage("a", ts))
+
+	ro, err := OpenReadOnly(tl.Dir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("opening timeline read-only: %v", err)
+	}
+	defer ro.Close()
+	if !ro.ReadOnly() || tl.ReadOnly() {
+		t.Errorf("expected only the second timeline to be read-only")
+	}
+
+	// writes are refused
+	var roErr ReadOnlyError
+	err = ro.Import(ctx, ImportParameters{DataSourceName: testDataSourceName, Filenames: []string{"test"}})
+	if !errors.As(err, &roErr) {
+		t.Errorf("expected import to fail with ReadOnlyError, got: %v", err)
+	}
+	results, err := ro.Search(ctx, ItemSearchParams{})
+	if err != nil {
+		t.Fatalf("searching read-only timeline: %v", err)
+	}
+	if len(results.Items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(results.Items))
+	}
+	if err := ro.DeleteItems(ctx, []int64{results.Items[0].ID}, DeleteOptions{}); !errors.As(err, &roErr) {
+		t.Errorf("expected deleting items to fail with ReadOnlyError, got: %v", err)
+	}
+	if err := ro.StartMaintenance(MaintenanceSchedule{}); !errors.As(err, &roErr) {
+		t.Errorf("expected starting maintenance to fail with ReadOnlyError, got: %v", err)
+	}
+	if _, err := ro.db.Exec(`DELETE FROM items`); err == nil {
+		t.Error("expected database opened read-only to refuse writes")
+	}
+
+	// reads see what the writer imports in the meantime
+	importTestItems(t, tl, testMessage("b", ts.Add(time.Minute)))
+	results, err = ro.Search(ctx, ItemSearchParams{})
+	if err != nil {
+		t.Fatalf("searching read-only timeline: %v", err)
+	}
+	if len(results.Items) != 2 {
+		t.Errorf("expected 2 items after import by writer, got %d", len(results.Items))
+	}
+	imports, err := ro.ListImports(ctx, ListImportsParams{})
+	if err != nil {
+		t.Fatalf("listing imports of read-only timeline: %v", err)
+	}
+	if len(imports) != 2 {
+		t.Errorf("expected 2 imports, got %d", len(imports))
+	}
+}
+
+func TestReadOnlyWriteMethods(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	importTestItems(t, tl, testMessage("a", time.Date(2022, 2, 2, 0, 0, 0, 0, time.UTC)))
+
+	ro, err := OpenReadOnly(tl.Dir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("opening timeline read-only: %v", err)
+	}
+	defer ro.Close()
+
+	params := ImportParameters{DataSourceName: testDataSourceName}
+
+	for _, tc := range []struct {
+		name  string
+		write func() error
+	}{
+		{"Import", func() error { return ro.Import(ctx, params) }},
+		{"ImportArchive", func() error { return ro.ImportArchive(ctx, "archive.zip", params) }},
+		{"ImportFromReader", func() error { return ro.ImportFromReader(ctx, strings.NewReader("x"), "x", params) }},
+		{"ImportFromURL", func() error { return ro.ImportFromURL(ctx, "http://localhost/x", params, URLImportOptions{}) }},
+		{"EnqueueImport", func() error { _, err := ro.EnqueueImport(params, 0); return err }},
+		{"DeleteItems", func() error { return ro.DeleteItems(ctx, []int64{1}, DeleteOptions{}) }},
+		{"SetFavorite", func() error { return ro.SetFavorite(ctx, 1, true) }},
+		{"AttachDataFile", func() error {
+			_, err := ro.AttachDataFile(ctx, 1, strings.NewReader("x"), "x", AttachDataFileOptions{})
+			return err
+		}},
+		{"StoreEntity", func() error { return ro.StoreEntity(ctx, Entity{}) }},
+		{"MergeEntities", func() error { return ro.MergeEntities(ctx, 1, []int64{2}) }},
+		{"MergeItems", func() error { return ro.MergeItems(ctx, DuplicateCluster{}, 1) }},
+		{"ShiftTimestamps", func() error { _, err := ro.ShiftTimestamps(ctx, ItemSearchParams{}, time.Hour); return err }},
+		{"SetTimezone", func() error { _, err := ro.SetTimezone(ctx, ItemSearchParams{}, time.UTC); return err }},
+		{"AddAccount", func() error { _, err := ro.AddAccount(ctx, testDataSourceName, nil); return err }},
+		{"CreateAccount", func() error { _, err := ro.CreateAccount(ctx, testDataSourceName); return err }},
+		{"DeleteAccount", func() error { return ro.DeleteAccount(ctx, 1, false) }},
+		{"ReassignImportAccount", func() error { return ro.ReassignImportAccount(ctx, 1, 1) }},
+		{"MergeImports", func() error { return ro.MergeImports(ctx, 1, []int64{2}) }},
+		{"DeleteImport", func() error { return ro.DeleteImport(ctx, 1) }},
+		{"CompactImportHistory", func() error { _, err := ro.CompactImportHistory(ctx, time.Now()); return err }},
+		{"ReconcileImports", func() error { _, err := ro.ReconcileImports(ctx); return err }},
+		{"PurgeDataSource", func() error { _, err := ro.PurgeDataSource(ctx, testDataSourceName, PurgeOptions{}); return err }},
+		{"ReshardDataFiles", func() error { _, err := ro.ReshardDataFiles(ctx, 1); return err }},
+		{"Reindex", func() error { _, err := ro.Reindex(ctx, ReindexOptions{}); return err }},
+		{"BackfillHashes", func() error { _, err := ro.BackfillHashes(ctx, BackfillHashesOptions{}); return err }},
+		{"CompleteRetrievalKeyedItems", func() error {
+			_, err := ro.CompleteRetrievalKeyedItems(ctx, CompletionOptions{})
+			return err
+		}},
+		{"RebuildRelationships", func() error { _, err := ro.RebuildRelationships(ctx, 1); return err }},
+		{"ReprocessMedia", func() error {
+			_, err := ro.ReprocessMedia(ctx, MediaFilter{}, ReprocessMediaOptions{})
+			return err
+		}},
+		{"CheckIntegrity", func() error { _, err := ro.CheckIntegrity(ctx, IntegrityCheckOptions{}); return err }},
+		{"SetItemIDStrategy", func() error { return ro.SetItemIDStrategy(ctx, ItemIDGlobal) }},
+		{"PopulateWithFakeData", func() error { return ro.PopulateWithFakeData(ctx) }},
+		{"StartMaintenance", func() error { return ro.StartMaintenance(MaintenanceSchedule{}) }},
+		{"Vacuum", func() error { return ro.Vacuum(ctx) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var roErr ReadOnlyError
+			if err := tc.write(); !errors.As(err, &roErr) {
+				t.Errorf("expected ReadOnlyError, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// DerivedRelationship is a relationship from a stored item to another
+// item of the same data source, which is identified by its original ID.
+type DerivedRelationship struct {
+	Relation
+	ToOriginalID string
+	Value        string
+}
+
+// RebuildRelationshipsResult describes the outcome of rebuilding relationships.
+type RebuildRelationshipsResult struct {
+	// Number of existing relationships that were deleted.
+	Deleted int64
+
+	// Number of relationships that were derived and stored.
+	Created int
+
+	// Number of derived relationships that were skipped because
+	// the other item is not part of the import.
+	Skipped int
+}
+
+// RebuildRelationships repairs the relationships between items of the given import by
+// deleting them and deriving them again from the stored items, which requires the data
+// source to support DeriveRelationships. Only relationships between two items of the
+// import are affected; relationships with entities, or that cross into other imports,
+// are left alone.
+func (tl *Timeline) RebuildRelationships(ctx context.Context, importID int64) (RebuildRelationshipsResult, error) {
+	if err := tl.checkWritable("rebuild relationships"); err != nil {
+		return RebuildRelationshipsResult{}, err
+	}
+
+	var result RebuildRelationshipsResult
+
+	imp, err := tl.loadImport(ctx, importID)
+	if err != nil {
+		return result, fmt.Errorf("loading import: %v", err)
+	}
+	ds, ok := lookupDataSource(imp.dataSourceName)
+	if !ok {
+		return result, fmt.Errorf("unknown data source: %s", imp.dataSourceName)
+	}
+	if ds.DeriveRelationships == nil {
+		return result, fmt.Errorf("data source %s does not support deriving relationships", ds.Name)
+	}
+
+	items, err := tl.importItems(ctx, importID)
+	if err != nil {
+		return result, err
+	}
+	rowIDs := make(map[string]int64, len(items))
+	for _, ir := range items {
+		if ir.OriginalID != nil {
+			rowIDs[*ir.OriginalID] = ir.ID
+		}
+	}
+
+	// derive all the relationships before changing anything
+	var rels []rawRelationship
+	for _, ir := range items {
+		derived, err := ds.DeriveRelationships(ctx, ir)
+		if err != nil {
+			return result, fmt.Errorf("deriving relationships of item %d: %v", ir.ID, err)
+		}
+		for _, dr := range derived {
+			toItemID, ok := rowIDs[dr.ToOriginalID]
+			if !ok {
+				result.Skipped++
+				continue
+			}
+			rel := rawRelationship{
+				Relation:   dr.Relation,
+				fromItemID: &ir.ID,
+				toItemID:   &toItemID,
+			}
+			if dr.Value != "" {
+				rel.value = dr.Value
+			}
+			rels = append(rels, rel)
+		}
+	}
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	tx, err := tl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM relationships
+		WHERE from_item_id IN (SELECT id FROM items WHERE import_id=?)
+			AND to_item_id IN (SELECT id FROM items WHERE import_id=?)`, importID, importID)
+	if err != nil {
+		return result, fmt.Errorf("deleting relationships: %v", err)
+	}
+	result.Deleted, err = res.RowsAffected()
+	if err != nil {
+		return result, err
+	}
+
+	for _, rel := range rels {
+		if err := tl.storeRelationship(ctx, tx, rel); err != nil {
+			return result, err
+		}
+		result.Created++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("committing transaction: %v", err)
+	}
+
+	Log.Info("rebuilt relationships of import",
+		zap.Int64("import_id", importID),
+		zap.Int64("deleted", result.Deleted),
+		zap.Int("created", result.Created),
+		zap.Int("skipped", result.Skipped))
+
+	return result, nil
+}
+
+// importItems returns the items that were originally imported by the given import.
+func (tl *Timeline) importItems(ctx context.Context, importID int64) ([]ItemRow, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT `+itemDBColumns+`
+		FROM extended_items AS items
+		WHERE import_id=? AND deleted IS NULL
+		ORDER BY id`, importID)
+	if err != nil {
+		return nil, fmt.Errorf("querying items of import: %v", err)
+	}
+	defer rows.Close()
+
+	var items []ItemRow
+	for rows.Next() {
+		ir, err := scanItemRow(rows, nil)
+		if err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		items = append(items, ir)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,142 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRebuildRelationships(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// replies can be derived deterministically from the stored items
+	replyTo := map[string]string{"b": "a", "c": "b", "d": "elsewhere"}
+	name := fmt.Sprintf("deriving_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:            name,
+		Title:           "Deriving test",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+		DeriveRelationships: func(_ context.Context, ir ItemRow) ([]DerivedRelationship, error) {
+			if to, ok := replyTo[*ir.OriginalID]; ok {
+				return []DerivedRelationship{{Relation: RelReply, ToOriginalID: to}}, nil
+			}
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importGraphs := func(graphs ...*Graph) int64 {
+		t.Helper()
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, g := range graphs {
+				itemChan <- g
+			}
+			return nil
+		}
+		err := tl.Import(ctx, ImportParameters{DataSourceName: name, Filenames: []string{"test"}, ProcessingOptions: ProcessingOptions{Force: true}})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		var importID int64
+		if err := tl.db.QueryRow(`SELECT max(id) FROM imports`).Scan(&importID); err != nil {
+			t.Fatal(err)
+		}
+		return importID
+	}
+
+	otherImport := importGraphs(&Graph{Item: testMessage("elsewhere", ts)})
+	importID := importGraphs(
+		&Graph{Item: testMessage("a", ts)},
+		&Graph{Item: testMessage("b", ts.Add(time.Minute)), Edges: []Relationship{
+			{Relation: RelReply, To: &Graph{Item: &Item{ID: "a"}}},
+		}},
+		&Graph{Item: testMessage("c", ts.Add(2*time.Minute))},
+		&Graph{Item: testMessage("d", ts.Add(3*time.Minute))},
+	)
+	if otherImport == importID {
+		t.Fatal("expected separate imports")
+	}
+
+	rowID := func(originalID string) int64 {
+		t.Helper()
+		var id int64
+		if err := tl.db.QueryRow(`SELECT id FROM items WHERE original_id=?`, originalID).Scan(&id); err != nil {
+			t.Fatalf("item %s: %v", originalID, err)
+		}
+		return id
+	}
+	a, b, c, d, elsewhere := rowID("a"), rowID("b"), rowID("c"), rowID("d"), rowID("elsewhere")
+
+	// corrupt the edges: a reply is mislinked, and an edge crosses into the other import
+	var replyRelID int64
+	if err := tl.db.QueryRow(`SELECT id FROM relations WHERE label='reply'`).Scan(&replyRelID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.db.Exec(`UPDATE relationships SET to_item_id=? WHERE from_item_id=?`, c, b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.db.Exec(`INSERT INTO relationships (relation_id, from_item_id, to_item_id) VALUES (?, ?, ?)`, replyRelID, d, elsewhere); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := tl.RebuildRelationships(ctx, importID)
+	if err != nil {
+		t.Fatalf("rebuilding relationships: %v", err)
+	}
+	if result.Deleted != 1 || result.Created != 2 || result.Skipped != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	type edge struct{ from, to int64 }
+	edges := make(map[edge]bool)
+	rows, err := tl.db.Query(`SELECT from_item_id, to_item_id FROM relationships`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e edge
+		if err := rows.Scan(&e.from, &e.to); err != nil {
+			t.Fatal(err)
+		}
+		edges[e] = true
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []edge{{b, a}, {c, b}, {d, elsewhere}} {
+		if !edges[want] {
+			t.Errorf("expected edge %d -> %d to exist", want.from, want.to)
+		}
+	}
+	if edges[edge{b, c}] {
+		t.Error("mislinked edge was not removed")
+	}
+	if len(edges) != 3 {
+		t.Errorf("expected 3 edges, got %d: %v", len(edges), edges)
+	}
+}
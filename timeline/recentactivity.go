@@ -0,0 +1,123 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetLength is the maximum number of characters in an item snippet.
+const snippetLength = 200
+
+// ActivityItem is an item in the recent activity feed.
+type ActivityItem struct {
+	ItemRow
+
+	// The title of the data source the item came from.
+	DataSourceTitle string `json:"data_source_title,omitempty"`
+
+	// The path to the item's thumbnail, if the item is of a type that has
+	// thumbnails. The thumbnail may not have been generated yet.
+	ThumbnailPath string `json:"thumbnail_path,omitempty"`
+
+	// The beginning of the item's text, for display.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// RecentActivity returns the n most recent items across all data sources, newest
+// first, ready to display in a feed: along with each item is the title of its
+// data source, the path to its thumbnail, and a snippet of its text. Items without
+// a timestamp, and hidden or deleted items, are not included. If n is not positive,
+// a default of 50 items is used. If healThumbnails is true, missing thumbnails of
+// the items are generated in the background (see ItemSearchParams.HealThumbnails).
+func (tl *Timeline) RecentActivity(ctx context.Context, n int, healThumbnails bool) ([]ActivityItem, error) {
+	if n <= 0 {
+		n = 50
+	}
+
+	// (ordering by timestamp like this uses the timestamp index, so
+	// only the rows being returned are visited)
+	q := `SELECT ` + itemDBColumns + `
+		FROM extended_items AS items
+		WHERE items.timestamp IS NOT NULL
+			AND items.deleted IS NULL
+			AND items.hidden IS NULL
+		ORDER BY items.timestamp DESC, items.id DESC
+		LIMIT ?`
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, q, n)
+	if err != nil {
+		return nil, fmt.Errorf("querying recent items: %v", err)
+	}
+	defer rows.Close()
+
+	var results []ActivityItem
+	for rows.Next() {
+		ir, err := scanItemRow(rows, nil)
+		if err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		result := ActivityItem{ItemRow: ir}
+		if ir.DataSourceName != nil {
+			if ds, err := GetDataSource(*ir.DataSourceName); err == nil {
+				result.DataSourceTitle = ds.Title
+			}
+		}
+		if ir.DataFile != nil && qualifiesForThumbnail(ir.DataType) {
+			result.ThumbnailPath = tl.ThumbnailPath(ir.ID, ImageThumbnail)
+		}
+		if ir.DataText != nil {
+			result.Snippet = snippet(*ir.DataText, snippetLength)
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	if healThumbnails {
+		itemRows := make([]ItemRow, 0, len(results))
+		for _, result := range results {
+			itemRows = append(itemRows, result.ItemRow)
+		}
+		tl.healThumbnails(itemRows)
+	}
+
+	return results, nil
+}
+
+// snippet returns the beginning of text, with its whitespace collapsed,
+// shortened to at most maxLen characters at a word boundary if possible.
+func snippet(text string, maxLen int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= maxLen {
+		return text
+	}
+	runes := []rune(text)[:maxLen-1]
+	if i := strings.LastIndexByte(string(runes), ' '); i > 0 {
+		return string(runes)[:i] + "…"
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,75 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecentActivity(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	photo := testFileItem("photo", ts.Add(2*time.Hour))
+	photo.Content.MediaType = "image/jpeg"
+	long := testTextItem("long", strings.Repeat("lorem ipsum ", 100), ts.Add(3*time.Hour))
+	importTestItems(t, tl,
+		testMessage("oldest", ts),
+		testMessage("middle", ts.Add(time.Hour)),
+		photo,
+		long,
+	)
+
+	items, err := tl.RecentActivity(context.Background(), 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, it := range items {
+		ids = append(ids, *it.OriginalID)
+	}
+	if strings.Join(ids, ",") != "long,photo,middle" {
+		t.Fatalf("expected 3 most recent items in descending order of time, got %v", ids)
+	}
+	for i := 1; i < len(items); i++ {
+		if items[i].Timestamp.After(*items[i-1].Timestamp) {
+			t.Errorf("item %d is newer than the item before it", i)
+		}
+	}
+
+	if items[1].ThumbnailPath != tl.ThumbnailPath(items[1].ID, ImageThumbnail) {
+		t.Errorf("expected photo's thumbnail path to be resolved, got %q", items[1].ThumbnailPath)
+	}
+	if items[0].ThumbnailPath != "" || items[2].ThumbnailPath != "" {
+		t.Errorf("expected no thumbnail paths for text items, got %q and %q", items[0].ThumbnailPath, items[2].ThumbnailPath)
+	}
+
+	if items[2].Snippet != "message middle" {
+		t.Errorf("expected snippet of short text to be the whole text, got %q", items[2].Snippet)
+	}
+	if n := len([]rune(items[0].Snippet)); n > snippetLength || !strings.HasSuffix(items[0].Snippet, "…") {
+		t.Errorf("expected snippet of long text to be shortened, got %d characters: %q", n, items[0].Snippet)
+	}
+	if items[0].DataSourceTitle == "" {
+		t.Error("expected data source title to be set")
+	}
+}
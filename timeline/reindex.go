@@ -0,0 +1,311 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ReindexOptions configures a reindex.
+type ReindexOptions struct {
+	// If set, the pass over the items resumes after this item,
+	// which is the LastItemID of a previous, incomplete reindex.
+	ResumeAfterItemID int64 `json:"resume_after_item_id,omitempty"`
+
+	// If set, this function will be called after each
+	// page of items is reindexed.
+	ProgressFunc func(ReindexResult) `json:"-"`
+}
+
+// ReindexResult is the outcome (or progress) of a reindex. If the reindex
+// did not complete, it can be resumed by passing LastItemID as the
+// ResumeAfterItemID option.
+type ReindexResult struct {
+	// Number of items whose normalized text was rebuilt.
+	NormalizedText int64 `json:"normalized_text"`
+
+	// Number of items whose original ID hash was rebuilt.
+	IDHashes int64 `json:"id_hashes"`
+
+	// Outcome of backfilling missing data file hashes.
+	DataHashes BackfillHashesResult `json:"data_hashes"`
+
+	// Number of duplicate relationships that were removed.
+	DuplicateRelationships int64 `json:"duplicate_relationships"`
+
+	LastItemID int64 `json:"last_item_id"`
+	Complete   bool  `json:"complete"`
+}
+
+// Reindex rebuilds the structures that are derived from the items and
+// relationships tables, which can drift after bulk changes or a schema
+// upgrade: the normalized text of items (according to the text normalization
+// options of the import that last modified the item), the original ID hashes
+// used for duplicate detection, missing data file hashes (see BackfillHashes),
+// the deduplication of relationships, and finally the database indexes. It is
+// safe to run at any time, but it refuses to run while imports are running,
+// and imports can't be started while it runs.
+func (tl *Timeline) Reindex(ctx context.Context, opts ReindexOptions) (ReindexResult, error) {
+	if err := tl.checkWritable("reindex"); err != nil {
+		return ReindexResult{}, err
+	}
+
+	result := ReindexResult{LastItemID: opts.ResumeAfterItemID}
+
+	if err := tl.beginReindex(); err != nil {
+		return result, err
+	}
+	defer tl.endReindex()
+
+	logger := Log.Named("reindex")
+	logger.Info("reindexing timeline", zap.Int64("resuming_after_item_id", result.LastItemID))
+
+	normalizations := make(map[int64]*TextNormalization)
+
+	for {
+		page, err := tl.reindexPage(ctx, result.LastItemID)
+		if err != nil {
+			return result, err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, it := range page {
+			if it.importID == nil {
+				continue
+			}
+			if _, ok := normalizations[*it.importID]; !ok {
+				tn, err := tl.importTextNormalization(ctx, *it.importID)
+				if err != nil {
+					return result, err
+				}
+				normalizations[*it.importID] = tn
+			}
+		}
+
+		if err := tl.reindexItems(ctx, page, normalizations, &result); err != nil {
+			return result, err
+		}
+		result.LastItemID = page[len(page)-1].rowID
+
+		if opts.ProgressFunc != nil {
+			opts.ProgressFunc(result)
+		}
+	}
+
+	var err error
+	result.DataHashes, err = tl.BackfillHashes(ctx, BackfillHashesOptions{})
+	if err != nil {
+		return result, fmt.Errorf("backfilling data file hashes: %w", err)
+	}
+
+	if err := tl.reindexRelationships(ctx, &result); err != nil {
+		return result, err
+	}
+
+	tl.dbMu.Lock()
+	_, err = tl.db.ExecContext(ctx, `REINDEX`)
+	tl.dbMu.Unlock()
+	if err != nil {
+		return result, fmt.Errorf("rebuilding database indexes: %v", err)
+	}
+
+	result.Complete = true
+
+	logger.Info("reindex complete",
+		zap.Int64("normalized_text", result.NormalizedText),
+		zap.Int64("id_hashes", result.IDHashes),
+		zap.Int64("data_hashes", result.DataHashes.Hashed),
+		zap.Int64("duplicate_relationships", result.DuplicateRelationships))
+
+	return result, nil
+}
+
+// reindexItem is the derived state of an item as stored in the DB,
+// along with what it is derived from.
+type reindexItem struct {
+	rowID          int64
+	importID       *int64 // the import that last modified the item
+	dataSourceName *string
+	originalID     *string
+	idHash         []byte
+	dataText       *string
+	normalizedText *string
+}
+
+// reindexPage returns the next page of items after the given item ID.
+func (tl *Timeline) reindexPage(ctx context.Context, afterItemID int64) ([]reindexItem, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx,
+		`SELECT items.id, coalesce(items.modified_import_id, items.import_id), data_sources.name,
+			items.original_id, items.original_id_hash, items.data_text, items.normalized_text
+		FROM items
+		LEFT JOIN data_sources ON data_sources.id = items.data_source_id
+		WHERE items.id > ?
+		ORDER BY items.id
+		LIMIT ?`, afterItemID, integrityCheckPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("querying items to reindex: %v", err)
+	}
+	defer rows.Close()
+
+	var page []reindexItem
+	for rows.Next() {
+		var it reindexItem
+		if err := rows.Scan(&it.rowID, &it.importID, &it.dataSourceName,
+			&it.originalID, &it.idHash, &it.dataText, &it.normalizedText); err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		page = append(page, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return page, nil
+}
+
+// importTextNormalization returns the text normalization options of the import, or nil if none.
+func (tl *Timeline) importTextNormalization(ctx context.Context, importID int64) (*TextNormalization, error) {
+	var procOptJSON *string
+	tl.dbMu.RLock()
+	err := tl.db.QueryRowContext(ctx, `SELECT processing_options FROM imports WHERE id=? LIMIT 1`, importID).Scan(&procOptJSON)
+	tl.dbMu.RUnlock()
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying processing options of import %d: %v", importID, err)
+	}
+	if procOptJSON == nil || *procOptJSON == "" {
+		return nil, nil
+	}
+	var po ProcessingOptions
+	if err := json.Unmarshal([]byte(*procOptJSON), &po); err != nil {
+		return nil, fmt.Errorf("decoding processing options of import %d: %v", importID, err)
+	}
+	return po.TextNormalization, nil
+}
+
+// reindexItems rebuilds the derived columns of the items in page that have drifted.
+func (tl *Timeline) reindexItems(ctx context.Context, page []reindexItem, normalizations map[int64]*TextNormalization, result *ReindexResult) error {
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	tx, err := tl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, it := range page {
+		var tn *TextNormalization
+		if it.importID != nil {
+			tn = normalizations[*it.importID]
+		}
+		var normalized *string
+		if tn != nil && it.dataText != nil {
+			n, err := tn.Normalize(*it.dataText)
+			if err != nil {
+				return fmt.Errorf("normalizing text of item %d: %v", it.rowID, err)
+			}
+			normalized = &n
+		}
+		if (normalized == nil) != (it.normalizedText == nil) ||
+			(normalized != nil && *normalized != *it.normalizedText) {
+			if _, err := tx.ExecContext(ctx, `UPDATE items SET normalized_text=? WHERE id=?`, normalized, it.rowID); err != nil {
+				return fmt.Errorf("updating normalized text of item %d: %v", it.rowID, err)
+			}
+			result.NormalizedText++
+		}
+
+		// an erased item may keep its hash without its original ID, so only fix
+		// hashes that can be computed
+		if it.dataSourceName != nil && it.originalID != nil {
+			idHash := Item{ID: *it.originalID}
+			idHash.makeIDHash(it.dataSourceName)
+			if !bytes.Equal(idHash.idHash, it.idHash) {
+				if _, err := tx.ExecContext(ctx, `UPDATE items SET original_id_hash=? WHERE id=?`, idHash.idHash, it.rowID); err != nil {
+					return fmt.Errorf("updating original ID hash of item %d: %v", it.rowID, err)
+				}
+				result.IDHashes++
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+	return nil
+}
+
+// reindexRelationships removes relationships that duplicate an older one. The
+// unique constraints of the table don't catch all of them, since NULLs are
+// distinct in SQLite, and the constraint indexes themselves can be damaged.
+func (tl *Timeline) reindexRelationships(ctx context.Context, result *ReindexResult) error {
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	res, err := tl.db.ExecContext(ctx, `DELETE FROM relationships WHERE id NOT IN (
+		SELECT min(id) FROM relationships
+		GROUP BY relation_id, from_item_id, from_attribute_id, to_item_id, to_attribute_id)`)
+	if err != nil {
+		return fmt.Errorf("deleting duplicate relationships: %v", err)
+	}
+	result.DuplicateRelationships, err = res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (tl *Timeline) beginReindex() error {
+	tl.importJobsMu.Lock()
+	defer tl.importJobsMu.Unlock()
+	if n := len(tl.activeImports); n > 0 {
+		return fmt.Errorf("cannot reindex while imports from %d data source(s) are running", n)
+	}
+	if len(tl.purging) > 0 {
+		return fmt.Errorf("cannot reindex while a data source is being purged")
+	}
+	if tl.resharding {
+		return fmt.Errorf("cannot reindex while data files are being resharded")
+	}
+	if tl.reindexing {
+		return fmt.Errorf("timeline is already being reindexed")
+	}
+	tl.reindexing = true
+	return nil
+}
+
+func (tl *Timeline) endReindex() {
+	tl.importJobsMu.Lock()
+	tl.reindexing = false
+	tl.importJobsMu.Unlock()
+}
@@ -0,0 +1,110 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReindexRestoresDerivedColumns(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	ts := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("a", ts)}
+		itemChan <- &Graph{Item: testMessage("b", ts.Add(time.Minute))}
+		return nil
+	}
+	err := tl.Import(ctx, ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{TextNormalization: &TextNormalization{FoldCase: true}},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	var wantText string
+	var wantHash []byte
+	if err := tl.db.QueryRow(`SELECT normalized_text, original_id_hash FROM items WHERE original_id='a'`).Scan(&wantText, &wantHash); err != nil {
+		t.Fatal(err)
+	}
+
+	// deliberately damage the derived columns
+	if _, err := tl.db.Exec(`UPDATE items SET normalized_text='garbage', original_id_hash=x'00' WHERE original_id='a'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.db.Exec(`UPDATE items SET normalized_text=NULL WHERE original_id='b'`); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress int
+	result, err := tl.Reindex(ctx, ReindexOptions{ProgressFunc: func(ReindexResult) { progress++ }})
+	if err != nil {
+		t.Fatalf("reindex failed: %v", err)
+	}
+	if !result.Complete || result.NormalizedText != 2 || result.IDHashes != 1 || progress == 0 {
+		t.Errorf("unexpected result: %+v (progress calls: %d)", result, progress)
+	}
+
+	var text, textB *string
+	var hash []byte
+	if err := tl.db.QueryRow(`SELECT normalized_text, original_id_hash FROM items WHERE original_id='a'`).Scan(&text, &hash); err != nil {
+		t.Fatal(err)
+	}
+	if text == nil || *text != wantText || !bytes.Equal(hash, wantHash) {
+		t.Errorf("expected derived columns to be restored to (%q, %x), got (%v, %x)", wantText, wantHash, text, hash)
+	}
+	if err := tl.db.QueryRow(`SELECT normalized_text FROM items WHERE original_id='b'`).Scan(&textB); err != nil {
+		t.Fatal(err)
+	}
+	if textB == nil || *textB != "message b" {
+		t.Errorf("expected normalized text to be rebuilt, got %v", textB)
+	}
+
+	// a consistent timeline needs no repairs
+	result, err = tl.Reindex(ctx, ReindexOptions{})
+	if err != nil {
+		t.Fatalf("second reindex failed: %v", err)
+	}
+	if result.NormalizedText != 0 || result.IDHashes != 0 || result.DuplicateRelationships != 0 {
+		t.Errorf("expected no repairs on second reindex, got: %+v", result)
+	}
+}
+
+func TestReindexRefusesDuringImport(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	done, err := tl.trackImport(testDataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.Reindex(context.Background(), ReindexOptions{}); err == nil {
+		t.Error("expected reindex to be refused while an import is running")
+	}
+	done()
+
+	if _, err := tl.Reindex(context.Background(), ReindexOptions{}); err != nil {
+		t.Errorf("expected reindex to run after the import finished: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RelativeTimeframe describes which items to import relative to when the
+// import runs, which is more convenient than absolute times for recurring
+// imports. When the import starts, it is resolved into a concrete timeframe
+// (which is what gets stored in the import's checkpoint, so a resumed import
+// covers the same window). If more than one bound is set, the later one is
+// used.
+type RelativeTimeframe struct {
+	// Only items from within this long before the import starts;
+	// for example, 168h for the last 7 days.
+	Last time.Duration `json:"last,omitempty"`
+
+	// Only items newer than the newest item of the Nth most recent
+	// successful import of the same data source (and account, if any);
+	// 1 means since the previous import. Unlike GetLatest, this is just
+	// a window: it doesn't change how the data source lists items. If
+	// there aren't enough imports, there is no lower bound.
+	SinceImports int `json:"since_imports,omitempty"`
+}
+
+func (rt RelativeTimeframe) validate() error {
+	if rt.Last < 0 {
+		return fmt.Errorf("relative timeframe cannot be negative: %s", rt.Last)
+	}
+	if rt.SinceImports < 0 {
+		return fmt.Errorf("number of imports for relative timeframe cannot be negative: %d", rt.SinceImports)
+	}
+	if rt.Last == 0 && rt.SinceImports == 0 {
+		return errors.New("relative timeframe is empty")
+	}
+	return nil
+}
+
+// resolveRelativeTimeframe returns tf with its lower bound set according to rt,
+// relative to now.
+func (p *processor) resolveRelativeTimeframe(ctx context.Context, rt RelativeTimeframe, tf Timeframe, now time.Time) (Timeframe, error) {
+	var since time.Time
+	if rt.Last > 0 {
+		since = now.Add(-rt.Last)
+	}
+	if rt.SinceImports > 0 {
+		latest, err := p.newestItemOfPreviousImport(ctx, rt.SinceImports, now)
+		if err != nil {
+			return tf, err
+		}
+		if latest.timestamp != nil && latest.timestamp.After(since) {
+			since = *latest.timestamp
+		}
+	}
+	if !since.IsZero() {
+		tf.Since = &since
+	}
+	return tf, nil
+}
+
+// previousImportItem is the newest item of a previous import.
+type previousImportItem struct {
+	originalID *string
+	timestamp  *time.Time
+}
+
+// newestItemOfPreviousImport returns the newest item that was added by the nth most
+// recent successful import of the data source (and account, if there is one). Only
+// imports that added items are counted. Items with timestamps after now, or that were
+// clamped because they were in the future, are not trustworthy and would exclude real
+// newer items, so they are ignored. If there is no such import, the result is empty.
+func (p *processor) newestItemOfPreviousImport(ctx context.Context, nth int, now time.Time) (previousImportItem, error) {
+	const trustworthyItem = `items.original_timestamp IS NULL AND items.timestamp <= ?`
+
+	var accountClause string
+	args := []any{importStatusSuccess, p.ds.Name, now.UnixMilli()}
+	if p.acc.ID > 0 {
+		accountClause = ` AND imports.account_id = ?`
+		args = append(args, p.acc.ID)
+	}
+	args = append(args, nth-1, now.UnixMilli())
+
+	var result previousImportItem
+	var ts, tsMicros *int64
+	p.tl.dbMu.RLock()
+	err := p.tl.db.QueryRowContext(ctx, `
+		SELECT items.original_id, items.timestamp, items.timestamp_micros
+		FROM items
+		WHERE items.import_id = (
+				SELECT imports.id
+				FROM imports
+				JOIN data_sources ON data_sources.id = imports.data_source_id
+				WHERE imports.status = ?
+					AND data_sources.name = ?
+					AND EXISTS (SELECT 1 FROM items WHERE items.import_id = imports.id AND `+trustworthyItem+`)`+accountClause+`
+				ORDER BY imports.started DESC, imports.id DESC
+				LIMIT 1 OFFSET ?)
+			AND `+trustworthyItem+`
+		ORDER BY items.timestamp DESC, items.timestamp_micros DESC
+		LIMIT 1`, args...).Scan(&result.originalID, &ts, &tsMicros)
+	p.tl.dbMu.RUnlock()
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("getting most recent item of previous import: %v", err)
+	}
+
+	if ts != nil {
+		t := time.UnixMilli(*ts)
+		if tsMicros != nil && time.UnixMicro(*tsMicros).UnixMilli() == *ts {
+			t = time.UnixMicro(*tsMicros)
+		}
+		result.timestamp = &t
+	}
+
+	return result, nil
+}
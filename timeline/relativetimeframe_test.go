@@ -0,0 +1,158 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRelativeTimeframe(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	now := time.Now().Truncate(time.Millisecond)
+	first, second := now.Add(-72*time.Hour), now.Add(-48*time.Hour)
+
+	importWith := func(rt *RelativeTimeframe, items ...*Item) (Timeframe, error) {
+		t.Helper()
+		var tf Timeframe
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+			tf = opt.Timeframe
+			for _, it := range items {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{RelativeTimeframe: rt},
+		})
+		return tf, err
+	}
+
+	// two previous imports, each with a newest item
+	if _, err := importWith(nil, testMessage("a", first.Add(-time.Hour)), testMessage("b", first)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importWith(nil, testMessage("c", second)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		rt          RelativeTimeframe
+		expectSince *time.Time // nil means no lower bound; zero means around now minus rt.Last
+	}{
+		{rt: RelativeTimeframe{SinceImports: 1}, expectSince: &second},
+		{rt: RelativeTimeframe{SinceImports: 2}, expectSince: &first},
+		{rt: RelativeTimeframe{SinceImports: 5}, expectSince: nil},
+		{rt: RelativeTimeframe{Last: 7 * 24 * time.Hour}, expectSince: &time.Time{}},
+		{rt: RelativeTimeframe{Last: 24 * time.Hour, SinceImports: 1}, expectSince: &time.Time{}}, // last 24h is later
+		{rt: RelativeTimeframe{Last: 96 * time.Hour, SinceImports: 1}, expectSince: &second},      // previous import is later
+	} {
+		before := time.Now()
+		tf, err := importWith(&tc.rt)
+		after := time.Now()
+		if err != nil {
+			t.Fatalf("test %d: import failed: %v", i, err)
+		}
+		if tf.Until != nil || tf.SinceItemID != nil {
+			t.Errorf("test %d: expected only a lower bound, got %s", i, tf)
+		}
+		switch {
+		case tc.expectSince == nil:
+			if tf.Since != nil {
+				t.Errorf("test %d: expected no lower bound, got %s", i, tf.Since)
+			}
+		case tc.expectSince.IsZero():
+			if tf.Since == nil || tf.Since.Before(before.Add(-tc.rt.Last)) || tf.Since.After(after.Add(-tc.rt.Last)) {
+				t.Errorf("test %d: expected lower bound of %s ago, got %v", i, tc.rt.Last, tf.Since)
+			}
+		default:
+			if tf.Since == nil || !tf.Since.Equal(*tc.expectSince) {
+				t.Errorf("test %d: expected lower bound of %s, got %v", i, tc.expectSince, tf.Since)
+			}
+		}
+	}
+
+	// the resolved timeframe is enforced
+	if _, err := importWith(&RelativeTimeframe{Last: 24 * time.Hour},
+		testMessage("old", now.Add(-36*time.Hour)), testMessage("new", now.Add(-time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE original_id IN ('old', 'new')`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected only the item within the relative timeframe to be imported, got %d items", count)
+	}
+
+	// invalid specs
+	for i, po := range []ProcessingOptions{
+		{RelativeTimeframe: &RelativeTimeframe{}},
+		{RelativeTimeframe: &RelativeTimeframe{Last: -time.Hour}},
+		{RelativeTimeframe: &RelativeTimeframe{SinceImports: 1}, GetLatest: true},
+		{RelativeTimeframe: &RelativeTimeframe{SinceImports: 1}, Timeframe: Timeframe{Since: &first}},
+	} {
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: po,
+		})
+		if err == nil {
+			t.Errorf("invalid spec %d: expected error", i)
+		}
+	}
+}
+
+func TestRelativeTimeframeNothingToDo(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	var listed bool
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		listed = true
+		return nil
+	}
+	until := time.Now().Add(-48 * time.Hour)
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		ProcessingOptions: ProcessingOptions{
+			RelativeTimeframe: &RelativeTimeframe{Last: 24 * time.Hour},
+			Timeframe:         Timeframe{Until: &until},
+		},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if listed {
+		t.Error("did not expect data source to be called for an empty timeframe")
+	}
+
+	var status string
+	var ended *int64
+	if err := tl.db.QueryRow(`SELECT status, ended FROM imports ORDER BY id DESC LIMIT 1`).Scan(&status, &ended); err != nil {
+		t.Fatal(err)
+	}
+	if status != "ok" || ended == nil {
+		t.Errorf("expected import with nothing to do to end with status ok, got %s (ended: %v)", status, ended)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import "container/heap"
+
+// reorderBuffer holds back a bounded number of graphs so that they can be
+// released in order of their items' timestamps (see ProcessingOptions.ReorderWindow).
+// A nil reorderBuffer holds nothing back.
+type reorderBuffer struct {
+	size    int
+	graphs  reorderHeap
+	arrival int64 // count of graphs pushed, to keep ties in order of arrival
+}
+
+// newReorderBuffer returns a buffer that holds back up to size graphs,
+// or nil if size is not positive.
+func newReorderBuffer(size int) *reorderBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &reorderBuffer{size: size, graphs: make(reorderHeap, 0, size+1)}
+}
+
+// push adds g to the buffer and returns the graph to release, if any: the
+// earliest one if the buffer is over capacity, or g itself if it has no
+// timestamp to order it by.
+func (rb *reorderBuffer) push(g *Graph) *Graph {
+	if rb == nil || g.Item == nil || g.Item.Timestamp.IsZero() {
+		return g
+	}
+	rb.arrival++
+	heap.Push(&rb.graphs, reorderEntry{graph: g, arrival: rb.arrival})
+	if rb.graphs.Len() > rb.size {
+		return rb.pop()
+	}
+	return nil
+}
+
+// pop removes and returns the earliest graph in the buffer,
+// or nil if the buffer is empty.
+func (rb *reorderBuffer) pop() *Graph {
+	if rb == nil || rb.graphs.Len() == 0 {
+		return nil
+	}
+	return heap.Pop(&rb.graphs).(reorderEntry).graph
+}
+
+type reorderEntry struct {
+	graph   *Graph
+	arrival int64
+}
+
+// reorderHeap is a min-heap of graphs by their items' timestamps.
+type reorderHeap []reorderEntry
+
+func (h reorderHeap) Len() int { return len(h) }
+func (h reorderHeap) Less(i, j int) bool {
+	ti, tj := h[i].graph.Item.Timestamp, h[j].graph.Item.Timestamp
+	if ti.Equal(tj) {
+		return h[i].arrival < h[j].arrival
+	}
+	return ti.Before(tj)
+}
+func (h reorderHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap) Push(x any)   { *h = append(*h, x.(reorderEntry)) }
+func (h *reorderHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = reorderEntry{}
+	*h = old[:n-1]
+	return entry
+}
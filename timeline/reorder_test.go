@@ -0,0 +1,126 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestReorderWindow(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rng := rand.New(rand.NewSource(1))
+
+	// inversions counts the pairs of items that are out of order
+	inversions := func(order []int) int {
+		var count int
+		for i := range order {
+			for j := i + 1; j < len(order); j++ {
+				if order[i] > order[j] {
+					count++
+				}
+			}
+		}
+		return count
+	}
+
+	// importShuffled imports items given in the order of the permutation (item i
+	// has the i'th timestamp), and returns the order in which they were processed
+	importShuffled := func(t *testing.T, perm []int, window int) []int {
+		tl := newTestTimeline(t)
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, i := range perm {
+				itemChan <- &Graph{Item: testMessage(fmt.Sprintf("%d", i), ts.Add(time.Duration(i)*time.Minute))}
+			}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{ReorderWindow: window},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+
+		rows, err := tl.db.Query(`SELECT original_id FROM items ORDER BY sequence`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var order []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			order = append(order, id)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(order) != len(perm) {
+			t.Fatalf("expected %d items, got %d", len(perm), len(order))
+		}
+		return order
+	}
+
+	t.Run("within window", func(t *testing.T) {
+		// shuffle within blocks that are smaller than the window
+		const numItems, block = 200, 10
+		perm := make([]int, numItems)
+		for i := range perm {
+			perm[i] = i
+		}
+		for start := 0; start < numItems; start += block {
+			rng.Shuffle(block, func(i, j int) {
+				perm[start+i], perm[start+j] = perm[start+j], perm[start+i]
+			})
+		}
+		if order := importShuffled(t, perm, 2*block); inversions(order) != 0 {
+			t.Errorf("expected items to be processed in order, got %v", order)
+		}
+	})
+
+	t.Run("beyond window", func(t *testing.T) {
+		perm := rng.Perm(200)
+		order := importShuffled(t, perm, 50)
+		if before, after := inversions(perm), inversions(order); after > before/2 {
+			t.Errorf("expected items to be mostly sorted: %d inversions before, %d after", before, after)
+		}
+	})
+}
+
+func TestReorderWindowValidation(t *testing.T) {
+	tl := newTestTimeline(t)
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		ProcessingOptions: ProcessingOptions{
+			ReorderWindow:    10,
+			MaxPendingGraphs: 20,
+		},
+	})
+	if err == nil {
+		t.Error("expected error when reorder window is not less than half of max pending graphs")
+	}
+}
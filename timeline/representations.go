@@ -0,0 +1,109 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// RepresentationRole describes what a representation of an item is, relative to
+// the others; for example, a photo may have an original and an edited version.
+// Data sources can use other roles as needed.
+type RepresentationRole string
+
+const (
+	// RepresentationPrimary is the representation that the others are linked
+	// to, which is the one that appears in search results.
+	RepresentationPrimary RepresentationRole = "primary"
+
+	// RepresentationOriginal is the unaltered form of the item.
+	RepresentationOriginal RepresentationRole = "original"
+
+	// RepresentationEdited is a modified form of the item, such as a cropped
+	// or color-corrected photo.
+	RepresentationEdited RepresentationRole = "edited"
+
+	// RepresentationScreenshot is a screen capture of the item.
+	RepresentationScreenshot RepresentationRole = "screenshot"
+)
+
+// Representation is one of the forms of an item.
+type Representation struct {
+	Role RepresentationRole `json:"role"`
+	ItemRow
+}
+
+// ItemRepresentations returns all the representations of the item with the given
+// row ID, which may be the primary representation or any of the others (see
+// Graph.AddRepresentation). The primary is first, followed by the others in the
+// order they were stored. An item without other representations has only itself
+// as the primary. Deleted representations are not included.
+func (tl *Timeline) ItemRepresentations(ctx context.Context, itemRowID int64) ([]Representation, error) {
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	primaryID := itemRowID
+	err := tl.db.QueryRowContext(ctx, `SELECT relationships.from_item_id
+		FROM relationships
+		JOIN relations ON relations.id = relationships.relation_id
+		WHERE relations.label=? AND relationships.to_item_id=?
+		LIMIT 1`, RelRepresentation.Label, itemRowID).Scan(&primaryID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("looking up primary representation of item %d: %v", itemRowID, err)
+	}
+
+	primary, err := scanItemRow(tl.db.QueryRowContext(ctx,
+		`SELECT `+itemDBColumns+` FROM extended_items AS items WHERE items.id=? LIMIT 1`, primaryID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("loading item %d: %w", primaryID, err)
+	}
+	representations := []Representation{{Role: RepresentationPrimary, ItemRow: primary}}
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT `+itemDBColumns+`, relationships.value
+		FROM relationships
+		JOIN relations ON relations.id = relationships.relation_id
+		JOIN extended_items AS items ON items.id = relationships.to_item_id
+		WHERE relations.label=? AND relationships.from_item_id=? AND items.deleted IS NULL
+		ORDER BY items.id`, RelRepresentation.Label, primaryID)
+	if err != nil {
+		return nil, fmt.Errorf("querying representations of item %d: %v", primaryID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role *string
+		ir, err := scanItemRow(rows, []any{&role})
+		if err != nil {
+			return nil, fmt.Errorf("scanning representation: %v", err)
+		}
+		rep := Representation{ItemRow: ir}
+		if role != nil {
+			rep.Role = RepresentationRole(*role)
+		}
+		representations = append(representations, rep)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating representations: %v", err)
+	}
+
+	return representations, nil
+}
@@ -0,0 +1,110 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestImportItemRepresentations(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	photoGraph := func() *Graph {
+		original := testFileItem("IMG_0001", ts)
+		original.Content.MediaType = "image/jpeg"
+		edited := testFileItem("IMG_0001-edited", ts)
+		edited.Content.MediaType = "image/jpeg"
+		g := &Graph{Item: original}
+		g.AddRepresentation(RepresentationEdited, edited)
+		return g
+	}
+	importGraphs := func() {
+		t.Helper()
+		graphs := []*Graph{photoGraph()}
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, g := range graphs {
+				itemChan <- g
+			}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+	importGraphs()
+
+	// only the primary appears in search results
+	results, err := tl.Search(context.Background(), ItemSearchParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Items) != 1 || *results.Items[0].OriginalID != "IMG_0001" {
+		t.Fatalf("expected only the primary representation in search results, got %d items", len(results.Items))
+	}
+	primaryID := results.Items[0].ID
+
+	reps, err := tl.ItemRepresentations(context.Background(), primaryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reps) != 2 {
+		t.Fatalf("expected 2 representations, got %d", len(reps))
+	}
+	if reps[0].Role != RepresentationPrimary || reps[0].ID != primaryID {
+		t.Errorf("expected primary first, got role %q for item %d", reps[0].Role, reps[0].ID)
+	}
+	if reps[1].Role != RepresentationEdited || *reps[1].OriginalID != "IMG_0001-edited" {
+		t.Errorf("expected edited representation, got role %q for %v", reps[1].Role, reps[1].OriginalID)
+	}
+
+	// each representation has its own data file and hash
+	if reps[0].DataFile == nil || reps[1].DataFile == nil || *reps[0].DataFile == *reps[1].DataFile {
+		t.Errorf("expected separate data files, got %v and %v", reps[0].DataFile, reps[1].DataFile)
+	}
+	if len(reps[0].DataHash) == 0 || bytes.Equal(reps[0].DataHash, reps[1].DataHash) {
+		t.Error("expected each representation to be hashed separately")
+	}
+
+	// the same representations are found from any of them
+	fromEdited, err := tl.ItemRepresentations(context.Background(), reps[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fromEdited) != 2 || fromEdited[0].ID != primaryID {
+		t.Errorf("expected the same representations from the edited item, got %d with primary %d", len(fromEdited), fromEdited[0].ID)
+	}
+
+	// importing again deduplicates each representation
+	importGraphs()
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 items after importing the same representations again, got %d", count)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// mediaProcessingVersion is the version of the processing that is done to media
+// data files (thumbnails, thumbhashes). Increment it when that processing changes
+// in a way that makes it worth redoing for existing items (see ReprocessMedia).
+const mediaProcessingVersion = 1
+
+// reprocessMediaPageSize is how many items are loaded at a time by ReprocessMedia.
+const reprocessMediaPageSize = 100
+
+// MediaFilter selects which media items to reprocess. Empty fields match all items.
+type MediaFilter struct {
+	DataSourceName string `json:"data_source_name,omitempty"`
+	ImportID       int64  `json:"import_id,omitempty"`
+
+	// A media type (e.g. "image/jpeg") or a media type prefix (e.g. "video/").
+	MediaType string `json:"media_type,omitempty"`
+}
+
+// ReprocessMediaOptions configures a media reprocessing pass.
+type ReprocessMediaOptions struct {
+	// The processing version to bring items up to; items already at this version
+	// (or a later one) are skipped. Default: the current media processing version.
+	// Applications that extract their own metadata may use their own version.
+	Version int `json:"version,omitempty"`
+
+	// If set, the pass resumes after this item, which is the
+	// LastItemID of a previous, incomplete pass.
+	ResumeAfterItemID int64 `json:"resume_after_item_id,omitempty"`
+
+	// How many items to process at the same time. Default: 2.
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// If set, metadata is extracted from each data file with this function
+	// (given the full path to the file and its media type) and merged into
+	// the item's metadata, replacing any existing values with the same keys.
+	ExtractMetadata func(ctx context.Context, filename, mediaType string) (Metadata, error) `json:"-"`
+
+	// If set, this function will be called after each
+	// page of items is processed.
+	ProgressFunc func(ReprocessMediaResult) `json:"-"`
+}
+
+// ReprocessMediaResult is the outcome (or progress) of a media reprocessing pass.
+// If the pass did not complete, it can be resumed by passing LastItemID as the
+// ResumeAfterItemID option.
+type ReprocessMediaResult struct {
+	Reprocessed int64 `json:"reprocessed"`
+	Failed      int64 `json:"failed"` // these keep their old version, so they are tried again next time
+	LastItemID  int64 `json:"last_item_id"`
+	Complete    bool  `json:"complete"`
+}
+
+// mediaItem is an item to be reprocessed by ReprocessMedia.
+type mediaItem struct {
+	rowID    int64
+	dataFile string
+	dataType string
+	metadata *string
+}
+
+// ReprocessMedia regenerates thumbnails and thumbhashes of media items (images, video,
+// and audio with data files), and re-extracts their metadata if configured to, without
+// re-importing them. Each item records the version of processing applied to it, so
+// items already at the desired version are skipped; running it again after it completes
+// only processes items that failed the last time.
+func (tl *Timeline) ReprocessMedia(ctx context.Context, filter MediaFilter, opts ReprocessMediaOptions) (ReprocessMediaResult, error) {
+	if err := tl.checkWritable("reprocess media"); err != nil {
+		return ReprocessMediaResult{}, err
+	}
+
+	result := ReprocessMediaResult{LastItemID: opts.ResumeAfterItemID}
+
+	if opts.Version <= 0 {
+		opts.Version = mediaProcessingVersion
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 2
+	}
+
+	logger := Log.Named("reprocess_media")
+	logger.Info("reprocessing media",
+		zap.Int("version", opts.Version),
+		zap.Int64("resuming_after_item_id", result.LastItemID))
+
+	for {
+		page, err := tl.reprocessMediaPage(ctx, filter, opts.Version, result.LastItemID)
+		if err != nil {
+			return result, err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		throttle := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, it := range page {
+			throttle <- struct{}{}
+			wg.Add(1)
+			go func(it mediaItem) {
+				defer func() {
+					<-throttle
+					wg.Done()
+				}()
+				if err := tl.reprocessMediaItem(ctx, it, opts); err != nil {
+					logger.Warn("unable to reprocess media item",
+						zap.Int64("item_id", it.rowID),
+						zap.String("data_file", it.dataFile),
+						zap.Error(err))
+					atomic.AddInt64(&result.Failed, 1)
+					return
+				}
+				atomic.AddInt64(&result.Reprocessed, 1)
+			}(it)
+		}
+		wg.Wait()
+
+		// only advance the checkpoint once the whole page is done, so
+		// that a canceled page is processed again when resuming
+		if err := ctx.Err(); err != nil {
+			logger.Info("media reprocessing canceled",
+				zap.Int64("reprocessed", result.Reprocessed),
+				zap.Int64("last_item_id", result.LastItemID))
+			return result, err
+		}
+		result.LastItemID = page[len(page)-1].rowID
+
+		if opts.ProgressFunc != nil {
+			opts.ProgressFunc(result)
+		}
+	}
+
+	result.Complete = true
+
+	logger.Info("media reprocessing complete",
+		zap.Int64("reprocessed", result.Reprocessed),
+		zap.Int64("failed", result.Failed))
+
+	return result, nil
+}
+
+// reprocessMediaPage returns the next page of media items after the given item ID
+// that match the filter and are not yet at the given processing version.
+func (tl *Timeline) reprocessMediaPage(ctx context.Context, filter MediaFilter, version int, afterItemID int64) ([]mediaItem, error) {
+	q := `SELECT items.id, items.data_file, items.data_type, items.metadata
+		FROM items
+		LEFT JOIN data_sources ON data_sources.id = items.data_source_id
+		WHERE items.id > ? AND items.data_file IS NOT NULL
+			AND (items.data_type LIKE 'image/%' OR items.data_type LIKE 'video/%' OR items.data_type LIKE 'audio/%')
+			AND (items.media_version IS NULL OR items.media_version < ?)`
+	args := []any{afterItemID, version}
+	if filter.DataSourceName != "" {
+		q += " AND data_sources.name=?"
+		args = append(args, filter.DataSourceName)
+	}
+	if filter.ImportID != 0 {
+		q += " AND items.import_id=?"
+		args = append(args, filter.ImportID)
+	}
+	if filter.MediaType != "" {
+		if strings.HasSuffix(filter.MediaType, "/") {
+			q += " AND items.data_type LIKE ?"
+			args = append(args, filter.MediaType+"%")
+		} else {
+			q += " AND items.data_type=?"
+			args = append(args, filter.MediaType)
+		}
+	}
+	q += " ORDER BY items.id LIMIT ?"
+	args = append(args, reprocessMediaPageSize)
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying media items: %v", err)
+	}
+	defer rows.Close()
+
+	var page []mediaItem
+	for rows.Next() {
+		var it mediaItem
+		if err := rows.Scan(&it.rowID, &it.dataFile, &it.dataType, &it.metadata); err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		page = append(page, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return page, nil
+}
+
+// reprocessMediaItem regenerates the thumbnail and thumbhash of the item (if it qualifies
+// for a thumbnail), re-extracts its metadata (if enabled), and records the new version.
+func (tl *Timeline) reprocessMediaItem(ctx context.Context, it mediaItem, opts ReprocessMediaOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	thumbHash, err := tl.regenerateThumbnail(ctx, it.rowID, it.dataFile, it.dataType)
+	if err != nil {
+		return err
+	}
+
+	var metadataJSON *string
+	if opts.ExtractMetadata != nil {
+		extracted, err := opts.ExtractMetadata(ctx, tl.FullPath(it.dataFile), it.dataType)
+		if err != nil {
+			return fmt.Errorf("extracting metadata: %w", err)
+		}
+		meta := make(Metadata)
+		if it.metadata != nil && *it.metadata != "" {
+			if err := json.Unmarshal([]byte(*it.metadata), &meta); err != nil {
+				return fmt.Errorf("decoding existing metadata: %v", err)
+			}
+		}
+		meta.Merge(extracted, MetaMergeReplace)
+		meta.Clean()
+		if len(meta) > 0 {
+			encoded, err := json.Marshal(meta)
+			if err != nil {
+				return fmt.Errorf("encoding metadata: %v", err)
+			}
+			s := string(encoded)
+			metadataJSON = &s
+		}
+	}
+
+	tl.dbMu.Lock()
+	_, err = tl.db.ExecContext(ctx,
+		`UPDATE items
+		SET media_version=?,
+			thumb_hash=coalesce(?, thumb_hash),
+			metadata=CASE WHEN ? THEN ? ELSE metadata END
+		WHERE id=?`, // TODO: limit 1 (see https://github.com/mattn/go-sqlite3/pull/802)
+		opts.Version, thumbHash, opts.ExtractMetadata != nil, metadataJSON, it.rowID)
+	tl.dbMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("storing reprocessed media: %v", err)
+	}
+
+	return nil
+}
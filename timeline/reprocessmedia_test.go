@@ -0,0 +1,95 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReprocessMediaVersions(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	ts := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	song, podcast := testFileItem("song", ts), testFileItem("podcast", ts.Add(time.Hour))
+	song.Content.MediaType, podcast.Content.MediaType = "audio/mpeg", "audio/ogg"
+	importTestItems(t, tl, song, podcast, testFileItem("other", ts), testMessage("text", ts))
+
+	var extracted atomic.Int64
+	reprocess := func(version int, filter MediaFilter) ReprocessMediaResult {
+		t.Helper()
+		result, err := tl.ReprocessMedia(ctx, filter, ReprocessMediaOptions{
+			Version: version,
+			ExtractMetadata: func(_ context.Context, filename, _ string) (Metadata, error) {
+				extracted.Add(1)
+				return Metadata{"Source": filepath.Base(filename), "Version": version}, nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("reprocessing media: %v", err)
+		}
+		if !result.Complete || result.Failed != 0 {
+			t.Fatalf("expected complete reprocessing without failures, got %+v", result)
+		}
+		return result
+	}
+
+	// only media items are reprocessed, and only once per version
+	if result := reprocess(1, MediaFilter{}); result.Reprocessed != 2 {
+		t.Errorf("expected 2 media items to be reprocessed, got %d", result.Reprocessed)
+	}
+	if result := reprocess(1, MediaFilter{}); result.Reprocessed != 0 {
+		t.Errorf("expected items already at the current version to be skipped, got %d reprocessed", result.Reprocessed)
+	}
+	if extracted.Load() != 2 {
+		t.Errorf("expected metadata to be extracted twice, got %d", extracted.Load())
+	}
+
+	// bumping the version reprocesses them again, subject to the filter
+	if result := reprocess(2, MediaFilter{MediaType: "audio/mpeg"}); result.Reprocessed != 1 {
+		t.Errorf("expected 1 filtered item to be reprocessed at the new version, got %d", result.Reprocessed)
+	}
+	if result := reprocess(2, MediaFilter{MediaType: "audio/"}); result.Reprocessed != 1 {
+		t.Errorf("expected the remaining item to be reprocessed at the new version, got %d", result.Reprocessed)
+	}
+
+	item, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err := tl.db.QueryRow(`SELECT media_version FROM items WHERE id=?`, item.ID).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("expected item to be at media version 2, got %d", version)
+	}
+	var meta Metadata
+	if err := json.Unmarshal(item.Metadata, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := meta["Version"].(float64); !ok || v != 2 || meta["Source"] == nil {
+		t.Errorf("expected extracted metadata to replace the old values, got %v", meta)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// MissingSourceFileError is returned when an import can't be resumed because
+// files it was importing no longer exist, for example because they were moved.
+// The import can be resumed by giving the new locations of the files in
+// ImportParameters.AltFilenames.
+type MissingSourceFileError struct {
+	ImportID  int64    `json:"import_id"`
+	Filenames []string `json:"filenames"`
+}
+
+func (e MissingSourceFileError) Error() string {
+	return fmt.Sprintf("cannot resume import %d because its source files are missing (alternate filenames may be given): %s",
+		e.ImportID, strings.Join(e.Filenames, ", "))
+}
+
+// resumeFilenames returns the filenames to resume the import with: its original
+// filenames, except those replaced by the alternates, which are keyed by original
+// filename. Replacements must have the same contents as the originals had when the
+// import started. Files that existed when the import started must still exist (if
+// not replaced), otherwise a MissingSourceFileError is returned.
+func (t *Timeline) resumeFilenames(ctx context.Context, importID int64, filenames []string, alt map[string]string) ([]string, error) {
+	for original := range alt {
+		if !slices.Contains(filenames, original) {
+			return nil, fmt.Errorf("alternate given for %s, which is not a file of import %d", original, importID)
+		}
+	}
+
+	hashes, err := t.importFileHashes(ctx, importID)
+	if err != nil {
+		return nil, err
+	}
+
+	resumed := make([]string, len(filenames))
+	var missing []string
+	for i, filename := range filenames {
+		resumed[i] = filename
+
+		if replacement, ok := alt[filename]; ok {
+			expected, ok := hashes[filename]
+			if !ok {
+				return nil, fmt.Errorf("contents of %s were not recorded when the import started, so it cannot be replaced", filename)
+			}
+			actual, err := hashImportFiles(ctx, []string{replacement})
+			if err != nil {
+				return nil, fmt.Errorf("hashing alternate file: %w", err)
+			}
+			if h, ok := actual[replacement]; !ok {
+				return nil, fmt.Errorf("alternate for %s does not exist or is not a regular file: %s", filename, replacement)
+			} else if h != expected {
+				return nil, fmt.Errorf("alternate for %s does not have the same contents: %s", filename, replacement)
+			}
+			resumed[i] = replacement
+			continue
+		}
+
+		// only files that were on disk when the import started need to be
+		// (not every data source takes paths on disk as input)
+		if _, ok := hashes[filename]; !ok {
+			continue
+		}
+		if _, err := os.Stat(filename); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, filename)
+		} else if err != nil {
+			return nil, fmt.Errorf("checking source file: %w", err)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, MissingSourceFileError{ImportID: importID, Filenames: missing}
+	}
+
+	// remember the contents of the files by their new names, in case the import is resumed again
+	if len(alt) > 0 {
+		for original, replacement := range alt {
+			hashes[replacement] = hashes[original]
+			delete(hashes, original)
+		}
+		hashesJSON, err := json.Marshal(hashes)
+		if err != nil {
+			return nil, fmt.Errorf("encoding file hashes: %w", err)
+		}
+		t.dbMu.Lock()
+		_, err = t.db.ExecContext(ctx, `UPDATE imports SET file_hashes=? WHERE id=?`, string(hashesJSON), importID)
+		t.dbMu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("recording file hashes: %w", err)
+		}
+	}
+
+	return resumed, nil
+}
+
+// importFileHashes returns the hashes of the files of the import that were recorded
+// when it started, keyed by filename.
+func (t *Timeline) importFileHashes(ctx context.Context, importID int64) (map[string]string, error) {
+	var hashesJSON *string
+	t.dbMu.RLock()
+	err := t.db.QueryRowContext(ctx, `SELECT file_hashes FROM imports WHERE id=? LIMIT 1`, importID).Scan(&hashesJSON)
+	t.dbMu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("querying file hashes of import %d: %w", importID, err)
+	}
+	hashes := make(map[string]string)
+	if hashesJSON != nil && *hashesJSON != "" {
+		if err := json.Unmarshal([]byte(*hashesJSON), &hashes); err != nil {
+			return nil, fmt.Errorf("decoding file hashes: %w", err)
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,112 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResumeWithMissingSourceFile(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+
+	var filesSeen []string
+	testFileImport = func(ctx context.Context, filenames []string, itemChan chan<- *Graph, opt ListingOptions) error {
+		filesSeen = append(filesSeen, filenames...)
+		return importLinesAsMessages(ctx, filenames, itemChan, opt)
+	}
+
+	dir := t.TempDir()
+	original := filepath.Join(dir, "export.txt")
+	if err := os.WriteFile(original, []byte("a\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.Import(ctx, ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{original},
+	}); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	// pretend the import was interrupted, then move its file
+	var importID int64
+	if err := tl.db.QueryRow(`SELECT max(id) FROM imports`).Scan(&importID); err != nil {
+		t.Fatal(err)
+	}
+	chkpt, err := marshalGob(checkpoint{Filenames: []string{original}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.db.Exec(`UPDATE imports SET checkpoint=?, status=? WHERE id=?`, chkpt, importStatusPartial, importID); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, "moved.txt")
+	if err := os.Rename(original, moved); err != nil {
+		t.Fatal(err)
+	}
+	filesSeen = nil
+
+	resume := func(alt map[string]string) error {
+		return tl.Import(ctx, ImportParameters{ResumeImportID: importID, AltFilenames: alt})
+	}
+
+	var missingErr MissingSourceFileError
+	err = resume(nil)
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("expected MissingSourceFileError, got: %v", err)
+	}
+	if missingErr.ImportID != importID || !slices.Equal(missingErr.Filenames, []string{original}) {
+		t.Errorf("expected error to list %s as missing from import %d, got %+v", original, importID, missingErr)
+	}
+
+	// a replacement with different contents is refused
+	different := filepath.Join(dir, "different.txt")
+	if err := os.WriteFile(different, []byte("a\nb\nc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := resume(map[string]string{original: different}); err == nil {
+		t.Error("expected error resuming with a replacement that has different contents")
+	}
+	if len(filesSeen) > 0 {
+		t.Errorf("expected no files to be imported, got %v", filesSeen)
+	}
+
+	// the moved file is accepted
+	if err := resume(map[string]string{original: moved}); err != nil {
+		t.Fatalf("resuming with moved file: %v", err)
+	}
+	if !slices.Equal(filesSeen, []string{moved}) {
+		t.Errorf("expected moved file to be imported, got %v", filesSeen)
+	}
+
+	// alternates are only for resuming
+	err = tl.Import(ctx, ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{moved},
+		AltFilenames:   map[string]string{original: moved},
+	})
+	if err == nil {
+		t.Error("expected error giving alternate filenames to a new import")
+	}
+}
@@ -43,15 +43,17 @@ CREATE TABLE IF NOT EXISTS "accounts" (
 CREATE TABLE IF NOT EXISTS "imports" (
 	"id" INTEGER PRIMARY KEY,
 	"data_source_id" INTEGER, -- TODO: remove this, as imports will become multi-data-source, probably?
-	"mode" TEXT NOT NULL, -- "api", "file", "manual"
+	"mode" TEXT NOT NULL, -- "api", "file", "manual", "archived" (stands in for imports removed by compaction)
 	"account_id" INTEGER, -- for "api" mode
 	"processing_options" TEXT, -- JSON encoding of additional import parameters and processing options
 	"snapshot_date" INTEGER, -- when the dataset was created; i.e. the "as of" date of the data being imported, reported by the data source
 	"started" INTEGER NOT NULL DEFAULT (unixepoch()), -- timestamp when import started
 	"ended" INTEGER, -- timestamp when import's last run ended
-	"status" TEXT NOT NULL DEFAULT 'started', -- started, abort, ok, err
+	"status" TEXT NOT NULL DEFAULT 'started', -- started, abort, ok, err, partial
 	"checkpoint" BLOB, -- for resuming the import later
 	"metadata" TEXT, -- additional information about the import, generally provided by data source
+	"file_hashes" TEXT, -- JSON object mapping each imported filename to the hash of its contents (for "file" mode)
+	"cancel_reason" TEXT, -- why the import stopped before it finished, if it did (see CancelReason)
 	FOREIGN KEY ("data_source_id") REFERENCES "data_sources"("id") ON UPDATE CASCADE,
 	FOREIGN KEY ("account_id") REFERENCES "accounts"("id") ON UPDATE CASCADE
 ) STRICT;
@@ -59,6 +61,40 @@ CREATE TABLE IF NOT EXISTS "imports" (
 CREATE INDEX IF NOT EXISTS "idx_imports_started" ON "imports"("started");
 CREATE INDEX IF NOT EXISTS "idx_imports_status" ON "imports"("status");
 
+-- Arbitrary key/value labels given to imports by the user, for organizing
+-- and querying import history (see ListImports).
+CREATE TABLE IF NOT EXISTS "import_labels" (
+	"import_id" INTEGER NOT NULL,
+	"key" TEXT NOT NULL,
+	"value" TEXT NOT NULL,
+	PRIMARY KEY ("import_id", "key"),
+	FOREIGN KEY ("import_id") REFERENCES "imports"("id") ON UPDATE CASCADE ON DELETE CASCADE
+) STRICT, WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS "idx_import_labels_key_value" ON "import_labels"("key", "value");
+
+-- The number of items and files skipped by each import, by reason, for
+-- troubleshooting imports (see ExportImportDebugBundle).
+CREATE TABLE IF NOT EXISTS "import_skip_reasons" (
+	"import_id" INTEGER NOT NULL,
+	"reason" TEXT NOT NULL,
+	"count" INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY ("import_id", "reason"),
+	FOREIGN KEY ("import_id") REFERENCES "imports"("id") ON UPDATE CASCADE ON DELETE CASCADE
+) STRICT, WITHOUT ROWID;
+
+-- Integrity checks verify that data files are intact. Since they can take a long
+-- time on large repositories, their progress is recorded so they can be resumed.
+CREATE TABLE IF NOT EXISTS "integrity_checks" (
+	"id" INTEGER PRIMARY KEY,
+	"started" INTEGER NOT NULL DEFAULT (unixepoch()), -- timestamp when check started
+	"ended" INTEGER, -- timestamp when check's last run ended
+	"status" TEXT NOT NULL DEFAULT 'started', -- started, abort, ok, err
+	"last_item_id" INTEGER, -- the last item that was checked; for resuming the check later
+	"checked_count" INTEGER NOT NULL DEFAULT 0, -- number of data files checked so far
+	"problems" TEXT -- JSON array of problems found so far
+) STRICT;
+
 -- Entity type names are hard-coded (but their IDs are not).
 CREATE TABLE IF NOT EXISTS "entity_types" (
 	"id" INTEGER PRIMARY KEY,
@@ -82,6 +118,8 @@ CREATE TABLE IF NOT EXISTS "entities" (
 	"metadata" TEXT, -- optional extra information, encoded as JSON (should almost never be used! use attributes instead)
 	"hidden" INTEGER, -- if owner would like to forget about this person, don't show in search results, etc.
 	"deleted" INTEGER, -- timestamp when item was moved to trash and can be purged after some amount of time after that
+	"name_import_id" INTEGER REFERENCES "imports"("id") ON UPDATE CASCADE ON DELETE SET NULL, -- import that provided the current name
+	"picture_import_id" INTEGER REFERENCES "imports"("id") ON UPDATE CASCADE ON DELETE SET NULL, -- import that provided the current picture
 	FOREIGN KEY ("type_id") REFERENCES "entity_types"("id") ON UPDATE CASCADE,
 	FOREIGN KEY ("import_id") REFERENCES "imports"("id") ON UPDATE CASCADE
 ) STRICT;
@@ -170,21 +208,30 @@ CREATE TABLE IF NOT EXISTS "items" (
 	"modified_import_id" INTEGER, -- the import that last modified this existing item
 	"attribute_id" INTEGER, -- owner, creator, or originator attributed to this item
 	"classification_id" INTEGER,
+	"category" TEXT, -- what the item is to the user, for faceted browsing (photo, message, location, etc.; see the Category constants and Classifier)
 	"original_id" TEXT, -- ID provided by the data source
+	"account_id" INTEGER, -- if set, original_id is only unique among items imported with this account, rather than among all items from the data source (see DedupScope)
 	"original_location" TEXT,     -- path or location of the file/data on the original data source; should include filename if applicable
 	"intermediate_location" TEXT, -- path or location of the file/data from the import dataset (e.g. after exporting from the data source); should include filename if application
 	"filename" TEXT, -- name of the original file as named by the owner, if known
 	"timestamp" INTEGER, -- unix epoch millisecond timestamp when item content was originally created (NOT when the database row was created)
+	"timestamp_micros" INTEGER, -- the timestamp as unix epoch microseconds, only if it has sub-millisecond precision (see TimestampPrecision)
+	"original_timestamp" INTEGER, -- if the timestamp from the data source was in the future and was clamped to the time of import, the timestamp from the data source (unix epoch ms)
 	"timespan" INTEGER,  -- ending unix epoch ms timestamp if this item spans time (instead of being a single point in time); can be used in conjunction with timeframe to suggest duration
 	"timeframe" INTEGER, -- ending unix epoch ms timestamp if this item takes place somewhere between timestamp and timeframe, but it's not certain exactly when
 	"time_offset" INTEGER, -- offset of original timestamp/timespan/timeframe in seconds east of UTC/GMT (time zone)
 	"time_uncertainty" INTEGER, -- if nonzero, time columns may be inaccurate on the order of this number of milliseconds, essentially sliding the times in a fuzzy interval
+	"sequence" INTEGER, -- ordinal of the item within its import (as emitted by the data source), used to break ties between identical timestamps
+	"source_file" TEXT, -- the file within the import that the item was read from, as reported by the data source (useful for debugging)
+	"source_offset" INTEGER, -- position of the item within its source file (e.g. line number or byte offset), as reported by the data source
 	"stored" INTEGER NOT NULL DEFAULT (unixepoch()), -- unix epoch second timestamp when row was created or last retrieved from source
 	"modified" INTEGER, -- unix epoch second timestamp when item was manually modified (not via an import); if not null, then item is "not clean"
 	"data_type" TEXT,  -- the MIME type (aka "media type") of the data
 	"data_text" TEXT COLLATE NOCASE, -- item content, if text-encoded and not very long
+	"normalized_text" TEXT, -- data_text normalized according to the import's text normalization options, for deduplication and search (any full-text index should index this instead of data_text)
 	"data_file" TEXT COLLATE NOCASE, -- item filename, if non-text or not suitable for storage in DB (usually media), relative to repo root
 	"data_hash" BLOB, -- BLAKE3 checksum of contents of the data file
+	"data_length" INTEGER, -- number of bytes of data, recorded when data is appended to (see AppendMode) so the same content isn't appended twice; if NULL, the size of the stored data
 	"metadata" TEXT,  -- optional extra information, encoded as JSON for flexibility
 	"longitude" REAL, -- or equivalent X-coord for the coordinate system
 	"latitude" REAL,  -- or equivalent Y-coord for the coordinate system
@@ -193,11 +240,14 @@ CREATE TABLE IF NOT EXISTS "items" (
 	"coordinate_uncertainty" REAL, -- if nonzero, lat/lon values may be inaccurate by this amount (same unit as coordinates)
 	"note" TEXT,      -- optional user-added information
 	"starred" INTEGER, -- like a bookmark; TODO: different numbers indicate different kinds of stars or something?
+	"visibility" TEXT, -- who may see this item: NULL or 'public' = anyone, 'private' = only the owner of the timeline, 'shared' = the owner and viewers in item_viewers
 	"thumb_hash" BLOB, -- bytes of the ThumbHash that represent a visual preview of the item (https://evanw.github.io/thumbhash/ and https://github.com/evanw/thumbhash)
+	"media_version" INTEGER, -- version of the media processing (thumbnails, metadata extraction) last applied to the data file; see ReprocessMedia
 	-- TODO: unique on these two hashes?
 	"original_id_hash" BLOB, -- a hash of the data source and original ID of the item, also used for duplicate detection, optionally stored when item is deleted
 	"initial_content_hash" BLOB, -- a hash computed during initial import, used for duplicate detection (remains same even if item is modified by user)
 	"retrieval_key" BLOB, -- an optional opaque value that indicates this item may not be fully populated in a single import; not an ID but still a unique identifier
+	"global_id" TEXT UNIQUE, -- optional globally-unique ID of the item (UUIDv7) that stays the same across repos, for export/import and sync; internal joins use the row ID
 	"hidden" INTEGER,  -- if owner would like to forget about this item, don't show it in search results, etc. TODO: keep?
 	"deleted" INTEGER, -- 1 = if the columns will be erased, they have been erased; >1 = a unix epoch timestamp after which the columns can be erased
 	FOREIGN KEY ("data_source_id") REFERENCES "data_sources"("id") ON UPDATE CASCADE,
@@ -206,13 +256,100 @@ CREATE TABLE IF NOT EXISTS "items" (
 	FOREIGN KEY ("attribute_id") REFERENCES "attributes"("id") ON UPDATE CASCADE,
 	FOREIGN KEY ("classification_id") REFERENCES "classifications"("id") ON UPDATE CASCADE,
 	-- TODO: UNIQUE("import_id", "intermediate_location") maybe? the only problem is I could see embedded items like album art violating this -- unless embedded items don't have an intermediate_location
-	UNIQUE ("data_source_id", "original_id"),
 	UNIQUE ("retrieval_key")
 ) STRICT;
 
+-- Prior versions of items, if the item's content was changed by a later version
+-- from the data source (for example, an edited message) and edit history is kept.
+CREATE TABLE IF NOT EXISTS "item_versions" (
+	"id" INTEGER PRIMARY KEY,
+	"item_id" INTEGER NOT NULL, -- the current version of the item
+	"import_id" INTEGER, -- the import that brought in this version
+	"replaced" INTEGER NOT NULL DEFAULT (unixepoch()), -- unix epoch second timestamp when this version was superseded
+	"timestamp" INTEGER,
+	"data_type" TEXT,
+	"data_text" TEXT,
+	"data_file" TEXT,
+	"data_hash" BLOB,
+	"metadata" TEXT,
+	FOREIGN KEY ("item_id") REFERENCES "items"("id") ON UPDATE CASCADE ON DELETE CASCADE,
+	FOREIGN KEY ("import_id") REFERENCES "imports"("id") ON UPDATE CASCADE ON DELETE SET NULL
+) STRICT;
+
+CREATE INDEX IF NOT EXISTS "idx_item_versions_item_id" ON "item_versions"("item_id");
+
+-- The points of items that span a sequence of coordinates, such as GPS tracks and
+-- routes, in order. (The item's own coordinates are generally the first point.)
+CREATE TABLE IF NOT EXISTS "item_geometry" (
+	"item_id" INTEGER NOT NULL,
+	"sequence" INTEGER NOT NULL, -- order of the point within the geometry, starting at 0
+	"longitude" REAL NOT NULL,
+	"latitude" REAL NOT NULL,
+	"altitude" REAL,
+	PRIMARY KEY ("item_id", "sequence"),
+	FOREIGN KEY ("item_id") REFERENCES "items"("id") ON UPDATE CASCADE ON DELETE CASCADE
+) STRICT, WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS "idx_item_geometry_latitude_longitude" ON "item_geometry"("latitude", "longitude");
+
+-- Viewers that a 'shared' item is visible to, in addition to the owner of the
+-- timeline. A viewer is an opaque identity provided by the application.
+CREATE TABLE IF NOT EXISTS "item_viewers" (
+	"item_id" INTEGER NOT NULL,
+	"viewer" TEXT NOT NULL,
+	FOREIGN KEY ("item_id") REFERENCES "items"("id") ON UPDATE CASCADE ON DELETE CASCADE,
+	UNIQUE ("item_id", "viewer")
+) STRICT;
+
+-- Attempts to complete items that were only partially imported (items with a
+-- retrieval key but no content) by asking their data source for the rest.
+CREATE TABLE IF NOT EXISTS "item_completions" (
+	"item_id" INTEGER PRIMARY KEY,
+	"attempts" INTEGER NOT NULL DEFAULT 0,
+	"last_attempt" INTEGER, -- unix epoch second timestamp of the most recent attempt
+	"last_error" TEXT,
+	"failed" INTEGER NOT NULL DEFAULT 0, -- 1 if the item could not be completed within the maximum number of attempts
+	FOREIGN KEY ("item_id") REFERENCES "items"("id") ON UPDATE CASCADE ON DELETE CASCADE
+) STRICT;
+
+-- Data files that are stored compressed on disk (see the CompressDataFiles processing option).
+-- Data files not listed here are stored as-is. Keyed by path since items can share data files.
+CREATE TABLE IF NOT EXISTS "compressed_data_files" (
+	"data_file" TEXT PRIMARY KEY COLLATE NOCASE, -- same as the data_file column of items, relative to repo root
+	"compression" TEXT NOT NULL -- gzip or zstd
+) WITHOUT ROWID;
+
+-- Files derived from data files by post-processors, such as images converted to a more
+-- widely supported format; they are stored alongside the data file they are made from.
+CREATE TABLE IF NOT EXISTS "derived_data_files" (
+	"derived_file" TEXT PRIMARY KEY COLLATE NOCASE, -- relative to repo root, like data_file
+	"data_file" TEXT NOT NULL COLLATE NOCASE, -- same as the data_file column of items
+	"media_type" TEXT NOT NULL
+) WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS "idx_derived_data_files_data_file" ON "derived_data_files"("data_file");
+
+-- The items given by the data source in each import, with digests of their fields as they
+-- were given, even if the item was already in the timeline and wasn't updated. This allows
+-- comparing two imports of the same data (see Timeline.Diff).
+CREATE TABLE IF NOT EXISTS "import_items" (
+	"import_id" INTEGER NOT NULL,
+	"item_id" INTEGER NOT NULL,
+	"field_digests" TEXT, -- JSON object mapping field names to short hashes of their values
+	PRIMARY KEY ("import_id", "item_id"),
+	FOREIGN KEY ("import_id") REFERENCES "imports"("id") ON UPDATE CASCADE ON DELETE CASCADE,
+	FOREIGN KEY ("item_id") REFERENCES "items"("id") ON UPDATE CASCADE ON DELETE CASCADE
+) STRICT, WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS "idx_import_items_item_id" ON "import_items"("item_id");
+
 -- TODO: figure out which of these are actually necessary (use EXPLAIN QUERY PLAN SELECT ...) -- (add a ton of data to a timeline with no indexes here, then perform some searches; then add indexes until they get fast)
+-- (timelines created before account_id existed had this as a table constraint without the account; see rebuildItemsTable)
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_items_original_id" ON "items"("data_source_id", "original_id", coalesce("account_id", 0));
+CREATE INDEX IF NOT EXISTS "idx_items_category" ON "items"("category");
 CREATE INDEX IF NOT EXISTS "idx_items_filename" ON "items"("filename");
 CREATE INDEX IF NOT EXISTS "idx_items_timestamp" ON "items"("timestamp");
+CREATE INDEX IF NOT EXISTS "idx_items_timestamp_sequence" ON "items"("timestamp", "sequence");
 CREATE INDEX IF NOT EXISTS "idx_items_timespan" ON "items"("timespan");
 CREATE INDEX IF NOT EXISTS "idx_items_timeframe" ON "items"("timeframe");
 CREATE INDEX IF NOT EXISTS "idx_items_time_offset" ON "items"("time_offset");
@@ -224,6 +361,7 @@ CREATE INDEX IF NOT EXISTS "idx_items_latitude" ON "items"("latitude");
 CREATE INDEX IF NOT EXISTS "idx_items_altitude" ON "items"("altitude");
 CREATE INDEX IF NOT EXISTS "idx_items_hidden" ON "items"("hidden");
 CREATE INDEX IF NOT EXISTS "idx_items_deleted" ON "items"("deleted");
+CREATE INDEX IF NOT EXISTS "idx_items_attribute_id" ON "items"("attribute_id");
 CREATE INDEX IF NOT EXISTS "idx_items_initial_hash" ON "items"("initial_hash");
 
 -- Relationships may exist between and across items and entities. A row
@@ -256,6 +394,8 @@ CREATE TABLE IF NOT EXISTS "relationships" (
 CREATE INDEX IF NOT EXISTS "idx_relationships_value" ON "relationships"("value");
 CREATE INDEX IF NOT EXISTS "idx_relationships_start" ON "relationships"("start");
 CREATE INDEX IF NOT EXISTS "idx_relationships_end" ON "relationships"("end");
+CREATE INDEX IF NOT EXISTS "idx_relationships_from_attribute_id" ON "relationships"("from_attribute_id");
+CREATE INDEX IF NOT EXISTS "idx_relationships_to_attribute_id" ON "relationships"("to_attribute_id");
 
 -- Relations define the way relationships connect. They are described by natural
 -- language phrases such as "in reply to", "picture of", or "attached to"; or could
@@ -24,8 +24,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
-	"os"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -39,21 +38,32 @@ type ItemSearchParams struct {
 	// The UUID of the open timeline to search.
 	Repo string `json:"repo,omitempty"`
 
-	RowID []int64 `json:"row_id,omitempty"`
-	// AccountID  []int    `json:"account_id,omitempty"` // TODO: restore this, if useful
+	RowID          []int64  `json:"row_id,omitempty"`
+	AccountID      []int64  `json:"account_id,omitempty"`
 	DataSourceName []string `json:"data_source,omitempty"`
 	ImportID       []int64  `json:"import_id,omitempty"`
 	AttributeID    []int64  `json:"attribute_id,omitempty"`
 	EntityID       []int64  `json:"entity_id,omitempty"`
 	Classification []string `json:"classification,omitempty"`
+	Category       []string `json:"category,omitempty"` // see the Category constants
 	OriginalID     []string `jsson:"original_id,omitempty"`
 	DataType       []string `json:"data_type,omitempty"`
 	DataText       []string `json:"data_text,omitempty"`
 	DataFile       []string `json:"data_file,omitempty"`
 
-	// TODO: how do we effectively search metadata? maybe virtual columns? https://antonz.org/json-virtual-columns/
-	// TODO: Well, this query was fast: `SELECT * FROM items WHERE items.metadata->>'$.Make' = 'Google' LIMIT 10`
-	// Metadata    map[string][]any `json:"metadata,omitempty"`
+	// If set, DataText terms are normalized with these rules and matched
+	// against the normalized text of items (or their original text, if
+	// they were not normalized when imported). This should generally be
+	// the same as the text normalization used when importing.
+	TextNormalization *TextNormalization `json:"text_normalization,omitempty"`
+
+	// Filters on the metadata of items, keyed by metadata key. An item
+	// matches a key if its value for that key equals any of the values
+	// (numbers, strings, and booleans compare as typed values). With no
+	// values, the item only needs to have the key; a nil value matches
+	// items that don't have the key.
+	// TODO: maybe virtual columns for commonly-searched keys? https://antonz.org/json-virtual-columns/
+	Metadata map[string][]any `json:"metadata,omitempty"`
 
 	// TODO: a way to get items with EntityID, but also any relation FromEntityID or ToEntityID...
 	ToAttributeID []int64 `json:"to_attribute_id,omitempty"`
@@ -85,6 +95,8 @@ type ItemSearchParams struct {
 
 	NoLocation bool `json:"no_location,omitempty"` // if true, require location columns to be NULL regardless of max/min lat/lon
 
+	Favorite bool `json:"favorite,omitempty"` // if true, only items marked as favorites (see SetFavorite)
+
 	// proximity searches (location and time are mutually exclusive)
 	Timestamp *time.Time `json:"timestamp,omitempty"`
 	Latitude  *float64   `json:"latitude,omitempty"`
@@ -157,8 +169,25 @@ type ItemSearchParams struct {
 	// If true, include deleted items (that haven't been erased yet).
 	Deleted bool `json:"deleted,omitempty"`
 
+	// If true, include the prior versions of each item, if any.
+	WithHistory bool `json:"with_history,omitempty"`
+
+	// If true, thumbnails are generated in the background for any of the
+	// resulting items that should have one but don't (see
+	// ItemsWithoutThumbnails), so they will appear eventually.
+	HealThumbnails bool `json:"heal_thumbnails,omitempty"`
+
+	// If set, only items this viewer is allowed to see are returned:
+	// public items, and shared items that are shared with the viewer.
+	// If nil, visibility is not enforced (i.e. the owner is searching).
+	Viewer *string `json:"viewer,omitempty"`
+
 	// stores the converted names to row IDs
 	classificationIDs []int64
+
+	// if true, only the row ID and timestamp of matching items
+	// are selected, for aggregating results in the DB
+	timestampsOnly bool
 }
 
 type SearchResults struct {
@@ -287,31 +316,41 @@ func (tl *Timeline) Search(ctx context.Context, params ItemSearchParams) (Search
 
 	// traverse relationships
 	for _, sr := range results {
-		err = tl.expandRelationships(ctx, tx, params.Related, sr)
+		err = tl.expandRelationships(ctx, tx, params.Related, params.Viewer, sr)
 		if err != nil {
 			return SearchResults{}, err
 		}
 	}
 
+	// include edit history, if requested
+	if params.WithHistory {
+		for _, sr := range results {
+			sr.History, err = loadItemVersions(ctx, tx, sr.ID)
+			if err != nil {
+				return SearchResults{}, err
+			}
+		}
+	}
+
 	// include size information, if requested
 	if params.WithSize {
 		for _, sr := range results {
-			if sr.DataText != nil {
-				sr.Size = int64(len(*sr.DataText))
-			}
-			if sr.DataFile != nil {
-				info, err := os.Stat(filepath.Join(tl.Dir(), *sr.DataFile))
-				if err == nil {
-					sr.Size = info.Size()
-				}
-			}
+			tl.fillSize(sr)
+		}
+	}
+
+	if params.HealThumbnails {
+		itemRows := make([]ItemRow, 0, len(results))
+		for _, sr := range results {
+			itemRows = append(itemRows, sr.ItemRow)
 		}
+		tl.healThumbnails(itemRows)
 	}
 
 	return SearchResults{Total: totalCount, Items: results}, nil
 }
 
-// TODO: favorites? or maybe a more flexible albums/lists feature? what to call it... "scrapbooks" or "curations"?
+// TODO: a more flexible albums/lists feature than favorites? what to call it... "scrapbooks" or "curations"?
 
 func (tl *Timeline) convertNamesToIDs(params *ItemSearchParams) {
 	tl.cachesMu.RLock()
@@ -337,8 +376,37 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 		return "", nil, fmt.Errorf("max degrees of separation for relationships is 2")
 	}
 
+	// normalize search terms the same way item text was normalized
+	if tn := params.TextNormalization; tn != nil && len(params.DataText) > 0 {
+		normalized := make([]string, len(params.DataText))
+		for i, v := range params.DataText {
+			var err error
+			normalized[i], err = tn.Normalize(v)
+			if err != nil {
+				return "", nil, err
+			}
+		}
+		params.DataText = normalized
+	}
+
 	tl.convertNamesToIDs(&params)
 
+	metadataKeys := make([]string, 0, len(params.Metadata))
+	for key, vals := range params.Metadata {
+		if key == "" || strings.Contains(key, `"`) {
+			return "", nil, fmt.Errorf("invalid metadata key: %q", key)
+		}
+		for _, v := range vals {
+			switch v.(type) {
+			case nil, string, bool, float64, float32, int, int64, int32:
+			default:
+				return "", nil, fmt.Errorf("metadata key %s: unsupported value type %T", key, v)
+			}
+		}
+		metadataKeys = append(metadataKeys, key)
+	}
+	slices.Sort(metadataKeys) // for a deterministic query
+
 	// When viewing a timeline, it can make more intuitive sense
 	// to only show "root" nodes of item graphs at the "top" of
 	// the search results, so that items which are only attachments,
@@ -358,6 +426,20 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 		itemsTable = "extended_items"
 	}
 
+	// only select from items the viewer is allowed to see; this is done
+	// in a subquery rather than the WHERE clause so that it can't be
+	// bypassed by OrFields
+	var args []any
+	if params.Viewer != nil {
+		itemsTable = fmt.Sprintf(`(SELECT * FROM %s AS visible_items
+			WHERE visible_items.visibility IS NULL
+				OR visible_items.visibility='%s'
+				OR (visible_items.visibility='%s' AND EXISTS (SELECT 1 FROM item_viewers
+					WHERE item_viewers.item_id=visible_items.id AND item_viewers.viewer=?)))`,
+			itemsTable, VisibilityPublic, VisibilityShared)
+		args = append(args, *params.Viewer)
+	}
+
 	// honor inclusivity for bounding-box searches
 	lt, gt := "<", ">"
 	if params.Inclusive {
@@ -371,6 +453,10 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 		// GeoJSON mode is intended to be more efficient; as such, only select coordinate data
 		q = "SELECT items.id, items.latitude, items.longitude"
 	}
+	if params.timestampsOnly {
+		// the joins can yield an item more than once
+		q = "SELECT DISTINCT items.id, items.timestamp"
+	}
 	if params.WithTotal {
 		q += ", count() over() AS total_count"
 	}
@@ -387,12 +473,13 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 	}
 
 	// build the WHERE in terms of groups of OR's that are AND'ed together
-	var args []any
 	var clauseCount int
+	var hasWhere bool
 	and := func(ors func()) {
 		clauseCount = 0
-		if len(args) == 0 {
+		if !hasWhere {
 			q += " WHERE"
+			hasWhere = true
 		} else {
 			if params.OrFields {
 				q += " OR"
@@ -408,7 +495,10 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 		// this is a poor-man's way of undoing it
 		q = strings.TrimSuffix(q, " OR ()")
 		q = strings.TrimSuffix(q, " AND ()")
-		q = strings.TrimSuffix(q, " WHERE ()")
+		if strings.HasSuffix(q, " WHERE ()") {
+			q = strings.TrimSuffix(q, " WHERE ()")
+			hasWhere = false
+		}
 	}
 	or := func(clause string, val any) {
 		if clauseCount > 0 {
@@ -435,6 +525,14 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 			or("data_source_name=?", v)
 		}
 	})
+	and(func() {
+		if params.AccountID != nil && len(params.AccountID) == 0 {
+			or("items.import_id IN (SELECT id FROM imports WHERE account_id IS ?)", nil)
+		}
+		for _, v := range params.AccountID {
+			or("items.import_id IN (SELECT id FROM imports WHERE account_id=?)", v)
+		}
+	})
 	and(func() {
 		if params.ImportID != nil && len(params.ImportID) == 0 {
 			or("items.import_id IS ?", nil)
@@ -468,6 +566,14 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 			}
 		}
 	})
+	and(func() {
+		if params.Category != nil && len(params.Category) == 0 {
+			or("items.category IS ?", nil)
+		}
+		for _, v := range params.Category {
+			or("items.category=?", v)
+		}
+	})
 	and(func() {
 		if params.OriginalID != nil && len(params.OriginalID) == 0 {
 			or("items.original_id IS ?", nil)
@@ -494,7 +600,11 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 			or("items.data_text IS ?", nil)
 		}
 		for _, v := range params.DataText {
-			or("items.data_text LIKE '%' || ? || '%'", v)
+			if params.TextNormalization != nil {
+				or("COALESCE(items.normalized_text, items.data_text) LIKE '%' || ? || '%'", v)
+			} else {
+				or("items.data_text LIKE '%' || ? || '%'", v)
+			}
 		}
 	})
 	and(func() {
@@ -506,6 +616,25 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 		}
 	})
 
+	// (items without metadata may have an empty string, which isn't valid JSON)
+	const metadata = "NULLIF(items.metadata, '')"
+	for _, key := range metadataKeys {
+		path := `$."` + key + `"`
+		and(func() {
+			if len(params.Metadata[key]) == 0 {
+				or("json_type("+metadata+", ?) IS NOT NULL", path)
+			}
+			for _, v := range params.Metadata[key] {
+				if v == nil {
+					or("json_type("+metadata+", ?) IS NULL", path)
+					continue
+				}
+				args = append(args, path)
+				or("json_extract("+metadata+", ?)=?", v)
+			}
+		})
+	}
+
 	and(func() {
 		// TODO: these can probably be in the same 'AND' group like this, right?
 		for _, v := range params.ToAttributeID {
@@ -539,6 +668,11 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 			})
 		}
 	}
+	if params.Favorite {
+		and(func() {
+			or("items.starred IS NOT ?", nil)
+		})
+	}
 	if params.NoLocation {
 		and(func() {
 			or("items.latitude IS ?", nil)
@@ -552,27 +686,35 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 		and(func() {
 			or("items.coordinate_system IS ?", nil)
 		})
-	} else {
+	} else if params.MinLatitude != nil || params.MaxLatitude != nil ||
+		params.MinLongitude != nil || params.MaxLongitude != nil {
+		// the item is in the bounding box if its own coordinates are, or
+		// if any point of its geometry (such as a GPS track) is
+		var itemBounds, geometryBounds []string
+		var boundArgs []any
+		bound := func(column, op string, val *float64) {
+			itemBounds = append(itemBounds, "items."+column+" "+op+" ?")
+			geometryBounds = append(geometryBounds, "item_geometry."+column+" "+op+" ?")
+			boundArgs = append(boundArgs, val)
+		}
 		if params.MinLatitude != nil {
-			and(func() {
-				or("items.latitude "+gt+" ?", params.MinLatitude)
-			})
+			bound("latitude", gt, params.MinLatitude)
 		}
 		if params.MaxLatitude != nil {
-			and(func() {
-				or("items.latitude "+lt+" ?", params.MaxLatitude)
-			})
+			bound("latitude", lt, params.MaxLatitude)
 		}
 		if params.MinLongitude != nil {
-			and(func() {
-				or("items.longitude "+gt+" ?", params.MinLongitude)
-			})
+			bound("longitude", gt, params.MinLongitude)
 		}
 		if params.MaxLongitude != nil {
-			and(func() {
-				or("items.longitude "+lt+" ?", params.MaxLongitude)
-			})
+			bound("longitude", lt, params.MaxLongitude)
 		}
+		and(func() {
+			q += "(" + strings.Join(itemBounds, " AND ") + ") OR EXISTS (SELECT 1 FROM item_geometry" +
+				" WHERE item_geometry.item_id=items.id AND " + strings.Join(geometryBounds, " AND ") + ")"
+			args = append(args, boundArgs...)
+			args = append(args, boundArgs...)
+		})
 	}
 
 	// skip deleted items unless we are explicitly supposed to include them
@@ -642,9 +784,9 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 		} else if params.OrderBy == "stored" {
 			q += "items.stored " + sortDir
 		} else {
-			// generic sort, which is timestamp and row ID
+			// generic sort, which is timestamp, then order emitted by data source, then row ID
 			// q += fmt.Sprintf(" ORDER BY items.timestamp %s, items.id %s", sortDir, sortDir)
-			q += fmt.Sprintf("items.timestamp %s, items.id %s", sortDir, sortDir)
+			q += fmt.Sprintf("items.timestamp %s, items.timestamp_micros %s, items.sequence %s, items.id %s", sortDir, sortDir, sortDir, sortDir)
 		}
 	}
 
@@ -664,14 +806,16 @@ func (tl *Timeline) prepareSearchQuery(params ItemSearchParams) (string, []any,
 	return q, args, nil
 }
 
-func (tl *Timeline) expandRelationships(ctx context.Context, tx *sql.Tx, degrees int, sr *SearchResult) error {
+// expandRelationships loads the items related to sr, up to the given degrees of
+// separation. If viewer is set, related items the viewer can't see are omitted.
+func (tl *Timeline) expandRelationships(ctx context.Context, tx *sql.Tx, degrees int, viewer *string, sr *SearchResult) error {
 	if degrees <= 0 {
 		return nil
 	}
 
 	// notice how we're careful to avoid recursion while we have open rows
 	// scanning happening, so that we don't step on other select queries
-	err := tl.expandRelationshipSingle(ctx, tx, sr)
+	err := tl.expandRelationshipSingle(ctx, tx, viewer, sr)
 	if err != nil {
 		return err
 	}
@@ -680,13 +824,13 @@ func (tl *Timeline) expandRelationships(ctx context.Context, tx *sql.Tx, degrees
 	// from the original search results are reached
 	for _, rel := range sr.Related {
 		if rel.FromItem != nil {
-			err := tl.expandRelationships(ctx, tx, degrees-1, rel.FromItem)
+			err := tl.expandRelationships(ctx, tx, degrees-1, viewer, rel.FromItem)
 			if err != nil {
 				return err
 			}
 		}
 		if rel.ToItem != nil {
-			err := tl.expandRelationships(ctx, tx, degrees-1, rel.ToItem)
+			err := tl.expandRelationships(ctx, tx, degrees-1, viewer, rel.ToItem)
 			if err != nil {
 				return err
 			}
@@ -696,7 +840,7 @@ func (tl *Timeline) expandRelationships(ctx context.Context, tx *sql.Tx, degrees
 	return nil
 }
 
-func (tl *Timeline) expandRelationshipSingle(ctx context.Context, tx *sql.Tx, sr *SearchResult) error {
+func (tl *Timeline) expandRelationshipSingle(ctx context.Context, tx *sql.Tx, viewer *string, sr *SearchResult) error {
 	// TODO: limit here is arbitrary I think... ho hum
 	rows, err := tx.QueryContext(ctx, `
 		SELECT
@@ -769,19 +913,26 @@ func (tl *Timeline) expandRelationshipSingle(ctx context.Context, tx *sql.Tx, sr
 
 		// expand items, we have to do this until our code can support
 		// loading an item from among other columns as well; expand
-		// only if the item is distinct from the parent/starting item
+		// only if the item is distinct from the parent/starting item;
+		// relationships to items the viewer can't see are left out
 		if fromItemID != nil && *fromItemID != sr.ID {
-			fromRel, err := tl.loadRelatedItem(ctx, tx, *fromItemID)
+			fromRel, err := tl.loadRelatedItem(ctx, tx, viewer, *fromItemID)
 			if err != nil {
 				return fmt.Errorf("loading from_item: %w", err)
 			}
+			if fromRel == nil {
+				continue
+			}
 			rel.FromItem = fromRel
 		}
 		if toItemID != nil && *toItemID != sr.ID {
-			toRel, err := tl.loadRelatedItem(ctx, tx, *toItemID)
+			toRel, err := tl.loadRelatedItem(ctx, tx, viewer, *toItemID)
 			if err != nil {
 				return fmt.Errorf("loading to_item: %w", err)
 			}
+			if toRel == nil {
+				continue
+			}
 			rel.ToItem = toRel
 		}
 
@@ -794,11 +945,22 @@ func (tl *Timeline) expandRelationshipSingle(ctx context.Context, tx *sql.Tx, sr
 	return nil
 }
 
-func (tl *Timeline) loadRelatedItem(ctx context.Context, tx *sql.Tx, itemRowID int64) (*SearchResult, error) {
-	ir, err := tl.loadItemRow(ctx, tx, itemRowID, nil, nil, nil, false)
+// loadRelatedItem loads the item with the given row ID. If viewer is set and
+// the viewer isn't allowed to see the item, it returns nil.
+func (tl *Timeline) loadRelatedItem(ctx context.Context, tx *sql.Tx, viewer *string, itemRowID int64) (*SearchResult, error) {
+	ir, err := tl.loadItemRow(ctx, tx, itemRowID, nil, nil, "", nil, nil, nil, false)
 	if err != nil {
 		return nil, fmt.Errorf("loading related item row: %w", err)
 	}
+	if viewer != nil {
+		visible, err := visibleTo(ctx, tx, ir, *viewer)
+		if err != nil {
+			return nil, err
+		}
+		if !visible {
+			return nil, nil
+		}
+	}
 	// TODO: dunno if this safe (QueryRow during a rows.Scan, by the caller of this function)
 	// TODO: if it would help increase performance, we could probably cache information about persons and person_identities...
 	// TODO: maybe there's a view we could use for this instead?
@@ -828,6 +990,10 @@ type SearchResult struct {
 	Entity  *relatedEntity `json:"entity,omitempty"`
 	Related []Related      `json:"related,omitempty"`
 	Size    int64          `json:"size,omitempty"`
+	History []ItemVersion  `json:"history,omitempty"`
+
+	// How the data file is compressed on disk, if at all (only set when exporting).
+	DataFileCompression DataFileCompression `json:"data_file_compression,omitempty"`
 }
 
 // TODO: Finish making this work
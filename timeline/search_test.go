@@ -0,0 +1,260 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// importTestItems imports the given items, in order, with the test data source.
+func importTestItems(t *testing.T, tl *Timeline, items ...*Item) {
+	t.Helper()
+	importTestItemsWithParams(t, tl, ImportParameters{}, items...)
+}
+
+func TestSearchOrdersSameTimestampBySourceOrder(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	const numItems = 30
+	ts := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	var items []*Item
+	for i := 0; i < numItems; i++ {
+		items = append(items, testMessage(fmt.Sprintf("bulk%02d", i), ts))
+	}
+	importTestItems(t, tl, items...)
+
+	for _, sort := range []SortDir{SortAsc, SortDesc} {
+		results, err := tl.Search(context.Background(), ItemSearchParams{Sort: sort})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		if len(results.Items) != numItems {
+			t.Fatalf("expected %d results, got %d", numItems, len(results.Items))
+		}
+		for i, result := range results.Items {
+			expected := i
+			if sort == SortDesc {
+				expected = numItems - 1 - i
+			}
+			expectedID := fmt.Sprintf("bulk%02d", expected)
+			if result.OriginalID == nil || *result.OriginalID != expectedID {
+				t.Errorf("sort %s: result %d: expected %s, got %v", sort, i, expectedID, result.OriginalID)
+			}
+			if result.Sequence == nil || *result.Sequence != int64(expected+1) {
+				t.Errorf("sort %s: result %d: expected sequence %d, got %v", sort, i, expected+1, result.Sequence)
+			}
+		}
+	}
+}
+
+func TestSearchExcludesItemsHiddenFromViewer(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	public := testMessage("public", ts)
+	private := testMessage("private", ts.Add(time.Minute))
+	private.Visibility = &Visibility{Level: VisibilityPrivate}
+	shared := testMessage("shared", ts.Add(2*time.Minute))
+	shared.Visibility = &Visibility{Level: VisibilityShared, SharedWith: []string{"alice"}}
+	importTestItems(t, tl, public, private, shared)
+
+	// items without their own visibility get the import's default
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("default_private", ts.Add(3*time.Minute))}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{DefaultVisibility: &Visibility{Level: VisibilityPrivate}},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	visibleTo := func(params ItemSearchParams) []string {
+		t.Helper()
+		params.Sort = SortAsc
+		results, err := tl.Search(context.Background(), params)
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		var ids []string
+		for _, sr := range results.Items {
+			ids = append(ids, *sr.OriginalID)
+		}
+		return ids
+	}
+	alice, bob := "alice", "bob"
+
+	for _, tc := range []struct {
+		name   string
+		params ItemSearchParams
+		expect []string
+	}{
+		{"owner", ItemSearchParams{}, []string{"public", "private", "shared", "default_private"}},
+		{"shared viewer", ItemSearchParams{Viewer: &alice}, []string{"public", "shared"}},
+		{"unauthorized viewer", ItemSearchParams{Viewer: &bob}, []string{"public"}},
+		{"unauthorized viewer with or_fields", ItemSearchParams{Viewer: &bob, OrFields: true, DataSourceName: []string{testDataSourceName}}, []string{"public"}},
+	} {
+		if got := visibleTo(tc.params); !slices.Equal(got, tc.expect) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, got)
+		}
+	}
+
+	err = tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{DefaultVisibility: &Visibility{Level: "secret"}},
+	})
+	if err == nil {
+		t.Error("expected error for invalid default visibility")
+	}
+}
+
+func TestSearchByMetadata(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	withMeta := func(id string, meta Metadata) *Item {
+		it := testMessage(id, ts)
+		it.Metadata = meta
+		return it
+	}
+	importTestItems(t, tl,
+		withMeta("phone", Metadata{"Device": "Pixel 7", "Reactions": 3, "Edited": true}),
+		withMeta("laptop", Metadata{"Device": "ThinkPad", "Reactions": 12}),
+		withMeta("plain", nil),
+	)
+
+	search := func(meta map[string][]any) []string {
+		t.Helper()
+		results, err := tl.Search(context.Background(), ItemSearchParams{Metadata: meta, Sort: SortAsc})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		var ids []string
+		for _, sr := range results.Items {
+			ids = append(ids, *sr.OriginalID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	for _, tc := range []struct {
+		name   string
+		meta   map[string][]any
+		expect []string
+	}{
+		{"string value", map[string][]any{"Device": {"Pixel 7"}}, []string{"phone"}},
+		{"any of values", map[string][]any{"Device": {"Pixel 7", "ThinkPad"}}, []string{"laptop", "phone"}},
+		{"typed number", map[string][]any{"Reactions": {12.0}}, []string{"laptop"}},
+		{"boolean", map[string][]any{"Edited": {true}}, []string{"phone"}},
+		{"has key", map[string][]any{"Reactions": nil}, []string{"laptop", "phone"}},
+		{"lacks key", map[string][]any{"Edited": {nil}}, []string{"laptop", "plain"}},
+		{"all keys must match", map[string][]any{"Device": {"ThinkPad"}, "Edited": {true}}, nil},
+		{"no match", map[string][]any{"Device": {"Walkman"}}, nil},
+	} {
+		if got := search(tc.meta); !slices.Equal(got, tc.expect) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, got)
+		}
+	}
+
+	// metadata of deleted items doesn't match
+	results, err := tl.Search(context.Background(), ItemSearchParams{Metadata: map[string][]any{"Device": {"Pixel 7"}}})
+	if err != nil || len(results.Items) != 1 {
+		t.Fatalf("expected 1 result, got %d (err=%v)", len(results.Items), err)
+	}
+	if err := tl.DeleteItems(context.Background(), []int64{results.Items[0].ID}, DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(map[string][]any{"Device": {"Pixel 7"}}); len(got) != 0 {
+		t.Errorf("expected deleted item not to match, got %v", got)
+	}
+
+	if _, err := tl.Search(context.Background(), ItemSearchParams{Metadata: map[string][]any{`bad"key`: nil}}); err == nil {
+		t.Error("expected error for invalid metadata key")
+	}
+}
+
+func TestSearchExcludesRelatedItemsHiddenFromViewer(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	private := testMessage("private", ts.Add(time.Minute))
+	private.Visibility = &Visibility{Level: VisibilityPrivate}
+	shared := testMessage("shared", ts.Add(2*time.Minute))
+	shared.Visibility = &Visibility{Level: VisibilityShared, SharedWith: []string{"alice"}}
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("public", ts), Edges: []Relationship{
+			{Relation: RelReply, To: &Graph{Item: private}},
+			{Relation: RelReply, To: &Graph{Item: shared}},
+		}}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	relatedTo := func(viewer *string) []string {
+		t.Helper()
+		results, err := tl.Search(context.Background(), ItemSearchParams{
+			Viewer:     viewer,
+			OriginalID: []string{"public"},
+			Related:    1,
+		})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		if len(results.Items) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results.Items))
+		}
+		var ids []string
+		for _, rel := range results.Items[0].Related {
+			if rel.ToItem != nil {
+				ids = append(ids, *rel.ToItem.OriginalID)
+			}
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	alice, bob := "alice", "bob"
+
+	for _, tc := range []struct {
+		name   string
+		viewer *string
+		expect []string
+	}{
+		{"owner", nil, []string{"private", "shared"}},
+		{"shared viewer", &alice, []string{"shared"}},
+		{"unauthorized viewer", &bob, nil},
+	} {
+		if got := relatedTo(tc.viewer); !slices.Equal(got, tc.expect) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrEnumerateUnsupported is returned when a data source is not able
+// to list the IDs of the items at the source.
+var ErrEnumerateUnsupported = errors.New("data source does not support enumerating item IDs")
+
+// SourceComparison is the result of comparing the items in the timeline
+// from a data source with the items currently at the source.
+type SourceComparison struct {
+	SourceCount int `json:"source_count"` // number of items at the source
+	LocalCount  int `json:"local_count"`  // number of items in the timeline with an original ID
+
+	// Items in the timeline that are no longer at the source;
+	// these are candidates for pruning. Ordered by item ID.
+	DeletedAtSource []SourceComparisonItem `json:"deleted_at_source,omitempty"`
+
+	// Original IDs of items at the source that are not in the
+	// timeline; these are candidates for backfilling. Sorted.
+	MissingLocally []string `json:"missing_locally,omitempty"`
+}
+
+// SourceComparisonItem is an item in the timeline that is
+// referred to by a source comparison.
+type SourceComparisonItem struct {
+	ItemID     int64  `json:"item_id"`
+	OriginalID string `json:"original_id"`
+}
+
+// CompareToSource audits the timeline against a data source: it asks the data
+// source for the original IDs of all items currently at the source, and compares
+// them with the original IDs of the items stored from that data source, to find
+// items that were deleted at the source and items that are missing locally. If
+// params has an account, only items imported with that account are compared.
+// Nothing is modified. Items that were deleted from the timeline are not reported
+// as missing. If the data source can't enumerate its items, an error wrapping
+// ErrEnumerateUnsupported is returned.
+func (tl *Timeline) CompareToSource(ctx context.Context, params ImportParameters) (SourceComparison, error) {
+	ds, ok := lookupDataSource(params.DataSourceName)
+	if !ok {
+		return SourceComparison{}, fmt.Errorf("unknown data source: %s", params.DataSourceName)
+	}
+	if ds.EnumerateIDs == nil {
+		return SourceComparison{}, fmt.Errorf("%s: %w", ds.Name, ErrEnumerateUnsupported)
+	}
+
+	if err := ds.validateOptions(params.DataSourceOptions); err != nil {
+		return SourceComparison{}, err
+	}
+	dsOpt, err := ds.UnmarshalOptions(params.DataSourceOptions)
+	if err != nil {
+		return SourceComparison{}, err
+	}
+
+	var acc *Account
+	if params.AccountID > 0 {
+		loaded, err := tl.LoadAccount(ctx, params.AccountID)
+		if err != nil {
+			return SourceComparison{}, err
+		}
+		acc = &loaded
+	}
+
+	sourceIDs, err := ds.EnumerateIDs(ctx, params.Filenames, acc, dsOpt)
+	if err != nil {
+		return SourceComparison{}, fmt.Errorf("%s: enumerating item IDs: %w", ds.Name, err)
+	}
+	atSource := make(map[string]bool, len(sourceIDs))
+	for _, id := range sourceIDs {
+		atSource[id] = false // becomes true once seen locally
+	}
+
+	result := SourceComparison{SourceCount: len(atSource)}
+
+	if err := tl.compareLocalItemsToSource(ctx, ds.Name, params.AccountID, atSource, &result); err != nil {
+		return SourceComparison{}, err
+	}
+
+	for id, seen := range atSource {
+		if !seen {
+			result.MissingLocally = append(result.MissingLocally, id)
+		}
+	}
+	slices.Sort(result.MissingLocally)
+
+	return result, nil
+}
+
+// compareLocalItemsToSource marks the IDs in atSource that are in the timeline, and
+// adds the items that aren't at the source to the result.
+func (tl *Timeline) compareLocalItemsToSource(ctx context.Context, dsName string, accountID int64, atSource map[string]bool, result *SourceComparison) error {
+	q := `SELECT items.id, items.original_id, items.deleted IS NOT NULL
+		FROM items
+		JOIN data_sources ON data_sources.id = items.data_source_id
+		WHERE data_sources.name=? AND items.original_id IS NOT NULL`
+	args := []any{dsName}
+	if accountID > 0 {
+		q += ` AND (items.account_id=? OR items.import_id IN (SELECT id FROM imports WHERE account_id=?))`
+		args = append(args, accountID, accountID)
+	}
+	q += ` ORDER BY items.id`
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return fmt.Errorf("querying items from data source: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowID int64
+		var originalID string
+		var deleted bool
+		if err := rows.Scan(&rowID, &originalID, &deleted); err != nil {
+			return fmt.Errorf("scanning item: %v", err)
+		}
+		if _, ok := atSource[originalID]; ok {
+			atSource[originalID] = true
+		} else if !deleted {
+			result.DeletedAtSource = append(result.DeletedAtSource, SourceComparisonItem{
+				ItemID:     rowID,
+				OriginalID: originalID,
+			})
+		}
+		if !deleted {
+			result.LocalCount++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return nil
+}
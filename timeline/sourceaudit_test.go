@@ -0,0 +1,101 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCompareToSource(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	name := fmt.Sprintf("enumerating_source_%d", time.Now().UnixNano())
+	err := RegisterDataSource(DataSource{
+		Name:            name,
+		Title:           "Enumerating test",
+		NewFileImporter: func() FileImporter { return testImporter{} },
+		EnumerateIDs: func(_ context.Context, _ []string, _ *Account, _ any) ([]string, error) {
+			return []string{"kept1", "kept2", "new"}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		for i, id := range []string{"kept1", "kept2", "gone"} {
+			itemChan <- &Graph{Item: testMessage(id, ts.Add(time.Duration(i)*time.Minute))}
+		}
+		return nil
+	}
+	err = tl.Import(context.Background(), ImportParameters{
+		DataSourceName: name,
+		Filenames:      []string{"test"},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	var goneRowID int64
+	if err := tl.db.QueryRow(`SELECT id FROM items WHERE original_id='gone'`).Scan(&goneRowID); err != nil {
+		t.Fatal(err)
+	}
+
+	var before int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&before); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := tl.CompareToSource(context.Background(), ImportParameters{
+		DataSourceName: name,
+		Filenames:      []string{"test"},
+	})
+	if err != nil {
+		t.Fatalf("comparing to source: %v", err)
+	}
+	if result.SourceCount != 3 || result.LocalCount != 3 {
+		t.Errorf("expected 3 items at source and 3 locally, got %d and %d", result.SourceCount, result.LocalCount)
+	}
+	if len(result.DeletedAtSource) != 1 || result.DeletedAtSource[0] != (SourceComparisonItem{ItemID: goneRowID, OriginalID: "gone"}) {
+		t.Errorf("expected only item 'gone' (row %d) to be deleted at source, got %+v", goneRowID, result.DeletedAtSource)
+	}
+	if !slices.Equal(result.MissingLocally, []string{"new"}) {
+		t.Errorf("expected only item 'new' to be missing locally, got %v", result.MissingLocally)
+	}
+
+	// the audit is read-only
+	var after int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&after); err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Errorf("expected %d items after comparing, got %d", before, after)
+	}
+
+	// data sources that can't enumerate their items report that it's unsupported
+	_, err = tl.CompareToSource(context.Background(), ImportParameters{DataSourceName: testDataSourceName})
+	if !errors.Is(err, ErrEnumerateUnsupported) {
+		t.Errorf("expected ErrEnumerateUnsupported, got %v", err)
+	}
+}
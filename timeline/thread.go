@@ -0,0 +1,136 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// ThreadNode is an item in a thread of replies, along with its replies.
+type ThreadNode struct {
+	Item    ItemRow       `json:"item"`
+	Replies []*ThreadNode `json:"replies,omitempty"`
+}
+
+// Thread returns the thread of replies to the given item: the tree of items that
+// are replies to it (see RelReply), and replies to those, and so on. Replies to
+// an item are ordered by timestamp, then sequence. Deleted items and their
+// replies are left out. If the thread's root was only referenced by replies and
+// never imported, it is a placeholder without content.
+func (tl *Timeline) Thread(ctx context.Context, rootItemID int64) (*ThreadNode, error) {
+	roots, err := tl.itemsWhere(ctx, "id=?", rootItemID)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("item %d: %w", rootItemID, ErrItemNotFound)
+	}
+
+	root := &ThreadNode{Item: roots[0]}
+	nodes := map[int64]*ThreadNode{rootItemID: root}
+	frontier := []int64{rootItemID}
+
+	// walk down the thread one level at a time; replies
+	// that were already visited are skipped, in case of cycles
+	for len(frontier) > 0 {
+		replies, err := tl.threadReplies(ctx, frontier)
+		if err != nil {
+			return nil, err
+		}
+		frontier = frontier[:0]
+		for _, reply := range replies {
+			if _, ok := nodes[reply.item.ID]; ok {
+				continue
+			}
+			node := &ThreadNode{Item: reply.item}
+			nodes[reply.item.ID] = node
+			parent := nodes[reply.parentID]
+			parent.Replies = append(parent.Replies, node)
+			frontier = append(frontier, reply.item.ID)
+		}
+	}
+
+	sortThread(root)
+
+	return root, nil
+}
+
+type threadReply struct {
+	parentID int64
+	item     ItemRow
+}
+
+// threadReplies returns the items that are replies to any of the given items.
+func (tl *Timeline) threadReplies(ctx context.Context, parentIDs []int64) ([]threadReply, error) {
+	array, args := sqlArray(parentIDs)
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, `SELECT `+itemDBColumns+`, relationships.to_item_id
+		FROM relationships
+		JOIN relations ON relations.id = relationships.relation_id
+		JOIN extended_items AS items ON items.id = relationships.from_item_id
+		WHERE relations.label=?
+			AND relationships.to_item_id IN `+array+`
+			AND items.deleted IS NULL
+		ORDER BY items.id`, append([]any{RelReply.Label}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying replies: %v", err)
+	}
+	defer rows.Close()
+
+	var replies []threadReply
+	for rows.Next() {
+		var reply threadReply
+		reply.item, err = scanItemRow(rows, []any{&reply.parentID})
+		if err != nil {
+			return nil, fmt.Errorf("scanning reply: %v", err)
+		}
+		replies = append(replies, reply)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating replies: %v", err)
+	}
+
+	return replies, nil
+}
+
+// sortThread orders the replies throughout the thread.
+func sortThread(node *ThreadNode) {
+	sort.SliceStable(node.Replies, func(i, j int) bool {
+		a, b := node.Replies[i].Item, node.Replies[j].Item
+		if ta, tb := a.timestampUnix(), b.timestampUnix(); ta != nil && tb != nil && *ta != *tb {
+			return *ta < *tb
+		}
+		var sa, sb int64
+		if a.Sequence != nil {
+			sa = *a.Sequence
+		}
+		if b.Sequence != nil {
+			sb = *b.Sequence
+		}
+		return sa < sb
+	})
+	for _, reply := range node.Replies {
+		sortThread(reply)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThread(t *testing.T) {
+	ts := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	reply := func(id, parentID string, minutes int) *Graph {
+		g := &Graph{Item: testMessage(id, ts.Add(time.Duration(minutes)*time.Minute))}
+		if parentID != "" {
+			g.ReplyTo(parentID)
+		}
+		return g
+	}
+	importGraphs := func(t *testing.T, tl *Timeline, graphs ...*Graph) {
+		t.Helper()
+		testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, g := range graphs {
+				itemChan <- g
+			}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	// renders the thread like "root(a(a1) b)"
+	var render func(n *ThreadNode) string
+	render = func(n *ThreadNode) string {
+		var sb strings.Builder
+		if n.Item.OriginalID != nil {
+			sb.WriteString(*n.Item.OriginalID)
+		}
+		if len(n.Replies) > 0 {
+			sb.WriteRune('(')
+			for i, r := range n.Replies {
+				if i > 0 {
+					sb.WriteRune(' ')
+				}
+				sb.WriteString(render(r))
+			}
+			sb.WriteRune(')')
+		}
+		return sb.String()
+	}
+
+	const expected = "root(a(a1 a2) b(b1))"
+
+	checkThread := func(t *testing.T, tl *Timeline) {
+		t.Helper()
+		ctx := context.Background()
+		root, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.DataText == nil {
+			t.Error("expected root placeholder to be filled in with content")
+		}
+		thread, err := tl.Thread(ctx, root.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := render(thread); got != expected {
+			t.Errorf("expected thread %s, got %s", expected, got)
+		}
+		var count int
+		if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 6 {
+			t.Errorf("expected 6 items (placeholders filled in, not duplicated), got %d", count)
+		}
+	}
+
+	t.Run("out of order within import", func(t *testing.T) {
+		tl := newTestTimeline(t)
+		importGraphs(t, tl,
+			reply("b1", "b", 5),
+			reply("a2", "a", 4),
+			reply("a1", "a", 3),
+			reply("b", "root", 2),
+			reply("a", "root", 1),
+			reply("root", "", 0),
+		)
+		checkThread(t, tl)
+	})
+
+	t.Run("parents in later import", func(t *testing.T) {
+		tl := newTestTimeline(t)
+		importGraphs(t, tl,
+			reply("a2", "a", 4),
+			reply("b1", "b", 5),
+			reply("a1", "a", 3),
+		)
+
+		// the parents that haven't arrived yet are placeholders, so the replies are kept connected
+		pending, err := tl.ItemsPendingCompletion(context.Background(), testDataSourceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 2 {
+			t.Errorf("expected 2 placeholder parents, got %d", len(pending))
+		}
+
+		importGraphs(t, tl,
+			reply("b", "root", 2),
+			reply("root", "", 0),
+			reply("a", "root", 1),
+		)
+		checkThread(t, tl)
+	})
+
+	t.Run("item not found", func(t *testing.T) {
+		tl := newTestTimeline(t)
+		if _, err := tl.Thread(context.Background(), 42); !errors.Is(err, ErrItemNotFound) {
+			t.Errorf("expected item not found error, got %v", err)
+		}
+	})
+}
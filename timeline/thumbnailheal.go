@@ -0,0 +1,200 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxThumbnailHeals is how many items can be waiting for a missing
+	// thumbnail to be generated at once; items found to be missing one
+	// beyond that are left for a later read to find.
+	maxThumbnailHeals = 100
+
+	// thumbnailHealTimeout is how long an item waits for its missing thumbnail
+	// before it can be queued again, in case the request was dropped.
+	thumbnailHealTimeout = 10 * time.Minute
+)
+
+// ItemsWithoutThumbnails returns up to limit items that should have a thumbnail,
+// but whose thumbnail has not been generated, such as after an interrupted import
+// or after the thumbnail cache was cleared. Deleted items are not included. If limit
+// is not positive, all such items are returned. This calls stat() on the thumbnail
+// of every item that qualifies for one, so it can be slow on large timelines.
+func (tl *Timeline) ItemsWithoutThumbnails(ctx context.Context, limit int) ([]ItemRow, error) {
+	// (the media type is filtered roughly here to avoid scanning every item;
+	// qualifiesForThumbnail is the final say)
+	q := `SELECT ` + itemDBColumns + `
+		FROM extended_items AS items
+		WHERE items.data_file IS NOT NULL
+			AND items.deleted IS NULL
+			AND (items.data_type LIKE 'image/%' OR items.data_type LIKE 'video/%' OR items.data_type='application/pdf')
+		ORDER BY items.id`
+
+	tl.dbMu.RLock()
+	defer tl.dbMu.RUnlock()
+
+	rows, err := tl.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("querying items that qualify for thumbnails: %v", err)
+	}
+	defer rows.Close()
+
+	var results []ItemRow
+	for rows.Next() {
+		ir, err := scanItemRow(rows, nil)
+		if err != nil {
+			return nil, fmt.Errorf("scanning item: %v", err)
+		}
+		if tl.thumbnailMissing(ir) {
+			results = append(results, ir)
+			if limit > 0 && len(results) >= limit {
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	return results, nil
+}
+
+// thumbnailMissing returns true if the item qualifies for a thumbnail that
+// doesn't exist.
+func (tl *Timeline) thumbnailMissing(ir ItemRow) bool {
+	if ir.DataFile == nil || !qualifiesForThumbnail(ir.DataType) {
+		return false
+	}
+	_, err := os.Stat(tl.ThumbnailPath(ir.ID, thumbnailFormat(*ir.DataType)))
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// thumbnailFormat returns the kind of thumbnail made for files of the media type.
+func thumbnailFormat(dataType string) ThumbnailType {
+	if strings.HasPrefix(dataType, "video/") {
+		return VideoThumbnail
+	}
+	return ImageThumbnail
+}
+
+// healThumbnails queues thumbnail generation in the background for any of the
+// items which are missing their thumbnail, by publishing an event (see
+// TopicThumbnailNeeded). Items already waiting for a thumbnail are skipped.
+// It does not block.
+func (tl *Timeline) healThumbnails(items []ItemRow) {
+	now := time.Now()
+
+	tl.thumbnailHealsMu.Lock()
+	if tl.thumbnailHeals == nil {
+		tl.thumbnailHeals = make(map[int64]time.Time)
+	}
+	for itemID, queued := range tl.thumbnailHeals {
+		if now.Sub(queued) > thumbnailHealTimeout {
+			delete(tl.thumbnailHeals, itemID)
+		}
+	}
+	var itemIDs []int64
+	for _, ir := range items {
+		if len(tl.thumbnailHeals) >= maxThumbnailHeals {
+			break
+		}
+		if _, ok := tl.thumbnailHeals[ir.ID]; ok || !tl.thumbnailMissing(ir) {
+			continue
+		}
+		tl.thumbnailHeals[ir.ID] = now
+		itemIDs = append(itemIDs, ir.ID)
+	}
+	tl.thumbnailHealsMu.Unlock()
+
+	if len(itemIDs) > 0 {
+		tl.publish(Event{Topic: TopicThumbnailNeeded, ItemIDs: itemIDs})
+	}
+}
+
+// generateMissingThumbnails handles TopicThumbnailNeeded events by generating
+// the thumbnails in the background.
+func (tl *Timeline) generateMissingThumbnails(ctx context.Context, ev Event) error {
+	results, err := tl.Search(ctx, ItemSearchParams{
+		Repo:        tl.id.String(),
+		RowID:       ev.ItemIDs,
+		Limit:       -1,
+		Astructured: true,
+	})
+	if err != nil {
+		tl.doneHealingThumbnails(ev.ItemIDs...)
+		return fmt.Errorf("loading items that need thumbnails: %v", err)
+	}
+
+	// thumbnail tasks are picked up one at a time by the workers, so
+	// don't hold up other events while waiting for them
+	go func() {
+		defer tl.doneHealingThumbnails(ev.ItemIDs...)
+
+		errChan := make(chan error)
+		for _, sr := range results.Items {
+			if !tl.thumbnailMissing(sr.ItemRow) {
+				continue
+			}
+			dataFile, dataType := tl.thumbnailSource(ctx, *sr.DataFile, *sr.DataType)
+			tl.GenerateThumbnail(ctx, sr.ID, dataFile, dataType, thumbnailFormat(dataType), errChan)
+			if err := <-errChan; err != nil {
+				Log.Error("generating missing thumbnail",
+					zap.Int64("item_id", sr.ID),
+					zap.String("data_file", dataFile),
+					zap.Error(err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// thumbnailSource returns the file to make the thumbnail of a data file from, and
+// its media type: a derived form of the data file (see DataFilePostProcessor) that
+// qualifies for a thumbnail if there is one, otherwise the data file itself.
+func (tl *Timeline) thumbnailSource(ctx context.Context, dataFile, dataType string) (string, string) {
+	derivedFiles, err := tl.DerivedDataFiles(ctx, dataFile)
+	if err != nil {
+		Log.Warn("looking up derived files for thumbnail", zap.String("data_file", dataFile), zap.Error(err))
+	}
+	for _, derived := range derivedFiles {
+		if qualifiesForThumbnail(&derived.MediaType) {
+			return derived.Filename, derived.MediaType
+		}
+	}
+	return dataFile, dataType
+}
+
+func (tl *Timeline) doneHealingThumbnails(itemIDs ...int64) {
+	tl.thumbnailHealsMu.Lock()
+	for _, itemID := range itemIDs {
+		delete(tl.thumbnailHeals, itemID)
+	}
+	tl.thumbnailHealsMu.Unlock()
+}
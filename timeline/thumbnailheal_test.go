@@ -0,0 +1,110 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHealMissingThumbnailsOnRead(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	photo := testFileItem("photo", ts)
+	photo.Content.MediaType = "image/jpeg"
+	importTestItems(t, tl, photo, testMessage("message", ts.Add(time.Hour)))
+
+	// without a thumbnail generator in this environment, the photo's thumbnail
+	// was never made, just as if the import had been interrupted
+	missing, err := tl.ItemsWithoutThumbnails(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || *missing[0].OriginalID != "photo" {
+		t.Fatalf("expected only the photo to be missing a thumbnail, got %d items", len(missing))
+	}
+	photoID := missing[0].ID
+
+	needed := make(chan []int64, 10)
+	tl.Subscribe(TopicThumbnailNeeded, func(_ context.Context, ev Event) error {
+		needed <- ev.ItemIDs
+		return nil
+	})
+	expectNeeded := func(how string) {
+		t.Helper()
+		select {
+		case itemIDs := <-needed:
+			if !slices.Equal(itemIDs, []int64{photoID}) {
+				t.Errorf("%s: expected thumbnail to be needed for item %d, got %v", how, photoID, itemIDs)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: reading item with missing thumbnail did not queue generation", how)
+		}
+	}
+
+	// reading without the option doesn't queue anything
+	if _, err := tl.RecentActivity(context.Background(), 10, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.RecentActivity(context.Background(), 10, true); err != nil {
+		t.Fatal(err)
+	}
+	expectNeeded("recent activity")
+
+	// wait for the failed attempt to finish so that the item can be queued again
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tl.thumbnailHealsMu.Lock()
+		_, pending := tl.thumbnailHeals[photoID]
+		tl.thumbnailHealsMu.Unlock()
+		if !pending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("thumbnail generation never finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := tl.Search(context.Background(), ItemSearchParams{HealThumbnails: true}); err != nil {
+		t.Fatal(err)
+	}
+	expectNeeded("search")
+
+	// once the thumbnail exists, the item is no longer missing one
+	thumbPath := tl.ThumbnailPath(photoID, ImageThumbnail)
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(thumbPath, []byte("thumbnail"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing, err = tl.ItemsWithoutThumbnails(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected no items missing thumbnails, got %d", len(missing))
+	}
+}
@@ -0,0 +1,86 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// SkipReasonThumbnail is the skip reason recorded for items whose thumbnails
+// were not generated because of the import's thumbnail options (see
+// ProcessingOptions.ThumbnailMinBytes and ProcessingOptions.ThumbnailTypes).
+// The items themselves are still imported.
+const SkipReasonThumbnail = "thumbnail_skipped"
+
+// validateThumbnailOptions returns an error if the thumbnail options are invalid.
+func (po ProcessingOptions) validateThumbnailOptions() error {
+	if po.ThumbnailMinBytes < 0 {
+		return fmt.Errorf("thumbnail minimum size cannot be negative: %d", po.ThumbnailMinBytes)
+	}
+	for _, t := range po.ThumbnailTypes {
+		if before, after, ok := strings.Cut(t, "/"); !ok || before == "" || after == "" {
+			return fmt.Errorf("invalid thumbnail media type (expected type/subtype or type/*): %s", t)
+		}
+	}
+	return nil
+}
+
+// thumbnailTypeAllowed returns true if the media type is in the allowlist
+// of types to generate thumbnails for, or if there is no allowlist.
+func (po ProcessingOptions) thumbnailTypeAllowed(mediaType string) bool {
+	if len(po.ThumbnailTypes) == 0 {
+		return true
+	}
+	for _, t := range po.ThumbnailTypes {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsThumbnail returns true if the import's thumbnail options allow
+// generating a thumbnail for the data file, which has the given media type.
+func (p *processor) wantsThumbnail(dataFile, mediaType string) bool {
+	opts := p.params.ProcessingOptions
+	if !opts.thumbnailTypeAllowed(mediaType) {
+		return false
+	}
+	if opts.ThumbnailMinBytes > 0 {
+		info, err := os.Stat(p.tl.FullPath(dataFile))
+		if err != nil {
+			p.log.Error("checking size of data file for thumbnail",
+				zap.String("data_file", dataFile),
+				zap.Error(err))
+			return false
+		}
+		if info.Size() < opts.ThumbnailMinBytes {
+			return false
+		}
+	}
+	return true
+}
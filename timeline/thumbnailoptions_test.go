@@ -0,0 +1,111 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThumbnailOptionsSkipSmallAndUnwantedFiles(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	ts := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	item := func(id, mediaType string, size int) *Item {
+		return &Item{
+			ID:             id,
+			Classification: ClassMedia,
+			Timestamp:      ts,
+			Content: ItemData{
+				Filename:  id,
+				MediaType: mediaType,
+				Data:      ByteData(bytes.Repeat([]byte(id), size/len(id))),
+			},
+		}
+	}
+	testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: item("icon.png", "image/png", 100)}
+		itemChan <- &Graph{Item: item("notes.txt", "text/plain", 64*1024)}
+		itemChan <- &Graph{Item: item("photo.jpg", "image/jpeg", 16*1024)}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		ProcessingOptions: ProcessingOptions{
+			InlineThresholdBytes: 1024, // so the text goes in a data file too
+			ThumbnailMinBytes:    1024,
+			ThumbnailTypes:       []string{"image/*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	var files int
+	if err := tl.db.QueryRow(`SELECT count() FROM items WHERE data_file IS NOT NULL`).Scan(&files); err != nil {
+		t.Fatal(err)
+	}
+	if files != 3 {
+		t.Fatalf("expected 3 items with data files, got %d", files)
+	}
+
+	// thumbnails are generated in the background after the import
+	var skipped int
+	deadline := time.Now().Add(5 * time.Second)
+	for skipped < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		tl.dbMu.RLock()
+		_ = tl.db.QueryRow(`SELECT count FROM import_skip_reasons WHERE reason=?`, SkipReasonThumbnail).Scan(&skipped)
+		tl.dbMu.RUnlock()
+	}
+	if skipped != 2 {
+		t.Errorf("expected thumbnails of the tiny image and the text file to be skipped, but %d were", skipped)
+	}
+}
+
+func TestThumbnailTypeAllowed(t *testing.T) {
+	opts := ProcessingOptions{ThumbnailTypes: []string{"image/*", "application/pdf"}}
+	for mediaType, expect := range map[string]bool{
+		"image/jpeg":      true,
+		"application/pdf": true,
+		"video/mp4":       false,
+		"imagex/foo":      false,
+		"":                false,
+	} {
+		if actual := opts.thumbnailTypeAllowed(mediaType); actual != expect {
+			t.Errorf("%q: expected %t, got %t", mediaType, expect, actual)
+		}
+	}
+	if !(ProcessingOptions{}).thumbnailTypeAllowed("video/mp4") {
+		t.Error("expected all types to be allowed without an allowlist")
+	}
+
+	for _, bad := range []ProcessingOptions{
+		{ThumbnailMinBytes: -1},
+		{ThumbnailTypes: []string{"image"}},
+		{ThumbnailTypes: []string{"/png"}},
+	} {
+		if err := bad.validateThumbnailOptions(); err == nil {
+			t.Errorf("expected error for invalid options: %+v", bad)
+		}
+	}
+}
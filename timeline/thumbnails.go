@@ -21,8 +21,10 @@ package timeline
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
+	"io/fs"
 	"math"
 	"os"
 	"os/exec"
@@ -355,6 +357,41 @@ func (tl *Timeline) generateThumbnail(ctx context.Context, itemID int64, dataFil
 	}
 }
 
+// regenerateThumbnail replaces the thumbnail of the item, if its data file qualifies
+// for one, and waits for it to be generated. It returns the new thumbhash, if the
+// thumbnail is an image.
+func (tl *Timeline) regenerateThumbnail(ctx context.Context, itemID int64, dataFile, dataType string) ([]byte, error) {
+	if !qualifiesForThumbnail(&dataType) {
+		return nil, nil
+	}
+
+	format := ImageThumbnail
+	if strings.HasPrefix(dataType, "video/") {
+		format = VideoThumbnail
+	}
+
+	// the old thumbnail has to go, since it won't be overwritten (by ffmpeg, at least)
+	thumbnailPath := tl.ThumbnailPath(itemID, format)
+	if err := os.Remove(thumbnailPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("removing old thumbnail: %v", err)
+	}
+
+	errChan := make(chan error)
+	tl.GenerateThumbnail(ctx, itemID, dataFile, dataType, format, errChan)
+	if err := <-errChan; err != nil {
+		return nil, fmt.Errorf("generating thumbnail: %w", err)
+	}
+
+	if format != ImageThumbnail {
+		return nil, nil
+	}
+	thumbHash, err := thumbhashFromThumbnail(thumbnailPath)
+	if err != nil {
+		return nil, fmt.Errorf("computing thumbhash: %w", err)
+	}
+	return thumbHash, nil
+}
+
 // GeneratePreviewImage generates a higher quality preview image for the given item. The
 // extension should be for a supported image format such as JPEG, PNG, WEBP, or AVIF.
 // (JPEG or WEBP recommended.) As preview images are not cached, the image bytes are
@@ -429,6 +466,7 @@ func (tl *Timeline) regenerateAllThumbnails() error {
 	tl.dbMu.RLock()
 	rows, err := tl.db.QueryContext(tl.ctx, `SELECT id, data_type, data_file FROM items WHERE data_file IS NOT NULL`)
 	if err != nil {
+		tl.dbMu.RUnlock()
 		return fmt.Errorf("querying items: %v", err)
 	}
 
@@ -475,8 +513,12 @@ func (tl *Timeline) regenerateAllThumbnails() error {
 // run after the import completes.
 func (p *processor) generateThumbnailsForImportedItems() {
 	p.tl.dbMu.RLock()
-	rows, err := p.tl.db.QueryContext(p.tl.ctx, `SELECT id, data_type, data_file FROM items WHERE import_id=? AND data_file IS NOT NULL`, p.impRow.id)
+	rows, err := p.tl.db.QueryContext(p.tl.ctx, `SELECT items.id, items.data_type, items.data_file, derived.derived_file, derived.media_type
+		FROM items
+		LEFT JOIN derived_data_files AS derived ON derived.data_file = items.data_file
+		WHERE items.import_id=? AND items.data_file IS NOT NULL`, p.impRow.id)
 	if err != nil {
+		p.tl.dbMu.RUnlock()
 		p.log.Error("unable to generate thumbnails from this import",
 			zap.Int64("import_id", p.impRow.id),
 			zap.Error(err))
@@ -484,16 +526,18 @@ func (p *processor) generateThumbnailsForImportedItems() {
 	}
 
 	type thumbInfo struct {
-		rowID    int64
-		dataType string
+		rowID       int64
+		dataType    string
+		derivedFile string // if set, the thumbnail is made from this file, which has type dataType
 	}
 	thumbnailsNeeded := make(map[string]thumbInfo) // map key is item ID; useful for deduplicating if shared by other items
+	thumbnailsSkipped := make(map[int64]struct{})  // (an item has a row for each of its derived files)
 
 	for rows.Next() {
 		var rowID int64
-		var dataType, dataFile *string
+		var dataType, dataFile, derivedFile, derivedType *string
 
-		err := rows.Scan(&rowID, &dataType, &dataFile)
+		err := rows.Scan(&rowID, &dataType, &dataFile, &derivedFile, &derivedType)
 		if err != nil {
 			rows.Close()
 			p.tl.dbMu.RUnlock()
@@ -502,8 +546,31 @@ func (p *processor) generateThumbnailsForImportedItems() {
 				zap.Error(err))
 			return
 		}
-		if dataFile != nil && dataType != nil && qualifiesForThumbnail(dataType) {
-			thumbnailsNeeded[*dataFile] = thumbInfo{rowID, *dataType}
+		if dataFile == nil {
+			continue
+		}
+		// a converted form of the file is preferred, since converting
+		// it is usually what makes it displayable in the first place
+		if derivedFile != nil && qualifiesForThumbnail(derivedType) {
+			thumbnailsNeeded[*dataFile] = thumbInfo{rowID, *derivedType, *derivedFile}
+			delete(thumbnailsSkipped, rowID)
+			continue
+		}
+		if _, ok := thumbnailsNeeded[*dataFile]; ok {
+			continue
+		}
+		if dataType != nil && qualifiesForThumbnail(dataType) {
+			thumbnailsNeeded[*dataFile] = thumbInfo{rowID: rowID, dataType: *dataType}
+			continue
+		}
+		// (files that can't have thumbnails anyway are only counted
+		// as skipped if the user restricted the types explicitly)
+		var mediaType string
+		if dataType != nil {
+			mediaType = *dataType
+		}
+		if !p.params.ProcessingOptions.thumbnailTypeAllowed(mediaType) {
+			thumbnailsSkipped[rowID] = struct{}{}
 		}
 	}
 	rows.Close()
@@ -515,6 +582,24 @@ func (p *processor) generateThumbnailsForImportedItems() {
 		return
 	}
 
+	// don't bother with files that the user doesn't want thumbnails for,
+	// like tiny icons, which can be numerous and are cheap to show as-is
+	for dataFile, info := range thumbnailsNeeded {
+		if !p.wantsThumbnail(dataFile, info.dataType) {
+			delete(thumbnailsNeeded, dataFile)
+			thumbnailsSkipped[info.rowID] = struct{}{}
+		}
+	}
+	if len(thumbnailsSkipped) > 0 {
+		p.log.Info("skipping thumbnails for imported items per import options", zap.Int("count", len(thumbnailsSkipped)))
+		for range thumbnailsSkipped {
+			p.countSkip(SkipReasonThumbnail)
+		}
+		if err := p.saveSkipReasons(); err != nil {
+			p.log.Error("saving skipped thumbnails", zap.Int64("import_id", p.impRow.id), zap.Error(err))
+		}
+	}
+
 	p.log.Info("generating thumbnails for imported items", zap.Int("count", len(thumbnailsNeeded)))
 
 	// now that we've closed our DB lock, we can take our
@@ -539,6 +624,9 @@ func (p *processor) generateThumbnailsForImportedItems() {
 		if strings.HasPrefix(info.dataType, "video/") {
 			format = VideoThumbnail
 		}
+		if info.derivedFile != "" {
+			dataFile = info.derivedFile
+		}
 		p.tl.GenerateThumbnail(p.tl.ctx, info.rowID, dataFile, info.dataType, format, errs)
 	}
 	<-done
@@ -556,6 +644,7 @@ func (p *processor) generateThumbhashesForItemsThatNeedOne() {
 		`SELECT id, data_type FROM items WHERE import_id=? AND data_file IS NOT NULL AND thumb_hash IS NULL`,
 		p.impRow.id)
 	if err != nil {
+		p.tl.dbMu.RUnlock()
 		p.log.Error("unable to generate thumbhashes for this import",
 			zap.Int64("import_id", p.impRow.id),
 			zap.Error(err))
@@ -606,18 +695,9 @@ func (p *processor) generateThumbhashesForItemsThatNeedOne() {
 				rowID := thumbhashesNeeded[i]
 
 				thumbnailPath := p.tl.ThumbnailPath(rowID, ImageThumbnail)
-				file, err := os.Open(thumbnailPath)
-				if err != nil {
-					p.log.Error("opening thumbnail to compute thumbhash failed",
-						zap.Int64("import_id", p.impRow.id),
-						zap.Int64("item_id", rowID),
-						zap.String("thumbnail_path", thumbnailPath),
-						zap.Error(err))
-					continue
-				}
-				img, _, err := image.Decode(file)
+				thumbHash, err := thumbhashFromThumbnail(thumbnailPath)
 				if err != nil {
-					p.log.Error("decoding thumbnail for thumbhash computation failed",
+					p.log.Error("computing thumbhash from thumbnail failed",
 						zap.Int64("import_id", p.impRow.id),
 						zap.Int64("item_id", rowID),
 						zap.String("thumbnail_path", thumbnailPath),
@@ -625,15 +705,7 @@ func (p *processor) generateThumbhashesForItemsThatNeedOne() {
 					continue
 				}
 
-				// thumbhash can recover the _approximate_ aspect ratio, but not
-				// exactly, which makes sizing the image difficult on the UI because
-				// replacing the thumbhash image with the real image would result in
-				// a content jump because the images are different sizes! so we
-				// prepend the thumbhash with the exact aspect ratio...
-				aspectRatio := float32(img.Bounds().Dx()) / float32(img.Bounds().Dy())
-				aspectRatioPre := float32ToByte(aspectRatio)
-
-				batch[rowID] = append(aspectRatioPre, thumbhash.EncodeImage(img)...)
+				batch[rowID] = thumbHash
 
 				// if batch is full, store into DB
 				if len(batch) >= 100 {
@@ -664,6 +736,29 @@ func (p *processor) generateThumbhashesForItemsThatNeedOne() {
 	wg.Wait()
 }
 
+// thumbhashFromThumbnail computes the thumbhash of the image thumbnail at the given path.
+func thumbhashFromThumbnail(thumbnailPath string) ([]byte, error) {
+	file, err := os.Open(thumbnailPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening thumbnail: %v", err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("decoding thumbnail: %v", err)
+	}
+
+	// thumbhash can recover the _approximate_ aspect ratio, but not
+	// exactly, which makes sizing the image difficult on the UI because
+	// replacing the thumbhash image with the real image would result in
+	// a content jump because the images are different sizes! so we
+	// prepend the thumbhash with the exact aspect ratio...
+	aspectRatio := float32(img.Bounds().Dx()) / float32(img.Bounds().Dy())
+	aspectRatioPre := float32ToByte(aspectRatio)
+
+	return append(aspectRatioPre, thumbhash.EncodeImage(img)...), nil
+}
+
 func float32ToByte(f float32) []byte {
 	var buf [4]byte
 	binary.BigEndian.PutUint32(buf[:], math.Float32bits(f))
@@ -0,0 +1,95 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TimeAwareDedup configures deduplication of items by their content (see
+// ProcessingOptions.ItemUniqueConstraints) to respect when the items happened.
+// A forwarded message or a re-shared photo has the same content as the original,
+// but happened at a different time; collapsing them into one item would lose
+// that. With this option, items with the same content are only considered the
+// same item if their timestamps are within the window. Otherwise, both items
+// are kept and linked with a "same_content" relationship. (Identical data
+// files are still stored only once either way.)
+type TimeAwareDedup struct {
+	// The maximum difference between the timestamps of items
+	// with the same content for them to be the same item.
+	Window time.Duration `json:"window"`
+}
+
+func (tad TimeAwareDedup) validate() error {
+	if tad.Window < 0 {
+		return fmt.Errorf("time-aware dedup window cannot be negative: %s", tad.Window)
+	}
+	return nil
+}
+
+// linkSameContent relates the newly-stored item to the other items which have
+// the same data, if time-aware deduplication is enabled.
+func (p *processor) linkSameContent(ctx context.Context, tx *sql.Tx, rowID int64, it *Item) error {
+	if p.params.ProcessingOptions.TimeAwareDedup == nil || rowID == 0 {
+		return nil
+	}
+
+	var rows *sql.Rows
+	var err error
+	switch {
+	case it.dataText != nil && *it.dataText != "":
+		rows, err = tx.QueryContext(ctx, `SELECT id FROM items WHERE data_text=? AND id!=? AND deleted IS NULL`, *it.dataText, rowID)
+	case len(it.dataFileHash) > 0:
+		rows, err = tx.QueryContext(ctx, `SELECT id FROM items WHERE data_hash=? AND id!=? AND deleted IS NULL`, it.dataFileHash, rowID)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("querying items with the same content: %v", err)
+	}
+	var sameContent []int64
+	for rows.Next() {
+		var otherID int64
+		if err := rows.Scan(&otherID); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning item with the same content: %v", err)
+		}
+		sameContent = append(sameContent, otherID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating items with the same content: %v", err)
+	}
+
+	for _, otherID := range sameContent {
+		err := p.tl.storeRelationship(ctx, tx, rawRelationship{
+			Relation:   RelSameContent,
+			fromItemID: &rowID,
+			toItemID:   &otherID,
+		})
+		if err != nil {
+			return fmt.Errorf("linking items with the same content: %w", err)
+		}
+	}
+
+	return nil
+}
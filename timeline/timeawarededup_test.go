@@ -0,0 +1,117 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeAwareDedup(t *testing.T) {
+	t0 := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// a message without an original ID, so it is deduplicated by its content
+	forwarded := func(ts time.Time) *Item {
+		it := testMessage("", ts)
+		it.Content.Data = StringData("look at this!")
+		return it
+	}
+
+	importItem := func(t *testing.T, tl *Timeline, it *Item, procOpt ProcessingOptions) {
+		t.Helper()
+		testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			itemChan <- &Graph{Item: it}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: procOpt,
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	count := func(t *testing.T, tl *Timeline) (items, links int) {
+		t.Helper()
+		err := tl.db.QueryRow(`SELECT count() FROM items WHERE data_text='look at this!'`).Scan(&items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tl.db.QueryRow(`SELECT count() FROM relationships
+			JOIN relations ON relations.id = relationships.relation_id
+			WHERE relations.label=?`, RelSameContent.Label).Scan(&links)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	uniqueConstraints := map[string]bool{"data_source_name": true, "classification_name": true, "data": true}
+
+	t.Run("disabled", func(t *testing.T) {
+		tl := newTestTimeline(t)
+		procOpt := ProcessingOptions{ItemUniqueConstraints: uniqueConstraints}
+		importItem(t, tl, forwarded(t0), procOpt)
+		importItem(t, tl, forwarded(t0.Add(30*24*time.Hour)), procOpt)
+		if items, links := count(t, tl); items != 1 || links != 0 {
+			t.Errorf("expected identical content to be collapsed into 1 item with no links, got %d items and %d links", items, links)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		tl := newTestTimeline(t)
+		procOpt := ProcessingOptions{
+			ItemUniqueConstraints: uniqueConstraints,
+			TimeAwareDedup:        &TimeAwareDedup{Window: time.Hour},
+		}
+		importItem(t, tl, forwarded(t0), procOpt)
+		importItem(t, tl, forwarded(t0.Add(30*24*time.Hour)), procOpt) // distant: kept, but linked
+		importItem(t, tl, forwarded(t0.Add(time.Minute)), procOpt)     // within window: same item
+		if items, links := count(t, tl); items != 2 || links != 1 {
+			t.Errorf("expected 2 items linked once, got %d items and %d links", items, links)
+		}
+
+		var fromTS, toTS int64
+		err := tl.db.QueryRow(`SELECT from_item.timestamp, to_item.timestamp
+			FROM relationships
+			JOIN items AS from_item ON from_item.id = relationships.from_item_id
+			JOIN items AS to_item ON to_item.id = relationships.to_item_id`).Scan(&fromTS, &toTS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fromTS == toTS {
+			t.Errorf("expected linked items to have different timestamps, both are %d", fromTS)
+		}
+	})
+
+	t.Run("invalid window", func(t *testing.T) {
+		tl := newTestTimeline(t)
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{TimeAwareDedup: &TimeAwareDedup{Window: -time.Second}},
+		})
+		if err == nil {
+			t.Error("expected error for negative window")
+		}
+	})
+}
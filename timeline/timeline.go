@@ -32,6 +32,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -59,6 +60,46 @@ type Timeline struct {
 	relations       map[string]int64
 	dataSources     map[string]int64
 
+	// running imports that have a job ID, so their progress can be followed
+	importJobsMu sync.Mutex
+	importJobs   map[string]*importJob
+
+	// semaphores for accounts whose data source limits concurrent imports (protected by importJobsMu)
+	accountImports map[int64]chan struct{}
+
+	// number of running imports per data source, and data sources being purged (protected by importJobsMu)
+	activeImports map[string]int
+	purging       map[string]bool
+	resharding    bool
+	reindexing    bool
+
+	// IDs of imports being run by this process (protected by importJobsMu)
+	runningImports map[int64]struct{}
+
+	// imports waiting to run, or running, by priority (see EnqueueImport)
+	importQueue importQueue
+
+	// subscribers to events, such as items being inserted
+	events eventBus
+
+	// items waiting for a missing thumbnail to be generated, and when they were queued
+	thumbnailHealsMu sync.Mutex
+	thumbnailHeals   map[int64]time.Time
+
+	// periodic maintenance tasks started by StartMaintenance
+	maintenanceMu     sync.Mutex
+	maintenanceCancel context.CancelFunc
+	maintenanceWG     sync.WaitGroup
+
+	// number of hash-prefix shard directories that new data files are placed in
+	dataFileShardLevels atomic.Int32
+
+	// whether new items are assigned a global ID (see ItemIDStrategy)
+	globalItemIDs atomic.Bool
+
+	// if true, the database was opened read-only (see OpenReadOnly)
+	readOnly bool
+
 	// The database handle and its mutex. Why a mutex for a DB handle? Because
 	// high-volume imports can sometimes yield "database is locked" errors,
 	// presumably because of scanning rows (`for rows.Next()`) while trying
@@ -137,7 +178,7 @@ func Create(repoPath, cacheDir string) (*Timeline, error) {
 		return nil, fmt.Errorf("opening database: %w", err)
 	}
 
-	return openTimeline(repoPath, cacheDir, db)
+	return openTimeline(repoPath, cacheDir, db, false)
 }
 
 // directoryEmpty returns true if dirPath is an empty directory. If false,
@@ -303,10 +344,10 @@ func Open(repo, cache string) (*Timeline, error) {
 		return nil, fmt.Errorf("opening database: %w", err)
 	}
 
-	return openTimeline(repo, cache, db)
+	return openTimeline(repo, cache, db, false)
 }
 
-func openTimeline(repo, cache string, db *sql.DB) (*Timeline, error) {
+func openTimeline(repo, cache string, db *sql.DB, readOnly bool) (*Timeline, error) {
 	repoMarkerFile := filepath.Join(repo, MarkerFilename)
 
 	var err error
@@ -323,7 +364,7 @@ func openTimeline(repo, cache string, db *sql.DB) (*Timeline, error) {
 	}
 
 	// create marker file; for informational purposes only
-	if !FileExists(repoMarkerFile) {
+	if !readOnly && !FileExists(repoMarkerFile) {
 		timelineMarkerFileContents := strings.ReplaceAll(timelineMarkerContents, "{{repo_id}}", id.String())
 		err = os.WriteFile(repoMarkerFile, []byte(timelineMarkerFileContents), 0644)
 		if err != nil {
@@ -349,11 +390,13 @@ func openTimeline(repo, cache string, db *sql.DB) (*Timeline, error) {
 		return nil, fmt.Errorf("mapping entity types names to IDs: %v", err)
 	}
 
-	// in case of unclean shutdown last time, set all imports that are on "started" status to "aborted"
-	// (no imports can be running currently since we haven't finished opening the timeline yet)
-	_, err = db.Exec(`UPDATE imports SET status='abort' WHERE status='started'`)
+	shardLevels, err := loadDataFileShardLevels(db)
 	if err != nil {
-		return nil, fmt.Errorf("resetting all uncleanly-stopped imports to 'abort' status: %v", err)
+		return nil, err
+	}
+	idStrategy, err := loadItemIDStrategy(db)
+	if err != nil {
+		return nil, err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -370,6 +413,24 @@ func openTimeline(repo, cache string, db *sql.DB) (*Timeline, error) {
 		classifications: classes,
 		entityTypes:     entityTypes,
 		relations:       relations,
+		readOnly:        readOnly,
+	}
+	tl.dataFileShardLevels.Store(int32(shardLevels))
+	tl.globalItemIDs.Store(idStrategy == ItemIDGlobal)
+
+	// thumbnails live in the cache, so they can be healed even if the repo is read-only
+	tl.Subscribe(TopicThumbnailNeeded, tl.generateMissingThumbnails)
+
+	// a read-only timeline leaves the upkeep of the repo to the process that writes to it
+	if readOnly {
+		return tl, nil
+	}
+
+	// in case of unclean shutdown last time, imports still on "started" status would appear to be
+	// running forever (none can actually be running yet, since we haven't finished opening the timeline)
+	if _, err := tl.ReconcileImports(ctx); err != nil {
+		cancel()
+		return nil, fmt.Errorf("reconciling uncleanly-stopped imports: %v", err)
 	}
 
 	// if thumbnail cache does not exist, start building cache
@@ -429,6 +490,7 @@ func (t *Timeline) Close() error {
 		delete(t.rateLimiters, key) // TODO: maybe racey?
 	}
 	t.cancel() // cancel this timeline's context, so anything waiting on it knows we're closing
+	t.StopMaintenance()
 	if t.db != nil {
 		t.dbMu.Lock()
 		defer t.dbMu.Unlock()
@@ -551,6 +613,10 @@ func (tl *Timeline) ItemClassifications() ([]Classification, error) {
 }
 
 func (tl *Timeline) StoreEntity(ctx context.Context, entity Entity) error {
+	if err := tl.checkWritable("store entity"); err != nil {
+		return err
+	}
+
 	tl.normalizeEntity(&entity)
 
 	metaStr, err := entity.metadataString()
@@ -696,6 +762,9 @@ func (tl *Timeline) DeleteItems(ctx context.Context, itemRowIDs []int64, options
 	if len(itemRowIDs) == 0 {
 		return nil
 	}
+	if err := tl.checkWritable("delete items"); err != nil {
+		return err
+	}
 
 	if options.Retain == nil {
 		// TODO: get globally-configured default retention period; for now just hard-coded here
@@ -733,7 +802,7 @@ func (tl *Timeline) DeleteItems(ctx context.Context, itemRowIDs []int64, options
 	if options.Remember || retention == 0 {
 		for _, rowID := range itemRowIDs {
 			// get the item
-			ir, err := tl.loadItemRow(ctx, tx, rowID, nil, nil, nil, false)
+			ir, err := tl.loadItemRow(ctx, tx, rowID, nil, nil, "", nil, nil, nil, false)
 			if err != nil {
 				return fmt.Errorf("could not load item to delete: %v", err)
 			}
@@ -799,6 +868,8 @@ func (tl *Timeline) DeleteItems(ctx context.Context, itemRowIDs []int64, options
 			zap.Int("count", len(itemRowIDs)),
 			zap.Int("deleted_data_files", numFilesDeleted))
 
+		tl.publish(Event{Topic: TopicItemDeleted, ItemIDs: itemRowIDs})
+
 		// deletion is completion :D
 		return nil
 	}
@@ -823,6 +894,8 @@ func (tl *Timeline) DeleteItems(ctx context.Context, itemRowIDs []int64, options
 		zap.String("retention_period", retention.String()),
 		zap.Time("deletion_scheduled", deleteAt))
 
+	tl.publish(Event{Topic: TopicItemDeleted, ItemIDs: itemRowIDs})
+
 	return nil
 }
 
@@ -935,24 +1008,22 @@ func StringData(data string) func(_ context.Context) (io.ReadCloser, error) {
 }
 
 // ByteData makes it easy to return a simple byte array as an item's data.
+// Since all of the data is in memory, large content should be returned
+// as a stream instead (see DataFunc).
 func ByteData(data []byte) func(_ context.Context) (io.ReadCloser, error) {
 	return func(_ context.Context) (io.ReadCloser, error) {
 		return io.NopCloser(bytes.NewReader(data)), nil
 	}
 }
 
-// DownloadData returns a function that opens the response body for the given url.
+// DownloadData returns a function that downloads the given url. The download is
+// saved to a temporary file, and only read once it is complete and its size (and,
+// when importing, the expected size and hash of the item's content) is verified.
+// If the download fails part way through, it is resumed where it left off, if the
+// server supports range requests.
 func DownloadData(ctx context.Context, url string) DataFunc {
 	return func(ctx context.Context) (io.ReadCloser, error) {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		if err != nil {
-			return nil, err
-		}
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		return resp.Body, err
+		return &downloadedData{ctx: ctx, client: http.DefaultClient, url: url}, nil
 	}
 }
 
@@ -1000,6 +1071,7 @@ var processorCtxKey ctxKey = "processor"
 // such as timeframe.
 type checkpoint struct {
 	Filenames []string
+	Archive   bool
 	ProcOpt   ProcessingOptions
 	Data      any // provided by, and passed back into, the data source
 }
@@ -1012,24 +1084,222 @@ type ProcessingOptions struct {
 	Timeframe      Timeframe `json:"timeframe,omitempty"`
 	KeepEmptyItems bool      `json:"keep_empty_items,omitempty"` // TODO: not used?
 
+	// If set, the timeframe is determined relative to when the import runs,
+	// for example "the last 7 days" or "since the previous import". It
+	// cannot be combined with GetLatest or Timeframe.Since.
+	RelativeTimeframe *RelativeTimeframe `json:"relative_timeframe,omitempty"`
+
+	// With GetLatest, items newer than this long ago are left for the next
+	// import, for APIs whose latest results can briefly omit items that were
+	// just published (eventual consistency). Otherwise, such an item could
+	// be older than the newest item of this import, and be missed by the next
+	// import, which only gets items newer than that.
+	QuietPeriod time.Duration `json:"quiet_period,omitempty"`
+
 	// If true, items with manual modifications may be updated, overwriting local changes.
 	OverwriteModifications bool `json:"overwrite_modifications,omitempty"`
 
+	// If true, files will be imported even if their contents are identical
+	// to files from a previous successful import of the same data source.
+	Force bool `json:"force,omitempty"`
+
+	// If nonzero, a warning will be logged if no items are processed and
+	// no checkpoints are saved for this long. If WatchdogAbort is also true,
+	// the import will be canceled with a StalledImportError.
+	Watchdog      time.Duration `json:"watchdog,omitempty"`
+	WatchdogAbort bool          `json:"watchdog_abort,omitempty"`
+
+	// If nonzero, the import will be stopped after running for this long.
+	// A checkpoint is saved so that the import can be resumed later, and
+	// the import returns a DeadlineExceededImport error.
+	MaxDuration time.Duration `json:"max_duration,omitempty"`
+
+	// If true, when the data source gives an existing item with different
+	// content (e.g. an edited message), the existing version is preserved
+	// in the item's edit history before being replaced by the new version.
+	ImportEditHistory bool `json:"import_edit_history,omitempty"`
+
+	// If set, the text of items is normalized with these rules, and the
+	// normalized text is used to find existing items when deduplicating.
+	TextNormalization *TextNormalization `json:"text_normalization,omitempty"`
+
+	// Plain text content smaller than this many bytes is stored in the
+	// database; content of this size or larger is stored in a data file.
+	// Higher values mean a bigger database but fewer files. If 0, the
+	// default of 1 MiB is used.
+	InlineThresholdBytes int `json:"inline_threshold_bytes,omitempty"`
+
+	// If nonzero, at most this many graphs from the data source are held in
+	// memory waiting to be processed or being processed; when the bound is
+	// reached, the data source blocks when it sends the next graph until the
+	// database catches up. The processor streams data files to disk, so it
+	// never needs a whole file in memory, but graphs themselves (including
+	// any inline text) are queued. Default: bounded only by the batch size
+	// and number of workers.
+	MaxPendingGraphs int `json:"max_pending_graphs,omitempty"`
+
+	// If nonzero, the estimated memory footprint of the import (graphs that
+	// are queued or being processed, plus the buffers of data file downloads)
+	// is kept within roughly this many bytes: as it approaches the budget,
+	// batches are processed early, and new downloads and graphs from the data
+	// source wait until memory is freed. Useful on memory-constrained devices.
+	MemoryBudgetBytes int64 `json:"memory_budget_bytes,omitempty"`
+
+	// If nonzero, at most this many data files are written at the same time,
+	// regardless of how many are being downloaded concurrently; the rest wait
+	// their turn. This keeps large imports from running out of file descriptors.
+	MaxOpenFiles int `json:"max_open_files,omitempty"`
+
+	// If set, batches of items are committed before they are full, at
+	// the expense of import speed (see FlushEvery for the tradeoff).
+	FlushEvery *FlushEvery `json:"flush_every,omitempty"`
+
+	// If nonzero, up to this many graphs from the data source are held back
+	// so they can be processed in order of their items' timestamps, which
+	// helps with data sources that give items out of order. Items that are
+	// further out of order than the window pass through unordered, and
+	// graphs without a timestamp are not held back. When combined with
+	// MaxPendingGraphs, the window must be less than half of it.
+	ReorderWindow int `json:"reorder_window,omitempty"`
+
+	// If true, when an existing item is given again with data that has new
+	// content at the end (e.g. a log or document that grows over time), only
+	// the new content is appended to the stored data instead of replacing it.
+	// Content that was already appended is not appended again.
+	AppendMode bool `json:"append_mode,omitempty"`
+
+	// If set, data files of text-like types (plain text, JSON, XML, etc.)
+	// are stored compressed with this format. Media files are left alone
+	// since they are usually compressed already.
+	CompressDataFiles DataFileCompression `json:"compress_data_files,omitempty"`
+
+	// How many times to download a data file whose size or checksum does not
+	// match what the data source says it should be, before skipping the item.
+	// Only applies to content with an expected size or hash. Default: 3.
+	DownloadAttempts int `json:"download_attempts,omitempty"`
+
+	// Data files smaller than this many bytes don't get thumbnails,
+	// since tiny images (like icons) are cheap to show as-is.
+	ThumbnailMinBytes int64 `json:"thumbnail_min_bytes,omitempty"`
+
+	// If set, only data files of these media types get thumbnails. A
+	// type may end in a wildcard, like "image/*". Default: all types
+	// that thumbnails can be generated for.
+	ThumbnailTypes []string `json:"thumbnail_types,omitempty"`
+
+	// What to do with items whose data files could not be written
+	// completely. Default: remove.
+	DataFileFailures DataFileFailurePolicy `json:"data_file_failures,omitempty"`
+
+	// How to handle items with timestamps in the future, which usually
+	// means the clock of the source device was wrong. Default: clamp.
+	FutureTimestamps FutureTimestampPolicy `json:"future_timestamps,omitempty"`
+
+	// If true, timestamps at or near the Unix epoch (1970-01-01), which many
+	// sources emit when they don't know the time, are treated as unknown, so
+	// such items don't cluster at the epoch.
+	ZeroTimestampAsUnknown bool `json:"zero_timestamp_as_unknown,omitempty"`
+
+	// How close to the epoch a timestamp must be to be treated as unknown
+	// when ZeroTimestampAsUnknown is enabled. Default: 24h.
+	ZeroTimestampThreshold time.Duration `json:"zero_timestamp_threshold,omitempty"`
+
+	// The precision with which item timestamps are stored and compared.
+	// Default: milliseconds.
+	TimestampPrecision TimestampPrecision `json:"timestamp_precision,omitempty"`
+
+	// Among which items to look for an item to determine whether it is
+	// already in the timeline. Default: data source.
+	DedupScope DedupScope `json:"dedup_scope,omitempty"`
+
+	// What to do when the data source gives an item with the same original
+	// ID more than once in the same import. Default: merge.
+	IntraImportDuplicates DuplicateItemPolicy `json:"intra_import_duplicates,omitempty"`
+
+	// What to do with relationships to items that are referenced only by
+	// ID and are not in the timeline (yet). Default: keep.
+	MissingReferences MissingReferencePolicy `json:"missing_references,omitempty"`
+
+	// If true, after the import, items from the data source that were only
+	// partially imported are completed (see CompleteRetrievalKeyedItems).
+	CompleteItems bool `json:"complete_items,omitempty"`
+
+	// If set, the import fails once too many items fail to be processed;
+	// otherwise, failed items are logged and the import carries on.
+	FailureThreshold *FailureThreshold `json:"failure_threshold,omitempty"`
+
+	// The visibility of imported items that don't specify their own.
+	// If nil, such items are public.
+	DefaultVisibility *Visibility `json:"default_visibility,omitempty"`
+
 	// Names of columns in the items table to check for sameness when loading an item
 	// that doesn't have data_source+original_id. The field/column is the same if the
 	// values are identical or if one of the values is NULL. If the map value is true,
 	// however, strict NULL comparison is applied, where NULL=NULL only.
 	ItemUniqueConstraints map[string]bool `json:"item_unique_constraints,omitempty"`
 
+	// How to resolve conflicting names and pictures of entities that are
+	// already in the timeline. If nil, existing values are kept.
+	EntityMerge *EntityMergePolicy `json:"entity_merge,omitempty"`
+
+	// If set, items with the same content (per ItemUniqueConstraints) are only
+	// the same item if they happened within a window of time; otherwise both are
+	// kept and linked as having the same content.
+	TimeAwareDedup *TimeAwareDedup `json:"time_aware_dedup,omitempty"`
+
+	// How to store data files that are symlinks to files outside the repo.
+	// Default: copy. See SymlinkPolicy for the implications of referencing.
+	SymlinkPolicy SymlinkPolicy `json:"symlink_policy,omitempty"`
+
 	// The policies to apply when updating an item in the DB, specified per-field.
 	// Note: Some fields are described in aggregate, such as data and location.
 	ItemFieldUpdates map[string]fieldUpdatePolicy `json:"item_field_updates,omitempty"`
 }
 
+// IsEmpty returns true if no processing options are set. Every
+// field must be checked here (see TestProcessingOptionsIsEmpty).
 func (po ProcessingOptions) IsEmpty() bool {
-	return !po.GetLatest && !po.Prune && !po.Integrity &&
-		po.Timeframe.IsEmpty() && !po.KeepEmptyItems &&
-		po.ItemUniqueConstraints == nil && po.ItemFieldUpdates == nil
+	return !po.GetLatest &&
+		!po.Prune &&
+		!po.Integrity &&
+		po.Timeframe.IsEmpty() &&
+		!po.KeepEmptyItems &&
+		po.RelativeTimeframe == nil &&
+		po.QuietPeriod == 0 &&
+		!po.OverwriteModifications &&
+		!po.Force &&
+		po.Watchdog == 0 &&
+		!po.WatchdogAbort &&
+		po.MaxDuration == 0 &&
+		!po.ImportEditHistory &&
+		po.TextNormalization == nil &&
+		po.InlineThresholdBytes == 0 &&
+		po.MaxPendingGraphs == 0 &&
+		po.MemoryBudgetBytes == 0 &&
+		po.MaxOpenFiles == 0 &&
+		po.FlushEvery == nil &&
+		po.ReorderWindow == 0 &&
+		!po.AppendMode &&
+		po.CompressDataFiles == "" &&
+		po.DownloadAttempts == 0 &&
+		po.ThumbnailMinBytes == 0 &&
+		po.ThumbnailTypes == nil &&
+		po.DataFileFailures == "" &&
+		po.FutureTimestamps == "" &&
+		!po.ZeroTimestampAsUnknown &&
+		po.ZeroTimestampThreshold == 0 &&
+		po.TimestampPrecision == "" &&
+		po.DedupScope == "" &&
+		po.IntraImportDuplicates == "" &&
+		po.MissingReferences == "" &&
+		!po.CompleteItems &&
+		po.FailureThreshold == nil &&
+		po.DefaultVisibility == nil &&
+		po.ItemUniqueConstraints == nil &&
+		po.EntityMerge == nil &&
+		po.TimeAwareDedup == nil &&
+		po.SymlinkPolicy == "" &&
+		po.ItemFieldUpdates == nil
 }
 
 // fieldUpdatePolicy values specify how to update a field/column of an item in the DB.
@@ -1070,11 +1340,30 @@ type ListingOptions struct {
 	// as provided by NewOptions.
 	DataSourceOptions any
 
+	// A directory for scratch files, such as partial downloads or
+	// decrypted copies of input files, that is unique to the import.
+	// It and everything in it is deleted when the import finishes.
+	TempDir string
+
+	// The passphrase for encrypted files, if given; file
+	// importers can use FileSystem to decrypt them. It
+	// must never be logged or stored in a checkpoint.
+	Passphrase Passphrase
+
+	// If set, the filenames given to FileImport are paths in this
+	// file system, such as the contents of an archive, instead of
+	// on disk (see ImportArchive). It is only set for data sources
+	// that support it (see DataSource.SupportsFS).
+	FS fs.FS
+
 	// Maximum number of items to list; useful
 	// for previews. Data sources should not
 	// checkpoint previews.
 	// TODO: still should enforce this in the processor... but this is good for the DS to know too, so it can limit its API calls, for example
 	MaxItems int
+
+	// how much of the input files has been read (see ReportFileProgress)
+	fileProgress *fileProgress
 }
 
 // Files belonging at the root within the timeline repository.
@@ -1089,6 +1378,10 @@ const (
 	// (for example, profile pictures, etc).
 	AssetsFolderName = "assets"
 
+	// The folder containing temporary files of imports that are
+	// running (see ListingOptions.TempDir).
+	TempFolderName = "tmp"
+
 	// An optional file that is placed for informational purposes only.
 	MarkerFilename = "timelinize_repo.txt"
 )
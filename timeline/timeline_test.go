@@ -0,0 +1,69 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcessingOptionsIsEmpty(t *testing.T) {
+	if !(ProcessingOptions{}).IsEmpty() {
+		t.Fatal("expected zero value to be empty")
+	}
+
+	// setting any one option must make the options non-empty, so that
+	// options added later can't be forgotten by IsEmpty
+	typ := reflect.TypeOf(ProcessingOptions{})
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		var po ProcessingOptions
+		setNonZero(t, reflect.ValueOf(&po).Elem().Field(i))
+		if po.IsEmpty() {
+			t.Errorf("expected options with %s set to not be empty", field.Name)
+		}
+	}
+}
+
+// setNonZero sets v to some value other than its zero value.
+func setNonZero(t *testing.T, v reflect.Value) {
+	t.Helper()
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+	case reflect.Struct:
+		setNonZero(t, v.Field(0))
+	default:
+		t.Fatalf("don't know how to set a %s", v.Type())
+	}
+}
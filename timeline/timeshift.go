@@ -0,0 +1,219 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ShiftTimestamps moves the timestamps of the items matching params by delta,
+// for example to correct items from a camera whose clock was set to the wrong
+// time zone. The timestamp, timespan, and timeframe of each item (and its
+// original timestamp, if it was clamped) are shifted together in a single
+// transaction, so the relative order of the items is preserved. Items that
+// are attached to other items are included if they match params. Shifted items
+// are marked as modified, so that importing them again does not revert the
+// change. Limit, offset, and sort of params are ignored. It returns the number
+// of items that were shifted.
+func (tl *Timeline) ShiftTimestamps(ctx context.Context, params ItemSearchParams, delta time.Duration) (int, error) {
+	if err := tl.checkWritable("shift timestamps"); err != nil {
+		return 0, err
+	}
+
+	if delta == 0 {
+		return 0, nil
+	}
+
+	itemsQuery, args, err := tl.prepareBulkEditQuery(params)
+	if err != nil {
+		return 0, err
+	}
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	tx, err := tl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	ms, micros := delta.Milliseconds(), delta.Microseconds()
+	rows, err := tx.QueryContext(ctx, `UPDATE items
+		SET timestamp=timestamp+?, timestamp_micros=timestamp_micros+?, original_timestamp=original_timestamp+?,
+			timespan=timespan+?, timeframe=timeframe+?, modified=unixepoch()
+		WHERE id IN (SELECT id FROM (`+itemsQuery+`))
+			AND (timestamp IS NOT NULL OR timespan IS NOT NULL OR timeframe IS NOT NULL)
+		RETURNING id`,
+		append([]any{ms, micros, ms, ms, ms}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("shifting timestamps: %v", err)
+	}
+	itemIDs, err := scanItemIDs(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %v", err)
+	}
+
+	Log.Info("shifted item timestamps",
+		zap.Duration("delta", delta),
+		zap.Int("count", len(itemIDs)))
+	if len(itemIDs) > 0 {
+		tl.publish(Event{Topic: TopicItemUpdated, ItemIDs: itemIDs})
+	}
+
+	return len(itemIDs), nil
+}
+
+// SetTimezone reinterprets the timestamps of the items matching params that
+// have no time zone (offset) as local times in loc. This is for data sources
+// that give naive times (without a time zone), which are stored as if they
+// were UTC: an item at 09:00 (naive) becomes 09:00 in loc, and its timespan
+// and timeframe are moved by the same amount. The offset of loc at each item's
+// time is recorded, so calling this again does not change the item further.
+// Items that already have an offset are not changed. Like ShiftTimestamps, it
+// happens in a single transaction and marks the items as modified. It returns
+// the number of items that were changed.
+func (tl *Timeline) SetTimezone(ctx context.Context, params ItemSearchParams, loc *time.Location) (int, error) {
+	if err := tl.checkWritable("set time zone"); err != nil {
+		return 0, err
+	}
+
+	if loc == nil {
+		return 0, fmt.Errorf("time zone is required")
+	}
+
+	itemsQuery, args, err := tl.prepareBulkEditQuery(params)
+	if err != nil {
+		return 0, err
+	}
+
+	tl.dbMu.Lock()
+	defer tl.dbMu.Unlock()
+
+	tx, err := tl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, timestamp FROM items
+		WHERE id IN (SELECT id FROM (`+itemsQuery+`))
+			AND timestamp IS NOT NULL
+			AND time_offset IS NULL`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("querying items without time zone: %v", err)
+	}
+	type naiveItem struct {
+		id        int64
+		timestamp int64
+	}
+	var items []naiveItem
+	for rows.Next() {
+		var it naiveItem
+		if err := rows.Scan(&it.id, &it.timestamp); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning item: %v", err)
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterating item rows: %v", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE items
+		SET timestamp=timestamp+?, timestamp_micros=timestamp_micros+?, original_timestamp=original_timestamp+?,
+			timespan=timespan+?, timeframe=timeframe+?, time_offset=?, modified=unixepoch()
+		WHERE id=?`)
+	if err != nil {
+		return 0, fmt.Errorf("preparing update statement: %v", err)
+	}
+	defer stmt.Close()
+
+	itemIDs := make([]int64, 0, len(items))
+	for _, it := range items {
+		// the wall clock reading of the naive time is its UTC reading
+		naive := time.UnixMilli(it.timestamp).UTC()
+		local := time.Date(naive.Year(), naive.Month(), naive.Day(),
+			naive.Hour(), naive.Minute(), naive.Second(), naive.Nanosecond(), loc)
+		_, offsetSec := local.Zone()
+		delta := local.Sub(naive)
+
+		ms, micros := delta.Milliseconds(), delta.Microseconds()
+		if _, err := stmt.ExecContext(ctx, ms, micros, ms, ms, ms, offsetSec, it.id); err != nil {
+			return 0, fmt.Errorf("setting time zone of item %d: %v", it.id, err)
+		}
+		itemIDs = append(itemIDs, it.id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %v", err)
+	}
+
+	Log.Info("set time zone of items",
+		zap.String("time_zone", loc.String()),
+		zap.Int("count", len(itemIDs)))
+	if len(itemIDs) > 0 {
+		tl.publish(Event{Topic: TopicItemUpdated, ItemIDs: itemIDs})
+	}
+
+	return len(itemIDs), nil
+}
+
+// prepareBulkEditQuery returns a query that selects the IDs of all
+// items matching params, including items attached to other items,
+// without any limit.
+func (tl *Timeline) prepareBulkEditQuery(params ItemSearchParams) (string, []any, error) {
+	params.timestampsOnly = true
+	params.Astructured = true
+	params.GeoJSON = false
+	params.WithTotal = false
+	params.Sort = SortNone
+	params.Limit = -1
+	params.Offset = 0
+	params.Sample = 0
+	params.Related = 0
+	params.Timestamp, params.Latitude, params.Longitude = nil, nil, nil
+	return tl.prepareSearchQuery(params)
+}
+
+func scanItemIDs(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scanning item ID: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating item IDs: %v", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,139 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShiftTimestamps(t *testing.T) {
+	tl := newTestTimeline(t)
+	ts := time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	importFrom := func(dsName string, procOpt ProcessingOptions, items ...*Item) Timeframe {
+		t.Helper()
+		var tf Timeframe
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, opt ListingOptions) error {
+			tf = opt.Timeframe
+			for _, it := range items {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    dsName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: procOpt,
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		return tf
+	}
+
+	importFrom(testDataSourceName, ProcessingOptions{},
+		testMessage("a", ts),
+		testMessage("b", ts.Add(time.Hour)),
+		testMessage("c", ts.Add(2*time.Hour)))
+	importFrom(redactTestDataSourceName, ProcessingOptions{},
+		testMessage("other", ts.Add(3*time.Hour)))
+
+	// the camera was 5 hours off
+	n, err := tl.ShiftTimestamps(context.Background(), ItemSearchParams{DataSourceName: []string{testDataSourceName}}, 5*time.Hour)
+	if err != nil {
+		t.Fatalf("shifting timestamps: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 items to be shifted, got %d", n)
+	}
+
+	results, err := tl.Search(context.Background(), ItemSearchParams{Sort: SortAsc})
+	if err != nil {
+		t.Fatalf("searching: %v", err)
+	}
+	var order []string
+	for _, sr := range results.Items {
+		order = append(order, *sr.OriginalID)
+		expect := map[string]time.Time{
+			"a":     ts.Add(5 * time.Hour),
+			"b":     ts.Add(6 * time.Hour),
+			"c":     ts.Add(7 * time.Hour),
+			"other": ts.Add(3 * time.Hour),
+		}[*sr.OriginalID]
+		if sr.Timestamp == nil || !sr.Timestamp.Equal(expect) {
+			t.Errorf("item %s: expected timestamp %s, got %v", *sr.OriginalID, expect, sr.Timestamp)
+		}
+	}
+	if expect := []string{"other", "a", "b", "c"}; !slices.Equal(order, expect) {
+		t.Errorf("expected items in order %v, got %v", expect, order)
+	}
+
+	// getting the latest items should continue after the shifted last item
+	tf := importFrom(testDataSourceName, ProcessingOptions{GetLatest: true})
+	if tf.Since == nil || !tf.Since.Equal(ts.Add(7*time.Hour)) {
+		t.Errorf("expected get latest to start at the shifted timestamp %s, got %v", ts.Add(7*time.Hour), tf.Since)
+	}
+	if tf.SinceItemID == nil || *tf.SinceItemID != "c" {
+		t.Errorf("expected get latest to start after item 'c', got %v", tf.SinceItemID)
+	}
+
+	// importing the items again must not undo the shift
+	importFrom(testDataSourceName, ProcessingOptions{}, testMessage("a", ts))
+	var aTimestamp int64
+	if err := tl.db.QueryRow(`SELECT timestamp FROM items WHERE original_id='a'`).Scan(&aTimestamp); err != nil {
+		t.Fatal(err)
+	}
+	if aTimestamp != ts.Add(5*time.Hour).UnixMilli() {
+		t.Errorf("expected reimport to keep shifted timestamp, got %s", time.UnixMilli(aTimestamp).UTC())
+	}
+}
+
+func TestSetTimezone(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// a naive time (no zone) is stored as if it was UTC
+	naive := time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)
+	importTestItems(t, tl, testMessage("naive", naive))
+
+	est := time.FixedZone("EST", -5*60*60)
+	for i, expect := range []int{1, 0} {
+		n, err := tl.SetTimezone(context.Background(), ItemSearchParams{}, est)
+		if err != nil {
+			t.Fatalf("setting time zone: %v", err)
+		}
+		if n != expect {
+			t.Errorf("call %d: expected %d items to be changed, got %d", i, expect, n)
+		}
+	}
+
+	var ts int64
+	var offset *int
+	if err := tl.db.QueryRow(`SELECT timestamp, time_offset FROM items WHERE original_id='naive'`).Scan(&ts, &offset); err != nil {
+		t.Fatal(err)
+	}
+	if expect := time.Date(2020, 6, 1, 9, 0, 0, 0, est); ts != expect.UnixMilli() {
+		t.Errorf("expected timestamp %s, got %s", expect, time.UnixMilli(ts).In(est))
+	}
+	if offset == nil || *offset != -5*60*60 {
+		t.Errorf("expected time offset to be recorded, got %v", offset)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampPrecision is the resolution at which item timestamps are
+// stored and compared when checking items for sameness. Timestamps are
+// stored as Unix milliseconds; sub-millisecond precision is kept in a
+// separate column so that ordering and lookups by millisecond continue
+// to work for all items.
+type TimestampPrecision string
+
+const (
+	// TimestampPrecisionSeconds truncates timestamps to the second, which
+	// is useful for sources whose copies of the same item only differ by
+	// fractions of a second.
+	TimestampPrecisionSeconds TimestampPrecision = "seconds"
+
+	// TimestampPrecisionMilliseconds truncates timestamps to the
+	// millisecond. This is the default.
+	TimestampPrecisionMilliseconds TimestampPrecision = "milliseconds"
+
+	// TimestampPrecisionMicroseconds keeps timestamps to the microsecond,
+	// which preserves the order of items from high-frequency sources like
+	// sensor logs.
+	TimestampPrecisionMicroseconds TimestampPrecision = "microseconds"
+)
+
+func (tp TimestampPrecision) validate() error {
+	switch tp {
+	case "", TimestampPrecisionSeconds, TimestampPrecisionMilliseconds, TimestampPrecisionMicroseconds:
+		return nil
+	}
+	return fmt.Errorf("unrecognized timestamp precision: %s", tp)
+}
+
+func (tp TimestampPrecision) unit() time.Duration {
+	switch tp {
+	case TimestampPrecisionSeconds:
+		return time.Second
+	case TimestampPrecisionMicroseconds:
+		return time.Microsecond
+	}
+	return time.Millisecond
+}
+
+// truncateTimestamps truncates the time fields of it to the precision.
+func (tp TimestampPrecision) truncateTimestamps(it *Item) {
+	unit := tp.unit()
+	it.Timestamp = it.Timestamp.Truncate(unit)
+	it.Timespan = it.Timespan.Truncate(unit)
+	it.Timeframe = it.Timeframe.Truncate(unit)
+}
+
+// timestampMicros returns the timestamp as Unix microseconds, but only if
+// it has sub-millisecond precision; otherwise the millisecond timestamp
+// column says it all.
+func (it Item) timestampMicros() *int64 {
+	if it.Timestamp.IsZero() {
+		return nil
+	}
+	return subMilliMicros(it.Timestamp)
+}
+
+// timestampMicros is like Item.timestampMicros. Clamped timestamps are
+// the time of import, whose precision is not meaningful, so they are
+// not kept.
+func (ir ItemRow) timestampMicros() *int64 {
+	if ir.Timestamp == nil || ir.OriginalTimestamp != nil {
+		return nil
+	}
+	return subMilliMicros(*ir.Timestamp)
+}
+
+func subMilliMicros(t time.Time) *int64 {
+	micros := t.UnixMicro()
+	if micros%1000 == 0 {
+		return nil
+	}
+	return &micros
+}
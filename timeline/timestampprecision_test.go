@@ -0,0 +1,140 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimestampPrecision(t *testing.T) {
+	base := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+	for _, tc := range []struct {
+		precision TimestampPrecision
+		offsets   []time.Duration // emitted in reverse order
+		expected  []time.Duration // in ascending search order
+		expectIDs []string
+	}{
+		{
+			// millisecond-distinct items keep their order and their timestamps
+			precision: TimestampPrecisionMilliseconds,
+			offsets:   []time.Duration{2 * time.Millisecond, time.Millisecond, 0},
+			expected:  []time.Duration{0, time.Millisecond, 2 * time.Millisecond},
+			expectIDs: []string{"i2", "i1", "i0"},
+		},
+		{
+			// so do microsecond-distinct ones, even within the same millisecond
+			precision: TimestampPrecisionMicroseconds,
+			offsets:   []time.Duration{1500 * time.Microsecond, 1250 * time.Microsecond, time.Millisecond},
+			expected:  []time.Duration{time.Millisecond, 1250 * time.Microsecond, 1500 * time.Microsecond},
+			expectIDs: []string{"i2", "i1", "i0"},
+		},
+		{
+			// by default, sub-millisecond precision is dropped, so source order breaks the tie
+			offsets:   []time.Duration{1500 * time.Microsecond, 1250 * time.Microsecond},
+			expected:  []time.Duration{time.Millisecond, time.Millisecond},
+			expectIDs: []string{"i0", "i1"},
+		},
+		{
+			precision: TimestampPrecisionSeconds,
+			offsets:   []time.Duration{900 * time.Millisecond, 100 * time.Millisecond},
+			expected:  []time.Duration{0, 0},
+			expectIDs: []string{"i0", "i1"},
+		},
+	} {
+		tl := newTestTimeline(t)
+
+		var items []*Item
+		for i, offset := range tc.offsets {
+			items = append(items, testMessage("i"+string(rune('0'+i)), base.Add(offset)))
+		}
+		testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, it := range items {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName:    testDataSourceName,
+			Filenames:         []string{"test"},
+			ProcessingOptions: ProcessingOptions{TimestampPrecision: tc.precision},
+		})
+		if err != nil {
+			t.Fatalf("precision %q: import failed: %v", tc.precision, err)
+		}
+
+		results, err := tl.Search(context.Background(), ItemSearchParams{Sort: SortAsc})
+		if err != nil {
+			t.Fatalf("precision %q: search failed: %v", tc.precision, err)
+		}
+		if len(results.Items) != len(tc.expected) {
+			t.Fatalf("precision %q: expected %d results, got %d", tc.precision, len(tc.expected), len(results.Items))
+		}
+		for i, result := range results.Items {
+			if result.OriginalID == nil || *result.OriginalID != tc.expectIDs[i] {
+				t.Errorf("precision %q: result %d: expected %s, got %v", tc.precision, i, tc.expectIDs[i], result.OriginalID)
+			}
+			if expected := base.Add(tc.expected[i]); result.Timestamp == nil || !result.Timestamp.Equal(expected) {
+				t.Errorf("precision %q: result %d: expected timestamp %s, got %v", tc.precision, i, expected, result.Timestamp)
+			}
+		}
+	}
+}
+
+func TestTimestampPrecisionDedup(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	// without an original ID, items are the same if their content and timestamp are
+	item := func(ts time.Time) *Item {
+		return &Item{Classification: ClassMessage, Timestamp: ts, Content: ItemData{Data: StringData("reading")}}
+	}
+	base := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	for _, precision := range []TimestampPrecision{TimestampPrecisionMicroseconds, TimestampPrecisionMicroseconds, TimestampPrecisionSeconds} {
+		readings := []*Item{item(base.Add(100 * time.Microsecond)), item(base.Add(200 * time.Microsecond))}
+		testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, it := range readings {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		err := tl.Import(context.Background(), ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+			ProcessingOptions: ProcessingOptions{
+				TimestampPrecision:    precision,
+				ItemUniqueConstraints: map[string]bool{"data": true, "timestamp": true},
+			},
+		})
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	// the microsecond imports give two distinct readings, the second import
+	// of which are duplicates; at second precision, the reading is a third item
+	var count int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 items, got %d", count)
+	}
+}
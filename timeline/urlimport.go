@@ -0,0 +1,179 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// urlDownloadTimeout is how long downloading a file for ImportFromURL may
+// take in total, unless overridden by the options.
+const urlDownloadTimeout = 2 * time.Hour
+
+// urlDownloadThrottle limits how many files are downloaded for
+// ImportFromURL at once, since exports are often very large.
+var urlDownloadThrottle = make(chan struct{}, 2)
+
+// URLImportOptions constrains what ImportFromURL will accept from the server.
+type URLImportOptions struct {
+	// If set, the media type the response must have (e.g. "application/zip").
+	ContentType string `json:"content_type,omitempty"`
+
+	// If greater than 0, the download fails if it is larger than this many bytes.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// How long the download may take; urlDownloadTimeout if 0.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// ImportFromURL downloads the file at rawURL to a temporary location and then
+// imports it as a file import with the given parameters, which must not
+// specify any filenames. The URL is recorded with the import as its source.
+// The downloaded file is removed once the import is done.
+func (t *Timeline) ImportFromURL(ctx context.Context, rawURL string, params ImportParameters, opts URLImportOptions) error {
+	if err := t.checkWritable("import"); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme: %s", u.Scheme)
+	}
+	if len(params.Filenames) > 0 || params.AccountID != 0 || params.ResumeImportID != 0 {
+		return fmt.Errorf("importing from a URL cannot be combined with filenames, accounts, or resuming an import")
+	}
+
+	// download into the repo's temporary folder, like imports do with their scratch files
+	base := filepath.Join(t.repoDir, TempFolderName)
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return fmt.Errorf("creating temporary folder: %w", err)
+	}
+	dir, err := os.MkdirTemp(base, "url_import_")
+	if err != nil {
+		return fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			Log.Error("removing downloaded file", zap.String("dir", dir), zap.Error(err))
+		}
+	}()
+
+	filename, err := t.downloadForImport(ctx, u, dir, opts)
+	if err != nil {
+		return err
+	}
+
+	params.Filenames = []string{filename}
+	params.SourceURL = u.Redacted()
+
+	return t.Import(ctx, params)
+}
+
+// downloadForImport downloads the resource at u into dir and returns the path of
+// the file it was saved to.
+func (t *Timeline) downloadForImport(ctx context.Context, u *url.URL, dir string, opts URLImportOptions) (string, error) {
+	select {
+	case urlDownloadThrottle <- struct{}{}:
+		defer func() { <-urlDownloadThrottle }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = urlDownloadTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logger := Log.Named("url_import").With(zap.String("url", u.Redacted()))
+
+	dl, err := openDownload(ctx, http.DefaultClient, u.String())
+	if err != nil {
+		return "", err
+	}
+	defer dl.Close()
+
+	if opts.ContentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(dl.header.Get("Content-Type"))
+		if !strings.EqualFold(mediaType, opts.ContentType) {
+			return "", fmt.Errorf("expected content type %s, got %s", opts.ContentType, mediaType)
+		}
+	}
+	if opts.MaxSize > 0 && dl.size > opts.MaxSize {
+		return "", fmt.Errorf("download is %d bytes, which exceeds the limit of %d", dl.size, opts.MaxSize)
+	}
+
+	filename := filepath.Join(dir, downloadFilename(u, dl.header))
+
+	logger.Info("downloading file for import", zap.String("filename", filename), zap.Int64("size", dl.size))
+
+	// the download is only moved into place once it is complete and verified
+	file, err := dl.save(dir, opts.MaxSize, ItemData{})
+	if err != nil {
+		return "", err
+	}
+	info, err := file.Stat()
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("saving downloaded file: %w", err)
+	}
+
+	logger.Info("finished downloading file for import", zap.String("filename", filename), zap.Int64("size", info.Size()))
+
+	return filename, nil
+}
+
+// downloadFilename chooses a name for the file being downloaded from u, preferring
+// the one suggested by the server, since data sources may recognize files by name.
+func downloadFilename(u *url.URL, header http.Header) string {
+	var name string
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = path.Base(u.Path)
+	}
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." || name == string(filepath.Separator) {
+		name = "download"
+	}
+	return name
+}
@@ -0,0 +1,144 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive returns a zip file containing a file for each of names.
+func testArchive(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("contents of " + name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func serveTestArchive(t *testing.T, archive []byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="export.zip"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archive))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestImportFromURL(t *testing.T) {
+	tl := newTestTimeline(t)
+	srv := serveTestArchive(t, testArchive(t, "one.txt", "two.txt"))
+
+	var downloaded string
+	testFileImport = func(ctx context.Context, filenames []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		downloaded = filenames[0]
+		zr, err := zip.OpenReader(downloaded)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		ts := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+		for i, f := range zr.File {
+			itemChan <- &Graph{Item: testMessage(f.Name, ts.Add(time.Duration(i)*time.Minute))}
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	err := tl.ImportFromURL(ctx, srv.URL+"/download?id=1", ImportParameters{DataSourceName: testDataSourceName},
+		URLImportOptions{ContentType: "application/zip", MaxSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	if filepath.Base(downloaded) != "export.zip" {
+		t.Errorf("expected downloaded file to be named by the server, got %s", downloaded)
+	}
+	if _, err := os.Stat(downloaded); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected downloaded file to be removed after import, got: %v", err)
+	}
+	for _, id := range []string{"one.txt", "two.txt"} {
+		if _, err := tl.ItemByOriginalID(ctx, testDataSourceName, 0, id); err != nil {
+			t.Errorf("item %s was not imported: %v", id, err)
+		}
+	}
+
+	var mode, metaJSON string
+	err = tl.db.QueryRow(`SELECT mode, metadata FROM imports ORDER BY id DESC LIMIT 1`).Scan(&mode, &metaJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != string(importModeFile) {
+		t.Errorf("expected file import, got %s", mode)
+	}
+	var meta importMetadata
+	if err := json.Unmarshal([]byte(metaJSON), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.SourceURL != srv.URL+"/download?id=1" {
+		t.Errorf("expected source URL to be recorded, got %q", meta.SourceURL)
+	}
+}
+
+func TestImportFromURLChecksResponse(t *testing.T) {
+	tl := newTestTimeline(t)
+	srv := serveTestArchive(t, testArchive(t, "one.txt"))
+
+	testFileImport = func(context.Context, []string, chan<- *Graph, ListingOptions) error {
+		t.Error("import should not have started")
+		return nil
+	}
+
+	for _, tc := range []struct {
+		opts    URLImportOptions
+		wantErr string
+	}{
+		{opts: URLImportOptions{ContentType: "application/json"}, wantErr: "content type"},
+		{opts: URLImportOptions{MaxSize: 10}, wantErr: "exceeds the limit"},
+	} {
+		err := tl.ImportFromURL(context.Background(), srv.URL, ImportParameters{DataSourceName: testDataSourceName}, tc.opts)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("expected error containing %q, got: %v", tc.wantErr, err)
+		}
+	}
+}
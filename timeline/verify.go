@@ -0,0 +1,424 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// VerificationReport describes how the items given by a data source compare
+// to the items already stored in the timeline.
+type VerificationReport struct {
+	// number of items that are stored exactly as given
+	Matched int `json:"matched"`
+
+	// items that are stored, but differently than given
+	Mismatched []ChangedItem `json:"mismatched,omitempty"`
+
+	// items that were given but aren't stored
+	Missing []MissingItem `json:"missing,omitempty"`
+
+	// stored items from the data source (and account, and timeframe, if
+	// specified) that were not given
+	Extra []ItemRow `json:"extra,omitempty"`
+}
+
+// MissingItem identifies an item that was given by the data source during
+// verification but was not found in the timeline.
+type MissingItem struct {
+	OriginalID string    `json:"original_id,omitempty"`
+	Timestamp  time.Time `json:"timestamp,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+}
+
+// OK returns true if the timeline has exactly the items that were given.
+func (r VerificationReport) OK() bool {
+	return len(r.Mismatched) == 0 && len(r.Missing) == 0 && len(r.Extra) == 0
+}
+
+// VerifyImport runs the data source with the given parameters, but instead of
+// importing the items, it compares each one to the item already stored in the
+// timeline: by original ID if the item has one, otherwise by its content. This
+// is useful for validating that a re-export matches what is stored. Nothing is
+// written to the timeline.
+//
+// Only the fields that the data source gives are compared, since stored items
+// may have been enriched by other imports. Items that were modified after they
+// were imported (for example, by editing them) are likely to be mismatches.
+func (t *Timeline) VerifyImport(ctx context.Context, params ImportParameters) (VerificationReport, error) {
+	var report VerificationReport
+
+	if params.ResumeImportID > 0 {
+		return report, fmt.Errorf("cannot verify a resumed import")
+	}
+
+	ds, ok := lookupDataSource(params.DataSourceName)
+	if !ok {
+		return report, fmt.Errorf("unknown data source: %s", params.DataSourceName)
+	}
+	if len(params.Filenames) > 0 && ds.NewFileImporter == nil {
+		return report, fmt.Errorf("data source %s does not support importing from files", ds.Name)
+	}
+	if len(params.Filenames) == 0 && ds.NewAPIImporter == nil {
+		return report, fmt.Errorf("data source %s does not support importing via API", ds.Name)
+	}
+	for _, filename := range params.Filenames {
+		if _, err := checkPassphrase(filename, params.Passphrase); err != nil {
+			return report, err
+		}
+	}
+
+	if err := ds.validateOptions(params.DataSourceOptions); err != nil {
+		return report, err
+	}
+	dsOpt, err := ds.UnmarshalOptions(params.DataSourceOptions)
+	if err != nil {
+		return report, err
+	}
+
+	var acc Account
+	if params.AccountID > 0 {
+		acc, err = t.LoadAccount(ctx, params.AccountID)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	logger := Log.Named("verify").With(zap.String("data_source", ds.Name))
+	listOpt := ListingOptions{
+		Log:               logger,
+		Timeframe:         params.ProcessingOptions.Timeframe,
+		DataSourceOptions: dsOpt,
+		Passphrase:        params.Passphrase,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan *Graph)
+	done := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		if len(params.Filenames) > 0 {
+			filenames, fsys, err := params.fileImportInput(ctx, ds)
+			if err != nil {
+				done <- err
+				return
+			}
+			listOpt.FS = fsys
+			done <- ds.NewFileImporter().FileImport(ctx, filenames, ch, listOpt)
+		} else {
+			done <- ds.NewAPIImporter().APIImport(ctx, acc, ch, listOpt)
+		}
+	}()
+
+	// compare items as they come in, since their content might
+	// not be readable after the data source moves on
+	v := verifier{
+		tl:              t,
+		ds:              ds,
+		inlineThreshold: maxTextSizeForDB,
+		report:          &report,
+		seen:            make(map[int64]struct{}),
+	}
+	if threshold := params.ProcessingOptions.InlineThresholdBytes; threshold > 0 {
+		v.inlineThreshold = threshold
+	}
+	for g := range ch {
+		if err != nil {
+			continue // keep draining so the data source can finish
+		}
+		if err = v.verifyGraph(ctx, g, make(map[*Graph]struct{})); err != nil {
+			cancel()
+		}
+	}
+	if dsErr := <-done; err == nil && dsErr != nil {
+		err = fmt.Errorf("%s: verifying import: %w", ds.Name, dsErr)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	report.Extra, err = v.extraItems(ctx, params)
+	if err != nil {
+		return report, err
+	}
+
+	logger.Info("verified import",
+		zap.Int("matched", report.Matched),
+		zap.Int("mismatched", len(report.Mismatched)),
+		zap.Int("missing", len(report.Missing)),
+		zap.Int("extra", len(report.Extra)))
+
+	return report, nil
+}
+
+// verifier compares items given by a data source to the stored items.
+type verifier struct {
+	tl              *Timeline
+	ds              DataSource
+	inlineThreshold int // text smaller than this is stored in the DB
+	report          *VerificationReport
+	seen            map[int64]struct{} // row IDs of stored items that were given
+}
+
+// verifyGraph verifies the item of the graph and of the graphs connected to it.
+func (v verifier) verifyGraph(ctx context.Context, g *Graph, visited map[*Graph]struct{}) error {
+	if g == nil {
+		return nil
+	}
+	if _, ok := visited[g]; ok {
+		return nil
+	}
+	visited[g] = struct{}{}
+
+	if g.Item != nil {
+		if err := v.verifyItem(ctx, g.Item); err != nil {
+			return err
+		}
+	}
+	for _, edge := range g.Edges {
+		if err := v.verifyGraph(ctx, edge.From, visited); err != nil {
+			return err
+		}
+		if err := v.verifyGraph(ctx, edge.To, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v verifier) verifyItem(ctx context.Context, it *Item) error {
+	if err := v.loadContent(ctx, it); err != nil {
+		return err
+	}
+
+	var stored []ItemRow
+	var err error
+	if it.ID != "" {
+		stored, err = v.tl.itemsWhere(ctx, `data_source_name=? AND original_id=? AND deleted IS NULL`, v.ds.Name, it.ID)
+	} else if it.makeContentHash(); len(it.contentHash) > 0 {
+		stored, err = v.tl.itemsWhere(ctx, `data_source_name=? AND initial_content_hash=? AND deleted IS NULL`, v.ds.Name, it.contentHash)
+	}
+	if err != nil {
+		return err
+	}
+	if len(stored) == 0 {
+		v.report.Missing = append(v.report.Missing, MissingItem{
+			OriginalID: it.ID,
+			Timestamp:  it.Timestamp,
+			Filename:   it.Content.Filename,
+		})
+		return nil
+	}
+
+	ir := stored[0]
+	v.seen[ir.ID] = struct{}{}
+	if fields := mismatchedFields(it, ir); len(fields) > 0 {
+		v.report.Mismatched = append(v.report.Mismatched, ChangedItem{Item: ir, Fields: fields})
+	} else {
+		v.report.Matched++
+	}
+	return nil
+}
+
+// loadContent reads the item's content, if any, into either its text (if it is small
+// enough to be stored in the database) or the hash of its data file, like the
+// processor does when importing it.
+func (v verifier) loadContent(ctx context.Context, it *Item) error {
+	if it.Content.Data == nil {
+		return nil
+	}
+	rc, err := it.Content.Data(ctx)
+	if err != nil {
+		return fmt.Errorf("opening item content: %v", err)
+	}
+	if rc == nil {
+		return nil
+	}
+	defer rc.Close()
+
+	peek := make([]byte, v.inlineThreshold)
+	n, err := io.ReadFull(rc, peek)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("reading item content: %v", err)
+	}
+	if n == 0 {
+		return nil
+	}
+	if n < len(peek) && it.Content.hasPlainTextMediaType() {
+		text := strings.TrimSpace(string(peek[:n]))
+		it.dataText = &text
+		return nil
+	}
+	h := newHash()
+	if _, err := io.Copy(h, io.MultiReader(bytes.NewReader(peek[:n]), rc)); err != nil {
+		return fmt.Errorf("hashing item content: %v", err)
+	}
+	it.dataFileHash = h.Sum(nil)
+	return nil
+}
+
+// mismatchedFields returns the names of the fields given by the item that
+// differ from the stored item, using the same names as ImportDiff.
+func mismatchedFields(it *Item, ir ItemRow) []string {
+	var fields []string
+	differs := func(field string, same bool) {
+		if !same {
+			fields = append(fields, field)
+		}
+	}
+	sameTime := func(given time.Time, stored *time.Time) bool {
+		return stored != nil && given.UnixMilli() == stored.UnixMilli()
+	}
+	sameString := func(given string, stored *string) bool {
+		return stored != nil && given == *stored
+	}
+	sameFloat := func(given, stored *float64) bool {
+		return given == nil || (stored != nil && *given == *stored)
+	}
+
+	if it.Classification.Name != "" {
+		differs("classification", sameString(it.Classification.Name, ir.Classification))
+	}
+	if !it.Timestamp.IsZero() {
+		// a clamped timestamp is stored as it was given in original_timestamp
+		differs("timestamp", sameTime(it.Timestamp, ir.Timestamp) || sameTime(it.Timestamp, ir.OriginalTimestamp))
+	}
+	if !it.Timespan.IsZero() {
+		differs("timespan", sameTime(it.Timespan, ir.Timespan))
+	}
+	if !it.Timeframe.IsZero() {
+		differs("timeframe", sameTime(it.Timeframe, ir.Timeframe))
+	}
+	if it.OriginalLocation != "" {
+		differs("original_location", sameString(it.OriginalLocation, ir.OriginalLocation))
+	}
+	if it.Content.Filename != "" {
+		differs("filename", sameString(it.Content.Filename, ir.Filename))
+	}
+	if it.Content.MediaType != "" {
+		differs("data_type", sameString(it.Content.MediaType, ir.DataType))
+	}
+	if it.dataText != nil {
+		differs("data_text", sameString(*it.dataText, ir.DataText))
+	} else if it.dataFileHash != nil {
+		differs("data_file", bytes.Equal(it.dataFileHash, ir.DataHash))
+	}
+	if !it.Location.IsEmpty() {
+		differs("location", sameFloat(it.Location.Latitude, ir.Latitude) &&
+			sameFloat(it.Location.Longitude, ir.Longitude) &&
+			sameFloat(it.Location.Altitude, ir.Altitude))
+	}
+	if it.Metadata.Clean(); len(it.Metadata) > 0 {
+		differs("metadata", sameMetadata(it.Metadata, ir.Metadata))
+	}
+
+	return fields
+}
+
+// sameMetadata returns true if the stored metadata has the same values as the given
+// metadata; the given metadata is round-tripped through JSON so that its values have
+// the same types as the stored ones.
+func sameMetadata(given Metadata, stored json.RawMessage) bool {
+	if len(stored) == 0 {
+		return false
+	}
+	encoded, err := json.Marshal(given)
+	if err != nil {
+		return false
+	}
+	var a, b map[string]any
+	if err := json.Unmarshal(encoded, &a); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(stored, &b); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// extraItems returns the stored items that could have been given by the data
+// source with the given parameters, but weren't.
+func (v verifier) extraItems(ctx context.Context, params ImportParameters) ([]ItemRow, error) {
+	query := `SELECT id FROM extended_items WHERE data_source_name=? AND deleted IS NULL`
+	args := []any{v.ds.Name}
+	if params.AccountID > 0 {
+		query += ` AND import_id IN (SELECT id FROM imports WHERE account_id=?)`
+		args = append(args, params.AccountID)
+	}
+	if since := params.ProcessingOptions.Timeframe.Since; since != nil {
+		query += ` AND timestamp >= ?`
+		args = append(args, since.UnixMilli())
+	}
+	if until := params.ProcessingOptions.Timeframe.Until; until != nil {
+		query += ` AND timestamp < ?`
+		args = append(args, until.UnixMilli())
+	}
+
+	v.tl.dbMu.RLock()
+	rows, err := v.tl.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		v.tl.dbMu.RUnlock()
+		return nil, fmt.Errorf("querying stored items: %v", err)
+	}
+	var extraIDs []int64
+	for rows.Next() {
+		var rowID int64
+		if err := rows.Scan(&rowID); err != nil {
+			rows.Close()
+			v.tl.dbMu.RUnlock()
+			return nil, fmt.Errorf("scanning item row ID: %v", err)
+		}
+		if _, ok := v.seen[rowID]; !ok {
+			extraIDs = append(extraIDs, rowID)
+		}
+	}
+	rows.Close()
+	err = rows.Err()
+	v.tl.dbMu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("iterating stored items: %v", err)
+	}
+
+	// load the items in chunks to stay within the limit of query parameters
+	const chunkSize = 500
+	var extra []ItemRow
+	for len(extraIDs) > 0 {
+		chunk := extraIDs[:min(chunkSize, len(extraIDs))]
+		extraIDs = extraIDs[len(chunk):]
+		array, args := sqlArray(chunk)
+		items, err := v.tl.itemsWhere(ctx, "items.id IN "+array, args...)
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, items...)
+	}
+
+	return extra, nil
+}
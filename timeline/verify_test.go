@@ -0,0 +1,104 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestVerifyImport(t *testing.T) {
+	tl := newTestTimeline(t)
+	ctx := context.Background()
+	ts := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	fixture := func() []*Item {
+		return []*Item{
+			testMessage("m1", ts),
+			testMessage("m2", ts.Add(time.Minute)),
+			testFileItem("f1", ts.Add(2*time.Minute)),
+		}
+	}
+	importTestItems(t, tl, fixture()...)
+
+	var itemsBefore int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&itemsBefore); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(items ...*Item) VerificationReport {
+		t.Helper()
+		testFileImport = func(ctx context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+			for _, it := range items {
+				itemChan <- &Graph{Item: it}
+			}
+			return nil
+		}
+		report, err := tl.VerifyImport(ctx, ImportParameters{
+			DataSourceName: testDataSourceName,
+			Filenames:      []string{"test"},
+		})
+		if err != nil {
+			t.Fatalf("verifying import: %v", err)
+		}
+		return report
+	}
+
+	// the same export matches exactly
+	report := verify(fixture()...)
+	if !report.OK() || report.Matched != 3 {
+		t.Errorf("expected all 3 items to match, got %+v", report)
+	}
+
+	// a re-export that changed one item, dropped another, and added a new one
+	changed := fixture()
+	changed[0].Timestamp = ts.Add(time.Hour)
+	changed[2].Content.Data = ByteData([]byte("different contents"))
+	report = verify(changed[0], changed[2], testMessage("new", ts))
+	if report.Matched != 0 {
+		t.Errorf("expected no matches, got %d", report.Matched)
+	}
+	mismatches := make(map[string][]string)
+	for _, m := range report.Mismatched {
+		mismatches[*m.Item.OriginalID] = m.Fields
+	}
+	if !slices.Equal(mismatches["m1"], []string{"timestamp"}) || !slices.Equal(mismatches["f1"], []string{"data_file"}) || len(mismatches) != 2 {
+		t.Errorf("unexpected mismatches: %v", mismatches)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].OriginalID != "new" {
+		t.Errorf("expected item 'new' to be missing, got %+v", report.Missing)
+	}
+	if len(report.Extra) != 1 || *report.Extra[0].OriginalID != "m2" {
+		t.Errorf("expected item 'm2' to be extra, got %+v", report.Extra)
+	}
+
+	// verifying never changes the timeline
+	var itemsAfter, imports int
+	if err := tl.db.QueryRow(`SELECT count() FROM items`).Scan(&itemsAfter); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.db.QueryRow(`SELECT count() FROM imports`).Scan(&imports); err != nil {
+		t.Fatal(err)
+	}
+	if itemsAfter != itemsBefore || imports != 1 {
+		t.Errorf("expected timeline to be unchanged, got %d items (was %d) and %d imports", itemsAfter, itemsBefore, imports)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Visibility levels of items.
+const (
+	VisibilityPublic  = "public"  // anyone may see the item
+	VisibilityPrivate = "private" // only the owner of the timeline may see the item
+	VisibilityShared  = "shared"  // the owner and the viewers in SharedWith may see the item
+)
+
+// Visibility describes who may see an item. It is merely data that
+// is enforced when searching with a viewer identity (see
+// ItemSearchParams.Viewer); authenticating viewers is up to the
+// application.
+type Visibility struct {
+	// One of the Visibility* constants.
+	Level string `json:"level"`
+
+	// The identities of viewers the item is shared with,
+	// if Level is VisibilityShared.
+	SharedWith []string `json:"shared_with,omitempty"`
+}
+
+func (v Visibility) validate() error {
+	switch v.Level {
+	case VisibilityPublic, VisibilityPrivate:
+		if len(v.SharedWith) > 0 {
+			return fmt.Errorf("visibility %s cannot be shared with viewers", v.Level)
+		}
+	case VisibilityShared:
+	default:
+		return fmt.Errorf("unrecognized visibility: %s", v.Level)
+	}
+	return nil
+}
+
+// itemVisibility returns the visibility to apply to the item:
+// its own, or the import's default visibility if it has none.
+func (p *processor) itemVisibility(it *Item) *Visibility {
+	if it.Visibility != nil {
+		return it.Visibility
+	}
+	return p.params.ProcessingOptions.DefaultVisibility
+}
+
+// storeItemViewers adds the viewers that the item is shared with.
+func storeItemViewers(ctx context.Context, tx *sql.Tx, itemRowID int64, viewers []string) error {
+	for _, viewer := range viewers {
+		_, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO item_viewers (item_id, viewer) VALUES (?, ?)`, itemRowID, viewer)
+		if err != nil {
+			return fmt.Errorf("sharing item %d with %s: %v", itemRowID, viewer, err)
+		}
+	}
+	return nil
+}
+
+// visibleTo returns whether the item row may be seen by viewer.
+// It mirrors the filter applied by Search when a viewer is set.
+func visibleTo(ctx context.Context, tx *sql.Tx, ir ItemRow, viewer string) (bool, error) {
+	if ir.Visibility == nil || *ir.Visibility == VisibilityPublic {
+		return true, nil
+	}
+	if *ir.Visibility != VisibilityShared {
+		return false, nil
+	}
+	var shared bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM item_viewers WHERE item_id=? AND viewer=?)`,
+		ir.ID, viewer).Scan(&shared)
+	if err != nil {
+		return false, fmt.Errorf("checking viewers of item %d: %v", ir.ID, err)
+	}
+	return shared, nil
+}
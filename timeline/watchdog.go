@@ -0,0 +1,153 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StalledImportError is the error returned when an import is aborted by
+// the watchdog because it has not made any progress for too long.
+type StalledImportError struct {
+	ImportID     int64         `json:"import_id"`
+	StalledFor   time.Duration `json:"stalled_for"`
+	WorkerPhases []string      `json:"worker_phases"`
+}
+
+func (e StalledImportError) Error() string {
+	return fmt.Sprintf("import %d stalled: no progress for %s (worker phases: %v)",
+		e.ImportID, e.StalledFor, e.WorkerPhases)
+}
+
+// processingPhase describes what a processing worker is currently doing.
+type processingPhase int32
+
+const (
+	phaseIdle     processingPhase = iota // waiting for graphs or filling a batch
+	phaseInsert                          // phase 1: inserting items into the DB
+	phaseDownload                        // phase 2: downloading data files
+	phaseFinalize                        // phase 3: updating the DB with data file info
+)
+
+func (ph processingPhase) String() string {
+	switch ph {
+	case phaseIdle:
+		return "idle"
+	case phaseInsert:
+		return "insert"
+	case phaseDownload:
+		return "download"
+	case phaseFinalize:
+		return "finalize"
+	}
+	return fmt.Sprintf("unknown(%d)", ph)
+}
+
+// setPhase records which phase the given worker is in.
+func (p *processor) setPhase(worker int, ph processingPhase) {
+	if worker < len(p.workerPhases) {
+		atomic.StoreInt32(&p.workerPhases[worker], int32(ph))
+	}
+}
+
+// phases returns the current phase of each worker.
+func (p *processor) phases() []string {
+	phases := make([]string, len(p.workerPhases))
+	for i := range p.workerPhases {
+		phases[i] = processingPhase(atomic.LoadInt32(&p.workerPhases[i])).String()
+	}
+	return phases
+}
+
+// progressMarker returns a value that changes whenever the import makes progress,
+// i.e. when items or entity-only graphs are processed or a checkpoint is saved.
+func (p *processor) progressMarker() int64 {
+	marker := atomic.LoadInt64(p.itemCount) + atomic.LoadInt64(p.checkpointCount)
+	if p.entityCount != nil {
+		marker += atomic.LoadInt64(p.entityCount)
+	}
+	return marker
+}
+
+// minWatchdogInterval is the shortest interval at which the watchdog checks for progress.
+const minWatchdogInterval = time.Millisecond
+
+// watchdog monitors the import for progress. If nothing is processed and no
+// checkpoint is saved for the timeout duration, a warning is logged with details
+// about what the workers are doing; and if the WatchdogAbort processing option
+// is enabled, the import is canceled with a StalledImportError as the cause.
+// It returns when ctx is done.
+func (p *processor) watchdog(ctx context.Context, timeout time.Duration, abort context.CancelCauseFunc) {
+	// check a few times per timeout, but not so often (or, for timeouts of a few
+	// nanoseconds, with an interval of 0, which panics) that it busy-loops
+	ticker := time.NewTicker(max(timeout/4, minWatchdogInterval))
+	defer ticker.Stop()
+
+	lastMarker := p.progressMarker()
+	lastProgress := time.Now()
+	var warned bool
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if marker := p.progressMarker(); marker != lastMarker {
+			lastMarker = marker
+			lastProgress = time.Now()
+			warned = false
+			continue
+		}
+
+		stalledFor := time.Since(lastProgress)
+		if stalledFor < timeout || warned {
+			continue
+		}
+		warned = true
+
+		stack := make([]byte, 64*1024)
+		stack = stack[:runtime.Stack(stack, true)]
+
+		p.log.Warn("import appears to be stalled",
+			zap.Int64("import_id", p.impRow.id),
+			zap.Duration("stalled_for", stalledFor),
+			zap.Strings("worker_phases", p.phases()),
+			zap.Int("queue_depth", len(p.graphs)),
+			zap.Int64("items_processed", atomic.LoadInt64(p.itemCount)),
+			zap.Int("goroutines", runtime.NumGoroutine()),
+			zap.ByteString("goroutine_dump", stack))
+
+		if p.params.ProcessingOptions.WatchdogAbort {
+			abort(StalledImportError{
+				ImportID:     p.impRow.id,
+				StalledFor:   stalledFor,
+				WorkerPhases: p.phases(),
+			})
+			return
+		}
+	}
+}
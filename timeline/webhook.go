@@ -0,0 +1,232 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Webhook configures HTTP notifications of the lifecycle of an import:
+// a JSON WebhookPayload is POSTed to the URL when the import starts and
+// when it finishes or fails. Delivery never affects the import.
+type Webhook struct {
+	URL string `json:"url"`
+
+	// If set, the payload is signed with HMAC-SHA256 using this secret,
+	// and the hex-encoded signature is sent in the X-Timelinize-Signature
+	// header as "sha256=<signature>". Like a passphrase, it is redacted
+	// when printed or encoded.
+	Secret Passphrase `json:"secret,omitempty"`
+
+	// How many times to try delivering each notification. Default: 5.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// How long to wait before the first retry; the wait doubles after
+	// each failed attempt, up to a minute. Default: 1s.
+	Backoff time.Duration `json:"backoff,omitempty"`
+}
+
+func (wh Webhook) validate() error {
+	u, err := url.Parse(wh.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL must be an absolute HTTP(S) URL: %s", u.Redacted())
+	}
+	if wh.MaxAttempts < 0 {
+		return fmt.Errorf("webhook max attempts cannot be negative: %d", wh.MaxAttempts)
+	}
+	if wh.Backoff < 0 {
+		return fmt.Errorf("webhook backoff cannot be negative: %s", wh.Backoff)
+	}
+	return nil
+}
+
+// WebhookEvent is the kind of lifecycle transition a webhook is notified of.
+type WebhookEvent string
+
+const (
+	WebhookImportStarted  WebhookEvent = "import-started"
+	WebhookImportFinished WebhookEvent = "import-finished"
+	WebhookImportFailed   WebhookEvent = "import-failed"
+)
+
+// WebhookPayload is the body of a webhook notification.
+type WebhookPayload struct {
+	Event          WebhookEvent `json:"event"`
+	JobID          string       `json:"job_id,omitempty"`
+	ImportID       int64        `json:"import_id"`
+	DataSourceName string       `json:"data_source_name"`
+	Status         string       `json:"status"`          // status of the import (e.g. "started", "ok", "err")
+	Error          string       `json:"error,omitempty"` // only for failed imports
+	Counts         ImportStatus `json:"counts"`
+	Time           time.Time    `json:"time"`
+}
+
+const (
+	// webhookSignatureHeader is the header that carries the signature of the payload.
+	webhookSignatureHeader = "X-Timelinize-Signature"
+
+	// webhookEventHeader is the header that carries the event of the payload.
+	webhookEventHeader = "X-Timelinize-Event"
+)
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// webhookNotifier delivers the notifications of an import in order, in the background.
+type webhookNotifier struct {
+	webhook  Webhook
+	payloads chan WebhookPayload
+	logger   *zap.Logger
+}
+
+// startWebhook starts delivering notifications to wh until close is called.
+func (tl *Timeline) startWebhook(wh Webhook, logger *zap.Logger) *webhookNotifier {
+	wn := &webhookNotifier{
+		webhook:  wh,
+		payloads: make(chan WebhookPayload, 4),
+		logger:   logger.Named("webhook"),
+	}
+	go func() {
+		for payload := range wn.payloads {
+			if err := wn.deliver(tl.ctx, payload); err != nil {
+				wn.logger.Error("unable to deliver webhook notification",
+					zap.String("event", string(payload.Event)),
+					zap.Int64("import_id", payload.ImportID),
+					zap.Error(err))
+			}
+		}
+	}()
+	return wn
+}
+
+// notify queues the payload for delivery; it never blocks.
+func (wn *webhookNotifier) notify(payload WebhookPayload) {
+	select {
+	case wn.payloads <- payload:
+	default:
+		wn.logger.Warn("too many pending webhook notifications; dropping",
+			zap.String("event", string(payload.Event)),
+			zap.Int64("import_id", payload.ImportID))
+	}
+}
+
+// close stops accepting notifications; queued ones are still delivered.
+func (wn *webhookNotifier) close() { close(wn.payloads) }
+
+// deliver POSTs the payload, retrying with backoff if it fails.
+func (wn *webhookNotifier) deliver(ctx context.Context, payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding payload: %v", err)
+	}
+
+	attempts := wn.webhook.MaxAttempts
+	if attempts == 0 {
+		attempts = 5
+	}
+	backoff := wn.webhook.Backoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = wn.post(ctx, payload.Event, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		wn.logger.Warn("webhook notification failed; retrying",
+			zap.String("event", string(payload.Event)),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+func (wn *webhookNotifier) post(ctx context.Context, event WebhookEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, string(event))
+	if wn.webhook.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookPayload(body, wn.webhook.Secret))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhookPayload returns the hex-encoded HMAC-SHA256 of body using secret.
+func signWebhookPayload(body []byte, secret Passphrase) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookPayload returns the payload for a lifecycle event of the import.
+func (p *processor) webhookPayload(event WebhookEvent, err error) WebhookPayload {
+	payload := WebhookPayload{
+		Event:          event,
+		JobID:          p.params.JobID,
+		ImportID:       p.impRow.id,
+		DataSourceName: p.ds.Name,
+		Status:         string(p.impRow.status),
+		Counts:         p.status(),
+		Time:           time.Now(),
+	}
+	if event == WebhookImportStarted {
+		payload.Status = importStatusStarted
+	}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+	return payload
+}
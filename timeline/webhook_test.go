@@ -0,0 +1,145 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifiesImportLifecycle(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	type delivery struct {
+		payload   WebhookPayload
+		signature string
+		valid     bool
+	}
+	deliveries := make(chan delivery, 10)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt to exercise retries
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var d delivery
+		if err := json.Unmarshal(body, &d.payload); err != nil {
+			t.Errorf("decoding payload: %v", err)
+		}
+		d.signature = r.Header.Get(webhookSignatureHeader)
+		d.valid = d.signature == "sha256="+signWebhookPayload(body, "s3cret")
+		if r.Header.Get(webhookEventHeader) != string(d.payload.Event) {
+			t.Errorf("event header %q does not match payload event %q", r.Header.Get(webhookEventHeader), d.payload.Event)
+		}
+		deliveries <- d
+	}))
+	defer srv.Close()
+
+	webhook := &Webhook{URL: srv.URL, Secret: "s3cret", Backoff: time.Millisecond}
+	testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("a", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test"},
+		JobID:          "job1",
+		Webhook:        webhook,
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	next := func() delivery {
+		t.Helper()
+		select {
+		case d := <-deliveries:
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhook")
+			return delivery{}
+		}
+	}
+
+	started := next()
+	if started.payload.Event != WebhookImportStarted || started.payload.Status != importStatusStarted ||
+		started.payload.JobID != "job1" || started.payload.DataSourceName != testDataSourceName || started.payload.ImportID == 0 {
+		t.Errorf("unexpected start payload: %+v", started.payload)
+	}
+	finished := next()
+	if finished.payload.Event != WebhookImportFinished || finished.payload.Status != importStatusSuccess ||
+		finished.payload.ImportID != started.payload.ImportID || finished.payload.Counts.NewItemCount != 1 {
+		t.Errorf("unexpected finish payload: %+v", finished.payload)
+	}
+	for _, d := range []delivery{started, finished} {
+		if !d.valid {
+			t.Errorf("invalid signature for %s: %s", d.payload.Event, d.signature)
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("expected 3 requests (including a retry), got %d", n)
+	}
+
+	// a failed import is reported as such
+	testFileImport = func(context.Context, []string, chan<- *Graph, ListingOptions) error {
+		return errors.New("corrupt export")
+	}
+	err = tl.Import(context.Background(), ImportParameters{
+		DataSourceName: testDataSourceName,
+		Filenames:      []string{"test2"},
+		Webhook:        webhook,
+	})
+	if err == nil {
+		t.Fatal("expected import to fail")
+	}
+	if d := next(); d.payload.Event != WebhookImportStarted {
+		t.Errorf("expected start of failed import, got: %+v", d.payload)
+	}
+	if d := next(); d.payload.Event != WebhookImportFailed || d.payload.Status != importStatusError || d.payload.Error == "" {
+		t.Errorf("expected failure payload, got: %+v", d.payload)
+	}
+}
+
+func TestWebhookValidation(t *testing.T) {
+	for i, wh := range []Webhook{
+		{URL: "ftp://example.com/hook"},
+		{URL: "/relative"},
+		{URL: "https://example.com/hook", MaxAttempts: -1},
+	} {
+		if err := wh.validate(); err == nil {
+			t.Errorf("test %d: expected invalid webhook %+v to be rejected", i, wh)
+		}
+	}
+	if err := (Webhook{URL: "https://example.com/hook"}).validate(); err != nil {
+		t.Errorf("expected valid webhook: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import "time"
+
+// defaultZeroTimestampThreshold is how close to the Unix epoch a timestamp
+// must be to be considered unknown, if no threshold is configured. It is
+// wide enough to cover an epoch of 0 that was shifted by any time zone.
+const defaultZeroTimestampThreshold = 24 * time.Hour
+
+// nearEpoch returns true if ts is within threshold of the Unix epoch. If
+// threshold is 0, the default is used.
+func nearEpoch(ts time.Time, threshold time.Duration) bool {
+	if ts.IsZero() {
+		return false
+	}
+	if threshold == 0 {
+		threshold = defaultZeroTimestampThreshold
+	}
+	d := ts.Sub(time.Unix(0, 0))
+	return d <= threshold && d >= -threshold
+}
+
+// clearEpochTimestamps treats times of it that are at or near the Unix epoch
+// as unknown, since many sources emit 0 when they don't know the time. The
+// ending time of a timespan is also dropped when the timestamp is, since it
+// can't be after an unknown start.
+func clearEpochTimestamps(it *Item, threshold time.Duration) {
+	if nearEpoch(it.Timestamp, threshold) {
+		it.Timestamp = time.Time{}
+		it.Timespan = time.Time{}
+	}
+	if nearEpoch(it.Timespan, threshold) {
+		it.Timespan = time.Time{}
+	}
+	if nearEpoch(it.Timeframe, threshold) {
+		it.Timeframe = time.Time{}
+	}
+}
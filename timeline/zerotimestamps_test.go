@@ -0,0 +1,80 @@
+/*
+	Timelinize
+	Copyright (c) 2013 Matthew Holt
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEpochTimestampTreatedAsUnknown(t *testing.T) {
+	tl := newTestTimeline(t)
+
+	testFileImport = func(_ context.Context, _ []string, itemChan chan<- *Graph, _ ListingOptions) error {
+		itemChan <- &Graph{Item: testMessage("epoch", time.Unix(0, 0))}
+		itemChan <- &Graph{Item: testMessage("shifted_epoch", time.Unix(0, 0).In(time.FixedZone("", -5*3600)).Add(-time.Hour))}
+		itemChan <- &Graph{Item: testMessage("real", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))}
+		return nil
+	}
+	err := tl.Import(context.Background(), ImportParameters{
+		DataSourceName:    testDataSourceName,
+		Filenames:         []string{"test"},
+		ProcessingOptions: ProcessingOptions{ZeroTimestampAsUnknown: true},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	for _, id := range []string{"epoch", "shifted_epoch"} {
+		var ts *int64
+		if err := tl.db.QueryRow(`SELECT timestamp FROM items WHERE original_id=?`, id).Scan(&ts); err != nil {
+			t.Fatalf("querying item %s: %v", id, err)
+		}
+		if ts != nil {
+			t.Errorf("expected item %s to have unknown timestamp, got %d", id, *ts)
+		}
+	}
+	var ts *int64
+	if err := tl.db.QueryRow(`SELECT timestamp FROM items WHERE original_id='real'`).Scan(&ts); err != nil {
+		t.Fatal(err)
+	}
+	if ts == nil {
+		t.Error("expected real timestamp to be kept")
+	}
+}
+
+func TestNearEpoch(t *testing.T) {
+	for i, tc := range []struct {
+		ts        time.Time
+		threshold time.Duration
+		expect    bool
+	}{
+		{ts: time.Time{}, expect: false},
+		{ts: time.Unix(0, 0), expect: true},
+		{ts: time.Unix(-3600, 0), expect: true},
+		{ts: time.Unix(3*24*3600, 0), expect: false},
+		{ts: time.Unix(3*24*3600, 0), threshold: 7 * 24 * time.Hour, expect: true},
+		{ts: time.Unix(3600, 0), threshold: time.Minute, expect: false},
+	} {
+		if actual := nearEpoch(tc.ts, tc.threshold); actual != tc.expect {
+			t.Errorf("test %d: expected %t for %s (threshold=%s), got %t", i, tc.expect, tc.ts, tc.threshold, actual)
+		}
+	}
+}
@@ -258,6 +258,78 @@ func (a App) GetEntity(repoID string, entityID int64) (timeline.Entity, error) {
 	return tl.LoadEntity(entityID)
 }
 
+func (a App) ListImports(repoID string, params timeline.ListImportsParams) ([]timeline.ImportInfo, error) {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return tl.ListImports(a.ctx, params)
+}
+
+func (a App) CountItemsByImport(repoID string, importIDs []int64) (map[int64]timeline.ImportItemCounts, error) {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return tl.CountItemsByImport(a.ctx, importIDs...)
+}
+
+func (a App) ImportDebugBundle(repoID string, importID int64) (timeline.ImportDebugBundle, error) {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return timeline.ImportDebugBundle{}, err
+	}
+	return tl.ExportImportDebugBundle(a.ctx, importID)
+}
+
+func (a App) ItemDataFileInfo(repoID string, itemID int64) (timeline.ItemDataFileInfo, error) {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return timeline.ItemDataFileInfo{}, err
+	}
+	return tl.ItemDataFileInfo(context.TODO(), itemID)
+}
+
+func (a App) ItemRepresentations(repoID string, itemID int64) ([]timeline.Representation, error) {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return tl.ItemRepresentations(a.ctx, itemID)
+}
+
+func (a App) ItemTimeline(repoID string, entityID int64, params timeline.ItemTimelineParams) ([]timeline.EntityItem, error) {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return tl.ItemTimeline(a.ctx, entityID, params)
+}
+
+func (a App) RecentActivity(repoID string, limit int, healThumbnails bool) ([]timeline.ActivityItem, error) {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return tl.RecentActivity(a.ctx, limit, healThumbnails)
+}
+
+func (a App) SetFavorite(repoID string, itemID int64, favorite bool) error {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return err
+	}
+	return tl.SetFavorite(a.ctx, itemID, favorite)
+}
+
+func (a App) Thread(repoID string, rootItemID int64) (*timeline.ThreadNode, error) {
+	tl, err := getOpenTimeline(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return tl.Thread(a.ctx, rootItemID)
+}
+
 func (a App) AddAccount(repoID string, dataSourceID string, auth bool, dsOpt json.RawMessage) (timeline.Account, error) {
 	tl, err := getOpenTimeline(repoID)
 	if err != nil {
@@ -555,6 +627,34 @@ func (a *App) SearchItems(params timeline.ItemSearchParams) (timeline.SearchResu
 	return results, nil
 }
 
+func (a *App) TimeHistogram(params timeline.ItemSearchParams, interval timeline.HistogramInterval) ([]timeline.HistogramBucket, error) {
+	tl, err := getOpenTimeline(params.Repo)
+	if err != nil {
+		return nil, err
+	}
+	return tl.TimeHistogram(a.ctx, params, interval)
+}
+
+func (a *App) ShiftTimestamps(params timeline.ItemSearchParams, delta time.Duration) (int, error) {
+	tl, err := getOpenTimeline(params.Repo)
+	if err != nil {
+		return 0, err
+	}
+	return tl.ShiftTimestamps(a.ctx, params, delta)
+}
+
+func (a *App) SetTimezone(params timeline.ItemSearchParams, timeZone string) (int, error) {
+	tl, err := getOpenTimeline(params.Repo)
+	if err != nil {
+		return 0, err
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time zone: %v", err)
+	}
+	return tl.SetTimezone(a.ctx, params, loc)
+}
+
 // TODO: all of these methods should be cancelable by the browser... somehow
 
 func (a *App) SearchEntities(params timeline.EntitySearchParams) ([]timeline.Entity, error) {
@@ -681,6 +781,14 @@ func (a App) LoadConversation(params timeline.ItemSearchParams) (timeline.Search
 	return convo, nil
 }
 
+func (a App) ValidateDataSourceOptions(repo, dataSourceName string, dsOpt json.RawMessage) error {
+	tl, err := getOpenTimeline(repo)
+	if err != nil {
+		return err
+	}
+	return tl.ValidateDataSourceOptions(dataSourceName, dsOpt)
+}
+
 func (a App) MergeEntities(repo string, base int64, others []int64) error {
 	tl, err := getOpenTimeline(repo)
 	if err != nil {
@@ -689,6 +797,22 @@ func (a App) MergeEntities(repo string, base int64, others []int64) error {
 	return tl.MergeEntities(a.ctx, base, others)
 }
 
+func (a App) FindDuplicateItems(repo string, opts timeline.FindDuplicatesOptions) ([]timeline.DuplicateCluster, error) {
+	tl, err := getOpenTimeline(repo)
+	if err != nil {
+		return nil, err
+	}
+	return tl.FindDuplicateItems(a.ctx, opts)
+}
+
+func (a App) MergeItems(repo string, cluster timeline.DuplicateCluster, keepID int64) error {
+	tl, err := getOpenTimeline(repo)
+	if err != nil {
+		return err
+	}
+	return tl.MergeItems(a.ctx, cluster, keepID)
+}
+
 func (a App) DeleteItems(repo string, itemRowIDs []int64, options timeline.DeleteOptions) error {
 	tl, err := getOpenTimeline(repo)
 	if err != nil {
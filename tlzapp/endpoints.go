@@ -97,6 +97,12 @@ func (a *App) registerCommands() {
 			Method:  http.MethodGet,
 			Help:    "Returns a list of root paths for a file picker.",
 		},
+		"find-duplicate-items": {
+			Handler: a.server.handleFindDuplicateItems,
+			Method:  http.MethodPost,
+			Payload: findDuplicateItemsPayload{},
+			Help:    "Returns clusters of items that are likely duplicates, without changing anything.",
+		},
 		"get-entity": {
 			Handler: a.server.handleGetEntity,
 			Method:  http.MethodPost,
@@ -109,12 +115,48 @@ func (a *App) registerCommands() {
 			Payload: ImportParameters{},
 			Help:    "Starts an import job.",
 		},
+		"import-debug-bundle": {
+			Handler: a.server.handleImportDebugBundle,
+			Method:  http.MethodPost,
+			Payload: importDebugBundlePayload{},
+			Help:    "Returns information for troubleshooting an import, with secrets redacted, that is safe to share.",
+		},
+		"import-item-counts": {
+			Handler: a.server.handleImportItemCounts,
+			Method:  http.MethodPost,
+			Payload: importItemCountsPayload{},
+			Help:    "Returns how many items each import added, modified, and gave (all imports if none are specified).",
+		},
+		"imports": {
+			Handler: a.server.handleListImports,
+			Method:  http.MethodPost,
+			Payload: listImportsPayload{},
+			Help:    "Lists the imports of a timeline, optionally filtered by data source, account, or label selectors.",
+		},
 		"item-classifications": {
 			Handler: a.server.handleItemClassifications,
 			Method:  http.MethodPost,
 			Payload: "",
 			Help:    "Returns the item classifications for the given timeline.",
 		},
+		"item-data-file": {
+			Handler: a.server.handleItemDataFile,
+			Method:  http.MethodPost,
+			Payload: itemDataFilePayload{},
+			Help:    "Returns information about an item's data file, including whether it is intact.",
+		},
+		"item-representations": {
+			Handler: a.server.handleItemRepresentations,
+			Method:  http.MethodPost,
+			Payload: itemRepresentationsPayload{},
+			Help:    "Returns all the representations of an item (such as the original and edited versions of a photo).",
+		},
+		"item-timeline": {
+			Handler: a.server.handleItemTimeline,
+			Method:  http.MethodPost,
+			Payload: itemTimelinePayload{},
+			Help:    "Returns the items involving an entity (owned by, sent to, depicting, etc.), ordered by time.",
+		},
 		"jobs": {
 			Handler: a.server.handleJobs,
 			Method:  http.MethodGet,
@@ -131,6 +173,12 @@ func (a *App) registerCommands() {
 			Payload: mergeEntitiesPayload{},
 			Help:    "Merge two entities together.",
 		},
+		"merge-items": {
+			Handler: a.server.handleMergeItems,
+			Method:  http.MethodPost,
+			Payload: mergeItemsPayload{},
+			Help:    "Resolves a cluster of duplicate items by keeping one and deleting the others.",
+		},
 		"open-repositories": {
 			Handler: a.server.handleRepos,
 			Method:  http.MethodGet,
@@ -142,6 +190,12 @@ func (a *App) registerCommands() {
 			Payload: openRepoPayload{},
 			Help:    "Open a timeline repository.",
 		},
+		"recent-activity": {
+			Handler: a.server.handleRecentActivity,
+			Method:  http.MethodPost,
+			Payload: recentActivityPayload{},
+			Help:    "Returns the most recent items across all data sources, with thumbnails and snippets.",
+		},
 		"recent-conversations": {
 			Handler: a.server.handleRecentConversations,
 			Method:  http.MethodPost,
@@ -172,11 +226,47 @@ func (a *App) registerCommands() {
 			Payload: timeline.ItemSearchParams{},
 			Help:    "Finds and filters items in a timeline.",
 		},
+		"set-favorite": {
+			Handler: a.server.handleSetFavorite,
+			Method:  http.MethodPost,
+			Payload: setFavoritePayload{},
+			Help:    "Marks an item as a favorite, or unmarks it.",
+		},
+		"set-time-zone": {
+			Handler: a.server.handleSetTimezone,
+			Method:  http.MethodPost,
+			Payload: setTimezonePayload{},
+			Help:    "Reinterprets the times of matching items that have no time zone as local times in the given time zone.",
+		},
+		"shift-timestamps": {
+			Handler: a.server.handleShiftTimestamps,
+			Method:  http.MethodPost,
+			Payload: shiftTimestampsPayload{},
+			Help:    "Moves the timestamps of matching items by a fixed amount of time.",
+		},
 		"stats": {
 			Handler: a.server.handleStats,
 			Method:  http.MethodGet,
 			Help:    "Returns statistics about the timeline.",
 		},
+		"thread": {
+			Handler: a.server.handleThread,
+			Method:  http.MethodPost,
+			Payload: threadPayload{},
+			Help:    "Returns the tree of replies to an item.",
+		},
+		"time-histogram": {
+			Handler: a.server.handleTimeHistogram,
+			Method:  http.MethodPost,
+			Payload: timeHistogramPayload{},
+			Help:    "Counts items matching a search in buckets of time (day, week, month, or year).",
+		},
+		"validate-data-source-options": {
+			Handler: a.server.handleValidateDataSourceOptions,
+			Method:  http.MethodPost,
+			Payload: validateDataSourceOptionsPayload{},
+			Help:    "Validates options for a data source before importing.",
+		},
 	}
 }
 
@@ -193,6 +193,21 @@ func (s server) serveDataFile(w http.ResponseWriter, r *http.Request, tl openedT
 		w.Header().Set("Content-Type", *results.Items[0].DataType)
 	}
 
+	// compressed data files have to be decompressed on the way out
+	compression, err := tl.DataFileCompression(r.Context(), dataFile)
+	if err != nil {
+		return err
+	}
+	if compression != "" {
+		rc, err := tl.OpenDataFile(r.Context(), dataFile)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(w, rc)
+		return err
+	}
+
 	tl.fileServer.ServeHTTP(w, r)
 	return nil
 }
@@ -521,12 +536,21 @@ func (s server) downloadItem(w http.ResponseWriter, r *http.Request, tl openedTi
 	if itemRow.DataText != nil {
 		content = bytes.NewReader([]byte(*itemRow.DataText))
 	} else if itemRow.DataFile != nil {
-		f, err := os.Open(tl.FullPath(*itemRow.DataFile))
+		f, err := tl.OpenDataFile(r.Context(), *itemRow.DataFile)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		content = f
+		if rs, ok := f.(io.ReadSeeker); ok {
+			content = rs
+		} else {
+			// decompressed data files can't seek, but they're only text
+			b, err := io.ReadAll(f)
+			if err != nil {
+				return err
+			}
+			content = bytes.NewReader(b)
+		}
 	} else if itemRow.Latitude != nil || itemRow.Longitude != nil || itemRow.Altitude != nil {
 		type geometry struct {
 			Type        string     `json:"type"`
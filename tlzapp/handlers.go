@@ -19,6 +19,7 @@
 package tlzapp
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -29,6 +30,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/timelinize/timelinize/timeline"
@@ -72,6 +74,96 @@ func (s *server) handleGetEntity(w http.ResponseWriter, r *http.Request) error {
 	return jsonResponse(w, entity, err)
 }
 
+type listImportsPayload struct {
+	RepoID string `json:"repo_id"`
+	timeline.ListImportsParams
+}
+
+func (s *server) handleListImports(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*listImportsPayload)
+	imports, err := s.app.ListImports(payload.RepoID, payload.ListImportsParams)
+	return jsonResponse(w, imports, err)
+}
+
+type importItemCountsPayload struct {
+	RepoID    string  `json:"repo_id"`
+	ImportIDs []int64 `json:"import_ids,omitempty"`
+}
+
+func (s *server) handleImportItemCounts(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*importItemCountsPayload)
+	counts, err := s.app.CountItemsByImport(payload.RepoID, payload.ImportIDs)
+	return jsonResponse(w, counts, err)
+}
+
+type importDebugBundlePayload struct {
+	RepoID   string `json:"repo_id"`
+	ImportID int64  `json:"import_id"`
+}
+
+func (s *server) handleImportDebugBundle(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*importDebugBundlePayload)
+	bundle, err := s.app.ImportDebugBundle(payload.RepoID, payload.ImportID)
+	return jsonResponse(w, bundle, err)
+}
+
+type itemDataFilePayload struct {
+	RepoID string `json:"repo_id"`
+	ItemID int64  `json:"item_id"`
+}
+
+func (s *server) handleItemDataFile(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*itemDataFilePayload)
+	info, err := s.app.ItemDataFileInfo(payload.RepoID, payload.ItemID)
+	return jsonResponse(w, info, err)
+}
+
+type itemRepresentationsPayload struct {
+	RepoID string `json:"repo_id"`
+	ItemID int64  `json:"item_id"`
+}
+
+func (s *server) handleItemRepresentations(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*itemRepresentationsPayload)
+	reps, err := s.app.ItemRepresentations(payload.RepoID, payload.ItemID)
+	return jsonResponse(w, reps, err)
+}
+
+type threadPayload struct {
+	RepoID     string `json:"repo_id"`
+	RootItemID int64  `json:"root_item_id"`
+}
+
+func (s *server) handleThread(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*threadPayload)
+	thread, err := s.app.Thread(payload.RepoID, payload.RootItemID)
+	return jsonResponse(w, thread, err)
+}
+
+type itemTimelinePayload struct {
+	RepoID   string `json:"repo_id"`
+	EntityID int64  `json:"entity_id"`
+	timeline.ItemTimelineParams
+}
+
+func (s *server) handleItemTimeline(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*itemTimelinePayload)
+	items, err := s.app.ItemTimeline(payload.RepoID, payload.EntityID, payload.ItemTimelineParams)
+	return jsonResponse(w, items, err)
+}
+
+type recentActivityPayload struct {
+	RepoID         string `json:"repo_id"`
+	Limit          int    `json:"limit,omitempty"`
+	HealThumbnails bool   `json:"heal_thumbnails,omitempty"`
+}
+
+func (s *server) handleRecentActivity(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*recentActivityPayload)
+	items, err := s.app.RecentActivity(payload.RepoID, payload.Limit, payload.HealThumbnails)
+	return jsonResponse(w, items, err)
+}
+
 type mergeEntitiesPayload struct {
 	RepoID         string  `json:"repo_id"`
 	BaseEntityID   int64   `json:"base_entity_id"`
@@ -84,6 +176,29 @@ func (s *server) handleMergeEntities(w http.ResponseWriter, r *http.Request) err
 	return jsonResponse(w, nil, err)
 }
 
+type findDuplicateItemsPayload struct {
+	RepoID  string                         `json:"repo_id"`
+	Options timeline.FindDuplicatesOptions `json:"options"`
+}
+
+func (s *server) handleFindDuplicateItems(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*findDuplicateItemsPayload)
+	clusters, err := s.app.FindDuplicateItems(payload.RepoID, payload.Options)
+	return jsonResponse(w, clusters, err)
+}
+
+type mergeItemsPayload struct {
+	RepoID  string                    `json:"repo_id"`
+	Cluster timeline.DuplicateCluster `json:"cluster"`
+	KeepID  int64                     `json:"keep_id"`
+}
+
+func (s *server) handleMergeItems(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*mergeItemsPayload)
+	err := s.app.MergeItems(payload.RepoID, payload.Cluster, payload.KeepID)
+	return jsonResponse(w, nil, err)
+}
+
 func (s *server) handleStats(w http.ResponseWriter, r *http.Request) error {
 	statName, repoID := r.FormValue("name"), r.FormValue("repo_id")
 	q := r.URL.Query()
@@ -353,6 +468,51 @@ func (s *server) handleSearchItems(w http.ResponseWriter, r *http.Request) error
 	return jsonResponse(w, results, err)
 }
 
+type timeHistogramPayload struct {
+	timeline.ItemSearchParams
+	Interval timeline.HistogramInterval `json:"interval"`
+}
+
+func (s *server) handleTimeHistogram(w http.ResponseWriter, r *http.Request) error {
+	payload := *r.Context().Value(ctxKeyPayload).(*timeHistogramPayload)
+	buckets, err := s.app.TimeHistogram(payload.ItemSearchParams, payload.Interval)
+	return jsonResponse(w, buckets, err)
+}
+
+type shiftTimestampsPayload struct {
+	timeline.ItemSearchParams
+	Delta time.Duration `json:"delta"`
+}
+
+func (s *server) handleShiftTimestamps(w http.ResponseWriter, r *http.Request) error {
+	payload := *r.Context().Value(ctxKeyPayload).(*shiftTimestampsPayload)
+	count, err := s.app.ShiftTimestamps(payload.ItemSearchParams, payload.Delta)
+	return jsonResponse(w, count, err)
+}
+
+type setFavoritePayload struct {
+	RepoID   string `json:"repo_id"`
+	ItemID   int64  `json:"item_id"`
+	Favorite bool   `json:"favorite"`
+}
+
+func (s *server) handleSetFavorite(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*setFavoritePayload)
+	err := s.app.SetFavorite(payload.RepoID, payload.ItemID, payload.Favorite)
+	return jsonResponse(w, nil, err)
+}
+
+type setTimezonePayload struct {
+	timeline.ItemSearchParams
+	TimeZone string `json:"time_zone"`
+}
+
+func (s *server) handleSetTimezone(w http.ResponseWriter, r *http.Request) error {
+	payload := *r.Context().Value(ctxKeyPayload).(*setTimezonePayload)
+	count, err := s.app.SetTimezone(payload.ItemSearchParams, payload.TimeZone)
+	return jsonResponse(w, count, err)
+}
+
 func (s *server) handleSearchEntities(w http.ResponseWriter, r *http.Request) error {
 	params := r.Context().Value(ctxKeyPayload).(*timeline.EntitySearchParams)
 	results, err := s.app.SearchEntities(*params)
@@ -371,6 +531,28 @@ func (s *server) handleConversation(w http.ResponseWriter, r *http.Request) erro
 	return jsonResponse(w, results, err)
 }
 
+type validateDataSourceOptionsPayload struct {
+	RepoID            string          `json:"repo_id"`
+	DataSourceName    string          `json:"data_source_name"`
+	DataSourceOptions json.RawMessage `json:"data_source_options,omitempty"`
+}
+
+func (s *server) handleValidateDataSourceOptions(w http.ResponseWriter, r *http.Request) error {
+	payload := r.Context().Value(ctxKeyPayload).(*validateDataSourceOptionsPayload)
+	err := s.app.ValidateDataSourceOptions(payload.RepoID, payload.DataSourceName, payload.DataSourceOptions)
+	var valErr timeline.OptionsValidationError
+	if errors.As(err, &valErr) {
+		return Error{
+			Err:        err,
+			HTTPStatus: http.StatusBadRequest,
+			Log:        "invalid data source options",
+			Message:    "Some options are invalid.",
+			Data:       valErr,
+		}
+	}
+	return jsonResponse(w, nil, err)
+}
+
 type deleteItemsPayload struct {
 	RepoID  string  `json:"repo_id"`
 	ItemIDs []int64 `json:"item_ids"`